@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	app "github.com/dugajean/goke/internal"
+	"github.com/dugajean/goke/internal/cli"
+	"github.com/dugajean/goke/pkg/goke"
+)
+
+// What a command gets: the options, the arguments after its name and the
+// project, which is nil when the config couldn't be loaded, see loadErr.
+type commandContext struct {
+	opts    app.Options
+	args    []string
+	project *goke.Project
+	loadErr error
+}
+
+// The commands of goke, ie. "goke clean-temp". A task with the same name
+// takes precedence, so that new commands never break existing configs.
+var commands = map[string]func(c commandContext) error{
+	"capabilities": capabilitiesCommand,
+	"clean-temp":   cleanTempCommand,
+	"doctor":       doctorCommand,
+	"config":       configCommand,
+	"fmt":          fmtCommand,
+	"hooks":        hooksCommand,
+	"hook":         hookCommand,
+	"prune-tasks":  pruneTasksCommand,
+	"validate":     validateCommand,
+}
+
+// The completion script completes the other commands.
+func init() {
+	commands["completion"] = completionCommand
+}
+
+// Returns the command given instead of tasks, if any.
+func lookupCommand(tasks []string, project *goke.Project) (func(commandContext) error, bool) {
+	if len(tasks) == 0 {
+		return nil, false
+	}
+
+	command, ok := commands[tasks[0]]
+	if !ok {
+		return nil, false
+	}
+
+	if project != nil {
+		if _, err := project.Task(tasks[0]); err == nil {
+			return nil, false
+		}
+	}
+
+	return command, true
+}
+
+// Fails when the flags of a command were given to the task which takes
+// precedence over it, which would silently ignore them.
+func checkCommandFlags(opts app.Options, tasks []string) error {
+	if len(opts.CommandFlags) == 0 {
+		return nil
+	}
+
+	return fmt.Errorf("%s only applies to goke %s, but the task '%s' takes precedence over it", strings.Join(opts.CommandFlags, ", "), tasks[0], tasks[0])
+}
+
+// Reports on the config: where each task comes from, or the variables
+// defined by more than one source with --env-conflicts, see app.EnvResolver.
+func configCommand(c commandContext) error {
+	if len(c.args) > 0 {
+		return errors.New("config does not accept arguments")
+	}
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	if c.opts.EnvConflicts {
+		c.project.WriteEnvConflicts(os.Stdout)
+	} else {
+		c.project.WriteTaskOrigins(os.Stdout)
+	}
+
+	return nil
+}
+
+// Formats goke.yml in goke's canonical style, see app.FormatConfig.
+func fmtCommand(c commandContext) error {
+	if len(c.args) > 0 {
+		return errors.New("fmt does not accept arguments")
+	}
+
+	configFile := app.CurrentConfigFile(c.opts.ConfigPath)
+	if configFile == "" {
+		return c.loadErr
+	}
+
+	return app.FormatConfigFile(configFile, c.opts, os.Stdout)
+}
+
+// Lists the problems of goke.yml without running anything, and fails when
+// there are any, see goke.Validate.
+func validateCommand(c commandContext) error {
+	if len(c.args) > 0 {
+		return errors.New("validate does not accept arguments")
+	}
+
+	configFile := app.CurrentConfigFile(c.opts.ConfigPath)
+	if configFile == "" {
+		return c.loadErr
+	}
+
+	problems, err := goke.Validate(configFile, goke.LoadOptions{Strict: c.opts.Strict, StateDir: c.opts.StateDir, NoGenerate: c.opts.NoGenerate})
+	if err != nil {
+		return err
+	}
+
+	for _, problem := range problems {
+		fmt.Println(problem)
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%s has %d problem(s)", configFile, len(problems))
+	}
+
+	if !c.opts.Quiet {
+		fmt.Println("No problems found")
+	}
+
+	return nil
+}
+
+// Reports on the state of goke on this machine, which is currently the
+// active watch sessions, see app.ActiveWatchSessions, the clock skew of the
+// filesystem of the config, see app.MeasureClockSkew, and the files shared
+// between the tasks of the config, see goke.Project.WriteOutputs. With
+// --tools, it only checks the binaries of the tasks, see doctorTools.
+func doctorCommand(c commandContext) error {
+	if len(c.args) > 0 {
+		return errors.New("doctor does not accept arguments")
+	}
+
+	if c.opts.Tools {
+		return doctorTools(c)
+	}
+
+	sessions, err := app.ActiveWatchSessions()
+	if err != nil {
+		return err
+	}
+
+	if len(sessions) == 0 {
+		fmt.Println("No active watch sessions")
+	} else {
+		fmt.Println("Active watch sessions:")
+		for _, s := range sessions {
+			fmt.Printf("  %s\n", s)
+		}
+	}
+
+	if configFile := app.CurrentConfigFile(c.opts.ConfigPath); configFile != "" {
+		dir, err := filepath.Abs(filepath.Dir(configFile))
+		if err != nil {
+			return err
+		}
+
+		skew, err := app.MeasureClockSkew(&app.LocalFileSystem{}, dir)
+		if err != nil {
+			fmt.Printf("Could not measure the clock skew of the filesystem of %s: %s\n", dir, err)
+		} else {
+			fmt.Printf("Clock skew of the filesystem of %s: %s\n", dir, app.DescribeClockSkew(skew))
+		}
+	}
+
+	var outputsErr *goke.OutputsError
+	if c.project != nil {
+		c.project.WriteOutputs(os.Stdout)
+	} else if errors.As(c.loadErr, &outputsErr) {
+		outputsErr.Analysis.Write(os.Stdout)
+		return outputsErr
+	}
+
+	return nil
+}
+
+// Reports the binaries used by the tasks which are missing, all at once,
+// and the commands which can't be checked, see goke.Project.CheckTools.
+func doctorTools(c commandContext) error {
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	skipped, err := c.project.CheckTools()
+	if !c.opts.Quiet {
+		for _, cmd := range skipped {
+			fmt.Printf("Skipped, resolved at runtime: %s\n", cmd)
+		}
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if !c.opts.Quiet {
+		fmt.Println("All binaries were found")
+	}
+
+	return nil
+}
+
+// Installs or uninstalls the git hooks of the config's hooks section, see
+// app.InstallGitHooks.
+func hooksCommand(c commandContext) error {
+	if len(c.args) != 1 || (c.args[0] != "install" && c.args[0] != "uninstall") {
+		return errors.New("hooks needs an action, ie. goke hooks install or goke hooks uninstall")
+	}
+
+	if c.args[0] == "uninstall" {
+		removed, err := app.UninstallGitHooks()
+		if err != nil {
+			return err
+		}
+
+		printHooks(c.opts, "Uninstalled", removed)
+		return nil
+	}
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	hooks := c.project.Hooks()
+	if len(hooks) == 0 {
+		return errors.New("the config has no hooks to install")
+	}
+
+	installed, removed, err := app.InstallGitHooks(sortedHooks(hooks))
+	if err != nil {
+		return err
+	}
+
+	printHooks(c.opts, "Installed", installed)
+	printHooks(c.opts, "Uninstalled", removed)
+	return nil
+}
+
+// Runs the tasks of a git hook, which is what the installed hook scripts do.
+// Hooks without tasks do nothing.
+func hookCommand(c commandContext) error {
+	if len(c.args) != 1 {
+		return errors.New("hook needs the name of a git hook, ie. goke hook pre-commit")
+	}
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	tasks := c.project.Hooks()[c.args[0]]
+	if len(tasks) == 0 {
+		return nil
+	}
+
+	// The executor already reported the error.
+	if err := c.project.RunTasks(context.Background(), tasks, runOptions(c.opts)); err != nil {
+		os.Exit(goke.ExitCode(err))
+	}
+
+	return nil
+}
+
+func sortedHooks(hooks map[string][]string) []string {
+	names := make([]string, 0, len(hooks))
+	for name := range hooks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func printHooks(opts app.Options, action string, hooks []string) {
+	if opts.Quiet {
+		return
+	}
+
+	for _, hook := range hooks {
+		fmt.Printf("%s the %s hook\n", action, hook)
+	}
+}
+
+// Reports the tasks nothing runs anymore, see app.Parser.PruneCandidates,
+// as JSON with --json. With --delete, asks which of them to remove from the
+// config.
+func pruneTasksCommand(c commandContext) error {
+	if len(c.args) > 0 {
+		return errors.New("prune-tasks does not accept arguments")
+	}
+
+	if c.loadErr != nil {
+		return c.loadErr
+	}
+
+	if c.opts.JSON && c.opts.Delete {
+		return errors.New("--json cannot be combined with --delete")
+	}
+
+	candidates := c.project.PruneCandidates(c.opts.UnusedFor)
+	if c.opts.JSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(candidates)
+	}
+
+	c.project.WritePruneCandidates(os.Stdout, candidates)
+	if !c.opts.Delete || len(candidates) == 0 {
+		return nil
+	}
+
+	selected := askPrune(candidates)
+	if len(selected) == 0 {
+		return nil
+	}
+
+	if err := c.project.DeleteTasks(selected); err != nil {
+		return err
+	}
+
+	fmt.Printf("Deleted %s\n", strings.Join(selected, ", "))
+	return nil
+}
+
+// Asks whether to delete each candidate. Anything but y keeps it, as does
+// the end of the input.
+func askPrune(candidates []goke.PruneCandidate) []string {
+	selected := []string{}
+	scanner := bufio.NewScanner(os.Stdin)
+
+	for _, c := range candidates {
+		fmt.Printf("Delete %s (%s)? [y/N] ", c.Name, c.Location)
+		if !scanner.Scan() {
+			fmt.Println()
+			break
+		}
+
+		if strings.ToLower(strings.TrimSpace(scanner.Text())) == "y" {
+			selected = append(selected, c.Name)
+		}
+	}
+
+	return selected
+}
+
+// Reports the version and the features of this build, as JSON with --json,
+// so that tooling can check for a feature before relying on it, see
+// goke.Capabilities.
+func capabilitiesCommand(c commandContext) error {
+	if len(c.args) > 0 {
+		return errors.New("capabilities does not accept arguments")
+	}
+
+	return app.WriteCapabilities(os.Stdout, c.opts.JSON)
+}
+
+// Removes goke's old temp files right away, see app.CleanTemp.
+func cleanTempCommand(c commandContext) error {
+	if len(c.args) > 0 {
+		return errors.New("clean-temp does not accept arguments")
+	}
+
+	cleanup, err := app.CleanTempNow(c.opts)
+	if err != nil {
+		return err
+	}
+
+	if !c.opts.Quiet {
+		fmt.Println(cleanup)
+	}
+
+	return nil
+}
+
+// Writes the completion script of the shell, ie. for
+// source <(goke completion zsh), see app.WriteCompletionScript. The tasks
+// are listed by goke completion --porcelain, see listTasksPorcelain.
+func completionCommand(c commandContext) error {
+	if len(c.args) != 1 {
+		return fmt.Errorf("completion needs a shell, ie. goke completion %s", strings.Join(app.CompletionShells, "|"))
+	}
+
+	opts := app.Options{}
+	flags := flag.NewFlagSet("goke", flag.ContinueOnError)
+	cli.RegisterFlags(flags, &opts)
+	cli.RegisterAllCommandFlags(flags, &opts)
+
+	names := []string{}
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return app.WriteCompletionScript(os.Stdout, c.args[0], flags, names)
+}
+
+// Prints the tasks for the completion scripts with goke completion
+// --porcelain, one per line, with their description after a tab. The tasks
+// come straight from the parsed, usually cached, config: the state of the
+// project isn't loaded and the executor doesn't run, so there is neither a
+// spinner nor a warning.
+func listTasksPorcelain(opts app.Options, args []string) error {
+	if len(args) > 0 {
+		return errors.New("completion --porcelain does not accept arguments")
+	}
+
+	configFile := app.CurrentConfigFile(opts.ConfigPath)
+	if configFile == "" {
+		return errors.New("no presence of goke.yml sighted")
+	}
+
+	tasks, err := goke.ListTasks(configFile, goke.LoadOptions{NoCache: opts.ClearCache, Quiet: true, StateDir: opts.StateDir, NoGenerate: opts.NoGenerate})
+	if err != nil {
+		return err
+	}
+
+	for _, task := range tasks {
+		if desc := strings.TrimSpace(strings.SplitN(task.Desc, "\n", 2)[0]); desc != "" {
+			fmt.Printf("%s\t%s\n", task.Name, desc)
+		} else {
+			fmt.Println(task.Name)
+		}
+	}
+
+	return nil
+}
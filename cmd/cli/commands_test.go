@@ -0,0 +1,27 @@
+package main
+
+import (
+	"flag"
+	"testing"
+
+	app "github.com/dugajean/goke/internal"
+	"github.com/dugajean/goke/internal/cli"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEveryFlagIsACapability(t *testing.T) {
+	var opts app.Options
+	fs := flag.NewFlagSet("goke", flag.ContinueOnError)
+	cli.RegisterFlags(fs, &opts)
+	cli.RegisterAllCommandFlags(fs, &opts)
+
+	features := app.GetCapabilities().Features
+
+	fs.VisitAll(func(f *flag.Flag) {
+		require.Contains(t, features, "flag."+f.Name)
+	})
+
+	for name := range commands {
+		require.Contains(t, features, "command."+name)
+	}
+}
@@ -1,32 +1,128 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"os"
 
 	app "github.com/dugajean/goke/internal"
 	"github.com/dugajean/goke/internal/cli"
+	"github.com/dugajean/goke/pkg/goke"
 )
 
 func main() {
-	argIndex := app.PermutateArgs(os.Args)
-	opts := cli.GetOptions()
+	defer app.RecoverPanic()
+
+	opts, tasks := cli.GetOptions()
 
 	handleGlobalFlags(&opts)
 
-	cfg, err := app.ReadYamlConfig()
-	if err != nil {
-		fmt.Println(err.Error())
-		os.Exit(1)
+	// Like git, goke works from any subdirectory of the project.
+	if opts.ConfigPath == "" {
+		if err := app.ChdirToConfigDir(&opts); err != nil {
+			exitWithError(opts, err)
+		}
+	}
+
+	if err := app.ResolveStateDir(&opts); err != nil {
+		exitWithError(opts, err)
+	}
+
+	// The shell completion lists the tasks on every tab, so nothing but
+	// the config is loaded. --porcelain is only accepted after "goke
+	// completion", see cli.RegisterCommandFlags.
+	if opts.Porcelain {
+		if err := listTasksPorcelain(opts, tasks[1:]); err != nil {
+			exitWithError(opts, err)
+		}
+
+		return
+	}
+
+	app.AutoCleanTemp(opts)
+
+	// Commands can run without a config, and even when it's invalid.
+	var project *goke.Project
+	var loadErr error
+
+	// goke validate lists the warnings of the config among its problems,
+	// rather than also before them.
+	validating := len(tasks) > 0 && tasks[0] == "validate"
+
+	if configFile := app.CurrentConfigFile(opts.ConfigPath); configFile != "" {
+		project, loadErr = goke.Load(configFile, goke.LoadOptions{NoCache: opts.ClearCache, Quiet: opts.Quiet || validating, Strict: opts.Strict, StateDir: opts.StateDir, NoGenerate: opts.NoGenerate})
+	} else {
+		loadErr = errors.New("no presence of goke.yml sighted")
+	}
+
+	if command, ok := lookupCommand(tasks, project); ok {
+		if err := command(commandContext{opts: opts, args: tasks[1:], project: project, loadErr: loadErr}); err != nil {
+			exitWithError(opts, err)
+		}
+
+		return
+	}
+
+	if loadErr != nil {
+		exitWithError(opts, loadErr)
 	}
 
-	fs := app.LocalFileSystem{}
-	p := app.NewParser(cfg, &opts, &fs)
-	p.Bootstrap()
+	// A task named validate took precedence over the command.
+	if validating && !opts.Quiet {
+		for _, warning := range project.Warnings() {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+	}
 
-	l := app.NewLockfile(p.FilePaths, &opts, &fs)
-	l.Bootstrap()
+	if err := checkCommandFlags(opts, tasks); err != nil {
+		exitWithError(opts, err)
+	}
+
+	// The executor already reported the error.
+	if err := project.RunTasks(context.Background(), tasks, runOptions(opts)); err != nil {
+		os.Exit(goke.ExitCode(err))
+	}
+}
+
+// Converts the flags into the options of the run.
+func runOptions(opts app.Options) goke.RunOptions {
+	return goke.RunOptions{
+		Force:              opts.Force,
+		Quiet:              opts.Quiet,
+		Verbose:            opts.Verbose,
+		VeryVerbose:        opts.VeryVerbose,
+		Watch:              opts.Watch,
+		Debounce:           opts.Debounce,
+		ServeStatus:        opts.ServeStatus,
+		AllowRemoteTrigger: opts.AllowRemoteTrigger,
+		AllowMultipleWatch: opts.AllowMultipleWatch,
+		Hup:                opts.Hup,
+		List:               opts.List,
+		Interactive:        !opts.NoInteractive,
+		Deadline:           opts.Deadline,
+		Jobs:               opts.Jobs,
+		Since:              opts.Since,
+		Batch:              opts.Batch,
+		SummaryLine:        opts.SummaryLine,
+		Bare:               opts.Bare,
+		DiscardQuiet:       opts.DiscardQuiet,
+		Preflight:          opts.Preflight,
+		Tag:                opts.Tag,
+		CaptureDir:         opts.CaptureDir,
+		DryRun:             opts.DryRun,
+		UpdateGolden:       opts.UpdateGolden,
+		ExtraArgs:          opts.ExtraArgs,
+		Params:             opts.Params,
+		Args:               os.Args[1:],
+	}
+}
+
+// Prints the error, unless running quietly, and exits with its exit code.
+func exitWithError(opts app.Options, err error) {
+	if !opts.Quiet {
+		fmt.Fprintln(os.Stderr, err)
+	}
 
-	e := app.NewExecutor(&p, &l, &opts)
-	e.Start(parseTaskName(argIndex))
+	os.Exit(goke.ExitCode(err))
 }
@@ -7,16 +7,6 @@ import (
 	app "github.com/dugajean/goke/internal"
 )
 
-func parseTaskName(argIndex int) string {
-	arg := ""
-
-	if len(os.Args) > argIndex {
-		arg = os.Args[argIndex]
-	}
-
-	return arg
-}
-
 func handleGlobalFlags(opts *app.Options) {
 	// Handle global flags here
 	err := opts.InitHandler()
@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("run.args_placeholder")
+}
+
+// The placeholder in "run" entries which gets replaced by the arguments
+// given after "--", ie. "goke test -- -run TestFoo".
+const ArgsPlaceholder = "{ARGS}"
+
+// Arguments made of these characters need no quoting, neither for goke nor
+// for the shell.
+var plainArgRegexp = regexp.MustCompile(`^[A-Za-z0-9_@%+=:,./-]+$`)
+
+// Replaces the {ARGS} placeholder in the command with the arguments, quoted
+// so that each one stays a single word, with or without shell: true.
+func replaceArgs(cmd string, args []string) string {
+	if !strings.Contains(cmd, ArgsPlaceholder) {
+		return cmd
+	}
+
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = quoteArg(arg)
+	}
+
+	return strings.Replace(cmd, ArgsPlaceholder, strings.Join(quoted, " "), -1)
+}
+
+func quoteArg(arg string) string {
+	if plainArgRegexp.MatchString(arg) {
+		return arg
+	}
+
+	return "'" + strings.ReplaceAll(arg, "'", `'\''`) + "'"
+}
+
+// Fails when arguments were given after "--" but one of the tasks has no
+// {ARGS} placeholder to receive them, so that they are never dropped silently.
+// The update flags of golden entries aren't meant for {ARGS}.
+func (e *Executor) checkExtraArgs(taskNames []string) error {
+	if len(e.commandArgs()) == 0 {
+		return nil
+	}
+
+	if e.options.Batch != "" {
+		return errors.New("--batch does not accept arguments after --")
+	}
+
+	for _, name := range taskNames {
+		task, ok := e.parser.Tasks[name]
+		if ok && !e.parser.usesArgs(task, make(map[string]bool)) {
+			return fmt.Errorf("task '%s' was given arguments, but none of its commands use %s", name, ArgsPlaceholder)
+		}
+	}
+
+	return nil
+}
+
+// Whether the commands of the task, or of the tasks it runs, use {ARGS}.
+func (p *Parser) usesArgs(task Task, visited map[string]bool) bool {
+	visited[task.Name] = true
+
+	for _, entry := range task.Run {
+		if strings.Contains(entry.Cmd, ArgsPlaceholder) {
+			return true
+		}
+
+		if ref, ok := p.Tasks[entry.Cmd]; ok && !visited[ref.Name] && p.usesArgs(ref, visited) {
+			return true
+		}
+	}
+
+	return false
+}
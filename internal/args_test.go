@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplaceArgs(t *testing.T) {
+	args := []string{"-run", "TestFoo|TestBar", "-v", "two words", "it's", "$HOME"}
+	cmd := replaceArgs("go test ./... {ARGS}", args)
+
+	require.Equal(t, `go test ./... -run 'TestFoo|TestBar' -v 'two words' 'it'\''s' '$HOME'`, cmd)
+
+	words, err := splitCommand(cmd)
+	require.Nil(t, err)
+	require.Equal(t, append([]string{"go", "test", "./..."}, args...), words)
+
+	// The shell gets the same words.
+	out, err := exec.Command("sh", "-c", replaceArgs("printf '%s\\n' {ARGS}", args)).Output()
+	require.Nil(t, err)
+	require.Equal(t, "-run\nTestFoo|TestBar\n-v\ntwo words\nit's\n$HOME\n", string(out))
+
+	require.Equal(t, "go test ", replaceArgs("go test {ARGS}", nil))
+}
+
+func TestArgsAreForwardedToTheCommands(t *testing.T) {
+	env := NewInMemoryEnv(`
+test:
+  env:
+    PKG: ./...
+  run:
+    - "go test $PKG {ARGS}"
+    - "echo done"
+`)
+	env.Options.ExtraArgs = []string{"-run", "Test Foo"}
+
+	require.Nil(t, env.Run("test"))
+
+	commands := env.Runner.Commands()
+	require.Len(t, commands, 2)
+	require.Equal(t, []string{"go", "test", "./...", "-run", "Test Foo"}, commands[0].Args)
+	require.Equal(t, []string{"echo", "done"}, commands[1].Args)
+}
+
+func TestArgsRequireThePlaceholder(t *testing.T) {
+	e := newTestExecutor(t, `
+test:
+  run:
+    - "go test {ARGS}"
+
+ci:
+  run:
+    - test
+
+build:
+  run:
+    - "go build"
+`)
+	e.options.ExtraArgs = []string{"-v"}
+
+	require.Nil(t, e.checkExtraArgs([]string{"test", "ci"}))
+	require.EqualError(t, e.checkExtraArgs([]string{"test", "build"}), "task 'build' was given arguments, but none of its commands use {ARGS}")
+	require.EqualError(t, e.start([]string{"build"}), "task 'build' was given arguments, but none of its commands use {ARGS}")
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+)
+
+func init() {
+	RegisterCapability("run.bare")
+}
+
+// What --bare leaves out of the run, printed when it starts.
+const bareBanner = "Bare run: skipping the events, on_success and on_failure commands, and the variables of global.environment and of the local overrides"
+
+// With --bare, says what the run leaves out, so that its results aren't
+// mistaken for the ones of a normal run.
+func (e *Executor) printBareBanner() {
+	if e.options.Bare && !e.options.Quiet {
+		fmt.Fprintln(os.Stderr, bareBanner)
+	}
+}
+
+// The events which run along with the task, none with --bare.
+func (e *Executor) taskEvents(task Task) Events {
+	if e.options.Bare {
+		return Events{}
+	}
+
+	return e.parser.taskEvents(task)
+}
+
+// The variables of a command in the format expected by exec.Cmd: the given
+// ones on top of goke's environment, or with --bare, on top of the one goke
+// was started with, without global.environment.
+func (e *Executor) commandEnv(env map[string]string) []string {
+	if !e.options.Bare {
+		return commandEnv(env)
+	}
+
+	startup := startupEnv()
+	vars := make([]string, 0, len(startup)+len(env))
+	for _, k := range sortedKeys(startup) {
+		vars = append(vars, k+"="+startup[k])
+	}
+
+	for _, k := range sortedKeys(env) {
+		vars = append(vars, k+"="+env[k])
+	}
+
+	return vars
+}
+
+// Expands the variables of str like expandEnv, falling back to the
+// environment goke was started with under --bare.
+func (e *Executor) expandEnv(str string, env map[string]string) (string, error) {
+	if !e.options.Bare {
+		return expandEnv(str, env)
+	}
+
+	return shellExpand(str, func(key string) (string, bool) {
+		if v, ok := env[key]; ok {
+			return v, true
+		}
+		return lookupStartupEnv(key)
+	})
+}
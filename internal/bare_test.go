@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const bareConfig = `
+global:
+  environment:
+    GOKE_BARE_GLOBAL: global
+  events:
+    before_each_task:
+      - "echo before"
+
+test:
+  env:
+    GOKE_BARE_TASK: task
+  on_success:
+    - "echo passed"
+  run:
+    - "go test -tags=$GOKE_BARE_GLOBAL ./..."
+`
+
+func TestBareRunsOnlyTheCommandsOfTheTask(t *testing.T) {
+	normal := NewInMemoryEnv(bareConfig)
+	require.Nil(t, normal.Run("test"))
+	require.Equal(t, []string{"echo before", "go test -tags=global ./...", "echo passed"}, recordedCommands(normal))
+
+	bare := NewInMemoryEnv(bareConfig)
+	bare.Options.Bare = true
+	require.Nil(t, bare.Run("test"))
+	require.Equal(t, []string{"go test -tags= ./..."}, recordedCommands(bare))
+
+	// Both get the variables of the task, only the normal run gets the ones
+	// of global.environment.
+	normalEnv := normal.Runner.Commands()[1].Env
+	require.Contains(t, normalEnv, "GOKE_BARE_TASK=task")
+	require.Contains(t, normalEnv, "GOKE_BARE_GLOBAL=global")
+
+	bareEnv := bare.Runner.Commands()[0].Env
+	require.Contains(t, bareEnv, "GOKE_BARE_TASK=task")
+	require.NotContains(t, bareEnv, "GOKE_BARE_GLOBAL=global")
+}
+
+func TestBareRunsAreMarkedInTheCaptureIndex(t *testing.T) {
+	env := NewInMemoryEnv(bareConfig)
+	dir := filepath.Join(t.TempDir(), "artifacts")
+	env.Options.CaptureDir = dir
+	env.Options.Bare = true
+
+	e := newCaptureExecutor(t, env)
+	require.Nil(t, e.Start([]string{"test"}))
+
+	var index struct {
+		Bare     bool              `json:"bare"`
+		Commands []capturedCommand `json:"commands"`
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(data, &index))
+	require.True(t, index.Bare)
+	require.Len(t, index.Commands, 1)
+}
+
+func TestBareRejectsWatch(t *testing.T) {
+	e := newTestExecutor(t, bareConfig)
+	e.options.Bare = true
+	e.options.Watch = true
+
+	require.EqualError(t, e.start([]string{"test"}), "--bare cannot be combined with --watch")
+}
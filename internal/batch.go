@@ -0,0 +1,124 @@
+package internal
+
+import (
+	"fmt"
+	"runtime"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("run.files_placeholder", "run.files_batching", "run.files_sorted_placeholder")
+}
+
+// The placeholder in "run" entries which gets replaced by the task's files,
+// in the order of their patterns, the matches of each pattern sorted.
+const FilesPlaceholder = "{FILES}"
+
+// Like {FILES}, but with all the files sorted and without duplicates,
+// regardless of the order of the patterns.
+const FilesSortedPlaceholder = "{FILES_SORTED}"
+
+// Conservative upper bound for the length of a composed command line.
+// Kept well below the OS limits, since the environment shares the same space.
+var maxCommandLength = defaultMaxCommandLength()
+
+func defaultMaxCommandLength() int {
+	if runtime.GOOS == "windows" {
+		return 30000
+	}
+
+	return 128 * 1024
+}
+
+// Replaces the {FILES} placeholder in cmd with the given files, quoted like
+// the arguments of {ARGS}. If the result would exceed the command length
+// limit, the files are split in batches and one command is returned for each
+// batch, similar to what xargs does. Commands with {FILES_SORTED} get the
+// sorted files, in all of their placeholders.
+func batchCommand(cmd string, files []string, limit int) ([]string, error) {
+	if strings.Contains(cmd, FilesSortedPlaceholder) {
+		cmd = strings.Replace(cmd, FilesSortedPlaceholder, FilesPlaceholder, -1)
+		files = sortedFiles(files)
+	}
+
+	if !strings.Contains(cmd, FilesPlaceholder) {
+		if expanded, _ := expandProcessEnv(cmd); len(expanded) > limit {
+			return nil, fmt.Errorf(
+				"command exceeds the argument length limit of %d bytes and cannot be split; "+
+					"pass the files through %s instead: %.80s...",
+				limit, ChangedFilesFilePlaceholder, cmd,
+			)
+		}
+
+		return []string{cmd}, nil
+	}
+
+	occurrences := strings.Count(cmd, FilesPlaceholder)
+	expanded, _ := expandProcessEnv(strings.Replace(cmd, FilesPlaceholder, "", -1))
+	overhead := len(expanded)
+	budget := (limit - overhead) / occurrences
+
+	if budget <= 0 {
+		return nil, fmt.Errorf("command exceeds the argument length limit of %d bytes even without files: %.80s...", limit, cmd)
+	}
+
+	commands := []string{}
+	batch := []string{}
+	batchLen := 0
+
+	for _, f := range files {
+		f = quoteArg(f)
+		if len(batch) > 0 && batchLen+1+len(f) > budget {
+			commands = append(commands, strings.Replace(cmd, FilesPlaceholder, strings.Join(batch, " "), -1))
+			batch = []string{}
+			batchLen = 0
+		}
+
+		if len(batch) > 0 {
+			batchLen++
+		}
+
+		batch = append(batch, f)
+		batchLen += len(f)
+	}
+
+	commands = append(commands, strings.Replace(cmd, FilesPlaceholder, strings.Join(batch, " "), -1))
+
+	return commands, nil
+}
+
+// The files sorted lexicographically, without duplicates.
+func sortedFiles(files []string) []string {
+	sorted := append([]string{}, files...)
+	sort.Strings(sorted)
+
+	unique := []string{}
+	for i, f := range sorted {
+		if i == 0 || f != sorted[i-1] {
+			unique = append(unique, f)
+		}
+	}
+
+	return unique
+}
+
+// Picks the most severe error out of a list of batch errors,
+// which is the one that carries the highest exit code.
+func worstError(errs []error) error {
+	var worst error
+	worstCode := -1
+
+	for _, err := range errs {
+		if err == nil {
+			continue
+		}
+
+		if code := ExitCode(err); code > worstCode {
+			worst = err
+			worstCode = code
+		}
+	}
+
+	return worst
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchCommandWithoutPlaceholder(t *testing.T) {
+	got, err := batchCommand("echo 'hello'", []string{"foo"}, 100)
+
+	require.Nil(t, err)
+	require.Equal(t, []string{"echo 'hello'"}, got)
+}
+
+func TestBatchCommandWithinLimit(t *testing.T) {
+	got, err := batchCommand("gofmt -l {FILES}", []string{"foo.go", "bar.go"}, 100)
+
+	require.Nil(t, err)
+	require.Equal(t, []string{"gofmt -l foo.go bar.go"}, got)
+}
+
+func TestBatchCommandSplitsFiles(t *testing.T) {
+	files := []string{"aaaa.go", "bbbb.go", "cccc.go", "dddd.go", "eeee.go"}
+	got, err := batchCommand("gofmt -l {FILES}", files, 30)
+
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"gofmt -l aaaa.go bbbb.go",
+		"gofmt -l cccc.go dddd.go",
+		"gofmt -l eeee.go",
+	}, got)
+
+	for _, c := range got {
+		require.LessOrEqual(t, len(c), 30)
+	}
+}
+
+func TestBatchCommandTooLongWithoutPlaceholder(t *testing.T) {
+	_, err := batchCommand("echo aaaa.go bbbb.go cccc.go dddd.go", nil, 20)
+
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), ChangedFilesFilePlaceholder)
+}
+
+func TestBatchCommandQuotesFiles(t *testing.T) {
+	files := []string{"my file.go", "bar.go", "it's.go"}
+	got, err := batchCommand("gofmt -l {FILES}", files, 30)
+
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"gofmt -l 'my file.go' bar.go",
+		`gofmt -l 'it'\''s.go'`,
+	}, got)
+}
+
+func TestWorstError(t *testing.T) {
+	exit1 := exec.Command("sh", "-c", "exit 1").Run()
+	exit3 := exec.Command("sh", "-c", "exit 3").Run()
+
+	require.Nil(t, worstError([]error{nil, nil}))
+	require.Equal(t, exit3, worstError([]error{exit1, nil, exit3}))
+	require.Equal(t, exit3, worstError([]error{exit3, errors.New("foo")}))
+}
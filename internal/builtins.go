@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Prefix of the commands which are implemented by goke itself, so that they
+// behave the same on every platform, ie. "goke:checksum".
+const builtinPrefix = "goke:"
+
+// A command implemented by goke. It runs in dir and writes its output to stdout.
+type builtin func(args []string, dir string, stdout io.Writer) error
+
+var builtins = map[string]builtin{
+	"goke:checksum": checksumBuiltin,
+}
+
+// Returns the built-in command with the given name, if there is one.
+// Unknown names with the builtin prefix result in an error.
+func lookupBuiltin(name string) (builtin, bool, error) {
+	if !strings.HasPrefix(name, builtinPrefix) {
+		return nil, false, nil
+	}
+
+	b, ok := builtins[name]
+	if !ok {
+		return nil, false, fmt.Errorf("unknown built-in command %s", name)
+	}
+
+	return b, true, nil
+}
+
+// Splits off a "> file" redirection at the end of the arguments of a
+// built-in, since built-ins don't run through a shell.
+func splitRedirect(args []string) ([]string, string, error) {
+	for i, arg := range args {
+		if !strings.HasPrefix(arg, ">") {
+			continue
+		}
+
+		target := strings.TrimPrefix(arg, ">")
+		rest := args[i+1:]
+
+		if target == "" && len(rest) > 0 {
+			target, rest = rest[0], rest[1:]
+		}
+
+		if target == "" || len(rest) > 0 {
+			return nil, "", fmt.Errorf("a redirection must be followed by exactly one file")
+		}
+
+		return args[:i], target, nil
+	}
+
+	return args, "", nil
+}
@@ -0,0 +1,212 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"regexp"
+	"runtime"
+)
+
+// The parsed configs as cached in the temp directory, see NewParser. The
+// same config parses differently depending on goke's environment, so the
+// cache keeps the latest few of them, most recent first.
+type parserCache struct {
+	// The cacheVersion of the goke which wrote the cache.
+	Schema string
+
+	Entries []parserCacheEntry
+}
+
+// A parsed config in the cache, which is only used when the config and
+// the variables read while parsing it are the same.
+type parserCacheEntry struct {
+	// The cacheKey of the config the parser was parsed from.
+	Key string
+
+	// The variables of goke's environment read while parsing, and the
+	// envDigest of their values.
+	Env       []string
+	EnvDigest string
+
+	// The parser, serialized on its own so that only the entry in use gets
+	// decoded, see readCache.
+	Parser string
+}
+
+// How many parsed configs the cache keeps, ie. for CI matrices switching
+// between variables.
+const cacheEntriesMax = 8
+
+// Matches the variables a config refers to, ie. $HOME or ${HOME:-/root}.
+var envReferenceRegexp = regexp.MustCompile(`\$\{?([A-Za-z_][A-Za-z0-9_]*)`)
+
+// Identifies everything the parsed config depends on: the version of goke,
+// the contents of the config, of its included files and of its local
+// overrides, the output of its generator, --strict, and the values of the
+// variables of goke's environment it refers to. A cache with another key is
+// stale, regardless of the mtimes of the files. Variables read while parsing
+// without appearing in the config, ie. the ones of generated tasks, are
+// compared separately, see parserCacheEntry.
+func (p *Parser) cacheKey() string {
+	_, local, _ := ReadLocalYamlConfig()
+
+	h := sha256.New()
+	configs := p.config + "\n" + local
+	for _, s := range []string{Version, runtime.GOOS, p.config, local, p.generated, fmt.Sprint(p.options.Strict)} {
+		_, _ = io.WriteString(h, s)
+		_, _ = h.Write([]byte{0})
+	}
+
+	for _, inc := range p.included {
+		_, _ = fmt.Fprintf(h, "%s\x00%s\x00", inc.path, inc.config)
+		configs += "\n" + inc.config
+	}
+
+	names := make(map[string]bool)
+	for _, m := range envReferenceRegexp.FindAllStringSubmatch(configs, -1) {
+		names[m[1]] = true
+	}
+
+	for _, name := range sortedKeys(names) {
+		value, ok := lookupProcessEnv(name)
+		_, _ = fmt.Fprintf(h, "%s %t %s\x00", name, ok, value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Hashes the values the variables had when goke started, so that the ones
+// goke set itself while parsing, ie. from global.environment, don't count.
+func envDigest(names []string) string {
+	h := sha256.New()
+	for _, name := range names {
+		value, ok := lookupStartupEnv(name)
+		_, _ = fmt.Fprintf(h, "%s %t %s\x00", name, ok, value)
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// The path of the cache file of the config, in the temp directory unless
+// the state lives in the project's .goke directory, see ResolveStateDir.
+func (p *Parser) cacheFile() string {
+	if p.options.StateDir != "" {
+		return path.Join(p.options.StateDir, p.localCacheName())
+	}
+
+	return path.Join(p.fs.TempDir(), p.getTempFileName())
+}
+
+// Loads the parser from the cache, unless it's missing, stale, corrupt or was
+// written by a goke with another cacheVersion, which all mean parsing again.
+// Only an entry with the same key, whose variables still have the values
+// they had when it was written, is used.
+func (p *Parser) readCache() (Parser, bool) {
+	tempFile := p.cacheFile()
+
+	var data []byte
+	err := withStateLock(p.fs, p.options, func() error {
+		if p.options.StateDir != "" {
+			p.migrateCache(tempFile)
+		}
+
+		if !p.fs.FileExists(tempFile) {
+			return os.ErrNotExist
+		}
+
+		var err error
+		data, err = p.fs.ReadFile(tempFile)
+		return err
+	})
+	if err != nil {
+		return Parser{}, false
+	}
+
+	cache, err := GOBDeserialize(string(data), &parserCache{})
+	if err != nil {
+		p.discardCache(tempFile, err)
+		return Parser{}, false
+	}
+
+	if cache.Schema != cacheVersion {
+		return Parser{}, false
+	}
+
+	for _, entry := range cache.Entries {
+		if entry.Key != p.key || entry.EnvDigest != envDigest(entry.Env) {
+			continue
+		}
+
+		// Decoding into the parser keeps its fields which aren't cached.
+		shell := *p
+		parser, err := GOBDeserialize(entry.Parser, &shell)
+		if err != nil {
+			p.discardCache(tempFile, err)
+			return Parser{}, false
+		}
+
+		return parser, true
+	}
+
+	return Parser{}, false
+}
+
+// Removes a cache which can't be decoded, ie. one truncated on a full disk,
+// so that it gets written again.
+func (p *Parser) discardCache(tempFile string, err error) {
+	if !p.options.Quiet {
+		fmt.Fprintf(os.Stderr, "Warning: discarding the corrupt cache %s: %s\n", tempFile, err)
+	}
+
+	_ = p.fs.Remove(tempFile)
+}
+
+// Writes the parsed config to the cache, ahead of the other entries, and
+// drops the oldest ones beyond cacheEntriesMax.
+func (p *Parser) writeCache() error {
+	parser, err := GOBSerialize(*p)
+	if err != nil {
+		return err
+	}
+
+	entry := parserCacheEntry{Key: p.key, Env: p.envReads, EnvDigest: envDigest(p.envReads), Parser: parser}
+
+	return withStateLock(p.fs, p.options, func() error {
+		entries := []parserCacheEntry{entry}
+		for _, other := range p.cachedEntries() {
+			if len(entries) == cacheEntriesMax {
+				break
+			}
+
+			if other.Key != entry.Key || other.EnvDigest != envDigest(other.Env) {
+				entries = append(entries, other)
+			}
+		}
+
+		data, err := GOBSerialize(parserCache{Schema: cacheVersion, Entries: entries})
+		if err != nil {
+			return err
+		}
+
+		return p.fs.WriteFile(p.cacheFile(), []byte(data), 0644)
+	})
+}
+
+// The entries of the cache file written by this version of goke, if any.
+func (p *Parser) cachedEntries() []parserCacheEntry {
+	data, err := p.fs.ReadFile(p.cacheFile())
+	if err != nil {
+		return nil
+	}
+
+	cache, err := GOBDeserialize(string(data), &parserCache{})
+	if err != nil || cache.Schema != cacheVersion {
+		return nil
+	}
+
+	return cache.Entries
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+)
+
+func init() {
+	RegisterCapability("command.capabilities")
+}
+
+// Version of goke, set at build time through -ldflags.
+var Version = "dev"
+
+const (
+	// Version of the JSON event stream. Zero means there is no event stream.
+	EventSchemaVersion = 0
+
+	// Version of the exit code contract: 0 on success, the exit code of the
+	// failed command (128+signal when it was killed by a signal or goke was
+	// interrupted), ExitCodeTimeout when it timed out,
+	// ExitCodeDeadlineExceeded when --deadline cut the run off, 1 when goke
+	// fails otherwise and ExitCodeInternalError when goke itself crashes.
+	ExitCodeContractVersion = 6
+)
+
+// Capabilities is a machine-readable report of what this goke build supports,
+// so that tooling can check for a feature before relying on it.
+type Capabilities struct {
+	Version                 string   `json:"version"`
+	EventSchemaVersion      int      `json:"event_schema_version"`
+	ExitCodeContractVersion int      `json:"exit_code_contract_version"`
+	Features                []string `json:"features"`
+}
+
+var features = map[string]bool{}
+
+// Registers feature identifiers, ie. "run.export". Features register
+// themselves next to their implementation, so the list doesn't rot.
+func RegisterCapability(ids ...string) {
+	for _, id := range ids {
+		features[id] = true
+	}
+}
+
+// Whether this build supports the feature.
+func HasCapability(id string) bool {
+	return features[id]
+}
+
+// Returns the capabilities of this build.
+func GetCapabilities() Capabilities {
+	ids := make([]string, 0, len(features))
+	for id := range features {
+		ids = append(ids, id)
+	}
+
+	sort.Strings(ids)
+
+	return Capabilities{
+		Version:                 Version,
+		EventSchemaVersion:      EventSchemaVersion,
+		ExitCodeContractVersion: ExitCodeContractVersion,
+		Features:                ids,
+	}
+}
+
+// Writes the capabilities for "goke capabilities": as JSON, or else the
+// versions followed by the features, one per line.
+func WriteCapabilities(w io.Writer, asJSON bool) error {
+	c := GetCapabilities()
+
+	if asJSON {
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(c)
+	}
+
+	fmt.Fprintf(w, "version: %s\n", c.Version)
+	fmt.Fprintf(w, "event schema version: %d\n", c.EventSchemaVersion)
+	fmt.Fprintf(w, "exit code contract version: %d\n", c.ExitCodeContractVersion)
+	fmt.Fprintln(w, "features:")
+	for _, id := range c.Features {
+		fmt.Fprintf(w, "  %s\n", id)
+	}
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetCapabilities(t *testing.T) {
+	c := GetCapabilities()
+
+	require.Equal(t, Version, c.Version)
+	require.Equal(t, ExitCodeContractVersion, c.ExitCodeContractVersion)
+	require.True(t, sort.StringsAreSorted(c.Features))
+	require.Contains(t, c.Features, "run.export")
+	require.Contains(t, c.Features, "task.deps")
+}
+
+func TestWriteCapabilities(t *testing.T) {
+	out := bytes.Buffer{}
+	require.NoError(t, WriteCapabilities(&out, true))
+
+	var c Capabilities
+	require.NoError(t, json.Unmarshal(out.Bytes(), &c))
+	require.Equal(t, GetCapabilities(), c)
+
+	out.Reset()
+	require.NoError(t, WriteCapabilities(&out, false))
+	require.Contains(t, out.String(), "version: "+Version+"\n")
+	require.Contains(t, out.String(), "features:\n")
+	require.Contains(t, out.String(), "\n  run.export\n")
+}
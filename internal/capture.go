@@ -0,0 +1,246 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterCapability("run.capture_dir")
+}
+
+// Length of the command in the names of the captured files.
+const maxCaptureNameLength = 32
+
+// Captures the stdout and stderr of every command into separate files of a
+// directory, for --capture-dir, along with an index.json describing them.
+// The files of a command are only open while it runs. A nil capture, ie.
+// when the directory couldn't be created, captures nothing.
+type commandCapture struct {
+	dir string
+
+	// Recorded in the index, so that the output of --bare runs isn't
+	// mistaken for the one of normal runs.
+	bare bool
+
+	mu       sync.Mutex
+	seq      int
+	commands []capturedCommand
+}
+
+// A command in the index.json of a capture directory.
+type capturedCommand struct {
+	Task       string `json:"task"`
+	Command    string `json:"command"`
+	Stdout     string `json:"stdout,omitempty"`
+	Stderr     string `json:"stderr,omitempty"`
+	ExitCode   int    `json:"exit_code"`
+	Error      string `json:"error,omitempty"`
+	DurationMs int64  `json:"duration_ms"`
+
+	// The output of the command was discarded, so it has no files, see
+	// runDiscarded.
+	Discarded bool `json:"discarded,omitempty"`
+
+	seq int
+}
+
+// Creates the capture directory. When it can't be created, a warning is
+// printed unless quiet and nothing gets captured.
+func newCommandCapture(dir string, quiet bool, bare bool) *commandCapture {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Warning: not capturing the output of commands: %s\n", err)
+		}
+
+		return nil
+	}
+
+	return &commandCapture{dir: dir, bare: bare}
+}
+
+// The files receiving the output of a single command.
+type commandOutput struct {
+	capture *commandCapture
+	stdout  *os.File
+	stderr  *os.File
+	command capturedCommand
+	started time.Time
+}
+
+// Creates the files for the output of the command. They are named after
+// the order of the command, its task and the start of the command line,
+// ie. "003-build-go-build.stdout.txt". Returns nil when not capturing or
+// when the files can't be created.
+func (c *commandCapture) begin(task string, line string) *commandOutput {
+	if c == nil {
+		return nil
+	}
+
+	if task == "" {
+		task = "events"
+	}
+
+	c.mu.Lock()
+	c.seq++
+	seq := c.seq
+	name := c.uniqueName(fmt.Sprintf("%03d-%s-%s", seq, sanitizeFileName(task, 0), sanitizeFileName(line, maxCaptureNameLength)))
+	c.mu.Unlock()
+
+	o := &commandOutput{
+		capture: c,
+		command: capturedCommand{Task: task, Command: line, Stdout: name + ".stdout.txt", Stderr: name + ".stderr.txt", seq: seq},
+		started: time.Now(),
+	}
+
+	var err error
+	if o.stdout, err = os.Create(filepath.Join(c.dir, o.command.Stdout)); err != nil {
+		return nil
+	}
+
+	if o.stderr, err = os.Create(filepath.Join(c.dir, o.command.Stderr)); err != nil {
+		_ = o.stdout.Close()
+		return nil
+	}
+
+	return o
+}
+
+// Appends a number to the name when files with it already exist, ie. from
+// a previous run.
+func (c *commandCapture) uniqueName(name string) string {
+	unique := name
+	for i := 2; ; i++ {
+		if !FileExists(filepath.Join(c.dir, unique+".stdout.txt")) && !FileExists(filepath.Join(c.dir, unique+".stderr.txt")) {
+			return unique
+		}
+
+		unique = fmt.Sprintf("%s-%d", name, i)
+	}
+}
+
+// Returns w, also writing to the stdout file of the command.
+func (o *commandOutput) teeStdout(w io.Writer) io.Writer {
+	if o == nil {
+		return w
+	}
+
+	return io.MultiWriter(w, o.stdout)
+}
+
+// Returns w, also writing to the stderr file of the command.
+func (o *commandOutput) teeStderr(w io.Writer) io.Writer {
+	if o == nil {
+		return w
+	}
+
+	return io.MultiWriter(w, o.stderr)
+}
+
+// Closes the files and records the command in the index, with its result.
+func (o *commandOutput) finish(err error) {
+	if o == nil {
+		return
+	}
+
+	_ = o.stdout.Close()
+	_ = o.stderr.Close()
+
+	o.command.ExitCode = ExitCode(err)
+	o.command.DurationMs = time.Since(o.started).Milliseconds()
+	if err != nil {
+		o.command.Error = err.Error()
+	}
+
+	o.capture.mu.Lock()
+	o.capture.commands = append(o.capture.commands, o.command)
+	o.capture.mu.Unlock()
+}
+
+// Records a command whose output was discarded, see runDiscarded: only its
+// exit code and duration, without files.
+func (c *commandCapture) discarded(task string, line string, err error, elapsed time.Duration) {
+	if c == nil {
+		return
+	}
+
+	if task == "" {
+		task = "events"
+	}
+
+	command := capturedCommand{Task: task, Command: line, ExitCode: ExitCode(err), DurationMs: elapsed.Milliseconds(), Discarded: true}
+	if err != nil {
+		command.Error = err.Error()
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	command.seq = c.seq
+	c.commands = append(c.commands, command)
+}
+
+// Writes the index.json of the commands which finished, in the order
+// they started. Nothing is written when no command ran, ie. with --dry-run.
+func (c *commandCapture) writeIndex() error {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	if len(c.commands) == 0 {
+		c.mu.Unlock()
+		return nil
+	}
+
+	index := struct {
+		Bare     bool              `json:"bare,omitempty"`
+		Commands []capturedCommand `json:"commands"`
+	}{c.bare, append([]capturedCommand{}, c.commands...)}
+	c.mu.Unlock()
+
+	sort.Slice(index.Commands, func(i, j int) bool { return index.Commands[i].seq < index.Commands[j].seq })
+
+	data, err := json.MarshalIndent(index, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filepath.Join(c.dir, "index.json"), append(data, '\n'), 0644)
+}
+
+// Replaces the characters which aren't safe in file names by dashes, and
+// shortens the name to limit characters unless limit is 0.
+func sanitizeFileName(name string, limit int) string {
+	var b strings.Builder
+	dash := false
+
+	for _, r := range name {
+		if r < 128 && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	s := strings.Trim(b.String(), "-.")
+	if limit > 0 && len(s) > limit {
+		s = strings.TrimRight(s[:limit], "-.")
+	}
+
+	if s == "" {
+		return "command"
+	}
+
+	return s
+}
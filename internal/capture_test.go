@@ -0,0 +1,127 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSanitizeFileName(t *testing.T) {
+	require.Equal(t, "go-build-.-cmd-goke", sanitizeFileName("go build ./cmd/goke", 0))
+	require.Equal(t, "echo-hi-there", sanitizeFileName(`echo "hi  there"`, 0))
+	require.Equal(t, "golangci-lint-run", sanitizeFileName("golangci-lint run --timeout 5m", 17))
+	require.Equal(t, "command", sanitizeFileName("→ ←", 0))
+}
+
+func newCaptureExecutor(t *testing.T, env *InMemoryEnv) Executor {
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+
+	return e
+}
+
+func TestCaptureDirIndexMatchesTheFiles(t *testing.T) {
+	env := NewInMemoryEnv(`
+build:
+  parallel: true
+  run:
+    - "go build ./cmd/a"
+    - "go build ./cmd/b"
+
+test:
+  continue_on_error: true
+  run:
+    - "go test ./..."
+    - "go vet ./..."
+`)
+	dir := filepath.Join(t.TempDir(), "artifacts")
+	env.Options.CaptureDir = dir
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		line := strings.Join(cmd.Args, " ")
+		_, _ = cmd.Stdout.Write([]byte("out: " + line + "\n"))
+
+		if cmd.Args[1] == "vet" {
+			_, _ = cmd.Stderr.Write([]byte("err: " + line + "\n"))
+			return exec.Command("sh", "-c", "exit 3").Run()
+		}
+
+		return nil
+	}
+
+	e := newCaptureExecutor(t, env)
+	// The failure of go vet is ignored, but still recorded.
+	require.Nil(t, e.Start([]string{"build", "test"}))
+
+	var index struct {
+		Commands []capturedCommand `json:"commands"`
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(data, &index))
+	require.Len(t, index.Commands, 4)
+
+	tasks := []string{}
+	files := []string{"index.json"}
+	for _, c := range index.Commands {
+		tasks = append(tasks, c.Task)
+		files = append(files, c.Stdout, c.Stderr)
+
+		require.Equal(t, "out: "+c.Command+"\n", readOutputFile(t, filepath.Join(dir, c.Stdout)))
+		require.GreaterOrEqual(t, c.DurationMs, int64(0))
+
+		if c.Command == "go vet ./..." {
+			require.Equal(t, "004-test-go-vet.stdout.txt", c.Stdout)
+			require.Equal(t, "err: go vet ./...\n", readOutputFile(t, filepath.Join(dir, c.Stderr)))
+			require.Equal(t, 3, c.ExitCode)
+			require.NotEmpty(t, c.Error)
+		} else {
+			require.Equal(t, "", readOutputFile(t, filepath.Join(dir, c.Stderr)))
+			require.Equal(t, 0, c.ExitCode)
+			require.Empty(t, c.Error)
+		}
+	}
+	require.Equal(t, []string{"build", "build", "test", "test"}, tasks)
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+
+	names := []string{}
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	sort.Strings(files)
+	require.Equal(t, files, names)
+}
+
+func TestCaptureDirHasNoIndexWhenNothingRan(t *testing.T) {
+	env := NewInMemoryEnv("build:\n  run: [\"go build\"]\n")
+	dir := filepath.Join(t.TempDir(), "artifacts")
+	env.Options.CaptureDir = dir
+	env.Options.DryRun = true
+
+	e := newCaptureExecutor(t, env)
+	require.Nil(t, e.Start([]string{"build"}))
+	require.Empty(t, recordedCommands(env))
+	require.NoFileExists(t, filepath.Join(dir, "index.json"))
+}
+
+func TestCaptureDirIsDisabledWhenItCannotBeCreated(t *testing.T) {
+	env := NewInMemoryEnv("build:\n  run: [\"go build\"]\n")
+	file := filepath.Join(t.TempDir(), "file")
+	require.Nil(t, os.WriteFile(file, nil, 0644))
+	env.Options.CaptureDir = filepath.Join(file, "artifacts")
+
+	e := newCaptureExecutor(t, env)
+	require.Nil(t, e.Start([]string{"build"}))
+	require.Equal(t, []string{"go build"}, recordedCommands(env))
+	require.NoFileExists(t, filepath.Join(file, "artifacts", "index.json"))
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"os"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterCapability("run.changed_files_file_placeholder")
+}
+
+// The placeholder in "run" entries which gets replaced by the path of a
+// temporary file listing the task's changed files, one per line. Unlike
+// {FILES}, it never grows too long for the command line.
+const ChangedFilesFilePlaceholder = "{CHANGED_FILES_FILE}"
+
+// The files of each task which changed since its last run, or since the
+// revision of --since, as found when deciding whether it runs. Shared by
+// the concurrent tasks of --jobs.
+type changedFiles struct {
+	mu    sync.Mutex
+	files map[string][]string
+}
+
+func newChangedFiles() *changedFiles {
+	return &changedFiles{files: map[string][]string{}}
+}
+
+// Records the changed files of the task which still exist, out of all of
+// its files.
+func (c *changedFiles) record(task string, changed []string, files []string) {
+	if c == nil {
+		return
+	}
+
+	existing := []string{}
+	for _, f := range changed {
+		if containsString(files, f) {
+			existing = append(existing, f)
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.files[task] = existing
+}
+
+// The recorded changed files of the task. Reports false when none were
+// recorded, ie. for tasks which run regardless of their files.
+func (c *changedFiles) get(task string) ([]string, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	files, ok := c.files[task]
+
+	return files, ok
+}
+
+// Replaces {CHANGED_FILES_FILE} in the command with the quoted path of a
+// temporary file listing the changed files of the task, relative to the
+// command's directory. Tasks which run without their files being checked
+// list all of them. The returned function removes the file once the
+// command is done. With --dry-run, the placeholder is printed as is.
+func (e *Executor) changedFilesFile(task Task, entry RunEntry) (RunEntry, func(), error) {
+	if !strings.Contains(entry.Cmd, ChangedFilesFilePlaceholder) || e.options.DryRun {
+		return entry, func() {}, nil
+	}
+
+	files, ok := e.changed.get(task.Name)
+	if !ok {
+		files = e.parser.taskFiles(task)
+	}
+
+	dir := entry.Dir
+	if dir == "" {
+		dir = task.Dir
+	}
+
+	f, err := os.CreateTemp("", "goke-changed-files-*")
+	if err != nil {
+		return entry, nil, err
+	}
+
+	cleanup := func() { os.Remove(f.Name()) }
+
+	content := ""
+	for _, file := range relativeTo(dir, files) {
+		content += file + "\n"
+	}
+
+	_, err = f.WriteString(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		cleanup()
+		return entry, nil, err
+	}
+
+	entry.Cmd = strings.Replace(entry.Cmd, ChangedFilesFilePlaceholder, quoteArg(f.Name()), -1)
+
+	return entry, cleanup, nil
+}
@@ -0,0 +1,66 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestChangedFilesFileListsTheChangedFiles(t *testing.T) {
+	env := NewInMemoryEnv(`
+lint:
+  files: [src/*.go]
+  run:
+    - "golint -set_exit_status -f {CHANGED_FILES_FILE}"
+`)
+	require.Nil(t, env.FS.WriteFile("src/a.go", []byte("a"), 0644))
+	require.Nil(t, env.FS.WriteFile("src/b.go", []byte("b"), 0644))
+
+	path, listed := "", ""
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		path = cmd.Args[len(cmd.Args)-1]
+		content, err := os.ReadFile(path)
+		listed = string(content)
+
+		return err
+	}
+
+	require.Nil(t, env.Run("lint"))
+	require.Equal(t, "", path)
+
+	require.Nil(t, env.FS.WriteFile("src/c go.go", []byte("c"), 0644))
+	require.Nil(t, env.Run("lint"))
+
+	require.Equal(t, "src/c go.go\n", listed)
+	_, err := os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+
+	env.Options.Force = true
+	require.Nil(t, env.Run("lint"))
+	require.Equal(t, "", listed)
+}
+
+func TestChangedFilesFileListsAllFilesWithoutChecks(t *testing.T) {
+	chdir(t, t.TempDir())
+	require.Nil(t, os.MkdirAll("web/src", 0755))
+	require.Nil(t, os.WriteFile("web/src/app.js", []byte(""), 0644))
+	require.Nil(t, os.WriteFile("web/src/lib.js", []byte(""), 0644))
+
+	config := `
+lint:
+  dir: web
+  files: [src/*.js]
+  run:
+    - "cp {CHANGED_FILES_FILE} lint.out"
+`
+
+	parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseTasks())
+
+	e := Executor{parser: parser, options: Options{Quiet: true}}
+	require.Nil(t, e.dispatchTask(parser.Tasks["lint"], true))
+
+	require.Equal(t, "src/app.js\nsrc/lib.js\n", readOutputFile(t, "web/lint.out"))
+}
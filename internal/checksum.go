@@ -0,0 +1,238 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("builtin.checksum")
+}
+
+var checksumAlgos = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+const checksumUsage = "usage: goke:checksum write [--algo name] files... [> sums] | goke:checksum verify [--algo name] sums"
+
+// Implements "goke:checksum write" and "goke:checksum verify". The sums file
+// has the format of sha256sum and friends, one "<hash>  <path>" per line,
+// with slash separated paths relative to the directory of the sums file.
+func checksumBuiltin(args []string, dir string, stdout io.Writer) error {
+	if len(args) == 0 {
+		return errors.New(checksumUsage)
+	}
+
+	flags := flag.NewFlagSet("goke:checksum", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	algo := flags.String("algo", "sha256", "")
+
+	if err := flags.Parse(args[1:]); err != nil {
+		return fmt.Errorf("%w\n%s", err, checksumUsage)
+	}
+
+	newHash, ok := checksumAlgos[*algo]
+	if !ok {
+		return fmt.Errorf("unknown checksum algorithm %s, use one of md5, sha1, sha256 or sha512", *algo)
+	}
+
+	switch args[0] {
+	case "write":
+		patterns, target, err := splitRedirect(flags.Args())
+		if err != nil {
+			return err
+		}
+
+		return writeChecksums(newHash, dir, patterns, target, stdout)
+	case "verify":
+		if flags.NArg() != 1 {
+			return errors.New(checksumUsage)
+		}
+
+		return verifyChecksums(newHash, joinDir(dir, flags.Arg(0)), stdout)
+	}
+
+	return errors.New(checksumUsage)
+}
+
+// Writes the checksums of the files matching the patterns, sorted by path,
+// to the target file or to stdout when there is none.
+func writeChecksums(newHash func() hash.Hash, dir string, patterns []string, target string, stdout io.Writer) error {
+	if len(patterns) == 0 {
+		return errors.New("no files to checksum")
+	}
+
+	base := dir
+	if target != "" {
+		target = joinDir(dir, target)
+		base = filepath.Dir(target)
+	}
+
+	files, err := checksumFiles(dir, patterns, target)
+	if err != nil {
+		return err
+	}
+
+	sums := bytes.Buffer{}
+	for _, f := range files {
+		sum, err := fileChecksum(newHash, f)
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(absDir(base), f)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(&sums, "%s  %s\n", sum, filepath.ToSlash(rel))
+	}
+
+	if target == "" {
+		_, err := stdout.Write(sums.Bytes())
+		return err
+	}
+
+	return os.WriteFile(target, sums.Bytes(), 0644)
+}
+
+// Verifies every file listed in the sums file, reporting each one on stdout.
+// All files are checked, even after the first mismatch.
+func verifyChecksums(newHash func() hash.Hash, sumsFile string, stdout io.Writer) error {
+	f, err := os.Open(sumsFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	base := filepath.Dir(sumsFile)
+	failed, total := 0, 0
+	scanner := bufio.NewScanner(f)
+
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimRight(scanner.Text(), "\r")
+		if text == "" {
+			continue
+		}
+
+		expected, path, ok := strings.Cut(text, " ")
+		if !ok || len(path) < 2 {
+			return fmt.Errorf("%s:%d: malformed checksum line", sumsFile, line)
+		}
+
+		// The second character marks text (" ") or binary ("*") mode, both are read as is.
+		path = path[1:]
+		total++
+
+		actual, err := fileChecksum(newHash, filepath.Join(base, filepath.FromSlash(path)))
+
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			failed++
+			fmt.Fprintf(stdout, "%s: FAILED (missing)\n", path)
+		case err != nil:
+			return err
+		case !strings.EqualFold(actual, expected):
+			failed++
+			fmt.Fprintf(stdout, "%s: FAILED (checksum mismatch)\n", path)
+		default:
+			fmt.Fprintf(stdout, "%s: OK\n", path)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d files failed checksum verification", failed, total)
+	}
+
+	return nil
+}
+
+// Returns the absolute paths of the regular files matching the patterns,
+// sorted and without duplicates. The sums file itself is left out.
+func checksumFiles(dir string, patterns []string, exclude string) ([]string, error) {
+	seen := make(map[string]bool)
+	files := []string{}
+
+	if exclude != "" {
+		exclude = filepath.Join(absDir(filepath.Dir(exclude)), filepath.Base(exclude))
+	}
+
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(joinDir(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %s", pattern)
+		}
+
+		for _, m := range matches {
+			info, err := os.Stat(m)
+			if err != nil {
+				return nil, err
+			}
+
+			abs := filepath.Join(absDir(filepath.Dir(m)), filepath.Base(m))
+			if !info.Mode().IsRegular() || abs == exclude || seen[abs] {
+				continue
+			}
+
+			seen[abs] = true
+			files = append(files, abs)
+		}
+	}
+
+	sort.Strings(files)
+	return files, nil
+}
+
+func fileChecksum(newHash func() hash.Hash, path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := newHash()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Makes the directory absolute, falling back to it as is.
+func absDir(dir string) string {
+	if dir == "" {
+		dir = "."
+	}
+
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return dir
+	}
+
+	return abs
+}
@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+func writeDist(t *testing.T) {
+	chdir(t, t.TempDir())
+	require.Nil(t, os.MkdirAll("dist/docs", 0755))
+	require.Nil(t, os.WriteFile("dist/b.tar.gz", []byte("b"), 0644))
+	require.Nil(t, os.WriteFile("dist/a.zip", []byte("a"), 0644))
+}
+
+func TestChecksumWrite(t *testing.T) {
+	writeDist(t)
+
+	out := bytes.Buffer{}
+	require.Nil(t, checksumBuiltin([]string{"write", "dist/*", ">", "SHA256SUMS"}, "", &out))
+	require.Empty(t, out.String())
+
+	expected := sha256Hex("a") + "  dist/a.zip\n" + sha256Hex("b") + "  dist/b.tar.gz\n"
+	require.Equal(t, expected, readOutputFile(t, "SHA256SUMS"))
+
+	// Paths are relative to the sums file, which is not part of its own sums.
+	require.Nil(t, checksumBuiltin([]string{"write", "dist/*", ">dist/SHA256SUMS"}, "", &out))
+	require.Nil(t, checksumBuiltin([]string{"write", "dist/*", ">dist/SHA256SUMS"}, "", &out))
+	require.Equal(t, sha256Hex("a")+"  a.zip\n"+sha256Hex("b")+"  b.tar.gz\n", readOutputFile(t, "dist/SHA256SUMS"))
+
+	require.Nil(t, checksumBuiltin([]string{"write", "--algo", "md5", "a.zip"}, "dist", &out))
+	require.Equal(t, "0cc175b9c0f1b6a831c399e269772661  a.zip\n", out.String())
+}
+
+func TestChecksumVerify(t *testing.T) {
+	writeDist(t)
+	require.Nil(t, checksumBuiltin([]string{"write", "dist/*", ">", "SHA256SUMS"}, "", &bytes.Buffer{}))
+
+	out := bytes.Buffer{}
+	require.Nil(t, checksumBuiltin([]string{"verify", "SHA256SUMS"}, "", &out))
+	require.Equal(t, "dist/a.zip: OK\ndist/b.tar.gz: OK\n", out.String())
+}
+
+func TestChecksumVerifyReportsEveryFailure(t *testing.T) {
+	writeDist(t)
+	require.Nil(t, os.WriteFile("dist/c.txt", []byte("c"), 0644))
+	require.Nil(t, checksumBuiltin([]string{"write", "dist/*", ">", "SHA256SUMS"}, "", &bytes.Buffer{}))
+
+	require.Nil(t, os.WriteFile("dist/a.zip", []byte("corrupted"), 0644))
+	require.Nil(t, os.Remove("dist/b.tar.gz"))
+
+	out := bytes.Buffer{}
+	err := checksumBuiltin([]string{"verify", "SHA256SUMS"}, "", &out)
+
+	require.EqualError(t, err, "2 of 3 files failed checksum verification")
+	require.Equal(t, "dist/a.zip: FAILED (checksum mismatch)\ndist/b.tar.gz: FAILED (missing)\ndist/c.txt: OK\n", out.String())
+}
+
+func TestChecksumUsageErrors(t *testing.T) {
+	require.NotNil(t, checksumBuiltin(nil, "", &bytes.Buffer{}))
+	require.EqualError(t, checksumBuiltin([]string{"write", "--algo", "crc", "a"}, "", &bytes.Buffer{}), "unknown checksum algorithm crc, use one of md5, sha1, sha256 or sha512")
+	require.EqualError(t, checksumBuiltin([]string{"write", "a", ">", "b", "c"}, "", &bytes.Buffer{}), "a redirection must be followed by exactly one file")
+
+	_, _, err := lookupBuiltin("goke:sum")
+	require.EqualError(t, err, "unknown built-in command goke:sum")
+}
+
+func TestDispatchTaskRunsBuiltins(t *testing.T) {
+	writeDist(t)
+
+	config := `
+release:
+  shell: true
+  run:
+    - "goke:checksum write dist/* > SHA256SUMS"
+    - "goke:checksum verify SHA256SUMS"
+`
+
+	parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseTasks())
+
+	e := Executor{parser: parser, options: Options{Quiet: true}}
+	require.Nil(t, e.dispatchTask(parser.Tasks["release"], true))
+	require.FileExists(t, "SHA256SUMS")
+}
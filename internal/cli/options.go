@@ -2,20 +2,248 @@ package cli
 
 import (
 	"flag"
+	"os"
+	"sort"
 
 	"github.com/dugajean/goke/internal"
 )
 
-func GetOptions() internal.Options {
+func init() {
+	internal.RegisterCapability(
+		"flag.no-cache",
+		"flag.watch",
+		"flag.force",
+		"flag.init",
+		"flag.quiet",
+		"flag.version",
+		"flag.verbose",
+		"flag.v",
+		"flag.debounce",
+		"flag.serve-status",
+		"flag.allow-remote-trigger",
+		"flag.list",
+		"flag.l",
+		"flag.batch",
+		"flag.preflight",
+		"flag.tag",
+		"flag.hup",
+		"flag.capture-dir",
+		"flag.dry-run",
+		"flag.keep-temp",
+		"flag.temp-retention",
+		"flag.allow-multiple-watch",
+		"flag.vv",
+		"flag.env-conflicts",
+		"flag.no-interactive",
+		"flag.deadline",
+		"flag.check",
+		"flag.diff",
+		"flag.jobs",
+		"flag.since",
+		"flag.config",
+		"flag.f",
+		"flag.strict",
+		"flag.state",
+		"flag.no-generate",
+		"flag.update-golden",
+		"flag.summary-line",
+		"flag.bare",
+		"flag.json",
+		"flag.delete",
+		"flag.unused-for",
+		"flag.porcelain",
+		"flag.discard-quiet-commands",
+		"flag.tools",
+	)
+}
+
+// Parses the command line into the options and the names of the tasks to run.
+func GetOptions() (internal.Options, []string) {
 	var opts internal.Options
 
-	flag.BoolVar(&opts.ClearCache, "no-cache", false, "Clear Goke's cache. Default: false")
-	flag.BoolVar(&opts.Watch, "watch", false, "Goke remains on and watches the task's specified files for changes, then reruns the command. Default: false")
-	flag.BoolVar(&opts.Force, "force", false, "Executes the task regardless whether the files have changed or not. Default: false")
-	flag.BoolVar(&opts.Init, "init", false, "Initializes a goke.yml file in the current directory")
-	flag.BoolVar(&opts.Quiet, "quiet", false, "Disables all output to the console. Default: false")
-	flag.BoolVar(&opts.Version, "version", false, "Prints the current Goke version")
-	flag.Parse()
+	RegisterFlags(flag.CommandLine, &opts)
+	tasks, extra, _ := ParseArgs(flag.CommandLine, &opts, os.Args[1:])
+	tasks, opts.Params = internal.SplitParams(tasks)
+	opts.ExtraArgs = extra
+	opts.Verbose = opts.Verbose || opts.VeryVerbose
+
+	return opts, tasks
+}
+
+// Parses the flags, which may be given before, after or in between task
+// names, and returns the task names in order. Everything after "--" is
+// returned as is, untouched by the flags, for the {ARGS} placeholder. The
+// flags of a command, see RegisterCommandFlags, are only accepted after its
+// name, given first, and the ones given end up in opts.CommandFlags.
+func ParseArgs(fs *flag.FlagSet, opts *internal.Options, args []string) ([]string, []string, error) {
+	tasks := []string{}
+	var extra, commandFlags []string
+
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, nil, err
+		}
+
+		rest := fs.Args()
+		if len(rest) == 0 {
+			break
+		}
+
+		if len(args) > len(rest) && args[len(args)-len(rest)-1] == "--" {
+			extra = rest
+			break
+		}
+
+		if len(tasks) == 0 {
+			commandFlags = RegisterCommandFlags(fs, rest[0], opts)
+		}
+
+		tasks = append(tasks, rest[0])
+		args = rest[1:]
+	}
+
+	fs.Visit(func(f *flag.Flag) {
+		for _, name := range commandFlags {
+			if f.Name == name {
+				opts.CommandFlags = append(opts.CommandFlags, "--"+name)
+			}
+		}
+	})
+
+	return tasks, extra, nil
+}
+
+// The flags of goke's commands, ie. "goke doctor --tools", by command.
+var commandFlags = map[string]func(fs *flag.FlagSet, opts *internal.Options){
+	"capabilities": func(fs *flag.FlagSet, opts *internal.Options) {
+		fs.BoolVar(&opts.JSON, "json", false, "With goke capabilities, prints the report as JSON")
+	},
+	"completion": func(fs *flag.FlagSet, opts *internal.Options) {
+		fs.BoolVar(&opts.Porcelain, "porcelain", false, "With goke completion, prints the task names one per line, followed by their description after a tab, for the completion scripts")
+	},
+	"doctor": func(fs *flag.FlagSet, opts *internal.Options) {
+		fs.BoolVar(&opts.Tools, "tools", false, "With goke doctor, only checks that the binaries used by all tasks exist")
+	},
+	"fmt": func(fs *flag.FlagSet, opts *internal.Options) {
+		fs.BoolVar(&opts.Check, "check", false, "With goke fmt, fails when goke.yml isn't formatted instead of formatting it")
+		fs.BoolVar(&opts.Diff, "diff", false, "With goke fmt, prints what formatting would change instead of formatting goke.yml")
+	},
+	"prune-tasks": func(fs *flag.FlagSet, opts *internal.Options) {
+		fs.BoolVar(&opts.JSON, "json", false, "With goke prune-tasks, prints the unused tasks as JSON")
+		fs.BoolVar(&opts.Delete, "delete", false, "With goke prune-tasks, asks which of the unused tasks to delete from the config")
+		fs.DurationVar(&opts.UnusedFor, "unused-for", internal.DefaultUnusedFor, "With goke prune-tasks, how long a task must not have run successfully to be reported")
+	},
+}
+
+// Binds the flags of the command to the given options, unless fs already
+// has a flag of the same name, and returns their names. Commands without
+// flags of their own have none.
+func RegisterCommandFlags(fs *flag.FlagSet, command string, opts *internal.Options) []string {
+	register, ok := commandFlags[command]
+	if !ok {
+		return nil
+	}
+
+	own := flag.NewFlagSet(command, flag.ContinueOnError)
+	register(own, opts)
+
+	names := []string{}
+	own.VisitAll(func(f *flag.Flag) {
+		if fs.Lookup(f.Name) == nil {
+			fs.Var(f.Value, f.Name, f.Usage)
+		}
+		names = append(names, f.Name)
+	})
+
+	return names
+}
+
+// Binds the flags of all commands, ie. for the completion scripts.
+func RegisterAllCommandFlags(fs *flag.FlagSet, opts *internal.Options) {
+	for _, command := range sortedCommands() {
+		RegisterCommandFlags(fs, command, opts)
+	}
+}
+
+func sortedCommands() []string {
+	names := make([]string, 0, len(commandFlags))
+	for name := range commandFlags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// Binds all of goke's flags to the given options.
+func RegisterFlags(fs *flag.FlagSet, opts *internal.Options) {
+	fs.BoolVar(&opts.ClearCache, "no-cache", false, "Parses the config without reading or writing Goke's cache")
+	fs.BoolVar(&opts.NoGenerate, "no-generate", false, "Parses the config without running its generate_tasks command, ie. offline")
+	fs.BoolVar(&opts.Watch, "watch", false, "Goke remains on and watches the task's specified files for changes, then reruns the command")
+	fs.BoolVar(&opts.Force, "force", false, "Executes the task regardless whether the files have changed or not")
+	fs.StringVar(&opts.ConfigPath, "config", "", "Loads the given config instead of the goke.yml of the current directory, ie. --config ci/goke.yml")
+	fs.StringVar(&opts.ConfigPath, "f", "", "Shorthand for --config")
+	fs.StringVar(&opts.State, "state", internal.StateAuto, "Where the lockfile and the cache of the config live: local for the project's .goke directory, global for the home and temp directories, or auto for local in git repositories and projects with a .goke directory")
+	fs.BoolVar(&opts.Strict, "strict", false, "Fails when tasks declare overlapping outputs or use unknown placeholders instead of warning")
+	fs.BoolVar(&opts.Init, "init", false, "Initializes a goke.yml file in the current directory")
+	fs.BoolVar(&opts.Quiet, "quiet", false, "Disables all output to the console")
+	fs.BoolVar(&opts.Version, "version", false, "Prints the current Goke version")
+	fs.BoolVar(&opts.Verbose, "verbose", false, "Prints additional details about what Goke is doing")
+	fs.BoolVar(&opts.Verbose, "v", false, "Shorthand for --verbose")
+	fs.BoolVar(&opts.VeryVerbose, "vv", false, "Like --verbose, also reporting the variables of each task defined by more than one source")
+	fs.BoolVar(&opts.EnvConflicts, "env-conflicts", false, "With goke config, lists the variables of each task defined by more than one source")
+	fs.DurationVar(&opts.Debounce, "debounce", internal.DefaultDebounce, "How long --watch waits for file changes to settle before rerunning the task")
+	fs.StringVar(&opts.ServeStatus, "serve-status", "", "Serves the state of the --watch session over HTTP on the given address, ie. :4477")
+	fs.BoolVar(&opts.AllowRemoteTrigger, "allow-remote-trigger", false, "Allows POST /trigger on the --serve-status server to rerun the task")
+	fs.BoolVar(&opts.List, "list", false, "Lists the available tasks with their descriptions")
+	fs.BoolVar(&opts.List, "l", false, "Shorthand for --list")
+	fs.StringVar(&opts.Batch, "batch", "", "Runs the steps of the given plan file, ie. --batch plan.yml")
+	fs.BoolVar(&opts.Preflight, "preflight", false, "Checks that all binaries used by the tasks exist before running anything")
+	fs.StringVar(&opts.Tag, "tag", "", "Runs all tasks with the given tag, ie. --tag docker")
+	fs.StringVar(&opts.Hup, "hup", internal.HupStop, "What --watch does when its terminal closes: stop, or ignore to keep running with the output written to a log file")
+	fs.StringVar(&opts.CaptureDir, "capture-dir", "", "Writes the stdout and stderr of every command to separate files in the given directory, with an index.json describing them")
+	fs.BoolVar(&opts.UpdateGolden, "update-golden", false, "Rewrites the golden files of the commands with their output instead of comparing them")
+	fs.BoolVar(&opts.DryRun, "dry-run", false, "Prints the commands the tasks would run, without running them")
+	fs.BoolVar(&opts.KeepTemp, "keep-temp", false, "Keeps goke's old temp files instead of removing them on startup")
+	fs.DurationVar(&opts.TempRetention, "temp-retention", internal.DefaultTempRetention, "How old goke's temp files get before they are removed")
+	fs.BoolVar(&opts.AllowMultipleWatch, "allow-multiple-watch", false, "Starts --watch even when another session watches the same tasks or files of the project")
+	fs.DurationVar(&opts.Deadline, "deadline", 0, "Stops the run once the given duration passed, ie. --deadline 25m, and exits with 75")
+	fs.IntVar(&opts.Jobs, "jobs", 1, "Runs the given tasks concurrently, at most this many at a time. Tasks of the same group never overlap")
+	fs.StringVar(&opts.Since, "since", "", "Only runs the tasks whose files changed since the given git ref, ie. origin/main, or within the given duration, ie. 2h")
+	fs.BoolVar(&opts.NoInteractive, "no-interactive", false, "Never asks whether to rerun the tasks after a failed run, nor which task to run without a main task")
+	fs.Var(summaryLineFlag{&opts.SummaryLine}, "summary-line", "Prints a single line summing up the run once it's over, even with --quiet, or writes it to the given file with --summary-line=status.txt")
+	fs.BoolVar(&opts.DiscardQuiet, "discard-quiet-commands", false, "Discards the output of the commands which ran in less than 100ms without any, from their next run on")
+	fs.BoolVar(&opts.Bare, "bare", false, "Runs only the commands of the tasks, without the events, on_success and on_failure, nor the variables of global.environment")
+}
+
+// The value of --summary-line, which is the file to write the line to, or
+// stdout when it's given without one.
+type summaryLineFlag struct {
+	target *string
+}
+
+func (f summaryLineFlag) String() string {
+	if f.target == nil {
+		return ""
+	}
+
+	return *f.target
+}
+
+func (f summaryLineFlag) Set(value string) error {
+	switch value {
+	case "true":
+		*f.target = internal.SummaryLineStdout
+	case "false":
+		*f.target = ""
+	default:
+		*f.target = value
+	}
+
+	return nil
+}
 
-	return opts
+// Allows the flag without a value.
+func (f summaryLineFlag) IsBoolFlag() bool {
+	return true
 }
@@ -0,0 +1,139 @@
+package cli
+
+import (
+	"flag"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/dugajean/goke/internal"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArgs(t *testing.T) {
+	var opts internal.Options
+	fs := flag.NewFlagSet("goke", flag.ContinueOnError)
+	RegisterFlags(fs, &opts)
+
+	tasks, extra, err := ParseArgs(fs, &opts, []string{"build", "--force", "test", "--debounce", "1s", "build"})
+
+	require.Nil(t, err)
+	require.Equal(t, []string{"build", "test", "build"}, tasks)
+	require.Empty(t, extra)
+	require.True(t, opts.Force)
+	require.Equal(t, time.Second, opts.Debounce)
+}
+
+func TestParseArgsKeepsTheArgumentsAfterDoubleDash(t *testing.T) {
+	var opts internal.Options
+	fs := flag.NewFlagSet("goke", flag.ContinueOnError)
+	RegisterFlags(fs, &opts)
+
+	tasks, extra, err := ParseArgs(fs, &opts, []string{"test", "--force", "--", "-run", "TestFoo", "-v", "--", "x"})
+
+	require.Nil(t, err)
+	require.Equal(t, []string{"test"}, tasks)
+	require.Equal(t, []string{"-run", "TestFoo", "-v", "--", "x"}, extra)
+	require.True(t, opts.Force)
+	require.False(t, opts.Verbose)
+}
+
+func TestParseArgsWithoutTasks(t *testing.T) {
+	var opts internal.Options
+	fs := flag.NewFlagSet("goke", flag.ContinueOnError)
+	RegisterFlags(fs, &opts)
+
+	tasks, _, err := ParseArgs(fs, &opts, []string{"-v"})
+
+	require.Nil(t, err)
+	require.Empty(t, tasks)
+	require.True(t, opts.Verbose)
+}
+
+func TestParseArgsWithSummaryLine(t *testing.T) {
+	var opts internal.Options
+	fs := flag.NewFlagSet("goke", flag.ContinueOnError)
+	RegisterFlags(fs, &opts)
+
+	tasks, _, err := ParseArgs(fs, &opts, []string{"--summary-line", "ci"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"ci"}, tasks)
+	require.Equal(t, internal.SummaryLineStdout, opts.SummaryLine)
+
+	_, _, err = ParseArgs(fs, &opts, []string{"ci", "--summary-line=status.txt"})
+	require.Nil(t, err)
+	require.Equal(t, "status.txt", opts.SummaryLine)
+}
+
+func TestParseArgsOnlyAcceptsCommandFlagsAfterTheirCommand(t *testing.T) {
+	var opts internal.Options
+	fs := flag.NewFlagSet("goke", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs, &opts)
+
+	tasks, _, err := ParseArgs(fs, &opts, []string{"doctor", "-v", "--tools"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"doctor"}, tasks)
+	require.True(t, opts.Tools)
+	require.Equal(t, []string{"--tools"}, opts.CommandFlags)
+
+	opts = internal.Options{}
+	fs = flag.NewFlagSet("goke", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs, &opts)
+
+	_, _, err = ParseArgs(fs, &opts, []string{"build", "--tools"})
+	require.EqualError(t, err, "flag provided but not defined: -tools")
+
+	_, _, err = ParseArgs(fs, &opts, []string{"--tools", "doctor"})
+	require.EqualError(t, err, "flag provided but not defined: -tools")
+}
+
+func TestFmtFlagsOnlyApplyToFmt(t *testing.T) {
+	var opts internal.Options
+	fs := flag.NewFlagSet("goke", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs, &opts)
+
+	_, _, err := ParseArgs(fs, &opts, []string{"build", "--check"})
+	require.EqualError(t, err, "flag provided but not defined: -check")
+
+	tasks, _, err := ParseArgs(fs, &opts, []string{"fmt", "--check", "--diff"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"fmt"}, tasks)
+	require.True(t, opts.Check)
+	require.True(t, opts.Diff)
+	require.Equal(t, []string{"--check", "--diff"}, opts.CommandFlags)
+}
+
+func TestPruneTasksFlagsOnlyApplyToPruneTasks(t *testing.T) {
+	var opts internal.Options
+	fs := flag.NewFlagSet("goke", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs, &opts)
+
+	_, _, err := ParseArgs(fs, &opts, []string{"build", "--unused-for", "1h"})
+	require.EqualError(t, err, "flag provided but not defined: -unused-for")
+
+	tasks, _, err := ParseArgs(fs, &opts, []string{"prune-tasks", "--json", "--unused-for", "1h"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"prune-tasks"}, tasks)
+	require.True(t, opts.JSON)
+	require.False(t, opts.Delete)
+	require.Equal(t, time.Hour, opts.UnusedFor)
+}
+
+func TestPorcelainOnlyAppliesToCompletion(t *testing.T) {
+	var opts internal.Options
+	fs := flag.NewFlagSet("goke", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	RegisterFlags(fs, &opts)
+
+	_, _, err := ParseArgs(fs, &opts, []string{"--list", "--porcelain"})
+	require.EqualError(t, err, "flag provided but not defined: -porcelain")
+
+	tasks, _, err := ParseArgs(fs, &opts, []string{"completion", "--porcelain"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"completion"}, tasks)
+	require.True(t, opts.Porcelain)
+}
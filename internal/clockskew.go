@@ -0,0 +1,98 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	RegisterCapability("files.clock_skew")
+}
+
+// How far the clock of the filesystem of the config may be from the one of
+// this machine before its mtimes aren't trusted, see CheckClockSkew.
+const maxClockSkew = 5 * time.Second
+
+// The file written next to the config to read the clock of its filesystem.
+const clockProbeName = ".goke-clock-probe"
+
+// Implemented by the filesystems with a clock of their own, which is then
+// the local time, ie. MemFileSystem.
+type clockFileSystem interface {
+	Now() time.Time
+}
+
+func localNow(fs FileSystem) time.Time {
+	if c, ok := fs.(clockFileSystem); ok {
+		return c.Now()
+	}
+
+	return time.Now()
+}
+
+// Measures how far the clock of the filesystem of dir is from the one of
+// this machine, ie. the one of an NFS server, by writing a probe file in dir
+// and comparing its mtime with the local time around the write. Positive
+// when the filesystem is ahead.
+func MeasureClockSkew(fs FileSystem, dir string) (time.Duration, error) {
+	probe := filepath.Join(dir, clockProbeName)
+
+	// Filesystems may only keep the seconds of mtimes.
+	before := localNow(fs).Truncate(time.Second)
+	if err := fs.WriteFile(probe, nil, 0644); err != nil {
+		return 0, err
+	}
+	defer func() { _ = fs.Remove(probe) }()
+	after := localNow(fs)
+
+	info, err := fs.Stat(probe)
+	if err != nil {
+		return 0, err
+	}
+
+	switch mtime := info.ModTime(); {
+	case mtime.Before(before):
+		return mtime.Sub(before), nil
+	case mtime.After(after):
+		return mtime.Sub(after), nil
+	}
+
+	return 0, nil
+}
+
+// Measures the clock skew of the filesystem of the config, see
+// MeasureClockSkew. Past maxClockSkew, the tasks which don't set checksum
+// detect changes by checksum instead of mtime, and a warning says so. The
+// cache of the config doesn't depend on mtimes, see cacheKey.
+func (p *Parser) CheckClockSkew() {
+	dir := filepath.Dir(p.configFile())
+	if cwd, err := p.fs.Getwd(); err == nil && !filepath.IsAbs(dir) {
+		dir = filepath.Join(cwd, dir)
+	}
+
+	skew, err := MeasureClockSkew(p.fs, dir)
+	if err != nil || (skew <= maxClockSkew && skew >= -maxClockSkew) {
+		return
+	}
+
+	p.clockSkew = skew
+	if !p.options.Quiet {
+		fmt.Fprintf(os.Stderr, "Warning: the clock of the filesystem of %s is %s, detecting changes by checksum instead of mtime\n", dir, DescribeClockSkew(skew))
+	}
+}
+
+// Describes the clock skew of a filesystem, ie. "42s ahead of this machine".
+func DescribeClockSkew(skew time.Duration) string {
+	if skew == 0 {
+		return "none"
+	}
+
+	direction := "ahead of"
+	if skew < 0 {
+		skew, direction = -skew, "behind"
+	}
+
+	return fmt.Sprintf("%s %s this machine", skew.Round(time.Second), direction)
+}
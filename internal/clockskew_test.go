@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A MemFileSystem whose mtimes are off by skew, like an NFS mount whose
+// server has another clock.
+type skewedFileSystem struct {
+	*MemFileSystem
+	skew time.Duration
+}
+
+type skewedFileInfo struct {
+	fs.FileInfo
+	skew time.Duration
+}
+
+func (fi skewedFileInfo) ModTime() time.Time { return fi.FileInfo.ModTime().Add(fi.skew) }
+
+func (s skewedFileSystem) Stat(name string) (fs.FileInfo, error) {
+	info, err := s.MemFileSystem.Stat(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return skewedFileInfo{info, s.skew}, nil
+}
+
+func TestMeasureClockSkew(t *testing.T) {
+	t.Parallel()
+
+	for _, skew := range []time.Duration{0, 42 * time.Second, -3 * time.Minute} {
+		fs := skewedFileSystem{NewMemFileSystem("/work"), skew}
+
+		measured, err := MeasureClockSkew(fs, "/work")
+		require.Nil(t, err)
+		// The write takes a second of the clock of MemFileSystem.
+		require.InDelta(t, skew.Seconds(), measured.Seconds(), 1)
+		require.False(t, fs.FileExists("/work/"+clockProbeName))
+	}
+
+	require.Equal(t, "none", DescribeClockSkew(0))
+	require.Equal(t, "42s ahead of this machine", DescribeClockSkew(42*time.Second))
+	require.Equal(t, "3m0s behind this machine", DescribeClockSkew(-3*time.Minute))
+}
+
+func TestClockSkewSwitchesToChecksums(t *testing.T) {
+	t.Parallel()
+
+	config := `
+build:
+  files: [main.go]
+  run:
+    - "go build"
+
+lint:
+  files: [main.go]
+  checksum: false
+  run:
+    - "golangci-lint run"
+`
+	opts := Options{Quiet: true, ClearCache: true}
+
+	for skew, checksum := range map[time.Duration]bool{time.Second: false, -time.Minute: true} {
+		fs := skewedFileSystem{NewMemFileSystem("/work"), skew}
+		require.Nil(t, fs.WriteFile("/work/main.go", []byte("package main"), 0644))
+
+		p := NewParser(config, &opts, fs)
+		require.Nil(t, p.Bootstrap())
+		p.CheckClockSkew()
+
+		require.Equal(t, checksum, p.usesChecksum(p.Tasks["build"]))
+		require.False(t, p.usesChecksum(p.Tasks["lint"]))
+	}
+}
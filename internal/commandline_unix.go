@@ -0,0 +1,16 @@
+//go:build !windows
+
+package internal
+
+import "github.com/dugajean/goke/pkg/shellwords"
+
+// Splits the command line into words, see shellwords.Split.
+func splitWords(line string) ([]string, error) {
+	return shellwords.Split(line)
+}
+
+// Expands the variables of the system's own syntax, which only Windows has,
+// see commandline_windows.go.
+func expandSystemVars(str string, lookup func(name string) (string, bool)) string {
+	return str
+}
@@ -0,0 +1,30 @@
+//go:build windows
+
+package internal
+
+import (
+	"regexp"
+
+	"github.com/dugajean/goke/pkg/shellwords"
+)
+
+// Splits the command line into words with the backslash rules of Windows,
+// so that paths keep their backslashes, see shellwords.SplitWindows.
+func splitWords(line string) ([]string, error) {
+	return shellwords.SplitWindows(line)
+}
+
+// Matches the variables of cmd, ie. %USERPROFILE%.
+var percentVarRegexp = regexp.MustCompile(`%([A-Za-z_][A-Za-z0-9_]*)%`)
+
+// Expands the %VAR% variables of cmd, leaving the undefined ones as they
+// are, like cmd does.
+func expandSystemVars(str string, lookup func(name string) (string, bool)) string {
+	return percentVarRegexp.ReplaceAllStringFunc(str, func(m string) string {
+		if v, ok := lookup(m[1 : len(m)-1]); ok {
+			return v
+		}
+
+		return m
+	})
+}
@@ -0,0 +1,44 @@
+//go:build windows
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplitCommandKeepsBackslashes(t *testing.T) {
+	words, err := splitCommand(`C:\tools\protoc.exe --proto_path=.\api "C:\Program Files\include\a.proto"`)
+	require.Nil(t, err)
+	require.Equal(t, []string{`C:\tools\protoc.exe`, `--proto_path=.\api`, `C:\Program Files\include\a.proto`}, words)
+
+	words, err = ParseCommandLine(`echo "say \"hi\"" 'a\b'`)
+	require.Nil(t, err)
+	require.Equal(t, []string{"echo", `say "hi"`, `a\b`}, words)
+}
+
+func TestExpandEnvExpandsPercentVariables(t *testing.T) {
+	out, err := expandEnv(`copy %OUT_DIR%\app.exe %MISSING_GOKE_VAR% $OUT_DIR`, map[string]string{"OUT_DIR": `C:\dist`})
+	require.Nil(t, err)
+	require.Equal(t, `copy C:\dist\app.exe %MISSING_GOKE_VAR% C:\dist`, out)
+}
+
+func TestTempFileNameWithDriveLetters(t *testing.T) {
+	fs := NewMemFileSystem(`C:\Users\me\app`)
+	require.Nil(t, fs.WriteFile(`C:\Users\me\app\goke.yml`, []byte(yamlConfigStub), 0644))
+
+	name := func(configPath string) string {
+		p := Parser{fs: fs, options: Options{ConfigPath: configPath}}
+		return p.getTempFileName()
+	}
+
+	require.Equal(t, "goke-v"+cacheVersion+"-C-Users-me-app", name(""))
+	require.Equal(t, name(""), name(`C:\Users\me\app\goke.yml`))
+	require.Equal(t, "goke-v"+cacheVersion+"-C-Users-me-app-ci-goke.yml", name(`ci\goke.yml`))
+}
+
+func TestShellCommandOnWindows(t *testing.T) {
+	require.Equal(t, []string{"cmd", "/C", "dir /B"}, shellCommand("dir /B", ""))
+	require.Equal(t, []string{"powershell", "-NoProfile", "-NonInteractive", "-Command", "Get-ChildItem"}, shellCommand("Get-ChildItem", windowsShellPowerShell))
+}
@@ -0,0 +1,193 @@
+package internal
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("command.completion", "completion.porcelain")
+}
+
+// The shells "goke completion" writes scripts for.
+var CompletionShells = []string{"bash", "zsh", "fish"}
+
+// Writes the completion script of the shell. The scripts complete the flags
+// and the commands given here, and the tasks of the project at hand, which
+// they get from "goke completion --porcelain" every time.
+func WriteCompletionScript(out io.Writer, shell string, flags *flag.FlagSet, commands []string) error {
+	switch shell {
+	case "bash":
+		writeBashCompletion(out, flags, commands)
+	case "zsh":
+		writeZshCompletion(out, flags, commands)
+	case "fish":
+		writeFishCompletion(out, flags, commands)
+	default:
+		return fmt.Errorf("completion needs a shell, one of %s, got '%s'", strings.Join(CompletionShells, ", "), shell)
+	}
+
+	return nil
+}
+
+type completionFlag struct {
+	name string
+	desc string
+
+	// Whether the flag takes a value, ie. --config goke.yml.
+	value bool
+}
+
+// The flags as written on the command line, ie. -l and --list.
+func (f completionFlag) arg() string {
+	if len(f.name) == 1 {
+		return "-" + f.name
+	}
+
+	return "--" + f.name
+}
+
+func completionFlags(flags *flag.FlagSet) []completionFlag {
+	all := []completionFlag{}
+	flags.VisitAll(func(f *flag.Flag) {
+		b, ok := f.Value.(interface{ IsBoolFlag() bool })
+		all = append(all, completionFlag{name: f.Name, desc: flagSummary(f.Usage), value: !ok || !b.IsBoolFlag()})
+	})
+
+	return all
+}
+
+// The usage of the flag without its examples, which don't fit in a
+// completion menu.
+func flagSummary(usage string) string {
+	usage = strings.SplitN(usage, ", ie. ", 2)[0]
+
+	return strings.TrimSuffix(usage, ".")
+}
+
+func writeBashCompletion(out io.Writer, flags *flag.FlagSet, commands []string) {
+	args, valueArgs := []string{}, []string{}
+	for _, f := range completionFlags(flags) {
+		args = append(args, f.arg())
+		if f.value {
+			valueArgs = append(valueArgs, f.arg())
+		}
+	}
+
+	fmt.Fprintf(out, `# bash completion for goke, from "goke completion bash".
+_goke() {
+	local cur prev
+	if declare -F _get_comp_words_by_ref >/dev/null; then
+		_get_comp_words_by_ref -n : cur prev
+	else
+		cur="${COMP_WORDS[COMP_CWORD]}"
+		prev="${COMP_WORDS[COMP_CWORD-1]}"
+	fi
+
+	case "$prev" in
+		%s)
+			COMPREPLY=($(compgen -f -- "$cur"))
+			return
+			;;
+	esac
+
+	if [[ "$cur" == -* ]]; then
+		COMPREPLY=($(compgen -W "%s" -- "$cur"))
+		return
+	fi
+
+	local tasks
+	tasks="$(goke completion --porcelain 2>/dev/null | cut -f1)"
+	COMPREPLY=($(compgen -W "$tasks %s" -- "$cur"))
+
+	if declare -F __ltrim_colon_completions >/dev/null; then
+		__ltrim_colon_completions "$cur"
+	fi
+}
+
+complete -F _goke goke
+`, strings.Join(valueArgs, "|"), strings.Join(args, " "), strings.Join(commands, " "))
+}
+
+func writeZshCompletion(out io.Writer, flags *flag.FlagSet, commands []string) {
+	fmt.Fprint(out, `#compdef goke
+# zsh completion for goke, from "goke completion zsh".
+
+_goke() {
+	local -a flags value_flags tasks commands
+	local line name
+
+	flags=(
+`)
+	valueArgs := []string{}
+	for _, f := range completionFlags(flags) {
+		fmt.Fprintf(out, "\t\t%s\n", quoteArg(f.arg()+":"+f.desc))
+		if f.value {
+			valueArgs = append(valueArgs, f.arg())
+		}
+	}
+
+	fmt.Fprintf(out, `	)
+	value_flags=(%s)
+	commands=(%s)
+
+	if (( ${value_flags[(Ie)${words[CURRENT-1]}]} )); then
+		_files
+		return
+	fi
+
+	if [[ $PREFIX == -* ]]; then
+		_describe -t flags 'flag' flags
+		return
+	fi
+
+	for line in ${(f)"$(goke completion --porcelain 2>/dev/null)"}; do
+		name=${line%%%%$'\t'*}
+		if [[ $line == *$'\t'* ]]; then
+			tasks+=("${name//:/\\:}:${line#*$'\t'}")
+		else
+			tasks+=("${name//:/\\:}")
+		fi
+	done
+
+	_describe -t tasks 'task' tasks
+	_describe -t commands 'command' commands
+}
+
+if [[ "$funcstack[1]" == "_goke" ]]; then
+	_goke "$@"
+else
+	compdef _goke goke
+fi
+`, strings.Join(valueArgs, " "), strings.Join(commands, " "))
+}
+
+func writeFishCompletion(out io.Writer, flags *flag.FlagSet, commands []string) {
+	fmt.Fprint(out, `# fish completion for goke, from "goke completion fish".
+complete -c goke -f
+complete -c goke -a '(goke completion --porcelain 2>/dev/null)'
+`)
+
+	if len(commands) > 0 {
+		fmt.Fprintf(out, "complete -c goke -a %s -d command\n", quoteArg(strings.Join(commands, " ")))
+	}
+
+	for _, f := range completionFlags(flags) {
+		option := "-l " + f.name
+		switch {
+		case len(f.name) == 1:
+			option = "-s " + f.name
+		case len(f.name) == 2:
+			// Go flags like -vv, which fish only knows as old style options.
+			option = "-o " + f.name
+		}
+
+		if f.value {
+			option += " -r -F"
+		}
+
+		fmt.Fprintf(out, "complete -c goke %s -d %s\n", option, quoteArg(f.desc))
+	}
+}
@@ -0,0 +1,50 @@
+package internal
+
+import (
+	"bytes"
+	"flag"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func completionTestFlags() *flag.FlagSet {
+	var opts Options
+	flags := flag.NewFlagSet("goke", flag.ContinueOnError)
+	flags.BoolVar(&opts.List, "list", false, "Lists the available tasks with their descriptions")
+	flags.BoolVar(&opts.List, "l", false, "Shorthand for --list")
+	flags.StringVar(&opts.ConfigPath, "config", "", "Loads the given config instead of the goke.yml of the current directory, ie. --config ci/goke.yml")
+	flags.DurationVar(&opts.Debounce, "debounce", DefaultDebounce, "How long --watch waits for file changes to settle before rerunning the task")
+
+	return flags
+}
+
+func TestCompletionScripts(t *testing.T) {
+	t.Parallel()
+
+	var out bytes.Buffer
+	require.Nil(t, WriteCompletionScript(&out, "bash", completionTestFlags(), []string{"doctor", "fmt"}))
+	require.Contains(t, out.String(), "\t\t--config|--debounce)\n")
+	require.Contains(t, out.String(), `compgen -W "--config --debounce -l --list" -- "$cur"`)
+	require.Contains(t, out.String(), `compgen -W "$tasks doctor fmt" -- "$cur"`)
+
+	if bash, err := exec.LookPath("bash"); err == nil {
+		check := exec.Command(bash, "-n")
+		check.Stdin = &out
+		require.Nil(t, check.Run())
+	}
+
+	out.Reset()
+	require.Nil(t, WriteCompletionScript(&out, "zsh", completionTestFlags(), []string{"doctor"}))
+	require.Contains(t, out.String(), "\t\t'--debounce:How long --watch waits for file changes to settle before rerunning the task'\n")
+	require.Contains(t, out.String(), "\t\t'-l:Shorthand for --list'\n")
+	require.Contains(t, out.String(), "\tvalue_flags=(--config --debounce)\n")
+
+	out.Reset()
+	require.Nil(t, WriteCompletionScript(&out, "fish", completionTestFlags(), []string{"doctor"}))
+	require.Contains(t, out.String(), "complete -c goke -l config -r -F -d 'Loads the given config instead of the goke.yml of the current directory'\n")
+	require.Contains(t, out.String(), "complete -c goke -s l -d 'Shorthand for --list'\n")
+
+	require.EqualError(t, WriteCompletionScript(&out, "tcsh", completionTestFlags(), nil), "completion needs a shell, one of bash, zsh, fish, got 'tcsh'")
+}
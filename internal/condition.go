@@ -0,0 +1,496 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+func init() {
+	RegisterCapability("task.when")
+	RegisterCapability("task.if")
+	RegisterCapability("task.only_os")
+}
+
+// The conditions of "when" are expressions like
+//
+//	exists("go.mod") && (os() == "linux" || env("CI"))
+//
+// made of "strings", true, false, the functions below, ==, !=, !, && and ||,
+// from the tightest binding to the loosest, and parentheses. Strings are true
+// when not empty, env("NAME") is true when the variable is set, even if empty.
+var conditionFuncs = map[string]int{
+	"exists": 1,
+	"env":    1,
+	"os":     0,
+	"arch":   0,
+}
+
+// What the functions of a condition are evaluated against.
+type conditionEnv struct {
+	getenv func(name string) (string, bool)
+	exists func(path string) bool
+}
+
+// The result of evaluating a condition or a part of it.
+type conditionValue struct {
+	str   string
+	truth bool
+}
+
+func boolValue(b bool) conditionValue {
+	return conditionValue{str: strconv.FormatBool(b), truth: b}
+}
+
+// A parsed condition, see parseCondition.
+type condition interface {
+	eval(env conditionEnv) conditionValue
+}
+
+type (
+	literalCondition struct {
+		value conditionValue
+	}
+
+	callCondition struct {
+		name string
+		args []condition
+	}
+
+	notCondition struct {
+		operand condition
+	}
+
+	binaryCondition struct {
+		op          string
+		left, right condition
+	}
+)
+
+func (c literalCondition) eval(conditionEnv) conditionValue {
+	return c.value
+}
+
+func (c callCondition) eval(env conditionEnv) conditionValue {
+	switch c.name {
+	case "exists":
+		return boolValue(env.exists(c.args[0].eval(env).str))
+	case "env":
+		v, ok := env.getenv(c.args[0].eval(env).str)
+		return conditionValue{str: v, truth: ok}
+	case "os":
+		return conditionValue{str: runtime.GOOS, truth: true}
+	default:
+		return conditionValue{str: runtime.GOARCH, truth: true}
+	}
+}
+
+func (c notCondition) eval(env conditionEnv) conditionValue {
+	return boolValue(!c.operand.eval(env).truth)
+}
+
+func (c binaryCondition) eval(env conditionEnv) conditionValue {
+	switch c.op {
+	case "&&":
+		return boolValue(c.left.eval(env).truth && c.right.eval(env).truth)
+	case "||":
+		return boolValue(c.left.eval(env).truth || c.right.eval(env).truth)
+	case "==":
+		return boolValue(c.left.eval(env).str == c.right.eval(env).str)
+	default:
+		return boolValue(c.left.eval(env).str != c.right.eval(env).str)
+	}
+}
+
+// An invalid condition, with the column where the problem is, counted from 1.
+type ConditionError struct {
+	Column  int
+	Message string
+}
+
+func (e *ConditionError) Error() string {
+	return fmt.Sprintf("%s at column %d", e.Message, e.Column)
+}
+
+type conditionToken struct {
+	kind  string // "string", "ident", "end" or the operator itself
+	value string
+	pos   int
+}
+
+// Splits the expression into tokens, which know their column.
+func tokenizeCondition(expr string) ([]conditionToken, error) {
+	tokens := []conditionToken{}
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		pos := i + 1
+
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '"':
+			var b strings.Builder
+			i++
+			for ; i < len(runes) && runes[i] != '"'; i++ {
+				if runes[i] == '\\' && i+1 < len(runes) {
+					i++
+				}
+				b.WriteRune(runes[i])
+			}
+
+			if i == len(runes) {
+				return nil, &ConditionError{Column: pos, Message: "unterminated string"}
+			}
+
+			tokens = append(tokens, conditionToken{kind: "string", value: b.String(), pos: pos})
+			i++
+		case unicode.IsLetter(r) || r == '_':
+			start := i
+			for i < len(runes) && (unicode.IsLetter(runes[i]) || unicode.IsDigit(runes[i]) || runes[i] == '_') {
+				i++
+			}
+
+			tokens = append(tokens, conditionToken{kind: "ident", value: string(runes[start:i]), pos: pos})
+		default:
+			op := string(r)
+			if i+1 < len(runes) {
+				if two := string(runes[i : i+2]); two == "&&" || two == "||" || two == "==" || two == "!=" {
+					op = two
+				}
+			}
+
+			if !strings.Contains("()!,", op) && len(op) == 1 {
+				return nil, &ConditionError{Column: pos, Message: fmt.Sprintf("unexpected %q", op)}
+			}
+
+			tokens = append(tokens, conditionToken{kind: op, value: op, pos: pos})
+			i += len(op)
+		}
+	}
+
+	return append(tokens, conditionToken{kind: "end", pos: len(runes) + 1}), nil
+}
+
+// Parses the condition of "when", see conditionFuncs for its syntax.
+func parseCondition(expr string) (condition, error) {
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &conditionParser{tokens: tokens}
+	c, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != "end" {
+		return nil, p.unexpected(t)
+	}
+
+	return c, nil
+}
+
+type conditionParser struct {
+	tokens []conditionToken
+	i      int
+}
+
+func (p *conditionParser) peek() conditionToken {
+	return p.tokens[p.i]
+}
+
+func (p *conditionParser) next() conditionToken {
+	t := p.tokens[p.i]
+	if t.kind != "end" {
+		p.i++
+	}
+
+	return t
+}
+
+func (p *conditionParser) unexpected(t conditionToken) error {
+	if t.kind == "end" {
+		return &ConditionError{Column: t.pos, Message: "unexpected end of condition"}
+	}
+
+	return &ConditionError{Column: t.pos, Message: fmt.Sprintf("unexpected %q", t.value)}
+}
+
+func (p *conditionParser) or() (condition, error) {
+	return p.binary("||", p.and)
+}
+
+func (p *conditionParser) and() (condition, error) {
+	return p.binary("&&", p.comparison)
+}
+
+// Comparisons don't chain, ie. a == b == c is rejected.
+func (p *conditionParser) comparison() (condition, error) {
+	left, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+
+	if op := p.peek().kind; op == "==" || op == "!=" {
+		p.next()
+		right, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return binaryCondition{op: op, left: left, right: right}, nil
+	}
+
+	return left, nil
+}
+
+// Parses operands joined by the left-associative operator.
+func (p *conditionParser) binary(op string, operand func() (condition, error)) (condition, error) {
+	left, err := operand()
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		if p.peek().kind != op {
+			return left, nil
+		}
+
+		p.next()
+		right, err := operand()
+		if err != nil {
+			return nil, err
+		}
+
+		left = binaryCondition{op: op, left: left, right: right}
+	}
+}
+
+func (p *conditionParser) unary() (condition, error) {
+	if p.peek().kind == "!" {
+		p.next()
+		operand, err := p.unary()
+		if err != nil {
+			return nil, err
+		}
+
+		return notCondition{operand: operand}, nil
+	}
+
+	return p.primary()
+}
+
+func (p *conditionParser) primary() (condition, error) {
+	t := p.next()
+
+	switch t.kind {
+	case "string":
+		return literalCondition{value: conditionValue{str: t.value, truth: t.value != ""}}, nil
+	case "(":
+		c, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+
+		if closing := p.next(); closing.kind != ")" {
+			return nil, p.unexpected(closing)
+		}
+
+		return c, nil
+	case "ident":
+		if t.value == "true" || t.value == "false" {
+			return literalCondition{value: boolValue(t.value == "true")}, nil
+		}
+
+		return p.call(t)
+	}
+
+	return nil, p.unexpected(t)
+}
+
+func (p *conditionParser) call(name conditionToken) (condition, error) {
+	arity, ok := conditionFuncs[name.value]
+	if !ok {
+		return nil, &ConditionError{Column: name.pos, Message: fmt.Sprintf("unknown function %s", name.value)}
+	}
+
+	if t := p.next(); t.kind != "(" {
+		return nil, p.unexpected(t)
+	}
+
+	args := []condition{}
+	for p.peek().kind != ")" {
+		if len(args) > 0 {
+			if t := p.next(); t.kind != "," {
+				return nil, p.unexpected(t)
+			}
+		}
+
+		arg, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+	p.next()
+
+	if len(args) != arity {
+		return nil, &ConditionError{Column: name.pos, Message: fmt.Sprintf("%s() takes %d argument(s), got %d", name.value, arity, len(args))}
+	}
+
+	return callCondition{name: name.value, args: args}, nil
+}
+
+// The values of GOOS, which only_os accepts.
+var knownOS = []string{
+	"aix", "android", "darwin", "dragonfly", "freebsd", "illumos", "ios", "js",
+	"linux", "netbsd", "openbsd", "plan9", "solaris", "wasip1", "windows",
+}
+
+// Fails unless only_os lists systems goke runs on, ie. "darwin", not "macos".
+func validateOnlyOS(taskName string, systems []string) error {
+	for _, system := range systems {
+		if !containsString(knownOS, system) {
+			return fmt.Errorf("task '%s': only_os: unknown system '%s', expected one of %s", taskName, system, strings.Join(knownOS, ", "))
+		}
+	}
+
+	return nil
+}
+
+// Whether the conditions of the task hold, which tasks without any always
+// do: its "when", then only_os, if and unless, stopping at the first one
+// which doesn't. env() sees the variables of the task, then the ones of goke,
+// and exists() paths are relative to goke.yml. Skipped tasks are reported,
+// and with --dry-run, the result of each condition is printed.
+func (e *Executor) conditionHolds(task Task) (bool, error) {
+	checks := []func(Task) (bool, string, error){e.whenHolds, onlyOSHolds, e.ifHolds, e.unlessHolds}
+
+	for _, check := range checks {
+		holds, desc, err := check(task)
+		if err != nil {
+			return false, err
+		}
+
+		switch {
+		case desc == "":
+			continue
+		case e.options.DryRun && holds:
+			e.printDryRun("%s: %s", task.Name, desc)
+		case e.options.DryRun:
+			e.printDryRun("%s: would skip: %s", task.Name, desc)
+		case !holds:
+			e.reportConditionSkip(task, desc)
+		}
+
+		if !holds {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// Prints that the task is skipped, unless running quietly.
+func (e *Executor) reportConditionSkip(task Task, desc string) {
+	if e.options.Quiet {
+		return
+	}
+
+	stdout, _ := e.outputWriters(nil)
+	_, _ = stdout.Write([]byte(fmt.Sprintf("Skipped %s: condition not met, %s\n", task.Name, desc)))
+}
+
+// Evaluates the "when" of the task. Like the other conditions, it returns
+// the description of the result, which is empty when the task has none.
+func (e *Executor) whenHolds(task Task) (bool, string, error) {
+	if task.When == "" {
+		return true, "", nil
+	}
+
+	c, err := parseCondition(task.When)
+	if err != nil {
+		return false, "", fmt.Errorf("task '%s': when: %w", task.Name, err)
+	}
+
+	holds := c.eval(conditionEnv{
+		getenv: e.envResolver(task).Lookup,
+		exists: func(path string) bool {
+			_, err := e.parser.fs.Stat(joinDir(filepath.Dir(e.parser.configFile()), path))
+			return err == nil
+		},
+	}).truth
+
+	return holds, fmt.Sprintf("when %s is %t", task.When, holds), nil
+}
+
+// Checks the only_os of the task against the system goke runs on.
+func onlyOSHolds(task Task) (bool, string, error) {
+	if len(task.OnlyOS) == 0 {
+		return true, "", nil
+	}
+
+	if containsString(task.OnlyOS, runtime.GOOS) {
+		return true, fmt.Sprintf("only_os %s includes %s", strings.Join(task.OnlyOS, ", "), runtime.GOOS), nil
+	}
+
+	return false, fmt.Sprintf("only_os %s doesn't include %s", strings.Join(task.OnlyOS, ", "), runtime.GOOS), nil
+}
+
+// Checks that the "if" command of the task succeeds.
+func (e *Executor) ifHolds(task Task) (bool, string, error) {
+	if task.If == "" {
+		return true, "", nil
+	}
+
+	ok, err := e.conditionCommandSucceeds(task, task.If)
+	if ok {
+		return true, fmt.Sprintf("if %s succeeded", task.If), err
+	}
+
+	return false, fmt.Sprintf("if %s failed", task.If), err
+}
+
+// Checks that the "unless" command of the task fails.
+func (e *Executor) unlessHolds(task Task) (bool, string, error) {
+	if task.Unless == "" {
+		return true, "", nil
+	}
+
+	ok, err := e.conditionCommandSucceeds(task, task.Unless)
+	if ok {
+		return false, fmt.Sprintf("unless %s succeeded", task.Unless), err
+	}
+
+	return true, fmt.Sprintf("unless %s failed", task.Unless), err
+}
+
+// Whether the command of a condition exits with 0, also with --dry-run. It
+// runs like the commands of the task, with its variables and in its
+// directory, but without retries or hooks, and its output is discarded.
+// Commands which can't start count as failed.
+func (e *Executor) conditionCommandSucceeds(task Task, command string) (bool, error) {
+	entry := e.parser.hookEntry(command)
+	entry.Dir = task.Dir
+	entry.shell = e.parser.usesShell(task)
+	entry.timeout = e.parser.commandTimeout(task)
+	entry.task = task.Name
+
+	p, err := e.prepareCommand(entry, e.taskEnv(task))
+	if err != nil {
+		return false, fmt.Errorf("task '%s': %w", task.Name, err)
+	}
+
+	if p.builtin != nil {
+		return p.builtin(p.args, entry.Dir, io.Discard) == nil, nil
+	}
+
+	return e.runFor(p.cmd, entry.timeout) == nil, nil
+}
@@ -0,0 +1,218 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var testConditionEnv = conditionEnv{
+	getenv: func(name string) (string, bool) {
+		vars := map[string]string{"CI": "true", "EMPTY": "", "STAGE": "prod"}
+		v, ok := vars[name]
+		return v, ok
+	},
+	exists: func(path string) bool {
+		return path == "go.mod"
+	},
+}
+
+func evalCondition(t *testing.T, expr string) bool {
+	c, err := parseCondition(expr)
+	require.Nil(t, err, expr)
+
+	return c.eval(testConditionEnv).truth
+}
+
+func TestConditions(t *testing.T) {
+	cases := map[string]bool{
+		`true`:                               true,
+		`false`:                              false,
+		`exists("go.mod")`:                   true,
+		`exists("missing.txt")`:              false,
+		`!exists("missing.txt")`:             true,
+		`env("CI")`:                          true,
+		`env("EMPTY")`:                       true,
+		`env("UNSET")`:                       false,
+		`env("UNSET") == ""`:                 true,
+		`env("STAGE") == "prod"`:             true,
+		`env("STAGE") != "prod"`:             false,
+		`os() == "` + runtime.GOOS + `"`:     true,
+		`arch() == "` + runtime.GOARCH + `"`: true,
+		`""`:                                 false,
+		`"x"`:                                true,
+		`exists(env("MODFILE"))`:             false,
+		`"a\"b" == "a\"b"`:                   true,
+	}
+
+	for expr, expected := range cases {
+		require.Equal(t, expected, evalCondition(t, expr), expr)
+	}
+}
+
+func TestConditionPrecedence(t *testing.T) {
+	// && binds tighter than ||.
+	require.True(t, evalCondition(t, `true || false && false`))
+	require.False(t, evalCondition(t, `(true || false) && false`))
+
+	// ! binds tighter than == and &&.
+	require.True(t, evalCondition(t, `!false && true`))
+	require.False(t, evalCondition(t, `!(false || true)`))
+	require.True(t, evalCondition(t, `!env("UNSET") == "true"`))
+
+	// == binds tighter than &&.
+	require.True(t, evalCondition(t, `env("CI") == "true" && env("STAGE") == "prod"`))
+	require.False(t, evalCondition(t, `env("CI") == "true" && env("STAGE") == "dev" || env("UNSET")`))
+}
+
+func TestConditionErrors(t *testing.T) {
+	cases := map[string]string{
+		`exists("go.mod"`:        `unexpected end of condition at column 16`,
+		`exists("go.mod") &&`:    `unexpected end of condition at column 20`,
+		`env("CI") = "true"`:     `unexpected "=" at column 11`,
+		`os() == "linux`:         `unterminated string at column 9`,
+		`platform() == "linux"`:  `unknown function platform at column 1`,
+		`exists()`:               `exists() takes 1 argument(s), got 0 at column 1`,
+		`true false`:             `unexpected "false" at column 6`,
+		`env("A") == "a" == "b"`: `unexpected "==" at column 17`,
+		`(true || false))`:       `unexpected ")" at column 16`,
+		``:                       `unexpected end of condition at column 1`,
+	}
+
+	for expr, message := range cases {
+		_, err := parseCondition(expr)
+		var condErr *ConditionError
+		require.ErrorAs(t, err, &condErr, expr)
+		require.EqualError(t, err, message, expr)
+	}
+}
+
+func TestTasksOnlyRunWhenTheirConditionHolds(t *testing.T) {
+	env := NewInMemoryEnv(`
+global:
+  environment:
+    STAGE: prod
+
+build:
+  when: exists("go.mod") && env("STAGE") == "prod"
+  run:
+    - "go build"
+
+release:
+  when: env("GOKE_TEST_UNSET_VARIABLE")
+  run:
+    - "goreleaser"
+
+all:
+  run:
+    - build
+    - release
+`)
+	env.FS.WriteFile("/work/go.mod", []byte("module x"), 0644)
+
+	require.Nil(t, env.Run("all"))
+	require.Equal(t, []string{"go build"}, recordedCommands(env))
+
+	require.Nil(t, env.Run("release"))
+	require.Equal(t, []string{"go build"}, recordedCommands(env))
+
+	out := stubDryRunOutput(t)
+	env.Options.DryRun = true
+	require.Nil(t, env.Run("all"))
+	require.Equal(t, `all:
+  build: when exists("go.mod") && env("STAGE") == "prod" is true
+  build:
+    go build
+  release: would skip: when env("GOKE_TEST_UNSET_VARIABLE") is false
+`, out.String())
+}
+
+func TestInvalidConditionsAreRejectedWhenParsing(t *testing.T) {
+	env := NewInMemoryEnv("build:\n  when: exists(\"go.mod\") &&\n  run: [\"go build\"]\n")
+
+	_, err := env.Parse()
+	require.EqualError(t, err, "task 'build': when: unexpected end of condition at column 20")
+}
+
+func TestIfAndUnlessConditions(t *testing.T) {
+	env := NewInMemoryEnv(`
+migrate:
+  if: "test -f .env"
+  retries: 2
+  run:
+    - "migrate up"
+
+image:
+  unless: "docker image inspect $IMAGE"
+  env:
+    IMAGE: api
+  run:
+    - "docker build -t $IMAGE ."
+
+deploy:
+  deps: [migrate, image]
+  run:
+    - "kubectl apply -f k8s"
+`)
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if cmd.Args[0] == "test" {
+			return errors.New("exit status 1")
+		}
+
+		return nil
+	}
+
+	// The skipped dependencies don't keep the task from running, and the
+	// failed condition isn't retried.
+	require.Nil(t, env.Run("deploy"))
+	require.Equal(t, []string{"test -f .env", "docker image inspect api", "kubectl apply -f k8s"}, recordedCommands(env))
+
+	out := stubDryRunOutput(t)
+	env.Options.DryRun = true
+	require.Nil(t, env.Run("migrate", "image"))
+	require.Equal(t, `migrate: would skip: if test -f .env failed
+image: would skip: unless docker image inspect $IMAGE succeeded
+`, out.String())
+}
+
+func TestOnlyOS(t *testing.T) {
+	other := "windows"
+	if runtime.GOOS == other {
+		other = "linux"
+	}
+
+	env := NewInMemoryEnv(fmt.Sprintf(`
+native:
+  only_os: [%s]
+  run:
+    - "make native"
+
+foreign:
+  only_os: [%s]
+  run:
+    - "make foreign"
+`, runtime.GOOS, other))
+
+	require.Nil(t, env.Run("native"))
+	require.Nil(t, env.Run("foreign"))
+	require.Equal(t, []string{"make native"}, recordedCommands(env))
+
+	_, err := NewInMemoryEnv("build:\n  only_os: [macos]\n  run: [make]\n").Parse()
+	require.ErrorContains(t, err, "task 'build': only_os: unknown system 'macos', expected one of aix, android, darwin")
+}
+
+func TestSkippedTasksAreReported(t *testing.T) {
+	env := NewInMemoryEnv("lint:\n  if: \"test -f .golangci.yml\"\n  run: [golangci-lint run]\n")
+	env.Options.Quiet = false
+	env.Runner.Handler = func(cmd *exec.Cmd) error { return errors.New("exit status 1") }
+
+	out := captureTerminal(t, func() {
+		e := newCaptureExecutor(t, env)
+		require.Nil(t, e.Start([]string{"lint"}))
+	})
+	require.Contains(t, out, "Skipped lint: condition not met, if test -f .golangci.yml failed\n")
+}
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"time"
+
+	"github.com/theckman/yacspin"
+)
+
+func init() {
+	RegisterCapability("crash.recovery")
+}
+
+// Exit code used when goke crashes because of an internal error.
+const ExitCodeInternalError = 70
+
+// Stubbed in tests, so that crashes can be asserted without exiting.
+var (
+	crashExit             = os.Exit
+	crashDir              = StateDir
+	crashOutput io.Writer = os.Stderr
+)
+
+// Written at the top of crash files, once known. See SetCrashMetadata.
+var crashMetadata *RunMetadata
+
+// Sets the metadata of the current run, which crash files start with.
+func SetCrashMetadata(m RunMetadata) {
+	crashMetadata = &m
+}
+
+// RecoverPanic converts a panic into a concise error message, writes the
+// full stack trace to a crash file and exits with ExitCodeInternalError.
+// It must be deferred directly and it is disabled when GOKE_DEBUG=1,
+// so that developers still get the raw stack trace.
+func RecoverPanic() {
+	if os.Getenv("GOKE_DEBUG") == "1" {
+		return
+	}
+
+	if r := recover(); r != nil {
+		handlePanic(r, debug.Stack(), nil)
+	}
+}
+
+// Same as RecoverPanic, but it also stops the executor's spinner
+// so that the terminal is not left in a bad state.
+func (e *Executor) RecoverPanic() {
+	if os.Getenv("GOKE_DEBUG") == "1" {
+		return
+	}
+
+	if r := recover(); r != nil {
+		handlePanic(r, debug.Stack(), e.spinner)
+	}
+}
+
+// Reports the recovered panic to the user and terminates goke.
+func handlePanic(value any, stack []byte, spinner *yacspin.Spinner) {
+	if spinner != nil {
+		spinner.StopFailMessage("Internal error")
+		_ = spinner.StopFail()
+	}
+
+	fmt.Fprintf(crashOutput, "goke: internal error: %v\n", value)
+
+	crashFile, err := writeCrashFile(value, stack)
+	if err == nil {
+		fmt.Fprintf(crashOutput, "The full stack trace was written to %s\n", crashFile)
+	}
+
+	crashExit(ExitCodeInternalError)
+}
+
+// Writes the panic value and its stack trace into a new crash file,
+// after the metadata of the run.
+func writeCrashFile(value any, stack []byte) (string, error) {
+	dir, err := crashDir()
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("crash-%s.log", time.Now().Format("20060102-150405.000000000"))
+	crashFile := filepath.Join(dir, name)
+	contents := fmt.Sprintf("panic: %v\n\n%s", value, stack)
+	if crashMetadata != nil {
+		contents = crashMetadata.header() + "\n" + contents
+	}
+
+	return crashFile, os.WriteFile(crashFile, []byte(contents), 0644)
+}
@@ -0,0 +1,81 @@
+package internal
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stubCrashHandling(t *testing.T) (string, *int) {
+	dir := t.TempDir()
+	code := -1
+
+	origExit, origDir, origOutput := crashExit, crashDir, crashOutput
+	crashExit = func(c int) { code = c }
+	crashDir = func() (string, error) { return dir, nil }
+	crashOutput = io.Discard
+
+	t.Cleanup(func() {
+		crashExit, crashDir, crashOutput = origExit, origDir, origOutput
+	})
+
+	return dir, &code
+}
+
+func TestRecoverPanicWritesCrashFile(t *testing.T) {
+	dir, code := stubCrashHandling(t)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+		defer RecoverPanic()
+
+		var files []string
+		_ = files[1]
+	}()
+
+	<-done
+
+	require.Equal(t, ExitCodeInternalError, *code)
+
+	crashFiles, _ := filepath.Glob(filepath.Join(dir, "crash-*.log"))
+	require.Len(t, crashFiles, 1)
+
+	contents, err := os.ReadFile(crashFiles[0])
+	require.Nil(t, err)
+	require.Contains(t, string(contents), "index out of range")
+	require.Contains(t, string(contents), "goroutine")
+}
+
+func TestExecutorRecoverPanicWithoutSpinner(t *testing.T) {
+	_, code := stubCrashHandling(t)
+	e := Executor{options: Options{Quiet: true}}
+
+	func() {
+		defer e.RecoverPanic()
+		panic("boom")
+	}()
+
+	require.Equal(t, ExitCodeInternalError, *code)
+}
+
+func TestRecoverPanicDisabledInDebugMode(t *testing.T) {
+	dir, code := stubCrashHandling(t)
+	t.Setenv("GOKE_DEBUG", "1")
+
+	var recovered any
+	func() {
+		defer func() { recovered = recover() }()
+		defer RecoverPanic()
+		panic("boom")
+	}()
+
+	crashFiles, _ := filepath.Glob(filepath.Join(dir, "crash-*.log"))
+
+	require.Equal(t, "boom", recovered)
+	require.Equal(t, -1, *code)
+	require.Empty(t, crashFiles)
+}
@@ -0,0 +1,256 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterCapability("run.deadline")
+}
+
+const (
+	// The exit status of a run which didn't finish within --deadline,
+	// EX_TEMPFAIL of sysexits.h, so that it tells apart from a command
+	// which timed out, see ExitCodeTimeout.
+	ExitCodeDeadlineExceeded = 75
+
+	// The budget reserved for the after_each_task events of the task which
+	// was cut off by --deadline: a tenth of the deadline, at most this much.
+	maxDeadlineReserve = 30 * time.Second
+)
+
+// Returned to commands started after the deadline cut the run off.
+var errDeadlineCutOff = errors.New("the deadline expired")
+
+// Calls f once the duration passed, and returns a function cancelling it.
+// Replaced in tests with a fake clock.
+type timerFunc func(d time.Duration, f func()) (stop func())
+
+func newRealTimer(d time.Duration, f func()) func() {
+	timer := time.AfterFunc(d, f)
+	return func() { timer.Stop() }
+}
+
+// The --deadline of the whole invocation. Once the deadline minus the
+// reserve is reached, the running commands are stopped, SIGTERM first, and
+// no new ones start. The after_each_task events of the task which was cut
+// off then get the reserve to clean up, see Executor.runCleanupEvents.
+// Its methods are no-ops on a nil deadline, ie. without --deadline.
+type runDeadline struct {
+	total    time.Duration
+	at       time.Time
+	reserve  time.Duration
+	now      func() time.Time
+	newTimer timerFunc
+
+	mu        sync.Mutex
+	expired   bool
+	cleanedUp bool
+	groups    map[*processes]bool
+	stopTimer func()
+}
+
+// Starts the deadline, which expires total from now.
+func newRunDeadline(total time.Duration, now func() time.Time, newTimer timerFunc) *runDeadline {
+	reserve := total / 10
+	if reserve > maxDeadlineReserve {
+		reserve = maxDeadlineReserve
+	}
+
+	d := &runDeadline{total: total, at: now().Add(total), now: now, newTimer: newTimer}
+	d.start(total-reserve, reserve)
+	return d
+}
+
+func (d *runDeadline) start(cutoff time.Duration, reserve time.Duration) {
+	d.reserve = reserve
+	d.groups = make(map[*processes]bool)
+	d.stopTimer = d.newTimer(cutoff, d.expire)
+}
+
+// How long until the deadline of the invocation.
+func (d *runDeadline) remaining() time.Duration {
+	return d.at.Sub(d.now())
+}
+
+// Stops the running commands, giving them at most the reserve to exit.
+func (d *runDeadline) expire() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expired = true
+	for group := range d.groups {
+		go group.stop(d.grace())
+	}
+}
+
+// How long stopped commands get to exit before they are killed.
+func (d *runDeadline) grace() time.Duration {
+	if d.reserve > 0 && d.reserve < restartGracePeriod {
+		return d.reserve
+	}
+
+	return restartGracePeriod
+}
+
+// Whether the deadline cut the run off.
+func (d *runDeadline) hasExpired() bool {
+	if d == nil {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.expired
+}
+
+// Fails once the deadline cut the run off, so that no new command starts.
+func (d *runDeadline) check() error {
+	if d.hasExpired() {
+		return errDeadlineCutOff
+	}
+
+	return nil
+}
+
+// Stops the processes of the group when the deadline expires, until the
+// returned function is called.
+func (d *runDeadline) track(group *processes) func() {
+	if d == nil {
+		return func() {}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.expired {
+		go group.stop(d.grace())
+		return func() {}
+	}
+
+	d.groups[group] = true
+	return func() {
+		d.mu.Lock()
+		delete(d.groups, group)
+		d.mu.Unlock()
+	}
+}
+
+// Stops the timer of the deadline, once the run is over.
+func (d *runDeadline) stop() {
+	if d != nil {
+		d.stopTimer()
+	}
+}
+
+// The deadline of the cleanup after the run was cut off, which expires at
+// the deadline of the invocation, so the events get the reserved budget.
+// Only the first caller gets it, so that the events of a task run once, not
+// again for the tasks depending on it.
+func (d *runDeadline) cleanup() (*runDeadline, bool) {
+	if d == nil {
+		return nil, false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if !d.expired || d.cleanedUp {
+		return nil, false
+	}
+	d.cleanedUp = true
+
+	c := &runDeadline{total: d.total, at: d.at, now: d.now, newTimer: d.newTimer}
+	c.start(d.remaining(), 0)
+	return c, true
+}
+
+// The run didn't finish within --deadline. Lists the given tasks which
+// completed, the one which was cut off and the ones which never started.
+type DeadlineExceededError struct {
+	Deadline   time.Duration
+	Completed  []string
+	CutOff     string
+	NotStarted []string
+	Err        error
+}
+
+func (e *DeadlineExceededError) Error() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "deadline of %s exceeded", e.Deadline)
+
+	if len(e.Completed) > 0 {
+		fmt.Fprintf(&b, "\n  completed: %s", strings.Join(e.Completed, ", "))
+	}
+
+	if e.CutOff != "" {
+		fmt.Fprintf(&b, "\n  cut off: %s", e.CutOff)
+	}
+
+	if len(e.NotStarted) > 0 {
+		fmt.Fprintf(&b, "\n  not started: %s", strings.Join(e.NotStarted, ", "))
+	}
+
+	return b.String()
+}
+
+func (e *DeadlineExceededError) Unwrap() error {
+	return e.Err
+}
+
+// Turns the failure of the task at i into a DeadlineExceededError when the
+// deadline caused it.
+func (e *Executor) deadlineExceeded(err error, taskNames []string, i int) error {
+	var exceeded *DeadlineExceededError
+	if e.deadline == nil || errors.As(err, &exceeded) {
+		return err
+	}
+
+	if !e.deadline.hasExpired() && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+
+	exceeded = &DeadlineExceededError{Deadline: e.deadline.total, Completed: taskNames[:i], Err: err}
+	if i < len(taskNames) {
+		exceeded.CutOff = taskNames[i]
+		exceeded.NotStarted = taskNames[i+1:]
+	}
+
+	return exceeded
+}
+
+// Runs the after_each_task events of the task which was cut off by the
+// deadline, within the reserved budget. Their failures are only reported.
+func (e *Executor) runCleanupEvents(task Task, env map[string]string) {
+	cleanup, ok := e.deadline.cleanup()
+	if !ok {
+		return
+	}
+	defer cleanup.stop()
+
+	c := *e
+	c.deadline = cleanup
+	c.progress = nil
+	outputs := make(chan Ref[string])
+
+	for _, ev := range e.taskEvents(task).AfterEachTask {
+		if !ev.appliesTo(task) {
+			continue
+		}
+
+		entry := e.parser.hookEntry(ev.Cmd)
+		entry.task = task.Name
+
+		if err := c.runSysOrRecurse(entry, env, &outputs); err != nil && !e.options.Quiet {
+			message, _, _ := strings.Cut(err.Error(), "\n")
+			fmt.Fprintf(os.Stderr, "Cleanup of '%s' failed: %s\n", task.Name, message)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A clock whose timers only fire when the test fires them.
+type fakeDeadlineClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []fakeDeadlineTimer
+}
+
+type fakeDeadlineTimer struct {
+	d time.Duration
+	f func()
+}
+
+func (c *fakeDeadlineClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+func (c *fakeDeadlineClock) newTimer(d time.Duration, f func()) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.timers = append(c.timers, fakeDeadlineTimer{d: d, f: f})
+	return func() {}
+}
+
+// Moves the clock to the end of the timer and fires it.
+func (c *fakeDeadlineClock) fire(i int, started time.Time) {
+	c.mu.Lock()
+	timer := c.timers[i]
+	c.now = started.Add(timer.d)
+	c.mu.Unlock()
+
+	timer.f()
+}
+
+const deadlineConfig = `
+global:
+  events:
+    after_each_task:
+      - "cleanup one"
+      - "cleanup two"
+
+build:
+  run:
+    - "go build"
+
+test:
+  run:
+    - "go test"
+    - "go vet"
+
+deploy:
+  run:
+    - "deploy"
+`
+
+// Runs the tasks with a deadline of 25m on a fake clock. The handler may
+// fire the timers of the clock while a command runs.
+func runWithDeadline(t *testing.T, handler func(clock *fakeDeadlineClock, started time.Time, cmd string) error, taskNames ...string) (*InMemoryEnv, *fakeDeadlineClock, error) {
+	env := NewInMemoryEnv(deadlineConfig)
+	env.Options.Deadline = 25 * time.Minute
+
+	started := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeDeadlineClock{now: started}
+
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		return handler(clock, started, strings.Join(cmd.Args, " "))
+	}
+
+	p, err := env.Parse()
+	require.NoError(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	e.deadline = newRunDeadline(env.Options.Deadline, clock.Now, clock.newTimer)
+
+	return env, clock, e.StartContext(context.Background(), taskNames)
+}
+
+func TestNewRunDeadlineReservesCleanupBudget(t *testing.T) {
+	clock := &fakeDeadlineClock{}
+
+	newRunDeadline(time.Minute, clock.Now, clock.newTimer)
+	newRunDeadline(25*time.Minute, clock.Now, clock.newTimer)
+
+	require.Equal(t, 54*time.Second, clock.timers[0].d)
+	require.Equal(t, 25*time.Minute-maxDeadlineReserve, clock.timers[1].d)
+}
+
+func TestDeadlineCutsOffTheRun(t *testing.T) {
+	env, clock, err := runWithDeadline(t, func(clock *fakeDeadlineClock, started time.Time, cmd string) error {
+		if cmd == "go test" {
+			clock.fire(0, started)
+			return errors.New("signal: terminated")
+		}
+
+		return nil
+	}, "build", "test", "deploy")
+
+	var exceeded *DeadlineExceededError
+	require.ErrorAs(t, err, &exceeded)
+	require.Equal(t, []string{"build"}, exceeded.Completed)
+	require.Equal(t, "test", exceeded.CutOff)
+	require.Equal(t, []string{"deploy"}, exceeded.NotStarted)
+	require.Equal(t, 75, ExitCode(err))
+	require.NotEqual(t, ExitCodeTimeout, ExitCode(err))
+	require.Equal(t, "deadline of 25m0s exceeded\n  completed: build\n  cut off: test\n  not started: deploy", err.Error())
+
+	// The cleanup of test runs once, within the reserve, and nothing else
+	// starts after the cutoff.
+	require.Equal(t, []string{
+		"go build", "cleanup one", "cleanup two",
+		"go test", "cleanup one", "cleanup two",
+	}, recordedCommands(env))
+
+	require.Len(t, clock.timers, 2)
+	require.Equal(t, maxDeadlineReserve, clock.timers[1].d)
+}
+
+func TestDeadlineBoundsTheCleanup(t *testing.T) {
+	env, _, err := runWithDeadline(t, func(clock *fakeDeadlineClock, started time.Time, cmd string) error {
+		switch {
+		case cmd == "go test":
+			clock.fire(0, started)
+			return errors.New("signal: terminated")
+		case cmd == "cleanup one" && len(clock.timers) > 1:
+			clock.fire(1, clock.Now())
+		}
+
+		return nil
+	}, "test")
+
+	require.Equal(t, ExitCodeDeadlineExceeded, ExitCode(err))
+	require.Equal(t, []string{"go test", "cleanup one"}, recordedCommands(env))
+}
+
+func TestDeadlineWithoutExpiry(t *testing.T) {
+	env, _, err := runWithDeadline(t, func(*fakeDeadlineClock, time.Time, string) error { return nil }, "build")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"go build", "cleanup one", "cleanup two"}, recordedCommands(env))
+}
@@ -0,0 +1,214 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fatih/color"
+)
+
+var (
+	diffHeaderColor  = color.New(color.Bold)
+	diffHunkColor    = color.New(color.FgCyan)
+	diffAddedColor   = color.New(color.FgGreen)
+	diffRemovedColor = color.New(color.FgRed)
+	diffFileHeaders  = []string{"diff ", "--- ", "+++ ", "index "}
+)
+
+// Renders the output of a command as a colored unified diff: additions in
+// green, deletions in red and file headers in bold. Any noise before the first
+// "---"/"+++" header pair is kept as is. When colors are disabled (ie. stdout
+// is not a terminal) or the output is not a diff, it is returned unchanged.
+func colorizeDiff(out string) string {
+	if color.NoColor {
+		return out
+	}
+
+	lines := strings.Split(out, "\n")
+	start := diffStart(lines)
+
+	if start == -1 {
+		return out
+	}
+
+	for i := start; i < len(lines); i++ {
+		lines[i] = colorizeDiffLine(lines[i])
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// Returns the index of the line where the diff begins, or -1 if there is none.
+// A "diff ..." line right before the first header pair belongs to the diff.
+func diffStart(lines []string) int {
+	for i := 0; i+1 < len(lines); i++ {
+		if !strings.HasPrefix(lines[i], "--- ") || !strings.HasPrefix(lines[i+1], "+++ ") {
+			continue
+		}
+
+		if i > 0 && strings.HasPrefix(lines[i-1], "diff ") {
+			return i - 1
+		}
+
+		return i
+	}
+
+	return -1
+}
+
+func colorizeDiffLine(line string) string {
+	for _, prefix := range diffFileHeaders {
+		if strings.HasPrefix(line, prefix) {
+			return diffHeaderColor.Sprint(line)
+		}
+	}
+
+	switch {
+	case strings.HasPrefix(line, "@@"):
+		return diffHunkColor.Sprint(line)
+	case strings.HasPrefix(line, "+"):
+		return diffAddedColor.Sprint(line)
+	case strings.HasPrefix(line, "-"):
+		return diffRemovedColor.Sprint(line)
+	}
+
+	return line
+}
+
+// The lines of context around the changes of unifiedDiff.
+const diffContext = 3
+
+type diffOp struct {
+	kind byte
+	line string
+
+	// The index of the line in the old and the new text, or where it would
+	// be for lines only in the other one.
+	old, new int
+}
+
+// Returns the unified diff of the lines of both texts, empty when they are
+// equal. Meant for small files like configs, since it compares all lines of
+// both with each other.
+func unifiedDiff(oldName string, newName string, oldText string, newText string) string {
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	var changes []int
+	for i, op := range ops {
+		if op.kind != ' ' {
+			changes = append(changes, i)
+		}
+	}
+
+	if len(changes) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- %s\n+++ %s\n", oldName, newName)
+
+	for i := 0; i < len(changes); {
+		start := changes[i] - diffContext
+		if start < 0 {
+			start = 0
+		}
+
+		// Changes whose context overlaps belong to the same hunk.
+		j := i
+		for j+1 < len(changes) && changes[j+1]-changes[j] <= 2*diffContext {
+			j++
+		}
+
+		end := changes[j] + diffContext + 1
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		writeHunk(&b, ops[start:end])
+		i = j + 1
+	}
+
+	return b.String()
+}
+
+func writeHunk(b *strings.Builder, ops []diffOp) {
+	var oldCount, newCount int
+	for _, op := range ops {
+		if op.kind != '+' {
+			oldCount++
+		}
+		if op.kind != '-' {
+			newCount++
+		}
+	}
+
+	fmt.Fprintf(b, "@@ -%s +%s @@\n", hunkRange(ops[0].old, oldCount), hunkRange(ops[0].new, newCount))
+	for _, op := range ops {
+		fmt.Fprintf(b, "%c%s\n", op.kind, op.line)
+	}
+}
+
+// The range of a hunk, 1-based like diff -u, which gives the line before
+// the hunk when it's empty.
+func hunkRange(start int, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+
+	if count == 1 {
+		return fmt.Sprint(start + 1)
+	}
+
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// Turns the old lines into the new ones, keeping their longest common
+// subsequence.
+func diffLines(oldLines []string, newLines []string) []diffOp {
+	// common[i][j] is the length of the longest common subsequence of
+	// oldLines[i:] and newLines[j:].
+	common := make([][]int, len(oldLines)+1)
+	for i := range common {
+		common[i] = make([]int, len(newLines)+1)
+	}
+
+	for i := len(oldLines) - 1; i >= 0; i-- {
+		for j := len(newLines) - 1; j >= 0; j-- {
+			switch {
+			case oldLines[i] == newLines[j]:
+				common[i][j] = common[i+1][j+1] + 1
+			case common[i+1][j] >= common[i][j+1]:
+				common[i][j] = common[i+1][j]
+			default:
+				common[i][j] = common[i][j+1]
+			}
+		}
+	}
+
+	var ops []diffOp
+	i, j := 0, 0
+	for i < len(oldLines) || j < len(newLines) {
+		switch {
+		case i < len(oldLines) && j < len(newLines) && oldLines[i] == newLines[j]:
+			ops = append(ops, diffOp{kind: ' ', line: oldLines[i], old: i, new: j})
+			i++
+			j++
+		case j == len(newLines) || (i < len(oldLines) && common[i+1][j] >= common[i][j+1]):
+			ops = append(ops, diffOp{kind: '-', line: oldLines[i], old: i, new: j})
+			i++
+		default:
+			ops = append(ops, diffOp{kind: '+', line: newLines[j], old: i, new: j})
+			j++
+		}
+	}
+
+	return ops
+}
@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+	"github.com/stretchr/testify/require"
+)
+
+func withColors(t *testing.T, enabled bool) {
+	orig := color.NoColor
+	color.NoColor = !enabled
+	t.Cleanup(func() { color.NoColor = orig })
+}
+
+func readDiffFixture(t *testing.T) string {
+	contents, err := os.ReadFile("testdata/gofmt.diff")
+	require.Nil(t, err)
+
+	return string(contents)
+}
+
+func TestColorizeDiff(t *testing.T) {
+	withColors(t, true)
+	diff := readDiffFixture(t)
+	lines := strings.Split(colorizeDiff(diff), "\n")
+
+	require.Equal(t, diffHeaderColor.Sprint("diff main.go.orig main.go"), lines[0])
+	require.Equal(t, diffHeaderColor.Sprint("--- main.go.orig"), lines[1])
+	require.Equal(t, diffHeaderColor.Sprint("+++ main.go"), lines[2])
+	require.Equal(t, diffHunkColor.Sprint("@@ -1,7 +1,9 @@"), lines[3])
+	require.Equal(t, " package main", lines[4])
+	require.Equal(t, diffAddedColor.Sprint("+"), lines[5])
+	require.Equal(t, diffRemovedColor.Sprint(`-fmt.Println( "hi" )`), lines[9])
+}
+
+func TestColorizeDiffWithLeadingNoise(t *testing.T) {
+	withColors(t, true)
+	noise := "checking formatting...\n--- not a header\n"
+	lines := strings.Split(colorizeDiff(noise+readDiffFixture(t)), "\n")
+
+	require.Equal(t, "checking formatting...", lines[0])
+	require.Equal(t, "--- not a header", lines[1])
+	require.Equal(t, diffHeaderColor.Sprint("diff main.go.orig main.go"), lines[2])
+}
+
+func TestColorizeDiffWithoutDiff(t *testing.T) {
+	withColors(t, true)
+	out := "ok  	github.com/dugajean/goke/internal	0.024s\n"
+
+	require.Equal(t, out, colorizeDiff(out))
+}
+
+func TestColorizeDiffWithoutColors(t *testing.T) {
+	withColors(t, false)
+	diff := readDiffFixture(t)
+
+	require.Equal(t, diff, colorizeDiff(diff))
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	before := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	after := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl\n"
+
+	require.Equal(t, `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -9,3 +9,4 @@
+ i
+ j
+ k
++l
+`, unifiedDiff("old", "new", before, after))
+
+	require.Empty(t, unifiedDiff("old", "new", before, before))
+	require.Equal(t, "--- old\n+++ new\n@@ -0,0 +1 @@\n+a\n", unifiedDiff("old", "new", "", "a\n"))
+}
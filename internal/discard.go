@@ -0,0 +1,155 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterCapability("run.discard_output")
+}
+
+// How fast commands must succeed without output to have their output
+// discarded from then on with --discard-quiet-commands.
+const quietCommandMax = 100 * time.Millisecond
+
+// Only commands can discard their output, and not the one diff_output and
+// golden look at.
+func validateDiscard(entry RunEntry, line int) error {
+	if entry.RerunOnFailureForOutput != nil && !entry.DiscardOutput {
+		return fmt.Errorf("line %d: \"rerun_on_failure_for_output\" only applies to \"discard_output\" entries", line)
+	}
+
+	if !entry.DiscardOutput {
+		return nil
+	}
+
+	if entry.Cmd == "" {
+		return fmt.Errorf("line %d: \"discard_output\" only applies to \"cmd\" entries", line)
+	}
+
+	if entry.DiffOutput || entry.Golden != "" {
+		return fmt.Errorf("line %d: \"discard_output\" can't be combined with \"diff_output\" nor \"golden\"", line)
+	}
+
+	return nil
+}
+
+// Commands whose output is discarded run again with it when they fail,
+// unless rerun_on_failure_for_output is false, ie. for the ones which aren't
+// safe to run twice. The ones discarded by --discard-quiet-commands never do.
+func (r RunEntry) rerunsForOutput() bool {
+	return r.DiscardOutput && (r.RerunOnFailureForOutput == nil || *r.RerunOnFailureForOutput)
+}
+
+// The commands which succeeded in less than quietCommandMax without any
+// output, for --discard-quiet-commands. It's shared by the copies of the
+// executor, like the runReport, so that a command which ran quietly once
+// discards its output for the rest of the run, ie. in every batch or every
+// task running it. A nil one knows no commands.
+type quietCommands struct {
+	mu    sync.Mutex
+	quiet map[string]bool
+}
+
+func newQuietCommands() *quietCommands {
+	return &quietCommands{quiet: make(map[string]bool)}
+}
+
+// Commands are told apart by their task, directory and command as written
+// in the config, before their variables are expanded.
+func quietCommandKey(entry RunEntry) string {
+	return strings.Join([]string{entry.task, entry.Dir, entry.Cmd}, "\x00")
+}
+
+// Whether the command already ran quietly.
+func (q *quietCommands) has(entry RunEntry) bool {
+	if q == nil {
+		return false
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.quiet[quietCommandKey(entry)]
+}
+
+// Records the command when it succeeded quickly without printing anything.
+// Unwatched commands, ie. interactive ones, never count as quiet.
+func (q *quietCommands) record(entry RunEntry, err error, elapsed time.Duration, stall *stallWatch) {
+	if q == nil || err != nil || elapsed >= quietCommandMax || stall.printed() {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.quiet[quietCommandKey(entry)] = true
+}
+
+// Whether the output of the command goes to the null device: with
+// discard_output, or with --discard-quiet-commands once it ran quietly.
+func (e *Executor) discardsOutput(entry RunEntry) bool {
+	return entry.DiscardOutput || e.quietCommands.has(entry)
+}
+
+// Runs the prepared system command with its stdout and stderr connected to
+// the null device, skipping the whole output pipeline: nothing is streamed,
+// buffered, captured into files or watched for stalls. Only its exit code
+// and duration are recorded, in the index of --capture-dir, and the summary
+// line counts it. Unless rerun_on_failure_for_output is false, a failed
+// command runs again once with its output, see runProcess, so that its
+// failure can still be diagnosed. It still fails when it succeeds the second
+// time.
+func (e *Executor) runDiscarded(p *preparedCommand, entry RunEntry) Ref[string] {
+	started := time.Now()
+	err := e.runFor(p.cmd, entry.timeout)
+	elapsed := time.Since(started)
+
+	e.capture.discarded(entry.task, p.line, err, elapsed)
+	e.report.outputDiscarded()
+	e.logVerbose(fmt.Sprintf("Discarded the output of %s (exit %d, %s)", p.line, ExitCode(err), elapsed.Round(time.Millisecond)))
+
+	err = newCommandError(p.line, err, p.enc)
+
+	var timeout *CommandTimeoutError
+	if err == nil || !entry.rerunsForOutput() || e.runStopped(err) || errors.As(err, &timeout) {
+		return NewRef("", err)
+	}
+
+	if !e.options.Quiet {
+		e.spinnerMessage(fmt.Sprintf("Rerunning with its output: %s", entry.Cmd))
+	}
+
+	rerun := p.again(e.context())
+	captured := e.capture.begin(entry.task, rerun.line)
+	result := e.runProcess(rerun, entry, captured)
+	captured.finish(result.Error())
+
+	if result.Error() != nil {
+		return result
+	}
+
+	if !e.options.Quiet {
+		fmt.Fprintf(os.Stderr, "Warning: '%s' failed with its output discarded, but succeeded when rerun with it\n", p.line)
+	}
+
+	return NewRef(result.Value(), err)
+}
+
+// A copy of the command which didn't run yet, since commands only run once.
+func (p *preparedCommand) again(ctx context.Context) *preparedCommand {
+	cmd := exec.CommandContext(ctx, p.cmd.Args[0], p.cmd.Args[1:]...)
+	cmd.Env, cmd.Dir, cmd.Stdin = p.cmd.Env, p.cmd.Dir, p.cmd.Stdin
+
+	again := *p
+	again.cmd = cmd
+
+	return &again
+}
@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const discardConfig = `
+build:
+  run:
+    - cmd: "chown -R app dist"
+      discard_output: true
+    - "go build ./..."
+`
+
+// The config of discardConfig, without rerunning chown with its output when
+// it fails.
+var noRerunDiscardConfig = strings.Replace(discardConfig, "discard_output: true", "discard_output: true\n      rerun_on_failure_for_output: false", 1)
+
+// Fails chown, with "permission denied" on stderr unless its output is
+// discarded.
+func failChown(cmd *exec.Cmd) error {
+	if cmd.Args[0] != "chown" {
+		return nil
+	}
+
+	if cmd.Stderr != nil {
+		_, _ = cmd.Stderr.Write([]byte("chown: dist: permission denied\n"))
+	}
+
+	return exec.Command("sh", "-c", "exit 1").Run()
+}
+
+func TestDiscardedOutputSkipsTheCapturePipeline(t *testing.T) {
+	env := NewInMemoryEnv(discardConfig)
+	dir := filepath.Join(t.TempDir(), "artifacts")
+	env.Options.CaptureDir = dir
+
+	outputs := map[string]bool{}
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		outputs[cmd.Args[0]] = cmd.Stdout != nil || cmd.Stderr != nil
+		return nil
+	}
+
+	e := newCaptureExecutor(t, env)
+	require.Nil(t, e.Start([]string{"build"}))
+
+	// Commands without stdout and stderr write to the null device.
+	require.Equal(t, map[string]bool{"chown": false, "go": true}, outputs)
+
+	var index struct {
+		Commands []capturedCommand `json:"commands"`
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(data, &index))
+	require.Len(t, index.Commands, 2)
+
+	require.Equal(t, "chown -R app dist", index.Commands[0].Command)
+	require.True(t, index.Commands[0].Discarded)
+	require.Empty(t, index.Commands[0].Stdout)
+	require.Empty(t, index.Commands[0].Stderr)
+	require.Equal(t, 0, index.Commands[0].ExitCode)
+	require.False(t, index.Commands[1].Discarded)
+
+	entries, err := os.ReadDir(dir)
+	require.Nil(t, err)
+	require.Len(t, entries, 3, "index.json and the files of go build only")
+}
+
+func TestDiscardedOutputIsRecoveredOnFailure(t *testing.T) {
+	env := NewInMemoryEnv(discardConfig)
+	dir := filepath.Join(t.TempDir(), "artifacts")
+	env.Options.CaptureDir = dir
+	env.Runner.Handler = failChown
+
+	e := newCaptureExecutor(t, env)
+	err := e.Start([]string{"build"})
+
+	var cmdErr *CommandError
+	require.True(t, errors.As(err, &cmdErr))
+	require.Equal(t, "chown: dist: permission denied\n", cmdErr.Stderr)
+	require.Equal(t, []string{"chown -R app dist", "chown -R app dist"}, recordedCommands(env))
+
+	var index struct {
+		Commands []capturedCommand `json:"commands"`
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "index.json"))
+	require.Nil(t, err)
+	require.Nil(t, json.Unmarshal(data, &index))
+	require.Len(t, index.Commands, 2)
+	require.True(t, index.Commands[0].Discarded)
+	require.Equal(t, 1, index.Commands[0].ExitCode)
+	require.Equal(t, "chown: dist: permission denied\n", readOutputFile(t, filepath.Join(dir, index.Commands[1].Stderr)))
+}
+
+func TestDiscardedOutputStillFailsWhenTheRerunSucceeds(t *testing.T) {
+	env := NewInMemoryEnv(discardConfig)
+	// Only the discarded run fails.
+	calls := 0
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if cmd.Args[0] == "chown" {
+			calls++
+			if calls == 1 {
+				return exec.Command("sh", "-c", "exit 1").Run()
+			}
+		}
+
+		return nil
+	}
+
+	err := env.Run("build")
+	require.NotNil(t, err)
+	require.Equal(t, 1, ExitCode(err))
+	require.Equal(t, []string{"chown -R app dist", "chown -R app dist"}, recordedCommands(env))
+}
+
+func TestDiscardedOutputWithoutRerun(t *testing.T) {
+	env := NewInMemoryEnv(noRerunDiscardConfig)
+	env.Runner.Handler = failChown
+
+	err := env.Run("build")
+
+	var cmdErr *CommandError
+	require.True(t, errors.As(err, &cmdErr))
+	require.Empty(t, cmdErr.Stderr)
+	require.Equal(t, []string{"chown -R app dist"}, recordedCommands(env))
+}
+
+func TestDiscardQuietCommands(t *testing.T) {
+	env := NewInMemoryEnv(`
+build:
+  run:
+    - "touch dist/.stamp"
+    - "go build ./..."
+    - "touch dist/.stamp"
+    - "go build ./..."
+`)
+	env.Options.DiscardQuiet = true
+
+	discarded := []string{}
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if cmd.Stdout == nil {
+			discarded = append(discarded, strings.Join(cmd.Args, " "))
+			return nil
+		}
+
+		// go build prints, so its output is kept.
+		if cmd.Args[0] == "go" {
+			_, _ = cmd.Stdout.Write([]byte("ok\n"))
+		}
+
+		return nil
+	}
+
+	e := newCaptureExecutor(t, env)
+	require.Nil(t, e.Start([]string{"build"}))
+	require.Equal(t, []string{"touch dist/.stamp"}, discarded)
+}
+
+func TestQuietCommandsDontRerunOnFailure(t *testing.T) {
+	env := NewInMemoryEnv(`
+build:
+  run:
+    - "touch dist/.stamp"
+    - "touch dist/.stamp"
+`)
+	env.Options.DiscardQuiet = true
+
+	calls := 0
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		calls++
+		if calls == 2 {
+			return exec.Command("sh", "-c", "exit 1").Run()
+		}
+
+		return nil
+	}
+
+	err := env.Run("build")
+	require.Equal(t, 1, ExitCode(err))
+	require.Equal(t, []string{"touch dist/.stamp", "touch dist/.stamp"}, recordedCommands(env))
+}
+
+func TestSlowCommandsAreNotQuiet(t *testing.T) {
+	q := newQuietCommands()
+	entry := RunEntry{Cmd: "sync", task: "build"}
+
+	q.record(entry, nil, time.Second, &stallWatch{})
+	require.False(t, q.has(entry))
+
+	q.record(entry, errors.New("exit status 1"), time.Millisecond, &stallWatch{})
+	require.False(t, q.has(entry))
+
+	q.record(entry, nil, time.Millisecond, nil)
+	require.False(t, q.has(entry), "unwatched commands may have printed")
+
+	q.record(entry, nil, time.Millisecond, &stallWatch{})
+	require.True(t, q.has(entry))
+}
+
+func TestSummaryLineCountsDiscardedOutput(t *testing.T) {
+	line, err := runWithSummaryLine(t, NewInMemoryEnv(discardConfig), succeed, "build")
+	require.NoError(t, err)
+	require.Equal(t, "build ✓ 1 task, 2 cmds, output of 1 discarded, 12s\n", line)
+}
+
+func TestDiscardOutputValidation(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]string{
+		"export":   "build:\n  run:\n    - export: {A: b}\n      discard_output: true\n",
+		"golden":   "build:\n  run:\n    - cmd: \"go run .\"\n      golden: out.txt\n      discard_output: true\n",
+		"rerun":    "build:\n  run:\n    - cmd: \"sync\"\n      rerun_on_failure_for_output: true\n",
+		"referred": "lint:\n  run: [\"go vet\"]\nbuild:\n  run:\n    - cmd: lint\n      discard_output: true\n",
+	}
+	errs := map[string]string{
+		"export":   `line 3: "discard_output" only applies to "cmd" entries`,
+		"golden":   `line 3: "discard_output" can't be combined with "diff_output" nor "golden"`,
+		"rerun":    `line 3: "rerun_on_failure_for_output" only applies to "discard_output" entries`,
+		"referred": "task 'build': discard_output doesn't apply to the referenced task 'lint'",
+	}
+
+	for name, config := range cases {
+		_, err := NewInMemoryEnv(config).Parse()
+		require.NotNil(t, err, name)
+		require.Contains(t, err.Error(), errs[name], name)
+	}
+}
@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("run.dry_run")
+}
+
+// Where --dry-run prints the commands, replaced in tests.
+var dryRunOutput io.Writer = os.Stdout
+
+// Prints a line of --dry-run, indented under the task it belongs to.
+func (e *Executor) printDryRun(format string, args ...any) {
+	fmt.Fprintf(dryRunOutput, "%s%s\n", strings.Repeat("  ", e.dryRunDepth), fmt.Sprintf(format, args...))
+}
+
+// Prints the command instead of running it, with its variables expanded.
+func (e *Executor) printDryRunCommand(entry RunEntry, env map[string]string) error {
+	env, err := entry.commandVars(env)
+	if err != nil {
+		return err
+	}
+
+	line, err := e.commandLine(entry, env, false)
+	if err != nil {
+		return err
+	}
+
+	if entry.Dir != "" && entry.Dir != "." {
+		line += fmt.Sprintf(" (in %s)", entry.Dir)
+	}
+
+	e.printDryRun("%s", line)
+	return nil
+}
+
+// Prints the name of the task and indents the lines of its commands, until
+// the returned function is called.
+func (e *Executor) beginDryRunTask(name string) func() {
+	e.printDryRun("%s:", name)
+	e.dryRunDepth++
+
+	return func() { e.dryRunDepth-- }
+}
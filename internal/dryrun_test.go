@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stubDryRunOutput(t *testing.T) *bytes.Buffer {
+	out := &bytes.Buffer{}
+	orig := dryRunOutput
+	dryRunOutput = out
+	t.Cleanup(func() { dryRunOutput = orig })
+
+	return out
+}
+
+func TestDryRunPrintsTheResolvedCommands(t *testing.T) {
+	out := stubDryRunOutput(t)
+	env := NewInMemoryEnv(`
+global:
+  environment:
+    OUT: bin
+  events:
+    before_each_task:
+      - "echo starting"
+
+lint:
+  files: [src/*.go]
+  run:
+    - "golint {FILES}"
+
+build:
+  deps: [lint]
+  files: [src/*.go]
+  run:
+    - export:
+        VERSION: "$(git describe)"
+    - "go build -o $OUT/app -ldflags $VERSION"
+    - test
+
+test:
+  run:
+    - "go test ./..."
+`)
+	env.FS.WriteFile("/work/src/main.go", []byte("package main"), 0644)
+	_, err := env.Parse()
+	require.Nil(t, err)
+
+	env.FS.WriteFile("/work/src/main.go", []byte("package main\n"), 0644)
+	env.Options.DryRun = true
+
+	expected := `build:
+  lint:
+    golint src/main.go
+  echo starting
+  export VERSION=$(git describe)
+  go build -o bin/app -ldflags $(git describe)
+  test:
+    go test ./...
+`
+
+	require.Nil(t, env.Run("build"))
+	require.Equal(t, expected, out.String())
+	require.Empty(t, recordedCommands(env))
+
+	// The lockfile is left untouched, so the files are still changed.
+	out.Reset()
+	require.Nil(t, env.Run("build"))
+	require.Equal(t, expected, out.String())
+
+	env.Options.DryRun = false
+	require.Nil(t, env.Run("build"))
+	require.NotEmpty(t, recordedCommands(env))
+
+	out.Reset()
+	env.Options.DryRun = true
+	require.Nil(t, env.Run("build"))
+	require.Equal(t, "build: would skip: files unchanged\n", out.String())
+}
+
+func TestDryRunPrintsTheExportsInOrder(t *testing.T) {
+	out := stubDryRunOutput(t)
+	env := NewInMemoryEnv(`
+release:
+  run:
+    - "go test ./..."
+    - export:
+        TAG: v1
+        CHANNEL: stable
+    - "echo $CHANNEL $TAG"
+    - export:
+        TAG: $TAG-rc
+    - "echo $TAG"
+`)
+	env.Options.DryRun = true
+
+	require.Nil(t, env.Run("release"))
+	require.Equal(t, `release:
+  go test ./...
+  export CHANNEL=stable
+  export TAG=v1
+  echo stable v1
+  export TAG=v1-rc
+  echo v1-rc
+`, out.String())
+	require.Empty(t, recordedCommands(env))
+}
+
+func TestDryRunRejectsWatch(t *testing.T) {
+	e := newTestExecutor(t, "build:\n  run: [\"go build\"]\n")
+	e.options.DryRun = true
+	e.options.Watch = true
+
+	require.EqualError(t, e.start([]string{"build"}), "--dry-run cannot be combined with --watch")
+}
@@ -0,0 +1,51 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/htmlindex"
+	"golang.org/x/text/encoding/ianaindex"
+)
+
+func init() {
+	RegisterCapability("task.output_encoding")
+}
+
+// Looks up the encoding of a task's output by name, ie. "cp1252" or
+// "shift_jis". An empty name means UTF-8 and returns nil.
+func lookupEncoding(name string) (encoding.Encoding, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	if enc, err := htmlindex.Get(name); err == nil {
+		return enc, nil
+	}
+
+	enc, err := ianaindex.IANA.Encoding(name)
+	if err != nil || enc == nil {
+		return nil, fmt.Errorf("unknown output encoding '%s'", name)
+	}
+
+	return enc, nil
+}
+
+// Converts the output of a command to valid UTF-8. Output in the given
+// encoding is transcoded, otherwise invalid UTF-8 sequences are replaced
+// with U+FFFD, so that the output is never garbled further down the line.
+func decodeOutput(out []byte, enc encoding.Encoding) []byte {
+	if enc != nil {
+		if decoded, err := enc.NewDecoder().Bytes(out); err == nil {
+			out = decoded
+		}
+	}
+
+	if utf8.Valid(out) {
+		return out
+	}
+
+	return []byte(strings.ToValidUTF8(string(out), string(utf8.RuneError)))
+}
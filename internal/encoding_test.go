@@ -0,0 +1,99 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func readFixture(t *testing.T, name string) []byte {
+	contents, err := os.ReadFile("testdata/" + name)
+	require.Nil(t, err)
+
+	return contents
+}
+
+// Asserts that the output survives a JSON round trip unchanged.
+func requireJSONSafe(t *testing.T, out []byte) {
+	encoded, err := json.Marshal(string(out))
+	require.Nil(t, err)
+
+	var decoded string
+	require.Nil(t, json.Unmarshal(encoded, &decoded))
+	require.Equal(t, string(out), decoded)
+}
+
+func TestDecodeOutputTranscodes(t *testing.T) {
+	cases := []struct {
+		fixture  string
+		encoding string
+		expected string
+	}{
+		{"cp1252.txt", "cp1252", "Compilación terminada: 3 señales, coût 5€\n"},
+		{"shift_jis.txt", "shift_jis", "ビルド成功\n"},
+	}
+
+	for _, c := range cases {
+		enc, err := lookupEncoding(c.encoding)
+		require.Nil(t, err)
+
+		out := decodeOutput(readFixture(t, c.fixture), enc)
+		require.Equal(t, c.expected, string(out))
+		requireJSONSafe(t, out)
+	}
+}
+
+func TestDecodeOutputSanitizesInvalidUTF8(t *testing.T) {
+	out := decodeOutput(readFixture(t, "cp1252.txt"), nil)
+
+	require.Equal(t, "Compilaci�n terminada: 3 se�ales, co�t 5�\n", string(out))
+	requireJSONSafe(t, out)
+}
+
+func TestDecodeOutputKeepsValidUTF8(t *testing.T) {
+	require.Equal(t, "ok ✓\n", string(decodeOutput([]byte("ok ✓\n"), nil)))
+}
+
+func TestLookupEncoding(t *testing.T) {
+	enc, err := lookupEncoding("")
+	require.Nil(t, err)
+	require.Nil(t, enc)
+
+	enc, err = lookupEncoding("IBM437")
+	require.Nil(t, err)
+	require.NotNil(t, enc)
+
+	_, err = lookupEncoding("klingon")
+	require.EqualError(t, err, "unknown output encoding 'klingon'")
+}
+
+func TestSpinnerWriterDecodesOutput(t *testing.T) {
+	enc, _ := lookupEncoding("cp1252")
+	out := bytes.Buffer{}
+	w := &spinnerWriter{out: &out, mu: &sync.Mutex{}, encoding: enc}
+
+	_, _ = w.Write(readFixture(t, "cp1252.txt"))
+	require.Equal(t, "Compilación terminada: 3 señales, coût 5€\n", out.String())
+}
+
+func TestRunSysCommandDecodesBufferedOutput(t *testing.T) {
+	e := Executor{options: Options{Quiet: true}}
+	ch := make(chan Ref[string])
+
+	go e.runSysCommand(RunEntry{Cmd: "cat testdata/cp1252.txt", outputEncoding: "cp1252"}, map[string]string{}, ch)
+	out := <-ch
+
+	require.Nil(t, out.Error())
+	require.Equal(t, "\nCompilación terminada: 3 señales, coût 5€\n\n", out.Value())
+}
+
+func TestParseTasksRejectsUnknownEncoding(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser("build:\n  output_encoding: klingon\n  run:\n    - \"true\"\n", &clearCacheOpts, fsMock)
+
+	require.EqualError(t, parser.parseTasks(), "task 'build': unknown output encoding 'klingon'")
+}
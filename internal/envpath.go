@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterCapability("env.path_type")
+}
+
+// The type of variables holding paths, see resolvePathValue.
+const envTypePath = "path"
+
+// A variable under "env" or global.environment, either a plain string, or
+// a mapping like {value: ./build, type: path} for paths.
+type EnvValue struct {
+	Value string `yaml:"value"`
+	Type  string `yaml:"type,omitempty"`
+}
+
+func (v *EnvValue) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		v.Type = ""
+		return node.Decode(&v.Value)
+	}
+
+	// Decoding into an alias type avoids recursing into this method.
+	type envValue EnvValue
+	var value envValue
+
+	if err := node.Decode(&value); err != nil {
+		return err
+	}
+
+	if value.Type != "" && value.Type != "string" && value.Type != envTypePath {
+		return fmt.Errorf("line %d: unknown variable type \"%s\", it can be \"string\" or \"path\"", node.Line, value.Type)
+	}
+
+	*v = EnvValue(value)
+	return nil
+}
+
+// Splits the variables into their values and the names of the ones which are
+// paths, either by their type or by being listed in global.env_path_vars.
+func (p *Parser) envValues(vars map[string]EnvValue, pathVars []string) (map[string]string, map[string]bool) {
+	values := make(map[string]string, len(vars))
+	paths := make(map[string]bool)
+
+	for k, v := range vars {
+		values[k] = v.Value
+		if v.Type == envTypePath {
+			paths[k] = true
+		}
+	}
+
+	for _, k := range pathVars {
+		paths[k] = true
+	}
+
+	return values, paths
+}
+
+// The absolute directory of the config, which paths are resolved against.
+func (p *Parser) configDir() string {
+	cwd, _ := p.fs.Getwd()
+	return joinDir(cwd, filepath.Dir(p.configFile()))
+}
+
+// Resolves the value of a path variable against the directory of the config,
+// so that it's the same for tasks with a dir, and uses the separators of
+// the OS. Variables in the value are expanded first, ie. "$HOME/.cache".
+func resolvePathValue(base string, value string, goos string) string {
+	// Unset ${VAR:?message} already failed when resolving the variables.
+	value, _ = expandProcessEnv(value)
+	if goos != "windows" {
+		if !strings.HasPrefix(value, "/") {
+			value = base + "/" + value
+		}
+
+		return path.Clean(value)
+	}
+
+	value = strings.ReplaceAll(value, `\`, "/")
+	if !isWindowsAbsPath(value) {
+		value = strings.ReplaceAll(base, `\`, "/") + "/" + value
+	}
+
+	// Cleaning would drop the second slash of UNC paths, ie. //server/share.
+	unc := strings.HasPrefix(value, "//")
+	value = path.Clean(value)
+	if unc {
+		value = "/" + value
+	}
+
+	return strings.ReplaceAll(value, "/", `\`)
+}
+
+// Whether the path, with forward slashes, is absolute on Windows, which
+// takes a drive letter or a UNC path.
+func isWindowsAbsPath(p string) bool {
+	return strings.HasPrefix(p, "//") || len(p) >= 3 && p[1] == ':' && p[2] == '/'
+}
+
+// Resolves the path variables among vars, see resolvePathValue.
+func (p *Parser) resolvePathVars(vars map[string]string, paths map[string]bool) {
+	for k := range paths {
+		if v, ok := vars[k]; ok && v != "" {
+			vars[k] = resolvePathValue(p.configDir(), v, runtime.GOOS)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolvePathValue(t *testing.T) {
+	require.Equal(t, "/work/build", resolvePathValue("/work", "./build", "linux"))
+	require.Equal(t, "/work/out", resolvePathValue("/work", "build/../out/", "linux"))
+	require.Equal(t, "/opt/build", resolvePathValue("/work", "/opt/build", "linux"))
+	require.Equal(t, "/work/build", resolvePathValue("/work", "build", "darwin"))
+
+	require.Equal(t, `C:\work\build`, resolvePathValue(`C:\work`, "./build", "windows"))
+	require.Equal(t, `C:\work\build\bin`, resolvePathValue(`C:\work`, `build\bin`, "windows"))
+	require.Equal(t, `D:\out`, resolvePathValue(`C:\work`, "D:/out", "windows"))
+	require.Equal(t, `\\server\share\out`, resolvePathValue(`C:\work`, `\\server\share\out`, "windows"))
+}
+
+func TestEnvValueTypes(t *testing.T) {
+	var task Task
+	require.Nil(t, decodeConfig("goke.yml", "env:\n  A: a\n  B: {value: ./b, type: path}\n", &task))
+	require.Equal(t, map[string]EnvValue{"A": {Value: "a"}, "B": {Value: "./b", Type: "path"}}, task.EnvValues)
+
+	err := decodeConfig("goke.yml", "env:\n  B: {value: ./b, type: dir}\n", &task)
+	require.ErrorContains(t, err, `line 2: unknown variable type "dir"`)
+}
+
+func TestPathVariablesResolveAgainstTheConfig(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the in-memory paths are Unix ones")
+	}
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+global:
+  environment:
+    ENVPATH_OUT: {value: ./build, type: path}
+    ENVPATH_CACHE: .cache
+    ENVPATH_NAME: ./app
+  env_path_vars: [ENVPATH_CACHE, ENVPATH_BIN]
+
+docs:
+  dir: docs
+  env:
+    ENVPATH_BIN: bin/app
+    ENVPATH_SITE: {value: site, type: path}
+  run:
+    - "mkdocs build -d ${ENVPATH_OUT} ${ENVPATH_CACHE} ${ENVPATH_BIN} ${ENVPATH_SITE} ${ENVPATH_NAME}"
+`)
+
+	require.Nil(t, env.FS.WriteFile("docs/index.md", []byte("# Docs"), 0644))
+	require.Nil(t, env.Run("docs"))
+
+	require.Equal(t, []string{"mkdocs build -d /work/build /work/.cache /work/bin/app /work/site ./app"}, recordedCommands(env))
+	require.Equal(t, "docs", env.Runner.Commands()[0].Dir)
+}
+
+func TestPathVariablesOfSubprojects(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the in-memory paths are Unix ones")
+	}
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+main:
+  run:
+    - goke: services/api
+`)
+	require.Nil(t, env.FS.WriteFile("services/api/goke.yml", []byte(`
+global:
+  environment:
+    ENVPATH_API_OUT: {value: ./build, type: path}
+
+main:
+  run:
+    - "go build -o ${ENVPATH_API_OUT}"
+`), 0644))
+
+	require.Nil(t, env.Run())
+	require.Equal(t, []string{"go build -o /work/services/api/build"}, recordedCommands(env))
+}
@@ -0,0 +1,375 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterCapability("env.resolver", "command.config")
+}
+
+// Where a variable of the commands comes from, see EnvPrecedence.
+type EnvSource string
+
+const (
+	// The environment goke was started with.
+	EnvFromOS EnvSource = "os"
+
+	// global.environment of goke.yml.
+	EnvFromGlobal EnvSource = "global.environment"
+
+	// global.environment of the local overrides file, ie. goke.local.yml.
+	EnvFromLocal EnvSource = "local overrides"
+
+	// The env of the task.
+	EnvFromTask EnvSource = "task env"
+
+	// The env of the current step of a --batch plan.
+	EnvFromPlan EnvSource = "plan step"
+
+	// The exports of the run entries of the task which already ran.
+	EnvFromExport EnvSource = "export"
+)
+
+// The sources of variables, from the lowest precedence to the highest. A
+// variable defined by several sources gets the value of the last one. This
+// order is a contract, which new sources have to fit into explicitly.
+var EnvPrecedence = []EnvSource{EnvFromOS, EnvFromGlobal, EnvFromLocal, EnvFromTask, EnvFromPlan, EnvFromExport}
+
+// EnvResolver layers the variables of all sources following EnvPrecedence,
+// for the parser, the plans and the executor alike. It's the only place
+// which reads the process environment, see lookupProcessEnv.
+type EnvResolver struct {
+	layers map[EnvSource]map[string]string
+
+	// Looks the variables of the OS source up, see FromStartupEnv.
+	lookupOS func(string) (string, bool)
+}
+
+func NewEnvResolver() *EnvResolver {
+	return &EnvResolver{layers: make(map[EnvSource]map[string]string), lookupOS: lookupProcessEnv}
+}
+
+// Makes the OS source the environment goke was started with, without the
+// variables of global.environment it exports to itself, see startupEnv.
+func (r *EnvResolver) FromStartupEnv() *EnvResolver {
+	r.lookupOS = lookupStartupEnv
+	return r
+}
+
+// Sets the variables of the source, replacing the ones it had. The OS source
+// can't be set, it's always the environment of goke.
+func (r *EnvResolver) Set(source EnvSource, vars map[string]string) *EnvResolver {
+	if source != EnvFromOS {
+		r.layers[source] = vars
+	}
+
+	return r
+}
+
+// The variables defined by goke's own sources, ie. all but the OS, with
+// their winning values.
+func (r *EnvResolver) Vars() map[string]string {
+	vars := make(map[string]string)
+
+	for _, source := range EnvPrecedence {
+		for k, v := range r.layers[source] {
+			vars[k] = v
+		}
+	}
+
+	return vars
+}
+
+// Looks the variable up in all sources, including the OS.
+func (r *EnvResolver) Lookup(name string) (string, bool) {
+	for i := len(EnvPrecedence) - 1; i > 0; i-- {
+		if v, ok := r.layers[EnvPrecedence[i]][name]; ok {
+			return v, true
+		}
+	}
+
+	return r.lookupOS(name)
+}
+
+// Expands $VAR, ${VAR} and the operators of shellExpand in str with the
+// variables of all sources.
+func (r *EnvResolver) Expand(str string) (string, error) {
+	return shellExpand(str, r.Lookup)
+}
+
+// A definition of a variable by one of the sources.
+type EnvDefinition struct {
+	Source EnvSource
+	Value  string
+}
+
+// A variable defined by more than one source. Its definitions follow
+// EnvPrecedence, so the last one wins.
+type EnvConflict struct {
+	Name        string
+	Definitions []EnvDefinition
+}
+
+func (c EnvConflict) Winner() EnvDefinition {
+	return c.Definitions[len(c.Definitions)-1]
+}
+
+// Lists the variables defined by more than one source, by name. The OS
+// source is the environment goke was started with, so the variables of
+// global.environment which goke exports to itself aren't conflicts.
+func (r *EnvResolver) Conflicts() []EnvConflict {
+	definitions := make(map[string][]EnvDefinition)
+
+	for _, source := range EnvPrecedence {
+		vars := r.layers[source]
+		if source == EnvFromOS {
+			vars = startupEnv()
+		}
+
+		for k, v := range vars {
+			definitions[k] = append(definitions[k], EnvDefinition{Source: source, Value: v})
+		}
+	}
+
+	conflicts := []EnvConflict{}
+	for _, name := range sortedKeys(definitions) {
+		if len(definitions[name]) > 1 {
+			conflicts = append(conflicts, EnvConflict{Name: name, Definitions: definitions[name]})
+		}
+	}
+
+	return conflicts
+}
+
+// Writes the conflicts as a table, marking the winning definitions.
+func writeEnvConflicts(out io.Writer, conflicts []EnvConflict) {
+	rows := [][]string{}
+
+	for _, c := range conflicts {
+		for i, d := range c.Definitions {
+			name, winner := "", ""
+			if i == 0 {
+				name = c.Name
+			}
+			if i == len(c.Definitions)-1 {
+				winner = "<- wins"
+			}
+
+			rows = append(rows, []string{name, string(d.Source), fmt.Sprintf("%q", d.Value), winner})
+		}
+	}
+
+	writeTable(out, rows)
+}
+
+// The variables goke set in its own environment, with the values they had
+// before, if any, so that startupEnv can tell them apart.
+var (
+	processEnvMu  sync.Mutex
+	overriddenEnv = make(map[string]*string)
+)
+
+// The variables of goke's environment read by the recordings in progress,
+// see recordEnvReads.
+var (
+	envReadsMu sync.Mutex
+	envReads   []*envRecording
+)
+
+type envRecording struct {
+	names map[string]bool
+}
+
+// Reads a variable of goke's environment.
+func lookupProcessEnv(name string) (string, bool) {
+	envReadsMu.Lock()
+	for _, r := range envReads {
+		r.names[name] = true
+	}
+	envReadsMu.Unlock()
+
+	return os.LookupEnv(name)
+}
+
+// Runs fn and returns the names of the variables of goke's environment read
+// in the meantime, sorted. Reads of other goroutines, ie. of another parser,
+// are included too, which only means comparing more variables.
+func recordEnvReads(fn func() error) ([]string, error) {
+	r := &envRecording{names: make(map[string]bool)}
+
+	envReadsMu.Lock()
+	envReads = append(envReads, r)
+	envReadsMu.Unlock()
+
+	err := fn()
+
+	envReadsMu.Lock()
+	defer envReadsMu.Unlock()
+
+	for i := range envReads {
+		if envReads[i] == r {
+			envReads = append(envReads[:i], envReads[i+1:]...)
+			break
+		}
+	}
+
+	return sortedKeys(r.names), err
+}
+
+// Reads a variable of the environment goke was started with, before
+// setProcessEnv, see startupEnv.
+func lookupStartupEnv(name string) (string, bool) {
+	processEnvMu.Lock()
+	prev, overridden := overriddenEnv[name]
+	processEnvMu.Unlock()
+
+	if !overridden {
+		return os.LookupEnv(name)
+	}
+
+	if prev == nil {
+		return "", false
+	}
+
+	return *prev, true
+}
+
+// Reads a variable of goke's environment, empty when unset.
+func getProcessEnv(name string) string {
+	v, _ := lookupProcessEnv(name)
+	return v
+}
+
+// Expands $VAR, ${VAR} and the operators of shellExpand in str with goke's
+// environment.
+func expandProcessEnv(str string) (string, error) {
+	return shellExpand(str, lookupProcessEnv)
+}
+
+// Sets a variable in goke's environment, ie. global.environment, which
+// the commands inherit.
+func setProcessEnv(name string, value string) {
+	processEnvMu.Lock()
+	if _, ok := overriddenEnv[name]; !ok {
+		if prev, ok := os.LookupEnv(name); ok {
+			overriddenEnv[name] = &prev
+		} else {
+			overriddenEnv[name] = nil
+		}
+	}
+	processEnvMu.Unlock()
+
+	_ = os.Setenv(name, value)
+}
+
+// The environment goke was started with, before setProcessEnv.
+func startupEnv() map[string]string {
+	env := make(map[string]string)
+	for _, kv := range os.Environ() {
+		if k, v, ok := strings.Cut(kv, "="); ok && k != "" {
+			env[k] = v
+		}
+	}
+
+	processEnvMu.Lock()
+	defer processEnvMu.Unlock()
+
+	for k, prev := range overriddenEnv {
+		if prev == nil {
+			delete(env, k)
+		} else {
+			env[k] = *prev
+		}
+	}
+
+	return env
+}
+
+// Layers the given variables on top of goke's environment, in the format
+// expected by exec.Cmd.
+func commandEnv(env map[string]string) []string {
+	vars := os.Environ()
+	for _, k := range sortedKeys(env) {
+		vars = append(vars, k+"="+env[k])
+	}
+
+	return vars
+}
+
+// Expands $VAR, ${VAR} and the operators of shellExpand in str, giving
+// precedence to the given variables over the ones of goke's environment.
+func expandEnv(str string, env map[string]string) (string, error) {
+	return shellExpand(str, func(key string) (string, bool) {
+		if v, ok := env[key]; ok {
+			return v, true
+		}
+		return lookupProcessEnv(key)
+	})
+}
+
+// The resolver of the variables of the task's commands, with the layers of
+// the config: global.environment, the local overrides and the task's env.
+func (p *Parser) envResolver(task Task) *EnvResolver {
+	return NewEnvResolver().
+		Set(EnvFromGlobal, p.EnvLayers[EnvFromGlobal]).
+		Set(EnvFromLocal, p.EnvLayers[EnvFromLocal]).
+		Set(EnvFromTask, task.Env)
+}
+
+// Lists the variables of the task which are defined by more than one source
+// of the config or the OS, for "goke config --env-conflicts".
+func (p *Parser) EnvConflicts(taskName string) ([]EnvConflict, error) {
+	task, ok := p.Tasks[taskName]
+	if !ok {
+		return nil, &TaskNotFoundError{Task: taskName}
+	}
+
+	return p.envResolver(task).Conflicts(), nil
+}
+
+// Writes the conflicts of the variables of each task, for
+// "goke config --env-conflicts".
+func (p *Parser) WriteEnvConflicts(out io.Writer) {
+	for _, name := range sortedKeys(p.Tasks) {
+		conflicts, _ := p.EnvConflicts(name)
+		if name == "global" || len(conflicts) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(out, "%s:\n", name)
+		writeEnvConflicts(out, conflicts)
+	}
+}
+
+// The resolver of the variables of the task's commands, which also has the
+// variables of the current plan step. With --bare, only the task's own
+// variables are layered on the environment goke was started with.
+func (e *Executor) envResolver(task Task) *EnvResolver {
+	if e.options.Bare {
+		return NewEnvResolver().FromStartupEnv().Set(EnvFromTask, task.Env).Set(EnvFromPlan, e.envOverrides)
+	}
+
+	return e.parser.envResolver(task).Set(EnvFromPlan, e.envOverrides)
+}
+
+// Prints the variables of the task defined by more than one source, with -vv.
+// Exports aren't known before the task runs, and always win.
+func (e *Executor) logEnvConflicts(task Task) {
+	if !e.options.VeryVerbose || e.options.Quiet {
+		return
+	}
+
+	conflicts := e.envResolver(task).Conflicts()
+	if len(conflicts) == 0 {
+		return
+	}
+
+	var b strings.Builder
+	writeEnvConflicts(&b, conflicts)
+	e.logVerbose(fmt.Sprintf("Variables of %s defined more than once:\n%s", task.Name, strings.TrimRight(b.String(), "\n")))
+}
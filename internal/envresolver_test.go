@@ -0,0 +1,132 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestEnvPrecedence(t *testing.T) {
+	require.Equal(t, []EnvSource{EnvFromOS, EnvFromGlobal, EnvFromLocal, EnvFromTask, EnvFromPlan, EnvFromExport}, EnvPrecedence)
+
+	// Every source overrides all the sources before it.
+	for i, lower := range EnvPrecedence {
+		for _, higher := range EnvPrecedence[i+1:] {
+			t.Run(fmt.Sprintf("%s < %s", lower, higher), func(t *testing.T) {
+				const name = "GOKE_PRECEDENCE_TEST"
+				r := NewEnvResolver()
+
+				if lower == EnvFromOS {
+					t.Setenv(name, string(lower))
+				} else {
+					r.Set(lower, map[string]string{name: string(lower)})
+				}
+				r.Set(higher, map[string]string{name: string(higher)})
+
+				require.Equal(t, string(higher), r.Vars()[name])
+
+				v, ok := r.Lookup(name)
+				require.True(t, ok)
+				require.Equal(t, string(higher), v)
+				expanded, err := r.Expand("value of ${" + name + "}")
+				require.NoError(t, err)
+				require.Equal(t, "value of "+string(higher), expanded)
+
+				conflicts := r.Conflicts()
+				require.Equal(t, []EnvConflict{{
+					Name: name,
+					Definitions: []EnvDefinition{
+						{Source: lower, Value: string(lower)},
+						{Source: higher, Value: string(higher)},
+					},
+				}}, conflicts)
+				require.Equal(t, higher, conflicts[0].Winner().Source)
+			})
+		}
+	}
+}
+
+func TestEnvResolverSources(t *testing.T) {
+	t.Setenv("GOKE_RESOLVER_OS", "os")
+
+	r := NewEnvResolver().
+		Set(EnvFromOS, map[string]string{"GOKE_RESOLVER_OS": "ignored"}).
+		Set(EnvFromGlobal, map[string]string{"A": "global", "B": "global"}).
+		Set(EnvFromTask, map[string]string{"B": "task"})
+
+	require.Equal(t, map[string]string{"A": "global", "B": "task"}, r.Vars())
+
+	v, ok := r.Lookup("GOKE_RESOLVER_OS")
+	require.True(t, ok)
+	require.Equal(t, "os", v)
+
+	_, ok = r.Lookup("GOKE_RESOLVER_UNSET")
+	require.False(t, ok)
+
+	var out bytes.Buffer
+	writeEnvConflicts(&out, r.Conflicts())
+	require.Equal(t, "B  global.environment  \"global\"\n   task env            \"task\"    <- wins\n", out.String())
+}
+
+func TestVariablesGokeSetsAreNotConflicts(t *testing.T) {
+	const name = "GOKE_RESOLVER_EXPORTED"
+	t.Cleanup(func() {
+		_ = os.Unsetenv(name)
+		processEnvMu.Lock()
+		delete(overriddenEnv, name)
+		processEnvMu.Unlock()
+	})
+
+	setProcessEnv(name, "global")
+	require.Equal(t, "global", os.Getenv(name))
+
+	r := NewEnvResolver().Set(EnvFromGlobal, map[string]string{name: "global"})
+	require.Empty(t, r.Conflicts())
+	require.NotContains(t, startupEnv(), name)
+}
+
+func TestEnvConflictsOfTasks(t *testing.T) {
+	t.Setenv("GOKE_CONFLICT_OS", "shell")
+
+	env := NewInMemoryEnv(`
+global:
+  environment:
+    GOKE_CONFLICT_GOOS: linux
+
+build:
+  env:
+    GOKE_CONFLICT_GOOS: darwin
+    GOKE_CONFLICT_OS: task
+  run:
+    - "go build"
+
+lint:
+  run:
+    - "go vet"
+`)
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	conflicts, err := p.EnvConflicts("build")
+	require.Nil(t, err)
+	require.Len(t, conflicts, 2)
+	require.Equal(t, "GOKE_CONFLICT_GOOS", conflicts[0].Name)
+	require.Equal(t, EnvDefinition{Source: EnvFromTask, Value: "darwin"}, conflicts[0].Winner())
+	require.Equal(t, []EnvDefinition{{Source: EnvFromOS, Value: "shell"}, {Source: EnvFromTask, Value: "task"}}, conflicts[1].Definitions)
+
+	conflicts, err = p.EnvConflicts("lint")
+	require.Nil(t, err)
+	require.Empty(t, conflicts)
+
+	_, err = p.EnvConflicts("test")
+	require.EqualError(t, err, "task 'test' not found")
+
+	var out bytes.Buffer
+	p.WriteEnvConflicts(&out)
+	require.Contains(t, out.String(), "build:\n")
+	require.NotContains(t, out.String(), "lint:")
+}
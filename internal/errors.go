@@ -0,0 +1,145 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+
+	"golang.org/x/text/encoding"
+)
+
+// CommandError is returned when a command of a task fails. It names the
+// failing command and carries whatever the command wrote to stderr.
+type CommandError struct {
+	Cmd    string
+	Stderr string
+	Err    error
+}
+
+// Wraps the error of the given command, picking up the stderr captured by
+// exec when the command ran buffered. Returns nil when err is nil.
+func newCommandError(cmd string, err error, enc encoding.Encoding) error {
+	if err == nil {
+		return nil
+	}
+
+	cmdErr := &CommandError{Cmd: cmd, Err: err}
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		cmdErr.Stderr = string(decodeOutput(exitErr.Stderr, enc))
+	}
+
+	return cmdErr
+}
+
+// The first line names the command, stderr follows verbatim on the next lines.
+func (e *CommandError) Error() string {
+	msg := fmt.Sprintf("\"%s\" failed: %s", e.Cmd, e.Err)
+
+	var timeout *CommandTimeoutError
+	if errors.As(e.Err, &timeout) {
+		msg = fmt.Sprintf("\"%s\" %s", e.Cmd, timeout)
+	}
+
+	stderr := strings.TrimRight(e.Stderr, "\n")
+	if stderr == "" {
+		return msg
+	}
+
+	return msg + "\n" + stderr
+}
+
+func (e *CommandError) Unwrap() error {
+	return e.Err
+}
+
+// The exit status goke ends with because of the command, see ExitCode.
+func (e *CommandError) ExitCode() int {
+	return ExitCode(e)
+}
+
+// TaskNotFoundError is returned when a task to run is not declared.
+type TaskNotFoundError struct {
+	Task string
+
+	// The tasks which can run instead, only listed when the main task was
+	// run implicitly and the config has none, see missingDefaultTask.
+	Available []string
+}
+
+func (e *TaskNotFoundError) Error() string {
+	if len(e.Available) > 0 {
+		return fmt.Sprintf("task '%s' not found\n\nAvailable tasks: %s", e.Task, strings.Join(e.Available, ", "))
+	}
+
+	return fmt.Sprintf("task '%s' not found", e.Task)
+}
+
+// ConfigErrors is returned when tasks of the config fail to parse with
+// Options.CollectErrors, one error per task, in the order they're declared.
+type ConfigErrors []error
+
+// Each error is on its own line.
+func (e ConfigErrors) Error() string {
+	msgs := make([]string, len(e))
+	for i, err := range e {
+		msgs[i] = err.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+// Wraps an error which was already reported to the user, ie. in the
+// summary of a plan, so that it's not reported twice.
+type reportedError struct {
+	error
+}
+
+func (e reportedError) Unwrap() error {
+	return e.error
+}
+
+// Returns the exit status goke should end with for the error: the exit code
+// of the failed command, or 128+signal when it was killed by a signal, like
+// shells do, ExitCodeTimeout when it timed out and ExitCodeDeadlineExceeded
+// when --deadline cut the run off. Interrupted runs also exit with
+// 128+signal, see InterruptedError.
+// Any other error results in 1.
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+
+	var interrupted *InterruptedError
+	if errors.As(err, &interrupted) {
+		return interrupted.ExitCode()
+	}
+
+	var exceeded *DeadlineExceededError
+	if errors.As(err, &exceeded) {
+		return ExitCodeDeadlineExceeded
+	}
+
+	var timeout *CommandTimeoutError
+	if errors.As(err, &timeout) {
+		return ExitCodeTimeout
+	}
+
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 1
+	}
+
+	if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		return 128 + int(status.Signal())
+	}
+
+	if code := exitErr.ExitCode(); code > 0 {
+		return code
+	}
+
+	return 1
+}
@@ -0,0 +1,37 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewCommandError(t *testing.T) {
+	require.Nil(t, newCommandError("true", nil, nil))
+
+	err := newCommandError("missing-binary", exec.ErrNotFound, nil)
+	require.Equal(t, `"missing-binary" failed: executable file not found in $PATH`, err.Error())
+	require.True(t, errors.Is(err, exec.ErrNotFound))
+}
+
+func TestCommandErrorKeepsExitCode(t *testing.T) {
+	err := newCommandError("sh -c 'exit 4'", exec.Command("sh", "-c", "exit 4").Run(), nil)
+
+	var exitErr *exec.ExitError
+	require.ErrorAs(t, err, &exitErr)
+	require.Equal(t, 4, exitErr.ExitCode())
+}
+
+func TestExitCode(t *testing.T) {
+	exit3 := newCommandError("sh -c 'exit 3'", exec.Command("sh", "-c", "exit 3").Run(), nil)
+	killed := newCommandError("sh -c 'kill -TERM $$'", exec.Command("sh", "-c", "kill -TERM $$").Run(), nil)
+
+	require.Equal(t, 0, ExitCode(nil))
+	require.Equal(t, 1, ExitCode(errors.New("foo")))
+	require.Equal(t, 3, ExitCode(exit3))
+	require.Equal(t, 3, ExitCode(fmt.Errorf("task 'lint' failed: %w", exit3)))
+	require.Equal(t, 143, ExitCode(killed))
+}
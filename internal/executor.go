@@ -1,14 +1,27 @@
 package internal
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/theckman/yacspin"
+	"golang.org/x/text/encoding"
 )
 
+func init() {
+	RegisterCapability("output.streaming")
+}
+
 // This represent the default task, so when the user
 // doesn't provide any args to the program, we default to this.
 const DefaultTask = "main"
@@ -31,262 +44,1642 @@ var spinnerCfg = yacspin.Config{
 type Executor struct {
 	parser   Parser
 	lockfile Lockfile
+	history  History
 	spinner  *yacspin.Spinner
+	term     *terminal
+	suffix   string
 	options  Options
+	resolved map[string]bool
+
+	// Variables given for the invocation, ie. by a batch plan step.
+	envOverrides map[string]string
+
+	// Only set while a task with "restart" runs in watch mode.
+	processes *processes
+
+	// Describes this run in the reports, ie. of --serve-status.
+	metadata RunMetadata
+
+	// Runs the system commands instead of executing them, when set.
+	runner CommandRunner
+
+	// Set for the executors of subprojects: the prefix of their output,
+	// and the directories of the projects running them, see runSubproject.
+	outputPrefix string
+	parents      []string
+
+	// Receives the output of the commands instead of the terminal when set,
+	// see dispatchReferenced.
+	outputTo io.Writer
+
+	// Cancels the run, see StartContext.
+	ctx context.Context
+
+	// Receives the output of every command with --capture-dir.
+	capture *commandCapture
+
+	// How deeply the printed commands of --dry-run are nested.
+	dryRunDepth int
+
+	// The tasks and dependencies which succeeded, and the given tasks from
+	// the one which failed on, for the prompt after a failed run, see rerun.
+	completed     map[string]bool
+	failed        []string
+	skipCompleted bool
+	prompt        *rerunPrompt
+
+	// Asks which task to run without a main task, see pickTask.
+	picker *taskPicker
+
+	// The --deadline of the invocation, nil without one.
+	deadline *runDeadline
+
+	// Forwards Ctrl-C to the running commands, nil in tests.
+	interrupt *runInterrupt
+
+	// The files which changed according to --since, nil without it. Git
+	// is replaced in tests.
+	since *sinceFilter
+	git   gitFunc
+
+	// The changed files of the tasks, for {CHANGED_FILES_FILE}.
+	changed *changedFiles
+
+	// The referenced tasks with restart running in the background, nil in
+	// tests, where they run like other tasks.
+	services *services
+
+	// Creates the timers of the commands' timeouts, see runInGroup.
+	newTimer timerFunc
+
+	// Creates the timers reporting silent commands, see watchStall.
+	newStallTimer timerFunc
+
+	// The progress across all the commands of the given tasks, nil when it
+	// isn't tracked, ie. with --jobs. The tasks being dispatched, from the
+	// given one to the innermost referenced one, label the steps.
+	progress  *runProgress
+	taskChain []string
+
+	// What the run did, for --summary-line, nil without it.
+	report *runReport
+
+	// The commands whose output is discarded with --discard-quiet-commands,
+	// nil without it.
+	quietCommands *quietCommands
+}
+
+// Runs the system commands of tasks. Goke executes them unless another
+// runner is set, ie. one recording them in tests, see NewInMemoryEnv.
+type CommandRunner interface {
+	Run(cmd *exec.Cmd) error
 }
 
 // Executor constructor.
-func NewExecutor(p *Parser, l *Lockfile, opts *Options) Executor {
-	spinner, _ := yacspin.New(spinnerCfg)
+func NewExecutor(p *Parser, l *Lockfile, h *History, opts *Options, meta RunMetadata) Executor {
+	term := newTerminal()
+	spinner, _ := yacspin.New(newSpinnerConfig(term.plain))
 
 	return Executor{
 		parser:   *p,
 		lockfile: *l,
+		history:  *h,
 		spinner:  spinner,
+		term:     term,
 		options:  *opts,
+		metadata: meta,
+		changed:  newChangedFiles(),
+		services: newServices(),
 	}
 }
 
-// Starts the command for a single run or as a watcher.
-func (e *Executor) Start(taskName string) {
-	arg := DefaultTask
-	if taskName != "" {
-		arg = taskName
+// Starts the given tasks in order, or the main task if none are given.
+// Multiple tasks run one after the other and stop at the first failure,
+// unless --jobs runs them concurrently, see executeJobs. Only a single task
+// can be watched. The error which failed the run is reported, then
+// returned, and goke exits with its ExitCode.
+func (e *Executor) Start(taskNames []string) error {
+	return e.StartContext(context.Background(), taskNames)
+}
+
+// Same as Start, but cancelling the context kills the running commands
+// and stops watching, like Ctrl-C does. Failed interactive runs offer to
+// rerun the tasks, see askRerun. With --deadline, the context expires at
+// the deadline, see runDeadline. Ctrl-C and SIGTERM stop the running
+// commands and fail the run with an InterruptedError, see runInterrupt.
+// Interactive runs without tasks nor a main task ask which task to run, see
+// pickTask.
+func (e *Executor) StartContext(ctx context.Context, taskNames []string) error {
+	taskNames, picked := e.pickTask(taskNames)
+	if !picked {
+		return nil
 	}
 
-	if e.options.Watch {
-		e.watch(arg)
-	} else {
-		if err := e.execute(arg); err != nil {
-			e.logErr(err)
+	if e.options.Deadline > 0 && e.deadline == nil {
+		e.deadline = newRunDeadline(e.options.Deadline, time.Now, newRealTimer)
+	}
+
+	if e.deadline != nil {
+		defer e.deadline.stop()
+
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.deadline.remaining())
+		defer cancel()
+	}
+
+	e.ctx = ctx
+	defer e.services.stopAll()
+
+	if e.options.SummaryLine != "" && e.report == nil {
+		e.report = newRunReport(e.summaryLabel(taskNames), time.Now)
+	}
+
+	if e.interrupt == nil {
+		e.interrupt = newRunInterrupt()
+	}
+
+	// Watch sessions handle signals themselves, see watchLoop.
+	if !e.options.Watch {
+		defer e.interrupt.notify()()
+	}
+
+	err := e.interrupted(e.start(taskNames))
+	for err != nil {
+		e.reportErr(err)
+
+		choice := e.askRerun()
+		if choice == rerunQuit {
+			break
 		}
+
+		err = e.interrupted(e.rerun(taskNames, choice))
+	}
+
+	e.writeSummaryLine(err)
+	return err
+}
+
+// Replaces the error of the run with an InterruptedError once it was
+// interrupted, even when it otherwise succeeded.
+func (e *Executor) interrupted(err error) error {
+	if sig := e.interrupt.received(); sig != nil && !e.options.Watch {
+		return &InterruptedError{Signal: sig, Err: err}
 	}
+
+	return err
 }
 
-// Executes all command strings under given taskName.
-// Each call happens in its own go routine.
-func (e *Executor) execute(taskName string) error {
-	task := e.initTask(taskName)
-	didDispatch, err := e.checkAndDispatch(task)
+func (e *Executor) start(taskNames []string) error {
+	if e.options.List {
+		e.listTasks(os.Stdout)
+		return nil
+	}
+
+	if e.options.Batch != "" && len(taskNames) > 0 {
+		return errors.New("--batch does not accept task names, list them in the plan")
+	}
+
+	if e.options.Bare && e.options.Watch {
+		return errors.New("--bare cannot be combined with --watch")
+	}
+
+	if err := validateHup(e.options.Hup); err != nil {
+		return err
+	}
+
+	if e.options.CaptureDir != "" {
+		e.capture = newCommandCapture(e.options.CaptureDir, e.options.Quiet, e.options.Bare)
+		defer e.writeCaptureIndex()
+	}
+
+	if e.options.DiscardQuiet {
+		e.quietCommands = newQuietCommands()
+	}
+
+	if e.options.Tag != "" {
+		tagged, err := e.taggedTasks(taskNames)
+		if err != nil {
+			return err
+		}
+		taskNames = tagged
+	}
+
+	if len(taskNames) == 0 {
+		if _, ok := e.parser.Tasks[DefaultTask]; !ok && e.options.Batch == "" {
+			return e.parser.missingDefaultTask()
+		}
+
+		taskNames = []string{DefaultTask}
+	}
+
+	if err := e.checkExtraArgs(taskNames); err != nil {
+		return err
+	}
+
+	if err := e.checkParams(taskNames); err != nil {
+		return err
+	}
+
+	e.printBareBanner()
+
+	var err error
+	switch {
+	case e.options.Batch != "":
+		err = e.executePlan(e.options.Batch)
+	case e.options.ServeStatus != "" && !e.options.Watch:
+		err = errors.New("--serve-status requires --watch")
+	case e.options.Watch && e.options.DryRun:
+		err = errors.New("--dry-run cannot be combined with --watch")
+	case e.options.Watch && len(taskNames) > 1:
+		err = errors.New("--watch accepts a single task")
+	case e.options.Watch && e.options.Since != "":
+		err = errors.New("--since cannot be combined with --watch")
+	case e.options.Watch && e.options.SummaryLine != "":
+		err = errors.New("--summary-line cannot be combined with --watch")
+	case e.options.Watch:
+		err = e.watch(taskNames[0])
+	default:
+		err = e.execute(taskNames)
+	}
+
+	return err
+}
 
+// Executes the given tasks in order. The commands of each task
+// happen in their own go routines.
+func (e *Executor) execute(taskNames []string) error {
+	didDispatch, err := e.executeTasks(taskNames)
 	if err != nil {
 		return err
 	}
 
+	message := "Done!"
 	if !didDispatch {
-		e.logExit("success", "Nothing to run")
+		message = "Nothing to run"
 	}
 
-	e.spinner.StopMessage("Done!")
-	e.spinner.Stop()
+	if !e.options.Quiet {
+		e.spinner.StopMessage(message)
+		e.spinner.Stop()
+	}
 
 	return nil
 }
 
-// Begins an infinite loop that watches for the file changes
-// in the "files" section of the task's configuration.
-func (e *Executor) watch(taskName string) {
-	task := e.initTask(taskName)
-	wait := make(chan struct{})
+// Same as execute, but it only reports whether any task was dispatched.
+func (e *Executor) executeTasks(taskNames []string) (bool, error) {
+	if err := e.initSince(); err != nil {
+		return false, err
+	}
 
-	for {
-		go func(ch chan struct{}) {
-			e.checkAndDispatch(task)
-			e.spinner.Message("Watching for file changes...")
+	pf := newPreflight(&e.parser)
+	for _, taskName := range taskNames {
+		task, err := e.parser.runnableTask(taskName)
+		if err != nil {
+			return false, err
+		}
 
-			time.Sleep(time.Second)
-			ch <- struct{}{}
-		}(wait)
+		if e.options.Preflight || task.Preflight {
+			pf.checkTask(task)
+		}
 
-		<-wait
+		if task.Every > 0 && !e.options.Quiet {
+			fmt.Fprintf(os.Stderr, "Warning: task '%s' has every: %s, which only applies in --watch mode\n", taskName, task.Every)
+		}
 	}
-}
 
-// Checks whether the task will be dispatched or not,
-// and then dispatches is true. Returns true if dispatched.
-func (e *Executor) checkAndDispatch(task Task) (bool, error) {
-	shouldDispatch, err := e.shouldDispatch(task)
-	if err != nil {
+	pf.logSkipped(e)
+	if err := pf.err(); err != nil {
 		return false, err
 	}
 
-	if shouldDispatch || e.options.Force {
-		if err := e.dispatchTask(task, true); err != nil {
+	if e.options.Jobs > 1 && len(taskNames) > 1 {
+		return e.executeJobs(taskNames)
+	}
+
+	didDispatch := false
+	ran := make(map[string]bool)
+
+	if !e.options.DryRun {
+		e.progress = newRunProgress(e.planSteps(taskNames))
+		defer func() { e.progress = nil }()
+	}
+
+	for i, taskName := range taskNames {
+		if err := e.context().Err(); err != nil {
+			return false, e.deadlineExceeded(err, taskNames, i)
+		}
+
+		if err := e.deadline.check(); err != nil {
+			return false, e.deadlineExceeded(err, taskNames, i)
+		}
+
+		if err := e.interrupt.check(); err != nil {
+			return false, err
+		}
+
+		task, err := e.initTask(taskName)
+		if err != nil {
+			return false, err
+		}
+
+		if len(taskNames) > 1 && !e.options.Quiet {
+			e.spinnerSuffix(fmt.Sprintf(" %d/%d %s", i+1, len(taskNames), taskName))
+		}
+
+		dispatched, err := e.runInvocation(task, ran)
+		if err != nil {
+			e.failed = taskNames[i:]
+		} else {
+			e.markCompleted(taskName)
+		}
+
+		var exceeded *DeadlineExceededError
+		if err = e.deadlineExceeded(err, taskNames, i); errors.As(err, &exceeded) {
+			return false, err
+		}
+
+		if err != nil && len(taskNames) > 1 {
+			return false, fmt.Errorf("task '%s' failed: %w", taskName, err)
+		}
+
+		if err != nil {
 			return false, err
 		}
+
+		didDispatch = didDispatch || dispatched
 	}
 
-	return (shouldDispatch || e.options.Force), nil
+	return didDispatch, nil
 }
 
-// Fetch the task from the parser based on task name.
-func (e *Executor) initTask(taskName string) Task {
-	if !e.options.Quiet {
-		e.spinner.Start()
+// Runs the steps of the plan file in order, then prints a summary of all
+// steps. A failing step stops the plan, unless it may continue on error.
+func (e *Executor) executePlan(path string) error {
+	plan, err := ReadPlan(path)
+	if err != nil {
+		return err
 	}
 
-	e.mustExist(taskName)
-	return e.parser.Tasks[taskName]
-}
+	if err := plan.validate(e.parser.Tasks); err != nil {
+		return err
+	}
 
-// Checks whether files have changed since the last run.
-// Also updates the lockfile if files did get modified.
-// If no "files" key is present in the task, simply returns true.
-func (e *Executor) shouldDispatch(task Task) (bool, error) {
-	if len(task.Files) == 0 {
-		return true, nil
+	results := make([]planResult, len(plan.Steps))
+	ran := make(map[string]bool)
+	var failure error
+
+	for i, step := range plan.Steps {
+		results[i] = planResult{step: step, result: "not run"}
+		if failure != nil {
+			continue
+		}
+
+		task, err := e.initTask(step.Task)
+		if err != nil {
+			return err
+		}
+
+		if !e.options.Quiet {
+			e.spinnerSuffix(fmt.Sprintf(" %d/%d %s", i+1, len(plan.Steps), step.taskString()))
+		}
+
+		e.envOverrides = step.Env
+		e.options.Params = map[string]map[string]string{step.Task: step.Params}
+		start := time.Now()
+		dispatched, err := e.runInvocation(task, ran)
+		e.envOverrides = nil
+		e.options.Params = nil
+
+		results[i].duration = time.Since(start)
+
+		switch {
+		case err != nil && step.ContinueOnError:
+			results[i].result = "failed (ignored)"
+		case err != nil:
+			results[i].result = "failed"
+			failure = fmt.Errorf("step %d (%s) failed: %w", i+1, step.Task, err)
+		case !dispatched:
+			results[i].result = "up to date"
+		default:
+			results[i].result = "success"
+		}
 	}
 
-	dispatchCh := make(chan Ref[bool])
-	go e.shouldDispatchRoutine(task, dispatchCh)
-	dispatch := <-dispatchCh
+	if !e.options.Quiet {
+		if failure != nil {
+			e.spinner.StopFailMessage("Failed")
+			e.spinner.StopFail()
+		} else {
+			e.spinner.StopMessage("Done!")
+			e.spinner.Stop()
+		}
 
-	if dispatch.Error() != nil {
-		return false, dispatch.Error()
+		printPlanSummary(os.Stdout, results)
 	}
 
-	if dispatch.Value() {
-		e.lockfile.UpdateTimestampsForFiles(task.Files)
+	if failure != nil {
+		return reportedError{failure}
 	}
 
-	return dispatch.Value(), nil
+	return nil
 }
 
-// Go Routine function that determines whether the stored
-// mtime is greater  than mtime if the file at this moment.
-func (e *Executor) shouldDispatchRoutine(task Task, ch chan Ref[bool]) {
-	lockedModTimes := e.lockfile.GetCurrentProject()
+// Runs one of the tasks given for the invocation. A task given twice
+// runs twice, even if its files didn't change in between.
+func (e *Executor) runInvocation(task Task, ran map[string]bool) (bool, error) {
+	defer func() { ran[task.Name] = true }()
 
-	for _, f := range task.Files {
-		fo, err := os.Stat(f)
-		if err != nil {
-			ch <- NewRef(false, err)
+	if ran[task.Name] {
+		if holds, err := e.conditionHolds(task); !holds || err != nil {
+			e.skipSteps(task, make(map[string]bool), true)
+			return false, err
 		}
 
-		modTimeNow := fo.ModTime().Unix()
-		if lockedModTimes[f] < modTimeNow {
-			ch <- NewRef(true, nil)
-			return
-		}
+		return true, e.runTask(task)
 	}
 
-	ch <- NewRef(false, nil)
+	return e.checkAndDispatch(task)
 }
 
-// Dispatches the individual commands of the current task,
-// including any events that need to be run.
-func (e *Executor) dispatchTask(task Task, initialRun bool) error {
-	outputs := make(chan Ref[string])
+// Prints the tasks sorted by name, along with their descriptions.
+// In verbose mode, it also shows how stale each task is.
+func (e *Executor) listTasks(out io.Writer) {
+	rows := [][]string{}
+	now := time.Now()
 
-	if initialRun {
-		for _, beforeEachCmd := range e.parser.Global.Shared.Events.BeforeEachTask {
-			err := e.runSysOrRecurse(beforeEachCmd, &outputs)
+	// Neither the global events nor abstract tasks are listed. The tags
+	// column is only shown when there are any tags.
+	names := []string{}
+	tagged := false
+	for _, task := range e.parser.RunnableTasks() {
+		names = append(names, task.Name)
+		tagged = tagged || len(task.Tags) > 0
+	}
 
-			if err != nil {
-				return err
+	// The tasks of namespaced includes are listed after the other ones,
+	// under their namespace.
+	sort.SliceStable(names, func(i, j int) bool {
+		return e.parser.Tasks[names[i]].Namespace < e.parser.Tasks[names[j]].Namespace
+	})
+
+	namespace := ""
+	for _, name := range names {
+		task := e.parser.Tasks[name]
+		if task.Namespace != namespace {
+			namespace = task.Namespace
+			if len(rows) > 0 {
+				rows = append(rows, []string{})
 			}
+			rows = append(rows, []string{namespace + ":"})
 		}
-	}
 
-	for _, mainCmd := range task.Run {
-		if initialRun {
-			for _, beforeEachCmd := range e.parser.Global.Shared.Events.BeforeEachRun {
-				if err := e.runSysOrRecurse(beforeEachCmd, &outputs); err != nil {
-					return err
-				}
-			}
+		row := []string{name, task.Desc}
+		if namespace != "" {
+			row[0] = "  " + name
 		}
 
-		if err := e.runSysOrRecurse(mainCmd, &outputs); err != nil {
-			return err
+		if tagged {
+			tags := ""
+			if len(task.Tags) > 0 {
+				tags = "[" + strings.Join(task.Tags, ", ") + "]"
+			}
+			row = append(row, tags)
 		}
 
-		if initialRun {
-			for _, afterEachCmd := range e.parser.Global.Shared.Events.AfterEachRun {
-				if err := e.runSysOrRecurse(afterEachCmd, &outputs); err != nil {
-					return err
-				}
+		if e.options.Verbose {
+			staleness, err := e.history.Staleness(task)
+			if err != nil {
+				row = append(row, err.Error())
+			} else {
+				row = append(row, staleness.Format(now))
 			}
 		}
+
+		rows = append(rows, row)
 	}
 
-	for _, afterEachCmd := range e.parser.Global.Shared.Events.AfterEachTask {
-		if err := e.runSysOrRecurse(afterEachCmd, &outputs); err != nil {
-			return err
-		}
+	writeTable(out, rows)
+}
+
+// Returns the tasks tagged with --tag, in the order they are declared.
+func (e *Executor) taggedTasks(taskNames []string) ([]string, error) {
+	if len(taskNames) > 0 {
+		return nil, errors.New("--tag does not accept task names")
 	}
 
-	return nil
+	tagged := e.parser.tasksWithTag(e.options.Tag)
+	if len(tagged) == 0 {
+		return nil, fmt.Errorf("no task is tagged '%s'", e.options.Tag)
+	}
+
+	return tagged, nil
 }
 
-// Determine what to execute: system command or another declared task in goke.yml.
-func (e *Executor) runSysOrRecurse(cmd string, ch *chan Ref[string]) error {
-	if !e.options.Quiet {
-		e.spinner.Message(fmt.Sprintf("Running: %s", cmd))
+// Runs the task, then watches the files in the "files" section of its
+// configuration and reruns it whenever they change, until interrupted.
+// Ctrl-C, SIGTERM and SIGHUP are handled as described in watchLoop.
+func (e *Executor) watch(taskName string) error {
+	w, err := e.newWatch(taskName, nil)
+	if err != nil {
+		return err
 	}
+	defer w.close()
 
-	if _, ok := e.parser.Tasks[cmd]; ok {
-		return e.dispatchTask(e.parser.Tasks[cmd], false)
-	} else {
-		go e.runSysCommand(cmd, *ch)
-		output := <-*ch
+	signal.Notify(w.interrupt, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(w.interrupt)
 
-		if output.Error() != nil {
-			return output.Error()
-		}
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
 
-		if !e.options.Quiet {
-			fmt.Print(output.Value())
-		}
+	w.loop.hangup = hangup
+	if e.options.Hup == HupIgnore {
+		w.loop.detach = e.detach
+	}
+
+	if sig := w.loop.loop(); sig == syscall.SIGHUP && !e.options.Quiet {
+		fmt.Fprintf(os.Stderr, "Terminal closed, stopped watching (--hup=%s)\n", HupStop)
 	}
 
+	e.stopWatchSpinner()
 	return nil
 }
 
-// Executes the given string in the underlying OS.
-func (e *Executor) runSysCommand(c string, ch chan Ref[string]) {
-	splitCmd, err := ParseCommandLine(os.ExpandEnv(c))
+// A watch session which is set up, but not running yet, see newWatch.
+type watchRun struct {
+	loop watchLoop
+
+	// Receives the signals which stop the session. Cancelling the context
+	// of the executor sends SIGTERM.
+	interrupt chan os.Signal
+
+	// Release what the session holds, in reverse order, see close.
+	cleanup []func()
+}
+
+func (w *watchRun) close() {
+	for i := len(w.cleanup) - 1; i >= 0; i-- {
+		w.cleanup[i]()
+	}
+}
 
+// Sets up the watch session of the task, which is the same for the goke
+// command and for Watch: the file watcher, the schedule of the tasks, the
+// registration of the session and --serve-status. Events of the session
+// are sent to events, unless it's nil.
+func (e *Executor) newWatch(taskName string, events chan<- WatchEvent) (w *watchRun, err error) {
+	task, err := e.initTask(taskName)
 	if err != nil {
-		ch <- NewRef("", err)
-		return
+		return nil, err
 	}
 
-	out, err := exec.Command(splitCmd[0], splitCmd[1:]...).Output()
+	files, _ := e.parser.inputFiles(task)
+	patterns := e.parser.inputPatterns(task)
+	scheduled := e.parser.scheduledTasks(task)
+
+	if len(files) == 0 && len(patterns) == 0 && len(scheduled) == 0 {
+		return nil, fmt.Errorf("task '%s' has no files to watch", task.Name)
+	}
+
+	w = &watchRun{interrupt: make(chan os.Signal, 1)}
+	defer func() {
+		if err != nil {
+			w.close()
+		}
+	}()
+
+	unregister, err := registerWatchSession(e.watchSession(task, files), e.options.AllowMultipleWatch, e.options.Quiet)
 	if err != nil {
-		ch <- NewRef("", err)
-		return
+		return nil, err
+	}
+	w.cleanup = append(w.cleanup, unregister)
+
+	var changes <-chan struct{}
+
+	// Tasks without files only run on their schedule.
+	if len(files) > 0 || len(patterns) > 0 {
+		debounce := e.options.Debounce
+		if debounce <= 0 {
+			debounce = DefaultDebounce
+		}
+
+		watcher, err := newFileWatcher(files, patterns, debounce)
+		if err != nil {
+			return nil, err
+		}
+		w.cleanup = append(w.cleanup, func() { watcher.Close() })
+
+		changes = watcher.Changes()
+
+		go func() {
+			for err := range watcher.Errors() {
+				e.logVerbose(fmt.Sprintf("Watcher error: %s", err))
+			}
+		}()
+	}
+
+	cancelled := make(chan struct{})
+	w.cleanup = append(w.cleanup, func() { close(cancelled) })
+
+	go func() {
+		select {
+		case <-e.context().Done():
+			// Stops watching right away, unlike a first Ctrl-C.
+			select {
+			case w.interrupt <- syscall.SIGTERM:
+			case <-cancelled:
+			}
+		case <-cancelled:
+		}
+	}()
+
+	stopSchedule := make(chan struct{})
+	w.cleanup = append(w.cleanup, func() { close(stopSchedule) })
+
+	// The services referenced by the task keep running across its runs.
+	w.cleanup = append(w.cleanup, e.services.stopAll)
+
+	status := newWatchStatus(task.Name)
+	status.metadata = &e.metadata
+
+	if e.options.ServeStatus != "" {
+		srv, addr, err := serveStatus(e.options.ServeStatus, status, e.options.AllowRemoteTrigger)
+		if err != nil {
+			return nil, err
+		}
+		w.cleanup = append(w.cleanup, func() { shutdownStatus(srv) })
+
+		e.logVerbose(fmt.Sprintf("Serving status on http://%s/status", addr))
+	}
+
+	w.loop = watchLoop{
+		changes:   changes,
+		interrupt: w.interrupt,
+		status:    status,
+		ticks:     scheduleTicks(scheduled, newRealTicker, stopSchedule),
+		emit:      e.emitWatchEvent(events),
+		run: func(initial bool) (bool, error) {
+			return e.watchedRun(task, initial)
+		},
+		runScheduled: e.scheduledRun,
+		reset: func() {
+			e.interrupt.reset()
+			if task.Restart {
+				e.processes = newProcesses()
+			}
+		},
+		interruptRun: func(sig os.Signal) {
+			if !e.options.Quiet {
+				e.spinnerMessage("Interrupted, press Ctrl-C again to stop watching")
+			}
+			e.interrupt.fire(sig)
+		},
+		stop: func() {
+			if e.processes != nil {
+				e.processes.stop(restartGracePeriod)
+			}
+		},
 	}
 
-	ch <- NewRef("\n"+string(out)+"\n", nil)
+	return w, nil
 }
 
-func (e *Executor) mustExist(taskName string) {
-	if _, ok := e.parser.Tasks[taskName]; !ok {
-		e.logExit("error", fmt.Sprintf("Command '%s' not found\n", taskName))
+func (e *Executor) stopWatchSpinner() {
+	if !e.options.Quiet {
+		e.spinner.StopMessage("Stopped watching")
+		e.spinner.Stop()
 	}
 }
 
-// Shortcut to logging an error using spinner logger.
-func (e *Executor) logErr(err error) {
-	e.logExit("error", fmt.Sprintf("Error: %s\n", err.Error()))
+// A single run of the task in watch mode.
+func (e *Executor) watchedRun(task Task, initial bool) (bool, error) {
+	defer e.RecoverPanic()
+	defer e.spinnerMessage("Watching for file changes...")
+	defer e.writeCaptureIndex()
+
+	if initial {
+		return e.checkAndDispatch(task)
+	}
+
+	if holds, err := e.conditionHolds(task); !holds || err != nil {
+		return false, err
+	}
+
+	// The change itself is known, mtimes may be too coarse to tell.
+	files, _ := e.parser.inputFiles(task)
+	if err := e.lockfile.UpdateTimestampsForFiles(task.Name, files, task.followSymlinks(), e.parser.usesChecksum(task)); err != nil {
+		return false, err
+	}
+
+	return true, e.runTask(task)
 }
 
-// Log to the console using the spinner instance.
-func (e *Executor) logExit(status string, message string) {
-	switch status {
-	default:
-	case "success":
-		if !e.options.Quiet {
-			e.spinner.StopMessage(message)
-			e.spinner.Stop()
-		}
-		os.Exit(0)
-	case "error":
-		if !e.options.Quiet {
-			e.spinner.StopFailMessage(message)
-			e.spinner.StopFail()
-		}
-		os.Exit(1)
+// A run of a task on its schedule, regardless of whether its files changed.
+func (e *Executor) scheduledRun(taskName string) (bool, error) {
+	defer e.RecoverPanic()
+	defer e.spinnerMessage("Watching for file changes...")
+	defer e.writeCaptureIndex()
+
+	task := e.parser.Tasks[taskName]
+	if holds, err := e.conditionHolds(task); !holds || err != nil {
+		return false, err
+	}
+
+	if !e.options.Quiet {
+		e.spinnerMessage(fmt.Sprintf("Running %s (scheduled every %s)", taskName, task.Every))
+	}
+
+	return true, e.runTask(task)
+}
+
+// Checks whether the task will be dispatched or not,
+// and then dispatches is true. Returns true if dispatched.
+func (e *Executor) checkAndDispatch(task Task) (bool, error) {
+	if holds, err := e.conditionHolds(task); !holds || err != nil {
+		e.skipSteps(task, make(map[string]bool), true)
+		return false, err
+	}
+
+	shouldDispatch, err := e.shouldDispatch(task)
+	if err != nil {
+		return false, err
+	}
+
+	if !shouldDispatch && !e.options.Force {
+		if e.options.DryRun && e.since == nil {
+			e.printDryRun("%s: would skip: files unchanged", task.Name)
+		}
+
+		e.skipSteps(task, make(map[string]bool), true)
+		return false, nil
+	}
+
+	return true, e.runTask(task)
+}
+
+// Dispatches the task and records its successful run.
+func (e *Executor) runTask(task Task) error {
+	e.resolved = make(map[string]bool)
+
+	if err := e.dispatchTask(task, true); err != nil {
+		return err
+	}
+
+	if e.options.DryRun {
+		return nil
+	}
+
+	return e.history.RecordSuccess(task.Name, time.Now(), e.metadata)
+}
+
+// Fetch the task from the parser based on task name.
+func (e *Executor) initTask(taskName string) (Task, error) {
+	if !e.options.Quiet && !e.options.DryRun {
+		e.spinner.Start()
+	}
+
+	return e.parser.runnableTask(taskName)
+}
+
+// Checks whether files have changed since the last run, including files
+// which were created or deleted since. Also updates the lockfile if files
+// did get modified, except with --dry-run. If the task has no files to
+// check, simply returns true.
+func (e *Executor) shouldDispatch(task Task) (bool, error) {
+	if e.since != nil {
+		return e.changedSince(task)
+	}
+
+	files, origins := e.parser.inputFiles(task)
+	if len(files) == 0 {
+		return true, nil
+	}
+
+	changedCh := make(chan Ref[[]string])
+	go e.shouldDispatchRoutine(task.Name, files, e.parser.inputPatterns(task), task.followSymlinks(), e.parser.usesChecksum(task), changedCh)
+	changed := <-changedCh
+
+	if changed.Error() != nil {
+		return false, changed.Error()
+	}
+
+	e.changed.record(task.Name, changed.Value(), files)
+	if len(changed.Value()) == 0 {
+		return false, nil
+	}
+
+	first := changed.Value()[0]
+	if origin, ok := origins[first]; ok && origin != task.Name {
+		e.logVerbose(fmt.Sprintf("Changed: %s (from task '%s')", first, origin))
+	} else {
+		e.logVerbose(fmt.Sprintf("Changed: %s", first))
+	}
+
+	if !e.options.DryRun {
+		e.lockfile.UpdateTimestampsForFiles(task.Name, files, task.followSymlinks(), e.parser.usesChecksum(task))
+	}
+
+	return true, nil
+}
+
+// Go Routine function that compares the stored mtime, or with checksum the
+// stored hash, of each file with its state at the last run of the task.
+// Files which are not in the task's lockfile entry are new, and recorded
+// files which match the patterns but don't exist anymore were deleted.
+// Sends the changed files, the new and modified ones first, then the
+// deleted ones.
+func (e *Executor) shouldDispatchRoutine(task string, files []string, patterns []string, followSymlinks bool, checksum bool, ch chan Ref[[]string]) {
+	defer e.RecoverPanic()
+
+	lockedFiles := e.lockfile.GetTaskFiles(task)
+	current := make(map[string]bool, len(files))
+	changed := []string{}
+
+	for i, entry := range readFileEntries(e.lockfile.fs, files, followSymlinks, checksum) {
+		if entry.Error() != nil {
+			ch <- NewRef[[]string](nil, entry.Error())
+			return
+		}
+
+		recorded, ok := lockedFiles[files[i]]
+		if !ok || entry.Value().changedSince(recorded) {
+			changed = append(changed, files[i])
+		}
+
+		current[files[i]] = true
+	}
+
+	for _, f := range sortedKeys(lockedFiles) {
+		if !current[f] && !lockedFiles[f].Missing && matchesPatterns(patterns, f) {
+			changed = append(changed, f)
+		}
+	}
+
+	ch <- NewRef(changed, nil)
+}
+
+// Dispatches the individual commands of the current task,
+// including any events that need to be run. Failures of commands prefixed
+// with "-", or of any command when the task has continue_on_error, don't
+// abort the task. They are reported together once the task is done.
+// With --dry-run, the commands are printed under the task instead.
+func (e *Executor) dispatchTask(task Task, initialRun bool) (err error) {
+	defer func() {
+		if err != nil {
+			e.report.taskFailed(task.Name)
+		}
+	}()
+
+	if err := task.checkPlatform(); err != nil {
+		return err
+	}
+
+	e.report.taskRan(task.Name)
+	outputs := make(chan Ref[string])
+	env := e.taskEnv(task)
+	ignored := []error{}
+	e.logEnvConflicts(task)
+
+	defer func() {
+		if err != nil && e.deadline.hasExpired() {
+			e.runCleanupEvents(task, env)
+		}
+	}()
+
+	if e.options.DryRun {
+		defer e.beginDryRunTask(task.Name)()
+	}
+
+	e.taskChain = append(e.taskChain, task.Name)
+	defer func() { e.taskChain = e.taskChain[:len(e.taskChain)-1] }()
+
+	if err := e.resolveDeps(task); err != nil {
+		return err
+	}
+
+	defer func() { err = e.runOutcomeHooks(task, env, err) }()
+
+	events := e.taskEvents(task)
+
+	runHooks := func(events []EventEntry) error {
+		for _, ev := range events {
+			if !ev.appliesTo(task) {
+				continue
+			}
+
+			entry := e.parser.hookEntry(ev.Cmd)
+			entry.task = task.Name
+			err := e.runSysOrRecurse(entry, env, &outputs)
+
+			if err := e.ignoreFailure(err, entry.IgnoreError, &ignored); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	finish := func() error {
+		if err := runHooks(events.AfterEachTask); err != nil {
+			return err
+		}
+
+		e.logIgnored(task, ignored)
+		return nil
+	}
+
+	if initialRun {
+		if err := runHooks(events.BeforeEachTask); err != nil {
+			return err
+		}
+	}
+
+	// The commands of parallel tasks run as a single group, see runParallel.
+	if task.Parallel {
+		if initialRun {
+			if err := runHooks(events.BeforeEachRun); err != nil {
+				return err
+			}
+		}
+
+		if err := e.runParallel(task, env, &ignored); err != nil {
+			return err
+		}
+
+		if initialRun {
+			if err := runHooks(events.AfterEachRun); err != nil {
+				return err
+			}
+		}
+
+		return finish()
+	}
+
+	for _, entry := range task.Run {
+		if len(entry.Export) > 0 {
+			if err := e.exportVariables(entry.Export, env); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if initialRun {
+			if err := runHooks(events.BeforeEachRun); err != nil {
+				return err
+			}
+		}
+
+		err := e.runTaskCommand(task, entry, env, &outputs)
+		if err := e.ignoreFailure(err, entry.IgnoreError || task.ContinueOnError, &ignored); err != nil {
+			return err
+		}
+
+		if initialRun {
+			if err := runHooks(events.AfterEachRun); err != nil {
+				return err
+			}
+		}
+	}
+
+	return finish()
+}
+
+// Collects the error instead of returning it when the failure is ignored.
+func (e *Executor) ignoreFailure(err error, ignore bool, ignored *[]error) error {
+	if err == nil || !ignore {
+		return err
+	}
+
+	*ignored = append(*ignored, err)
+	return nil
+}
+
+// Reports the ignored failures of a task, one line per failed command.
+func (e *Executor) logIgnored(task Task, ignored []error) {
+	if len(ignored) == 0 || e.options.Quiet {
+		return
+	}
+
+	fmt.Fprintf(os.Stderr, "Task '%s' ignored %d failed command(s):\n", task.Name, len(ignored))
+	for _, err := range ignored {
+		message, _, _ := strings.Cut(err.Error(), "\n")
+		fmt.Fprintf(os.Stderr, "  %s\n", message)
+	}
+}
+
+// Runs the dependencies of the task depth first. Each dependency runs at most
+// once per invocation, and is skipped if its files did not change.
+// Cycles are already rejected by the parser.
+func (e *Executor) resolveDeps(task Task) error {
+	if e.resolved == nil {
+		e.resolved = make(map[string]bool)
+	}
+
+	for _, dep := range task.Deps {
+		if e.resolved[dep] || e.skipCompleted && e.completed[dep] {
+			continue
+		}
+
+		e.resolved[dep] = true
+		depTask := e.parser.Tasks[dep]
+
+		if holds, err := e.conditionHolds(depTask); !holds || err != nil {
+			if err != nil {
+				return err
+			}
+
+			e.skipSteps(depTask, copyResolved(e.resolved), false)
+			continue
+		}
+
+		shouldDispatch, err := e.shouldDispatch(depTask)
+		if err != nil {
+			return err
+		}
+
+		if !shouldDispatch && !e.options.Force {
+			if e.options.DryRun && e.since == nil {
+				e.printDryRun("%s: would skip: files unchanged", dep)
+			}
+
+			e.skipSteps(depTask, copyResolved(e.resolved), false)
+			e.markCompleted(dep)
+			continue
+		}
+
+		if !e.options.Quiet {
+			e.spinnerMessage(fmt.Sprintf("Running dependency: %s", dep))
+		}
+
+		if err := e.dispatchTask(depTask, false); err != nil {
+			return err
+		}
+
+		e.markCompleted(dep)
+	}
+
+	return nil
+}
+
+// Builds the variables of the task's commands, following EnvPrecedence. They
+// are only passed to the commands, so that they don't leak into other tasks.
+func (e *Executor) taskEnv(task Task) map[string]string {
+	return e.envResolver(task).Vars()
+}
+
+// Resolves the exported variables against the current task environment and
+// adds them to it, so that they apply to all the subsequent commands and
+// events of the task. Values may reference earlier exports and use $(...),
+// which is left as is with --dry-run, where the exports are printed along
+// the commands.
+func (e *Executor) exportVariables(vars map[string]string, env map[string]string) error {
+	if !e.options.Quiet {
+		e.spinnerMessage(fmt.Sprintf("Exporting: %s", strings.Join(sortedKeys(vars), ", ")))
+	}
+
+	resolved := make(map[string]string, len(vars))
+
+	for k, v := range vars {
+		value, err := expandEnv(v, env)
+		if err != nil {
+			return fmt.Errorf("export %s: %w", k, err)
+		}
+
+		if !e.options.DryRun {
+			value, err = substituteSystemCmds(value, func(cmd string) (string, error) {
+				return e.runSubstitution(cmd, "", env)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		resolved[k] = value
+	}
+
+	for _, k := range sortedKeys(resolved) {
+		env[k] = resolved[k]
+
+		if e.options.DryRun {
+			e.printDryRun("export %s=%s", k, resolved[k])
+		}
+	}
+
+	return nil
+}
+
+// Runs a $(...) command substitution and returns its trimmed output.
+func (e *Executor) runSubstitution(c string, dir string, env map[string]string) (string, error) {
+	splitCmd, err := splitCommand(c)
+	if err != nil {
+		return "", err
+	}
+
+	cmd := exec.Command(splitCmd[0], splitCmd[1:]...)
+	cmd.Env = e.commandEnv(env)
+	cmd.Dir = dir
+
+	out, err := e.output(cmd)
+	if err != nil {
+		return "", newCommandError(c, err, nil)
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Runs one of the task's own commands, replacing the {FILES} placeholder.
+// Commands which grow too long get split into sequential batches.
+func (e *Executor) runTaskCommand(task Task, entry RunEntry, env map[string]string, ch *chan Ref[string]) error {
+	if entry.Goke != "" {
+		return e.runSubproject(entry)
+	}
+
+	if _, ok := e.parser.Tasks[entry.Cmd]; ok {
+		// Tasks which already ran as a dependency are not repeated.
+		if e.resolved[entry.Cmd] && !e.options.Force {
+			return nil
+		}
+
+		return e.runSysOrRecurse(entry, env, ch)
+	}
+
+	entry, cleanup, err := e.changedFilesFile(task, entry)
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	batches, err := e.commandBatches(task, entry)
+	if err != nil {
+		return err
+	}
+
+	if e.progress != nil {
+		if len(batches) == 0 {
+			e.progress.skip(1)
+		}
+		e.progress.grow(len(batches) - 1)
+	}
+
+	errs := []error{}
+	for _, batch := range batches {
+		errs = append(errs, e.runSysOrRecurse(batch, env, ch))
+	}
+
+	return worstError(errs)
+}
+
+// Splits a command of the task into batches when {FILES} is too long, see
+// batchCommand. The entries inherit the settings of the task. Commands with
+// {STAGED_FILES} get the staged files of the task instead, and don't run
+// when there are none.
+func (e *Executor) commandBatches(task Task, entry RunEntry) ([]RunEntry, error) {
+	if entry.Dir == "" {
+		entry.Dir = task.Dir
+	}
+
+	// The patterns are expanded again, like when deciding whether the task
+	// runs, so that files created since parsing are passed too.
+	files := e.parser.taskFiles(task)
+	if strings.Contains(entry.Cmd, StagedFilesPlaceholder) {
+		staged, err := e.stagedTaskFiles(task)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(staged) == 0 {
+			e.logVerbose(fmt.Sprintf("No staged files, skipping: %s", entry.Cmd))
+			return nil, nil
+		}
+
+		files = staged
+		entry.Cmd = strings.Replace(entry.Cmd, StagedFilesPlaceholder, FilesPlaceholder, -1)
+	}
+
+	batches, err := batchCommand(entry.Cmd, relativeTo(entry.Dir, files), maxCommandLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(batches) > 1 {
+		e.logVerbose(fmt.Sprintf("Split into %d batches: %s", len(batches), entry.Cmd))
+	}
+
+	entries := make([]RunEntry, len(batches))
+	for i, batch := range batches {
+		entries[i] = entry
+		entries[i].Cmd = batch
+		entries[i].outputEncoding = task.OutputEncoding
+		entries[i].shell = e.parser.usesShell(task)
+		entries[i].windowsShell = e.parser.Global.Shared.WindowsShell
+		entries[i].timeout = e.parser.commandTimeout(task)
+		entries[i].retries = task.Retries
+		entries[i].retryDelay = task.RetryDelay
+		entries[i].retryBackoff = task.RetryBackoff
+		entries[i].interactive = task.Interactive
+		entries[i].task = task.Name
+	}
+
+	return entries, nil
+}
+
+// Determine what to execute: system command or another declared task in goke.yml.
+// Referenced tasks start with a clean task environment, so exports never leak.
+func (e *Executor) runSysOrRecurse(entry RunEntry, env map[string]string, ch *chan Ref[string]) error {
+	cmd := entry.Cmd
+
+	if task, ok := e.parser.Tasks[cmd]; ok {
+		if !e.options.Quiet {
+			e.spinnerMessage(fmt.Sprintf("Running: %s", cmd))
+		}
+
+		if holds, err := e.conditionHolds(task); !holds || err != nil {
+			e.skipSteps(task, copyResolved(e.resolved), false)
+			return err
+		}
+
+		if task.Restart && e.services != nil && !e.options.DryRun {
+			return e.startService(task)
+		}
+
+		return e.dispatchReferenced(entry, task)
+	} else if e.options.DryRun {
+		return e.printDryRunCommand(entry, env)
+	}
+
+	e.reportStep(entry.label())
+	e.report.commandsRan(1)
+
+	if entry.Golden != "" {
+		return e.runGolden(entry, env)
+	}
+
+	return e.withRetries(entry, func() error {
+		go e.runSysCommand(entry, env, *ch)
+		output := <-*ch
+
+		if !e.options.Quiet {
+			e.printOutput(entry, output.Value())
+		}
+
+		return output.Error()
+	})
+}
+
+// Prints the output of a command, rendering it as a diff if requested.
+func (e *Executor) printOutput(entry RunEntry, out string) {
+	if entry.DiffOutput {
+		out = colorizeDiff(out)
+	}
+
+	if trimmed := strings.TrimRight(out, "\n"); e.outputPrefix != "" && trimmed != "" {
+		out = prefixLines(trimmed, e.outputPrefix)
+	}
+
+	fmt.Fprint(e.stdout(), out)
+}
+
+// Executes the given entry's command in the underlying OS. Its stdout and
+// stderr are streamed live, unless running quietly or the output is a diff,
+// in which case the buffered stdout is sent back over the channel and the
+// buffered stderr ends up in the CommandError. With --capture-dir, both are
+// also written to the files of the command. Commands discarding their output
+// skip all of it, see runDiscarded.
+func (e *Executor) runSysCommand(entry RunEntry, env map[string]string, ch chan Ref[string]) {
+	defer e.RecoverPanic()
+
+	p, err := e.prepareCommand(entry, env)
+	if err != nil {
+		ch <- NewRef("", err)
+		return
+	}
+
+	if p.builtin == nil && e.discardsOutput(entry) {
+		ch <- e.runDiscarded(p, entry)
+		return
+	}
+
+	captured := e.capture.begin(entry.task, p.line)
+
+	var result Ref[string]
+	if p.builtin != nil {
+		result = e.runBuiltin(p.builtin, p.args, entry, p.line, captured)
+	} else {
+		result = e.runProcess(p, entry, captured)
+	}
+
+	captured.finish(result.Error())
+	ch <- result
+}
+
+// Runs the prepared system command, see runSysCommand.
+func (e *Executor) runProcess(p *preparedCommand, entry RunEntry, captured *commandOutput) (result Ref[string]) {
+	c, cmd, enc := p.line, p.cmd, p.enc
+
+	stall := e.watchStall(entry, c)
+	defer stall.end()
+
+	if e.quietCommands != nil {
+		started := time.Now()
+		defer func() { e.quietCommands.record(entry, result.Error(), time.Since(started), stall) }()
+	}
+
+	if e.options.Quiet || entry.DiffOutput {
+		out, err := e.capturedOutput(cmd, captured, stall, entry.timeout)
+		err = newCommandError(c, err, enc)
+
+		if err != nil && len(out) == 0 {
+			return NewRef("", err)
+		}
+
+		return NewRef("\n"+string(decodeOutput(out, enc))+"\n", err)
+	}
+
+	stdout, stderr := e.outputWriters(enc)
+	cmd.Stdout = stall.watch(captured.teeStdout(stdout))
+	cmd.Stderr = stall.watch(captured.teeStderr(stderr))
+
+	err := e.runFor(cmd, entry.timeout)
+	_ = stdout.Flush()
+	_ = stderr.Flush()
+
+	return NewRef("", newCommandError(c, err, enc))
+}
+
+// The command of a run entry, ready to run. Either cmd or builtin is set.
+type preparedCommand struct {
+	// The command line, with the variables expanded.
+	line    string
+	cmd     *exec.Cmd
+	builtin builtin
+	args    []string
+	enc     encoding.Encoding
+}
+
+// The command line of the entry, with its $(...) commands replaced by their
+// output when substitute is set, then its variables expanded and the params,
+// the placeholders of the config and {ARGS} replaced, in this order. Only the
+// commands written in the config run: params, arguments and the values of
+// variables are never substituted nor expanded.
+func (e *Executor) commandLine(entry RunEntry, env map[string]string, substitute bool) (string, error) {
+	line := entry.Cmd
+
+	if substitute {
+		var err error
+		line, err = substituteSystemCmds(line, func(cmd string) (string, error) {
+			cmd, err := e.expandEnv(cmd, env)
+			if err != nil {
+				return "", err
+			}
+
+			return e.runSubstitution(cmd, entry.Dir, env)
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	line, err := e.expandEnv(line, env)
+	if err != nil {
+		return "", err
+	}
+
+	line, err = e.parser.replacePlaceholders(replaceParams(line, e.taskParams(entry.task)), env)
+	if err != nil {
+		return "", err
+	}
+
+	return replaceArgs(line, e.commandArgs()), nil
+}
+
+// Returns the variables of the entry's command: the ones of the task, with
+// the entry's own env on top. Its values may refer to the task's variables.
+func (r RunEntry) commandVars(env map[string]string) (map[string]string, error) {
+	if len(r.Env) == 0 {
+		return env, nil
+	}
+
+	vars := make(map[string]string, len(env)+len(r.Env))
+	for k, v := range env {
+		vars[k] = v
+	}
+
+	for _, k := range sortedKeys(r.Env) {
+		value, err := expandEnv(r.Env[k], env)
+		if err != nil {
+			return nil, fmt.Errorf("env %s: %w", k, err)
+		}
+		vars[k] = value
+	}
+
+	return vars, nil
+}
+
+// The name of the entry when it has one, or else its command.
+func (r RunEntry) label() string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	return r.Cmd
+}
+
+// Expands the variables of the entry's command and splits it. Built-ins never
+// run through the shell, not even with shell: true.
+func (e *Executor) prepareCommand(entry RunEntry, env map[string]string) (*preparedCommand, error) {
+	env, err := entry.commandVars(env)
+	if err != nil {
+		return nil, err
+	}
+
+	// Shells substitute the commands themselves.
+	line, err := e.commandLine(entry, env, !entry.shell)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &preparedCommand{line: line}
+
+	splitCmd, err := splitCommand(p.line)
+
+	if err == nil {
+		b, ok, err := lookupBuiltin(splitCmd[0])
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			p.builtin, p.args = b, splitCmd[1:]
+			return p, nil
+		}
+	}
+
+	if entry.shell {
+		splitCmd, err = shellCommand(p.line, entry.windowsShell), nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	p.enc, err = lookupEncoding(entry.outputEncoding)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cmd = exec.CommandContext(e.context(), splitCmd[0], splitCmd[1:]...)
+	p.cmd.Env = e.commandEnv(env)
+	for _, k := range sortedKeys(entry.processEnv) {
+		p.cmd.Env = append(p.cmd.Env, k+"="+entry.processEnv[k])
+	}
+	p.cmd.Dir = entry.Dir
+
+	if entry.interactive {
+		p.cmd.Stdin = os.Stdin
+	}
+
+	return p, nil
+}
+
+// Runs a built-in command, its output is handled like the one of system commands.
+func (e *Executor) runBuiltin(b builtin, args []string, entry RunEntry, c string, captured *commandOutput) Ref[string] {
+	if e.options.Quiet || entry.DiffOutput {
+		out := bytes.Buffer{}
+		err := newCommandError(c, b(args, entry.Dir, captured.teeStdout(&out)), nil)
+
+		if err != nil && out.Len() == 0 {
+			return NewRef("", err)
+		}
+
+		return NewRef("\n"+out.String()+"\n", err)
+	}
+
+	stdout, _ := e.outputWriters(nil)
+	err := b(args, entry.Dir, captured.teeStdout(stdout))
+	_ = stdout.Flush()
+
+	return NewRef("", newCommandError(c, err, nil))
+}
+
+// The context of the run, which is never cancelled unless set by StartContext.
+func (e *Executor) context() context.Context {
+	if e.ctx == nil {
+		return context.Background()
+	}
+
+	return e.ctx
+}
+
+// Writes the index.json of --capture-dir, see commandCapture.
+func (e *Executor) writeCaptureIndex() {
+	if err := e.capture.writeIndex(); err != nil && !e.options.Quiet {
+		fmt.Fprintf(os.Stderr, "Warning: could not write the index of the captured output: %s\n", err)
+	}
+}
+
+// Creates the writers streaming the output of a command to the terminal, or
+// to outputTo when set, see newOutputWriters.
+func (e *Executor) outputWriters(enc encoding.Encoding) (*spinnerWriter, *spinnerWriter) {
+	stdout, stderr := newOutputWriters(e.spinner, enc)
+	stdout.prefix, stderr.prefix = e.outputPrefix, e.outputPrefix
+
+	if e.outputTo != nil {
+		stdout.out, stderr.out = e.outputTo, e.outputTo
+		stdout.erase, stderr.erase = false, false
+	}
+
+	return stdout, stderr
+}
+
+// Runs the command, keeping track of it when the task restarts in watch mode.
+func (e *Executor) run(cmd *exec.Cmd) error {
+	return e.runFor(cmd, 0)
+}
+
+// Same as run, but the command stops after the timeout, see runInGroup.
+func (e *Executor) runFor(cmd *exec.Cmd, timeout time.Duration) error {
+	group := e.processes
+	if group == nil {
+		group = newProcesses()
+	}
+
+	return e.runInGroup(group, cmd, timeout)
+}
+
+// Same as exec.Cmd.Output, but it keeps track of the command like run does.
+func (e *Executor) output(cmd *exec.Cmd) ([]byte, error) {
+	return e.capturedOutput(cmd, nil, nil, 0)
+}
+
+// Same as output, but stdout and stderr are also written to the files of
+// the command, see commandCapture, watched for output, see watchStall, and
+// it stops after the timeout.
+func (e *Executor) capturedOutput(cmd *exec.Cmd, captured *commandOutput, stall *stallWatch, timeout time.Duration) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = stall.watch(captured.teeStdout(&stdout))
+	cmd.Stderr = stall.watch(captured.teeStderr(&stderr))
+
+	err := e.runFor(cmd, timeout)
+
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		exitErr.Stderr = stderr.Bytes()
+	}
+
+	return stdout.Bytes(), err
+}
+
+// Updates the spinner message, truncated to fit the terminal.
+func (e *Executor) spinnerMessage(message string) {
+	if e.term != nil {
+		message = e.term.fit(message, e.suffix, e.options.Verbose)
+	}
+
+	e.spinner.Message(message)
+}
+
+// Updates the spinner suffix, which is taken into account to fit messages.
+func (e *Executor) spinnerSuffix(suffix string) {
+	e.suffix = suffix
+	e.spinner.Suffix(suffix)
+}
+
+// Prints the message only when running in verbose mode.
+func (e *Executor) logVerbose(message string) {
+	if e.options.Verbose && !e.options.Quiet {
+		fmt.Println(message)
+	}
+}
+
+// Reports the error using the spinner. Only the first line of the error
+// goes into the spinner, the rest (ie. the stderr of a failed command) is
+// printed verbatim below it. Errors happening before the spinner started,
+// ie. invalid flags, are printed on their own.
+func (e *Executor) reportErr(err error) {
+	var reported reportedError
+	if e.options.Quiet || errors.As(err, &reported) {
+		return
+	}
+
+	message, details, _ := strings.Cut(err.Error(), "\n")
+	if details == "" {
+		message += "\n"
+	}
+
+	message = "Error: " + message
+
+	var interrupted *InterruptedError
+	if errors.As(err, &interrupted) {
+		message, details = "Interrupted\n", ""
+	}
+
+	e.spinner.StopFailMessage(message)
+	if e.spinner.StopFail() != nil {
+		fmt.Fprint(os.Stderr, message)
+	}
+
+	if details != "" {
+		fmt.Fprintln(os.Stderr, details)
 	}
 }
@@ -1 +1,589 @@
 package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	iofs "io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dugajean/goke/internal/tests"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestExecutor(t *testing.T, config string) Executor {
+	fsMock := mockCacheDoesNotExist(t)
+	fsMock.On("WriteFile", mock.Anything, mock.Anything, mock.Anything).Return(nil).Maybe()
+	fsMock.On("Rename", mock.Anything, mock.Anything).Return(nil).Maybe()
+	parser := NewParser(config, &clearCacheOpts, fsMock)
+	require.Nil(t, parser.parseTasks())
+
+	return Executor{
+		parser:  parser,
+		history: NewHistory(&clearCacheOpts, fsMock),
+		options: Options{Quiet: true},
+	}
+}
+
+func readOutputFile(t *testing.T, path string) string {
+	contents, err := os.ReadFile(path)
+	require.Nil(t, err)
+
+	return string(contents)
+}
+
+func TestDispatchTaskExportsVariables(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.txt")
+	config := fmt.Sprintf(`
+export-a:
+  run:
+    - export:
+        EXPORTED_FOO: "foo"
+    - "sh -c 'echo ${EXPORTED_FOO} >> %[1]s'"
+    - export:
+        EXPORTED_FOO: "${EXPORTED_FOO}-bar"
+        EXPORTED_GREETING: "$(echo hello)"
+    - "sh -c 'printenv EXPORTED_FOO EXPORTED_GREETING >> %[1]s'"
+`, out)
+
+	e := newTestExecutor(t, config)
+	err := e.dispatchTask(e.parser.Tasks["export-a"], true)
+
+	require.Nil(t, err)
+	require.Equal(t, "foo\nfoo-bar\nhello\n", readOutputFile(t, out))
+	require.Empty(t, os.Getenv("EXPORTED_FOO"))
+}
+
+func TestDispatchTaskExportsDoNotLeakAcrossTasks(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.txt")
+	config := fmt.Sprintf(`
+export-a:
+  run:
+    - export:
+        EXPORTED_BAZ: "baz"
+    - "export-b"
+    - "sh -c 'echo a:${EXPORTED_BAZ} >> %[1]s'"
+
+export-b:
+  run:
+    - "sh -c 'echo b:${EXPORTED_BAZ} >> %[1]s'"
+`, out)
+
+	e := newTestExecutor(t, config)
+
+	require.Nil(t, e.dispatchTask(e.parser.Tasks["export-a"], true))
+	require.Nil(t, e.dispatchTask(e.parser.Tasks["export-b"], true))
+	require.Equal(t, "b:\na:baz\nb:\n", readOutputFile(t, out))
+}
+
+func TestDispatchTaskRunsDepsOnce(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.txt")
+	config := fmt.Sprintf(`
+generate:
+  run:
+    - "sh -c 'echo generate >> %[1]s'"
+
+build:
+  deps: [generate]
+  run:
+    - "sh -c 'echo build >> %[1]s'"
+
+ci:
+  deps: [generate, build]
+  run:
+    - "build"
+    - "sh -c 'echo ci >> %[1]s'"
+`, out)
+
+	e := newTestExecutor(t, config)
+	err := e.dispatchTask(e.parser.Tasks["ci"], true)
+
+	require.Nil(t, err)
+	require.Equal(t, "generate\nbuild\nci\n", readOutputFile(t, out))
+}
+
+func TestDispatchTaskRerunsDepsWithForce(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.txt")
+	config := fmt.Sprintf(`
+build:
+  run:
+    - "sh -c 'echo build >> %[1]s'"
+
+ci:
+  deps: [build]
+  run:
+    - "build"
+`, out)
+
+	e := newTestExecutor(t, config)
+	e.options.Force = true
+	err := e.dispatchTask(e.parser.Tasks["ci"], true)
+
+	require.Nil(t, err)
+	require.Equal(t, "build\nbuild\n", readOutputFile(t, out))
+}
+
+func TestDispatchTaskReportsFailingCommandAndStderr(t *testing.T) {
+	config := `
+lint:
+  run:
+    - "true"
+    - "sh -c 'echo first >&2; echo second >&2; exit 3'"
+`
+
+	e := newTestExecutor(t, config)
+	err := e.dispatchTask(e.parser.Tasks["lint"], true)
+
+	var cmdErr *CommandError
+	require.ErrorAs(t, err, &cmdErr)
+	require.Equal(t, "sh -c 'echo first >&2; echo second >&2; exit 3'", cmdErr.Cmd)
+	require.Equal(t, "first\nsecond\n", cmdErr.Stderr)
+	require.Equal(t, "\"sh -c 'echo first >&2; echo second >&2; exit 3'\" failed: exit status 3\nfirst\nsecond", err.Error())
+}
+
+func TestExecuteRunsTasksInOrder(t *testing.T) {
+	env := NewInMemoryEnv(`
+build:
+  run:
+    - "go build ./..."
+
+test:
+  run:
+    - "go test ./..."
+`)
+
+	require.Nil(t, env.Run("test", "build", "test"))
+	require.Equal(t, []string{"go test ./...", "go build ./...", "go test ./..."}, recordedCommands(env))
+}
+
+func TestExecuteStopsAtFirstFailure(t *testing.T) {
+	env := NewInMemoryEnv(`
+build:
+  run:
+    - "false"
+
+test:
+  run:
+    - "go test ./..."
+`)
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if cmd.Args[0] == "false" {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+
+	err := env.Run("build", "test")
+
+	require.EqualError(t, err, `task 'build' failed: "false" failed: exit status 1`)
+	require.Equal(t, []string{"false"}, recordedCommands(env))
+}
+
+func TestListTasks(t *testing.T) {
+	config := `
+global:
+  events:
+    before_each_task:
+      - "echo starting"
+
+test:
+  desc: Runs the tests
+  run:
+    - "go test ./..."
+
+build:
+  desc: Builds the binary
+  run:
+    - "go build ./..."
+
+clean:
+  run:
+    - "rm -rf dist"
+`
+
+	e := newTestExecutor(t, config)
+	out := bytes.Buffer{}
+	e.listTasks(&out)
+
+	require.Equal(t, "build  Builds the binary\nclean\ntest   Runs the tests\n", out.String())
+}
+
+func TestListTasksVerboseShowsStaleness(t *testing.T) {
+	e := newTestExecutor(t, "build:\n  desc: Builds the binary\n  run:\n    - \"go build ./...\"\n")
+	e.options.Verbose = true
+	out := bytes.Buffer{}
+	e.listTasks(&out)
+
+	require.Equal(t, "build  Builds the binary  never\n", out.String())
+}
+
+func TestListTasksShowsTags(t *testing.T) {
+	e := newTestExecutor(t, `
+build:
+  desc: Builds the binary
+  tags: [go, release]
+  run:
+    - "go build ./..."
+clean:
+  run:
+    - "rm -rf dist"
+`)
+	out := bytes.Buffer{}
+	e.listTasks(&out)
+
+	require.Equal(t, "build  Builds the binary  [go, release]\nclean\n", out.String())
+}
+
+func TestTaggedTasks(t *testing.T) {
+	e := newTestExecutor(t, `
+image:
+  tags: [docker]
+  run:
+    - "docker build ."
+push:
+  tags: [docker, release]
+  run:
+    - "docker push"
+test:
+  run:
+    - "go test ./..."
+`)
+	e.options.Tag = "docker"
+
+	tasks, err := e.taggedTasks(nil)
+	require.Nil(t, err)
+	require.Equal(t, []string{"image", "push"}, tasks)
+
+	_, err = e.taggedTasks([]string{"test"})
+	require.EqualError(t, err, "--tag does not accept task names")
+
+	e.options.Tag = "missing"
+	_, err = e.taggedTasks(nil)
+	require.EqualError(t, err, "no task is tagged 'missing'")
+}
+
+func TestDispatchTaskFiltersEventsByTag(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	config := `
+global:
+  events:
+    before_each_task:
+      - "sh -c 'echo all >> hooks.out'"
+      - cmd: "sh -c 'echo docker >> hooks.out'"
+        only_tags: [docker]
+      - cmd: "sh -c 'echo fast >> hooks.out'"
+        except_tags: [slow]
+image:
+  tags: [docker]
+  run:
+    - "true"
+tests:
+  tags: [slow]
+  run:
+    - "true"
+`
+
+	parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseGlobal())
+	require.Nil(t, parser.parseTasks())
+
+	e := Executor{parser: parser, options: Options{Quiet: true}}
+	require.Nil(t, e.dispatchTask(parser.Tasks["image"], true))
+	require.Nil(t, e.dispatchTask(parser.Tasks["tests"], true))
+
+	require.Equal(t, "all\ndocker\nfast\nall\n", readOutputFile(t, "hooks.out"))
+}
+
+func TestShouldDispatchWhenFileIsMissing(t *testing.T) {
+	e := newTestExecutor(t, "gen:\n  run:\n    - \"go generate\"\n")
+	task := e.parser.Tasks["gen"]
+	task.Files = []string{"gen.go"}
+
+	fsMock := tests.NewFileSystem(t)
+	fsMock.On("Lstat", "gen.go").Return(nil, &iofs.PathError{Op: "lstat", Path: "gen.go", Err: iofs.ErrNotExist})
+	fsMock.On("Getwd").Return("path/to/cwd", nil)
+	fsMock.On("WriteFile", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	fsMock.On("Rename", mock.Anything, mock.Anything).Return(nil)
+
+	e.lockfile = NewLockfile(nil, &clearCacheOpts, fsMock)
+	e.lockfile.JSON = lockFileJson{"path/to/cwd": {"gen": {"gen.go": {ModTime: 1671843661}}}}
+
+	dispatch, err := e.shouldDispatch(task)
+	require.Nil(t, err)
+	require.True(t, dispatch)
+
+	dispatch, err = e.shouldDispatch(task)
+	require.Nil(t, err)
+	require.False(t, dispatch)
+}
+
+func TestShouldDispatchNoticesNewAndDeletedFiles(t *testing.T) {
+	env := NewInMemoryEnv(`
+generate:
+  files: [api/*.proto, buf.yaml]
+  run:
+    - "buf generate"
+`)
+	require.Nil(t, env.FS.WriteFile("api/user.proto", []byte("user"), 0644))
+	require.Nil(t, env.FS.WriteFile("buf.yaml", []byte("version: v1"), 0644))
+
+	run := func() int {
+		before := len(env.Runner.Commands())
+		require.Nil(t, env.Run("generate"))
+		return len(env.Runner.Commands()) - before
+	}
+
+	require.Equal(t, 0, run())
+
+	require.Nil(t, env.FS.WriteFile("api/order.proto", []byte("order"), 0644))
+	require.Equal(t, 1, run())
+	require.Equal(t, 0, run())
+
+	require.Nil(t, env.FS.Remove("api/user.proto"))
+	require.Equal(t, 1, run())
+	require.Equal(t, 0, run())
+
+	require.Nil(t, env.FS.Remove("buf.yaml"))
+	require.Equal(t, 1, run())
+	require.Equal(t, 0, run())
+}
+
+func TestShouldDispatchReportsStatErrors(t *testing.T) {
+	e := newTestExecutor(t, "gen:\n  run:\n    - \"go generate\"\n")
+	task := e.parser.Tasks["gen"]
+	task.Files = []string{"gen.go"}
+
+	fsMock := tests.NewFileSystem(t)
+	fsMock.On("Lstat", "gen.go").Return(nil, &iofs.PathError{Op: "lstat", Path: "gen.go", Err: iofs.ErrPermission})
+	fsMock.On("Getwd").Return("path/to/cwd", nil)
+
+	e.lockfile = NewLockfile(nil, &clearCacheOpts, fsMock)
+	e.lockfile.JSON = lockFileJson{}
+
+	_, err := e.shouldDispatch(task)
+	require.EqualError(t, err, "lstat gen.go: permission denied")
+}
+
+func TestStructuredRunEntrySettings(t *testing.T) {
+	messages := stubReportProgress(t)
+	env := NewInMemoryEnv(`
+build:
+  run:
+    - cmd: "go build ./..."
+      dir: services/api
+      env:
+        CGO_ENABLED: "0"
+        GOFLAGS: "$BASE -trimpath"
+      ignore_error: true
+      name: "build api"
+    - "go vet"
+`)
+	env.Options.Force = true
+	require.Nil(t, env.FS.WriteFile("/work/services/api/main.go", []byte("package main"), 0644))
+	t.Setenv("BASE", "-mod=mod")
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if cmd.Args[0] == "go" && cmd.Args[1] == "build" {
+			return errors.New("exit status 1")
+		}
+		return nil
+	}
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	require.Nil(t, e.Start([]string{"build"}))
+
+	commands := env.Runner.Commands()
+	require.Len(t, commands, 2)
+	require.Equal(t, "services/api", commands[0].Dir)
+	require.Contains(t, commands[0].Env, "CGO_ENABLED=0")
+	require.Contains(t, commands[0].Env, "GOFLAGS=-mod=mod -trimpath")
+	require.NotContains(t, commands[1].Env, "CGO_ENABLED=0")
+
+	require.Equal(t, []string{"step 1/2 · build › build api", "step 2/2 · build › go vet"}, *messages)
+}
+
+func TestDispatchTaskRunsInDir(t *testing.T) {
+	chdir(t, t.TempDir())
+	require.Nil(t, os.MkdirAll("web/src", 0755))
+	require.Nil(t, os.WriteFile("web/src/app.js", []byte(""), 0644))
+	require.Nil(t, os.WriteFile("web/src/lib.js", []byte(""), 0644))
+
+	config := `
+lint:
+  dir: web
+  files: [src/*.js]
+  run:
+    - "sh -c 'echo {FILES} > lint.out'"
+    - cmd: "sh -c 'pwd > dir.out'"
+      dir: web/src
+`
+
+	parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseTasks())
+	require.Equal(t, []string{"web/src/app.js", "web/src/lib.js"}, parser.Tasks["lint"].Files)
+
+	e := Executor{parser: parser, options: Options{Quiet: true}}
+	require.Nil(t, e.dispatchTask(parser.Tasks["lint"], true))
+
+	require.Equal(t, "src/app.js src/lib.js\n", readOutputFile(t, "web/lint.out"))
+	require.True(t, strings.HasSuffix(readOutputFile(t, "web/src/dir.out"), "/web/src\n"))
+}
+
+func TestDispatchTaskPassesFilesCreatedAfterParsing(t *testing.T) {
+	chdir(t, t.TempDir())
+	require.Nil(t, os.MkdirAll("src", 0755))
+	require.Nil(t, os.WriteFile("src/app.js", []byte(""), 0644))
+
+	config := `
+lint:
+  files: [src/*.js]
+  run:
+    - "sh -c 'echo {FILES} > lint.out'"
+`
+
+	parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseTasks())
+	require.Equal(t, []string{"src/app.js"}, parser.Tasks["lint"].Files)
+
+	require.Nil(t, os.WriteFile("src/new.js", []byte(""), 0644))
+
+	e := Executor{parser: parser, options: Options{Quiet: true}}
+	require.Nil(t, e.dispatchTask(parser.Tasks["lint"], true))
+
+	require.Equal(t, "src/app.js src/new.js\n", readOutputFile(t, "lint.out"))
+}
+
+func TestParseTasksRejectsMissingDir(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	parser := NewParser("lint:\n  dir: web\n  run:\n    - \"true\"\n", &clearCacheOpts, &LocalFileSystem{})
+
+	require.EqualError(t, parser.parseTasks(), "task 'lint': directory web does not exist")
+}
+
+func TestDispatchTaskRunsThroughShell(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	config := `
+global:
+  shell: true
+build:
+  run:
+    - "echo one > out.txt && echo two >> out.txt"
+raw:
+  shell: false
+  run:
+    - "echo a > raw.txt"
+`
+
+	parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseGlobal())
+	require.Nil(t, parser.parseTasks())
+	require.Equal(t, []string{`task 'raw' runs "echo a > raw.txt" without a shell, so its shell operators are passed as arguments; set shell: true on the task`}, parser.Warnings)
+
+	e := Executor{parser: parser, options: Options{Quiet: true}}
+	require.Nil(t, e.dispatchTask(parser.Tasks["build"], true))
+	require.Nil(t, e.dispatchTask(parser.Tasks["raw"], true))
+
+	require.Equal(t, "one\ntwo\n", readOutputFile(t, "out.txt"))
+	require.NoFileExists(t, "raw.txt")
+}
+
+func TestTaskEnvDoesNotLeakIntoOtherTasks(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	config := `
+global:
+  environment:
+    GOKE_TEST_STAGE: "global"
+first:
+  env:
+    GOKE_TEST_STAGE: "first"
+    GOKE_TEST_ONLY_FIRST: "$(echo only)"
+  run:
+    - "sh -c 'echo $GOKE_TEST_STAGE $GOKE_TEST_ONLY_FIRST > first.out'"
+second:
+  run:
+    - "sh -c 'echo ${GOKE_TEST_STAGE} $GOKE_TEST_ONLY_FIRST > second.out'"
+`
+
+	parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseGlobal())
+	require.Nil(t, parser.parseTasks())
+	require.Empty(t, os.Getenv("GOKE_TEST_ONLY_FIRST"))
+
+	e := Executor{parser: parser, options: Options{Quiet: true}}
+	require.Nil(t, e.dispatchTask(parser.Tasks["first"], true))
+	require.Nil(t, e.dispatchTask(parser.Tasks["second"], true))
+
+	require.Equal(t, "first only\n", readOutputFile(t, "first.out"))
+	require.Equal(t, "global\n", readOutputFile(t, "second.out"))
+}
+
+func TestDispatchTaskIgnoresFailures(t *testing.T) {
+	chdir(t, t.TempDir())
+
+	config := `
+cleanup:
+  run:
+    - "-false"
+    - cmd: "-sh -c 'exit 3'"
+    - "sh -c 'echo cleaned > cleanup.out'"
+teardown:
+  continue_on_error: true
+  run:
+    - "false"
+    - "sh -c 'echo down > teardown.out'"
+strict:
+  run:
+    - "false"
+    - "sh -c 'echo strict > strict.out'"
+`
+
+	parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseTasks())
+	require.Equal(t, RunEntry{Cmd: "false", IgnoreError: true}, parser.Tasks["cleanup"].Run[0])
+
+	e := Executor{parser: parser, options: Options{Quiet: true}}
+	require.Nil(t, e.dispatchTask(parser.Tasks["cleanup"], true))
+	require.Nil(t, e.dispatchTask(parser.Tasks["teardown"], true))
+	require.NotNil(t, e.dispatchTask(parser.Tasks["strict"], true))
+
+	require.Equal(t, "cleaned\n", readOutputFile(t, "cleanup.out"))
+	require.Equal(t, "down\n", readOutputFile(t, "teardown.out"))
+	require.NoFileExists(t, "strict.out")
+}
+
+func TestStartReturnsErrorsInsteadOfExiting(t *testing.T) {
+	e := newTestExecutor(t, "build:\n  run:\n    - \"true\"\n")
+
+	err := e.Start([]string{"deploy"})
+	var notFound *TaskNotFoundError
+	require.ErrorAs(t, err, &notFound)
+	require.Equal(t, "deploy", notFound.Task)
+	require.Equal(t, 1, ExitCode(err))
+
+	e.options.Batch = "plan.yml"
+	require.EqualError(t, e.Start([]string{"build"}), "--batch does not accept task names, list them in the plan")
+}
+
+func TestStartReturnsTheExitCodeOfFailedCommands(t *testing.T) {
+	env := NewInMemoryEnv("build:\n  run:\n    - \"go build\"\n")
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		return exec.Command("sh", "-c", "exit 4").Run()
+	}
+
+	err := env.Run("build")
+	var cmdErr *CommandError
+	require.ErrorAs(t, err, &cmdErr)
+	require.Equal(t, 4, cmdErr.ExitCode())
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("env.defaults")
+}
+
+// A ${VAR:?message} whose variable is unset or empty.
+type UnsetVariableError struct {
+	Name    string
+	Message string
+}
+
+func (e *UnsetVariableError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("variable %s is not set", e.Name)
+	}
+
+	return fmt.Sprintf("%s: %s", e.Name, e.Message)
+}
+
+// Expands $VAR and ${VAR} like os.Expand does, along with the operators of
+// POSIX shells, which treat empty variables like unset ones:
+//
+//	${VAR:-default}  default when VAR is unset, otherwise VAR
+//	${VAR:+alt}      alt when VAR is set, otherwise nothing
+//	${VAR:?message}  fails with the message when VAR is unset
+//
+// The words of the operators are expanded themselves, ie. ${A:-$B}. On
+// Windows, %VAR% is expanded too, see expandSystemVars.
+func shellExpand(str string, lookup func(name string) (string, bool)) (string, error) {
+	return expandOperators(str, lookup, func(s string) string {
+		return expandSystemVars(os.Expand(s, func(name string) string {
+			v, _ := lookup(name)
+			return v
+		}), lookup)
+	})
+}
+
+// Only expands the ${VAR:-default}, ${VAR:+alt} and ${VAR:?message} of str,
+// leaving $VAR and ${VAR} as they are.
+func expandDefaults(str string, lookup func(name string) (string, bool)) (string, error) {
+	return expandOperators(str, lookup, func(s string) string { return s })
+}
+
+// Replaces the operators of shellExpand in str, passing the text between
+// them through plain.
+func expandOperators(str string, lookup func(name string) (string, bool), plain func(string) string) (string, error) {
+	var b strings.Builder
+	last := 0
+
+	for i := 0; i < len(str); i++ {
+		if !strings.HasPrefix(str[i:], "${") {
+			continue
+		}
+
+		name, op, word, end, ok := parseOperator(str, i)
+		if !ok {
+			continue
+		}
+
+		value, err := expandOperator(name, op, word, lookup, plain)
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(plain(str[last:i]))
+		b.WriteString(value)
+		last = end
+		i = end - 1
+	}
+
+	b.WriteString(plain(str[last:]))
+	return b.String(), nil
+}
+
+// Parses the operator at the "${" at start, ie. ${VAR:-default}, returning
+// the variable, the operator, its word and where the expression ends.
+// Braces in the word must be balanced.
+func parseOperator(str string, start int) (name string, op string, word string, end int, ok bool) {
+	i := start + 2
+	for i < len(str) && (str[i] == '_' || isAlphaNum(str[i])) {
+		i++
+	}
+
+	name = str[start+2 : i]
+	if name == "" || i+1 >= len(str) || str[i] != ':' || !strings.ContainsRune("-+?", rune(str[i+1])) {
+		return "", "", "", 0, false
+	}
+
+	op = str[i : i+2]
+	depth := 1
+	for j := i + 2; j < len(str); j++ {
+		switch str[j] {
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return name, op, str[i+2 : j], j + 1, true
+			}
+		}
+	}
+
+	return "", "", "", 0, false
+}
+
+func expandOperator(name string, op string, word string, lookup func(name string) (string, bool), plain func(string) string) (string, error) {
+	value, _ := lookup(name)
+
+	switch {
+	case op != ":+" && value != "":
+		return value, nil
+	case op == ":+" && value == "":
+		return "", nil
+	}
+
+	word, err := expandOperators(word, lookup, plain)
+	if err != nil {
+		return "", err
+	}
+
+	if op == ":?" {
+		return "", &UnsetVariableError{Name: name, Message: word}
+	}
+
+	return word, nil
+}
+
+func isAlphaNum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestShellExpand(t *testing.T) {
+	vars := map[string]string{"NAME": "goke", "EMPTY": "", "OTHER": "other"}
+	lookup := func(name string) (string, bool) {
+		v, ok := vars[name]
+		return v, ok
+	}
+
+	cases := map[string]string{
+		"$NAME ${NAME}":          "goke goke",
+		"$UNSET-${UNSET}":        "-",
+		"${NAME:-default}":       "goke",
+		"${UNSET:-default}":      "default",
+		"${EMPTY:-default}":      "default",
+		"${UNSET:-}":             "",
+		"${NAME:+alt}":           "alt",
+		"${UNSET:+alt}":          "",
+		"${EMPTY:+alt}":          "",
+		"${NAME:?must be set}":   "goke",
+		"${UNSET:-$OTHER}":       "other",
+		"${UNSET:-${OTHER}/bin}": "other/bin",
+		"${UNSET:-${EMPTY:-x}}":  "x",
+		"a ${UNSET:-b} $NAME c":  "a b goke c",
+		"${UNSET:-a b}":          "a b",
+		"${UNSET:-{x}}":          "{x}",
+		"$$":                     "",
+	}
+
+	for str, expected := range cases {
+		expanded, err := shellExpand(str, lookup)
+		require.NoError(t, err, str)
+		require.Equal(t, expected, expanded, str)
+	}
+}
+
+func TestShellExpandFailsOnUnsetVariables(t *testing.T) {
+	lookup := func(string) (string, bool) { return "", false }
+
+	_, err := shellExpand("deploy ${DEPLOY_ENV:?pick an environment}", lookup)
+	require.EqualError(t, err, "DEPLOY_ENV: pick an environment")
+
+	_, err = shellExpand("${DEPLOY_ENV:?}", lookup)
+	require.EqualError(t, err, "variable DEPLOY_ENV is not set")
+
+	var unset *UnsetVariableError
+	require.ErrorAs(t, err, &unset)
+	require.Equal(t, "DEPLOY_ENV", unset.Name)
+}
+
+func TestExpandDefaultsLeavesPlainVariables(t *testing.T) {
+	lookup := func(string) (string, bool) { return "", false }
+
+	expanded, err := expandDefaults("$HOME/${DIR} ${DIR:-build}", lookup)
+	require.NoError(t, err)
+	require.Equal(t, "$HOME/${DIR} build", expanded)
+}
+
+func TestRunExpandsDefaults(t *testing.T) {
+	env := NewInMemoryEnv(`
+global:
+  environment:
+    GOKE_TEST_TARGET: "${GOKE_TEST_UNSET_TARGET:-staging}"
+
+deploy:
+  env:
+    REGION: eu
+  run:
+    - "deploy ${GOKE_TEST_UNSET_ENV:-$GOKE_TEST_TARGET} ${REGION:+--region=$REGION}"
+
+release:
+  run:
+    - "release ${GOKE_TEST_UNSET_VERSION:?set the version to release}"
+`)
+
+	require.NoError(t, env.Run("deploy"))
+	require.Equal(t, []string{"deploy staging --region=eu"}, recordedCommands(env))
+
+	require.EqualError(t, env.Run("release"), "GOKE_TEST_UNSET_VERSION: set the version to release")
+	require.Len(t, recordedCommands(env), 1)
+}
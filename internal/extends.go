@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterCapability("task.extends", "task.abstract")
+}
+
+// The keys of a task which the tasks extending it don't inherit.
+var notInherited = map[string]bool{"extends": true, "abstract": true, "append_run": true}
+
+func (t *Task) UnmarshalYAML(node *yaml.Node) error {
+	// Decoding into an alias type avoids recursing into this method.
+	type task Task
+	if err := node.Decode((*task)(t)); err != nil {
+		return err
+	}
+
+	// The keys the task declares, which override the ones it extends.
+	if node.Kind == yaml.MappingNode {
+		t.keys = make(map[string]bool, len(node.Content)/2)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			t.keys[node.Content[i].Value] = true
+		}
+	}
+
+	return nil
+}
+
+// Resolves the tasks which extend others, in the order they are declared,
+// so that the relative paths and placeholders of the keys they inherit are
+// resolved like their own.
+func resolveExtends(tasks taskList, lines map[string]int) error {
+	resolved := make(map[string]bool, len(tasks))
+
+	var resolve func(name string, chain []string) error
+	resolve = func(name string, chain []string) error {
+		task := tasks[name]
+		if task.Extends == "" || resolved[name] {
+			return nil
+		}
+
+		for i, n := range chain {
+			if n == name {
+				return fmt.Errorf("extends cycle detected: %s", strings.Join(append(chain[i:], name), " -> "))
+			}
+		}
+
+		if _, ok := tasks[task.Extends]; !ok {
+			return fmt.Errorf("task '%s' extends unknown task '%s'", name, task.Extends)
+		}
+
+		if err := resolve(task.Extends, append(chain, name)); err != nil {
+			return err
+		}
+
+		tasks[name] = task.inherit(tasks[task.Extends])
+		resolved[name] = true
+		return nil
+	}
+
+	for _, name := range declarationOrder(tasks, lines) {
+		if err := resolve(name, nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Returns the task with the keys it doesn't declare taken from the given
+// one. Maps, ie. env, vars and params, are merged key by key instead, and
+// run comes after the one of the extended task with append_run.
+func (t Task) inherit(base Task) Task {
+	child := reflect.ValueOf(&t).Elem()
+	parent := reflect.ValueOf(base)
+
+	for i := 0; i < child.NumField(); i++ {
+		key, _, _ := strings.Cut(child.Type().Field(i).Tag.Get("yaml"), ",")
+		if key == "" || key == "-" || notInherited[key] {
+			continue
+		}
+
+		field := child.Field(i)
+		switch {
+		case key == "run" && t.AppendRun:
+			field.Set(reflect.AppendSlice(copyValue(parent.Field(i)), field))
+		case field.Kind() == reflect.Map && t.keys[key]:
+			merged := copyValue(parent.Field(i))
+			if merged.IsNil() {
+				merged = reflect.MakeMap(field.Type())
+			}
+
+			iter := field.MapRange()
+			for iter.Next() {
+				merged.SetMapIndex(iter.Key(), iter.Value())
+			}
+			field.Set(merged)
+		case !t.keys[key]:
+			field.Set(copyValue(parent.Field(i)))
+		}
+	}
+
+	return t
+}
+
+// Copies slices and maps, which parsing the tasks modifies in place, so
+// that the task and the one it extends don't share them.
+func copyValue(v reflect.Value) reflect.Value {
+	switch {
+	case v.Kind() == reflect.Slice && !v.IsNil():
+		return reflect.AppendSlice(reflect.MakeSlice(v.Type(), 0, v.Len()), v)
+	case v.Kind() == reflect.Map && !v.IsNil():
+		copied := reflect.MakeMapWithSize(v.Type(), v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			copied.SetMapIndex(iter.Key(), iter.Value())
+		}
+		return copied
+	}
+
+	return v
+}
+
+// Looks up a task given on the command line, or by a plan. Abstract tasks
+// are only extended, they don't run on their own.
+func (p *Parser) runnableTask(name string) (Task, error) {
+	task, ok := p.Tasks[name]
+	if !ok {
+		return Task{}, &TaskNotFoundError{Task: name}
+	}
+
+	if task.Abstract {
+		return Task{}, fmt.Errorf("task '%s' is abstract, run one of the tasks extending it", name)
+	}
+
+	return task, nil
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const extendsConfig = `
+base-deploy:
+  abstract: true
+  desc: Deploys the app
+  files: [app/*.go]
+  env:
+    REGION: eu-west-1
+    STAGE: prod
+  run:
+    - "deploy --region $REGION --stage $STAGE"
+
+deploy-us:
+  extends: base-deploy
+  env:
+    REGION: us-east-1
+
+deploy-staging:
+  extends: deploy-us
+  desc: Deploys the app to staging
+  env:
+    STAGE: staging
+  append_run: true
+  run:
+    - "smoke-test --stage $STAGE"
+
+deploy-manual:
+  extends: base-deploy
+  run:
+    - "echo deploy by hand"
+`
+
+func TestTasksInheritTheKeysTheyDontDeclare(t *testing.T) {
+	env := NewInMemoryEnv(extendsConfig)
+	require.Nil(t, env.FS.WriteFile("/work/app/main.go", []byte("package main"), 0644))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	us := p.Tasks["deploy-us"]
+	require.False(t, us.Abstract)
+	require.Equal(t, "Deploys the app", us.Desc)
+	require.Equal(t, []string{"app/main.go"}, us.Files)
+	require.Equal(t, map[string]string{"REGION": "us-east-1", "STAGE": "prod"}, us.Env)
+
+	// Extends chains are resolved in order, run is appended to with
+	// append_run and replaced otherwise.
+	staging := p.Tasks["deploy-staging"]
+	require.Equal(t, "Deploys the app to staging", staging.Desc)
+	require.Equal(t, map[string]string{"REGION": "us-east-1", "STAGE": "staging"}, staging.Env)
+	require.Len(t, staging.Run, 2)
+	require.Len(t, p.Tasks["deploy-manual"].Run, 1)
+	require.Equal(t, map[string]string{"REGION": "eu-west-1", "STAGE": "prod"}, p.Tasks["base-deploy"].Env)
+
+	env.Options.Force = true
+	require.Nil(t, env.Run("deploy-staging"))
+	require.Equal(t, []string{"deploy --region us-east-1 --stage staging", "smoke-test --stage staging"}, recordedCommands(env))
+}
+
+func TestAbstractTasksDontRunOnTheirOwn(t *testing.T) {
+	env := NewInMemoryEnv(extendsConfig)
+
+	err := env.Run("base-deploy")
+	require.EqualError(t, err, "task 'base-deploy' is abstract, run one of the tasks extending it")
+	require.Empty(t, recordedCommands(env))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	out := bytes.Buffer{}
+	e.listTasks(&out)
+	require.Equal(t, "deploy-manual   Deploys the app\ndeploy-staging  Deploys the app to staging\ndeploy-us       Deploys the app\n", out.String())
+}
+
+func TestExtendsErrors(t *testing.T) {
+	_, err := NewInMemoryEnv("a:\n  extends: b\n  run: [\"a\"]\n\nb:\n  extends: a\n").Parse()
+	require.EqualError(t, err, "extends cycle detected: a -> b -> a")
+
+	_, err = NewInMemoryEnv("a:\n  extends: base\n  run: [\"a\"]\n").Parse()
+	require.EqualError(t, err, "task 'a' extends unknown task 'base'")
+}
@@ -0,0 +1,28 @@
+//go:build windows
+
+package internal
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// Holds a lock on the first byte of the file with LockFileEx, see fileLocker.
+func (fs *LocalFileSystem) Lock(name string) (func(), error) {
+	f, err := os.OpenFile(name, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	handle := windows.Handle(f.Fd())
+	if err := windows.LockFileEx(handle, windows.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, &windows.Overlapped{}); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = windows.UnlockFileEx(handle, 0, 1, 0, &windows.Overlapped{})
+		f.Close()
+	}, nil
+}
@@ -12,11 +12,15 @@ import (
 
 type FileSystem interface {
 	ReadFile(name string) ([]byte, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
 	WriteFile(name string, data []byte, perm fs.FileMode) error
 	Getwd() (dir string, err error)
 	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	EvalSymlinks(path string) (string, error)
 	FileExists(filename string) bool
 	Remove(name string) error
+	Rename(oldpath string, newpath string) error
 	TempDir() string
 	Glob(path string) ([]string, error)
 }
@@ -27,6 +31,10 @@ func (fs *LocalFileSystem) ReadFile(name string) ([]byte, error) {
 	return os.ReadFile(name)
 }
 
+func (fs *LocalFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	return os.ReadDir(name)
+}
+
 func (fs *LocalFileSystem) WriteFile(name string, data []byte, perm fs.FileMode) error {
 	return os.WriteFile(name, data, perm)
 }
@@ -39,10 +47,22 @@ func (fs *LocalFileSystem) Stat(name string) (fs.FileInfo, error) {
 	return os.Stat(name)
 }
 
+func (fs *LocalFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (fs *LocalFileSystem) EvalSymlinks(path string) (string, error) {
+	return filepath.EvalSymlinks(path)
+}
+
 func (fs *LocalFileSystem) Remove(name string) error {
 	return os.Remove(name)
 }
 
+func (fs *LocalFileSystem) Rename(oldpath string, newpath string) error {
+	return os.Rename(oldpath, newpath)
+}
+
 func (fs *LocalFileSystem) TempDir() string {
 	return os.TempDir()
 }
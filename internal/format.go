@@ -0,0 +1,438 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterCapability("command.fmt")
+}
+
+// The order of the keys of a task in a formatted config: what the task is
+// first, then when and where it runs, how, and what it runs last. Keys
+// which aren't listed keep their order, before "run".
+var taskKeyOrder = []string{
+	"<<",
+	"desc", "tags", "when", "if", "unless", "only_os", "deps", "group",
+	"files", "follow_symlinks", "inherit_files", "checksum", "outputs",
+	"dir", "shell", "env", "vars", "params",
+	"preflight", "output_encoding", "restart", "every",
+	"parallel", "max_concurrency", "continue_on_error",
+	"timeout", "retries", "retry_delay", "retry_backoff",
+	"events", "inherit_events",
+	"run", "run_windows", "run_darwin", "run_linux",
+	"on_success", "on_failure",
+}
+
+var globalKeyOrder = []string{
+	"<<",
+	"environment", "env_path_vars", "shell", "windows_shell", "checksum", "timeout", "state_backend", "events", "fmt",
+}
+
+var eventKeyOrder = []string{
+	"before_each_run", "after_each_run", "before_each_task", "after_each_task",
+	"before_each_run_windows", "after_each_run_windows", "before_each_task_windows", "after_each_task_windows",
+	"before_each_run_darwin", "after_each_run_darwin", "before_each_task_darwin", "after_each_task_darwin",
+	"before_each_run_linux", "after_each_run_linux", "before_each_task_linux", "after_each_task_linux",
+}
+
+// Formats the YAML config in goke's canonical style, keeping its comments
+// and anchors:
+//
+//   - two spaces of indentation, with a blank line between top-level keys;
+//   - global first, then vars, then the tasks in the order they're declared,
+//     or sorted by name with global.fmt.sort_tasks;
+//   - the keys of tasks in the order of taskKeyOrder;
+//   - "run", "files" and the events as block lists of quoted strings;
+//   - double quotes, unless the string contains some, see quoteStyle.
+//
+// Fails rather than return a config which would parse differently.
+func FormatConfig(file string, src []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := decodeConfig(file, string(src), &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 {
+		return src, nil
+	}
+
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: the config must be a mapping of tasks", file)
+	}
+
+	formatNode(&doc)
+	sortTopLevel(root, sortTasks(root))
+
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		switch key.Value {
+		case "global":
+			formatGlobal(key, value)
+		case "vars", "hooks", generateTasksKey, includesKey:
+		default:
+			formatTask(key, value)
+		}
+	}
+
+	formatted, err := encodeConfig(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := sameConfig(file, src, formatted); err != nil {
+		return nil, err
+	}
+
+	return formatted, nil
+}
+
+// Encodes the config with two spaces of indentation and a blank line
+// between top-level keys.
+func encodeConfig(doc *yaml.Node) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(doc); err != nil {
+		return nil, err
+	}
+	encoder.Close()
+
+	return separateTopLevelKeys(b.Bytes()), nil
+}
+
+// Formats the config file in place, see FormatConfig. With --diff, prints
+// what would change instead, and with --check fails when the file isn't
+// formatted, for CI.
+func FormatConfigFile(file string, o Options, out io.Writer) error {
+	src, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+
+	formatted, err := FormatConfig(file, src)
+	if err != nil || bytes.Equal(src, formatted) {
+		return err
+	}
+
+	if o.Diff {
+		fmt.Fprint(out, colorizeDiff(unifiedDiff(file, file+" (formatted)", string(src), string(formatted))))
+	}
+
+	if o.Check {
+		return fmt.Errorf("%s is not formatted, run goke fmt", file)
+	}
+
+	if o.Diff {
+		return nil
+	}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(file, formatted, info.Mode().Perm())
+}
+
+// Whether global.fmt.sort_tasks is set.
+func sortTasks(root *yaml.Node) bool {
+	node := mappingValue(mappingValue(mappingValue(root, "global"), "fmt"), "sort_tasks")
+	if node == nil {
+		return false
+	}
+
+	var sort bool
+	return node.Decode(&sort) == nil && sort
+}
+
+// Returns the value of the key in the mapping, nil when there is none.
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(mapping, key); i >= 0 {
+		return mapping.Content[i+1]
+	}
+
+	return nil
+}
+
+// Returns the node of the key in the mapping, nil when there is none.
+func mappingKey(mapping *yaml.Node, key string) *yaml.Node {
+	if i := mappingIndex(mapping, key); i >= 0 {
+		return mapping.Content[i]
+	}
+
+	return nil
+}
+
+func mappingIndex(mapping *yaml.Node, key string) int {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return -1
+	}
+
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// Quotes the strings of the whole document the same way, see quoteStyle,
+// and leaves the tag of merge keys implicit, ie. "<<: *base", since the
+// encoder would write "!!merge <<: *base".
+func formatNode(node *yaml.Node) {
+	if node.Kind == yaml.ScalarNode && node.Style&(yaml.SingleQuotedStyle|yaml.DoubleQuotedStyle) != 0 {
+		node.Style = quoteStyle(node.Value) | node.Style&yaml.TaggedStyle
+	}
+
+	if node.Kind == yaml.ScalarNode && node.ShortTag() == "!!merge" {
+		node.Tag = ""
+	}
+
+	for _, child := range node.Content {
+		formatNode(child)
+	}
+}
+
+// Moves global, vars, placeholders, hooks, generate_tasks and includes
+// first, and sorts the tasks when asked to.
+func sortTopLevel(root *yaml.Node, byName bool) {
+	rank := func(key string) int {
+		switch key {
+		case "global":
+			return 0
+		case "vars":
+			return 1
+		case placeholdersKey:
+			return 2
+		case "hooks":
+			return 3
+		case generateTasksKey:
+			return 4
+		case includesKey:
+			return 5
+		}
+
+		return 6
+	}
+
+	sortMapping(root, func(a, b string) bool {
+		if rank(a) != rank(b) || !byName {
+			return rank(a) < rank(b)
+		}
+
+		return a < b
+	})
+}
+
+// Orders the keys of the mapping in the given order, the ones which aren't
+// listed before the last one.
+func orderKeys(mapping *yaml.Node, order []string) {
+	rank := func(key string) int {
+		for i, k := range order {
+			if k == key {
+				if i == len(order)-1 {
+					return len(order)
+				}
+				return i
+			}
+		}
+
+		return len(order) - 1
+	}
+
+	sortMapping(mapping, func(a, b string) bool {
+		return rank(a) < rank(b)
+	})
+}
+
+// Stable sort of the key and value pairs of the mapping by their keys.
+func sortMapping(mapping *yaml.Node, less func(a, b string) bool) {
+	if mapping == nil || mapping.Kind != yaml.MappingNode {
+		return
+	}
+
+	pairs := make([][2]*yaml.Node, 0, len(mapping.Content)/2)
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		pairs = append(pairs, [2]*yaml.Node{mapping.Content[i], mapping.Content[i+1]})
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool {
+		return less(pairs[i][0].Value, pairs[j][0].Value)
+	})
+
+	mapping.Content = mapping.Content[:0]
+	for _, pair := range pairs {
+		mapping.Content = append(mapping.Content, pair[0], pair[1])
+	}
+}
+
+func formatGlobal(key *yaml.Node, global *yaml.Node) {
+	if global.Kind != yaml.MappingNode {
+		return
+	}
+
+	toBlock(key, global)
+	orderKeys(global, globalKeyOrder)
+
+	events := mappingValue(global, "events")
+	if events == nil || events.Kind != yaml.MappingNode {
+		return
+	}
+
+	toBlock(mappingKey(global, "events"), events)
+	orderKeys(events, append(eventKeyOrder, ""))
+
+	for i := 0; i+1 < len(events.Content); i += 2 {
+		formatCommandList(events.Content[i], events.Content[i+1])
+	}
+}
+
+func formatTask(key *yaml.Node, task *yaml.Node) {
+	if task.Kind != yaml.MappingNode {
+		return
+	}
+
+	toBlock(key, task)
+	orderKeys(task, taskKeyOrder)
+
+	for _, key := range []string{"run", "run_windows", "run_darwin", "run_linux"} {
+		formatCommandList(mappingKey(task, key), mappingValue(task, key))
+	}
+	formatStringList(mappingKey(task, "files"), mappingValue(task, "files"))
+}
+
+// Makes a block list of the commands, double-quoting them, including the
+// ones under "cmd" of entries like {cmd: ..., dir: ...}.
+func formatCommandList(key *yaml.Node, list *yaml.Node) {
+	formatStringList(key, list)
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return
+	}
+
+	for _, item := range list.Content {
+		quote(mappingValue(item, "cmd"))
+	}
+}
+
+// Makes a block list of double-quoted strings of the list.
+func formatStringList(key *yaml.Node, list *yaml.Node) {
+	if list == nil || list.Kind != yaml.SequenceNode {
+		return
+	}
+
+	toBlock(key, list)
+	for _, item := range list.Content {
+		quote(item)
+	}
+}
+
+// Turns the flow collection under the key into a block one. The comment
+// after a flow collection moves to its key, since it would otherwise end up
+// after the last item.
+func toBlock(key *yaml.Node, value *yaml.Node) {
+	if value.Style&yaml.FlowStyle == 0 {
+		return
+	}
+
+	value.Style &^= yaml.FlowStyle
+	if value.LineComment != "" {
+		key.LineComment = strings.TrimSpace(key.LineComment + " " + value.LineComment)
+		value.LineComment = ""
+	}
+}
+
+// Quotes the string, unless it's a block scalar, which keeps its layout, or
+// explicitly tagged, ie. with !!str.
+func quote(node *yaml.Node) {
+	if node == nil || node.Kind != yaml.ScalarNode || node.ShortTag() != "!!str" {
+		return
+	}
+
+	if node.Style == 0 {
+		node.Style = quoteStyle(node.Value)
+	}
+}
+
+// Strings are double-quoted, unless they contain double quotes but no
+// single ones, ie. 'echo "hi"', which would need escaping.
+func quoteStyle(value string) yaml.Style {
+	if strings.Contains(value, `"`) && !strings.Contains(value, "'") {
+		return yaml.SingleQuotedStyle
+	}
+
+	return yaml.DoubleQuotedStyle
+}
+
+// Inserts a blank line before each top-level key but the first one, and
+// before the comments right above it.
+func separateTopLevelKeys(out []byte) []byte {
+	lines := strings.Split(strings.TrimRight(string(out), "\n"), "\n")
+	formatted := make([]string, 0, len(lines))
+
+	for i, line := range lines {
+		topLevel := line != "" && !strings.ContainsAny(line[:1], " -#")
+		if topLevel && i > 0 {
+			start := len(formatted)
+			for start > 0 && strings.HasPrefix(formatted[start-1], "#") {
+				start--
+			}
+
+			if start > 0 && formatted[start-1] != "" {
+				formatted = append(formatted[:start], append([]string{""}, formatted[start:]...)...)
+			}
+		}
+
+		formatted = append(formatted, line)
+	}
+
+	return []byte(strings.Join(formatted, "\n") + "\n")
+}
+
+// The parts of the config goke reads, to compare configs before and after
+// formatting.
+type formattedConfig struct {
+	Tasks     taskList
+	Global    Global
+	Vars      configVars
+	Hooks     configHooks
+	Generator configGenerator
+}
+
+func decodeFormattedConfig(file string, src []byte) (formattedConfig, error) {
+	var c formattedConfig
+	for _, out := range []any{&c.Tasks, &c.Global, &c.Vars, &c.Hooks, &c.Generator} {
+		if err := decodeConfig(file, string(src), out); err != nil {
+			return c, err
+		}
+	}
+
+	return c, nil
+}
+
+// Fails unless both configs parse into the same tasks, global section,
+// vars, hooks and generator.
+func sameConfig(file string, src []byte, formatted []byte) error {
+	before, err := decodeFormattedConfig(file, src)
+	if err != nil {
+		return err
+	}
+
+	after, err := decodeFormattedConfig(file, formatted)
+	if err != nil || !reflect.DeepEqual(before, after) {
+		return fmt.Errorf("%s: formatting would change how the config parses, leaving it as is", file)
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+var (
+	yamlCommentRegexp = regexp.MustCompile(`(^|\s)(#.*)$`)
+	yamlAnchorRegexp  = regexp.MustCompile(`[&*][\w.-]+`)
+)
+
+// The comments, anchors and aliases of the config, in no particular order.
+func yamlAnnotations(config string) []string {
+	var annotations []string
+	for _, line := range strings.Split(config, "\n") {
+		if m := yamlCommentRegexp.FindStringSubmatch(line); m != nil {
+			annotations = append(annotations, m[2])
+		}
+		annotations = append(annotations, yamlAnchorRegexp.FindAllString(line, -1)...)
+	}
+
+	return annotations
+}
+
+func TestFormatConfigCorpus(t *testing.T) {
+	files, err := filepath.Glob("testdata/fmt/*.yml")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			require.NoError(t, err)
+
+			formatted, err := FormatConfig(file, src)
+			require.NoError(t, err)
+
+			before, err := decodeFormattedConfig(file, src)
+			require.NoError(t, err)
+			after, err := decodeFormattedConfig(file, formatted)
+			require.NoError(t, err)
+			require.Equal(t, before, after)
+
+			again, err := FormatConfig(file, formatted)
+			require.NoError(t, err)
+			require.Equal(t, string(formatted), string(again))
+
+			require.ElementsMatch(t, yamlAnnotations(string(src)), yamlAnnotations(string(formatted)))
+			require.NotContains(t, string(formatted), "\t")
+		})
+	}
+}
+
+func TestFormatConfigStyle(t *testing.T) {
+	src := `# The tasks
+test:
+    run: ['go test ./...', "-go vet ./..."]   # the checks
+    files: [cmd/cli/*.go, internal/*]
+    desc: 'Runs the tests'
+build: &build
+    run:
+        - go build ./...
+global:
+    events: {after_each_task: ['echo done']}
+`
+
+	formatted, err := FormatConfig("goke.yml", []byte(src))
+	require.NoError(t, err)
+	require.Equal(t, `global:
+  events:
+    after_each_task:
+      - "echo done"
+
+# The tasks
+test:
+  desc: "Runs the tests"
+  files:
+    - "cmd/cli/*.go"
+    - "internal/*"
+  run: # the checks
+    - "go test ./..."
+    - "-go vet ./..."
+
+build: &build
+  run:
+    - "go build ./..."
+`, string(formatted))
+}
+
+func TestFormatConfigSortTasks(t *testing.T) {
+	src := "vars:\n  A: a\nzeta:\n  run: [z]\nalpha:\n  run: [a]\nglobal:\n  fmt:\n    sort_tasks: true\n"
+
+	formatted, err := FormatConfig("goke.yml", []byte(src))
+	require.NoError(t, err)
+	require.Equal(t, `global:
+  fmt:
+    sort_tasks: true
+
+vars:
+  A: a
+
+alpha:
+  run:
+    - "a"
+
+zeta:
+  run:
+    - "z"
+`, string(formatted))
+}
+
+func TestFormatConfigInvalid(t *testing.T) {
+	_, err := FormatConfig("goke.yml", []byte("- build\n- test\n"))
+	require.EqualError(t, err, "goke.yml: the config must be a mapping of tasks")
+
+	_, err = FormatConfig("goke.yml", []byte("build:\n  run: \"go build\"\n    - \"go vet\"\n"))
+	require.Error(t, err)
+}
+
+func TestFormatConfigFile(t *testing.T) {
+	withColors(t, false)
+
+	file := filepath.Join(t.TempDir(), "goke.yml")
+	src := "build:\n  run: ['go build']\n"
+	require.NoError(t, os.WriteFile(file, []byte(src), 0600))
+
+	var out strings.Builder
+	err := FormatConfigFile(file, Options{Check: true, Diff: true}, &out)
+	require.EqualError(t, err, file+" is not formatted, run goke fmt")
+	require.Contains(t, out.String(), "-  run: ['go build']\n+  run:\n+    - \"go build\"\n")
+
+	contents, _ := os.ReadFile(file)
+	require.Equal(t, src, string(contents))
+
+	require.NoError(t, FormatConfigFile(file, Options{}, &out))
+	contents, _ = os.ReadFile(file)
+	require.Equal(t, "build:\n  run:\n    - \"go build\"\n", string(contents))
+
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	require.Equal(t, os.FileMode(0600), info.Mode().Perm())
+
+	out.Reset()
+	require.NoError(t, FormatConfigFile(file, Options{Check: true, Diff: true}, &out))
+	require.Empty(t, out.String())
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterCapability("config.generate_tasks")
+}
+
+// The top-level key naming the command which generates tasks when the
+// config is parsed, which is not a task.
+const generateTasksKey = "generate_tasks"
+
+// How long the generator of the tasks may run.
+const generateTimeout = time.Minute
+
+// The top-level generate_tasks of the config, ie. "./scripts/gen-tasks".
+type configGenerator struct {
+	GenerateTasks string `yaml:"generate_tasks,omitempty"`
+}
+
+// Decodes the tasks of the config, without the top-level keys which aren't
+// tasks and can't be decoded as one, see generateTasksKey and includesKey.
+func (l *taskList) UnmarshalYAML(node *yaml.Node) error {
+	type plain taskList
+
+	if node.Kind != yaml.MappingNode {
+		return node.Decode((*plain)(l))
+	}
+
+	mapping := *node
+	mapping.Content = nil
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if key := node.Content[i].Value; key != generateTasksKey && key != includesKey {
+			mapping.Content = append(mapping.Content, node.Content[i], node.Content[i+1])
+		}
+	}
+
+	return mapping.Decode((*plain)(l))
+}
+
+// Runs the generator of the config, unless it has none or --no-generate is
+// given, and keeps its output, which is merged into the tasks by
+// mergeGeneratedTasks. Its failure is reported when parsing the tasks.
+func (p *Parser) runGenerator() {
+	var c configGenerator
+	if yaml.Unmarshal([]byte(p.config), &c) != nil || c.GenerateTasks == "" || p.options.NoGenerate {
+		return
+	}
+
+	p.generator = c.GenerateTasks
+	p.generated, p.generateErr = generateTasks(c.GenerateTasks, filepath.Dir(p.configPath))
+}
+
+// Runs the command in dir, from the working directory when empty, and
+// returns what it printed. Commands which fail or run longer than
+// generateTimeout are errors, along with what they printed to stderr.
+func generateTasks(command string, dir string) (string, error) {
+	splitCmd, err := splitCommand(command)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", generateTasksKey, err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), generateTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, splitCmd[0], splitCmd[1:]...)
+	cmd.Dir = dir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	err = cmd.Run()
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return "", fmt.Errorf("%s: %s timed out after %s", generateTasksKey, command, generateTimeout)
+	}
+
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s: %s failed: %w: %s", generateTasksKey, command, err, msg)
+		}
+
+		return "", fmt.Errorf("%s: %s failed: %w", generateTasksKey, command, err)
+	}
+
+	return stdout.String(), nil
+}
+
+// Adds the tasks printed by the generator. Like the tasks of local
+// overrides, they can only be added, and only tasks can be generated.
+func (p *Parser) mergeGeneratedTasks(tasks taskList) error {
+	if p.generateErr != nil {
+		return p.generateErr
+	}
+
+	if strings.TrimSpace(p.generated) == "" {
+		return nil
+	}
+
+	var generated taskList
+	if err := decodeConfig(p.generator, p.generated, &generated); err != nil {
+		return fmt.Errorf("%s: output of %s: %w", generateTasksKey, p.generator, err)
+	}
+
+	for _, name := range sortedKeys(generated) {
+		if name == "global" || name == varsKey || name == hooksKey || name == placeholdersKey || name == includesKey {
+			return fmt.Errorf("%s: %s can only generate tasks, not %s", generateTasksKey, p.generator, name)
+		}
+
+		if _, ok := tasks[name]; ok {
+			return fmt.Errorf("task '%s' generated by %s is already defined in %s", name, p.generator, p.taskLocation(name))
+		}
+
+		task := generated[name]
+		task.Origin = p.generator
+		tasks[name] = task
+	}
+
+	return nil
+}
+
+// Where the task of the config, of its included files or of its local
+// overrides is declared, ie. goke.yml:12.
+func (p *Parser) taskLocation(name string) string {
+	if line := keyLine(p.config, name); line > 0 {
+		return fmt.Sprintf("%s:%d", p.configFile(), line)
+	}
+
+	for _, inc := range p.included {
+		local, ok := inc.localName(name)
+		if !ok {
+			continue
+		}
+
+		if line := keyLine(inc.config, local); line > 0 {
+			return fmt.Sprintf("%s:%d", inc.path, line)
+		}
+	}
+
+	return p.localConfigPath
+}
+
+// Writes where each task comes from, in the order they are declared, for
+// "goke config".
+func (p *Parser) WriteTaskOrigins(out io.Writer) {
+	names := []string{}
+	width := 0
+	for _, name := range declarationOrder(p.Tasks, keyLines(p.config)) {
+		if name == "global" {
+			continue
+		}
+
+		names = append(names, name)
+		if len(name) > width {
+			width = len(name)
+		}
+	}
+
+	for _, name := range names {
+		origin := p.taskLocation(name)
+		if task := p.Tasks[name]; task.Origin != "" {
+			origin = "generated by " + task.Origin
+		}
+
+		fmt.Fprintf(out, "%-*s  %s\n", width, name, origin)
+	}
+}
@@ -0,0 +1,122 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Writes a generator which prints the contents of its tasks file, and
+// returns the paths of both.
+func generatorFixture(t *testing.T, tasks string) (string, string) {
+	if runtime.GOOS == "windows" {
+		t.Skip("generator scripts need sh")
+	}
+
+	dir := t.TempDir()
+	tasksFile := filepath.Join(dir, "tasks.yml")
+	script := filepath.Join(dir, "gen-tasks")
+
+	require.Nil(t, os.WriteFile(tasksFile, []byte(tasks), 0644))
+	require.Nil(t, os.WriteFile(script, []byte(fmt.Sprintf("#!/bin/sh\ncat '%s'\n", tasksFile)), 0755))
+
+	return script, tasksFile
+}
+
+// An in-memory env of the config, loaded as goke.yml so that errors name it.
+func newGeneratorEnv(config string) *InMemoryEnv {
+	env := NewInMemoryEnv(config)
+	env.Options.ConfigPath = "goke.yml"
+
+	return env
+}
+
+const generatedTasks = `
+deploy-api:
+  deps: [build]
+  run:
+    - "deploy api"
+
+deploy-web:
+  run:
+    - "deploy web"
+`
+
+func TestGenerateTasks(t *testing.T) {
+	t.Parallel()
+
+	script, _ := generatorFixture(t, generatedTasks)
+	env := newGeneratorEnv(fmt.Sprintf("generate_tasks: %s\n\nbuild:\n  run:\n    - \"go build\"\n", script))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, script, p.Tasks["deploy-api"].Origin)
+	require.Empty(t, p.Tasks["build"].Origin)
+	require.NotContains(t, p.Tasks, generateTasksKey)
+
+	require.Nil(t, env.Run("deploy-api"))
+	require.Equal(t, []string{"go build", "deploy api"}, recordedCommands(env))
+
+	var out bytes.Buffer
+	p.WriteTaskOrigins(&out)
+	require.Equal(t, fmt.Sprintf(
+		"build       goke.yml:3\ndeploy-api  generated by %s\ndeploy-web  generated by %s\n", script, script,
+	), out.String())
+}
+
+func TestGeneratedTasksCantRedefineTasks(t *testing.T) {
+	t.Parallel()
+
+	script, _ := generatorFixture(t, "build:\n  run: [make]\n")
+	_, err := newGeneratorEnv(fmt.Sprintf("generate_tasks: %s\n\nbuild:\n  run:\n    - \"go build\"\n", script)).Parse()
+	require.EqualError(t, err, fmt.Sprintf("task 'build' generated by %s is already defined in goke.yml:3", script))
+
+	script, _ = generatorFixture(t, "global:\n  shell: true\n")
+	_, err = NewInMemoryEnv(fmt.Sprintf("generate_tasks: %s\n", script)).Parse()
+	require.EqualError(t, err, fmt.Sprintf("generate_tasks: %s can only generate tasks, not global", script))
+}
+
+func TestGenerateTasksFailure(t *testing.T) {
+	t.Parallel()
+
+	script, _ := generatorFixture(t, "")
+	require.Nil(t, os.WriteFile(script, []byte("#!/bin/sh\necho 'topology unavailable' >&2\nexit 3\n"), 0755))
+
+	_, err := NewInMemoryEnv(fmt.Sprintf("generate_tasks: %s\n", script)).Parse()
+	require.EqualError(t, err, fmt.Sprintf("generate_tasks: %s failed: exit status 3: topology unavailable", script))
+
+	// The tasks of the config are still there for offline inspection.
+	env := NewInMemoryEnv(fmt.Sprintf("generate_tasks: %s\n\nbuild:\n  run: [make]\n", script))
+	env.Options.NoGenerate = true
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, []string{"build"}, sortedKeys(p.Tasks))
+}
+
+func TestGeneratorOutputInvalidatesCache(t *testing.T) {
+	t.Parallel()
+
+	script, tasksFile := generatorFixture(t, generatedTasks)
+	config := fmt.Sprintf("generate_tasks: %s\n\nbuild:\n  run: [make]\n", script)
+	fs := NewMemFileSystem("/work")
+	require.Nil(t, fs.WriteFile("/work/goke.yml", []byte(config), 0644))
+
+	opts := Options{Quiet: true}
+	p := NewParser(config, &opts, fs)
+	require.Nil(t, p.Bootstrap())
+
+	p = NewParser(config, &opts, fs)
+	require.True(t, p.cached)
+
+	require.Nil(t, os.WriteFile(tasksFile, []byte("deploy-db:\n  run: [\"deploy db\"]\n"), 0644))
+	p = NewParser(config, &opts, fs)
+	require.False(t, p.cached)
+	require.Nil(t, p.Bootstrap())
+	require.Contains(t, p.Tasks, "deploy-db")
+	require.NotContains(t, p.Tasks, "deploy-api")
+}
@@ -0,0 +1,226 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("config.hooks", "run.staged_files_placeholder", "command.hooks", "command.hook")
+}
+
+// The top-level key mapping git hooks to the tasks they run, which is not
+// a task.
+const hooksKey = "hooks"
+
+// The placeholder in "run" entries which gets replaced by the staged files
+// matching the task's patterns, see stagedTaskFiles.
+const StagedFilesPlaceholder = "{STAGED_FILES}"
+
+// Marks the hook scripts written by goke, which are the only ones it
+// overwrites or removes.
+const gitHookMarker = "# Installed by goke hooks install"
+
+// The client-side hooks of git, which goke can install.
+var gitHooks = map[string]bool{
+	"applypatch-msg":     true,
+	"pre-applypatch":     true,
+	"post-applypatch":    true,
+	"pre-commit":         true,
+	"pre-merge-commit":   true,
+	"prepare-commit-msg": true,
+	"commit-msg":         true,
+	"post-commit":        true,
+	"pre-rebase":         true,
+	"post-checkout":      true,
+	"post-merge":         true,
+	"pre-push":           true,
+	"post-rewrite":       true,
+	"pre-auto-gc":        true,
+}
+
+// The top-level hooks of the config, ie. pre-commit: [fmt, lint].
+type configHooks struct {
+	Hooks map[string][]string `yaml:"hooks,omitempty"`
+}
+
+// Parses the top-level hooks, which must be git hooks running declared tasks.
+func (p *Parser) parseHooks(tasks taskList) error {
+	var c configHooks
+	if err := decodeConfig(p.configFile(), p.config, &c); err != nil {
+		return err
+	}
+
+	for _, hook := range sortedKeys(c.Hooks) {
+		if !gitHooks[hook] {
+			return fmt.Errorf("hooks: unknown git hook '%s'", hook)
+		}
+
+		for _, name := range c.Hooks[hook] {
+			if _, ok := tasks[name]; !ok || name == "global" {
+				return fmt.Errorf("hooks: %s runs unknown task '%s'", hook, name)
+			}
+		}
+	}
+
+	p.Hooks = c.Hooks
+	return nil
+}
+
+// The directory git runs the hooks of the repository from, which is
+// .git/hooks unless core.hooksPath says otherwise.
+func gitHooksDir(git gitFunc) (string, error) {
+	out, err := git("rev-parse", "--git-path", "hooks")
+	if err != nil {
+		return "", fmt.Errorf("hooks need a git repository: %w", err)
+	}
+
+	return filepath.FromSlash(strings.TrimSpace(string(out))), nil
+}
+
+// The script of a hook, which runs "goke hook <name>" from the directory
+// of the config. Commits aren't blocked on machines without goke.
+func gitHookScript(hook string, prefix string) string {
+	cd := ""
+	if prefix != "" {
+		cd = fmt.Sprintf("cd '%s' || exit 1\n", strings.TrimSuffix(prefix, "/"))
+	}
+
+	return fmt.Sprintf(`#!/bin/sh
+%s
+if ! command -v goke >/dev/null 2>&1; then
+  echo "goke is not on PATH, skipping the %s hook" >&2
+  exit 0
+fi
+
+%sexec goke hook %s
+`, gitHookMarker, hook, cd, hook)
+}
+
+// Whether the hook script at path was written by goke.
+func isGokeHook(path string) bool {
+	content, err := os.ReadFile(path)
+	return err == nil && strings.Contains(string(content), gitHookMarker)
+}
+
+// Writes the scripts of the given hooks into the hooks directory of the
+// repository in the working directory, and removes the ones goke wrote for
+// other hooks, so that installing again is always safe. Hooks which goke
+// didn't write are left alone, and fail the install.
+func InstallGitHooks(hooks []string) (installed []string, removed []string, err error) {
+	dir, err := gitHooksDir(runGit)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out, err := runGit("rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, nil, err
+	}
+	prefix := strings.TrimSpace(string(out))
+
+	wanted := make(map[string]bool, len(hooks))
+	for _, hook := range hooks {
+		wanted[hook] = true
+
+		path := filepath.Join(dir, hook)
+		if _, err := os.Stat(path); err == nil && !isGokeHook(path) {
+			return nil, nil, fmt.Errorf("%s was not installed by goke, remove it to let goke manage the %s hook", path, hook)
+		}
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+
+	for _, hook := range hooks {
+		if err := os.WriteFile(filepath.Join(dir, hook), []byte(gitHookScript(hook, prefix)), 0755); err != nil {
+			return nil, nil, err
+		}
+		installed = append(installed, hook)
+	}
+
+	for _, hook := range sortedKeys(gitHooks) {
+		if wanted[hook] {
+			continue
+		}
+
+		path := filepath.Join(dir, hook)
+		if isGokeHook(path) {
+			if err := os.Remove(path); err != nil {
+				return nil, nil, err
+			}
+			removed = append(removed, hook)
+		}
+	}
+
+	return installed, removed, nil
+}
+
+// Removes the hook scripts goke wrote, see InstallGitHooks.
+func UninstallGitHooks() ([]string, error) {
+	dir, err := gitHooksDir(runGit)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := []string{}
+	for _, hook := range sortedKeys(gitHooks) {
+		path := filepath.Join(dir, hook)
+		if !isGokeHook(path) {
+			continue
+		}
+
+		if err := os.Remove(path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+		removed = append(removed, hook)
+	}
+
+	return removed, nil
+}
+
+// The files staged in git which match the patterns of the task, relative to
+// the working directory. Tasks without files get all of them.
+func (e *Executor) stagedTaskFiles(task Task) ([]string, error) {
+	git := e.git
+	if git == nil {
+		git = runGit
+	}
+
+	out, err := git("diff", "--cached", "--name-only", "--relative", "--diff-filter=ACMR")
+	if err != nil {
+		return nil, fmt.Errorf("%s needs a git repository: %w", StagedFilesPlaceholder, err)
+	}
+
+	files := []string{}
+	for _, line := range strings.Split(string(out), "\n") {
+		f := filepath.FromSlash(strings.TrimSpace(line))
+		if f != "" && (len(task.FilePatterns) == 0 || readsFile(task.FilePatterns, f)) {
+			files = append(files, f)
+		}
+	}
+
+	return files, nil
+}
+
+// Whether the file is one of the files of a task with the patterns.
+func readsFile(patterns []string, file string) bool {
+	included := false
+
+	for _, pattern := range patterns {
+		if isExclusion(pattern) {
+			if globMatch(strings.TrimPrefix(pattern, "!"), file) {
+				return false
+			}
+		} else if globMatch(pattern, file) {
+			included = true
+		}
+	}
+
+	return included
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Creates a git repository in a temp directory and works from its dir
+// subdirectory, like a goke.yml which isn't at the root of the repository.
+func gitRepoFixture(t *testing.T, dir string) string {
+	if runtime.GOOS == "windows" {
+		t.Skip("hook scripts need sh")
+	}
+
+	root := t.TempDir()
+	gitIn(t, root, "init", "-q")
+	require.Nil(t, os.MkdirAll(filepath.Join(root, dir), 0755))
+	chdir(t, filepath.Join(root, dir))
+
+	return root
+}
+
+func gitIn(t *testing.T, dir string, args ...string) {
+	cmd := exec.Command("git", append([]string{"-c", "user.name=goke", "-c", "user.email=goke@example.com"}, args...)...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	require.Nil(t, err, string(out))
+}
+
+func TestInstallGitHooks(t *testing.T) {
+	root := gitRepoFixture(t, "app")
+	hooksDir := filepath.Join(root, ".git", "hooks")
+
+	installed, removed, err := InstallGitHooks([]string{"pre-commit", "pre-push"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"pre-commit", "pre-push"}, installed)
+	require.Empty(t, removed)
+
+	script, err := os.ReadFile(filepath.Join(hooksDir, "pre-commit"))
+	require.Nil(t, err)
+	require.Contains(t, string(script), "cd 'app' || exit 1\nexec goke hook pre-commit\n")
+
+	info, err := os.Stat(filepath.Join(hooksDir, "pre-push"))
+	require.Nil(t, err)
+	require.NotZero(t, info.Mode()&0100)
+
+	// Installing again drops the hooks the config no longer has.
+	installed, removed, err = InstallGitHooks([]string{"pre-commit"})
+	require.Nil(t, err)
+	require.Equal(t, []string{"pre-commit"}, installed)
+	require.Equal(t, []string{"pre-push"}, removed)
+	require.NoFileExists(t, filepath.Join(hooksDir, "pre-push"))
+
+	// Hooks goke didn't write are never touched.
+	require.Nil(t, os.WriteFile(filepath.Join(hooksDir, "commit-msg"), []byte("#!/bin/sh\nexit 0\n"), 0755))
+	_, _, err = InstallGitHooks([]string{"commit-msg"})
+	require.ErrorContains(t, err, "was not installed by goke, remove it to let goke manage the commit-msg hook")
+	require.FileExists(t, filepath.Join(hooksDir, "pre-commit"))
+
+	removed, err = UninstallGitHooks()
+	require.Nil(t, err)
+	require.Equal(t, []string{"pre-commit"}, removed)
+	require.NoFileExists(t, filepath.Join(hooksDir, "pre-commit"))
+	require.FileExists(t, filepath.Join(hooksDir, "commit-msg"))
+}
+
+func TestInstallGitHooksFollowsHooksPath(t *testing.T) {
+	root := gitRepoFixture(t, ".")
+	gitIn(t, root, "config", "core.hooksPath", ".githooks")
+
+	_, _, err := InstallGitHooks([]string{"pre-commit"})
+	require.Nil(t, err)
+
+	script, err := os.ReadFile(filepath.Join(root, ".githooks", "pre-commit"))
+	require.Nil(t, err)
+	require.NotContains(t, string(script), "cd ")
+	require.NoFileExists(t, filepath.Join(root, ".git", "hooks", "pre-commit"))
+}
+
+func TestGitHookRunsGoke(t *testing.T) {
+	root := gitRepoFixture(t, "app")
+	_, _, err := InstallGitHooks([]string{"pre-commit"})
+	require.Nil(t, err)
+
+	// A goke which records how the hook ran it.
+	bin := t.TempDir()
+	require.Nil(t, os.WriteFile(filepath.Join(bin, "goke"), []byte("#!/bin/sh\necho \"$(pwd) $*\" > \"$GOKE_CALLS\"\n"), 0755))
+	calls := filepath.Join(t.TempDir(), "calls")
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("GOKE_CALLS", calls)
+
+	gitIn(t, root, "commit", "-q", "--allow-empty", "-m", "first")
+
+	out, err := os.ReadFile(calls)
+	require.Nil(t, err)
+	wd, err := os.Getwd()
+	require.Nil(t, err)
+	require.Equal(t, wd+" hook pre-commit\n", string(out))
+}
+
+func TestGitHookSkipsWithoutGoke(t *testing.T) {
+	gitRepoFixture(t, ".")
+	_, _, err := InstallGitHooks([]string{"pre-commit"})
+	require.Nil(t, err)
+
+	cmd := exec.Command("/bin/sh", filepath.Join(".git", "hooks", "pre-commit"))
+	cmd.Env = []string{"PATH=" + t.TempDir()}
+	out, err := cmd.CombinedOutput()
+	require.Nil(t, err)
+	require.Equal(t, "goke is not on PATH, skipping the pre-commit hook\n", string(out))
+}
+
+func TestStagedFilesPlaceholder(t *testing.T) {
+	root := gitRepoFixture(t, ".")
+	for _, f := range []string{"main.go", "util.go", "util_test.go", "README.md", "docs/guide.md"} {
+		require.Nil(t, os.MkdirAll(filepath.Dir(filepath.Join(root, f)), 0755))
+		require.Nil(t, os.WriteFile(filepath.Join(root, f), []byte(f), 0644))
+	}
+	gitIn(t, root, "add", "util.go", "util_test.go", "README.md")
+
+	env := NewInMemoryEnv(`
+fmt:
+  files: ["*.go", "!*_test.go"]
+  run:
+    - "gofmt -w {STAGED_FILES}"
+
+docs:
+  files: [docs/*.md]
+  run:
+    - "markdownlint {STAGED_FILES}"
+    - "echo docs"
+
+staged:
+  run:
+    - "git diff --check {STAGED_FILES}"
+`)
+	env.Options.Force = true
+
+	require.Nil(t, env.Run("fmt", "docs", "staged"))
+	require.Equal(t, []string{
+		"gofmt -w util.go",
+		"echo docs",
+		"git diff --check README.md util.go util_test.go",
+	}, recordedCommands(env))
+}
+
+func TestHooksMustRunTasksOfGitHooks(t *testing.T) {
+	t.Parallel()
+
+	for config, msg := range map[string]string{
+		"hooks:\n  pre-comit: [fmt]\nfmt:\n  run: [gofmt]\n":  "hooks: unknown git hook 'pre-comit'",
+		"hooks:\n  pre-commit: [fnt]\nfmt:\n  run: [gofmt]\n": "hooks: pre-commit runs unknown task 'fnt'",
+	} {
+		_, err := NewInMemoryEnv(config).Parse()
+		require.EqualError(t, err, msg)
+	}
+
+	p, err := NewInMemoryEnv("hooks:\n  pre-commit: [fmt]\nfmt:\n  run: [gofmt]\n").Parse()
+	require.Nil(t, err)
+	require.Equal(t, map[string][]string{"pre-commit": {"fmt"}}, p.Hooks)
+	require.NotContains(t, p.Tasks, "hooks")
+}
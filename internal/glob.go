@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"io/fs"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("files.doublestar")
+	RegisterCapability("files.exclude")
+}
+
+// Whether the entry of a files section excludes files, ie. "!vendor/**".
+func isExclusion(pattern string) bool {
+	return strings.HasPrefix(pattern, "!")
+}
+
+// Whether the path matches the pattern. Works like filepath.Match, except
+// that a "**" segment matches any number of directories, including none.
+func globMatch(pattern string, name string) bool {
+	return matchSegments(splitSegments(pattern), splitSegments(name))
+}
+
+func splitSegments(p string) []string {
+	return strings.Split(filepath.ToSlash(filepath.Clean(p)), "/")
+}
+
+func matchSegments(pattern []string, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pattern[1:], name[i:]) {
+					return true
+				}
+			}
+
+			return false
+		}
+
+		if len(name) == 0 {
+			return false
+		}
+
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+
+		pattern, name = pattern[1:], name[1:]
+	}
+
+	return len(name) == 0
+}
+
+// Whether the path matches one of the patterns of a files section, and none
+// of its "!" exclusions.
+func matchesPatterns(patterns []string, name string) bool {
+	included := false
+
+	for _, pattern := range patterns {
+		if isExclusion(pattern) {
+			if globMatch(strings.TrimPrefix(pattern, "!"), name) {
+				return false
+			}
+		} else if globMatch(pattern, name) {
+			included = true
+		}
+	}
+
+	return included
+}
+
+// Whether the path matches one of the patterns.
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if globMatch(pattern, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The longest leading directory of the pattern without wildcards, which
+// is where the files matching it are searched.
+func globBase(pattern string) string {
+	segments := strings.Split(filepath.Clean(pattern), string(filepath.Separator))
+
+	for i, segment := range segments {
+		if hasGlobMeta(segment) {
+			if i == 0 {
+				return "."
+			}
+
+			if base := strings.Join(segments[:i], string(filepath.Separator)); base != "" {
+				return base
+			}
+
+			return string(filepath.Separator)
+		}
+	}
+
+	return filepath.Dir(filepath.Clean(pattern))
+}
+
+// Returns the files matching a pattern with "**" segments, sorted. The tree
+// below the base of the pattern is walked, following symlinks to directories
+// but visiting each directory only once. Unreadable directories are skipped,
+// and so are .goke directories, whose lockfile changes with every run.
+func globRecursive(fsys FileSystem, pattern string) ([]string, error) {
+	if _, err := path.Match(filepath.ToSlash(pattern), ""); err != nil {
+		return nil, err
+	}
+
+	matches := []string{}
+	visited := make(map[string]bool)
+
+	var walk func(dir string)
+	walk = func(dir string) {
+		real, err := fsys.EvalSymlinks(dir)
+		if err != nil || visited[real] {
+			return
+		}
+		visited[real] = true
+
+		entries, err := fsys.ReadDir(dir)
+		if err != nil {
+			return
+		}
+
+		for _, entry := range entries {
+			p := filepath.Join(dir, entry.Name())
+			isDir := entry.IsDir()
+
+			if entry.Type()&fs.ModeSymlink != 0 {
+				info, err := fsys.Stat(p)
+				if err != nil {
+					continue
+				}
+				isDir = info.IsDir()
+			}
+
+			if isDir {
+				if entry.Name() != stateDirName {
+					walk(p)
+				}
+			} else if globMatch(pattern, p) {
+				matches = append(matches, p)
+			}
+		}
+	}
+
+	walk(globBase(pattern))
+	sort.Strings(matches)
+
+	return matches, nil
+}
@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobMatch(t *testing.T) {
+	cases := []struct {
+		pattern string
+		name    string
+		matches bool
+	}{
+		{"*.go", "main.go", true},
+		{"*.go", "internal/main.go", false},
+		{"**/*.go", "main.go", true},
+		{"**/*.go", "internal/cli/options.go", true},
+		{"internal/**/*.go", "internal/parser.go", true},
+		{"internal/**/*.go", "internal/tests/fs.go", true},
+		{"internal/**/*.go", "cmd/main.go", false},
+		{"internal/**", "internal/tests/fs.go", true},
+		{"vendor/**", "vendor", true},
+		{"src/**/test/*.txt", "src/a/b/test/x.txt", true},
+		{"src/**/test/*.txt", "src/a/b/x.txt", false},
+		{"./docs/*.md", "docs/index.md", true},
+	}
+
+	for _, c := range cases {
+		require.Equal(t, c.matches, globMatch(c.pattern, c.name), "%s ~ %s", c.pattern, c.name)
+	}
+}
+
+func TestMatchesPatternsAppliesExclusions(t *testing.T) {
+	patterns := []string{"**/*.go", "!vendor/**", "!**/*_test.go"}
+
+	require.True(t, matchesPatterns(patterns, "internal/parser.go"))
+	require.False(t, matchesPatterns(patterns, "vendor/pkg/lib.go"))
+	require.False(t, matchesPatterns(patterns, "internal/parser_test.go"))
+	require.False(t, matchesPatterns(patterns, "README.md"))
+}
+
+func TestGlobRecursive(t *testing.T) {
+	fs := NewMemFileSystem("/work")
+	for _, f := range []string{"main.go", "internal/parser.go", "internal/tests/fs.go", "internal/README.md", ".goke/cache.go"} {
+		require.Nil(t, fs.WriteFile(f, []byte(f), 0644))
+	}
+
+	// Links to directories are followed, without looping forever.
+	require.Nil(t, fs.WriteFile("/shared/gen.go", []byte("gen"), 0644))
+	require.Nil(t, fs.Symlink("/shared", "linked"))
+	require.Nil(t, fs.Symlink("../internal", "internal/tests/loop"))
+
+	matches, err := globRecursive(fs, "**/*.go")
+	require.Nil(t, err)
+	require.Equal(t, []string{"internal/parser.go", "internal/tests/fs.go", "linked/gen.go", "main.go"}, matches)
+
+	matches, err = globRecursive(fs, "internal/**/*.go")
+	require.Nil(t, err)
+	require.Equal(t, []string{"internal/parser.go", "internal/tests/fs.go"}, matches)
+
+	matches, err = globRecursive(fs, "/work/internal/**/*.md")
+	require.Nil(t, err)
+	require.Equal(t, []string{"/work/internal/README.md"}, matches)
+
+	_, err = globRecursive(fs, "**/[.go")
+	require.NotNil(t, err)
+}
+
+func TestFilesExclusionsAndUnmatchedPatterns(t *testing.T) {
+	config := `
+build:
+  files: ["**/*.go", "!vendor/**", "*.proto"]
+  run:
+    - "go build"
+`
+	env := NewInMemoryEnv(config)
+	for _, f := range []string{"main.go", "internal/parser.go", "vendor/lib/lib.go"} {
+		require.Nil(t, env.FS.WriteFile(f, []byte(f), 0644))
+	}
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, []string{"internal/parser.go", "main.go"}, p.Tasks["build"].Files)
+	require.Equal(t, []string{`task 'build': files pattern "*.proto" matches no files`}, p.Warnings)
+
+	// Files created later are picked up, and still excluded.
+	require.Nil(t, env.FS.WriteFile("cmd/cli/main.go", []byte("main"), 0644))
+	require.Nil(t, env.FS.WriteFile("vendor/other/other.go", []byte("other"), 0644))
+	require.Equal(t, []string{"cmd/cli/main.go", "internal/parser.go", "main.go"}, p.taskFiles(p.Tasks["build"]))
+}
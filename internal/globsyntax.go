@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Syntax of files patterns which not every goke version supports, by the
+// capability of the feature. A pattern using a feature this build doesn't
+// have fails to parse instead of silently matching nothing, which would
+// make its task run every time or never.
+var globFeatures = []struct {
+	id     string
+	syntax string
+	uses   func(pattern string) bool
+}{
+	{id: "files.exclude", syntax: `"!" exclusions`, uses: isExclusion},
+	{id: "files.doublestar", syntax: `"**" segments`, uses: func(pattern string) bool {
+		return strings.Contains(pattern, "**")
+	}},
+	{id: "files.braces", syntax: "{a,b} alternatives", uses: braceRegexp.MatchString},
+	{id: "files.directories", syntax: "directories", uses: func(pattern string) bool {
+		return strings.HasSuffix(pattern, "/") || strings.HasSuffix(pattern, `\`)
+	}},
+}
+
+var braceRegexp = regexp.MustCompile(`\{[^{}]*,[^{}]*\}`)
+
+// A files pattern using a feature this build doesn't support.
+type UnsupportedPatternError struct {
+	Pattern string
+	Feature string
+	Syntax  string
+}
+
+func (e *UnsupportedPatternError) Error() string {
+	return fmt.Sprintf("files pattern \"%s\" uses feature %s (%s) not supported by this build of goke (%s)", e.Pattern, e.Feature, e.Syntax, Version)
+}
+
+// Fails when the pattern uses a feature which this build doesn't support,
+// see globFeatures.
+func checkPatternFeatures(pattern string) error {
+	for _, f := range globFeatures {
+		if f.uses(pattern) && !HasCapability(f.id) {
+			return &UnsupportedPatternError{Pattern: pattern, Feature: f.id, Syntax: f.syntax}
+		}
+	}
+
+	return nil
+}
+
+// Fails when the pattern names a directory, unless this build expands
+// directories into their files.
+func (p *Parser) checkDirectoryPattern(pattern string, path string) error {
+	if HasCapability("files.directories") || hasGlobMeta(path) {
+		return nil
+	}
+
+	if info, err := p.fs.Stat(path); err == nil && info.IsDir() {
+		return &UnsupportedPatternError{Pattern: pattern, Feature: "files.directories", Syntax: "directories"}
+	}
+
+	return nil
+}
@@ -0,0 +1,77 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Disables the feature until the test ends. Tests using it mustn't be
+// parallel, as they change the capabilities of the build.
+func withoutCapability(t *testing.T, id string) {
+	t.Helper()
+
+	had := features[id]
+	delete(features, id)
+	t.Cleanup(func() {
+		if had {
+			features[id] = true
+		}
+	})
+}
+
+func TestCheckPatternFeatures(t *testing.T) {
+	cases := []struct {
+		pattern string
+		feature string
+	}{
+		{"internal/**/*.go", "files.doublestar"},
+		{"!vendor/**", "files.exclude"},
+		{"*.{go,mod}", "files.braces"},
+		{"docs/", "files.directories"},
+	}
+
+	for _, c := range cases {
+		withoutCapability(t, c.feature)
+
+		err := checkPatternFeatures(c.pattern)
+		var unsupported *UnsupportedPatternError
+		require.ErrorAs(t, err, &unsupported, c.pattern)
+		require.Equal(t, c.feature, unsupported.Feature)
+		require.ErrorContains(t, err, "files pattern \""+c.pattern+"\" uses feature "+c.feature)
+		require.NotContains(t, GetCapabilities().Features, c.feature)
+	}
+}
+
+func TestCheckPatternFeaturesAcceptsSupportedSyntax(t *testing.T) {
+	for _, pattern := range []string{"**/*.go", "!vendor/**", "*.go", "cmd/main.go", "[ab].txt", "{FILES}"} {
+		require.NoError(t, checkPatternFeatures(pattern), pattern)
+	}
+}
+
+func TestParseRejectsUnsupportedPatterns(t *testing.T) {
+	withoutCapability(t, "files.doublestar")
+
+	env := NewInMemoryEnv(`
+build:
+  files: ["src/**/*.go"]
+  run:
+    - "go build"
+`)
+
+	_, err := env.Parse()
+	require.EqualError(t, err, "task 'build': files pattern \"src/**/*.go\" uses feature files.doublestar (\"**\" segments) not supported by this build of goke ("+Version+")")
+}
+
+func TestParseRejectsDirectoryPatterns(t *testing.T) {
+	env := NewInMemoryEnv(`
+docs:
+  files: [docs]
+  run:
+    - "make docs"
+`)
+	require.NoError(t, env.FS.WriteFile("/work/docs/index.md", []byte("# Docs"), 0644))
+
+	_, err := env.Parse()
+	require.ErrorContains(t, err, "task 'docs': files pattern \"docs\" uses feature files.directories")
+}
@@ -0,0 +1,188 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("run.golden")
+}
+
+// The flag rewriting the golden files of the run, and the one of golden
+// entries without an update_flag.
+const defaultUpdateFlag = "--update-golden"
+
+// The normalizations applied to the output of a golden entry and to its golden
+// file before comparing them, see normalizeGolden.
+const (
+	// Turns "\r\n" into "\n".
+	normalizeLineEndings = "line_endings"
+
+	// Drops the spaces and tabs at the end of the lines and the empty lines
+	// at the end.
+	normalizeTrailingWhitespace = "trailing_whitespace"
+)
+
+// Fails unless the settings of golden entries only appear along with golden
+// and their normalizations are known.
+func validateGolden(entry RunEntry, line int) error {
+	if entry.Golden == "" {
+		if entry.UpdateFlag != "" || len(entry.Normalize) != 0 {
+			return fmt.Errorf("line %d: \"update_flag\" and \"normalize\" only apply to \"golden\" entries", line)
+		}
+
+		return nil
+	}
+
+	for _, n := range entry.Normalize {
+		if n != normalizeLineEndings && n != normalizeTrailingWhitespace {
+			return fmt.Errorf("line %d: unknown normalization '%s', expected %s or %s", line, n, normalizeLineEndings, normalizeTrailingWhitespace)
+		}
+	}
+
+	return nil
+}
+
+// The flag which rewrites the golden file of the entry, see updatesGolden.
+func (r RunEntry) updateFlag() string {
+	if r.UpdateFlag != "" {
+		return r.UpdateFlag
+	}
+
+	return defaultUpdateFlag
+}
+
+// Applies the normalizations of the entry to the text.
+func (r RunEntry) normalizeGolden(text string) string {
+	for _, n := range r.Normalize {
+		switch n {
+		case normalizeLineEndings:
+			text = strings.ReplaceAll(text, "\r\n", "\n")
+		case normalizeTrailingWhitespace:
+			lines := strings.Split(strings.TrimRight(text, " \t\r\n"), "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimRight(line, " \t")
+			}
+
+			text = strings.Join(lines, "\n")
+			if text != "" {
+				text += "\n"
+			}
+		}
+	}
+
+	return text
+}
+
+// Whether the run rewrites the golden file of the entry: with --update-golden,
+// or with the update_flag of the entry after "--".
+func (e *Executor) updatesGolden(entry RunEntry) bool {
+	if e.options.UpdateGolden {
+		return true
+	}
+
+	for _, arg := range e.options.ExtraArgs {
+		if arg == entry.updateFlag() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Whether the argument is the update_flag of a golden entry, which isn't
+// meant for {ARGS}.
+func (p *Parser) isGoldenUpdateFlag(arg string) bool {
+	for _, task := range p.Tasks {
+		for _, entry := range task.Run {
+			if entry.Golden != "" && entry.updateFlag() == arg {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// The arguments after "--" which replace {ARGS}, without the update flags of
+// golden entries.
+func (e *Executor) commandArgs() []string {
+	var args []string
+	for _, arg := range e.options.ExtraArgs {
+		if !e.parser.isGoldenUpdateFlag(arg) {
+			args = append(args, arg)
+		}
+	}
+
+	return args
+}
+
+// Runs the command of a golden entry with its stdout captured, and compares
+// it with the golden file, see checkGolden.
+func (e *Executor) runGolden(entry RunEntry, env map[string]string) error {
+	var out []byte
+
+	err := e.withRetries(entry, func() error {
+		p, err := e.prepareCommand(entry, env)
+		if err != nil {
+			return err
+		}
+
+		captured := e.capture.begin(entry.task, p.line)
+
+		if p.builtin != nil {
+			var stdout bytes.Buffer
+			err = newCommandError(p.line, p.builtin(p.args, entry.Dir, captured.teeStdout(&stdout)), nil)
+			out = stdout.Bytes()
+		} else {
+			stall := e.watchStall(entry, p.line)
+			out, err = e.capturedOutput(p.cmd, captured, stall, entry.timeout)
+			stall.end()
+			err = newCommandError(p.line, err, p.enc)
+			out = decodeOutput(out, p.enc)
+		}
+
+		captured.finish(err)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	return e.checkGolden(entry, string(out))
+}
+
+// Compares the output of the command with the golden file, failing with
+// their diff when they differ. When the run updates the golden file, it's
+// written instead, which also creates missing ones.
+func (e *Executor) checkGolden(entry RunEntry, out string) error {
+	out = entry.normalizeGolden(out)
+
+	if e.updatesGolden(entry) {
+		if err := e.parser.fs.WriteFile(entry.Golden, []byte(out), 0644); err != nil {
+			return err
+		}
+
+		e.logVerbose(fmt.Sprintf("Updated %s", entry.Golden))
+		return nil
+	}
+
+	golden, err := e.parser.fs.ReadFile(entry.Golden)
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("golden file %s doesn't exist, run with %s to create it", entry.Golden, entry.updateFlag())
+	}
+	if err != nil {
+		return err
+	}
+
+	diff := unifiedDiff(entry.Golden, "output of "+entry.label(), entry.normalizeGolden(string(golden)), out)
+	if diff == "" {
+		return nil
+	}
+
+	return fmt.Errorf("the output of %s differs from %s, run with %s to update it:\n%s", entry.label(), entry.Golden, entry.updateFlag(), strings.TrimRight(colorizeDiff(diff), "\n"))
+}
@@ -0,0 +1,120 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const goldenConfig = `
+check:
+  run:
+    - cmd: "./scripts/dump-schema"
+      golden: schema.sql
+      normalize: [line_endings, trailing_whitespace]
+    - cmd: "./scripts/gen-openapi"
+      golden: openapi.yml
+      update_flag: --update-openapi
+`
+
+// An in-memory env of goldenConfig whose commands print the given outputs.
+func newGoldenEnv(schema string, openapi string) *InMemoryEnv {
+	env := NewInMemoryEnv(goldenConfig)
+	env.Options.Force = true
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		switch cmd.Args[0] {
+		case "./scripts/dump-schema":
+			fmt.Fprint(cmd.Stdout, schema)
+		case "./scripts/gen-openapi":
+			fmt.Fprint(cmd.Stdout, openapi)
+		}
+		return nil
+	}
+
+	return env
+}
+
+func readGolden(t *testing.T, env *InMemoryEnv, name string) string {
+	contents, err := env.FS.ReadFile(name)
+	require.Nil(t, err)
+
+	return string(contents)
+}
+
+func TestGoldenMatches(t *testing.T) {
+	t.Parallel()
+
+	env := newGoldenEnv("CREATE TABLE users (id int);  \r\n\r\n", "openapi: 3.0.0\n")
+	require.Nil(t, env.FS.WriteFile("schema.sql", []byte("CREATE TABLE users (id int);\n"), 0644))
+	require.Nil(t, env.FS.WriteFile("openapi.yml", []byte("openapi: 3.0.0\n"), 0644))
+
+	require.Nil(t, env.Run("check"))
+	require.Equal(t, []string{"./scripts/dump-schema", "./scripts/gen-openapi"}, recordedCommands(env))
+}
+
+func TestGoldenMismatchFailsWithDiff(t *testing.T) {
+	t.Parallel()
+
+	env := newGoldenEnv("CREATE TABLE users (id int);\nCREATE TABLE teams (id int);\n", "")
+	require.Nil(t, env.FS.WriteFile("schema.sql", []byte("CREATE TABLE users (id int);\n"), 0644))
+
+	err := env.Run("check")
+	require.EqualError(t, err, `the output of ./scripts/dump-schema differs from schema.sql, run with --update-golden to update it:
+--- schema.sql
++++ output of ./scripts/dump-schema
+@@ -1 +1,2 @@
+ CREATE TABLE users (id int);
++CREATE TABLE teams (id int);`)
+	require.Equal(t, "CREATE TABLE users (id int);\n", readGolden(t, env, "schema.sql"))
+}
+
+func TestGoldenUpdate(t *testing.T) {
+	t.Parallel()
+
+	env := newGoldenEnv("CREATE TABLE users (id int);  \n", "openapi: 3.1.0\n")
+	require.Nil(t, env.FS.WriteFile("schema.sql", []byte("CREATE TABLE teams (id int);\n"), 0644))
+	require.Nil(t, env.FS.WriteFile("openapi.yml", []byte("openapi: 3.0.0\n"), 0644))
+
+	// The update_flag after "--" only rewrites its own golden file.
+	env.Options.ExtraArgs = []string{"--update-openapi"}
+	require.ErrorContains(t, env.Run("check"), "differs from schema.sql")
+
+	env = newGoldenEnv("CREATE TABLE users (id int);  \n", "openapi: 3.1.0\n")
+	env.Options.ExtraArgs = []string{"--update-openapi"}
+	require.Nil(t, env.FS.WriteFile("schema.sql", []byte("CREATE TABLE users (id int);\n"), 0644))
+	require.Nil(t, env.Run("check"))
+	require.Equal(t, "openapi: 3.1.0\n", readGolden(t, env, "openapi.yml"))
+
+	// --update-golden rewrites all of them, normalized.
+	env = newGoldenEnv("CREATE TABLE users (id int);  \n", "openapi: 3.1.0\n")
+	env.Options.UpdateGolden = true
+	require.Nil(t, env.FS.WriteFile("schema.sql", []byte("CREATE TABLE teams (id int);\n"), 0644))
+	require.Nil(t, env.Run("check"))
+	require.Equal(t, "CREATE TABLE users (id int);\n", readGolden(t, env, "schema.sql"))
+}
+
+func TestMissingGoldenFile(t *testing.T) {
+	t.Parallel()
+
+	env := newGoldenEnv("CREATE TABLE users (id int);\n", "openapi: 3.0.0\n")
+	require.EqualError(t, env.Run("check"), "golden file schema.sql doesn't exist, run with --update-golden to create it")
+
+	env.Options.UpdateGolden = true
+	require.Nil(t, env.Run("check"))
+	require.Equal(t, "CREATE TABLE users (id int);\n", readGolden(t, env, "schema.sql"))
+	require.Equal(t, "openapi: 3.0.0\n", readGolden(t, env, "openapi.yml"))
+}
+
+func TestGoldenSettingsOnlyApplyToGoldenEntries(t *testing.T) {
+	t.Parallel()
+
+	var entry RunEntry
+	require.ErrorContains(t, decodeConfig("", "cmd: dump\nupdate_flag: --regen\n", &entry), `"update_flag" and "normalize" only apply to "golden" entries`)
+	require.ErrorContains(t, decodeConfig("", "cmd: dump\ngolden: out.txt\nnormalize: [case]\n", &entry), "unknown normalization 'case', expected line_endings or trailing_whitespace")
+	require.ErrorContains(t, decodeConfig("", "goke: api\ngolden: out.txt\n", &entry), `"golden" only applies to "cmd" entries`)
+
+	_, err := NewInMemoryEnv("dump:\n  run: [dump]\ncheck:\n  run:\n    - cmd: dump\n      golden: out.txt\n").Parse()
+	require.EqualError(t, err, "task 'check': golden doesn't apply to the referenced task 'dump'")
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+func init() {
+	RegisterCapability("watch.hangup")
+}
+
+// What a watch session does when its terminal closes, see --hup.
+const (
+	// Stops the session the same way as Ctrl-C does.
+	HupStop = "stop"
+
+	// Keeps the session running, with its output going to a log file.
+	HupIgnore = "ignore"
+)
+
+// Stubbed in tests, so that detaching doesn't write to the state directory.
+var detachedLogDir = StateDir
+
+func validateHup(mode string) error {
+	if mode != "" && mode != HupStop && mode != HupIgnore {
+		return fmt.Errorf("--hup must be either %s or %s", HupStop, HupIgnore)
+	}
+
+	return nil
+}
+
+// Keeps the watch session running once its terminal closed. The spinner
+// stops, and the output of goke and of the commands goes to a log file
+// in the state directory instead of the terminal, which is gone.
+func (e *Executor) detach() {
+	dir, err := detachedLogDir()
+	if err != nil {
+		return
+	}
+
+	logFile := filepath.Join(dir, fmt.Sprintf("watch-%s.log", time.Now().Format("20060102-150405")))
+	f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return
+	}
+
+	if !e.options.Quiet {
+		_ = e.spinner.Stop()
+	}
+
+	os.Stdout, os.Stderr = f, f
+	fmt.Fprintf(f, "Terminal closed, watching %s detached (--hup=%s)\n", time.Now().Format(time.RFC3339), HupIgnore)
+}
@@ -0,0 +1,107 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWatchLoopStopsOnHangup(t *testing.T) {
+	hangup := make(chan os.Signal, 1)
+	started := make(chan struct{})
+	stopChildren := make(chan struct{})
+	var flushed atomic.Bool
+
+	loop := watchLoop{
+		interrupt: make(chan os.Signal),
+		hangup:    hangup,
+		status:    newWatchStatus("dev"),
+		run: func(initial bool) (bool, error) {
+			close(started)
+			<-stopChildren
+			flushed.Store(true)
+			return true, nil
+		},
+		stop: func() { close(stopChildren) },
+	}
+
+	done := make(chan os.Signal)
+	go func() { done <- loop.loop() }()
+
+	<-started
+	hangup <- syscall.SIGHUP
+
+	// The run in progress is stopped and finishes before the session ends,
+	// the same way as on an interrupt.
+	require.Equal(t, syscall.SIGHUP, <-done)
+	require.True(t, flushed.Load())
+}
+
+func TestWatchLoopDetachesOnHangup(t *testing.T) {
+	hangup := make(chan os.Signal, 1)
+	interrupt := make(chan os.Signal)
+	changes := make(chan struct{})
+	runner := &fakeRunner{}
+	var detached atomic.Int32
+
+	loop := watchLoop{
+		changes:   changes,
+		interrupt: interrupt,
+		hangup:    hangup,
+		detach:    func() { detached.Add(1) },
+		status:    newWatchStatus("dev"),
+		run:       runner.run,
+		stop:      func() {},
+	}
+
+	done := make(chan os.Signal)
+	go func() { done <- loop.loop() }()
+
+	hangup <- syscall.SIGHUP
+	require.Eventually(t, func() bool { return detached.Load() == 1 }, time.Second, time.Millisecond)
+
+	// The session keeps running after detaching.
+	changes <- struct{}{}
+	require.Eventually(t, func() bool { return runner.count() == 2 }, time.Second, time.Millisecond)
+
+	interrupt <- os.Interrupt
+	require.Equal(t, os.Interrupt, <-done)
+}
+
+func TestDetachRedirectsOutputToLogFile(t *testing.T) {
+	dir := t.TempDir()
+	origDir, origStdout, origStderr := detachedLogDir, os.Stdout, os.Stderr
+	detachedLogDir = func() (string, error) { return dir, nil }
+	t.Cleanup(func() {
+		if os.Stdout != origStdout {
+			os.Stdout.Close()
+		}
+		detachedLogDir, os.Stdout, os.Stderr = origDir, origStdout, origStderr
+	})
+
+	e := Executor{options: Options{Quiet: true}}
+	e.detach()
+	fmt.Fprintln(os.Stderr, "still watching")
+
+	logFiles, _ := filepath.Glob(filepath.Join(dir, "watch-*.log"))
+	require.Len(t, logFiles, 1)
+
+	contents, err := os.ReadFile(logFiles[0])
+	require.Nil(t, err)
+	require.Contains(t, string(contents), "Terminal closed")
+	require.Contains(t, string(contents), "(--hup=ignore)")
+	require.Contains(t, string(contents), "still watching")
+}
+
+func TestValidateHup(t *testing.T) {
+	require.Nil(t, validateHup(""))
+	require.Nil(t, validateHup(HupStop))
+	require.Nil(t, validateHup(HupIgnore))
+	require.EqualError(t, validateHup("detach"), "--hup must be either stop or ignore")
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+func init() {
+	RegisterCapability("history.last_success")
+}
+
+// Upper bound of files inspected per task when computing staleness,
+// so that it stays fast for tasks matching a huge amount of files.
+const stalenessScanLimit = 500
+
+type (
+	taskHistoryJson map[string]historyEntry
+	historyFileJson map[string]taskHistoryJson
+
+	// The last successful run of a task: when it finished, and the metadata
+	// of the goke invocation which ran it, nil when it's unknown.
+	historyEntry struct {
+		At       int64        `json:"at"`
+		Metadata *RunMetadata `json:"metadata,omitempty"`
+	}
+)
+
+// Reads the entries written before the metadata was recorded as well, which
+// are only the time of the run.
+func (h *historyEntry) UnmarshalJSON(data []byte) error {
+	var at int64
+	if err := json.Unmarshal(data, &at); err == nil {
+		*h = historyEntry{At: at}
+		return nil
+	}
+
+	type entry historyEntry
+	return json.Unmarshal(data, (*entry)(h))
+}
+
+// History keeps track of when each task of a project last ran successfully.
+type History struct {
+	JSON    historyFileJson
+	options Options
+	fs      FileSystem
+	store   StateStore
+
+	// Directory the runs are recorded under, see Lockfile.project.
+	project string
+}
+
+// Staleness describes how outdated a task is relative to its files.
+type Staleness struct {
+	LastSuccess time.Time
+	Changed     int
+	Truncated   bool
+}
+
+func NewHistory(opts *Options, fs FileSystem) History {
+	return History{
+		JSON:    make(historyFileJson),
+		options: *opts,
+		fs:      fs,
+		store:   newStateStore(*opts, fs),
+	}
+}
+
+// Loads the existing history information, if any.
+func (h *History) Bootstrap() error {
+	history, err := h.store.LoadHistory()
+	if err != nil {
+		return err
+	}
+
+	h.JSON = history
+	return nil
+}
+
+// Returns the time of the last successful run of the task in the current project.
+func (h *History) LastSuccess(taskName string) (time.Time, bool) {
+	entry, ok := h.lastEntry(taskName)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	return time.Unix(entry.At, 0), true
+}
+
+// Returns the metadata of the goke invocation which last ran the task of
+// the current project successfully, nil when it's unknown.
+func (h *History) LastRun(taskName string) *RunMetadata {
+	entry, _ := h.lastEntry(taskName)
+	return entry.Metadata
+}
+
+func (h *History) lastEntry(taskName string) (historyEntry, bool) {
+	cwd, _ := h.projectDir()
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	entry, ok := h.JSON[cwd][taskName]
+	return entry, ok
+}
+
+// Returns the history of another project, ie. a subproject.
+func (h *History) ForProject(dir string) History {
+	sub := *h
+	sub.project = dir
+
+	return sub
+}
+
+func (h *History) projectDir() (string, error) {
+	if h.project != "" {
+		return h.project, nil
+	}
+
+	return h.fs.Getwd()
+}
+
+// Stores the time of a successful run of the task in the current project,
+// along with the metadata of the run unless it's unknown.
+func (h *History) RecordSuccess(taskName string, at time.Time, meta RunMetadata) error {
+	cwd, err := h.projectDir()
+	if err != nil {
+		return err
+	}
+
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	if h.JSON[cwd] == nil {
+		h.JSON[cwd] = make(taskHistoryJson)
+	}
+
+	entry := historyEntry{At: at.Unix()}
+	if !meta.Started.IsZero() {
+		entry.Metadata = &meta
+	}
+
+	h.JSON[cwd][taskName] = entry
+
+	return h.store.Update(func(tx StateTx) error {
+		tx.SetLastSuccess(cwd, taskName, entry)
+		return nil
+	})
+}
+
+// Computes the staleness of the given task without mutating any state.
+// Only the first files of the task are inspected, see stalenessScanLimit.
+func (h *History) Staleness(task Task) (Staleness, error) {
+	lastSuccess, ok := h.LastSuccess(task.Name)
+	if !ok {
+		return Staleness{}, nil
+	}
+
+	s := Staleness{LastSuccess: lastSuccess}
+	files := task.Files
+
+	if len(files) > stalenessScanLimit {
+		files = files[:stalenessScanLimit]
+		s.Truncated = true
+	}
+
+	for _, f := range files {
+		changed, err := modifiedSince(h.fs, f, lastSuccess.Unix())
+		if err != nil {
+			return s, err
+		}
+
+		if changed {
+			s.Changed++
+		}
+	}
+
+	return s, nil
+}
+
+// Renders the staleness in a human readable way, relative to now.
+func (s Staleness) Format(now time.Time) string {
+	if s.LastSuccess.IsZero() {
+		return "never"
+	}
+
+	changed := fmt.Sprintf("%d", s.Changed)
+	if s.Truncated {
+		changed += "+"
+	}
+
+	noun := "files"
+	if s.Changed == 1 && !s.Truncated {
+		noun = "file"
+	}
+
+	return fmt.Sprintf("last success %s, %s source %s changed since", humanizeSince(s.LastSuccess, now), changed, noun)
+}
+
+// Formats the elapsed time between t and now, ie. "3 days ago".
+func humanizeSince(t time.Time, now time.Time) string {
+	d := now.Sub(t)
+
+	unit := func(n int, name string) string {
+		if n == 1 {
+			return fmt.Sprintf("1 %s ago", name)
+		}
+		return fmt.Sprintf("%d %ss ago", n, name)
+	}
+
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return unit(int(d/time.Minute), "minute")
+	case d < 24*time.Hour:
+		return unit(int(d/time.Hour), "hour")
+	default:
+		return unit(int(d/(24*time.Hour)), "day")
+	}
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/dugajean/goke/internal/tests"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var historyOpts = Options{}
+
+func TestNewHistory(t *testing.T) {
+	fsMock := tests.NewFileSystem(t)
+	history := NewHistory(&historyOpts, fsMock)
+
+	assert.NotNil(t, history.JSON)
+}
+
+func TestHistoryRecordSuccess(t *testing.T) {
+	fsMock := tests.NewFileSystem(t)
+	fsMock.On("Getwd").Return("path/to/cwd", nil)
+	fsMock.On("WriteFile", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	fsMock.On("Rename", mock.Anything, mock.Anything).Return(nil).Once()
+
+	history := NewHistory(&historyOpts, fsMock)
+	now := time.Unix(1671843661, 0)
+	err := history.RecordSuccess("greet-cats", now, RunMetadata{})
+
+	assert.Nil(t, err)
+
+	last, ok := history.LastSuccess("greet-cats")
+	assert.True(t, ok)
+	assert.Equal(t, now, last)
+	assert.Nil(t, history.LastRun("greet-cats"))
+}
+
+func TestHistoryRecordsTheMetadataOfTheRun(t *testing.T) {
+	fsMock := tests.NewFileSystem(t)
+	fsMock.On("Getwd").Return("path/to/cwd", nil)
+	fsMock.On("WriteFile", mock.Anything, mock.Anything, mock.Anything).Return(nil).Once()
+	fsMock.On("Rename", mock.Anything, mock.Anything).Return(nil).Once()
+
+	history := NewHistory(&historyOpts, fsMock)
+	meta := RunMetadata{Version: "1.2.3", OS: "linux", Arch: "amd64", Args: []string{"goke", "greet-cats"}, Started: time.Unix(1671843600, 0)}
+	assert.Nil(t, history.RecordSuccess("greet-cats", time.Unix(1671843661, 0), meta))
+
+	assert.Equal(t, &meta, history.LastRun("greet-cats"))
+	assert.Nil(t, history.LastRun("greet-dogs"))
+}
+
+func TestHistoryEntriesWithoutMetadataAreRead(t *testing.T) {
+	history := historyFileJson{}
+	contents := `{"/work": {"build": 1671843661, "lint": {"at": 1671843662, "metadata": {"version": "1.2.3"}}}}`
+
+	assert.Nil(t, json.Unmarshal([]byte(contents), &history))
+	assert.Equal(t, historyEntry{At: 1671843661}, history["/work"]["build"])
+	assert.Equal(t, int64(1671843662), history["/work"]["lint"].At)
+	assert.Equal(t, "1.2.3", history["/work"]["lint"].Metadata.Version)
+}
+
+func TestStalenessNeverRan(t *testing.T) {
+	fsMock := tests.NewFileSystem(t)
+	fsMock.On("Getwd").Return("path/to/cwd", nil)
+
+	history := NewHistory(&historyOpts, fsMock)
+	s, err := history.Staleness(Task{Name: "greet-cats", Files: []string{"foo"}})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "never", s.Format(time.Now()))
+}
+
+func TestStalenessCountsChangedFiles(t *testing.T) {
+	lastSuccess := time.Date(2022, time.December, 20, 1, 1, 1, 1, time.UTC)
+
+	fsMock := tests.NewFileSystem(t)
+	fsMock.On("Getwd").Return("path/to/cwd", nil)
+	fsMock.On("Stat", "old").Return(tests.MemFileInfo{Mtime: lastSuccess.Add(-time.Hour)}, nil)
+	fsMock.On("Stat", mock.Anything).Return(tests.MemFileInfo{}, nil)
+
+	history := NewHistory(&historyOpts, fsMock)
+	history.JSON["path/to/cwd"] = taskHistoryJson{"greet-cats": {At: lastSuccess.Unix()}}
+
+	s, err := history.Staleness(Task{Name: "greet-cats", Files: []string{"old", "new1", "new2"}})
+	now := lastSuccess.Add(3 * 24 * time.Hour)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, s.Changed)
+	assert.Equal(t, "last success 3 days ago, 2 source files changed since", s.Format(now))
+}
+
+func TestHumanizeSince(t *testing.T) {
+	now := time.Now()
+
+	assert.Equal(t, "just now", humanizeSince(now.Add(-time.Second), now))
+	assert.Equal(t, "1 minute ago", humanizeSince(now.Add(-time.Minute), now))
+	assert.Equal(t, "5 hours ago", humanizeSince(now.Add(-5*time.Hour), now))
+	assert.Equal(t, "2 days ago", humanizeSince(now.Add(-49*time.Hour), now))
+}
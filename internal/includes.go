@@ -0,0 +1,262 @@
+package internal
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterCapability("config.includes")
+}
+
+// The top-level key listing the files whose tasks are added to the config,
+// which is not a task.
+const includesKey = "includes"
+
+// How deeply included files may include other files.
+const maxIncludeDepth = 8
+
+// The top-level includes of a config, ie. [tasks/frontend.yml].
+type configIncludes struct {
+	Includes []includeEntry `yaml:"includes,omitempty"`
+}
+
+// An entry under "includes": the path of the file, or the path and the
+// namespace prefixing the names of its tasks, ie.
+// {path: services/api/goke.yml, namespace: api}.
+type includeEntry struct {
+	Path      string `yaml:"path"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+// A file included by the config, see readIncludes.
+type includedConfig struct {
+	// Relative to the working directory, like configFile.
+	path   string
+	config string
+
+	// The namespace of its tasks, including the ones of the files
+	// including it, ie. "api:db". Empty when they have none.
+	namespace string
+}
+
+func (entry *includeEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&entry.Path)
+	}
+
+	type include includeEntry
+	var decoded include
+
+	if err := node.Decode(&decoded); err != nil {
+		return err
+	}
+
+	if decoded.Path == "" {
+		return fmt.Errorf("line %d: includes must have a \"path\"", node.Line)
+	}
+
+	if strings.ContainsAny(decoded.Namespace, ": \t") {
+		return fmt.Errorf("line %d: namespace '%s' can't contain colons or spaces", node.Line, decoded.Namespace)
+	}
+
+	*entry = includeEntry(decoded)
+	return nil
+}
+
+// Reads the files included by the config, and the ones they include in
+// turn, depth first in the order they are listed. Their paths are relative
+// to the directory of the file listing them, and a file included twice is
+// only read once per namespace. They are read before parsing, so that their
+// contents are part of the cacheKey, and their failures are reported when
+// parsing the tasks, like the ones of the generator.
+func (p *Parser) readIncludes() {
+	var c configIncludes
+	if err := decodeConfig(p.configFile(), p.config, &c); err != nil || len(c.Includes) == 0 {
+		p.includeErr = err
+		return
+	}
+
+	root := filepath.Clean(p.configFile())
+	seen := map[includedConfig]bool{{path: root}: true}
+	p.included, p.includeErr = p.readIncludesOf(root, "", c.Includes, []string{root}, seen)
+}
+
+func (p *Parser) readIncludesOf(file string, namespace string, includes []includeEntry, chain []string, seen map[includedConfig]bool) ([]includedConfig, error) {
+	included := []includedConfig{}
+
+	for _, include := range includes {
+		path := joinDir(filepath.Dir(file), include.Path)
+		ns := joinNamespace(namespace, include.Namespace)
+
+		for i, f := range chain {
+			if f == path {
+				return nil, fmt.Errorf("%s: include cycle detected: %s", file, strings.Join(append(chain[i:], path), " -> "))
+			}
+		}
+
+		if seen[includedConfig{path: path, namespace: ns}] {
+			continue
+		}
+		seen[includedConfig{path: path, namespace: ns}] = true
+
+		if len(chain) > maxIncludeDepth {
+			return nil, fmt.Errorf("%s: more than %d nested includes", file, maxIncludeDepth)
+		}
+
+		data, err := p.fs.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: can't include %s: %w", file, include.Path, err)
+		}
+
+		var c configIncludes
+		if err := decodeConfig(path, string(data), &c); err != nil {
+			return nil, err
+		}
+
+		included = append(included, includedConfig{path: path, config: string(data), namespace: ns})
+
+		nested, err := p.readIncludesOf(path, ns, c.Includes, append(append([]string{}, chain...), path), seen)
+		if err != nil {
+			return nil, err
+		}
+		included = append(included, nested...)
+	}
+
+	return included, nil
+}
+
+// Adds the tasks of the included files to the tasks of the config. Included
+// files only declare tasks, and a task declared twice is an error naming
+// both places. The tasks of namespaced files are prefixed with their
+// namespace, see qualifyReferences.
+func (p *Parser) mergeIncludedTasks(tasks taskList) error {
+	if p.includeErr != nil {
+		return p.includeErr
+	}
+
+	decoded := make([]taskList, len(p.included))
+	namespaced := map[string]bool{}
+
+	for i, inc := range p.included {
+		if err := decodeConfig(inc.path, inc.config, &decoded[i]); err != nil {
+			return err
+		}
+
+		if keyLine(inc.config, generateTasksKey) > 0 {
+			return fmt.Errorf("%s: included files can only declare tasks, not %s", inc.path, generateTasksKey)
+		}
+
+		if inc.namespace != "" {
+			for name := range decoded[i] {
+				namespaced[inc.qualify(name)] = true
+			}
+		}
+	}
+
+	for i, inc := range p.included {
+		for _, name := range declarationOrder(decoded[i], keyLines(inc.config)) {
+			if name == "global" || name == varsKey || name == hooksKey || name == placeholdersKey {
+				return fmt.Errorf("%s: included files can only declare tasks, not %s", inc.path, name)
+			}
+
+			qualified := inc.qualify(name)
+			if _, ok := tasks[qualified]; ok {
+				return fmt.Errorf("task '%s' defined in %s:%d is already defined in %s", qualified, inc.path, keyLine(inc.config, name), p.taskLocation(qualified))
+			}
+
+			task := decoded[i][name]
+			task.Include = inc.path
+			task.Namespace = inc.namespace
+			if inc.namespace != "" {
+				task.qualifyReferences(inc.namespace, namespaced)
+			}
+
+			tasks[qualified] = task
+		}
+	}
+
+	return nil
+}
+
+// The name of the task of the included file, prefixed with its namespace.
+func (inc includedConfig) qualify(name string) string {
+	return joinNamespace(inc.namespace, name)
+}
+
+// The name of the task in the included file, if it declares it.
+func (inc includedConfig) localName(name string) (string, bool) {
+	if inc.namespace == "" {
+		return name, true
+	}
+
+	if !strings.HasPrefix(name, inc.namespace+":") {
+		return "", false
+	}
+
+	return strings.TrimPrefix(name, inc.namespace+":"), true
+}
+
+// Rewrites the references of a namespaced task to the tasks of its
+// namespace, under extends, deps, run, on_success, on_failure and events, ie. "build"
+// into "api:build", so that they stay within the namespace. References to
+// the tasks of other namespaces, or of the config, are left as they are:
+// they must be written in full, ie. "web:build".
+func (t *Task) qualifyReferences(namespace string, namespaced map[string]bool) {
+	qualify := func(ref string) string {
+		if namespaced[joinNamespace(namespace, ref)] {
+			return joinNamespace(namespace, ref)
+		}
+
+		return ref
+	}
+
+	t.Extends = qualify(t.Extends)
+	for i := range t.Deps {
+		t.Deps[i] = qualify(t.Deps[i])
+	}
+
+	for _, entries := range [][]RunEntry{t.Run, t.RunWindows, t.RunDarwin, t.RunLinux} {
+		for i := range entries {
+			entries[i].Cmd = qualify(entries[i].Cmd)
+		}
+	}
+
+	for _, hooks := range [][]string{t.OnSuccess, t.OnFailure} {
+		for i := range hooks {
+			hooks[i] = qualify(hooks[i])
+		}
+	}
+
+	for _, entries := range t.Events.lists() {
+		for i := range *entries {
+			(*entries)[i].Cmd = qualify((*entries)[i].Cmd)
+		}
+	}
+}
+
+// Joins namespaces and task names, ie. "api" and "build" into "api:build".
+func joinNamespace(namespace string, name string) string {
+	if namespace == "" {
+		return name
+	}
+
+	if name == "" {
+		return namespace
+	}
+
+	return namespace + ":" + name
+}
+
+// The directory the relative paths of the task are resolved from: the one
+// of the file declaring it.
+func (p *Parser) taskConfigDir(task Task) string {
+	if task.Include != "" {
+		return filepath.Dir(task.Include)
+	}
+
+	return filepath.Dir(p.configFile())
+}
@@ -0,0 +1,230 @@
+package internal
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const includesConfig = `
+includes: [tasks/frontend.yml, tasks/infra.yml]
+
+ci:
+  run:
+    - web
+    - migrate
+`
+
+// Writes the included files of includesConfig to the env.
+func writeIncludes(t *testing.T, env *InMemoryEnv) {
+	files := map[string]string{
+		"/work/tasks/frontend.yml": `
+web:
+  dir: ../web
+  files: ["../web/src/*.ts"]
+  outputs: [../web/dist/app.js]
+  run:
+    - "npm run build"
+`,
+		"/work/tasks/infra.yml": `
+includes: [db/tasks.yml]
+
+migrate:
+  deps: [db]
+  run:
+    - "migrate up"
+`,
+		"/work/tasks/db/tasks.yml": `
+db:
+  files: [schema.sql]
+  run:
+    - "docker compose up -d db"
+`,
+		"/work/web/src/app.ts":      "export {}",
+		"/work/tasks/db/schema.sql": "create table users ();",
+	}
+
+	for path, contents := range files {
+		require.Nil(t, env.FS.WriteFile(path, []byte(contents), 0644))
+	}
+}
+
+func TestIncludedTasks(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(includesConfig)
+	writeIncludes(t, env)
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.NotContains(t, p.Tasks, includesKey)
+
+	web := p.Tasks["web"]
+	require.Equal(t, "web", web.Dir)
+	require.Equal(t, []string{"web/src/*.ts"}, web.FilePatterns)
+	require.Equal(t, []string{"web/src/app.ts"}, web.Files)
+	require.Equal(t, []string{"web/dist/app.js"}, web.Outputs)
+
+	// Tasks without a dir still run from the project, only their paths are
+	// relative to the file declaring them.
+	db := p.Tasks["db"]
+	require.Empty(t, db.Dir)
+	require.Equal(t, []string{"tasks/db/schema.sql"}, db.Files)
+	require.Equal(t, "tasks/db/tasks.yml", db.Include)
+
+	env.Options.Force = true
+	require.Nil(t, env.Run("ci"))
+	require.Equal(t, []string{"npm run build", "docker compose up -d db", "migrate up"}, recordedCommands(env))
+	require.Equal(t, "web", env.Runner.Commands()[0].Dir)
+}
+
+func TestIncludedTasksCantBeDeclaredTwice(t *testing.T) {
+	t.Parallel()
+
+	env := newGeneratorEnv(includesConfig + "\ndb:\n  run:\n    - \"postgres\"\n")
+	writeIncludes(t, env)
+
+	_, err := env.Parse()
+	require.EqualError(t, err, "task 'db' defined in tasks/db/tasks.yml:2 is already defined in goke.yml:9")
+}
+
+func TestIncludeCycles(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv("includes: [tasks/a.yml]\n")
+	require.Nil(t, env.FS.WriteFile("/work/tasks/a.yml", []byte("includes: [b.yml]\n"), 0644))
+	require.Nil(t, env.FS.WriteFile("/work/tasks/b.yml", []byte("includes: [a.yml]\n"), 0644))
+
+	_, err := env.Parse()
+	require.EqualError(t, err, "tasks/b.yml: include cycle detected: tasks/a.yml -> tasks/b.yml -> tasks/a.yml")
+}
+
+func TestIncludedFilesOnlyDeclareTasks(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv("includes: [tasks/env.yml]\n")
+	require.Nil(t, env.FS.WriteFile("/work/tasks/env.yml", []byte("global:\n  environment:\n    FOO: bar\n"), 0644))
+
+	_, err := env.Parse()
+	require.EqualError(t, err, "tasks/env.yml: included files can only declare tasks, not global")
+
+	_, err = newGeneratorEnv("includes: [tasks/missing.yml]\n").Parse()
+	require.ErrorContains(t, err, "goke.yml: can't include tasks/missing.yml")
+}
+
+func TestIncludedFilesArePartOfTheCacheKey(t *testing.T) {
+	env := NewInMemoryEnv(includesConfig)
+	writeIncludes(t, env)
+
+	p := NewParser(includesConfig, &env.Options, env.FS)
+	require.Nil(t, p.Bootstrap())
+	require.True(t, NewParser(includesConfig, &env.Options, env.FS).cached)
+
+	require.Nil(t, env.FS.WriteFile("/work/tasks/db/tasks.yml", []byte("db:\n  run:\n    - \"postgres\"\n"), 0644))
+	require.False(t, NewParser(includesConfig, &env.Options, env.FS).cached)
+}
+
+const namespacedIncludesConfig = `
+includes:
+  - {path: services/api/goke.yml, namespace: api}
+  - path: services/web/goke.yml
+    namespace: web
+
+build:
+  desc: Builds everything
+  run:
+    - api:build
+    - web:build
+`
+
+// Writes the included files of namespacedIncludesConfig to the env.
+func writeNamespacedIncludes(t *testing.T, env *InMemoryEnv) {
+	files := map[string]string{
+		"/work/services/api/goke.yml": `
+build:
+  desc: Builds the API
+  deps: [gen]
+  run:
+    - "go build ./..."
+
+gen:
+  run:
+    - "go generate ./..."
+
+release:
+  run:
+    - build
+    - web:build
+`,
+		"/work/services/web/goke.yml": `
+build:
+  run:
+    - "npm run build"
+`,
+	}
+
+	for path, contents := range files {
+		require.Nil(t, env.FS.WriteFile(path, []byte(contents), 0644))
+	}
+}
+
+func TestNamespacedIncludes(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(namespacedIncludesConfig)
+	writeNamespacedIncludes(t, env)
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"build", "api:build", "api:gen", "api:release", "web:build"}, sortedKeys(p.Tasks))
+
+	// References stay within the namespace, unless they name another one.
+	require.Equal(t, []string{"api:gen"}, p.Tasks["api:build"].Deps)
+	require.Equal(t, "api:build", p.Tasks["api:release"].Run[0].Cmd)
+	require.Equal(t, "web:build", p.Tasks["api:release"].Run[1].Cmd)
+
+	require.Nil(t, env.Run("api:release"))
+	require.Equal(t, []string{"go generate ./...", "go build ./...", "npm run build"}, recordedCommands(env))
+}
+
+func TestListTasksGroupsNamespaces(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(namespacedIncludesConfig)
+	writeNamespacedIncludes(t, env)
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	out := bytes.Buffer{}
+	e.listTasks(&out)
+
+	require.Equal(t, `build  Builds everything
+
+api:
+  api:build    Builds the API
+  api:gen
+  api:release
+
+web:
+  web:build
+`, out.String())
+}
+
+func TestNamespacedIncludeErrors(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewInMemoryEnv("includes:\n  - {namespace: api}\n").Parse()
+	require.EqualError(t, err, `line 2: includes must have a "path"`)
+
+	_, err = NewInMemoryEnv("includes:\n  - {path: api.yml, namespace: 'api:v2'}\n").Parse()
+	require.EqualError(t, err, "line 2: namespace 'api:v2' can't contain colons or spaces")
+
+	env := newGeneratorEnv(namespacedIncludesConfig + "\napi:gen:\n  run:\n    - \"buf generate\"\n")
+	writeNamespacedIncludes(t, env)
+
+	_, err = env.Parse()
+	require.EqualError(t, err, "task 'api:gen' defined in services/api/goke.yml:8 is already defined in goke.yml:13")
+}
@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func init() {
+	RegisterCapability("run.signals")
+}
+
+// Returned to commands started after the run was interrupted.
+var errInterrupted = errors.New("interrupted")
+
+// InterruptedError is returned when Ctrl-C or SIGTERM stopped the run. Goke
+// then exits with 128 plus the signal number, like shells do, ie. 130.
+type InterruptedError struct {
+	Signal os.Signal
+	Err    error
+}
+
+func (e *InterruptedError) Error() string {
+	return "interrupted"
+}
+
+func (e *InterruptedError) Unwrap() error {
+	return e.Err
+}
+
+// The exit status goke ends with once interrupted by the signal.
+func (e *InterruptedError) ExitCode() int {
+	if sig, ok := e.Signal.(syscall.Signal); ok {
+		return 128 + int(sig)
+	}
+
+	return 128 + int(syscall.SIGINT)
+}
+
+// Forwards Ctrl-C and SIGTERM to the running commands, see fire. Commands
+// run in their own process group, so the signal reaches their children too,
+// while the terminal only sends Ctrl-C to goke itself.
+// Its methods are no-ops on a nil interrupt, ie. in tests.
+type runInterrupt struct {
+	mu     sync.Mutex
+	signal os.Signal
+	count  int
+	groups map[*processes]bool
+}
+
+func newRunInterrupt() *runInterrupt {
+	return &runInterrupt{groups: make(map[*processes]bool)}
+}
+
+// Forwards the signal to the running commands until the returned function
+// is called.
+func (i *runInterrupt) notify() func() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+
+	stopped := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				i.fire(sig)
+			case <-stopped:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(signals)
+		close(stopped)
+	}
+}
+
+// Sends the signal to the running commands, and kills the ones which are
+// still running after the grace period. A second signal kills them right
+// away. No new command starts afterwards, see check.
+func (i *runInterrupt) fire(sig os.Signal) {
+	if i == nil {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.signal = sig
+	i.count++
+
+	for group := range i.groups {
+		go group.interrupt(sig, i.grace())
+	}
+}
+
+// Commands get the usual grace period to exit, unless goke was already
+// interrupted before.
+func (i *runInterrupt) grace() time.Duration {
+	if i.count > 1 {
+		return 0
+	}
+
+	return restartGracePeriod
+}
+
+// The signal which interrupted the run, nil when there was none.
+func (i *runInterrupt) received() os.Signal {
+	if i == nil {
+		return nil
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.signal
+}
+
+// Fails once the run was interrupted, so that no new command starts.
+func (i *runInterrupt) check() error {
+	if i.received() != nil {
+		return errInterrupted
+	}
+
+	return nil
+}
+
+// Forwards signals to the processes of the group until the returned
+// function is called.
+func (i *runInterrupt) track(group *processes) func() {
+	if i == nil {
+		return func() {}
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.signal != nil {
+		go group.interrupt(i.signal, i.grace())
+		return func() {}
+	}
+
+	i.groups[group] = true
+	return func() {
+		i.mu.Lock()
+		delete(i.groups, group)
+		i.mu.Unlock()
+	}
+}
+
+// Forgets the signal, so that the next run of a watch session starts anew.
+func (i *runInterrupt) reset() {
+	if i == nil {
+		return
+	}
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.signal = nil
+	i.count = 0
+}
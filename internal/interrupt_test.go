@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"os"
+	"runtime"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInterruptStopsRunningCommands(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on POSIX signals")
+	}
+
+	// The shell waits for sleep, which only stops when the whole process
+	// group gets the signal.
+	config := `
+build:
+  run:
+    - "sh -c 'sleep 30; echo done'"
+    - "echo never"
+`
+	e := newTestExecutor(t, config)
+	e.interrupt = newRunInterrupt()
+
+	go func() {
+		require.Eventually(t, func() bool {
+			e.interrupt.mu.Lock()
+			defer e.interrupt.mu.Unlock()
+			return len(e.interrupt.groups) == 1
+		}, 5*time.Second, 10*time.Millisecond)
+
+		e.interrupt.fire(os.Interrupt)
+	}()
+
+	started := time.Now()
+	err := e.interrupted(e.dispatchTask(e.parser.Tasks["build"], true))
+
+	require.Less(t, time.Since(started), 3*time.Second)
+	require.EqualError(t, err, "interrupted")
+	require.Equal(t, 130, ExitCode(err))
+
+	// No command starts once interrupted.
+	require.Equal(t, errInterrupted, e.runFor(nil, 0))
+}
+
+func TestInterruptedExitCode(t *testing.T) {
+	require.Equal(t, 130, ExitCode(&InterruptedError{Signal: os.Interrupt}))
+	require.Equal(t, 143, ExitCode(&InterruptedError{Signal: syscall.SIGTERM, Err: errInterrupted}))
+	require.NoError(t, (&Executor{}).interrupted(nil))
+}
+
+func TestWatchLoopInterruptStopsRunThenSession(t *testing.T) {
+	interrupt := make(chan os.Signal)
+	release := make(chan struct{})
+	var interrupts, runs atomic.Int32
+
+	loop := watchLoop{
+		interrupt: interrupt,
+		status:    newWatchStatus("dev"),
+		run: func(initial bool) (bool, error) {
+			runs.Add(1)
+			<-release
+			return true, nil
+		},
+		stop:         func() { close(release) },
+		interruptRun: func(sig os.Signal) { interrupts.Add(1) },
+	}
+
+	done := make(chan os.Signal)
+	go func() { done <- loop.loop() }()
+
+	require.Eventually(t, func() bool { return runs.Load() == 1 }, time.Second, time.Millisecond)
+
+	// The first Ctrl-C only stops the run in progress.
+	interrupt <- os.Interrupt
+	require.Eventually(t, func() bool { return interrupts.Load() == 1 }, time.Second, time.Millisecond)
+
+	// The second one, while it's still stopping, ends the session.
+	interrupt <- os.Interrupt
+	require.Equal(t, os.Interrupt, <-done)
+	require.Equal(t, int32(2), interrupts.Load())
+}
+
+func TestWatchLoopInterruptWhenIdle(t *testing.T) {
+	interrupt := make(chan os.Signal)
+	var interrupts atomic.Int32
+
+	loop := watchLoop{
+		interrupt: interrupt,
+		status:    newWatchStatus("dev"),
+		run: func(initial bool) (bool, error) {
+			return true, nil
+		},
+		stop:         func() {},
+		interruptRun: func(sig os.Signal) { interrupts.Add(1) },
+	}
+
+	done := make(chan os.Signal)
+	go func() { done <- loop.loop() }()
+
+	require.Eventually(t, func() bool { return loop.status.snapshot().State == stateWaiting }, time.Second, time.Millisecond)
+
+	interrupt <- os.Interrupt
+	require.Equal(t, os.Interrupt, <-done)
+	require.Zero(t, interrupts.Load())
+}
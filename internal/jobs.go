@@ -0,0 +1,298 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterCapability("run.jobs", "task.group")
+}
+
+// One of the tasks given for an invocation with --jobs.
+type job struct {
+	name string
+
+	// The same task was given before, so it runs regardless of its files,
+	// see runInvocation.
+	repeated bool
+
+	// The jobs which have to finish before this one starts.
+	after []jobDependency
+	done  chan struct{}
+
+	// Set once the job finished.
+	started    bool
+	dispatched bool
+	err        error
+}
+
+// A job waits for another one of the same group, or for the same task
+// given before, in which case group is empty.
+type jobDependency struct {
+	job   *job
+	group string
+}
+
+// Runs the given tasks concurrently, at most --jobs at a time, each like it
+// would run on its own, with the lines of its output prefixed with its name.
+// Tasks whose groups overlap, including the groups of the tasks they depend
+// on or reference, never run at the same time: they run one after the
+// other, in the order they are declared in. Once a task failed, the ones
+// which didn't start yet never do, while the running ones finish.
+func (e *Executor) executeJobs(taskNames []string) (bool, error) {
+	jobs := e.planJobs(taskNames)
+	if e.options.DryRun {
+		return e.dryRunJobs(jobs)
+	}
+
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+		slots  = make(chan struct{}, e.options.Jobs)
+	)
+
+	for _, j := range jobs {
+		wg.Add(1)
+		go func(j *job) {
+			defer e.RecoverPanic()
+			defer wg.Done()
+			defer close(j.done)
+
+			for _, dep := range j.after {
+				<-dep.job.done
+			}
+
+			slots <- struct{}{}
+			defer func() { <-slots }()
+
+			mu.Lock()
+			stop := failed || e.context().Err() != nil || e.deadline.hasExpired() || e.interrupt.received() != nil
+			c := e.jobExecutor(j.name)
+			mu.Unlock()
+
+			if stop {
+				return
+			}
+
+			j.started = true
+			j.dispatched, j.err = c.runJob(j)
+
+			mu.Lock()
+			defer mu.Unlock()
+
+			if j.err != nil {
+				failed = true
+				return
+			}
+
+			for name := range c.completed {
+				e.markCompleted(name)
+			}
+			e.markCompleted(j.name)
+		}(j)
+	}
+
+	wg.Wait()
+	return e.jobsResult(jobs)
+}
+
+// Orders the given tasks into jobs. Each one waits for the previous one, in
+// the order of declaration, with which it shares a group.
+func (e *Executor) planJobs(taskNames []string) []*job {
+	jobs := make([]*job, len(taskNames))
+	given := make(map[string]*job)
+
+	for i, name := range taskNames {
+		jobs[i] = &job{name: name, done: make(chan struct{})}
+
+		if prev, ok := given[name]; ok {
+			jobs[i].repeated = true
+			jobs[i].after = append(jobs[i].after, jobDependency{job: prev})
+		}
+		given[name] = jobs[i]
+	}
+
+	declared := append([]*job{}, jobs...)
+	sort.SliceStable(declared, func(i, j int) bool {
+		return e.parser.declaredBefore(declared[i].name, declared[j].name)
+	})
+
+	last := make(map[string]*job)
+	for _, j := range declared {
+		for _, group := range e.parser.taskGroups(j.name) {
+			if prev, ok := last[group]; ok {
+				j.after = append(j.after, jobDependency{job: prev, group: group})
+			}
+			last[group] = j
+		}
+	}
+
+	return jobs
+}
+
+// A copy of the executor running a single job, which shares the lockfile,
+// the history and the spinner with the others.
+func (e *Executor) jobExecutor(taskName string) *Executor {
+	c := *e
+	c.resolved = nil
+	c.completed = nil
+	c.outputPrefix = e.outputPrefix + fmt.Sprintf("[%s] ", taskName)
+
+	for name := range e.completed {
+		c.markCompleted(name)
+	}
+
+	return &c
+}
+
+func (e *Executor) runJob(j *job) (bool, error) {
+	task, err := e.initTask(j.name)
+	if err != nil {
+		return false, err
+	}
+
+	return e.runInvocation(task, map[string]bool{j.name: j.repeated})
+}
+
+// Reports the failure of the first given task which failed, like execute
+// does, or a DeadlineExceededError listing the tasks which were cut off.
+func (e *Executor) jobsResult(jobs []*job) (bool, error) {
+	var (
+		completed, cutOff, notStarted []string
+		failure                       *job
+		didDispatch                   bool
+	)
+
+	for _, j := range jobs {
+		switch {
+		case !j.started:
+			notStarted = append(notStarted, j.name)
+		case j.err != nil:
+			cutOff = append(cutOff, j.name)
+			if failure == nil {
+				failure = j
+			}
+		default:
+			completed = append(completed, j.name)
+			didDispatch = didDispatch || j.dispatched
+		}
+	}
+
+	if failure == nil {
+		return didDispatch, nil
+	}
+
+	e.failed = append(append([]string{}, cutOff...), notStarted...)
+
+	names := append(append(completed, strings.Join(cutOff, ", ")), notStarted...)
+	err := e.deadlineExceeded(failure.err, names, len(completed))
+
+	var exceeded *DeadlineExceededError
+	if errors.As(err, &exceeded) {
+		return false, err
+	}
+
+	return false, fmt.Errorf("task '%s' failed: %w", failure.name, failure.err)
+}
+
+// Prints the commands of the jobs one after the other, in an order they
+// could run in, noting which ones wait for another task of their group.
+func (e *Executor) dryRunJobs(jobs []*job) (bool, error) {
+	didDispatch := false
+
+	for _, j := range scheduleJobs(jobs) {
+		for _, dep := range j.after {
+			if dep.group != "" {
+				e.printDryRun("%s: serialized by group '%s', after %s", j.name, dep.group, dep.job.name)
+			}
+		}
+
+		dispatched, err := e.runJob(j)
+		if err != nil {
+			return false, fmt.Errorf("task '%s' failed: %w", j.name, err)
+		}
+
+		didDispatch = didDispatch || dispatched
+	}
+
+	return didDispatch, nil
+}
+
+// Orders the jobs so that each one comes after the ones it waits for, and
+// otherwise in the order they were given.
+func scheduleJobs(jobs []*job) []*job {
+	scheduled := make(map[*job]bool)
+	order := make([]*job, 0, len(jobs))
+
+	for len(order) < len(jobs) {
+		for _, j := range jobs {
+			if scheduled[j] {
+				continue
+			}
+
+			ready := true
+			for _, dep := range j.after {
+				ready = ready && scheduled[dep.job]
+			}
+
+			if ready {
+				scheduled[j] = true
+				order = append(order, j)
+				break
+			}
+		}
+	}
+
+	return order
+}
+
+// Whether the first task is declared before the second one in the config.
+// Tasks only declared by local overrides come last.
+func (p *Parser) declaredBefore(a string, b string) bool {
+	return lineBefore(p.Tasks[a].Line, p.Tasks[b].Line)
+}
+
+// The groups of the task and of the tasks it depends on or references,
+// including in the events which apply to it, since they run as part of it.
+func (p *Parser) taskGroups(taskName string) []string {
+	groups := make(map[string]bool)
+	visited := make(map[string]bool)
+
+	var visit func(name string)
+	visit = func(name string) {
+		task, ok := p.Tasks[name]
+		if !ok || visited[name] {
+			return
+		}
+		visited[name] = true
+
+		if task.Group != "" {
+			groups[task.Group] = true
+		}
+
+		for _, dep := range task.Deps {
+			visit(dep)
+		}
+
+		for _, entry := range task.Run {
+			visit(entry.Cmd)
+		}
+
+		events := p.taskEvents(task)
+		for _, list := range [][]EventEntry{events.BeforeEachRun, events.AfterEachRun, events.BeforeEachTask, events.AfterEachTask} {
+			for _, ev := range list {
+				if ev.appliesTo(task) {
+					visit(ev.Cmd)
+				}
+			}
+		}
+	}
+
+	visit(taskName)
+	return sortedKeys(groups)
+}
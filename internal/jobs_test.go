@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const jobsConfig = `
+migrate:
+  group: db
+  run:
+    - "migrate up"
+
+seed:
+  group: db
+  run:
+    - "seed db"
+
+reset:
+  run:
+    - "drop db"
+    - "migrate"
+
+lint:
+  run:
+    - "lint ./..."
+
+test:
+  run:
+    - "test ./..."
+`
+
+// Counts the commands running at the same time, by the group of their task.
+type concurrencyTracker struct {
+	mu      sync.Mutex
+	running map[string]int
+	max     map[string]int
+}
+
+func (c *concurrencyTracker) run(group string, d time.Duration) {
+	c.mu.Lock()
+	c.running[group]++
+	if c.running[group] > c.max[group] {
+		c.max[group] = c.running[group]
+	}
+	c.mu.Unlock()
+
+	time.Sleep(d)
+
+	c.mu.Lock()
+	c.running[group]--
+	c.mu.Unlock()
+}
+
+func TestJobsSerializeGroups(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(jobsConfig)
+	env.Options.Jobs = 4
+
+	tracker := &concurrencyTracker{running: map[string]int{}, max: map[string]int{}}
+	lintStarted, testStarted := make(chan struct{}), make(chan struct{})
+
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		switch cmd.Args[0] {
+		case "lint":
+			// Only returns once test runs at the same time.
+			close(lintStarted)
+			select {
+			case <-testStarted:
+			case <-time.After(5 * time.Second):
+				return errors.New("test never overlapped with lint")
+			}
+		case "test":
+			close(testStarted)
+			<-lintStarted
+		default:
+			tracker.run("db", 20*time.Millisecond)
+		}
+
+		return nil
+	}
+
+	// reset references migrate, so it's part of the db group too.
+	require.NoError(t, env.Run("seed", "reset", "lint", "migrate", "test"))
+	require.Equal(t, 1, tracker.max["db"])
+
+	// The tasks of the group run in the order they're declared in.
+	var db []string
+	for _, c := range recordedCommands(env) {
+		if c != "lint ./..." && c != "test ./..." {
+			db = append(db, c)
+		}
+	}
+	require.Equal(t, []string{"migrate up", "seed db", "drop db", "migrate up"}, db)
+}
+
+func TestJobsStopAfterFailure(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(jobsConfig)
+	env.Options.Jobs = 2
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if cmd.Args[0] == "migrate" {
+			return errors.New("exit status 1")
+		}
+
+		return nil
+	}
+
+	p, err := env.Parse()
+	require.NoError(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+
+	_, err = e.executeTasks([]string{"migrate", "seed"})
+	require.EqualError(t, err, `task 'migrate' failed: "migrate up" failed: exit status 1`)
+	require.Equal(t, []string{"migrate up"}, recordedCommands(env))
+	require.Equal(t, []string{"migrate", "seed"}, e.failed)
+}
+
+func TestJobsDryRun(t *testing.T) {
+	out := stubDryRunOutput(t)
+
+	env := NewInMemoryEnv(jobsConfig)
+	env.Options.Jobs = 4
+	env.Options.DryRun = true
+
+	require.NoError(t, env.Run("reset", "lint", "seed"))
+	require.Equal(t, `lint:
+  lint ./...
+seed:
+  seed db
+reset: serialized by group 'db', after seed
+reset:
+  drop db
+  migrate:
+    migrate up
+`, out.String())
+}
+
+func TestTaskGroups(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+global:
+  events:
+    after_each_task:
+      - cmd: "notify"
+        only_tags: [ci]
+
+notify:
+  group: slack
+  run: ["notify-send done"]
+
+migrate:
+  group: db
+  run: ["migrate up"]
+
+deploy:
+  group: k8s
+  tags: [ci]
+  deps: [migrate]
+  run: ["kubectl apply"]
+`)
+	p, err := env.Parse()
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"db", "k8s", "slack"}, p.taskGroups("deploy"))
+	require.Equal(t, []string{"db"}, p.taskGroups("migrate"))
+	require.True(t, p.declaredBefore("migrate", "deploy"))
+}
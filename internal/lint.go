@@ -0,0 +1,203 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterCapability("config.lint")
+}
+
+// Matches the indicator of a block scalar, ie. "|", ">-" or "|2".
+var blockScalarRegexp = regexp.MustCompile(`^[|>][-+0-9]*\s*(#.*)?$`)
+
+// A common YAML mistake found in the config, explained in plain language.
+type lintError struct {
+	file   string
+	line   int
+	column int
+	source string
+	msg    string
+	hint   string
+}
+
+func (e *lintError) Error() string {
+	location := fmt.Sprintf("line %d, column %d", e.line, e.column)
+	if e.file != "" {
+		location = fmt.Sprintf("%s:%d:%d", e.file, e.line, e.column)
+	}
+
+	// The caret is preceded by the same whitespace as the line itself,
+	// so that it lines up even when the line is indented with tabs.
+	caret := strings.Map(func(r rune) rune {
+		if r == '\t' {
+			return r
+		}
+		return ' '
+	}, e.source[:e.column-1]) + "^"
+
+	return fmt.Sprintf("%s: %s\n\n    %s\n    %s\n\n%s", location, e.msg, e.source, caret, e.hint)
+}
+
+// A block of the config which is currently open, ie. the mapping of a task.
+type lintLevel struct {
+	indent int
+	line   int
+}
+
+// A line of the config with its indentation and YAML structure.
+type lintLine struct {
+	number  int
+	source  string
+	indent  int
+	item    bool
+	key     string
+	value   string
+	content int
+}
+
+// Whether the line starts a nested block, ie. "run:" without a value.
+func (l lintLine) opens() bool {
+	return l.value == "" && (l.item || l.key != "")
+}
+
+// Decodes the YAML config. When it is invalid because of a common indentation
+// mistake, the error of the YAML library is replaced with one of lintConfig.
+func decodeConfig(file string, config string, out any) error {
+	err := yaml.Unmarshal([]byte(config), out)
+	if err == nil {
+		return nil
+	}
+
+	if lintErr := lintConfig(file, config); lintErr != nil {
+		return lintErr
+	}
+
+	return err
+}
+
+// Looks for common indentation mistakes in the raw config: tabs used for
+// indentation, blocks with inconsistent indentation and list items indented
+// under a key which already has a value. Only the first mistake is returned.
+func lintConfig(file string, config string) error {
+	var (
+		stack   []lintLevel
+		prev    *lintLine
+		block   = -1
+		flow    = 0
+		lintErr = func(l lintLine, column int, msg string, hint string) error {
+			return &lintError{file: file, line: l.number, column: column, source: l.source, msg: msg, hint: hint}
+		}
+	)
+
+	for i, source := range strings.Split(config, "\n") {
+		source = strings.TrimRight(source, "\r")
+		trimmed := strings.TrimLeft(source, " \t")
+		indent := len(source) - len(trimmed)
+
+		// The contents of block scalars and multi-line flow collections are
+		// not indented as blocks.
+		if block != -1 && (trimmed == "" || indent > block) {
+			continue
+		}
+		block = -1
+
+		if flow > 0 {
+			flow += strings.Count(source, "[") + strings.Count(source, "{") - strings.Count(source, "]") - strings.Count(source, "}")
+			continue
+		}
+
+		if trimmed == "" || trimmed[0] == '#' || trimmed == "---" || trimmed == "..." {
+			continue
+		}
+
+		l := parseLintLine(i+1, source, indent)
+
+		if tab := strings.IndexByte(source[:indent], '\t'); tab != -1 {
+			return lintErr(l, tab+1, "tab character used for indentation, YAML only allows spaces", "Replace the tabs at the start of the line with spaces, ie. 2 spaces per level.")
+		}
+
+		if stack == nil {
+			stack = []lintLevel{{indent: indent, line: l.number}}
+		}
+
+		top := stack[len(stack)-1]
+
+		switch {
+		case indent > top.indent && prev != nil && prev.opens():
+			stack = append(stack, lintLevel{indent: indent, line: l.number})
+		case indent > top.indent && l.item && prev != nil && prev.key != "":
+			return lintErr(l, indent+1,
+				fmt.Sprintf("list item under '%s', which already has a value on line %d", prev.key, prev.number),
+				fmt.Sprintf("Either remove the value after '%s:' so that the list belongs to it, or remove the list item.", prev.key))
+		case indent > top.indent:
+			return lintErr(l, indent+1,
+				fmt.Sprintf("unexpected indentation, line %d doesn't start a nested block", prev.number),
+				fmt.Sprintf("Indent the line with %d spaces like the line above it.", top.indent))
+		case indent < top.indent:
+			for len(stack) > 1 && stack[len(stack)-1].indent > indent {
+				stack = stack[:len(stack)-1]
+			}
+
+			if outer := stack[len(stack)-1]; outer.indent != indent {
+				return lintErr(l, indent+1,
+					fmt.Sprintf("inconsistent indentation, %d spaces don't line up with the block starting on line %d", indent, top.line),
+					fmt.Sprintf("Indent the line with %d spaces to stay in the block, or %d spaces to leave it.", top.indent, outer.indent))
+			}
+		}
+
+		// The contents of a list item, ie. "- cmd: ...", form a nested block of their own.
+		if l.item && l.content > indent && (l.key != "" || l.value == "") {
+			stack = append(stack, lintLevel{indent: l.content, line: l.number})
+		}
+
+		if blockScalarRegexp.MatchString(l.value) {
+			block = indent
+		}
+
+		if strings.HasPrefix(l.value, "[") || strings.HasPrefix(l.value, "{") {
+			flow = strings.Count(l.value, "[") + strings.Count(l.value, "{") - strings.Count(l.value, "]") - strings.Count(l.value, "}")
+		}
+
+		prev = &l
+	}
+
+	return nil
+}
+
+// Splits a line into its list item marker, key and value.
+func parseLintLine(number int, source string, indent int) lintLine {
+	l := lintLine{number: number, source: source, indent: indent, content: indent}
+	rest := source[indent:]
+
+	if rest == "-" || strings.HasPrefix(rest, "- ") {
+		l.item = true
+		trimmed := strings.TrimLeft(rest[1:], " ")
+		l.content = indent + len(rest) - len(trimmed)
+		rest = trimmed
+	}
+
+	if i := strings.Index(rest, " #"); i != -1 {
+		rest = rest[:i]
+	}
+	rest = strings.TrimSpace(rest)
+
+	if rest == "" || rest[0] == '"' || rest[0] == '\'' || rest[0] == '[' || rest[0] == '{' {
+		l.value = rest
+		return l
+	}
+
+	if strings.HasSuffix(rest, ":") {
+		l.key = strings.TrimSuffix(rest, ":")
+	} else if key, value, ok := strings.Cut(rest, ": "); ok {
+		l.key, l.value = key, strings.TrimSpace(value)
+	} else {
+		l.value = rest
+	}
+
+	return l
+}
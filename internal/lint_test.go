@@ -0,0 +1,68 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeConfigExplainsIndentationMistakes(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		err    string
+	}{
+		{
+			name:   "tab indentation",
+			config: "build:\n\trun:\n\t\t- \"go build\"\n",
+			err:    "goke.yml:2:1: tab character used for indentation, YAML only allows spaces\n\n    \trun:\n    ^\n\nReplace the tabs at the start of the line with spaces, ie. 2 spaces per level.",
+		},
+		{
+			name:   "tab after spaces",
+			config: "build:\n  files: [a.go]\n  \trun: []\n",
+			err:    "goke.yml:3:3: tab character used for indentation, YAML only allows spaces\n\n      \trun: []\n      ^\n\nReplace the tabs at the start of the line with spaces, ie. 2 spaces per level.",
+		},
+		{
+			name:   "list under scalar key",
+			config: "build:\n  run: \"go build\"\n    - \"go vet\"\n",
+			err:    "goke.yml:3:5: list item under 'run', which already has a value on line 2\n\n        - \"go vet\"\n        ^\n\nEither remove the value after 'run:' so that the list belongs to it, or remove the list item.",
+		},
+		{
+			name:   "inconsistent indentation",
+			config: "build:\n    files: [a.go]\n  run:\n    - \"go build\"\n",
+			err:    "goke.yml:3:3: inconsistent indentation, 2 spaces don't line up with the block starting on line 2\n\n      run:\n      ^\n\nIndent the line with 4 spaces to stay in the block, or 0 spaces to leave it.",
+		},
+		{
+			name:   "unexpected indentation",
+			config: "build:\n  files: [a.go]\n    run:\n      - \"go build\"\n",
+			err:    "goke.yml:3:5: unexpected indentation, line 2 doesn't start a nested block\n\n        run:\n        ^\n\nIndent the line with 2 spaces like the line above it.",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tasks taskList
+			require.EqualError(t, decodeConfig("goke.yml", tt.config, &tasks), tt.err)
+		})
+	}
+}
+
+func TestLintConfigAcceptsValidConfigs(t *testing.T) {
+	configs := []string{
+		yamlConfigStub,
+		"global:\n  environment:\n    A: \"1\"\n\nbuild:\n  run:\n  - \"go build\"\n  - cmd: \"go vet\"\n    dir: web\n",
+		"build:\n  desc: |\n    Builds\n\t  with tabs\n  files: [\n    a.go,\n      b.go]\n  run:\n    - export:\n        A: \"1\"\n    -\n      cmd: \"true\" # comment\n",
+	}
+
+	for _, config := range configs {
+		require.Nil(t, lintConfig("goke.yml", config))
+	}
+}
+
+func TestParseTasksReportsLintErrors(t *testing.T) {
+	parser := NewParser("build:\n\trun: []\n", &clearCacheOpts, &LocalFileSystem{})
+
+	err := parser.parseTasks()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "line 2, column 1: tab character used for indentation")
+}
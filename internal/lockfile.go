@@ -1,117 +1,243 @@
 package internal
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
 	"os/user"
 	"path"
+	"runtime"
+	"strings"
+	"sync"
 )
 
+func init() {
+	RegisterCapability("lockfile.mtime", "lockfile.symlinks", "lockfile.checksum")
+}
+
 type (
-	singleProjectJson map[string]int64
+	// The recorded state of a single file. Symlinks also record their
+	// resolved target, so that retargeting a link counts as a change.
+	// Files of tasks with checksum: true also record the SHA-256 of
+	// their contents, which then decides whether they changed. Files
+	// which don't exist, ie. deleted since the config was cached, are
+	// recorded as missing.
+	fileEntry struct {
+		ModTime int64  `json:"mtime"`
+		Target  string `json:"target,omitempty"`
+		Hash    string `json:"sha256,omitempty"`
+		Missing bool   `json:"missing,omitempty"`
+	}
+
+	// The recorded files of a task, of the tasks of a project, and of all
+	// the projects. Each task records its own files, so that running one
+	// task never makes another one miss a change to a file they share.
+	taskFilesJson     map[string]fileEntry
+	singleProjectJson map[string]taskFilesJson
 	lockFileJson      map[string]singleProjectJson
+
+	// The lockfile as written to disk. Lockfiles of older versions, which
+	// recorded the files by project only, have no version.
+	lockfileContents struct {
+		Version  int          `json:"version"`
+		Projects lockFileJson `json:"projects"`
+	}
 )
 
+// Bumped whenever the schema of the lockfile changes, see decodeLockfile.
+const lockfileVersion = 2
+
+// Returned for the lockfiles of older versions, whose recorded files can't
+// tell which task saw them.
+var errOutdatedLockfile = errors.New("outdated lockfile")
+
+// Guards the lockfiles and histories, whose tasks may run concurrently with
+// --jobs, see Executor.executeJobs.
+var stateMu sync.Mutex
+
 type Lockfile struct {
-	files   []string
+	// The files of each task, which a new lockfile records as they are.
+	files map[string][]string
+
 	JSON    lockFileJson
 	options Options
 	fs      FileSystem
+	store   StateStore
+
+	// Directory the files are recorded under, the working directory
+	// unless it's the lockfile of a subproject, see ForProject.
+	project string
 }
 
-func NewLockfile(files []string, opts *Options, fs FileSystem) Lockfile {
+func NewLockfile(files map[string][]string, opts *Options, fs FileSystem) Lockfile {
 	return Lockfile{
 		files:   files,
 		options: *opts,
 		fs:      fs,
+		store:   newStateStore(*opts, fs),
 	}
 }
 
 // Loads existing lock information generates it for the first time.
-func (l *Lockfile) Bootstrap() {
-	lockfilePath, err := l.getLockfilePath()
-	if err != nil && !l.options.Quiet {
-		log.Fatal(err)
+func (l *Lockfile) Bootstrap() error {
+	if l.options.StateDir != "" {
+		lockfilePath, err := l.getLockfilePath()
+		if err != nil {
+			return err
+		}
+
+		_ = withStateLock(l.fs, l.options, func() error {
+			l.migrateLockfile(lockfilePath)
+			return nil
+		})
+	}
+
+	projects, found, err := l.store.LoadFiles()
+	if err != nil {
+		return err
+	}
+
+	if found {
+		l.JSON = projects
+		return nil
+	}
+
+	project, err := l.initialProject()
+	if err != nil {
+		return err
+	}
+
+	cwd, _ := l.projectDir()
+	l.JSON = lockFileJson{cwd: project}
+
+	return l.store.Update(func(tx StateTx) error {
+		for task, files := range project {
+			setTaskFiles(tx, cwd, task, files)
+		}
+
+		return nil
+	})
+}
+
+// Decodes the lockfile, which fails with errOutdatedLockfile when it was
+// written by an older version.
+func decodeLockfile(data []byte) (lockFileJson, error) {
+	var contents lockfileContents
+	if err := json.Unmarshal(data, &contents); err != nil {
+		return nil, err
 	}
 
-	if !l.fs.FileExists(lockfilePath) {
-		l.generateLockfile(true)
+	if contents.Version != lockfileVersion {
+		return nil, errOutdatedLockfile
 	}
 
-	currentLockFile, err := l.fs.ReadFile(lockfilePath)
-	if err != nil && !l.options.Quiet {
-		log.Fatal(err)
+	if contents.Projects == nil {
+		contents.Projects = lockFileJson{}
 	}
 
-	err = json.Unmarshal(currentLockFile, &l.JSON)
-	if err != nil && !l.options.Quiet {
-		log.Fatal(err)
+	return contents.Projects, nil
+}
+
+func encodeLockfile(projects lockFileJson) ([]byte, error) {
+	return json.MarshalIndent(lockfileContents{Version: lockfileVersion, Projects: projects}, "", "  ")
+}
+
+// Returns the recorded files of the task in the current project.
+func (l *Lockfile) GetTaskFiles(task string) taskFilesJson {
+	stateMu.Lock()
+	defer stateMu.Unlock()
+
+	cwd, _ := l.projectDir()
+	files := make(taskFilesJson, len(l.JSON[cwd][task]))
+	for f, entry := range l.JSON[cwd][task] {
+		files[f] = entry
 	}
+
+	return files
+}
+
+// Returns a lockfile recording the files of another project, ie. a subproject.
+// Both write to the same file.
+func (l *Lockfile) ForProject(dir string, files map[string][]string) Lockfile {
+	sub := *l
+	sub.project = dir
+	sub.files = files
+
+	return sub
 }
 
-// Returns the lock information for the current project.
-func (l *Lockfile) GetCurrentProject() singleProjectJson {
-	cwd, _ := l.fs.Getwd()
-	return l.JSON[cwd]
+func (l *Lockfile) projectDir() (string, error) {
+	if l.project != "" {
+		return l.project, nil
+	}
+
+	return l.fs.Getwd()
 }
 
-// Update timestamps for files in current project.
-func (l *Lockfile) UpdateTimestampsForFiles(files []string) error {
-	lockfileMap, err := l.prepareMap(files)
+// Update timestamps, and with checksum the hashes, for the files of the task
+// in the current project. The files recorded for other tasks stay as they are.
+func (l *Lockfile) UpdateTimestampsForFiles(task string, files []string, followSymlinks bool, checksum bool) error {
+	lockfileMap, err := l.prepareMap(files, followSymlinks, checksum)
 	if err != nil {
 		return err
 	}
 
-	cwd, err := l.fs.Getwd()
+	cwd, err := l.projectDir()
 	if err != nil {
 		return err
 	}
 
-	l.JSON[cwd] = lockfileMap
-	for f := range l.JSON[cwd] {
-		l.JSON[cwd][f] = lockfileMap[f]
-	}
+	stateMu.Lock()
+	defer stateMu.Unlock()
 
-	err = l.generateLockfile(false)
-	if err != nil {
-		return err
+	if l.JSON[cwd] == nil {
+		l.JSON[cwd] = make(singleProjectJson)
 	}
+	l.JSON[cwd][task] = lockfileMap
 
-	return nil
+	return l.store.Update(func(tx StateTx) error {
+		setTaskFiles(tx, cwd, task, lockfileMap)
+		return nil
+	})
 }
 
-// Generate the lockfile file, or update it with new contents.
-func (l *Lockfile) generateLockfile(initialLockfile bool) error {
-	contents := l.JSON
-	if initialLockfile {
-		lockfileMap, err := l.prepareMap(l.files)
-		if err != nil {
-			return err
+// Records the files of every task as they are, reading each file once.
+func (l *Lockfile) initialProject() (singleProjectJson, error) {
+	all := []string{}
+	seen := make(map[string]bool)
+	for _, task := range sortedKeys(l.files) {
+		for _, f := range l.files[task] {
+			if !seen[f] {
+				seen[f] = true
+				all = append(all, f)
+			}
 		}
-
-		cwd, _ := l.fs.Getwd()
-		contents = lockFileJson{cwd: lockfileMap}
 	}
 
-	jsonString, err := json.MarshalIndent(contents, "", "  ")
+	entries, err := l.prepareMap(all, true, false)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	writeCh := make(chan error)
-	go l.writeLockfileRoutine(jsonString, writeCh)
-
-	if err = <-writeCh; err != nil {
-		return err
+	project := make(singleProjectJson, len(l.files))
+	for task, files := range l.files {
+		project[task] = make(taskFilesJson, len(files))
+		for _, f := range files {
+			project[task][f] = entries[f]
+		}
 	}
 
-	return nil
+	return project, nil
 }
 
-// Prepares the map used to populate individual project files.
-func (l *Lockfile) prepareMap(files []string) (singleProjectJson, error) {
-	lockfileMapCh := make(chan Ref[singleProjectJson])
-	go l.getFileModifiedMapRoutine(files, lockfileMapCh)
+// Prepares the map used to populate the files of a task.
+func (l *Lockfile) prepareMap(files []string, followSymlinks bool, checksum bool) (taskFilesJson, error) {
+	lockfileMapCh := make(chan Ref[taskFilesJson])
+	go l.getFileModifiedMapRoutine(files, followSymlinks, checksum, lockfileMapCh)
 
 	lockfileRef := <-lockfileMapCh
 
@@ -123,40 +249,171 @@ func (l *Lockfile) prepareMap(files []string) (singleProjectJson, error) {
 }
 
 // Go routine used to dispatch file mtime checks in the background.
-func (l *Lockfile) getFileModifiedMapRoutine(files []string, ch chan Ref[singleProjectJson]) {
-	lockfileMap := make(singleProjectJson)
+func (l *Lockfile) getFileModifiedMapRoutine(files []string, followSymlinks bool, checksum bool, ch chan Ref[taskFilesJson]) {
+	defer RecoverPanic()
 
-	for _, f := range files {
-		fo, err := l.fs.Stat(f)
+	lockfileMap := make(taskFilesJson)
 
-		if err != nil {
-			ch <- NewRef[singleProjectJson](nil, err)
+	for i, entry := range readFileEntries(l.fs, files, followSymlinks, checksum) {
+		if entry.Error() != nil {
+			ch <- NewRef[taskFilesJson](nil, entry.Error())
+			return
 		}
 
-		lockfileMap[f] = fo.ModTime().Unix()
+		lockfileMap[files[i]] = entry.Value()
 	}
 
 	ch <- NewRef(lockfileMap, nil)
 }
 
-// Writes the lockfile into the filesystem.
-func (l *Lockfile) writeLockfileRoutine(contents []byte, ch chan error) {
-	gokePath, err := l.getLockfilePath()
+// Entries without a symlink target nor a hash are stored as a plain mtime,
+// which is also the format of lockfiles written by older versions.
+func (f fileEntry) MarshalJSON() ([]byte, error) {
+	if f.Target == "" && f.Hash == "" && !f.Missing {
+		return json.Marshal(f.ModTime)
+	}
+
+	type entry fileEntry
+	return json.Marshal(entry(f))
+}
+
+func (f *fileEntry) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, &f.ModTime); err == nil {
+		return nil
+	}
+
+	type entry fileEntry
+	return json.Unmarshal(data, (*entry)(f))
+}
+
+// Reports whether the file changed compared to its recorded state. When the
+// file was hashed, only its contents count, so touching it is not a change.
+// A recorded state without a hash, ie. of an older lockfile, always differs.
+// A file which went missing, or appeared, also counts as a change.
+func (f fileEntry) changedSince(recorded fileEntry) bool {
+	if f.Missing || recorded.Missing {
+		return f.Missing != recorded.Missing
+	}
+
+	if f.Target != recorded.Target {
+		return true
+	}
+
+	if f.Hash != "" {
+		return f.Hash != recorded.Hash
+	}
+
+	return f.ModTime > recorded.ModTime
+}
+
+// Reads the current state of all files, in the same order. Tasks can match
+// thousands of files, so they are read, and hashed with checksum, by a pool
+// of workers.
+func readFileEntries(fs FileSystem, files []string, followSymlinks bool, checksum bool) []Ref[fileEntry] {
+	entries := make([]Ref[fileEntry], len(files))
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer RecoverPanic()
+			defer wg.Done()
+
+			for i := range indexes {
+				entry, err := readFileEntry(fs, files[i], followSymlinks)
+				if err == nil && checksum && !entry.Missing {
+					entry.Hash, err = hashFile(fs, files[i])
+				}
+
+				entries[i] = NewRef(entry, err)
+			}
+		}()
+	}
+
+	for i := range files {
+		indexes <- i
+	}
+
+	close(indexes)
+	wg.Wait()
+
+	return entries
+}
+
+// Returns the hex encoded SHA-256 of the file's contents.
+func hashFile(fs FileSystem, file string) (string, error) {
+	contents, err := fs.ReadFile(file)
 	if err != nil {
-		ch <- err
-		return
+		return "", err
 	}
 
-	if err = l.fs.WriteFile(gokePath, contents, 0644); err != nil {
-		ch <- err
-		return
+	sum := sha256.Sum256(contents)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// Reads the current state of a file. Symlinks are resolved, so the mtime is
+// the one of the target, and the target itself is recorded. Without following
+// symlinks, links are treated as opaque files. Files which don't exist, as
+// well as links to them, are missing rather than an error.
+func readFileEntry(fs FileSystem, file string, followSymlinks bool) (fileEntry, error) {
+	lfo, err := fs.Lstat(file)
+	if isNotExist(err) {
+		return fileEntry{Missing: true}, nil
+	}
+	if err != nil {
+		return fileEntry{}, err
+	}
+
+	if !followSymlinks || lfo.Mode()&os.ModeSymlink == 0 {
+		return fileEntry{ModTime: lfo.ModTime().Unix()}, nil
+	}
+
+	target, err := fs.EvalSymlinks(file)
+	if isNotExist(err) {
+		return fileEntry{Missing: true}, nil
+	}
+	if err != nil {
+		if strings.Contains(err.Error(), "too many links") {
+			return fileEntry{}, fmt.Errorf("symlink cycle detected while resolving %s", file)
+		}
+		return fileEntry{}, fmt.Errorf("cannot resolve symlink %s: %w", file, err)
+	}
+
+	fo, err := fs.Stat(target)
+	if err != nil {
+		return fileEntry{}, err
+	}
+
+	return fileEntry{ModTime: fo.ModTime().Unix(), Target: target}, nil
+}
+
+// Reports whether the file was modified after the given unix timestamp.
+// A file which doesn't exist anymore counts as modified.
+func modifiedSince(fs FileSystem, file string, since int64) (bool, error) {
+	fo, err := fs.Stat(file)
+	if isNotExist(err) {
+		return true, nil
+	}
+	if err != nil {
+		return false, err
 	}
 
-	ch <- nil
+	return fo.ModTime().Unix() > since, nil
+}
+
+func isNotExist(err error) bool {
+	return errors.Is(err, fs.ErrNotExist)
 }
 
 // Returns the location of the lockfile in the system.
 func (l *Lockfile) getLockfilePath() (string, error) {
+	return lockfilePath(l.options)
+}
+
+// The lockfile in the home directory, shared by all the projects which don't
+// keep their state in a .goke directory.
+func globalLockfilePath() (string, error) {
 	user, err := user.Current()
 	if err != nil {
 		return "", err
@@ -164,3 +421,38 @@ func (l *Lockfile) getLockfilePath() (string, error) {
 
 	return path.Join(user.HomeDir, ".goke"), nil
 }
+
+// Moves the files recorded for the project in the home directory's lockfile
+// into the new one of its .goke directory, unless that one already exists,
+// so that switching to the .goke directory doesn't rerun every task.
+func (l *Lockfile) migrateLockfile(lockfilePath string) {
+	legacyPath, err := globalLockfilePath()
+	if err != nil || l.fs.FileExists(lockfilePath) || !l.fs.FileExists(legacyPath) {
+		return
+	}
+
+	contents, err := l.fs.ReadFile(legacyPath)
+	if err != nil {
+		return
+	}
+
+	cwd, err := l.projectDir()
+	if err != nil {
+		return
+	}
+
+	legacy, err := decodeLockfile(contents)
+	if err != nil || legacy[cwd] == nil {
+		return
+	}
+
+	migrated, err := encodeLockfile(lockFileJson{cwd: legacy[cwd]})
+	if err != nil || writeFileAtomic(l.fs, lockfilePath, migrated) != nil {
+		return
+	}
+
+	delete(legacy, cwd)
+	if rest, err := encodeLockfile(legacy); err == nil {
+		_ = writeFileAtomic(l.fs, legacyPath, rest)
+	}
+}
@@ -1,14 +1,19 @@
 package internal
 
 import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/dugajean/goke/internal/tests"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
 
-var files = []string{"./lockfile.go"}
+var files = map[string][]string{"lint": {"./lockfile.go"}}
 
 var lockfileOpts = Options{
 	ClearCache: true,
@@ -22,27 +27,254 @@ func TestNewLockfile(t *testing.T) {
 	assert.Equal(t, files, lockfile.files)
 }
 
-func TestGenerateLockfileWithTrue(t *testing.T) {
+func TestBootstrapWritesNewLockfile(t *testing.T) {
 	fsMock := tests.NewFileSystem(t)
 	fsMock.On("Getwd").Return("path/to/cwd", nil)
-	fsMock.On("Stat", mock.Anything).Return(tests.MemFileInfo{}, nil)
+	fsMock.On("FileExists", mock.Anything).Return(false)
+	fsMock.On("Lstat", mock.Anything).Return(tests.MemFileInfo{}, nil)
 	fsMock.On("WriteFile", mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	fsMock.On("Rename", mock.Anything, mock.Anything).Return(nil)
 
 	lockfile := NewLockfile(files, &lockfileOpts, fsMock)
-	err := lockfile.generateLockfile(true)
+	err := lockfile.Bootstrap()
 
 	assert.Nil(t, err)
+	assert.Contains(t, lockfile.JSON["path/to/cwd"], "lint")
 }
 
-func TestGenerateLockfileWithFalse(t *testing.T) {
+func TestUpdateTimestampsForFiles(t *testing.T) {
 	fsMock := tests.NewFileSystem(t)
 	fsMock.On("Getwd").Return("path/to/cwd", nil)
-	fsMock.On("Stat", mock.Anything).Return(tests.MemFileInfo{}, nil)
+	fsMock.On("Lstat", mock.Anything).Return(tests.MemFileInfo{}, nil)
 	fsMock.On("WriteFile", mock.Anything, mock.Anything, mock.Anything).Return(nil)
-	// fsMock.On("FileExists", mock.Anything).Return(false)
+	fsMock.On("Rename", mock.Anything, mock.Anything).Return(nil)
 
 	lockfile := NewLockfile(files, &lockfileOpts, fsMock)
-	err := lockfile.generateLockfile(true)
+	lockfile.JSON = lockFileJson{}
+	err := lockfile.UpdateTimestampsForFiles("lint", files["lint"], true, false)
 
 	assert.Nil(t, err)
+	assert.Contains(t, lockfile.GetTaskFiles("lint"), "./lockfile.go")
+}
+
+func TestFileEntryJSON(t *testing.T) {
+	var project taskFilesJson
+	err := json.Unmarshal([]byte(`{"old.go": 1671843661, "link.go": {"mtime": 1671843662, "target": "/src/real.go"}}`), &project)
+
+	assert.Nil(t, err)
+	assert.Equal(t, fileEntry{ModTime: 1671843661}, project["old.go"])
+	assert.Equal(t, fileEntry{ModTime: 1671843662, Target: "/src/real.go"}, project["link.go"])
+
+	encoded, err := json.Marshal(project)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"old.go": 1671843661, "link.go": {"mtime": 1671843662, "target": "/src/real.go"}}`, string(encoded))
+}
+
+func TestFileEntryJSONWithHash(t *testing.T) {
+	entry := fileEntry{ModTime: 1671843661, Hash: "ab12"}
+
+	encoded, err := json.Marshal(entry)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"mtime": 1671843661, "sha256": "ab12"}`, string(encoded))
+
+	var decoded fileEntry
+	assert.Nil(t, json.Unmarshal(encoded, &decoded))
+	assert.Equal(t, entry, decoded)
+}
+
+func TestReadFileEntriesWithChecksum(t *testing.T) {
+	dir := t.TempDir()
+	fs := &LocalFileSystem{}
+	files := []string{}
+
+	for i := 0; i < 20; i++ {
+		f := filepath.Join(dir, fmt.Sprintf("%d.go", i))
+		assert.Nil(t, os.WriteFile(f, []byte(fmt.Sprintf("package p%d", i)), 0644))
+		files = append(files, f)
+	}
+
+	before := readFileEntries(fs, files, true, true)
+	for i, entry := range before {
+		assert.Nil(t, entry.Error())
+		hash, _ := hashFile(fs, files[i])
+		assert.Equal(t, hash, entry.Value().Hash)
+	}
+
+	// Touching a file without changing its contents is not a change.
+	later := time.Now().Add(time.Hour)
+	assert.Nil(t, os.Chtimes(files[3], later, later))
+	assert.Nil(t, os.WriteFile(files[7], []byte("package changed"), 0644))
+
+	after := readFileEntries(fs, files, true, true)
+	for i, entry := range after {
+		assert.Equal(t, i == 7, entry.Value().changedSince(before[i].Value()), files[i])
+	}
+
+	// Entries recorded without a hash always count as changed.
+	assert.True(t, after[0].Value().changedSince(fileEntry{ModTime: after[0].Value().ModTime}))
+}
+
+func TestReadFileEntriesWithMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "a.go")
+	assert.Nil(t, os.WriteFile(existing, []byte("a"), 0644))
+
+	entries := readFileEntries(&LocalFileSystem{}, []string{existing, filepath.Join(dir, "missing.go")}, true, true)
+
+	assert.Nil(t, entries[0].Error())
+	assert.Nil(t, entries[1].Error())
+	assert.True(t, entries[1].Value().Missing)
+	assert.Empty(t, entries[1].Value().Hash)
+}
+
+func symlinkOrSkip(t *testing.T, target string, link string) {
+	if err := os.Symlink(target, link); err != nil {
+		t.Skipf("symlinks are not supported: %s", err)
+	}
+}
+
+func TestReadFileEntryDetectsRetargetedSymlink(t *testing.T) {
+	dir := t.TempDir()
+	fs := &LocalFileSystem{}
+	mtime := time.Date(2022, time.December, 24, 1, 1, 1, 0, time.UTC)
+
+	for _, name := range []string{"a.proto", "b.proto"} {
+		f := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(f, []byte(name), 0644))
+		assert.Nil(t, os.Chtimes(f, mtime, mtime))
+	}
+
+	link := filepath.Join(dir, "shared.proto")
+	symlinkOrSkip(t, filepath.Join(dir, "a.proto"), link)
+
+	before, err := readFileEntry(fs, link, true)
+	assert.Nil(t, err)
+	assert.Equal(t, mtime.Unix(), before.ModTime)
+	assert.Equal(t, "a.proto", filepath.Base(before.Target))
+
+	assert.Nil(t, os.Remove(link))
+	symlinkOrSkip(t, filepath.Join(dir, "b.proto"), link)
+
+	after, err := readFileEntry(fs, link, true)
+	assert.Nil(t, err)
+	assert.Equal(t, before.ModTime, after.ModTime)
+	assert.True(t, after.changedSince(before))
+	assert.False(t, after.changedSince(after))
+}
+
+func TestReadFileEntryWithoutFollowingSymlinks(t *testing.T) {
+	dir := t.TempDir()
+	target := filepath.Join(dir, "a.proto")
+	link := filepath.Join(dir, "shared.proto")
+
+	assert.Nil(t, os.WriteFile(target, []byte("a"), 0644))
+	symlinkOrSkip(t, target, link)
+
+	entry, err := readFileEntry(&LocalFileSystem{}, link, false)
+
+	assert.Nil(t, err)
+	assert.Empty(t, entry.Target)
+}
+
+func TestReadFileEntrySymlinkCycle(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+
+	symlinkOrSkip(t, b, a)
+	symlinkOrSkip(t, a, b)
+
+	_, err := readFileEntry(&LocalFileSystem{}, a, true)
+
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "symlink cycle detected")
+}
+
+func TestReadFileEntryMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	fs := &LocalFileSystem{}
+	file := filepath.Join(dir, "gen.go")
+
+	assert.Nil(t, os.WriteFile(file, []byte("package gen"), 0644))
+	before, err := readFileEntry(fs, file, true)
+	assert.Nil(t, err)
+
+	assert.Nil(t, os.Remove(file))
+	missing, err := readFileEntry(fs, file, true)
+	assert.Nil(t, err)
+	assert.Equal(t, fileEntry{Missing: true}, missing)
+	assert.True(t, missing.changedSince(before))
+	assert.False(t, missing.changedSince(missing))
+	assert.True(t, before.changedSince(missing))
+
+	changed, err := modifiedSince(fs, file, time.Now().Unix())
+	assert.Nil(t, err)
+	assert.True(t, changed)
+
+	encoded, err := json.Marshal(missing)
+	assert.Nil(t, err)
+	assert.JSONEq(t, `{"mtime": 0, "missing": true}`, string(encoded))
+}
+
+func TestReadFileEntryDanglingSymlinkIsMissing(t *testing.T) {
+	dir := t.TempDir()
+	link := filepath.Join(dir, "shared.proto")
+	symlinkOrSkip(t, filepath.Join(dir, "deleted.proto"), link)
+
+	entry, err := readFileEntry(&LocalFileSystem{}, link, true)
+
+	assert.Nil(t, err)
+	assert.True(t, entry.Missing)
+}
+
+func TestTasksSharingFilesDetectChangesIndependently(t *testing.T) {
+	env := NewInMemoryEnv(`
+lint:
+  files: [main.go]
+  run:
+    - "golint"
+
+test:
+  files: [main.go]
+  run:
+    - "go test"
+`)
+	assert.Nil(t, env.FS.WriteFile("main.go", []byte("package main"), 0644))
+
+	run := func(task string) []string {
+		before := len(env.Runner.Commands())
+		assert.Nil(t, env.Run(task))
+		return recordedCommands(env)[before:]
+	}
+
+	assert.Empty(t, run("lint"))
+	assert.Nil(t, env.FS.WriteFile("main.go", []byte("package main // changed"), 0644))
+
+	assert.Equal(t, []string{"golint"}, run("lint"))
+	assert.Empty(t, run("lint"))
+
+	// Running lint recorded the change for lint only.
+	assert.Equal(t, []string{"go test"}, run("test"))
+	assert.Empty(t, run("test"))
+}
+
+func TestBootstrapDiscardsOutdatedLockfile(t *testing.T) {
+	fs := NewMemFileSystem("/work")
+	lockfile := NewLockfile(files, &Options{Quiet: true}, fs)
+	path, err := lockfile.getLockfilePath()
+	assert.Nil(t, err)
+
+	// Older versions recorded the files by project only.
+	assert.Nil(t, fs.WriteFile(path, []byte(`{"/work": {"./lockfile.go": 1671843661}}`), 0644))
+
+	assert.Nil(t, lockfile.Bootstrap())
+	assert.Empty(t, lockfile.GetTaskFiles("lint"))
+
+	assert.Nil(t, lockfile.UpdateTimestampsForFiles("lint", []string{"./lockfile.go"}, true, false))
+	contents, err := fs.ReadFile(path)
+	assert.Nil(t, err)
+
+	recorded, err := decodeLockfile(contents)
+	assert.Nil(t, err)
+	assert.Equal(t, taskFilesJson{"./lockfile.go": {Missing: true}}, recorded["/work"]["lint"])
 }
@@ -0,0 +1,128 @@
+package internal
+
+import (
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterCapability("testing.in_memory")
+}
+
+// A system command received by a RecordingRunner.
+type RecordedCommand struct {
+	Args []string
+	Dir  string
+	Env  []string
+}
+
+// The command line of the recorded command, ie. "go build ./...".
+func (c RecordedCommand) String() string {
+	return strings.Join(c.Args, " ")
+}
+
+// RecordingRunner is a CommandRunner which records the commands instead of
+// running them. Handler decides the outcome of each command, ie. by writing
+// to cmd.Stdout or returning an error. Without it, every command succeeds.
+type RecordingRunner struct {
+	Handler func(cmd *exec.Cmd) error
+
+	mu       sync.Mutex
+	commands []RecordedCommand
+}
+
+func (r *RecordingRunner) Run(cmd *exec.Cmd) error {
+	r.mu.Lock()
+	r.commands = append(r.commands, RecordedCommand{Args: cmd.Args, Dir: cmd.Dir, Env: cmd.Env})
+	r.mu.Unlock()
+
+	if r.Handler == nil {
+		return nil
+	}
+
+	return r.Handler(cmd)
+}
+
+// Returns the recorded commands, in the order they ran.
+func (r *RecordingRunner) Commands() []RecordedCommand {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]RecordedCommand{}, r.commands...)
+}
+
+// InMemoryEnv runs a config entirely in memory: the files, the parser cache,
+// the lockfile and the history live in a MemFileSystem, and the commands are
+// recorded by a RecordingRunner. Tests using it are deterministic and can run
+// in parallel. Command substitutions in global.environment and in files still
+// run when the config is parsed, and built-ins use the real filesystem.
+type InMemoryEnv struct {
+	FS      *MemFileSystem
+	Runner  *RecordingRunner
+	Options Options
+
+	config   string
+	parser   *Parser
+	lockfile Lockfile
+	history  History
+}
+
+// Creates an in-memory engine for the config, working in /work. Files can be
+// added to FS before the first Run, which parses the config.
+func NewInMemoryEnv(config string) *InMemoryEnv {
+	return &InMemoryEnv{
+		FS:      NewMemFileSystem("/work"),
+		Runner:  &RecordingRunner{},
+		Options: Options{Quiet: true},
+		config:  config,
+	}
+}
+
+// Parses the config, unless it was already parsed.
+func (env *InMemoryEnv) Parse() (*Parser, error) {
+	if env.parser != nil {
+		return env.parser, nil
+	}
+
+	p := NewParser(env.config, &env.Options, env.FS)
+	if err := p.parseGlobal(); err != nil {
+		return nil, err
+	}
+
+	if err := p.parseTasks(); err != nil {
+		return nil, err
+	}
+
+	env.Options.StateBackend = p.Global.Shared.StateBackend
+	env.lockfile = NewLockfile(p.TaskFiles(), &env.Options, env.FS)
+	if err := env.lockfile.Bootstrap(); err != nil {
+		return nil, err
+	}
+
+	env.history = NewHistory(&env.Options, env.FS)
+	if err := env.history.Bootstrap(); err != nil {
+		return nil, err
+	}
+
+	env.parser = &p
+	return env.parser, nil
+}
+
+// Runs the given tasks like goke would, or the main task if none are given.
+func (env *InMemoryEnv) Run(taskNames ...string) error {
+	p, err := env.Parse()
+	if err != nil {
+		return err
+	}
+
+	if len(taskNames) == 0 {
+		taskNames = []string{DefaultTask}
+	}
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+
+	_, err = e.executeTasks(taskNames)
+	return err
+}
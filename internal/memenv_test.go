@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func recordedCommands(env *InMemoryEnv) []string {
+	commands := []string{}
+	for _, c := range env.Runner.Commands() {
+		commands = append(commands, c.String())
+	}
+
+	return commands
+}
+
+func ExampleNewInMemoryEnv() {
+	env := NewInMemoryEnv(`
+generate:
+  files: [api/*.proto]
+  run:
+    - "buf generate"
+
+build:
+  deps: [generate]
+  run:
+    - "go build ./..."
+`)
+	_ = env.FS.WriteFile("api/user.proto", []byte(`syntax = "proto3";`), 0644)
+
+	// The proto file didn't change since the lockfile was created,
+	// so the generate dependency is skipped.
+	if err := env.Run("build"); err != nil {
+		fmt.Println(err)
+	}
+
+	// Now it changes, so generate runs.
+	_ = env.FS.WriteFile("api/user.proto", []byte(`syntax = "proto3"; message User {}`), 0644)
+
+	if err := env.Run("generate"); err != nil {
+		fmt.Println(err)
+	}
+
+	for _, c := range env.Runner.Commands() {
+		fmt.Println(c)
+	}
+
+	// Output:
+	// go build ./...
+	// buf generate
+}
+
+func TestInMemoryEnvSkipsUnchangedFiles(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+generate:
+  files: [api/*.proto]
+  run:
+    - "buf generate"
+`)
+	require.Nil(t, env.FS.WriteFile("api/user.proto", []byte("a"), 0644))
+
+	require.Nil(t, env.Run("generate"))
+	require.Empty(t, recordedCommands(env))
+
+	require.Nil(t, env.FS.WriteFile("api/user.proto", []byte("b"), 0644))
+	require.Nil(t, env.Run("generate"))
+	require.Nil(t, env.Run("generate"))
+	require.Equal(t, []string{"buf generate"}, recordedCommands(env))
+
+	require.Nil(t, env.FS.Remove("api/user.proto"))
+	require.Nil(t, env.Run("generate"))
+	require.Equal(t, []string{"buf generate", "buf generate"}, recordedCommands(env))
+}
+
+func TestInMemoryEnvRecordsCommands(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+main:
+  dir: web
+  shell: true
+  env:
+    NODE_ENV: production
+  run:
+    - "npm ci && npm run build"
+`)
+	require.Nil(t, env.FS.WriteFile("web/package.json", []byte("{}"), 0644))
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		_, err := cmd.Stdout.Write([]byte("built\n"))
+		return err
+	}
+
+	require.Nil(t, env.Run())
+
+	commands := env.Runner.Commands()
+	require.Len(t, commands, 1)
+	require.Equal(t, []string{"sh", "-c", "npm ci && npm run build"}, commands[0].Args)
+	require.Equal(t, "web", commands[0].Dir)
+	require.Contains(t, commands[0].Env, "NODE_ENV=production")
+}
+
+func TestInMemoryEnvUnknownTask(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv("build:\n  run:\n    - \"go build ./...\"\n")
+
+	require.EqualError(t, env.Run("deploy"), "task 'deploy' not found")
+}
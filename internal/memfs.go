@@ -0,0 +1,383 @@
+package internal
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Amount of links followed before EvalSymlinks gives up, like the os does.
+const maxSymlinks = 255
+
+// A file, directory or symlink of a MemFileSystem.
+type memFile struct {
+	data    []byte
+	mode    fs.FileMode
+	modTime time.Time
+	target  string
+}
+
+type memFileInfo struct {
+	name string
+	file *memFile
+}
+
+func (fi memFileInfo) Name() string       { return fi.name }
+func (fi memFileInfo) Size() int64        { return int64(len(fi.file.data)) }
+func (fi memFileInfo) Mode() fs.FileMode  { return fi.file.mode }
+func (fi memFileInfo) ModTime() time.Time { return fi.file.modTime }
+func (fi memFileInfo) IsDir() bool        { return fi.file.mode.IsDir() }
+func (fi memFileInfo) Sys() any           { return nil }
+
+// MemFileSystem is a FileSystem kept entirely in memory, so that configs can
+// be tested without touching the real filesystem. Directories are created
+// implicitly. Every write advances its clock by a second, so that mtimes
+// are deterministic and each write is newer than the previous one.
+type MemFileSystem struct {
+	mu    sync.Mutex
+	files map[string]*memFile
+	cwd   string
+	clock time.Time
+}
+
+func NewMemFileSystem(cwd string) *MemFileSystem {
+	m := &MemFileSystem{
+		files: make(map[string]*memFile),
+		cwd:   filepath.Clean(cwd),
+		clock: time.Date(2022, time.December, 24, 0, 0, 0, 0, time.UTC),
+	}
+
+	m.mkdirAll(m.cwd)
+	m.mkdirAll(m.TempDir())
+
+	return m
+}
+
+func (m *MemFileSystem) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.follow("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errors.New("is a directory")}
+	}
+
+	return append([]byte{}, f.data...), nil
+}
+
+// Returns the entries of the directory, sorted by name like os.ReadDir.
+func (m *MemFileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir, f, err := m.resolve("open", name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !f.mode.IsDir() {
+		return nil, &fs.PathError{Op: "readdirent", Path: name, Err: errors.New("not a directory")}
+	}
+
+	entries := []fs.DirEntry{}
+	for p, child := range m.files {
+		if p != dir && filepath.Dir(p) == dir {
+			entries = append(entries, fs.FileInfoToDirEntry(memFileInfo{name: filepath.Base(p), file: child}))
+		}
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name() < entries[j].Name() })
+	return entries, nil
+}
+
+func (m *MemFileSystem) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := m.target(name)
+	if f, ok := m.files[p]; ok && f.mode.IsDir() {
+		return &fs.PathError{Op: "open", Path: name, Err: errors.New("is a directory")}
+	}
+
+	m.mkdirAll(filepath.Dir(p))
+	m.files[p] = &memFile{data: append([]byte{}, data...), mode: perm, modTime: m.tick()}
+
+	return nil
+}
+
+// Creates a symlink at newname pointing to oldname, like os.Symlink.
+func (m *MemFileSystem) Symlink(oldname string, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := m.target(newname)
+	if _, ok := m.files[p]; ok {
+		return &fs.PathError{Op: "symlink", Path: newname, Err: fs.ErrExist}
+	}
+
+	m.mkdirAll(filepath.Dir(p))
+	m.files[p] = &memFile{mode: fs.ModeSymlink | 0777, modTime: m.tick(), target: oldname}
+
+	return nil
+}
+
+// Sets the mtime of the file, like os.Chtimes.
+func (m *MemFileSystem) Chtimes(name string, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.follow("chtimes", name)
+	if err != nil {
+		return err
+	}
+
+	f.modTime = mtime
+	return nil
+}
+
+func (m *MemFileSystem) Getwd() (string, error) {
+	return m.cwd, nil
+}
+
+func (m *MemFileSystem) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, err := m.follow("stat", name)
+	if err != nil {
+		return nil, err
+	}
+
+	return memFileInfo{name: filepath.Base(name), file: f}, nil
+}
+
+func (m *MemFileSystem) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p, err := m.realPath("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+
+	return memFileInfo{name: filepath.Base(name), file: m.files[p]}, nil
+}
+
+func (m *MemFileSystem) EvalSymlinks(path string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	resolved, _, err := m.resolve("lstat", path)
+	if err != nil {
+		return "", err
+	}
+
+	// Like filepath.EvalSymlinks, relative paths stay relative.
+	if !filepath.IsAbs(path) {
+		if rel, err := filepath.Rel(m.cwd, resolved); err == nil {
+			return rel, nil
+		}
+	}
+
+	return resolved, nil
+}
+
+func (m *MemFileSystem) FileExists(filename string) bool {
+	info, err := m.Stat(filename)
+	return err == nil && !info.IsDir()
+}
+
+func (m *MemFileSystem) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	p := m.target(name)
+	if _, ok := m.files[p]; !ok {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrNotExist}
+	}
+
+	for other := range m.files {
+		if strings.HasPrefix(other, p+string(filepath.Separator)) {
+			return &fs.PathError{Op: "remove", Path: name, Err: errors.New("directory not empty")}
+		}
+	}
+
+	delete(m.files, p)
+	return nil
+}
+
+// Moves the file, replacing the one at newpath, like os.Rename.
+func (m *MemFileSystem) Rename(oldpath string, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	from, to := m.target(oldpath), m.target(newpath)
+	f, ok := m.files[from]
+	if !ok {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: fs.ErrNotExist}
+	}
+
+	if f.mode.IsDir() {
+		return &os.LinkError{Op: "rename", Old: oldpath, New: newpath, Err: errors.New("is a directory")}
+	}
+
+	m.mkdirAll(filepath.Dir(to))
+	delete(m.files, from)
+	m.files[to] = f
+
+	return nil
+}
+
+func (m *MemFileSystem) TempDir() string {
+	return filepath.Join(string(filepath.Separator), "tmp")
+}
+
+// Returns the paths matching the pattern, sorted like filepath.Glob.
+// Relative patterns match paths relative to the working directory.
+func (m *MemFileSystem) Glob(pattern string) ([]string, error) {
+	if _, err := filepath.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	abs := m.abs(pattern)
+	matches := []string{}
+
+	for p := range m.files {
+		if ok, _ := filepath.Match(abs, p); !ok {
+			continue
+		}
+
+		if !filepath.IsAbs(pattern) {
+			p, _ = filepath.Rel(m.cwd, p)
+		}
+
+		matches = append(matches, p)
+	}
+
+	sort.Strings(matches)
+	return matches, nil
+}
+
+func (m *MemFileSystem) abs(name string) string {
+	if filepath.IsAbs(name) {
+		return filepath.Clean(name)
+	}
+
+	return filepath.Join(m.cwd, name)
+}
+
+// The time of the filesystem, which only moves forward with writes.
+func (m *MemFileSystem) Now() time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.clock
+}
+
+func (m *MemFileSystem) tick() time.Time {
+	m.clock = m.clock.Add(time.Second)
+	return m.clock
+}
+
+func (m *MemFileSystem) mkdirAll(dir string) {
+	for {
+		if _, ok := m.files[dir]; ok {
+			return
+		}
+
+		m.files[dir] = &memFile{mode: fs.ModeDir | 0755, modTime: m.clock}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return
+		}
+		dir = parent
+	}
+}
+
+// Returns the file at the end of the symlinks starting at name.
+func (m *MemFileSystem) follow(op string, name string) (*memFile, error) {
+	_, f, err := m.resolve(op, name)
+	return f, err
+}
+
+// Resolves all symlinks of the path, including the last element.
+func (m *MemFileSystem) resolve(op string, name string) (string, *memFile, error) {
+	p, err := m.realPath(op, name, true)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return p, m.files[p], nil
+}
+
+// Resolves the symlinks of the directories in the path, and the one of the
+// last element when followLast is set. The resolved path exists.
+func (m *MemFileSystem) realPath(op string, name string, followLast bool) (string, error) {
+	sep := string(filepath.Separator)
+	links := 0
+
+	var walk func(p string, followLast bool) (string, error)
+	walk = func(p string, followLast bool) (string, error) {
+		parts := strings.Split(strings.TrimPrefix(p, sep), sep)
+		cur := sep
+
+		for i, part := range parts {
+			if part == "" {
+				continue
+			}
+
+			next := filepath.Join(cur, part)
+			f, ok := m.files[next]
+			if !ok {
+				return "", &fs.PathError{Op: op, Path: name, Err: fs.ErrNotExist}
+			}
+
+			if f.mode&fs.ModeSymlink != 0 && (i < len(parts)-1 || followLast) {
+				if links++; links > maxSymlinks {
+					return "", &fs.PathError{Op: op, Path: name, Err: errors.New("too many links")}
+				}
+
+				target := f.target
+				if !filepath.IsAbs(target) {
+					target = filepath.Join(cur, target)
+				}
+
+				resolved, err := walk(filepath.Clean(target), true)
+				if err != nil {
+					return "", err
+				}
+				next = resolved
+			}
+
+			cur = next
+		}
+
+		return cur, nil
+	}
+
+	return walk(m.abs(name), followLast)
+}
+
+// Returns the absolute path of name, with the symlinks of its directories
+// resolved when they exist, ie. to create a file in a linked directory.
+func (m *MemFileSystem) target(name string) string {
+	p := m.abs(name)
+	if dir, err := m.realPath("open", filepath.Dir(p), true); err == nil {
+		return filepath.Join(dir, filepath.Base(p))
+	}
+
+	return p
+}
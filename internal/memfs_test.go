@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"io/fs"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemFileSystemFiles(t *testing.T) {
+	m := NewMemFileSystem("/work")
+
+	require.Nil(t, m.WriteFile("src/a.go", []byte("a"), 0644))
+	require.Nil(t, m.WriteFile("src/b.go", []byte("b"), 0644))
+	require.Nil(t, m.WriteFile("/work/src/c.txt", []byte("c"), 0644))
+
+	contents, err := m.ReadFile("/work/src/a.go")
+	require.Nil(t, err)
+	require.Equal(t, "a", string(contents))
+
+	matches, err := m.Glob("src/*.go")
+	require.Nil(t, err)
+	require.Equal(t, []string{"src/a.go", "src/b.go"}, matches)
+
+	a, _ := m.Stat("src/a.go")
+	b, _ := m.Stat("src/b.go")
+	require.True(t, b.ModTime().After(a.ModTime()))
+
+	require.True(t, m.FileExists("src/a.go"))
+	require.False(t, m.FileExists("src"))
+	require.NotNil(t, m.Remove("src"))
+	require.Nil(t, m.Remove("src/a.go"))
+
+	_, err = m.ReadFile("src/a.go")
+	require.ErrorIs(t, err, fs.ErrNotExist)
+}
+
+func TestMemFileSystemSymlinks(t *testing.T) {
+	m := NewMemFileSystem("/work")
+	mtime := time.Date(2022, time.December, 24, 1, 1, 1, 0, time.UTC)
+
+	require.Nil(t, m.WriteFile("a.proto", []byte("a"), 0644))
+	require.Nil(t, m.Chtimes("a.proto", mtime))
+	require.Nil(t, m.Symlink("a.proto", "shared.proto"))
+
+	entry, err := readFileEntry(m, "shared.proto", true)
+	require.Nil(t, err)
+	require.Equal(t, fileEntry{ModTime: mtime.Unix(), Target: "a.proto"}, entry)
+
+	require.Nil(t, m.Symlink("loop-b", "loop-a"))
+	require.Nil(t, m.Symlink("loop-a", "loop-b"))
+
+	_, err = readFileEntry(m, "loop-a", true)
+	require.EqualError(t, err, "symlink cycle detected while resolving loop-a")
+}
@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCapability("report.metadata")
+}
+
+// Commit of goke, set at build time through -ldflags. Falls back
+// to the VCS information embedded by the Go toolchain.
+var Commit = ""
+
+// Parts of argument names whose values are masked in the metadata.
+var secretArgNames = []string{"token", "secret", "password", "passwd", "key", "auth", "credential"}
+
+// Describes the goke build and invocation which produced a report, so that
+// attached reports and logs can be traced back to what produced them.
+// It's populated once at startup and shared by all reports of the run.
+type RunMetadata struct {
+	Version    string    `json:"version"`
+	Commit     string    `json:"commit,omitempty"`
+	OS         string    `json:"os"`
+	Arch       string    `json:"arch"`
+	Args       []string  `json:"args"`
+	ConfigHash string    `json:"config_sha256"`
+	Started    time.Time `json:"started"`
+	Hostname   string    `json:"hostname,omitempty"`
+}
+
+// Collects the metadata of the current run. Values of secret-looking
+// arguments are masked and the hostname is left out with GOKE_NO_HOSTNAME=1.
+func NewRunMetadata(args []string, config string) RunMetadata {
+	sum := sha256.Sum256([]byte(config))
+
+	m := RunMetadata{
+		Version:    Version,
+		Commit:     buildCommit(),
+		OS:         runtime.GOOS,
+		Arch:       runtime.GOARCH,
+		Args:       maskArgs(args),
+		ConfigHash: hex.EncodeToString(sum[:]),
+		Started:    time.Now().Round(0),
+	}
+
+	if os.Getenv("GOKE_NO_HOSTNAME") != "1" {
+		m.Hostname, _ = os.Hostname()
+	}
+
+	return m
+}
+
+// Renders the metadata as "key: value" lines, for the top of log files.
+func (m RunMetadata) header() string {
+	lines := []string{
+		fmt.Sprintf("version: %s", m.Version),
+		fmt.Sprintf("commit: %s", m.Commit),
+		fmt.Sprintf("os: %s/%s", m.OS, m.Arch),
+		fmt.Sprintf("args: %s", strings.Join(m.Args, " ")),
+		fmt.Sprintf("config_sha256: %s", m.ConfigHash),
+		fmt.Sprintf("started: %s", m.Started.Format(time.RFC3339Nano)),
+	}
+
+	if m.Hostname != "" {
+		lines = append(lines, fmt.Sprintf("hostname: %s", m.Hostname))
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func buildCommit() string {
+	if Commit != "" {
+		return Commit
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return ""
+	}
+
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			return setting.Value
+		}
+	}
+
+	return ""
+}
+
+// Replaces the values of secret-looking arguments with "***", both for
+// "--token=abc" and "--token abc", as well as variables like "API_KEY=abc".
+func maskArgs(args []string) []string {
+	masked := make([]string, len(args))
+	maskNext := false
+
+	for i, arg := range args {
+		if maskNext {
+			masked[i] = "***"
+			maskNext = false
+			continue
+		}
+
+		name, _, hasValue := strings.Cut(arg, "=")
+		if !isSecretArg(name) {
+			masked[i] = arg
+			continue
+		}
+
+		if hasValue {
+			masked[i] = name + "=***"
+		} else {
+			masked[i] = arg
+			maskNext = strings.HasPrefix(arg, "-")
+		}
+	}
+
+	return masked
+}
+
+func isSecretArg(name string) bool {
+	name = strings.ToLower(strings.TrimLeft(name, "-"))
+
+	for _, secret := range secretArgNames {
+		if strings.Contains(name, secret) {
+			return true
+		}
+	}
+
+	return false
+}
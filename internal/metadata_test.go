@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestMaskArgs(t *testing.T) {
+	args := []string{"--watch", "--api-token=abc", "build", "--password", "hunter2", "DEPLOY_KEY=xyz", "--verbose"}
+
+	require.Equal(t, []string{"--watch", "--api-token=***", "build", "--password", "***", "DEPLOY_KEY=***", "--verbose"}, maskArgs(args))
+}
+
+func TestNewRunMetadata(t *testing.T) {
+	t.Setenv("GOKE_NO_HOSTNAME", "1")
+	m := NewRunMetadata([]string{"--force", "build"}, "build:\n  run: [\"go build\"]\n")
+
+	require.Equal(t, Version, m.Version)
+	require.Equal(t, []string{"--force", "build"}, m.Args)
+	require.Len(t, m.ConfigHash, 64)
+	require.Empty(t, m.Hostname)
+	require.NotContains(t, m.header(), "hostname")
+}
+
+func TestRunMetadataIsIdenticalInAllReports(t *testing.T) {
+	t.Setenv("GOKE_NO_HOSTNAME", "")
+	m := NewRunMetadata([]string{"--watch", "--serve-status", ":4477", "build"}, "build:\n  run: [\"go build\"]\n")
+
+	// The crash file starts with the metadata.
+	dir, _ := stubCrashHandling(t)
+	orig := crashMetadata
+	SetCrashMetadata(m)
+	t.Cleanup(func() { crashMetadata = orig })
+
+	handlePanic("boom", []byte("goroutine 1"), nil)
+
+	crashFiles, _ := filepath.Glob(filepath.Join(dir, "crash-*.log"))
+	require.Len(t, crashFiles, 1)
+
+	contents, err := os.ReadFile(crashFiles[0])
+	require.Nil(t, err)
+	require.True(t, strings.HasPrefix(string(contents), m.header()+"\n"))
+
+	// The status and every record of the history carry it too.
+	status := newWatchStatus("build")
+	status.metadata = &m
+	status.beginIteration("build", triggerInitial)
+	status.endIteration(true, nil)
+
+	server := httptest.NewServer(status.handler(false))
+	defer server.Close()
+	s := &testWatchSession{server: server}
+
+	var snapshot statusJson
+	s.getJson(t, "/status", &snapshot)
+	require.Equal(t, m.header(), snapshot.Metadata.header())
+
+	var history []iteration
+	s.getJson(t, "/history", &history)
+	require.Len(t, history, 1)
+	require.Equal(t, m.header(), history[0].Metadata.header())
+
+	// So does the last successful run of the task in the run history.
+	runs := NewHistory(&Options{}, NewMemFileSystem("/work"))
+	require.Nil(t, runs.RecordSuccess("build", m.Started, m))
+	require.Equal(t, m.header(), runs.LastRun("build").header())
+}
@@ -4,7 +4,9 @@ import (
 	"encoding/json"
 	"errors"
 	"net/http"
+	"path/filepath"
 	"strings"
+	"time"
 )
 
 const GITHUB_TAGS_ENDPOINT = "https://api.github.com/repos/dugajean/goke/git/refs/tags"
@@ -16,6 +18,139 @@ type Options struct {
 	Init       bool
 	Quiet      bool
 	Version    bool
+	Verbose    bool
+	Debounce   time.Duration
+	List       bool
+	Batch      string
+	Preflight  bool
+	Tag        string
+	Hup        string
+	CaptureDir string
+	DryRun     bool
+
+	// With "goke completion", prints one task per line for the completion
+	// scripts, see WriteCompletionScript.
+	Porcelain bool
+
+	// Replace the {ARGS} placeholder, given after "--".
+	ExtraArgs []string
+
+	// Override the params of tasks, by task name, see SplitParams.
+	Params map[string]map[string]string
+
+	// Also reports which source each variable comes from, with -vv.
+	VeryVerbose bool
+
+	// Lists the variables defined by more than one source, for
+	// "goke config --env-conflicts".
+	EnvConflicts bool
+
+	// Bounds the whole invocation, see runDeadline.
+	Deadline time.Duration
+
+	// How many of the given tasks run at the same time, see executeJobs.
+	Jobs int
+
+	// The config to load instead of the goke.yml of the working directory,
+	// see CurrentConfigFile.
+	ConfigPath string
+
+	// Only runs the tasks whose files changed since the git ref or within
+	// the duration, see sinceFilter.
+	Since string
+
+	// Fails on overlapping outputs instead of warning, see analyzeOutputs.
+	Strict bool
+
+	// Skips the generate_tasks command of the config, see runGenerator.
+	NoGenerate bool
+
+	// Rewrites the golden files instead of comparing them, see checkGolden.
+	UpdateGolden bool
+
+	// Never asks whether to rerun a failed run, see askRerun, nor which
+	// task to run without a main task, see pickTask.
+	NoInteractive bool
+
+	// With "goke fmt", fail when the config isn't formatted, or print what
+	// would change, instead of formatting it, see FormatConfigFile.
+	Check bool
+	Diff  bool
+
+	// With "goke doctor", only checks the binaries of the tasks, see
+	// Parser.CheckTools.
+	Tools bool
+
+	// The flags of the command given on the command line, which only apply
+	// to it, see cli.RegisterCommandFlags.
+	CommandFlags []string
+
+	// With "goke prune-tasks", the report as JSON, or asking which tasks to
+	// delete, and how long tasks must not have run, see PruneCandidates.
+	JSON      bool
+	Delete    bool
+	UnusedFor time.Duration
+
+	// Writes the summary line of the run to the file, or to stdout with
+	// SummaryLineStdout, see runReport.
+	SummaryLine string
+
+	// Runs the commands of the tasks without the events, the on_success and
+	// on_failure commands and the variables of the config, see bareBanner.
+	Bare bool
+
+	// Discards the output of the commands which ran quickly without any,
+	// from then on, see quietCommands.
+	DiscardQuiet bool
+
+	// Watch even when another session watches the same tasks or files.
+	AllowMultipleWatch bool
+
+	// See AutoCleanTemp.
+	KeepTemp      bool
+	TempRetention time.Duration
+
+	ServeStatus        string
+	AllowRemoteTrigger bool
+
+	// Where the lockfile and the cache of the config live, see --state.
+	// StateDir is the project's .goke directory when they live there, and
+	// empty otherwise, see ResolveStateDir.
+	State    string
+	StateDir string
+
+	// The state_backend of the config, which keeps the lockfile and the
+	// history, see StateStore.
+	StateBackend string
+
+	// Parses every task of the config even after one failed, returning
+	// the errors of all of them, see ConfigErrors. Only goke validate
+	// does, to report every problem at once.
+	CollectErrors bool
+}
+
+// Makes the paths given on the command line absolute, relative to the
+// working directory, ie. before goke changes to the directory of the config.
+func (opts *Options) absPaths() error {
+	paths := []*string{&opts.ConfigPath, &opts.Batch, &opts.CaptureDir}
+	if opts.SummaryLine != SummaryLineStdout {
+		paths = append(paths, &opts.SummaryLine)
+	}
+
+	for _, path := range paths {
+		if *path == "" {
+			continue
+		}
+
+		abs, err := filepath.Abs(*path)
+		if err != nil {
+			return err
+		}
+
+		*path = abs
+	}
+
+	return nil
 }
 
 func (opts *Options) InitHandler() error {
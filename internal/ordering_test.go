@@ -0,0 +1,138 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// The orderings goke guarantees, which the traces below pin down:
+//
+//   - Dependencies run before the task, in the order of "deps", each at most
+//     once per run.
+//   - Run entries run in the order they are listed. A referenced task runs in
+//     place of its entry, and exports apply to the entries after them.
+//   - The events which apply to a task given on the command line run around
+//     it: before_each_task before its first entry, after_each_task after its
+//     last one, and before_each_run and after_each_run around every entry,
+//     or once around the group of a parallel task. Dependencies and
+//     referenced tasks only run after_each_task.
+//   - {FILES} lists the files in the order of the "files" patterns, the
+//     matches of each pattern sorted. {FILES_SORTED} lists all of them
+//     sorted, without duplicates.
+//   - Tasks given by name run in the given order, and tasks selected with
+//     --tag in the order the config declares them.
+//   - Tasks are parsed in the order the config declares them, which is the
+//     order their $(...) commands run in.
+const orderingConfig = `
+global:
+  events:
+    before_each_task:
+      - "echo task"
+    after_each_task:
+      - "echo done"
+    after_each_run:
+      - cmd: "echo ran"
+        only_tags: [check]
+
+lint:
+  tags: [check]
+  files: ["src/**/*.go", main.go]
+  run:
+    - "gofmt -l {FILES}"
+    - "golint {FILES_SORTED}"
+
+generate:
+  tags: [build]
+  files: [api/*.proto]
+  run:
+    - "protoc {FILES}"
+
+build:
+  tags: [build]
+  deps: [generate, lint]
+  params:
+    os: linux
+  run:
+    - export:
+        GOOS: "{os}"
+    - "go build"
+    - "lint"
+    - cmd: "go test"
+      dir: src
+
+vet:
+  tags: [check]
+  run:
+    - "go vet"
+`
+
+func newOrderingEnv(t *testing.T) *InMemoryEnv {
+	env := NewInMemoryEnv(orderingConfig)
+	env.Options.Force = true
+
+	for _, f := range []string{"main.go", "src/b.go", "src/b/x.go", "src/a.go", "api/user.proto", "api/order.proto"} {
+		require.Nil(t, env.FS.WriteFile("/work/"+f, []byte(f), 0644))
+	}
+
+	return env
+}
+
+func TestOrderingOfARun(t *testing.T) {
+	t.Parallel()
+
+	env := newOrderingEnv(t)
+	require.Nil(t, env.Run("build"))
+
+	require.Equal(t, []string{
+		"protoc api/order.proto api/user.proto",
+		"echo done",
+		"gofmt -l src/a.go src/b.go src/b/x.go main.go",
+		"golint main.go src/a.go src/b.go src/b/x.go",
+		"echo done",
+		"echo task",
+		"go build",
+		"gofmt -l src/a.go src/b.go src/b/x.go main.go",
+		"golint main.go src/a.go src/b.go src/b/x.go",
+		"echo done",
+		"go test",
+		"echo done",
+	}, recordedCommands(env))
+}
+
+func TestOrderingOfTaggedTasks(t *testing.T) {
+	t.Parallel()
+
+	env := newOrderingEnv(t)
+	env.Options.Tag = "check"
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, []string{"lint", "vet"}, p.tasksWithTag("check"))
+	require.Equal(t, []string{"generate", "build"}, p.tasksWithTag("build"))
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	require.Nil(t, e.Start(nil))
+
+	require.Equal(t, []string{
+		"echo task",
+		"gofmt -l src/a.go src/b.go src/b/x.go main.go",
+		"echo ran",
+		"golint main.go src/a.go src/b.go src/b/x.go",
+		"echo ran",
+		"echo done",
+		"echo task",
+		"go vet",
+		"echo ran",
+		"echo done",
+	}, recordedCommands(env))
+}
+
+func TestFilesSortedRemovesDuplicates(t *testing.T) {
+	t.Parallel()
+
+	commands, err := batchCommand("cat {FILES_SORTED}", []string{"b.go", "a.go", "b.go"}, 100)
+	require.Nil(t, err)
+	require.Equal(t, []string{"cat a.go b.go"}, commands)
+}
@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("task.on_success")
+	RegisterCapability("task.on_failure")
+}
+
+// The variables the on_failure commands of a task receive: the command which
+// failed, and the error of the task. They hold arbitrary text, so they are
+// only set in the environment of the commands and never expanded by goke,
+// see hookEnv.
+const (
+	FailedCmdVar = "GOKE_FAILED_CMD"
+	ErrorVar     = "GOKE_ERROR"
+)
+
+// Runs the on_success or the on_failure commands of the task, depending on
+// the error its commands ended with, and returns the error of the task. When
+// an on_success command fails, so does the task. The failures of on_failure
+// commands are only reported, the task keeps its own error. Tasks cut off by
+// the deadline, and tasks run with --bare, run neither.
+func (e *Executor) runOutcomeHooks(task Task, env map[string]string, err error) error {
+	if e.deadline.hasExpired() || e.options.Bare {
+		return err
+	}
+
+	if err == nil {
+		return e.runTaskHooks(task, task.OnSuccess, env, nil)
+	}
+
+	if len(task.OnFailure) == 0 {
+		return err
+	}
+
+	failure := map[string]string{FailedCmdVar: "", ErrorVar: err.Error()}
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) {
+		failure[FailedCmdVar] = cmdErr.Cmd
+	}
+
+	if hookErr := e.runTaskHooks(task, task.OnFailure, env, failure); hookErr != nil && !e.options.Quiet {
+		message, _, _ := strings.Cut(hookErr.Error(), "\n")
+		fmt.Fprintf(os.Stderr, "on_failure of '%s' failed: %s\n", task.Name, message)
+	}
+
+	return err
+}
+
+// Runs the commands like the ones of the task, with its variables and in its
+// directory, but without retries, stopping at the first one which fails.
+// They don't count as steps of the progress. The outcome variables are only
+// set in the environment of the commands, see hookEnv.
+func (e *Executor) runTaskHooks(task Task, cmds []string, env map[string]string, outcome map[string]string) error {
+	c := *e
+	c.progress = nil
+	outputs := make(chan Ref[string])
+
+	for _, cmd := range cmds {
+		entry := e.parser.hookEntry(cmd)
+		entry.Dir = task.Dir
+		entry.shell = e.parser.usesShell(task)
+		entry.timeout = e.parser.commandTimeout(task)
+		entry.task = task.Name
+		entry.processEnv = outcome
+
+		err := c.runSysOrRecurse(entry, hookEnv(env, outcome, entry), &outputs)
+		if err != nil && !entry.IgnoreError {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The variables the command line of a hook is expanded with. The outcome
+// variables expand to a reference to themselves in the syntax of the shell,
+// ie. ${GOKE_ERROR}, so that the shell reads them from the environment of
+// the command instead of parsing their text as part of the command line.
+func hookEnv(env map[string]string, outcome map[string]string, entry RunEntry) map[string]string {
+	if len(outcome) == 0 {
+		return env
+	}
+
+	vars := make(map[string]string, len(env)+len(outcome))
+	for k, v := range env {
+		vars[k] = v
+	}
+
+	for k := range outcome {
+		vars[k] = shellVarReference(k, entry.windowsShell)
+	}
+
+	return vars
+}
+
+// Refers to the variable in the syntax of the shell commands run through,
+// see shellCommand.
+func shellVarReference(name string, windowsShell string) string {
+	if runtime.GOOS != "windows" {
+		return "${" + name + "}"
+	}
+
+	switch windowsShell {
+	case windowsShellPowerShell, windowsShellPwsh:
+		return "$env:" + name
+	}
+
+	return "%" + name + "%"
+}
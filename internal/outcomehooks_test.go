@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const outcomeHooksConfig = `
+test:
+  run:
+    - "go vet ./..."
+    - "go test ./..."
+  on_success:
+    - "notify passed"
+  on_failure:
+    - "docker compose logs"
+    - "notify failed"
+`
+
+// Fails the commands starting with one of the prefixes.
+func failCommands(env *InMemoryEnv, prefixes ...string) {
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(strings.Join(cmd.Args, " "), prefix) {
+				return errors.New("exit status 1")
+			}
+		}
+
+		return nil
+	}
+}
+
+func TestOnSuccessRunsAfterTheCommands(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(outcomeHooksConfig)
+	require.Nil(t, env.Run("test"))
+	require.Equal(t, []string{"go vet ./...", "go test ./...", "notify passed"}, recordedCommands(env))
+}
+
+func TestOnFailureReceivesTheFailure(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(outcomeHooksConfig)
+	failCommands(env, "go test")
+
+	err := env.Run("test")
+	require.EqualError(t, err, `"go test ./..." failed: exit status 1`)
+	require.Equal(t, []string{"go vet ./...", "go test ./...", "docker compose logs", "notify failed"}, recordedCommands(env))
+
+	hook := env.Runner.Commands()[3]
+	require.Contains(t, hook.Env, "GOKE_FAILED_CMD=go test ./...")
+	require.Contains(t, hook.Env, `GOKE_ERROR="go test ./..." failed: exit status 1`)
+}
+
+func TestFailingOnFailureKeepsTheError(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(outcomeHooksConfig)
+	failCommands(env, "go test", "docker")
+
+	err := env.Run("test")
+	require.EqualError(t, err, `"go test ./..." failed: exit status 1`)
+	require.Equal(t, []string{"go vet ./...", "go test ./...", "docker compose logs"}, recordedCommands(env))
+}
+
+func TestFailingOnSuccessFailsTheTask(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(outcomeHooksConfig)
+	failCommands(env, "notify")
+
+	err := env.Run("test")
+	require.EqualError(t, err, `"notify passed" failed: exit status 1`)
+	require.Equal(t, []string{"go vet ./...", "go test ./...", "notify passed"}, recordedCommands(env))
+}
+
+func TestOnFailureNeverParsesTheFailure(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+test:
+  shell: true
+  run:
+    - "go test ./... \"; touch pwned; $(id) && whoami\""
+  on_failure:
+    - "notify \"$GOKE_FAILED_CMD\" \"${GOKE_ERROR}\""
+`)
+	failCommands(env, "sh -c go test")
+
+	require.NotNil(t, env.Run("test"))
+
+	commands := env.Runner.Commands()
+	require.Len(t, commands, 2)
+	require.Equal(t, []string{"sh", "-c", `notify "${GOKE_FAILED_CMD}" "${GOKE_ERROR}"`}, commands[1].Args)
+	require.Contains(t, commands[1].Env, "GOKE_FAILED_CMD=go test ./... \"; touch pwned; $(id) && whoami\"")
+}
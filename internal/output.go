@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/mattn/go-isatty"
+	"github.com/theckman/yacspin"
+	"golang.org/x/text/encoding"
+)
+
+// Clears the current terminal line, which is where the spinner is drawn.
+const eraseLine = "\r\033[K"
+
+// Streams the output of a command line by line. While a line is written, the
+// spinner is paused and its line erased, so it gets redrawn below the output
+// instead of the two clobbering each other. Lines are converted to UTF-8
+// from the given encoding, see decodeOutput.
+type spinnerWriter struct {
+	spinner  *yacspin.Spinner
+	out      io.Writer
+	mu       *sync.Mutex
+	erase    bool
+	encoding encoding.Encoding
+	buf      []byte
+
+	// Prepended to every line, ie. the name of a subproject.
+	prefix string
+}
+
+// Creates the writers for the stdout and stderr of a command. They share
+// a lock, so that lines of both streams never get interleaved.
+func newOutputWriters(spinner *yacspin.Spinner, enc encoding.Encoding) (*spinnerWriter, *spinnerWriter) {
+	mu := &sync.Mutex{}
+	erase := spinner != nil && !isPlainTerminal(os.Getenv("TERM"), isatty.IsTerminal(os.Stdout.Fd()))
+
+	stdout := &spinnerWriter{spinner: spinner, out: os.Stdout, mu: mu, erase: erase, encoding: enc}
+	stderr := &spinnerWriter{spinner: spinner, out: os.Stderr, mu: mu, erase: erase, encoding: enc}
+
+	return stdout, stderr
+}
+
+// Buffers the written bytes and outputs every complete line.
+func (w *spinnerWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+
+	i := bytes.LastIndexByte(w.buf, '\n')
+	if i == -1 {
+		return len(p), nil
+	}
+
+	if err := w.writeOut(w.buf[:i+1]); err != nil {
+		return 0, err
+	}
+
+	w.buf = append([]byte{}, w.buf[i+1:]...)
+	return len(p), nil
+}
+
+// Outputs whatever is left in the buffer, ie. a last line without a newline.
+func (w *spinnerWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	err := w.writeOut(append(w.buf, '\n'))
+	w.buf = nil
+
+	return err
+}
+
+func (w *spinnerWriter) writeOut(lines []byte) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	paused := w.spinner != nil && w.spinner.Pause() == nil
+	if paused {
+		defer w.spinner.Unpause()
+	}
+
+	if w.erase {
+		if _, err := io.WriteString(w.out, eraseLine); err != nil {
+			return err
+		}
+	}
+
+	out := decodeOutput(lines, w.encoding)
+	if w.prefix != "" {
+		out = []byte(prefixLines(strings.TrimSuffix(string(out), "\n"), w.prefix))
+	}
+
+	_, err := w.out.Write(out)
+	return err
+}
@@ -0,0 +1,48 @@
+package internal
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpinnerWriterWritesCompleteLines(t *testing.T) {
+	out := bytes.Buffer{}
+	w := &spinnerWriter{out: &out, mu: &sync.Mutex{}}
+
+	_, _ = w.Write([]byte("ok  \tgithub.com/dugajean/goke"))
+	require.Empty(t, out.String())
+
+	_, _ = w.Write([]byte("/internal\nok  \tgithub.com/dugajean/goke/cmd"))
+	require.Equal(t, "ok  \tgithub.com/dugajean/goke/internal\n", out.String())
+
+	require.Nil(t, w.Flush())
+	require.Equal(t, "ok  \tgithub.com/dugajean/goke/internal\nok  \tgithub.com/dugajean/goke/cmd\n", out.String())
+}
+
+func TestSpinnerWriterErasesSpinnerLine(t *testing.T) {
+	out := bytes.Buffer{}
+	w := &spinnerWriter{out: &out, mu: &sync.Mutex{}, erase: true}
+
+	_, _ = w.Write([]byte("first\nsecond\n"))
+	require.Equal(t, eraseLine+"first\nsecond\n", out.String())
+}
+
+func TestSpinnerWriterFlushWithoutPendingOutput(t *testing.T) {
+	out := bytes.Buffer{}
+	w := &spinnerWriter{out: &out, mu: &sync.Mutex{}}
+
+	require.Nil(t, w.Flush())
+	require.Empty(t, out.String())
+}
+
+func TestSpinnerWriterPrefixesLines(t *testing.T) {
+	out := bytes.Buffer{}
+	w := &spinnerWriter{out: &out, mu: &sync.Mutex{}, prefix: "[services/api] "}
+
+	_, _ = w.Write([]byte("first\nsecond\nthird"))
+	require.Nil(t, w.Flush())
+	require.Equal(t, "[services/api] first\n[services/api] second\n[services/api] third\n", out.String())
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCapability("run.output")
+}
+
+// How the output of a referenced task shows, set with the output of its
+// run entry.
+const (
+	// Like the task referencing it, the default.
+	OutputInherit = "inherit"
+
+	// Only when the referenced task fails, see dispatchReferenced.
+	OutputOnFailure = "on-failure"
+
+	// Always, even when the task referencing it hides its own output.
+	OutputAlways = "always"
+
+	// Never.
+	OutputNever = "never"
+)
+
+// How many of the last lines of a referenced task with output: on-failure
+// are shown when it fails. All of them are written to a log file.
+const failedOutputLines = 50
+
+// Fails unless the output of the entry is one of the policies.
+func validateOutput(entry RunEntry, line int) error {
+	switch entry.Output {
+	case "", OutputInherit, OutputOnFailure, OutputAlways, OutputNever:
+		return nil
+	}
+
+	return fmt.Errorf("line %d: unknown output '%s', expected %s, %s, %s or %s", line, entry.Output, OutputInherit, OutputOnFailure, OutputAlways, OutputNever)
+}
+
+// Where the output of the commands goes: the terminal, unless the output
+// of a referenced task is hidden or held back, see dispatchReferenced.
+func (e *Executor) stdout() io.Writer {
+	if e.outputTo != nil {
+		return e.outputTo
+	}
+
+	return os.Stdout
+}
+
+// Dispatches the task referenced by the entry, showing its output according
+// to the output of the entry. --capture-dir receives the whole output of its
+// commands anyway, since they are captured before being shown.
+func (e *Executor) dispatchReferenced(entry RunEntry, task Task) error {
+	outputTo := e.outputTo
+	defer func() { e.outputTo = outputTo }()
+
+	switch entry.Output {
+	case OutputAlways:
+		e.outputTo = nil
+	case OutputNever:
+		e.outputTo = io.Discard
+	case OutputOnFailure:
+		held := &lockedBuffer{}
+		e.outputTo = held
+
+		err := e.dispatchTask(task, false)
+		if err != nil {
+			e.outputTo = outputTo
+			e.showFailedOutput(task.Name, held.String())
+		}
+
+		return err
+	}
+
+	return e.dispatchTask(task, false)
+}
+
+// Shows the output held back for the failed task. Only its last lines are
+// shown when it's long, along with the log file holding all of it.
+func (e *Executor) showFailedOutput(taskName string, out string) {
+	out = strings.TrimRight(out, "\n")
+	if out == "" {
+		return
+	}
+
+	shown := strings.Builder{}
+	lines := strings.Split(out, "\n")
+	if len(lines) > failedOutputLines {
+		omitted := len(lines) - failedOutputLines
+		lines = lines[omitted:]
+
+		if logFile, err := e.writeFailedOutput(taskName, out); err == nil {
+			fmt.Fprintf(&shown, "... %d earlier line(s) of task '%s', all of them are in %s\n", omitted, taskName, logFile)
+		} else {
+			fmt.Fprintf(&shown, "... %d earlier line(s) of task '%s'\n", omitted, taskName)
+		}
+	}
+	shown.WriteString(strings.Join(lines, "\n") + "\n")
+
+	// The lines were already prefixed when they were held back.
+	stdout, _ := e.outputWriters(nil)
+	stdout.prefix = ""
+	_, _ = stdout.Write([]byte(shown.String()))
+}
+
+// Writes the whole output of the failed task to a log file in the state
+// directory, and returns its path.
+func (e *Executor) writeFailedOutput(taskName string, out string) (string, error) {
+	dir := e.options.StateDir
+	if dir == "" {
+		var err error
+		if dir, err = StateDir(); err != nil {
+			return "", err
+		}
+	}
+
+	logFile := filepath.Join(dir, fmt.Sprintf("output%s-%s.log", pathFileName(taskName), time.Now().Format("20060102-150405")))
+
+	return logFile, os.WriteFile(logFile, []byte(out+"\n"), 0644)
+}
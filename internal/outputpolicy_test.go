@@ -0,0 +1,189 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Returns what the function printed to the terminal, ie. to stdout and
+// stderr, including the spinner. Tests using it can't run in parallel.
+func captureTerminal(t *testing.T, fn func()) string {
+	f, err := os.Create(filepath.Join(t.TempDir(), "terminal"))
+	require.Nil(t, err)
+	defer f.Close()
+
+	origStdout, origStderr := os.Stdout, os.Stderr
+	os.Stdout, os.Stderr = f, f
+	defer func() { os.Stdout, os.Stderr = origStdout, origStderr }()
+
+	fn()
+
+	out, err := os.ReadFile(f.Name())
+	require.Nil(t, err)
+
+	return string(out)
+}
+
+// An env where ci references check with the given output, which references
+// lint. Lint prints the given lines, and fails when told to.
+func outputPolicyEnv(output string, lines int, fail bool) *InMemoryEnv {
+	env := NewInMemoryEnv(fmt.Sprintf(`
+ci:
+  run:
+    - cmd: check
+      output: %s
+    - "echo done"
+
+check:
+  run:
+    - lint
+
+lint:
+  run:
+    - "golint ./..."
+`, output))
+	env.Options.Quiet = false
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if cmd.Args[0] != "golint" {
+			return nil
+		}
+
+		for i := 1; i <= lines; i++ {
+			fmt.Fprintf(cmd.Stdout, "lint line %d\n", i)
+		}
+
+		if fail {
+			return errors.New("lint failed")
+		}
+
+		return nil
+	}
+
+	return env
+}
+
+func TestOutputPolicies(t *testing.T) {
+	tests := []struct {
+		output string
+		fail   bool
+		shown  bool
+	}{
+		{OutputInherit, false, true},
+		{OutputInherit, true, true},
+		{OutputOnFailure, false, false},
+		{OutputOnFailure, true, true},
+		{OutputAlways, false, true},
+		{OutputAlways, true, true},
+		{OutputNever, false, false},
+		{OutputNever, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s failing=%t", tt.output, tt.fail), func(t *testing.T) {
+			env := outputPolicyEnv(tt.output, 2, tt.fail)
+
+			var err error
+			out := captureTerminal(t, func() {
+				e := newCaptureExecutor(t, env)
+				err = e.Start([]string{"ci"})
+			})
+
+			if tt.fail {
+				require.ErrorContains(t, err, "lint failed")
+			} else {
+				require.Nil(t, err)
+			}
+
+			if tt.shown {
+				require.Contains(t, out, "lint line 1\n")
+				require.Contains(t, out, "lint line 2\n")
+			} else {
+				require.NotContains(t, out, "lint line")
+			}
+		})
+	}
+}
+
+func TestOutputAlwaysShowsWithinHiddenOutput(t *testing.T) {
+	env := NewInMemoryEnv(`
+ci:
+  run:
+    - cmd: check
+      output: never
+
+check:
+  run:
+    - "go vet ./..."
+    - cmd: lint
+      output: always
+
+lint:
+  run:
+    - "golint ./..."
+`)
+	env.Options.Quiet = false
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		fmt.Fprintf(cmd.Stdout, "%s says hi\n", cmd.Args[0])
+		return nil
+	}
+
+	out := captureTerminal(t, func() {
+		e := newCaptureExecutor(t, env)
+		require.Nil(t, e.Start([]string{"ci"}))
+	})
+	require.Contains(t, out, "golint says hi\n")
+	require.NotContains(t, out, "go says hi")
+}
+
+func TestOutputOnFailureIsTruncated(t *testing.T) {
+	env := outputPolicyEnv(OutputOnFailure, failedOutputLines+10, true)
+	env.Options.StateDir = t.TempDir()
+
+	out := captureTerminal(t, func() {
+		e := newCaptureExecutor(t, env)
+		require.NotNil(t, e.Start([]string{"ci"}))
+	})
+	require.NotContains(t, out, "lint line 10\n")
+	require.Contains(t, out, fmt.Sprintf("lint line 11\n%s\n", "lint line 12"))
+	require.Contains(t, out, fmt.Sprintf("lint line %d\n", failedOutputLines+10))
+
+	logFiles, _ := filepath.Glob(filepath.Join(env.Options.StateDir, "output-check-*.log"))
+	require.Len(t, logFiles, 1)
+	require.Contains(t, out, fmt.Sprintf("... 10 earlier line(s) of task 'check', all of them are in %s\n", logFiles[0]))
+
+	contents, err := os.ReadFile(logFiles[0])
+	require.Nil(t, err)
+	require.Equal(t, failedOutputLines+10, strings.Count(string(contents), "\n"))
+	require.True(t, strings.HasPrefix(string(contents), "lint line 1\n"))
+}
+
+func TestOutputIsCapturedWhateverThePolicy(t *testing.T) {
+	env := outputPolicyEnv(OutputNever, 2, false)
+	env.Options.CaptureDir = t.TempDir()
+
+	out := captureTerminal(t, func() {
+		e := newCaptureExecutor(t, env)
+		require.Nil(t, e.Start([]string{"ci"}))
+	})
+	require.NotContains(t, out, "lint line")
+	require.Equal(t, "lint line 1\nlint line 2\n", readOutputFile(t, filepath.Join(env.Options.CaptureDir, "001-lint-golint.stdout.txt")))
+}
+
+func TestOutputValidation(t *testing.T) {
+	var entry RunEntry
+	err := decodeConfig("", "cmd: lint\noutput: quiet\n", &entry)
+	require.EqualError(t, err, "line 1: unknown output 'quiet', expected inherit, on-failure, always or never")
+
+	err = decodeConfig("", "goke: ./api\noutput: never\n", &entry)
+	require.EqualError(t, err, "line 1: \"output\" only applies to \"cmd\" entries")
+
+	_, err = NewInMemoryEnv("ci:\n  run:\n    - cmd: golint\n      output: never\n").Parse()
+	require.EqualError(t, err, "task 'ci': output only applies to referenced tasks, 'golint' isn't a task")
+}
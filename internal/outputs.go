@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("task.outputs")
+}
+
+// A file written by one task, through its outputs, and read by another one,
+// through its files. Tasks reading their own outputs, like formatters, aren't
+// connected to themselves.
+type OutputEdge struct {
+	From string
+	To   string
+	File string
+}
+
+func (e OutputEdge) String() string {
+	return fmt.Sprintf("%s -[%s]-> %s", e.From, e.File, e.To)
+}
+
+// Two tasks declaring outputs which overlap, ie. both writing build/cli.
+type OutputOverlap struct {
+	Tasks [2]string
+	File  string
+}
+
+func (o OutputOverlap) String() string {
+	return fmt.Sprintf("'%s' and '%s' both write %s", o.Tasks[0], o.Tasks[1], o.File)
+}
+
+// How the tasks are connected through the files they write and read, see
+// analyzeOutputs.
+type OutputAnalysis struct {
+	Edges    []OutputEdge
+	Overlaps []OutputOverlap
+
+	// Chains of edges leading back to the task they start from. Tasks in a
+	// cycle trigger each other, ie. over and over again in watch mode.
+	Cycles [][]OutputEdge
+}
+
+// Returned when the outputs of the tasks form a cycle, or overlap with --strict.
+type OutputsError struct {
+	Analysis OutputAnalysis
+}
+
+func (e *OutputsError) Error() string {
+	if len(e.Analysis.Cycles) > 0 {
+		return fmt.Sprintf("outputs cycle detected: %s", formatOutputChain(e.Analysis.Cycles[0]))
+	}
+
+	return fmt.Sprintf("overlapping outputs: %s", e.Analysis.Overlaps[0])
+}
+
+// Builds the graph of the tasks connected through their outputs and files,
+// in alphabetical order.
+func analyzeOutputs(tasks taskList) OutputAnalysis {
+	analysis := OutputAnalysis{}
+	names := sortedKeys(tasks)
+
+	for i, from := range names {
+		for _, to := range names[i+1:] {
+			if file, ok := overlappingOutput(tasks[from].Outputs, tasks[to].Outputs); ok {
+				analysis.Overlaps = append(analysis.Overlaps, OutputOverlap{Tasks: [2]string{from, to}, File: file})
+			}
+		}
+	}
+
+	edges := make(map[string][]OutputEdge)
+	for _, from := range names {
+		for _, to := range names {
+			if from == to {
+				continue
+			}
+
+			for _, output := range tasks[from].Outputs {
+				if readsOutput(tasks[to].FilePatterns, output) {
+					edge := OutputEdge{From: from, To: to, File: output}
+					edges[from] = append(edges[from], edge)
+					analysis.Edges = append(analysis.Edges, edge)
+					break
+				}
+			}
+		}
+	}
+
+	// Every cycle is found through the edge closing it, which is followed
+	// once since each task is only visited once.
+	visited := make(map[string]bool)
+
+	var visit func(name string, path []OutputEdge)
+	visit = func(name string, path []OutputEdge) {
+		visited[name] = true
+
+		for _, edge := range edges[name] {
+			chain := append(append([]OutputEdge{}, path...), edge)
+
+			for i, e := range chain {
+				if e.From == edge.To {
+					analysis.Cycles = append(analysis.Cycles, chain[i:])
+					break
+				}
+			}
+
+			if !visited[edge.To] {
+				visit(edge.To, chain)
+			}
+		}
+	}
+
+	for _, name := range names {
+		if !visited[name] {
+			visit(name, []OutputEdge{})
+		}
+	}
+
+	return analysis
+}
+
+// Returns the first output of a which overlaps with one of b.
+func overlappingOutput(a []string, b []string) (string, bool) {
+	for _, x := range a {
+		for _, y := range b {
+			if patternsOverlap(x, y) {
+				return x, true
+			}
+		}
+	}
+
+	return "", false
+}
+
+// Whether a file may match both patterns, ie. "build/*" and "build/cli".
+func patternsOverlap(a string, b string) bool {
+	return globMatch(a, b) || globMatch(b, a)
+}
+
+// Whether the output may be one of the files of a task with the patterns.
+func readsOutput(patterns []string, output string) bool {
+	included := false
+
+	for _, pattern := range patterns {
+		if isExclusion(pattern) {
+			if globMatch(strings.TrimPrefix(pattern, "!"), output) {
+				return false
+			}
+		} else if patternsOverlap(pattern, output) {
+			included = true
+		}
+	}
+
+	return included
+}
+
+// Formats the chain of edges, ie. "gen -[gen.go]-> build -[build/cli]-> gen".
+func formatOutputChain(chain []OutputEdge) string {
+	parts := []string{chain[0].From}
+	for _, edge := range chain {
+		parts = append(parts, fmt.Sprintf("-[%s]-> %s", edge.File, edge.To))
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// The overlapping outputs, as warnings of the parser.
+func (a OutputAnalysis) warnings() []string {
+	warnings := []string{}
+	for _, overlap := range a.Overlaps {
+		warnings = append(warnings, fmt.Sprintf("tasks %s, which makes their runs depend on each other's order", overlap))
+	}
+
+	sort.Strings(warnings)
+	return warnings
+}
+
+// Fails on cycles, and on overlapping outputs with --strict.
+func (a OutputAnalysis) err(strict bool) error {
+	if len(a.Cycles) > 0 || (strict && len(a.Overlaps) > 0) {
+		return &OutputsError{Analysis: a}
+	}
+
+	return nil
+}
+
+// Writes the files shared between tasks, along with the overlapping outputs
+// and the cycles, for "goke doctor".
+func (a OutputAnalysis) Write(w io.Writer) {
+	if len(a.Edges) == 0 && len(a.Overlaps) == 0 {
+		fmt.Fprintln(w, "No files are shared between tasks")
+		return
+	}
+
+	if len(a.Edges) > 0 {
+		fmt.Fprintln(w, "Files shared between tasks:")
+		for _, edge := range a.Edges {
+			fmt.Fprintf(w, "  %s\n", edge)
+		}
+	}
+
+	if len(a.Overlaps) > 0 {
+		fmt.Fprintln(w, "Overlapping outputs:")
+		for _, overlap := range a.Overlaps {
+			fmt.Fprintf(w, "  %s\n", overlap)
+		}
+	}
+
+	if len(a.Cycles) > 0 {
+		fmt.Fprintln(w, "Outputs cycles:")
+		for _, cycle := range a.Cycles {
+			fmt.Fprintf(w, "  %s\n", formatOutputChain(cycle))
+		}
+	}
+}
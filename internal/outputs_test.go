@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestOutputsOfACleanConfig(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+gen:
+  files: [api.proto]
+  outputs: [api.pb.go]
+  run:
+    - "protoc api.proto"
+
+build:
+  files: ["*.go"]
+  outputs: [build/cli]
+  run:
+    - "go build -o build/cli"
+
+test:
+  files: ["*.go", build/cli]
+  run:
+    - "go test ./..."
+
+lint:
+  files: ["*.go"]
+  run:
+    - "go vet ./..."
+`)
+
+	for _, f := range []string{"api.proto", "main.go", "build/cli"} {
+		require.Nil(t, env.FS.WriteFile("/work/"+f, []byte(f), 0644))
+	}
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Empty(t, p.Outputs.Overlaps)
+	require.Empty(t, p.Outputs.Cycles)
+	require.Empty(t, p.Warnings)
+
+	require.Equal(t, []OutputEdge{
+		{From: "build", To: "test", File: "build/cli"},
+		{From: "gen", To: "build", File: "api.pb.go"},
+		{From: "gen", To: "lint", File: "api.pb.go"},
+		{From: "gen", To: "test", File: "api.pb.go"},
+	}, p.Outputs.Edges)
+}
+
+const overlappingOutputsConfig = `
+build:
+  outputs: [build/cli]
+  run:
+    - "go build -o build/cli"
+
+release:
+  dir: build
+  outputs: ["*"]
+  run:
+    - "goreleaser"
+`
+
+func TestOverlappingOutputsWarn(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(overlappingOutputsConfig)
+	require.Nil(t, env.FS.WriteFile("/work/build/cli", []byte("cli"), 0755))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, []OutputOverlap{{Tasks: [2]string{"build", "release"}, File: "build/cli"}}, p.Outputs.Overlaps)
+	require.Equal(t, []string{"tasks 'build' and 'release' both write build/cli, which makes their runs depend on each other's order"}, p.Warnings)
+}
+
+func TestOverlappingOutputsFailWithStrict(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(overlappingOutputsConfig)
+	env.Options.Strict = true
+	require.Nil(t, env.FS.WriteFile("/work/build/cli", []byte("cli"), 0755))
+
+	_, err := env.Parse()
+	require.EqualError(t, err, "overlapping outputs: 'build' and 'release' both write build/cli")
+}
+
+func TestOutputsCycleFails(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+gen:
+  files: [build/cli]
+  outputs: [gen.go]
+  run:
+    - "./build/cli gen"
+
+build:
+  files: ["*.go", "!*_test.go"]
+  outputs: [build/cli]
+  run:
+    - "go build -o build/cli"
+
+docs:
+  files: [gen.go]
+  outputs: [docs.md]
+  run:
+    - "go doc > docs.md"
+`)
+
+	_, err := env.Parse()
+	require.EqualError(t, err, "outputs cycle detected: build -[build/cli]-> gen -[gen.go]-> build")
+
+	var outputsErr *OutputsError
+	require.True(t, errors.As(err, &outputsErr))
+
+	out := bytes.Buffer{}
+	outputsErr.Analysis.Write(&out)
+	require.Equal(t, `Files shared between tasks:
+  build -[build/cli]-> gen
+  gen -[gen.go]-> build
+  gen -[gen.go]-> docs
+Outputs cycles:
+  build -[build/cli]-> gen -[gen.go]-> build
+`, out.String())
+}
+
+func TestExcludedFilesAreNotConnected(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+gen:
+  files: ["*.go"]
+  outputs: [gen_test.go]
+  run:
+    - "go generate"
+
+test:
+  files: ["*.go", "!gen_test.go"]
+  outputs: [coverage.out]
+  run:
+    - "go test -coverprofile coverage.out"
+`)
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Empty(t, p.Outputs.Edges)
+
+	out := bytes.Buffer{}
+	p.Outputs.Write(&out)
+	require.Equal(t, "No files are shared between tasks\n", out.String())
+}
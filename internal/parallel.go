@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterCapability("task.parallel")
+}
+
+// Runs the commands of a parallel task concurrently, at most max_concurrency
+// (NumCPU by default) at a time. The output of each command is buffered and
+// printed at once when it finishes, each line prefixed with the command, so
+// that the output of different commands never gets interleaved. The first
+// failure stops the commands still running and the remaining ones never start.
+func (e *Executor) runParallel(task Task, env map[string]string, ignored *[]error) error {
+	jobs := []RunEntry{}
+	for _, entry := range task.Run {
+		batches, err := e.commandBatches(task, entry)
+		if err != nil {
+			return err
+		}
+
+		jobs = append(jobs, batches...)
+	}
+
+	if e.options.DryRun {
+		for _, job := range jobs {
+			if err := e.printDryRunCommand(job, env); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	limit := task.MaxConcurrency
+	if limit <= 0 {
+		limit = runtime.NumCPU()
+	}
+
+	e.report.commandsRan(len(jobs))
+
+	if e.progress != nil {
+		e.progress.grow(len(jobs) - len(task.Run))
+		e.progress.skip(len(jobs) - 1)
+		e.reportStep(fmt.Sprintf("%d commands in parallel", len(jobs)))
+	} else if !e.options.Quiet {
+		e.spinnerMessage(fmt.Sprintf("Running %d commands in parallel", len(jobs)))
+	}
+
+	// Each command runs in its own process group, so that the first failure
+	// stops their children too. In watch mode, a restarting task already
+	// keeps track of its processes.
+	group := e.processes
+	if group == nil {
+		group = newProcesses()
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		failure error
+		slots   = make(chan struct{}, limit)
+		flush   = e.parallelPrinter()
+	)
+
+	fail := func(err error) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		// Commands stopped after the first failure don't count as failures of their own.
+		if ctx.Err() != nil {
+			return false
+		}
+
+		failure = err
+		cancel()
+		return true
+	}
+
+	for _, job := range jobs {
+		slots <- struct{}{}
+		if ctx.Err() != nil {
+			break
+		}
+
+		wg.Add(1)
+		go func(job RunEntry) {
+			defer e.RecoverPanic()
+			defer func() { <-slots }()
+			defer wg.Done()
+
+			var out string
+			err := e.withRetries(job, func() (err error) {
+				out, err = e.runBuffered(group, job, env)
+				return err
+			})
+			flush(job, out)
+
+			switch {
+			case err == nil:
+			case job.IgnoreError || task.ContinueOnError:
+				mu.Lock()
+				*ignored = append(*ignored, err)
+				mu.Unlock()
+			case fail(err):
+				group.stop(restartGracePeriod)
+			}
+		}(job)
+	}
+
+	wg.Wait()
+	return failure
+}
+
+// Runs the command of the entry, with its stdout and stderr captured together.
+// With --capture-dir, they are also written to separate files.
+func (e *Executor) runBuffered(group *processes, entry RunEntry, env map[string]string) (out string, err error) {
+	p, err := e.prepareCommand(entry, env)
+	if err != nil {
+		return "", err
+	}
+
+	captured := e.capture.begin(entry.task, p.line)
+	defer func() { captured.finish(err) }()
+
+	buf := &lockedBuffer{}
+
+	if p.builtin != nil {
+		err := p.builtin(p.args, entry.Dir, captured.teeStdout(buf))
+		return buf.String(), newCommandError(p.line, err, nil)
+	}
+
+	if captured == nil {
+		p.cmd.Stdout = buf
+		p.cmd.Stderr = buf
+	} else {
+		p.cmd.Stdout = captured.teeStdout(buf)
+		p.cmd.Stderr = captured.teeStderr(buf)
+	}
+
+	err = e.runInGroup(group, p.cmd, entry.timeout)
+
+	return string(decodeOutput(buf.Bytes(), p.enc)), newCommandError(p.line, err, p.enc)
+}
+
+// A buffer which stdout and stderr can be written to concurrently.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Bytes()
+}
+
+func (b *lockedBuffer) String() string {
+	return string(b.Bytes())
+}
+
+// Returns a function printing the output of a finished command, one at a time.
+// Nothing gets printed when running quietly.
+func (e *Executor) parallelPrinter() func(entry RunEntry, out string) {
+	if e.options.Quiet {
+		return func(RunEntry, string) {}
+	}
+
+	mu := sync.Mutex{}
+	stdout, _ := e.outputWriters(nil)
+
+	return func(entry RunEntry, out string) {
+		out = strings.TrimRight(out, "\n")
+		if out == "" {
+			return
+		}
+
+		if entry.DiffOutput {
+			out = colorizeDiff(out)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		_, _ = stdout.Write([]byte(prefixLines(out, fmt.Sprintf("[%s] ", entry.label()))))
+	}
+}
+
+// Prefixes every line of the output, which ends with a newline afterwards.
+func prefixLines(out string, prefix string) string {
+	lines := strings.Split(out, "\n")
+	for i := range lines {
+		lines[i] = prefix + lines[i]
+	}
+
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func dispatchParallelTask(t *testing.T, config string) error {
+	chdir(t, t.TempDir())
+
+	parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseTasks())
+
+	e := Executor{parser: parser, options: Options{Quiet: true}}
+	return e.dispatchTask(parser.Tasks["build"], true)
+}
+
+func TestParallelTaskRunsCommandsConcurrently(t *testing.T) {
+	// Each command expects the other one to have started, so running them
+	// one by one fails.
+	config := `
+build:
+  parallel: true
+  max_concurrency: 2
+  run:
+    - "sh -c 'touch a; sleep 0.3; test -f b'"
+    - "sh -c 'touch b; sleep 0.3; test -f a'"
+`
+
+	require.Nil(t, dispatchParallelTask(t, config))
+}
+
+func TestParallelTaskRespectsMaxConcurrency(t *testing.T) {
+	// Creating the lock fails when another command holds it.
+	config := `
+build:
+  parallel: true
+  max_concurrency: 1
+  run:
+    - "sh -c 'mkdir lock && sleep 0.05 && rmdir lock'"
+    - "sh -c 'mkdir lock && sleep 0.05 && rmdir lock'"
+    - "sh -c 'mkdir lock && sleep 0.05 && rmdir lock'"
+`
+
+	require.Nil(t, dispatchParallelTask(t, config))
+}
+
+func TestParallelTaskCancelsOnFirstFailure(t *testing.T) {
+	config := `
+build:
+  parallel: true
+  max_concurrency: 2
+  run:
+    - "sh -c 'sleep 0.05; exit 3'"
+    - "sh -c 'sleep 5; touch late'"
+    - "sh -c 'touch never'"
+    - "-false"
+`
+
+	start := time.Now()
+	err := dispatchParallelTask(t, config)
+
+	require.Equal(t, 3, ExitCode(err))
+	require.Less(t, time.Since(start), 2*time.Second)
+	require.NoFileExists(t, "late")
+	require.NoFileExists(t, "never")
+}
+
+func TestParallelTaskIgnoresFailures(t *testing.T) {
+	config := `
+build:
+  parallel: true
+  continue_on_error: true
+  run:
+    - "false"
+    - "sh -c 'sleep 0.05; touch done'"
+`
+
+	require.Nil(t, dispatchParallelTask(t, config))
+	require.FileExists(t, "done")
+}
+
+func TestParseTasksValidatesParallelTasks(t *testing.T) {
+	tests := map[string]string{
+		"build:\n  parallel: true\n  run:\n    - export: {A: b}\n":                      "task 'build': parallel tasks can't export variables, use env instead",
+		"build:\n  parallel: true\n  run:\n    - lint\nlint:\n  run:\n    - \"true\"\n": "task 'build': parallel tasks can't run task 'lint', list it under deps instead",
+		"build:\n  max_concurrency: -1\n  run:\n    - \"true\"\n":                       "task 'build': max_concurrency must be positive",
+	}
+
+	for config, expected := range tests {
+		parser := NewParser(config, &clearCacheOpts, &LocalFileSystem{})
+		require.EqualError(t, parser.parseTasks(), expected)
+	}
+}
+
+func TestPrefixLines(t *testing.T) {
+	require.Equal(t, "[go vet] a\n[go vet] b\n", prefixLines("a\nb", "[go vet] "))
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("task.params")
+}
+
+// Placeholders like {env} in the commands of tasks with params. Variables
+// like ${env} are matched too, so that they can be told apart, but they
+// aren't placeholders, see isVariable.
+var paramRegexp = regexp.MustCompile(`\$?\{([A-Za-z_][A-Za-z0-9_-]*)\}`)
+
+// Reports whether the match of paramRegexp is a variable, ie. ${env}.
+func isVariable(match string) bool {
+	return strings.HasPrefix(match, "$")
+}
+
+// Fails when a command of the task has a placeholder which is neither one
+// of its params, {FILES}, {FILES_SORTED}, {STAGED_FILES},
+// {CHANGED_FILES_FILE}, {ARGS} nor one of the placeholders of the config.
+// Only tasks with params are checked, since braces are common in commands,
+// ie. awk '{print}'.
+func validateParams(name string, task Task, placeholders map[string]string) error {
+	if len(task.Params) == 0 {
+		return nil
+	}
+
+	for _, entry := range task.Run {
+		for _, m := range paramRegexp.FindAllStringSubmatch(entry.Cmd, -1) {
+			if isVariable(m[0]) {
+				continue
+			}
+
+			placeholder := "{" + m[1] + "}"
+			if _, ok := placeholders[m[1]]; ok {
+				continue
+			}
+
+			if _, ok := task.Params[m[1]]; ok || placeholder == FilesPlaceholder || placeholder == FilesSortedPlaceholder ||
+				placeholder == StagedFilesPlaceholder || placeholder == ChangedFilesFilePlaceholder || placeholder == ArgsPlaceholder {
+				continue
+			}
+
+			return fmt.Errorf(
+				"task '%s': unknown placeholder %s in \"%s\", it must be %s, %s, %s, %s, %s or one of the params: %s",
+				name, placeholder, entry.Cmd, FilesPlaceholder, FilesSortedPlaceholder, StagedFilesPlaceholder, ChangedFilesFilePlaceholder, ArgsPlaceholder, strings.Join(sortedKeys(task.Params), ", "),
+			)
+		}
+	}
+
+	return nil
+}
+
+// Replaces the {name} placeholders of the params in cmd, quoted like the
+// arguments of {ARGS}.
+func replaceParams(cmd string, params map[string]string) string {
+	if len(params) == 0 {
+		return cmd
+	}
+
+	return paramRegexp.ReplaceAllStringFunc(cmd, func(m string) string {
+		if isVariable(m) {
+			return m
+		}
+
+		value, ok := params[m[1:len(m)-1]]
+		if !ok {
+			return m
+		}
+
+		return quoteArg(value)
+	})
+}
+
+// The params of the task, their defaults overridden by the ones given on
+// the command line, ie. "goke deploy env=prod".
+func (e *Executor) taskParams(name string) map[string]string {
+	task, ok := e.parser.Tasks[name]
+	if !ok || len(task.Params) == 0 {
+		return nil
+	}
+
+	params := make(map[string]string, len(task.Params))
+	for k, v := range task.Params {
+		params[k] = v
+	}
+
+	for k, v := range e.options.Params[name] {
+		params[k] = v
+	}
+
+	return params
+}
+
+// Fails when params were given on the command line for a task which isn't
+// run, or which doesn't declare them.
+func (e *Executor) checkParams(taskNames []string) error {
+	if len(e.options.Params) == 0 {
+		return nil
+	}
+
+	if e.options.Batch != "" {
+		return errors.New("--batch does not accept params, give them to the steps of the plan instead")
+	}
+
+	names := sortedKeys(e.options.Params)
+	for _, name := range names {
+		run := false
+		for _, taskName := range taskNames {
+			run = run || taskName == name
+		}
+
+		task, ok := e.parser.Tasks[name]
+		if !ok || !run {
+			return fmt.Errorf("params were given for task '%s', which isn't run", name)
+		}
+
+		if err := checkGivenParams(name, task, e.options.Params[name]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Fails when one of the given params isn't declared by the task.
+func checkGivenParams(name string, task Task, given map[string]string) error {
+	for _, param := range sortedKeys(given) {
+		if _, ok := task.Params[param]; ok {
+			continue
+		}
+
+		if len(task.Params) == 0 {
+			return fmt.Errorf("task '%s' has no params, but was given %s", name, param)
+		}
+
+		return fmt.Errorf("task '%s' has no param %s, it has: %s", name, param, strings.Join(sortedKeys(task.Params), ", "))
+	}
+
+	return nil
+}
+
+// Splits the "name=value" params out of the task names given on the command
+// line. Params belong to the task before them, or to the main task when
+// they come first.
+func SplitParams(args []string) ([]string, map[string]map[string]string) {
+	tasks := []string{}
+	var params map[string]map[string]string
+
+	for _, arg := range args {
+		name, value, ok := strings.Cut(arg, "=")
+		if !ok || name == "" {
+			tasks = append(tasks, arg)
+			continue
+		}
+
+		task := DefaultTask
+		if len(tasks) > 0 {
+			task = tasks[len(tasks)-1]
+		}
+
+		if params == nil {
+			params = make(map[string]map[string]string)
+		}
+		if params[task] == nil {
+			params[task] = make(map[string]string)
+		}
+		params[task][name] = value
+	}
+
+	return tasks, params
+}
@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const paramsConfig = `
+deploy:
+  params:
+    env: staging
+    region: us-east-1
+  run:
+    - "./deploy.sh --env {env} --region {region} --user ${USER}"
+    - "echo {FILES} {ARGS}"
+`
+
+func TestParamsUseTheirDefaults(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(paramsConfig)
+	env.Options.Force = true
+	require.Nil(t, env.Run("deploy"))
+
+	require.Contains(t, recordedCommands(env)[0], "./deploy.sh --env staging --region us-east-1 --user")
+}
+
+func TestParamsCanBeOverridden(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(paramsConfig)
+	env.Options.Force = true
+	env.Options.Params = map[string]map[string]string{"deploy": {"env": "prod eu"}}
+	require.Nil(t, env.Run("deploy"))
+
+	require.Contains(t, recordedCommands(env)[0], "./deploy.sh --env prod eu --region us-east-1")
+}
+
+func TestAdjacentParamsAreReplaced(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+tag:
+  params:
+    name: app
+    version: "1.0"
+  run:
+    - "docker tag {name}{version} ${HOME}{name}"
+    - "echo {name}{zone}"
+`)
+	_, err := env.Parse()
+	require.EqualError(t, err, `task 'tag': unknown placeholder {zone} in "echo {name}{zone}", it must be {FILES}, {FILES_SORTED}, {STAGED_FILES}, {CHANGED_FILES_FILE}, {ARGS} or one of the params: name, version`)
+
+	require.Equal(t, "docker tag app1.0 ${HOME}app", replaceParams("docker tag {name}{version} ${HOME}{name}", map[string]string{"name": "app", "version": "1.0"}))
+}
+
+func TestParamsAreNeverSubstituted(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+deploy:
+  params:
+    env: staging
+  run:
+    - "./deploy.sh --env {env} --rev $(git rev-parse HEAD)"
+`)
+	env.Options.Force = true
+	env.Options.Params = map[string]map[string]string{"deploy": {"env": "$(rm -rf ~)"}}
+	require.Nil(t, env.Run("deploy"))
+
+	require.Equal(t, []string{"git rev-parse HEAD", "./deploy.sh --env $(rm -rf ~) --rev"}, recordedCommands(env))
+}
+
+func TestUnknownParamsFail(t *testing.T) {
+	e := newTestExecutor(t, paramsConfig+`
+lint:
+  run:
+    - "go vet"
+`)
+
+	e.options.Params = map[string]map[string]string{"deploy": {"zone": "b"}}
+	require.EqualError(t, e.checkParams([]string{"deploy"}), "task 'deploy' has no param zone, it has: env, region")
+	require.EqualError(t, e.start([]string{"deploy"}), "task 'deploy' has no param zone, it has: env, region")
+
+	e.options.Params = map[string]map[string]string{"lint": {"zone": "b"}}
+	require.EqualError(t, e.checkParams([]string{"lint"}), "task 'lint' has no params, but was given zone")
+	require.EqualError(t, e.checkParams([]string{"deploy"}), "params were given for task 'lint', which isn't run")
+}
+
+func TestUnknownPlaceholdersFailParsing(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+deploy:
+  params:
+    env: staging
+  run:
+    - "./deploy.sh --env {env} --zone {zone}"
+`)
+	_, err := env.Parse()
+	require.EqualError(t, err, `task 'deploy': unknown placeholder {zone} in "./deploy.sh --env {env} --zone {zone}", it must be {FILES}, {FILES_SORTED}, {STAGED_FILES}, {CHANGED_FILES_FILE}, {ARGS} or one of the params: env`)
+
+	// Tasks without params may have braces in their commands.
+	env = NewInMemoryEnv(`
+fields:
+  run:
+    - "awk '{print}' go.mod"
+`)
+	_, err = env.Parse()
+	require.Nil(t, err)
+}
+
+func TestSplitParams(t *testing.T) {
+	tasks, params := SplitParams([]string{"env=prod", "build", "deploy", "env=prod", "region=eu=1"})
+
+	require.Equal(t, []string{"build", "deploy"}, tasks)
+	require.Equal(t, map[string]map[string]string{
+		"main":   {"env": "prod"},
+		"deploy": {"env": "prod", "region": "eu=1"},
+	}, params)
+
+	tasks, params = SplitParams([]string{"build"})
+	require.Equal(t, []string{"build"}, tasks)
+	require.Nil(t, params)
+}
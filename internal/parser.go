@@ -1,104 +1,438 @@
 package internal
 
 import (
-	"log"
+	"errors"
+	"fmt"
 	"os"
 	"os/exec"
-	"path"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/dugajean/goke/pkg/shellwords"
 	"gopkg.in/yaml.v3"
 )
 
+func init() {
+	RegisterCapability("task.desc", "run.export", "run.diff_output", "task.deps", "task.follow_symlinks", "task.dir", "task.inherit_files", "task.shell", "run.ignore_error", "task.continue_on_error", "task.tags", "config.local_overrides")
+}
+
 type (
 	Task struct {
 		Name  string
+		Desc  string            `yaml:"desc,omitempty"`
 		Files []string          `yaml:"files,omitempty"`
-		Run   []string          `yaml:"run"`
-		Env   map[string]string `yaml:"env,omitempty"`
+		Run   []RunEntry        `yaml:"run"`
+		Env   map[string]string `yaml:"-"`
+		Deps  []string          `yaml:"deps,omitempty"`
+
+		// Replace run on Windows, macOS and Linux, see platformRun.
+		RunWindows []RunEntry `yaml:"run_windows,omitempty"`
+		RunDarwin  []RunEntry `yaml:"run_darwin,omitempty"`
+		RunLinux   []RunEntry `yaml:"run_linux,omitempty"`
+
+		// The variables under "env" as written in the config, which are
+		// resolved into Env when parsing.
+		EnvValues map[string]EnvValue `yaml:"env,omitempty"`
+
+		// The patterns under "files", which are expanded again whenever the
+		// task is checked for changes, so that new files are noticed too.
+		FilePatterns []string `yaml:"-"`
+
+		// Symlinks under "files" are followed by default.
+		FollowSymlinks *bool `yaml:"follow_symlinks,omitempty"`
+
+		// Also gate the task on the files of the tasks it references.
+		InheritFiles bool `yaml:"inherit_files,omitempty"`
+
+		// The files the task writes, relative to dir like "files". Tasks
+		// reading them through their files are connected to it, see
+		// analyzeOutputs.
+		Outputs []string `yaml:"outputs,omitempty"`
+
+		// Used to select tasks, ie. with --tag or by global events.
+		Tags []string `yaml:"tags,omitempty"`
+
+		// Tasks of the same group never run at the same time with --jobs,
+		// see executeJobs.
+		Group string `yaml:"group,omitempty"`
+
+		// The line the task is declared on in the config, 0 for the tasks
+		// of local overrides and generated ones.
+		Line int `yaml:"-"`
+
+		// The generator of the task, empty unless it was generated, see
+		// mergeGeneratedTasks.
+		Origin string `yaml:"-"`
+
+		// The included file declaring the task, empty for the tasks of the
+		// config itself, see mergeIncludedTasks.
+		Include string `yaml:"-"`
+
+		// The namespace of the included file, which prefixes the name of
+		// the task, ie. "api" for "api:build".
+		Namespace string `yaml:"-"`
+
+		// Run the commands through the system shell, defaults to global.shell.
+		Shell *bool `yaml:"shell,omitempty"`
+
+		// Detect changes of the files by their SHA-256, defaults to global.checksum.
+		Checksum *bool `yaml:"checksum,omitempty"`
+
+		// Check that all binaries exist before running any command.
+		Preflight bool `yaml:"preflight,omitempty"`
+
+		// Directory the commands run in, relative to goke.yml.
+		// The "files" are relative to it too.
+		Dir string `yaml:"dir,omitempty"`
+
+		// Encoding of the commands' output, when it isn't UTF-8.
+		OutputEncoding string `yaml:"output_encoding,omitempty"`
+
+		// In watch mode, stop the running commands on changes instead of
+		// waiting for them to exit, ie. for dev servers. Referenced by other
+		// tasks, they run as services, see services.
+		Restart bool `yaml:"restart,omitempty"`
+
+		// The commands read goke's stdin, instead of the null device, and
+		// aren't reported when silent, see watchStall.
+		Interactive bool `yaml:"interactive,omitempty"`
+
+		// Run the commands concurrently, at most MaxConcurrency at a time.
+		Parallel       bool `yaml:"parallel,omitempty"`
+		MaxConcurrency int  `yaml:"max_concurrency,omitempty"`
+
+		// Keep running the task's commands when one of them fails.
+		ContinueOnError bool `yaml:"continue_on_error,omitempty"`
+
+		// In watch mode, also run the task on this interval, ie. "10m".
+		Every time.Duration `yaml:"every,omitempty"`
+
+		// Only run the task when the condition holds, see parseCondition.
+		When string `yaml:"when,omitempty"`
+
+		// Only run the task when the If command succeeds, the Unless command
+		// fails and goke runs on one of OnlyOS, see conditionHolds.
+		If     string   `yaml:"if,omitempty"`
+		Unless string   `yaml:"unless,omitempty"`
+		OnlyOS []string `yaml:"only_os,omitempty"`
+
+		// Override the top-level vars for the task, see expandVars.
+		Vars map[string]string `yaml:"vars,omitempty"`
+
+		// Defaults of the {name} placeholders in the commands, which can be
+		// overridden on the command line, ie. "goke deploy env=prod".
+		Params map[string]string `yaml:"params,omitempty"`
+
+		// Stop each command running longer, defaults to global.timeout.
+		// Zero means no timeout.
+		Timeout *time.Duration `yaml:"timeout,omitempty"`
+
+		// Run failed commands again, up to Retries more times, waiting
+		// RetryDelay in between, doubled after each attempt with RetryBackoff.
+		Retries      int           `yaml:"retries,omitempty"`
+		RetryDelay   time.Duration `yaml:"retry_delay,omitempty"`
+		RetryBackoff bool          `yaml:"retry_backoff,omitempty"`
+
+		// Run after the commands of the task, depending on whether they
+		// succeeded, see runOutcomeHooks.
+		OnSuccess []string `yaml:"on_success,omitempty"`
+		OnFailure []string `yaml:"on_failure,omitempty"`
+
+		// The events of the task, replacing the global ones, or running
+		// after them with InheritEvents. Once parsed, they hold all the events
+		// of a task with OwnEvents, see resolveEvents.
+		Events        Events `yaml:"events,omitempty"`
+		InheritEvents bool   `yaml:"inherit_events,omitempty"`
+		OwnEvents     bool   `yaml:"-"`
+
+		// The task whose keys the task inherits, unless it declares them,
+		// see resolveExtends. With AppendRun, its run comes after the one
+		// of the extended task instead of replacing it.
+		Extends   string `yaml:"extends,omitempty"`
+		AppendRun bool   `yaml:"append_run,omitempty"`
+
+		// Only extended by other tasks, it isn't listed and doesn't run on
+		// its own.
+		Abstract bool `yaml:"abstract,omitempty"`
+
+		// The keys declared in the config, see inherit.
+		keys map[string]bool
+	}
+
+	// A single entry under "run", which is either a command (or task name),
+	// or an "export" of variables for all subsequent entries of the task.
+	RunEntry struct {
+		Cmd        string            `yaml:"cmd,omitempty"`
+		Dir        string            `yaml:"dir,omitempty"`
+		DiffOutput bool              `yaml:"diff_output,omitempty"`
+		Export     map[string]string `yaml:"export,omitempty"`
+
+		// Runs Task of the goke.yml in the Goke directory, see runSubproject.
+		Goke string `yaml:"goke,omitempty"`
+		Task string `yaml:"task,omitempty"`
+
+		// Shown instead of the command while it runs, ie. "build api".
+		Name string `yaml:"name,omitempty"`
+
+		// Variables of this command only, on top of the ones of the task,
+		// see commandVars.
+		Env map[string]string `yaml:"env,omitempty"`
+
+		// Set with ignore_error, or by prefixing the command with "-", like
+		// in make.
+		IgnoreError bool `yaml:"ignore_error,omitempty"`
+
+		// The file the stdout of the command must match, rewritten when the
+		// run includes UpdateFlag, see checkGolden.
+		Golden     string   `yaml:"golden,omitempty"`
+		UpdateFlag string   `yaml:"update_flag,omitempty"`
+		Normalize  []string `yaml:"normalize,omitempty"`
+
+		// How the output of the referenced task shows, see dispatchReferenced.
+		Output string `yaml:"output,omitempty"`
+
+		// Connects the command to the null device instead of the output
+		// pipeline, and unless RerunOnFailureForOutput is false reruns it
+		// with its output when it fails, see runDiscarded.
+		DiscardOutput           bool  `yaml:"discard_output,omitempty"`
+		RerunOnFailureForOutput *bool `yaml:"rerun_on_failure_for_output,omitempty"`
+
+		outputEncoding string
+		shell          bool
+		windowsShell   string
+		timeout        time.Duration
+		retries        int
+		retryDelay     time.Duration
+		retryBackoff   bool
+		interactive    bool
+
+		// The task the command belongs to, if any.
+		task string
+
+		// Variables only set in the environment of the command, which its
+		// command line isn't expanded with, see runTaskHooks.
+		processEnv map[string]string
+	}
+
+	// A command of a global event. With only_tags or except_tags,
+	// it only applies to the tasks with, or without, one of the tags.
+	EventEntry struct {
+		Cmd        string   `yaml:"cmd,omitempty"`
+		OnlyTags   []string `yaml:"only_tags,omitempty"`
+		ExceptTags []string `yaml:"except_tags,omitempty"`
+	}
+
+	// The commands which run around each task and each run of a task.
+	Events struct {
+		BeforeEachRun  []EventEntry `yaml:"before_each_run,omitempty"`
+		AfterEachRun   []EventEntry `yaml:"after_each_run,omitempty"`
+		BeforeEachTask []EventEntry `yaml:"before_each_task,omitempty"`
+		AfterEachTask  []EventEntry `yaml:"after_each_task,omitempty"`
+
+		// Replace the commands of the event on Windows, macOS and Linux,
+		// see forOS.
+		BeforeEachRunWindows  []EventEntry `yaml:"before_each_run_windows,omitempty"`
+		AfterEachRunWindows   []EventEntry `yaml:"after_each_run_windows,omitempty"`
+		BeforeEachTaskWindows []EventEntry `yaml:"before_each_task_windows,omitempty"`
+		AfterEachTaskWindows  []EventEntry `yaml:"after_each_task_windows,omitempty"`
+		BeforeEachRunDarwin   []EventEntry `yaml:"before_each_run_darwin,omitempty"`
+		AfterEachRunDarwin    []EventEntry `yaml:"after_each_run_darwin,omitempty"`
+		BeforeEachTaskDarwin  []EventEntry `yaml:"before_each_task_darwin,omitempty"`
+		AfterEachTaskDarwin   []EventEntry `yaml:"after_each_task_darwin,omitempty"`
+		BeforeEachRunLinux    []EventEntry `yaml:"before_each_run_linux,omitempty"`
+		AfterEachRunLinux     []EventEntry `yaml:"after_each_run_linux,omitempty"`
+		BeforeEachTaskLinux   []EventEntry `yaml:"before_each_task_linux,omitempty"`
+		AfterEachTaskLinux    []EventEntry `yaml:"after_each_task_linux,omitempty"`
 	}
 
 	Global struct {
 		Shared struct {
-			Environment map[string]string `yaml:"environment,omitempty"`
-			Events      struct {
-				BeforeEachRun  []string `yaml:"before_each_run,omitempty"`
-				AfterEachRun   []string `yaml:"after_each_run,omitempty"`
-				BeforeEachTask []string `yaml:"before_each_task,omitempty"`
-				AfterEachTask  []string `yaml:"after_each_task,omitempty"`
-			} `yaml:"events,omitempty"`
+			Environment map[string]string `yaml:"-"`
+			Shell       bool              `yaml:"shell,omitempty"`
+			Checksum    bool              `yaml:"checksum,omitempty"`
+			Events      Events            `yaml:"events,omitempty"`
+
+			// The shell of shell: true on Windows, see shellCommand.
+			WindowsShell string `yaml:"windows_shell,omitempty"`
+
+			// Where the lockfile and the history are kept, see StateStore.
+			StateBackend string `yaml:"state_backend,omitempty"`
+
+			// The timeout of the commands of tasks without one, and of events.
+			Timeout time.Duration `yaml:"timeout,omitempty"`
+
+			// How long commands run without output before they are
+			// reported as waiting for input, see watchStall.
+			StallAfter time.Duration `yaml:"stall_after,omitempty"`
+
+			// The variables as written in the config, which are resolved
+			// into Environment when parsing.
+			EnvironmentValues map[string]EnvValue `yaml:"environment,omitempty"`
+
+			// Variables which hold paths, like {type: path}, see EnvValue.
+			EnvPathVars []string `yaml:"env_path_vars,omitempty"`
 		} `yaml:"global,omitempty"`
 	}
 
 	Parser struct {
-		Tasks     taskList
-		FilePaths []string
-		config    string
-		options   Options
-		fs        FileSystem
+		Tasks           taskList
+		FilePaths       []string
+		Warnings        []string
+		config          string
+		localConfig     string
+		localConfigPath string
+		options         Options
+		fs              FileSystem
 		Global
+
+		// Path of the config of a subproject, whose relative paths are
+		// resolved from its directory. Empty for the main config.
+		configPath string
+
+		// Loaded from the cache, so there is nothing left to parse.
+		cached bool
+
+		// Identifies the config in the cache, see cacheKey, along with the
+		// variables of goke's environment read while parsing it.
+		key      string
+		envReads []string
+
+		// The resolved variables of global.environment by source, see
+		// EnvResolver. Global.Shared.Environment holds the winning ones.
+		EnvLayers map[EnvSource]map[string]string
+
+		// How the tasks are connected through their outputs and files.
+		Outputs OutputAnalysis
+
+		// The tasks each git hook runs, see parseHooks.
+		Hooks map[string][]string
+
+		// The top-level placeholders, ie. DIST for {DIST}, see
+		// parsePlaceholders.
+		Placeholders map[string]string
+
+		// The warnings about the placeholders, until the tasks are parsed.
+		placeholderWarnings []string
+
+		// The deps which aren't tasks, with CollectErrors, which reports
+		// them along with the other problems, see Validate.
+		unknownDeps []string
+
+		// The top-level vars, which are only needed while parsing.
+		vars map[string]string
+
+		// The command of generate_tasks, and what it printed or how it
+		// failed, see runGenerator.
+		generator   string
+		generated   string
+		generateErr error
+
+		// The files included by the config, or why they couldn't be read,
+		// see readIncludes.
+		included   []includedConfig
+		includeErr error
+
+		// How far the clock of the filesystem of the config is from the
+		// local one, when it's too far to trust mtimes, see CheckClockSkew.
+		clockSkew time.Duration
 	}
 
 	taskList map[string]Task
 )
 
-var osCommandRegexp = regexp.MustCompile(`\$\((.+)\)`)
-var parserString string
+// Bumped whenever the serialized parser changes shape, so that caches of
+// other goke versions are discarded, see parserCache.
+const cacheVersion = "34"
 
 // NewParser creates a parser instance which can be either a blank one,
-// or one provided  from the cache, which gets deserialized.
+// or one provided from the cache, which gets deserialized. The cache is
+// skipped with --no-cache.
 func NewParser(cfg string, opts *Options, fs FileSystem) Parser {
 	p := Parser{}
 	p.fs = fs
 	p.config = cfg
 	p.options = *opts
+	p.runGenerator()
+	p.readIncludes()
+	p.key = p.cacheKey()
 
-	tempFile := path.Join(p.fs.TempDir(), p.getTempFileName())
+	if p.options.ClearCache || p.generateErr != nil || p.includeErr != nil {
+		return p
+	}
 
-	if p.shouldClearCache(tempFile) {
-		_ = p.fs.Remove(tempFile)
+	if cached, ok := p.readCache(); ok {
+		p = cached
+		p.cached = true
 	}
 
-	if !p.fs.FileExists(tempFile) {
-		return p
+	return p
+}
+
+// Parses the config of another directory than the working one, ie. of a
+// subproject. It's not cached, nor merged with local overrides, and its
+// global.environment only applies to its own commands. Relative paths are
+// resolved from the directory of configPath.
+func ParseProjectConfig(configPath string, cfg string, opts *Options, fs FileSystem) (*Parser, error) {
+	p := Parser{config: cfg, configPath: configPath, options: *opts, fs: fs}
+	p.runGenerator()
+	p.readIncludes()
+
+	if err := p.parseGlobal(); err != nil {
+		return nil, err
 	}
 
-	pBytes, err := p.fs.ReadFile(tempFile)
-	if err != nil && !opts.Quiet {
-		log.Fatal(err)
+	if err := p.parseTasks(); err != nil {
+		return nil, err
 	}
 
-	pStr := string(pBytes)
-	parserString = pStr
+	return &p, nil
+}
 
-	return GOBDeserialize(pStr, &p)
+// Sets the contents of the per-developer overrides file, see LocalGokeFiles.
+func (p *Parser) SetLocalConfig(path string, cfg string) {
+	p.localConfigPath = path
+	p.localConfig = cfg
 }
 
 // Bootstrap does the parsing process or skip if cached.
-func (p *Parser) Bootstrap() {
-	// Nothing too bootstrap if cached.
-	if parserString != "" {
-		return
+func (p *Parser) Bootstrap() error {
+	// Nothing too bootstrap if cached, though the cache may have been
+	// written without --strict.
+	if p.cached {
+		return p.Outputs.err(p.options.Strict)
 	}
 
-	err := p.parseGlobal()
-	if err != nil && !p.options.Quiet {
-		log.Fatal(err)
+	if p.localConfigPath != "" && !IsGitIgnored(p.localConfigPath) && !p.options.Quiet {
+		fmt.Printf("Hint: %s contains local overrides, consider adding it to .gitignore\n", p.localConfigPath)
 	}
 
-	err = p.parseTasks()
-	if err != nil && !p.options.Quiet {
-		log.Fatal(err)
+	envReads, err := recordEnvReads(func() error {
+		if err := p.parseGlobal(); err != nil {
+			return err
+		}
+
+		return p.parseTasks()
+	})
+	if err != nil {
+		return err
 	}
 
-	pStr := GOBSerialize(*p)
-	err = p.fs.WriteFile(path.Join(p.fs.TempDir(), p.getTempFileName()), []byte(pStr), 0644)
+	p.envReads = envReads
+	for _, warning := range p.Warnings {
+		if !p.options.Quiet {
+			fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+		}
+	}
 
-	if err != nil && !p.options.Quiet {
-		log.Fatal(err)
+	if p.options.ClearCache {
+		return nil
 	}
+
+	return p.writeCache()
 }
 
 // Parses the individual user defined tasks in the YAML config,
@@ -106,167 +440,1155 @@ func (p *Parser) Bootstrap() {
 func (p *Parser) parseTasks() error {
 	var tasks taskList
 
-	if err := yaml.Unmarshal([]byte(p.config), &tasks); err != nil {
+	if err := decodeConfig(p.configFile(), p.config, &tasks); err != nil {
+		return err
+	}
+
+	if err := p.mergeIncludedTasks(tasks); err != nil {
+		return err
+	}
+
+	if err := p.mergeLocalTasks(tasks); err != nil {
 		return err
 	}
 
+	if err := p.mergeGeneratedTasks(tasks); err != nil {
+		return err
+	}
+
+	delete(tasks, varsKey)
+	delete(tasks, hooksKey)
+	delete(tasks, placeholdersKey)
+	delete(tasks, includesKey)
+
 	allFilesPaths := []string{}
+	patternWarnings := []string{}
+	lines := keyLines(p.config)
 
-	for k, c := range tasks {
-		filePaths := []string{}
-		for i := range c.Files {
-			p.replaceEnvironmentVariables(osCommandRegexp, &tasks[k].Files[i])
-			expanded, err := p.expandFilePaths(tasks[k].Files[i])
+	if err := resolveExtends(tasks, lines); err != nil {
+		return err
+	}
 
-			if err != nil {
+	parseTask := func(k string) error {
+		c := tasks[k]
+		c.Run = c.platformRun(runtime.GOOS)
+
+		c.resolveEvents(p.Global.Shared.Events)
+
+		// Relative paths are resolved from the directory of the file
+		// declaring the task, and its files and outputs from its dir.
+		configDir := p.taskConfigDir(c)
+		filesDir := ""
+		if c.Include != "" {
+			filesDir = configDir
+		}
+
+		// Tasks of subprojects run in the subproject's directory by default.
+		if c.Dir == "" && p.configPath != "" {
+			c.Dir = "."
+			configDir = filepath.Dir(p.configFile())
+		}
+
+		dir, err := p.resolveDir(k, configDir, c.Dir)
+		if err != nil {
+			return err
+		}
+		c.Dir = dir
+		if c.Dir != "" {
+			filesDir = c.Dir
+		}
+
+		vars, err := p.taskVars(c)
+		if err != nil {
+			return err
+		}
+
+		patterns := []string{}
+		for i := range c.Files {
+			if err := p.resolveFilesPattern(k, &tasks[k].Files[i]); err != nil {
+				return err
+			}
+			if err := p.expandVars(k, vars, &tasks[k].Files[i]); err != nil {
 				return err
 			}
+			if tasks[k].Files[i], err = p.replacePlaceholders(tasks[k].Files[i], p.Global.Shared.Environment); err != nil {
+				return fmt.Errorf("task '%s': %w", k, err)
+			}
+
+			pattern := tasks[k].Files[i]
+			if err := checkPatternFeatures(pattern); err != nil {
+				return fmt.Errorf("task '%s': %w", k, err)
+			}
+
+			if isExclusion(pattern) {
+				patterns = append(patterns, "!"+joinDir(filesDir, strings.TrimPrefix(pattern, "!")))
+			} else {
+				path := joinDir(filesDir, pattern)
+				if err := p.checkDirectoryPattern(pattern, path); err != nil {
+					return fmt.Errorf("task '%s': %w", k, err)
+				}
+				patterns = append(patterns, path)
+			}
+		}
+
+		filePaths, unmatched, err := p.expandPatterns(patterns)
+		if err != nil {
+			return err
+		}
 
-			filePaths = append(filePaths, expanded...)
-			allFilesPaths = append(allFilesPaths, expanded...)
+		for _, pattern := range unmatched {
+			patternWarnings = append(patternWarnings, fmt.Sprintf("task '%s': files pattern \"%s\" matches no files", k, pattern))
 		}
 
+		allFilesPaths = append(allFilesPaths, filePaths...)
 		c.Files = filePaths
+		c.FilePatterns = patterns
+
+		for i := range c.Outputs {
+			if err := p.expandVars(k, vars, &c.Outputs[i]); err != nil {
+				return err
+			}
+			if c.Outputs[i], err = p.replacePlaceholders(c.Outputs[i], p.Global.Shared.Environment); err != nil {
+				return fmt.Errorf("task '%s': %w", k, err)
+			}
+
+			if isExclusion(c.Outputs[i]) {
+				return fmt.Errorf("task '%s': outputs can't exclude files: %s", k, c.Outputs[i])
+			}
+
+			c.Outputs[i] = joinDir(filesDir, c.Outputs[i])
+		}
+
+		cmds := []*string{&c.If, &c.Unless}
+		for i := range c.OnSuccess {
+			cmds = append(cmds, &c.OnSuccess[i])
+		}
+		for i := range c.OnFailure {
+			cmds = append(cmds, &c.OnFailure[i])
+		}
+
+		for _, cmd := range cmds {
+			if err := p.expandVars(k, vars, cmd); err != nil {
+				return err
+			}
+		}
+
 		tasks[k] = c
 
-		for i, r := range c.Run {
-			tasks[k].Run[i] = strings.Replace(r, "{FILES}", strings.Join(c.Files, " "), -1)
-			p.replaceEnvironmentVariables(osCommandRegexp, &tasks[k].Run[i])
+		for i := range c.Run {
+			if err := p.expandVars(k, vars, &tasks[k].Run[i].Cmd); err != nil {
+				return err
+			}
+
+			dir, err := p.resolveDir(k, p.taskConfigDir(c), c.Run[i].Dir)
+			if err != nil {
+				return err
+			}
+			c.Run[i].Dir = dir
+
+			if c.Run[i].Goke != "" {
+				c.Run[i].Goke = joinDir(p.taskConfigDir(c), c.Run[i].Goke)
+			}
+
+			if _, ok := tasks[c.Run[i].Cmd]; ok && len(c.Run[i].Env) != 0 {
+				return fmt.Errorf("task '%s': env doesn't apply to the referenced task '%s'", k, c.Run[i].Cmd)
+			}
+
+			if _, ok := tasks[c.Run[i].Cmd]; ok && c.Run[i].Golden != "" {
+				return fmt.Errorf("task '%s': golden doesn't apply to the referenced task '%s'", k, c.Run[i].Cmd)
+			}
+
+			if _, ok := tasks[c.Run[i].Cmd]; ok && c.Run[i].DiscardOutput {
+				return fmt.Errorf("task '%s': discard_output doesn't apply to the referenced task '%s'", k, c.Run[i].Cmd)
+			}
+
+			if _, ok := tasks[c.Run[i].Cmd]; !ok && c.Run[i].Output != "" {
+				return fmt.Errorf("task '%s': output only applies to referenced tasks, '%s' isn't a task", k, c.Run[i].Cmd)
+			}
+
+			if c.Run[i].Golden != "" {
+				c.Run[i].Golden = joinDir(p.taskConfigDir(c), c.Run[i].Golden)
+			}
+
+			for name, value := range c.Run[i].Env {
+				if err := p.expandVars(k, vars, &value); err != nil {
+					return err
+				}
+				c.Run[i].Env[name] = value
+			}
+		}
+
+		if err := validateParallel(k, c, tasks); err != nil {
+			return err
+		}
+
+		if _, err := parseCondition(c.When); c.When != "" && err != nil {
+			return fmt.Errorf("task '%s': when: %w", k, err)
+		}
+
+		if err := validateOnlyOS(k, c.OnlyOS); err != nil {
+			return err
+		}
+
+		if err := validateParams(k, c, p.Placeholders); err != nil {
+			return err
+		}
+
+		strs := append(append(c.placeholderCommands(), c.FilePatterns...), c.Outputs...)
+		if err := p.checkPlaceholders(fmt.Sprintf("task '%s'", k), c.Params, strs...); err != nil {
+			return err
+		}
+
+		if c.Every < 0 {
+			return fmt.Errorf("task '%s': every must be a positive duration", k)
+		}
+
+		if c.Timeout != nil && *c.Timeout < 0 {
+			return fmt.Errorf("task '%s': timeout must be a positive duration", k)
+		}
+
+		if c.Retries < 0 || c.RetryDelay < 0 {
+			return fmt.Errorf("task '%s': retries and retry_delay can't be negative", k)
+		}
+
+		if _, err := lookupEncoding(c.OutputEncoding); err != nil {
+			return fmt.Errorf("task '%s': %w", k, err)
 		}
 
-		if len(c.Env) != 0 {
-			vars, err := p.setEnvVariables(c.Env)
+		if len(c.EnvValues) != 0 {
+			values, paths := p.envValues(c.EnvValues, p.Global.Shared.EnvPathVars)
+			for name := range values {
+				v := values[name]
+				if err := p.expandVars(k, vars, &v); err != nil {
+					return err
+				}
+				values[name] = v
+			}
+			vars, err := p.resolveEnvVariables(values, paths)
 			if err != nil {
 				return err
 			}
 			c.Env = vars
+			c.EnvValues = nil
 		}
 		c.Name = k
+		c.Line = lines[k]
 		tasks[k] = c
+
+		return nil
+	}
+
+	// Tasks are parsed in the order they are declared, which is the order
+	// their $(...) commands run in and the first error is reported. With
+	// CollectErrors, the tasks after a failed one are still parsed, and the
+	// errors of all of them are returned together.
+	errs := ConfigErrors{}
+	for _, k := range declarationOrder(tasks, lines) {
+		if err := parseTask(k); err != nil {
+			if !p.options.CollectErrors {
+				return err
+			}
+
+			errs = append(errs, err)
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	// With CollectErrors, the tasks are parsed regardless of their deps, so
+	// that the deps are reported along with the other problems. The cycles
+	// are found by referenceCycles.
+	if err := validateDeps(tasks); err != nil {
+		if !p.options.CollectErrors {
+			return err
+		}
+
+		p.unknownDeps = unknownDeps(tasks)
+	}
+
+	if err := p.parseHooks(tasks); err != nil {
+		return err
+	}
+
+	p.Outputs = analyzeOutputs(tasks)
+	if err := p.Outputs.err(p.options.Strict); err != nil {
+		return err
 	}
 
 	p.FilePaths = allFilesPaths
 	p.Tasks = tasks
+	if err := p.validateServices(); err != nil {
+		return err
+	}
+	p.Warnings = append(p.shellWarnings(), p.tagWarnings()...)
+	p.Warnings = append(p.Warnings, p.substitutionWarnings()...)
+
+	sort.Strings(patternWarnings)
+	p.Warnings = append(p.Warnings, patternWarnings...)
+	p.Warnings = append(p.Warnings, p.Outputs.warnings()...)
+	p.Warnings = append(p.Warnings, p.placeholderWarnings...)
 
 	return nil
 }
 
-// Parses the "global" key in the yaml config and adds it to the parser.
-// Also sets all variables under global.environment as OS environment variables.
-func (p *Parser) parseGlobal() error {
-	var g Global
-
-	if err := yaml.Unmarshal([]byte(p.config), &g); err != nil {
-		return err
+// The commands of parallel tasks run independently of each other, so they
+// can't export variables nor reference tasks, which should be deps instead.
+func validateParallel(name string, task Task, tasks taskList) error {
+	if task.MaxConcurrency < 0 {
+		return fmt.Errorf("task '%s': max_concurrency must be positive", name)
 	}
 
-	vars, err := p.setEnvVariables(g.Shared.Environment)
-	if err != nil {
+	if !task.Parallel {
 		return nil
 	}
 
-	g.Shared.Environment = vars
-	p.Global = g
+	for _, entry := range task.Run {
+		if len(entry.Export) > 0 {
+			return fmt.Errorf("task '%s': parallel tasks can't export variables, use env instead", name)
+		}
+
+		if _, ok := tasks[entry.Cmd]; ok {
+			return fmt.Errorf("task '%s': parallel tasks can't run task '%s', list it under deps instead", name, entry.Cmd)
+		}
+
+		if entry.Goke != "" {
+			return fmt.Errorf("task '%s': parallel tasks can't run subproject %s", name, entry.Goke)
+		}
+	}
 
 	return nil
 }
 
-// Parses the interpolated system commands, ie. "Hello $(echo 'World')" and returns it.
-// Returns the command wrapper in $() and without the wrapper.
-func (p *Parser) parseSystemCmd(re *regexp.Regexp, str string) (string, string) {
-	match := re.FindAllStringSubmatch(str, -1)
-
-	if len(match) > 0 && len(match[0]) > 0 {
-		return match[0][0], match[0][1]
+// Whether the task's commands run through the system shell.
+func (p *Parser) usesShell(task Task) bool {
+	if task.Shell != nil {
+		return *task.Shell
 	}
 
-	return "", ""
+	return p.Global.Shared.Shell
 }
 
-// Replace the placeholders with actual environment variable values in string pointer.
-// Given that a string pointer must be provided, the replacement happens in place.
-func (p *Parser) replaceEnvironmentVariables(re *regexp.Regexp, str *string) {
-	resolved := *str
-	raw, env := p.parseSystemCmd(re, resolved)
-
-	if raw != "" && env != "" {
-		*str = strings.Replace(resolved, raw, os.Getenv(env), -1)
+// Whether changes to the task's files are detected by their contents
+// instead of their mtime, which is the default when the clock of the
+// filesystem is skewed, see CheckClockSkew.
+func (p *Parser) usesChecksum(task Task) bool {
+	if task.Checksum != nil {
+		return *task.Checksum
 	}
+
+	return p.Global.Shared.Checksum || p.clockSkew != 0
 }
 
-// Expand the path glob and returns all paths in an array
-func (p *Parser) expandFilePaths(file string) ([]string, error) {
-	filePaths := []string{}
+// Warns about commands with shell operators which don't run through a shell,
+// since the operators would silently be passed as arguments.
+func (p *Parser) shellWarnings() []string {
+	warnings := []string{}
 
-	if strings.Contains(file, "*") {
-		files, err := p.fs.Glob(file)
-		if err != nil {
-			return nil, err
+	for _, name := range sortedKeys(p.Tasks) {
+		task := p.Tasks[name]
+		if p.usesShell(task) {
+			continue
 		}
 
-		if len(files) > 0 {
-			filePaths = append(filePaths, files...)
+		for _, entry := range task.Run {
+			if strings.HasPrefix(entry.Cmd, builtinPrefix) {
+				continue
+			}
+
+			if shellwords.ContainsOperators(entry.Cmd) {
+				warnings = append(warnings, fmt.Sprintf("task '%s' runs \"%s\" without a shell, so its shell operators are passed as arguments; set shell: true on the task", name, entry.Cmd))
+			}
 		}
-	} else if p.fs.FileExists(file) {
-		filePaths = append(filePaths, file)
 	}
 
-	return filePaths, nil
+	return warnings
 }
 
-// Retrieves the temp file name
-func (p *Parser) getTempFileName() string {
-	cwd, _ := p.fs.Getwd()
-	return "goke-" + strings.Replace(cwd, string(filepath.Separator), "-", -1)
+// Warns about event selectors with tags which no task declares,
+// since they are most likely typos.
+func (p *Parser) tagWarnings() []string {
+	declared := make(map[string]bool)
+	for _, task := range p.Tasks {
+		for _, tag := range task.Tags {
+			declared[tag] = true
+		}
+	}
+
+	warnings := []string{}
+	for _, ev := range p.Global.Shared.Events.all() {
+		for _, tag := range append(append([]string{}, ev.OnlyTags...), ev.ExceptTags...) {
+			if !declared[tag] {
+				warnings = append(warnings, fmt.Sprintf("event \"%s\" selects tag '%s', which no task declares", ev.Cmd, tag))
+			}
+		}
+	}
+
+	return warnings
 }
 
-// Determines whether the parser cache should be cleaned or not
-func (p *Parser) shouldClearCache(tempFile string) bool {
-	tempFileExists := p.fs.FileExists(tempFile)
-	mustCleanCache := false
+// Returns the names of the tasks with the given tag, in the order they are
+// declared. Abstract tasks are left out, they don't run on their own.
+func (p *Parser) tasksWithTag(tag string) []string {
+	lines := make(map[string]int, len(p.Tasks))
+	for name, task := range p.Tasks {
+		lines[name] = task.Line
+	}
+
+	names := []string{}
+	for _, name := range declarationOrder(p.Tasks, lines) {
+		if task := p.Tasks[name]; task.hasAnyTag([]string{tag}) && !task.Abstract {
+			names = append(names, name)
+		}
+	}
 
-	if !p.options.ClearCache && tempFileExists {
-		tempStat, _ := p.fs.Stat(tempFile)
-		tempModTime := tempStat.ModTime().Unix()
+	return names
+}
 
-		configStat, _ := p.fs.Stat(CurrentConfigFile())
-		configModTime := configStat.ModTime().Unix()
+// Resolves a "dir" relative to configDir, the directory of the file
+// declaring the task, see taskConfigDir, and ensures that it exists. An
+// empty dir stays empty, meaning the current directory.
+func (p *Parser) resolveDir(taskName string, configDir string, dir string) (string, error) {
+	if dir == "" {
+		return "", nil
+	}
 
-		mustCleanCache = tempModTime < configModTime
+	dir, err := expandProcessEnv(dir)
+	if err != nil {
+		return "", fmt.Errorf("task '%s': dir: %w", taskName, err)
 	}
 
-	if p.options.ClearCache && tempFileExists {
-		mustCleanCache = true
+	dir = joinDir(configDir, dir)
+
+	info, err := p.fs.Stat(dir)
+	if err != nil || !info.IsDir() {
+		return "", fmt.Errorf("task '%s': directory %s does not exist", taskName, dir)
 	}
 
-	return mustCleanCache
+	return dir, nil
 }
 
-// prase system commands and store results to env
-func (p *Parser) setEnvVariables(vars map[string]string) (map[string]string, error) {
-	retVars := make(map[string]string)
-	for k, v := range vars {
-		_, cmd := p.parseSystemCmd(osCommandRegexp, v)
+// Path of the parsed config, relative to the working directory.
+func (p *Parser) configFile() string {
+	if p.configPath != "" {
+		return p.configPath
+	}
 
-		if cmd == "" {
-			retVars[k] = v
-			_ = os.Setenv(k, v)
+	return CurrentConfigFile(p.options.ConfigPath)
+}
+
+// Ensures that every dependency is a declared task,
+// and that tasks don't depend on themselves through a cycle.
+func validateDeps(tasks taskList) error {
+	visited := make(map[string]bool)
+
+	var visit func(name string, path []string) error
+	visit = func(name string, path []string) error {
+		for i, n := range path {
+			if n == name {
+				return fmt.Errorf("dependency cycle detected: %s", strings.Join(append(path[i:], name), " -> "))
+			}
+		}
+
+		if visited[name] {
+			return nil
+		}
+
+		for _, dep := range tasks[name].Deps {
+			if _, ok := tasks[dep]; !ok {
+				return unknownDepError(name, dep)
+			}
+
+			if err := visit(dep, append(path, name)); err != nil {
+				return err
+			}
+		}
+
+		visited[name] = true
+		return nil
+	}
+
+	for _, name := range sortedKeys(tasks) {
+		if err := visit(name, []string{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Lists the deps of all the tasks which aren't tasks, see validateDeps.
+func unknownDeps(tasks taskList) []string {
+	problems := []string{}
+	for _, name := range sortedKeys(tasks) {
+		for _, dep := range tasks[name].Deps {
+			if _, ok := tasks[dep]; !ok {
+				problems = append(problems, unknownDepError(name, dep).Error())
+			}
+		}
+	}
+
+	return problems
+}
+
+func unknownDepError(task string, dep string) error {
+	return fmt.Errorf("task '%s' depends on unknown task '%s'", task, dep)
+}
+
+// Whether symlinks under "files" get resolved for change detection.
+func (t Task) followSymlinks() bool {
+	return t.FollowSymlinks == nil || *t.FollowSymlinks
+}
+
+// Returns the files which decide whether the task runs. With inherit_files,
+// they include the files of the tasks referenced under "run", transitively.
+// The returned map attributes each file to the task which declared it.
+func (p *Parser) inputFiles(task Task) ([]string, map[string]string) {
+	files := []string{}
+	origins := make(map[string]string)
+
+	for _, t := range p.inputTasks(task) {
+		for _, f := range p.taskFiles(t) {
+			if _, ok := origins[f]; !ok {
+				origins[f] = t.Name
+				files = append(files, f)
+			}
+		}
+	}
+
+	return files, origins
+}
+
+// Returns the files which decide whether each task with files runs, which
+// is what a new lockfile records, see inputFiles.
+func (p *Parser) TaskFiles() map[string][]string {
+	files := make(map[string][]string)
+	for name, task := range p.Tasks {
+		if taskFiles, _ := p.inputFiles(task); len(taskFiles) > 0 {
+			files[name] = taskFiles
+		}
+	}
+
+	return files
+}
+
+// Returns the patterns of the files returned by inputFiles.
+func (p *Parser) inputPatterns(task Task) []string {
+	patterns := []string{}
+	for _, t := range p.inputTasks(task) {
+		patterns = append(patterns, t.FilePatterns...)
+	}
+
+	return patterns
+}
+
+// Returns the task, followed by the tasks it inherits files from.
+func (p *Parser) inputTasks(task Task) []Task {
+	tasks := []Task{}
+	visited := make(map[string]bool)
+
+	var visit func(t Task)
+	visit = func(t Task) {
+		if visited[t.Name] {
+			return
+		}
+		visited[t.Name] = true
+		tasks = append(tasks, t)
+
+		if !task.InheritFiles {
+			return
+		}
+
+		for _, entry := range t.Run {
+			if ref, ok := p.Tasks[entry.Cmd]; ok {
+				visit(ref)
+			}
+		}
+	}
+
+	visit(task)
+
+	return tasks
+}
+
+// Returns the files of the task, with its patterns expanded again so that
+// files created since the config was parsed are included. Tasks without
+// patterns keep the files expanded when parsing.
+func (p *Parser) taskFiles(t Task) []string {
+	if len(t.FilePatterns) == 0 {
+		return t.Files
+	}
+
+	files, _, err := p.expandPatterns(t.FilePatterns)
+	if err != nil {
+		return t.Files
+	}
+
+	return files
+}
+
+// Decodes a run entry from either a plain string or a mapping.
+func (r *RunEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		if err := node.Decode(&r.Cmd); err != nil {
+			return err
+		}
+
+		r.Cmd, r.IgnoreError = trimIgnorePrefix(r.Cmd)
+		return nil
+	}
+
+	// Decoding into an alias type avoids recursing into this method.
+	type runEntry RunEntry
+	var entry runEntry
+
+	if err := node.Decode(&entry); err != nil {
+		return err
+	}
+
+	kinds := []string{}
+	if entry.Cmd != "" {
+		kinds = append(kinds, `"cmd"`)
+	}
+	if len(entry.Export) != 0 {
+		kinds = append(kinds, `"export"`)
+	}
+	if entry.Goke != "" {
+		kinds = append(kinds, `"goke"`)
+	}
+
+	if len(kinds) == 0 {
+		return fmt.Errorf("line %d: run entries must have either \"cmd\", \"export\" or \"goke\"", node.Line)
+	}
+
+	if len(kinds) > 1 {
+		return fmt.Errorf("line %d: run entries cannot have both %s", node.Line, strings.Join(kinds, " and "))
+	}
+
+	if entry.Task != "" && entry.Goke == "" {
+		return fmt.Errorf("line %d: \"task\" only applies to \"goke\" entries", node.Line)
+	}
+
+	if (entry.Name != "" || len(entry.Env) != 0 || entry.IgnoreError) && entry.Cmd == "" {
+		return fmt.Errorf("line %d: \"name\", \"env\" and \"ignore_error\" only apply to \"cmd\" entries", node.Line)
+	}
+
+	if entry.Golden != "" && entry.Cmd == "" {
+		return fmt.Errorf("line %d: \"golden\" only applies to \"cmd\" entries", node.Line)
+	}
+
+	if err := validateGolden(RunEntry(entry), node.Line); err != nil {
+		return err
+	}
+
+	if entry.Output != "" && entry.Cmd == "" {
+		return fmt.Errorf("line %d: \"output\" only applies to \"cmd\" entries", node.Line)
+	}
+
+	if err := validateOutput(RunEntry(entry), node.Line); err != nil {
+		return err
+	}
+
+	if err := validateDiscard(RunEntry(entry), node.Line); err != nil {
+		return err
+	}
+
+	*r = RunEntry(entry)
+	cmd, ignore := trimIgnorePrefix(r.Cmd)
+	r.Cmd, r.IgnoreError = cmd, r.IgnoreError || ignore
+
+	return nil
+}
+
+// Decodes an event entry from either a plain string or a mapping.
+func (ev *EventEntry) UnmarshalYAML(node *yaml.Node) error {
+	if node.Kind == yaml.ScalarNode {
+		return node.Decode(&ev.Cmd)
+	}
+
+	// Decoding into an alias type avoids recursing into this method.
+	type eventEntry EventEntry
+	var entry eventEntry
+
+	if err := node.Decode(&entry); err != nil {
+		return err
+	}
+
+	if entry.Cmd == "" {
+		return fmt.Errorf("line %d: event entries must have a \"cmd\"", node.Line)
+	}
+
+	*ev = EventEntry(entry)
+	return nil
+}
+
+// Returns the entries of all events, including the ones of the operating
+// systems which forOS didn't pick.
+func (events Events) all() []EventEntry {
+	all := []EventEntry{}
+	for _, entries := range events.lists() {
+		all = append(all, *entries...)
+	}
+
+	return all
+}
+
+// The lists of entries of all events, see all.
+func (events *Events) lists() []*[]EventEntry {
+	return []*[]EventEntry{
+		&events.BeforeEachTask, &events.BeforeEachRun, &events.AfterEachRun, &events.AfterEachTask,
+		&events.BeforeEachTaskWindows, &events.BeforeEachRunWindows, &events.AfterEachRunWindows, &events.AfterEachTaskWindows,
+		&events.BeforeEachTaskDarwin, &events.BeforeEachRunDarwin, &events.AfterEachRunDarwin, &events.AfterEachTaskDarwin,
+		&events.BeforeEachTaskLinux, &events.BeforeEachRunLinux, &events.AfterEachRunLinux, &events.AfterEachTaskLinux,
+	}
+}
+
+// Whether the event applies to the task, according to its tags.
+func (ev EventEntry) appliesTo(task Task) bool {
+	if len(ev.OnlyTags) > 0 && !task.hasAnyTag(ev.OnlyTags) {
+		return false
+	}
+
+	return !task.hasAnyTag(ev.ExceptTags)
+}
+
+// Whether the task has at least one of the given tags.
+func (t Task) hasAnyTag(tags []string) bool {
+	for _, tag := range tags {
+		for _, own := range t.Tags {
+			if own == tag {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Strips the "-" prefix, which marks commands whose failure is ignored.
+func trimIgnorePrefix(cmd string) (string, bool) {
+	if !strings.HasPrefix(cmd, "-") {
+		return cmd, false
+	}
+
+	return strings.TrimSpace(cmd[1:]), true
+}
+
+// Wraps an event command, which runs through a shell if that's the global default.
+func (p *Parser) hookEntry(cmd string) RunEntry {
+	entry := RunEntry{shell: p.Global.Shared.Shell, windowsShell: p.Global.Shared.WindowsShell, timeout: p.Global.Shared.Timeout}
+	entry.Cmd, entry.IgnoreError = trimIgnorePrefix(cmd)
+
+	return entry
+}
+
+// Parses the "global" key in the yaml config and adds it to the parser.
+// Also sets all variables under global.environment as OS environment variables.
+func (p *Parser) parseGlobal() error {
+	var g Global
+
+	if err := decodeConfig(p.configFile(), p.config, &g); err != nil {
+		return err
+	}
+
+	local, err := p.mergeLocalGlobal(&g)
+	if err != nil {
+		return err
+	}
+
+	if err := p.parseVars(); err != nil {
+		return err
+	}
+
+	if err := p.parsePlaceholders(); err != nil {
+		return err
+	}
+
+	if g.Shared.Timeout < 0 {
+		return errors.New("global: timeout must be a positive duration")
+	}
+
+	if g.Shared.StallAfter < 0 {
+		return errors.New("global: stall_after must be a positive duration")
+	}
+
+	if err := validateWindowsShell(g.Shared.WindowsShell); err != nil {
+		return err
+	}
+
+	if err := validateStateBackend(g.Shared.StateBackend); err != nil {
+		return err
+	}
+
+	g.Shared.Events = g.Shared.Events.forOS(runtime.GOOS)
+
+	mainVars, err := p.globalEnv(g.Shared.EnvironmentValues, g.Shared.EnvPathVars)
+	if err != nil {
+		return err
+	}
+
+	localVars, err := p.globalEnv(local, g.Shared.EnvPathVars)
+	if err != nil {
+		return err
+	}
+
+	p.EnvLayers = map[EnvSource]map[string]string{EnvFromGlobal: mainVars, EnvFromLocal: localVars}
+	vars := NewEnvResolver().Set(EnvFromGlobal, mainVars).Set(EnvFromLocal, localVars).Vars()
+
+	// The variables of subprojects only reach their own commands.
+	if p.configPath == "" {
+		for k, v := range vars {
+			setProcessEnv(k, v)
+		}
+	}
+
+	g.Shared.Environment = vars
+	g.Shared.EnvironmentValues = nil
+	p.Global = g
+
+	return p.checkPlaceholders("global", nil, g.Shared.Events.placeholderCommands()...)
+}
+
+// Adds the tasks of the local overrides file. Local tasks can only be added,
+// redefining a task of the main config is an error naming both locations.
+func (p *Parser) mergeLocalTasks(tasks taskList) error {
+	if p.localConfig == "" {
+		return nil
+	}
+
+	var localTasks taskList
+	if err := decodeConfig("", p.localConfig, &localTasks); err != nil {
+		return fmt.Errorf("%s: %w", p.localConfigPath, err)
+	}
+
+	for name, task := range localTasks {
+		if name == "global" {
 			continue
 		}
 
-		splitCmd, err := ParseCommandLine(os.ExpandEnv(cmd))
+		if _, ok := tasks[name]; ok {
+			return fmt.Errorf(
+				"task '%s' defined in %s:%d is already defined in %s:%d, local overrides can only add tasks",
+				name, p.localConfigPath, keyLine(p.localConfig, name), p.configFile(), keyLine(p.config, name),
+			)
+		}
+
+		tasks[name] = task
+	}
+
+	return nil
+}
+
+// Returns global.environment of the local overrides file, which applies on
+// top of the main one, see EnvFromLocal.
+func (p *Parser) mergeLocalGlobal(g *Global) (map[string]EnvValue, error) {
+	if p.localConfig == "" {
+		return nil, nil
+	}
+
+	var local Global
+	if err := decodeConfig("", p.localConfig, &local); err != nil {
+		return nil, fmt.Errorf("%s: %w", p.localConfigPath, err)
+	}
+
+	if len(local.Shared.Events.all()) > 0 {
+		return nil, fmt.Errorf("%s: only global.environment can be overridden locally", p.localConfigPath)
+	}
+
+	g.Shared.EnvPathVars = append(g.Shared.EnvPathVars, local.Shared.EnvPathVars...)
+
+	return local.Shared.EnvironmentValues, nil
+}
+
+// Resolves the variables of global.environment, expanding the vars.
+func (p *Parser) globalEnv(vars map[string]EnvValue, pathVars []string) (map[string]string, error) {
+	values, paths := p.envValues(vars, pathVars)
+	for name := range values {
+		v := values[name]
+		if err := p.expandVars("global", p.vars, &v); err != nil {
+			return nil, err
+		}
+		values[name] = v
+	}
+
+	return p.resolveEnvVariables(values, paths)
+}
+
+// An interpolated system command, ie. the $(echo 'World') of "Hello $(echo 'World')".
+type systemCmd struct {
+	// Offsets of the "$(" and after the ")" in the string.
+	start, end int
+
+	// The command without the wrapper.
+	cmd string
+}
+
+// Parses the outermost interpolated system commands of the string, in order.
+// Parentheses are balanced, so "$(dirname $(pwd))" is a single command which
+// holds another one. A "$" which isn't followed by "(" and a "$(" which is
+// never closed are not commands.
+func parseSystemCmds(str string) []systemCmd {
+	cmds := []systemCmd{}
+
+	for i := 0; i+1 < len(str); i++ {
+		if str[i] != '$' || str[i+1] != '(' {
+			continue
+		}
+
+		depth, end := 0, -1
+		for j := i + 1; j < len(str) && end < 0; j++ {
+			switch str[j] {
+			case '(':
+				depth++
+			case ')':
+				depth--
+				if depth == 0 {
+					end = j
+				}
+			}
+		}
+
+		if end < 0 {
+			continue
+		}
+
+		cmds = append(cmds, systemCmd{start: i, end: end + 1, cmd: str[i+2 : end]})
+		i = end
+	}
+
+	return cmds
+}
+
+// Replaces every interpolated system command of the string by what resolve
+// returns for it. Each one is resolved independently, the nested ones first,
+// so resolve gets "dirname /work" for "$(dirname $(pwd))".
+func substituteSystemCmds(str string, resolve func(cmd string) (string, error)) (string, error) {
+	cmds := parseSystemCmds(str)
+	if len(cmds) == 0 {
+		return str, nil
+	}
+
+	var b strings.Builder
+	last := 0
+
+	for _, c := range cmds {
+		cmd, err := substituteSystemCmds(c.cmd, resolve)
 		if err != nil {
-			return retVars, err
+			return "", err
+		}
+
+		out, err := resolve(cmd)
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(str[last:c.start])
+		b.WriteString(out)
+		last = c.end
+	}
+
+	b.WriteString(str[last:])
+	return b.String(), nil
+}
+
+// Replaces the $(...) commands of a files pattern of the task by their
+// output, then expands its $VAR and ${VAR} variables, in place. The ones of
+// "run" are replaced when the commands run.
+func (p *Parser) resolveFilesPattern(task string, str *string) error {
+	out, err := substituteSystemCmds(*str, runSystemCmd)
+	if err != nil {
+		return fmt.Errorf("task '%s': files pattern \"%s\": %w%s", task, *str, err, variableHint(*str))
+	}
+
+	expanded, err := expandEnv(out, p.Global.Shared.Environment)
+	if err != nil {
+		return fmt.Errorf("task '%s': files pattern \"%s\": %w", task, *str, err)
+	}
+
+	*str = expanded
+	return nil
+}
+
+// Names of variables, which $(...) used to substitute before it ran commands.
+var variableNameRegexp = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// Suggests ${NAME} for the first $(NAME) of the string, if any.
+func variableHint(str string) string {
+	for _, c := range parseSystemCmds(str) {
+		if variableNameRegexp.MatchString(c.cmd) {
+			return fmt.Sprintf("; $(%s) runs a command, use ${%s} for the variable", c.cmd, c.cmd)
+		}
+	}
+
+	return ""
+}
+
+// Warns about $(NAME) in commands, which runs NAME as a command while it
+// most likely meant the variable.
+func (p *Parser) substitutionWarnings() []string {
+	warnings := []string{}
+
+	for _, name := range sortedKeys(p.Tasks) {
+		for _, entry := range p.Tasks[name].Run {
+			if hint := variableHint(entry.Cmd); hint != "" {
+				warnings = append(warnings, fmt.Sprintf("task '%s' runs \"%s\"%s", name, entry.Cmd, hint))
+			}
+		}
+	}
+
+	return warnings
+}
+
+// Expands the patterns of a files section, then drops the files matched by
+// its "!" exclusions. Also returns the wildcard patterns which match nothing.
+func (p *Parser) expandPatterns(patterns []string) ([]string, []string, error) {
+	files := []string{}
+	excludes := []string{}
+	unmatched := []string{}
+
+	for _, pattern := range patterns {
+		if isExclusion(pattern) {
+			excludes = append(excludes, strings.TrimPrefix(pattern, "!"))
+			continue
 		}
 
-		out, err := exec.Command(splitCmd[0], splitCmd[1:]...).Output()
+		expanded, err := p.expandFilePaths(pattern)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if len(expanded) == 0 && hasGlobMeta(pattern) {
+			unmatched = append(unmatched, pattern)
+		}
+
+		files = append(files, expanded...)
+	}
+
+	kept := []string{}
+	for _, f := range files {
+		if !matchesAny(excludes, f) {
+			kept = append(kept, f)
+		}
+	}
+
+	return kept, unmatched, nil
+}
+
+// Expand the path glob and returns all paths in an array
+func (p *Parser) expandFilePaths(file string) ([]string, error) {
+	filePaths := []string{}
+
+	if strings.Contains(file, "**") {
+		return globRecursive(p.fs, file)
+	}
+
+	if hasGlobMeta(file) {
+		files, err := p.fs.Glob(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(files) > 0 {
+			filePaths = append(filePaths, files...)
+		}
+	} else if p.fs.FileExists(file) {
+		filePaths = append(filePaths, file)
+	}
+
+	return filePaths, nil
+}
+
+// Retrieves the temp file name. Configs of the working directory other than
+// goke.yml, given with --config, get their own.
+func (p *Parser) getTempFileName() string {
+	cwd, _ := p.fs.Getwd()
+	name := cwd
+
+	if f := p.options.ConfigPath; f != "" {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(cwd, f)
+		}
+
+		if f != filepath.Join(cwd, p.defaultConfigFile()) {
+			name = f
+		}
+	}
+
+	return "goke-v" + cacheVersion + pathFileName(name)
+}
+
+// Turns the path into a part of a file name, ie. "-home-me-app" for
+// /home/me/app, or "-C-Users-me-app" for C:\Users\me\app.
+func pathFileName(path string) string {
+	name := strings.NewReplacer(":", "", `\`, "-", "/", "-").Replace(path)
+	if !strings.HasPrefix(name, "-") {
+		name = "-" + name
+	}
+
+	return name
+}
+
+// The goke.yml or goke.yaml of the working directory, see GokeFiles.
+func (p *Parser) defaultConfigFile() string {
+	for _, f := range GokeFiles() {
+		if p.fs.FileExists(f) {
+			return f
+		}
+	}
+
+	return ""
+}
+
+// Resolves the $(...) commands in the values of the variables and exports them
+// to the environment of goke itself, which is only done for global.environment.
+func (p *Parser) setEnvVariables(vars map[string]string, paths map[string]bool) (map[string]string, error) {
+	retVars, err := p.resolveEnvVariables(vars, paths)
+
+	for k, v := range retVars {
+		setProcessEnv(k, v)
+	}
+
+	return retVars, err
+}
+
+// Runs a $(...) command of a variable when parsing and returns its trimmed output.
+func runSystemCmd(cmd string) (string, error) {
+	cmd, err := expandProcessEnv(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	splitCmd, err := splitCommand(cmd)
+	if err != nil {
+		return "", err
+	}
+
+	out, err := exec.Command(splitCmd[0], splitCmd[1:]...).Output()
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Resolves the $(...) commands in the values of the variables, then the
+// paths among them, without setting them in the environment of goke itself.
+func (p *Parser) resolveEnvVariables(vars map[string]string, paths map[string]bool) (map[string]string, error) {
+	retVars := make(map[string]string)
+	defer p.resolvePathVars(retVars, paths)
+
+	for k, v := range vars {
+		value, err := substituteSystemCmds(v, runSystemCmd)
 		if err != nil {
 			return retVars, err
 		}
 
-		outStr := strings.TrimSpace(string(out))
-		retVars[k] = outStr
-		_ = os.Setenv(k, outStr)
+		if value, err = expandDefaults(value, lookupProcessEnv); err != nil {
+			return retVars, fmt.Errorf("variable %s: %w", k, err)
+		}
+
+		retVars[k] = value
 	}
 
 	return retVars, nil
@@ -1,9 +1,14 @@
 package internal
 
 import (
+	"fmt"
 	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/dugajean/goke/internal/tests"
 	"github.com/stretchr/testify/mock"
@@ -58,65 +63,133 @@ var baseOptions = Options{}
 
 func mockCacheDoesNotExist(t *testing.T) *tests.FileSystem {
 	fsMock := tests.NewFileSystem(t)
-	fsMock.On("TempDir").Return("path/to/temp")
-	fsMock.On("Getwd").Return("path/to/cwd", nil)
-	fsMock.On("FileExists", mock.Anything).Return(false).Twice()
+	fsMock.On("TempDir").Return("path/to/temp").Maybe()
+	fsMock.On("Getwd").Return("path/to/cwd", nil).Maybe()
+	fsMock.On("FileExists", mock.Anything).Return(false).Maybe()
 
 	return fsMock
 }
 
-func mockCacheDoesNotExistOnce(t *testing.T) *tests.FileSystem {
+func TestNewParserWithoutCache(t *testing.T) {
+	fsMock := tests.NewFileSystem(t)
+	parser := NewParser(yamlConfigStub, &clearCacheOpts, fsMock)
+	require.False(t, parser.cached)
+}
+
+func TestNewParserDiscardsCachesOfOlderVersions(t *testing.T) {
 	fsMock := tests.NewFileSystem(t)
 	fsMock.On("TempDir").Return("path/to/temp")
 	fsMock.On("Getwd").Return("path/to/cwd", nil)
-	fsMock.On("FileExists", mock.Anything).Return(false).Once()
 	fsMock.On("FileExists", mock.Anything).Return(true).Once()
+	fsMock.On("ReadFile", mock.Anything).Return([]byte(tests.ReadFileBase64), nil).Once()
+	fsMock.On("Remove", mock.Anything).Return(nil).Once()
 
-	return fsMock
+	parser := NewParser(yamlConfigStub, &Options{Quiet: true}, fsMock)
+	require.False(t, parser.cached)
 }
 
-func mockCacheExists(t *testing.T) *tests.FileSystem {
-	fsMock := tests.NewFileSystem(t)
-	fsMock.On("TempDir").Return("path/to/temp")
-	fsMock.On("Getwd").Return("path/to/cwd", nil)
-	fsMock.On("FileExists", mock.Anything).Return(true).Twice()
+func TestNewParserRemovesCorruptCaches(t *testing.T) {
+	fs := NewMemFileSystem("/work")
 
-	return fsMock
+	p := NewParser(yamlConfigStub, &baseOptions, fs)
+	require.Nil(t, p.writeCache())
+
+	data, err := fs.ReadFile(p.cacheFile())
+	require.Nil(t, err)
+	require.Nil(t, fs.WriteFile(p.cacheFile(), data[:len(data)/2], 0644))
+
+	p = NewParser(yamlConfigStub, &Options{Quiet: true}, fs)
+	require.False(t, p.cached)
+	require.False(t, fs.FileExists(p.cacheFile()))
 }
 
-func TestNewParserWithoutCache(t *testing.T) {
-	fsMock := mockCacheDoesNotExist(t)
-	parser := NewParser(yamlConfigStub, &clearCacheOpts, fsMock)
-	require.NotNil(t, parser)
+func TestNewParserUsesTheCacheOfTheSameConfig(t *testing.T) {
+	fs := NewMemFileSystem("/work")
+	require.Nil(t, fs.WriteFile("/work/goke.yml", []byte(yamlConfigStub), 0644))
+
+	p := NewParser(yamlConfigStub, &baseOptions, fs)
+	require.False(t, p.cached)
+	require.Nil(t, p.writeCache())
+
+	p = NewParser(yamlConfigStub, &baseOptions, fs)
+	require.True(t, p.cached)
+	require.True(t, fs.FileExists(p.cacheFile()))
+
+	// Older mtimes of the config, ie. when restored from git, don't matter.
+	require.Nil(t, fs.Chtimes("/work/goke.yml", time.Unix(0, 0)))
+	require.True(t, NewParser(yamlConfigStub, &baseOptions, fs).cached)
+
+	require.False(t, NewParser(yamlConfigStub+"\n", &baseOptions, fs).cached)
+	require.False(t, NewParser(yamlConfigStub, &clearCacheOpts, fs).cached)
 }
 
-func TestNewParserWithCache(t *testing.T) {
-	fsMock := mockCacheDoesNotExistOnce(t)
-	fsMock.On("ReadFile", mock.Anything).Return([]byte(tests.ReadFileBase64), nil)
+func TestNewParserDiscardsTheCacheWhenReferencedVariablesChange(t *testing.T) {
+	config := yamlConfigStub + "\n\ngreet-home:\n  run:\n    - \"echo ${GOKE_TEST_GREETING:-hi}\"\n"
+	fs := NewMemFileSystem("/work")
 
-	parser := NewParser(yamlConfigStub, &clearCacheOpts, fsMock)
-	require.NotNil(t, parser)
+	t.Setenv("GOKE_TEST_GREETING", "hello")
+	p := NewParser(config, &baseOptions, fs)
+	require.Nil(t, p.writeCache())
+	require.True(t, NewParser(config, &baseOptions, fs).cached)
+
+	t.Setenv("GOKE_TEST_GREETING", "bye")
+	require.False(t, NewParser(config, &baseOptions, fs).cached)
 }
 
-func TestNewParserWithCacheAndWithoutClearCacheFlag(t *testing.T) {
-	fsMock := mockCacheExists(t)
-	fsMock.On("Stat", mock.Anything).Return(tests.MemFileInfo{}, nil).Twice()
-	fsMock.On("ReadFile", mock.Anything).Return([]byte(tests.ReadFileBase64), nil).Once()
+func TestNewParserCachesEachCombinationOfGeneratedTasksAndVariables(t *testing.T) {
+	script, tasksFile := generatorFixture(t, "")
+	config := fmt.Sprintf("generate_tasks: %s\n\nbuild:\n  run:\n    - \"go build\"\n", script)
+	fs := NewMemFileSystem("/work")
+	opts := Options{Quiet: true}
+
+	// The variable only appears in the generated tasks, so it's only known
+	// once they are parsed.
+	sources := map[string]string{
+		"go":   "deploy:\n  files: [\"${GOKE_TEST_SRC}/*.go\"]\n  run:\n    - \"deploy\"\n",
+		"yaml": "deploy:\n  files: [\"${GOKE_TEST_SRC}/*.yml\"]\n  run:\n    - \"deploy\"\n",
+	}
+	expected := map[string]string{"go": "%s/*.go", "yaml": "%s/*.yml"}
+
+	parse := func(kind string, src string) Parser {
+		require.Nil(t, os.WriteFile(tasksFile, []byte(sources[kind]), 0644))
+		t.Setenv("GOKE_TEST_SRC", src)
+
+		p := NewParser(config, &opts, fs)
+		require.Nil(t, p.Bootstrap())
+		require.Equal(t, []string{fmt.Sprintf(expected[kind], src)}, p.Tasks["deploy"].FilePatterns)
+
+		return p
+	}
 
-	parser := NewParser(yamlConfigStub, &baseOptions, fsMock)
-	require.NotNil(t, parser)
+	for _, kind := range []string{"go", "yaml"} {
+		for _, src := range []string{"api", "web"} {
+			require.False(t, parse(kind, src).cached, "%s %s", kind, src)
+		}
+	}
+
+	for _, kind := range []string{"go", "yaml"} {
+		for _, src := range []string{"api", "web"} {
+			require.True(t, parse(kind, src).cached, "%s %s", kind, src)
+		}
+	}
+
+	p := NewParser(config, &opts, fs)
+	data, err := fs.ReadFile(p.cacheFile())
+	require.Nil(t, err)
+
+	cache, err := GOBDeserialize(string(data), &parserCache{})
+	require.Nil(t, err)
+	require.Len(t, cache.Entries, 4)
+	require.Equal(t, []string{"GOKE_TEST_SRC"}, cache.Entries[0].Env)
 }
 
-func TestNewParserWithShouldClearCacheTrue(t *testing.T) {
-	fsMock := tests.NewFileSystem(t)
-	fsMock.On("TempDir").Return("path/to/temp")
-	fsMock.On("Getwd").Return("path/to/cwd", nil)
-	fsMock.On("FileExists", mock.Anything).Return(true).Once()
-	fsMock.On("FileExists", mock.Anything).Return(false).Once()
-	fsMock.On("Remove", mock.Anything).Return(nil)
+func TestNewParserDiscardsTheCacheOfOtherStrictness(t *testing.T) {
+	fs := NewMemFileSystem("/work")
 
-	parser := NewParser(yamlConfigStub, &clearCacheOpts, fsMock)
-	require.NotNil(t, parser)
+	p := NewParser(yamlConfigStub, &baseOptions, fs)
+	require.Nil(t, p.writeCache())
+	require.True(t, NewParser(yamlConfigStub, &baseOptions, fs).cached)
+	require.False(t, NewParser(yamlConfigStub, &Options{Strict: true}, fs).cached)
 }
 
 func TestTaskParsing(t *testing.T) {
@@ -173,7 +246,7 @@ func TestSetEnvVariables(t *testing.T) {
 		"THOR_CMD": "Hello Thor",
 	}
 
-	got, _ := parser.setEnvVariables(values)
+	got, _ := parser.setEnvVariables(values, nil)
 	require.Equal(t, want["THOR"], os.Getenv("THOR"))
 	require.Equal(t, want["THOR_CMD"], os.Getenv("THOR_CMD"))
 
@@ -181,3 +254,387 @@ func TestSetEnvVariables(t *testing.T) {
 		require.Equal(t, want[k], got[k])
 	}
 }
+
+func TestRunEntryParsing(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(`
+greet-export:
+  run:
+    - "echo 'Hello'"
+    - export:
+        GREETING: "Hello"
+    - cmd: "gofmt -d ."
+      diff_output: true
+`, &clearCacheOpts, fsMock)
+
+	require.Nil(t, parser.parseTasks())
+
+	run := parser.Tasks["greet-export"].Run
+	require.Equal(t, "echo 'Hello'", run[0].Cmd)
+	require.Equal(t, map[string]string{"GREETING": "Hello"}, run[1].Export)
+	require.Equal(t, RunEntry{Cmd: "gofmt -d .", DiffOutput: true}, run[2])
+}
+
+func TestRunEntryParsingInvalidMapping(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(`
+greet-export:
+  run:
+    - foo: "bar"
+`, &clearCacheOpts, fsMock)
+
+	require.NotNil(t, parser.parseTasks())
+}
+
+func TestStructuredRunEntryParsing(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(`
+vars:
+  CGO: "0"
+
+build:
+  run:
+    - "-go vet ./..."
+    - cmd: "go build ./..."
+      env:
+        CGO_ENABLED: "{{.CGO}}"
+      ignore_error: true
+      name: "build api"
+`, &clearCacheOpts, fsMock)
+
+	require.Nil(t, parser.parseGlobal())
+	require.Nil(t, parser.parseTasks())
+
+	run := parser.Tasks["build"].Run
+	require.Equal(t, RunEntry{Cmd: "go vet ./...", IgnoreError: true}, run[0])
+	require.Equal(t, RunEntry{
+		Cmd:         "go build ./...",
+		Env:         map[string]string{"CGO_ENABLED": "0"},
+		IgnoreError: true,
+		Name:        "build api",
+	}, run[1])
+}
+
+func TestStructuredRunEntryOptionsOnlyApplyToCommands(t *testing.T) {
+	var entry RunEntry
+	require.ErrorContains(t, decodeConfig("", "export: {A: b}\nname: a\n", &entry), `"name", "env" and "ignore_error" only apply to "cmd" entries`)
+
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(`
+lint:
+  run:
+    - "go vet"
+
+check:
+  run:
+    - cmd: lint
+      env:
+        GOFLAGS: "-mod=mod"
+`, &clearCacheOpts, fsMock)
+
+	require.EqualError(t, parser.parseTasks(), "task 'check': env doesn't apply to the referenced task 'lint'")
+}
+
+func TestDepsParsing(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(`
+build:
+  run:
+    - "echo 'build'"
+
+test:
+  deps: [build]
+  run:
+    - "echo 'test'"
+`, &clearCacheOpts, fsMock)
+
+	require.Nil(t, parser.parseTasks())
+	require.Equal(t, []string{"build"}, parser.Tasks["test"].Deps)
+}
+
+func TestDepsUnknownTask(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(`
+test:
+  deps: [biuld]
+  run:
+    - "echo 'test'"
+`, &clearCacheOpts, fsMock)
+
+	err := parser.parseTasks()
+	require.EqualError(t, err, "task 'test' depends on unknown task 'biuld'")
+}
+
+func TestDepsCycle(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(`
+a:
+  deps: [b]
+  run:
+    - "echo 'a'"
+
+b:
+  deps: [c]
+  run:
+    - "echo 'b'"
+
+c:
+  deps: [a]
+  run:
+    - "echo 'c'"
+`, &clearCacheOpts, fsMock)
+
+	err := parser.parseTasks()
+	require.EqualError(t, err, "dependency cycle detected: a -> b -> c -> a")
+}
+
+func TestLocalConfigOverridesEnvironmentAndAddsTasks(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(yamlConfigStub, &clearCacheOpts, fsMock)
+	parser.SetLocalConfig("goke.local.yml", `
+global:
+  environment:
+    BAZ: "local-baz"
+
+greet-me:
+  run:
+    - "echo 'Hello me'"
+`)
+
+	require.Nil(t, parser.parseGlobal())
+	require.Equal(t, "local-baz", parser.Global.Shared.Environment["BAZ"])
+	require.Equal(t, "foo", parser.Global.Shared.Environment["FOO"])
+
+	fsMock.On("Glob", mock.Anything).Return([]string{}, nil).Once()
+	require.Nil(t, parser.parseTasks())
+	require.Equal(t, "echo 'Hello me'", parser.Tasks["greet-me"].Run[0].Cmd)
+}
+
+func TestLocalConfigCannotRedefineTasks(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(yamlConfigStub, &clearCacheOpts, fsMock)
+	parser.SetLocalConfig("goke.local.yml", `
+greet-loki:
+  run:
+    - "echo 'Hello Loki'"
+`)
+
+	err := parser.parseTasks()
+	require.NotNil(t, err)
+	require.Contains(t, err.Error(), "task 'greet-loki' defined in goke.local.yml:2 is already defined in")
+	require.Contains(t, err.Error(), ":24")
+}
+
+func TestLocalConfigCannotOverrideEvents(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(yamlConfigStub, &clearCacheOpts, fsMock)
+	parser.SetLocalConfig("goke.local.yml", `
+global:
+  events:
+    before_each_run:
+      - "echo 'local'"
+`)
+
+	require.NotNil(t, parser.parseGlobal())
+}
+
+func TestInputFilesInheritsFromReferencedTasks(t *testing.T) {
+	p := Parser{Tasks: taskList{
+		"ci":    {Name: "ci", InheritFiles: true, Run: []RunEntry{{Cmd: "test"}, {Cmd: "echo done"}}},
+		"test":  {Name: "test", Files: []string{"a_test.go", "a.go"}, Run: []RunEntry{{Cmd: "build"}}},
+		"build": {Name: "build", Files: []string{"a.go", "main.go"}, Run: []RunEntry{{Cmd: "test"}}},
+	}}
+
+	files, origins := p.inputFiles(p.Tasks["ci"])
+
+	require.Equal(t, []string{"a_test.go", "a.go", "main.go"}, files)
+	require.Equal(t, map[string]string{"a_test.go": "test", "a.go": "test", "main.go": "build"}, origins)
+}
+
+func TestInputFilesWithoutInheritance(t *testing.T) {
+	p := Parser{Tasks: taskList{
+		"ci":   {Name: "ci", Run: []RunEntry{{Cmd: "test"}}},
+		"test": {Name: "test", Files: []string{"a_test.go"}},
+	}}
+
+	files, _ := p.inputFiles(p.Tasks["ci"])
+	require.Empty(t, files)
+
+	files, _ = p.inputFiles(p.Tasks["test"])
+	require.Equal(t, []string{"a_test.go"}, files)
+}
+
+func TestEventEntryParsingAndTagWarnings(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(`
+global:
+  events:
+    before_each_task:
+      - "echo 'plain'"
+      - cmd: "docker login"
+        only_tags: [docker]
+      - cmd: "echo 'fast'"
+        except_tags: [slow, dockr]
+image:
+  tags: [docker]
+  run:
+    - "docker build ."
+tests:
+  tags: [slow]
+  run:
+    - "go test ./..."
+`, &clearCacheOpts, fsMock)
+
+	require.Nil(t, parser.parseGlobal())
+	require.Nil(t, parser.parseTasks())
+
+	events := parser.Global.Shared.Events.BeforeEachTask
+	require.Equal(t, EventEntry{Cmd: "echo 'plain'"}, events[0])
+	require.Equal(t, EventEntry{Cmd: "docker login", OnlyTags: []string{"docker"}}, events[1])
+	require.Equal(t, []string{`event "echo 'fast'" selects tag 'dockr', which no task declares`}, parser.Warnings)
+	require.Equal(t, []string{"image"}, parser.tasksWithTag("docker"))
+	require.Empty(t, parser.tasksWithTag("missing"))
+
+	require.True(t, events[1].appliesTo(parser.Tasks["image"]))
+	require.False(t, events[1].appliesTo(parser.Tasks["tests"]))
+	require.True(t, events[2].appliesTo(parser.Tasks["image"]))
+	require.False(t, events[2].appliesTo(parser.Tasks["tests"]))
+}
+
+func TestEventEntryParsingWithoutCmd(t *testing.T) {
+	fsMock := mockCacheDoesNotExist(t)
+	parser := NewParser(`
+global:
+  events:
+    before_each_task:
+      - only_tags: [docker]
+`, &clearCacheOpts, fsMock)
+
+	require.NotNil(t, parser.parseGlobal())
+}
+
+func TestBootstrapReturnsErrorsWhenQuiet(t *testing.T) {
+	fs := NewMemFileSystem("/work")
+	parser := NewParser("build: [", &Options{Quiet: true, ClearCache: true}, fs)
+
+	require.NotNil(t, parser.Bootstrap())
+
+	u, err := user.Current()
+	require.Nil(t, err)
+	require.Nil(t, fs.WriteFile(filepath.Join(u.HomeDir, ".goke"), []byte("{"), 0644))
+
+	lockfile := NewLockfile(nil, &Options{Quiet: true}, fs)
+	require.NotNil(t, lockfile.Bootstrap())
+}
+
+func TestSubstituteSystemCmds(t *testing.T) {
+	tests := []struct {
+		name string
+		str  string
+		want string
+	}{
+		{name: "none", str: "go build", want: "go build"},
+		{name: "single", str: "Hello $(echo a)!", want: "Hello <echo a>!"},
+		{name: "multiple", str: "$(echo a) and $(echo b)", want: "<echo a> and <echo b>"},
+		{name: "adjacent", str: "$(echo a)$(echo b)", want: "<echo a><echo b>"},
+		{name: "nested", str: "cd $(dirname $(pwd))", want: "cd <dirname <pwd>>"},
+		{name: "parentheses", str: "$(expr (1 + 2)) (done)", want: "<expr (1 + 2)> (done)"},
+		{name: "literal dollar", str: "costs $5 and ${HOME} $", want: "costs $5 and ${HOME} $"},
+		{name: "unclosed", str: "$(echo a $(echo b)", want: "$(echo a <echo b>"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := substituteSystemCmds(tt.str, func(cmd string) (string, error) {
+				return "<" + cmd + ">", nil
+			})
+
+			require.Nil(t, err)
+			require.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestResolveEnvVariablesWithMultipleCommands(t *testing.T) {
+	parser := NewParser(yamlConfigStub, &clearCacheOpts, mockCacheDoesNotExist(t))
+
+	got, err := parser.resolveEnvVariables(map[string]string{
+		"GREETING": "$(echo Hello) $(echo Thor)!",
+		"PARENT":   "$(dirname $(echo /usr/lib))",
+	}, nil)
+
+	require.Nil(t, err)
+	require.Equal(t, "Hello Thor!", got["GREETING"])
+	require.Equal(t, "/usr", got["PARENT"])
+}
+
+func TestCommandSubstitutionInFiles(t *testing.T) {
+	t.Setenv("GOKE_FILES_DIR", "cmd")
+
+	env := NewInMemoryEnv(`
+build:
+  files: ["$(echo cmd)/*.go", "${GOKE_FILES_DIR}/*.txt", "$GOKE_FILES_DIR/*.md"]
+  run:
+    - "go build"
+`)
+	require.Nil(t, env.FS.WriteFile("cmd/main.go", []byte("package main"), 0644))
+	require.Nil(t, env.FS.WriteFile("cmd/notes.txt", []byte("notes"), 0644))
+	require.Nil(t, env.FS.WriteFile("cmd/README.md", []byte("# cmd"), 0644))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, []string{"cmd/main.go", "cmd/notes.txt", "cmd/README.md"}, p.Tasks["build"].Files)
+
+	env = NewInMemoryEnv(`
+build:
+  files: ["$(GOKE_FILES_DIR)/*.go"]
+  run:
+    - "go build"
+`)
+	_, err = env.Parse()
+	require.ErrorContains(t, err, `task 'build': files pattern "$(GOKE_FILES_DIR)/*.go": `)
+	require.ErrorContains(t, err, "; $(GOKE_FILES_DIR) runs a command, use ${GOKE_FILES_DIR} for the variable")
+}
+
+func TestCommandSubstitutionInRun(t *testing.T) {
+	t.Setenv("GOKE_RUN_NAME", "Thor")
+
+	env := NewInMemoryEnv(`
+greet:
+  run:
+    - "echo $(whoami) ${GOKE_RUN_NAME} $GOKE_RUN_NAME"
+    - "echo $(GOKE_RUN_NAME)"
+`)
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if cmd.Args[0] == "whoami" {
+			_, err := cmd.Stdout.Write([]byte("loki\n"))
+			return err
+		}
+
+		return nil
+	}
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, "echo $(whoami) ${GOKE_RUN_NAME} $GOKE_RUN_NAME", p.Tasks["greet"].Run[0].Cmd)
+	require.Contains(t, p.Warnings, `task 'greet' runs "echo $(GOKE_RUN_NAME)"; $(GOKE_RUN_NAME) runs a command, use ${GOKE_RUN_NAME} for the variable`)
+
+	require.Nil(t, env.Run("greet"))
+	require.Equal(t, []string{"whoami", "echo loki Thor Thor", "GOKE_RUN_NAME", "echo"}, recordedCommands(env))
+}
+
+func TestTempFileNameOfOtherConfigs(t *testing.T) {
+	fs := NewMemFileSystem("/work")
+	require.Nil(t, fs.WriteFile("/work/goke.yml", []byte(yamlConfigStub), 0644))
+
+	name := func(configPath string) string {
+		p := Parser{fs: fs, options: Options{ConfigPath: configPath}}
+		return p.getTempFileName()
+	}
+
+	require.Equal(t, "goke-v"+cacheVersion+"-work", name(""))
+	require.Equal(t, name(""), name("goke.yml"))
+	require.Equal(t, name(""), name("/work/goke.yml"))
+	require.Equal(t, "goke-v"+cacheVersion+"-work-ci.yml", name("ci.yml"))
+	require.NotEqual(t, name("ci.yml"), name("release.yml"))
+}
@@ -0,0 +1,158 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("run.task_picker")
+}
+
+// Asks which task to run when goke is run without tasks and the config has
+// no main task. The terminal stays in line mode, like for the rerun prompt:
+// text filters the tasks, a number runs the task listed with it, and an
+// empty line or the end of the input quits.
+type taskPicker struct {
+	in  *bufio.Scanner
+	out io.Writer
+}
+
+func newTaskPicker(in io.Reader, out io.Writer) *taskPicker {
+	return &taskPicker{in: bufio.NewScanner(in), out: out}
+}
+
+// Returns the picked task, false when nothing was picked.
+func (tp *taskPicker) pick(tasks []Task) (string, bool) {
+	shown := tasks
+	fmt.Fprintln(tp.out, "No main task, pick one to run:")
+
+	for {
+		writePickerTasks(tp.out, shown)
+		fmt.Fprint(tp.out, "Type to filter, a number to run the task, or Enter to quit: ")
+
+		if !tp.in.Scan() {
+			fmt.Fprintln(tp.out)
+			return "", false
+		}
+
+		answer := strings.TrimSpace(tp.in.Text())
+		if answer == "" {
+			return "", false
+		}
+
+		if n, err := strconv.Atoi(answer); err == nil {
+			if n >= 1 && n <= len(shown) {
+				return shown[n-1].Name, true
+			}
+
+			fmt.Fprintf(tp.out, "No task %d\n", n)
+			continue
+		}
+
+		if shown = filterTasks(tasks, answer); len(shown) == 0 {
+			fmt.Fprintf(tp.out, "No task matches '%s'\n", answer)
+			shown = tasks
+		}
+	}
+}
+
+func writePickerTasks(out io.Writer, tasks []Task) {
+	rows := make([][]string, len(tasks))
+	for i, task := range tasks {
+		rows[i] = []string{"  " + strconv.Itoa(i+1), task.Name, task.Desc}
+	}
+
+	writeTable(out, rows)
+}
+
+// Returns the tasks whose name contains the letters of the filter in order,
+// ignoring case, ie. "dpl" matches "deploy". The ones containing the whole
+// filter come first.
+func filterTasks(tasks []Task, filter string) []Task {
+	filter = strings.ToLower(filter)
+
+	matches := []Task{}
+	for _, task := range tasks {
+		if fuzzyMatch(strings.ToLower(task.Name), filter) {
+			matches = append(matches, task)
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		return strings.Contains(strings.ToLower(matches[i].Name), filter) && !strings.Contains(strings.ToLower(matches[j].Name), filter)
+	})
+
+	return matches
+}
+
+func fuzzyMatch(s string, filter string) bool {
+	for _, r := range filter {
+		i := strings.IndexRune(s, r)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(string(r)):]
+	}
+
+	return true
+}
+
+// Returns the tasks which can be run by name, sorted by name.
+func (p *Parser) RunnableTasks() []Task {
+	tasks := []Task{}
+	for _, name := range sortedKeys(p.Tasks) {
+		if task := p.Tasks[name]; name != "global" && !task.Abstract {
+			tasks = append(tasks, task)
+		}
+	}
+
+	return tasks
+}
+
+// The error of running goke without tasks when the config has no main
+// task, which lists the tasks to run instead.
+func (p *Parser) missingDefaultTask() error {
+	err := &TaskNotFoundError{Task: DefaultTask}
+	for _, task := range p.RunnableTasks() {
+		err.Available = append(err.Available, task.Name)
+	}
+
+	return err
+}
+
+// Without task names and without a main task, asks which task to run when
+// goke runs interactively, see taskPicker. Never asks with --quiet,
+// --no-interactive, --list, --batch or --tag. Returns false
+// when nothing was picked.
+func (e *Executor) pickTask(taskNames []string) ([]string, bool) {
+	o := e.options
+	if len(taskNames) > 0 || o.Quiet || o.NoInteractive || o.List || o.Batch != "" || o.Tag != "" {
+		return taskNames, true
+	}
+
+	tasks := e.parser.RunnableTasks()
+	if _, ok := e.parser.Tasks[DefaultTask]; ok || len(tasks) == 0 {
+		return taskNames, true
+	}
+
+	if e.picker == nil {
+		if !isInteractive() {
+			return taskNames, true
+		}
+
+		e.picker = newTaskPicker(os.Stdin, os.Stderr)
+	}
+
+	name, ok := e.picker.pick(tasks)
+	if !ok {
+		return nil, false
+	}
+
+	return []string{name}, true
+}
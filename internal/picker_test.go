@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const pickerConfig = `
+build:
+  desc: Builds the binary
+  run:
+    - "go build ./..."
+
+deploy:
+  run:
+    - "kubectl apply -f deploy.yml"
+
+test:
+  desc: Runs the tests
+  run:
+    - "go test ./..."
+
+base:
+  abstract: true
+`
+
+// Starts the config without tasks, answering the picker with the input.
+func runWithTaskPicker(t *testing.T, opts Options, input string) (*InMemoryEnv, string, error) {
+	env := NewInMemoryEnv(pickerConfig)
+	env.Options = opts
+
+	p, err := env.Parse()
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	e.picker = newTaskPicker(strings.NewReader(input), &out)
+
+	err = e.StartContext(context.Background(), nil)
+	return env, out.String(), err
+}
+
+func TestTaskPickerRunsThePickedTask(t *testing.T) {
+	env, out, err := runWithTaskPicker(t, Options{}, "dpl\n1\n")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"kubectl apply -f deploy.yml"}, recordedCommands(env))
+	require.Equal(t, `No main task, pick one to run:
+  1  build   Builds the binary
+  2  deploy
+  3  test    Runs the tests
+Type to filter, a number to run the task, or Enter to quit:   1  deploy
+Type to filter, a number to run the task, or Enter to quit: `, out)
+}
+
+func TestTaskPickerQuits(t *testing.T) {
+	for _, input := range []string{"\n", "", "xyz\n4\n"} {
+		env, _, err := runWithTaskPicker(t, Options{}, input)
+
+		require.NoError(t, err)
+		require.Empty(t, recordedCommands(env))
+	}
+}
+
+func TestFilterTasksPrefersWholeMatches(t *testing.T) {
+	t.Parallel()
+
+	tasks := []Task{{Name: "db:seed"}, {Name: "deploy"}, {Name: "e2e"}}
+	names := []string{}
+	for _, task := range filterTasks(tasks, "E") {
+		names = append(names, task.Name)
+	}
+
+	require.Equal(t, []string{"db:seed", "deploy", "e2e"}, names)
+	require.Equal(t, []Task{{Name: "deploy"}, {Name: "db:seed"}}, filterTasks(tasks, "de"))
+}
+
+func TestMissingMainTaskListsTheTasks(t *testing.T) {
+	_, out, err := runWithTaskPicker(t, Options{Quiet: true}, "1\n")
+
+	require.Empty(t, out)
+	require.EqualError(t, err, "task 'main' not found\n\nAvailable tasks: build, deploy, test")
+}
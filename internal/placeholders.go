@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+)
+
+func init() {
+	RegisterCapability("config.placeholders")
+}
+
+// The top-level key holding the placeholders, which is not a task.
+const placeholdersKey = "placeholders"
+
+// The top-level placeholders of the config, see parsePlaceholders.
+type configPlaceholders struct {
+	Placeholders map[string]string `yaml:"placeholders,omitempty"`
+}
+
+var (
+	// Placeholders like {DIST}. They are uppercase, so that they stand out
+	// from the params of tasks and from the braces of other tools, ie.
+	// awk '{print}'. Variables like ${DIST} aren't placeholders.
+	placeholderRegexp     = regexp.MustCompile(`(^|[^$])\{([A-Z][A-Z0-9_]*)\}`)
+	placeholderNameRegexp = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+)
+
+// The placeholders goke replaces itself, which win over the ones of the
+// config.
+var builtinPlaceholders = []string{FilesPlaceholder, FilesSortedPlaceholder, StagedFilesPlaceholder, ChangedFilesFilePlaceholder, ArgsPlaceholder}
+
+// Parses the top-level placeholders, ie. DIST: ./dist for {DIST}. Their
+// values may use variables, which are expanded where they are replaced, but
+// not other placeholders.
+func (p *Parser) parsePlaceholders() error {
+	var c configPlaceholders
+	if err := decodeConfig(p.configFile(), p.config, &c); err != nil {
+		return err
+	}
+
+	placeholders := map[string]string{}
+	for _, name := range sortedKeys(c.Placeholders) {
+		if !placeholderNameRegexp.MatchString(name) {
+			return fmt.Errorf("%s: '%s' must be made of uppercase letters, digits and underscores, ie. DIST", placeholdersKey, name)
+		}
+
+		if containsString(builtinPlaceholders, "{"+name+"}") {
+			p.placeholderWarnings = append(p.placeholderWarnings, fmt.Sprintf("%s: {%s} is built into goke, which replaces it instead", placeholdersKey, name))
+			continue
+		}
+
+		for _, m := range placeholderRegexp.FindAllStringSubmatch(c.Placeholders[name], -1) {
+			if _, ok := c.Placeholders[m[2]]; ok {
+				return fmt.Errorf("%s: %s can't use the placeholder {%s}, placeholders aren't replaced in placeholders", placeholdersKey, name, m[2])
+			}
+		}
+
+		placeholders[name] = c.Placeholders[name]
+	}
+
+	p.Placeholders = placeholders
+	return nil
+}
+
+// Replaces the placeholders of the config in str, after the variables of
+// env were expanded in it. The variables in the values of the placeholders
+// are expanded from env too. Unknown placeholders are left alone, see
+// checkPlaceholders.
+func (p *Parser) replacePlaceholders(str string, env map[string]string) (string, error) {
+	if len(p.Placeholders) == 0 {
+		return str, nil
+	}
+
+	var err error
+	replaced := placeholderRegexp.ReplaceAllStringFunc(str, func(m string) string {
+		sub := placeholderRegexp.FindStringSubmatch(m)
+		value, ok := p.Placeholders[sub[2]]
+		if !ok {
+			return m
+		}
+
+		expanded, expandErr := expandEnv(value, env)
+		if expandErr != nil && err == nil {
+			err = fmt.Errorf("%s: %s: %w", placeholdersKey, sub[2], expandErr)
+		}
+
+		return sub[1] + expanded
+	})
+
+	return replaced, err
+}
+
+// Reports the placeholders in the strings of the task, or of the global
+// events, which are neither built in, declared under placeholders nor params
+// of the task: with --strict, it's an error, otherwise a warning. Only
+// configs with placeholders are checked, since braces are common in commands.
+func (p *Parser) checkPlaceholders(where string, params map[string]string, strs ...string) error {
+	if len(p.Placeholders) == 0 {
+		return nil
+	}
+
+	for _, str := range strs {
+		for _, m := range placeholderRegexp.FindAllStringSubmatch(str, -1) {
+			name := m[2]
+			if _, ok := p.Placeholders[name]; ok || containsString(builtinPlaceholders, "{"+name+"}") {
+				continue
+			}
+
+			if _, ok := params[name]; ok {
+				continue
+			}
+
+			msg := fmt.Sprintf("%s: unknown placeholder {%s} in \"%s\", it isn't under %s", where, name, str, placeholdersKey)
+			if p.options.Strict {
+				return fmt.Errorf("%s", msg)
+			}
+
+			p.placeholderWarnings = append(p.placeholderWarnings, msg)
+		}
+	}
+
+	return nil
+}
+
+// The commands of the task, including its conditions, whose placeholders
+// are replaced when they run.
+func (t Task) placeholderCommands() []string {
+	cmds := []string{t.If, t.Unless}
+	for _, entry := range t.Run {
+		cmds = append(cmds, entry.Cmd)
+	}
+
+	return append(append(cmds, t.OnSuccess...), t.OnFailure...)
+}
+
+// The commands of the global events, see placeholderCommands.
+func (events Events) placeholderCommands() []string {
+	cmds := []string{}
+	for _, entry := range events.all() {
+		cmds = append(cmds, entry.Cmd)
+	}
+
+	return cmds
+}
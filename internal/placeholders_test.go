@@ -0,0 +1,126 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const placeholdersConfig = `
+global:
+  environment:
+    COMPOSE_FILE: deploy/compose.yml
+  events:
+    before_each_task:
+      - "mkdir -p {DIST}"
+
+placeholders:
+  DIST: ./dist
+  COMPOSE: docker compose -f $COMPOSE_FILE
+
+build:
+  files: ["{DIST}/*.txt"]
+  outputs: ["{DIST}/app"]
+  params:
+    target: linux
+  run:
+    - "go build -o {DIST}/app-{target} ./cmd/app"
+    - "{COMPOSE} up -d"
+`
+
+func TestPlaceholdersAreReplaced(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(placeholdersConfig)
+	require.Nil(t, env.FS.WriteFile("/work/dist/notes.txt", []byte("notes"), 0644))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Empty(t, p.Warnings)
+	require.NotContains(t, p.Tasks, placeholdersKey)
+	require.Equal(t, []string{"./dist/*.txt"}, p.Tasks["build"].FilePatterns)
+	require.Equal(t, []string{"./dist/app"}, p.Tasks["build"].Outputs)
+
+	env.Options.Force = true
+	require.Nil(t, env.Run("build"))
+	require.Equal(t, []string{
+		"mkdir -p ./dist",
+		"go build -o ./dist/app-linux ./cmd/app",
+		"docker compose -f deploy/compose.yml up -d",
+	}, recordedCommands(env))
+}
+
+func TestDryRunShowsReplacedPlaceholders(t *testing.T) {
+	out := stubDryRunOutput(t)
+
+	env := NewInMemoryEnv(placeholdersConfig)
+	env.Options.DryRun = true
+	require.Nil(t, env.Run("build"))
+	require.Contains(t, out.String(), "go build -o ./dist/app-linux ./cmd/app\n")
+	require.Contains(t, out.String(), "docker compose -f deploy/compose.yml up -d\n")
+}
+
+func TestBuiltinPlaceholdersWin(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+placeholders:
+  FILES: everything
+  ARGS: --verbose
+
+lint:
+  files: [main.go]
+  run:
+    - "gofmt -l {FILES} {ARGS}"
+`)
+	require.Nil(t, env.FS.WriteFile("/work/main.go", []byte("package main"), 0644))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"placeholders: {ARGS} is built into goke, which replaces it instead",
+		"placeholders: {FILES} is built into goke, which replaces it instead",
+	}, p.Warnings)
+	require.Empty(t, p.Placeholders)
+
+	env.Options.Force = true
+	require.Nil(t, env.Run("lint"))
+	require.Equal(t, []string{"gofmt -l main.go"}, recordedCommands(env))
+}
+
+func TestUnknownPlaceholders(t *testing.T) {
+	t.Parallel()
+
+	config := `
+placeholders:
+  DIST: ./dist
+
+build:
+  run:
+    - "cp app {DSIT}/app"
+    - "awk '{print}' {DIST}/log"
+`
+	p, err := NewInMemoryEnv(config).Parse()
+	require.Nil(t, err)
+	require.Equal(t, []string{`task 'build': unknown placeholder {DSIT} in "cp app {DSIT}/app", it isn't under placeholders`}, p.Warnings)
+
+	env := NewInMemoryEnv(config)
+	env.Options.Strict = true
+	_, err = env.Parse()
+	require.EqualError(t, err, `task 'build': unknown placeholder {DSIT} in "cp app {DSIT}/app", it isn't under placeholders`)
+
+	// Without placeholders, braces are left to the commands.
+	p, err = NewInMemoryEnv("build:\n  run:\n    - \"echo {DSIT}\"\n").Parse()
+	require.Nil(t, err)
+	require.Empty(t, p.Warnings)
+}
+
+func TestInvalidPlaceholders(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewInMemoryEnv("placeholders:\n  OUT: build\n  DIST: \"{OUT}/dist\"\n").Parse()
+	require.EqualError(t, err, "placeholders: DIST can't use the placeholder {OUT}, placeholders aren't replaced in placeholders")
+
+	_, err = NewInMemoryEnv("placeholders:\n  dist: ./dist\n").Parse()
+	require.EqualError(t, err, "placeholders: 'dist' must be made of uppercase letters, digits and underscores, ie. DIST")
+}
@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterCapability("batch.plan")
+}
+
+type (
+	// A sequence of task invocations, read from a YAML (or JSON) file.
+	Plan struct {
+		Steps []PlanStep `yaml:"steps"`
+	}
+
+	PlanStep struct {
+		Task            string            `yaml:"task"`
+		Params          map[string]string `yaml:"params,omitempty"`
+		Env             map[string]string `yaml:"env,omitempty"`
+		ContinueOnError bool              `yaml:"continue_on_error,omitempty"`
+	}
+
+	// The outcome of a single step, as shown in the summary.
+	planResult struct {
+		step     PlanStep
+		result   string
+		duration time.Duration
+	}
+)
+
+// Reads the plan from the given file. Unknown keys are rejected,
+// so that typos don't silently change what runs.
+func ReadPlan(path string) (Plan, error) {
+	var plan Plan
+
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return plan, err
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(contents))
+	decoder.KnownFields(true)
+
+	if err := decoder.Decode(&plan); err != nil && !errors.Is(err, io.EOF) {
+		return plan, fmt.Errorf("invalid plan %s: %w", path, err)
+	}
+
+	return plan, nil
+}
+
+// Ensures that every step of the plan can run: its task is declared, isn't
+// abstract, has commands for this OS and declares the params given to it.
+// All the steps which can't run are reported at once.
+func (p Plan) validate(tasks taskList) error {
+	if len(p.Steps) == 0 {
+		return errors.New("the plan has no steps")
+	}
+
+	var errs ConfigErrors
+	for i, step := range p.Steps {
+		if step.Task == "" {
+			errs = append(errs, fmt.Errorf("step %d of the plan has no task", i+1))
+			continue
+		}
+
+		task, ok := tasks[step.Task]
+		if !ok || step.Task == "global" {
+			errs = append(errs, fmt.Errorf("step %d of the plan refers to unknown task '%s'", i+1, step.Task))
+			continue
+		}
+
+		if task.Abstract {
+			errs = append(errs, fmt.Errorf("step %d of the plan: task '%s' is abstract, run one of the tasks extending it", i+1, step.Task))
+			continue
+		}
+
+		if err := task.checkPlatform(); err != nil {
+			errs = append(errs, fmt.Errorf("step %d of the plan: %w", i+1, err))
+			continue
+		}
+
+		if err := checkGivenParams(step.Task, task, step.Params); err != nil {
+			errs = append(errs, fmt.Errorf("step %d of the plan: %w", i+1, err))
+		}
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+
+	return nil
+}
+
+// Describes the task of the step with its params, ie. "deploy env=prod".
+func (s PlanStep) taskString() string {
+	invocation := []string{s.Task}
+	for _, k := range sortedKeys(s.Params) {
+		invocation = append(invocation, k+"="+s.Params[k])
+	}
+
+	return strings.Join(invocation, " ")
+}
+
+// Describes the step's variable overrides, ie. "GOARCH=arm64 GOOS=linux".
+func (s PlanStep) envString() string {
+	vars := []string{}
+	for _, k := range sortedKeys(s.Env) {
+		vars = append(vars, k+"="+s.Env[k])
+	}
+
+	return strings.Join(vars, " ")
+}
+
+// Prints one line per step with its result and duration.
+func printPlanSummary(out io.Writer, results []planResult) {
+	rows := [][]string{}
+
+	for i, r := range results {
+		duration := ""
+		if r.duration > 0 {
+			duration = r.duration.Round(time.Millisecond).String()
+		}
+
+		rows = append(rows, []string{fmt.Sprint(i + 1), r.step.taskString(), r.step.envString(), r.result, duration})
+	}
+
+	writeTable(out, rows)
+}
@@ -0,0 +1,185 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func writePlan(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "plan.yml")
+	require.Nil(t, os.WriteFile(path, []byte(contents), 0644))
+
+	return path
+}
+
+func TestReadPlan(t *testing.T) {
+	path := writePlan(t, `
+steps:
+  - task: build
+    env:
+      GOOS: linux
+  - task: publish
+    continue_on_error: true
+`)
+
+	plan, err := ReadPlan(path)
+
+	require.Nil(t, err)
+	require.Equal(t, Plan{Steps: []PlanStep{
+		{Task: "build", Env: map[string]string{"GOOS": "linux"}},
+		{Task: "publish", ContinueOnError: true},
+	}}, plan)
+}
+
+func TestReadPlanAcceptsJson(t *testing.T) {
+	path := writePlan(t, `{"steps": [{"task": "build", "env": {"GOOS": "darwin"}}]}`)
+
+	plan, err := ReadPlan(path)
+
+	require.Nil(t, err)
+	require.Equal(t, "GOOS=darwin", plan.Steps[0].envString())
+}
+
+func TestReadPlanRejectsUnknownKeys(t *testing.T) {
+	_, err := ReadPlan(writePlan(t, "steps:\n  - task: build\n    contine_on_error: true\n"))
+
+	require.ErrorContains(t, err, "field contine_on_error not found")
+}
+
+func TestPlanValidate(t *testing.T) {
+	tasks := taskList{"build": {Name: "build"}}
+
+	require.EqualError(t, Plan{}.validate(tasks), "the plan has no steps")
+	require.EqualError(t, Plan{Steps: []PlanStep{{Task: "build"}, {}}}.validate(tasks), "step 2 of the plan has no task")
+	require.EqualError(t, Plan{Steps: []PlanStep{{Task: "deploy"}}}.validate(tasks), "step 1 of the plan refers to unknown task 'deploy'")
+	require.Nil(t, Plan{Steps: []PlanStep{{Task: "build"}}}.validate(tasks))
+}
+
+func TestPlanValidateReportsEveryStepWhichCantRun(t *testing.T) {
+	tasks := taskList{
+		"build":  {Name: "build"},
+		"base":   {Name: "base", Abstract: true},
+		"deploy": {Name: "deploy", Params: map[string]string{"env": "staging"}},
+	}
+
+	plan := Plan{Steps: []PlanStep{
+		{Task: "base"},
+		{Task: "build"},
+		{Task: "global"},
+		{Task: "deploy", Params: map[string]string{"region": "eu"}},
+		{Task: "build", Params: map[string]string{"env": "prod"}},
+		{Task: "deploy", Params: map[string]string{"env": "prod"}},
+	}}
+
+	require.EqualError(t, plan.validate(tasks), strings.Join([]string{
+		"step 1 of the plan: task 'base' is abstract, run one of the tasks extending it",
+		"step 3 of the plan refers to unknown task 'global'",
+		"step 4 of the plan: task 'deploy' has no param region, it has: env",
+		"step 5 of the plan: task 'build' has no params, but was given env",
+	}, "\n"))
+}
+
+func TestPrintPlanSummary(t *testing.T) {
+	out := bytes.Buffer{}
+	printPlanSummary(&out, []planResult{
+		{step: PlanStep{Task: "build", Env: map[string]string{"GOOS": "linux"}}, result: "success", duration: 1500 * time.Millisecond},
+		{step: PlanStep{Task: "publish", Params: map[string]string{"to": "s3"}}, result: "not run"},
+	})
+
+	require.Equal(t, "1  build          GOOS=linux  success  1.5s\n2  publish to=s3              not run\n", out.String())
+}
+
+func TestExecutePlan(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.txt")
+	config := fmt.Sprintf(`
+build:
+  run:
+    - "sh -c 'echo build $GOOS >> %[1]s'"
+
+lint:
+  run:
+    - "false"
+
+publish:
+  run:
+    - "sh -c 'echo publish >> %[1]s'"
+`, out)
+
+	path := writePlan(t, `
+steps:
+  - task: build
+    env: {GOOS: linux}
+  - task: build
+    env: {GOOS: darwin}
+  - task: lint
+    continue_on_error: true
+  - task: publish
+`)
+
+	e := newTestExecutor(t, config)
+	err := e.executePlan(path)
+
+	require.Nil(t, err)
+	require.Equal(t, "build linux\nbuild darwin\npublish\n", readOutputFile(t, out))
+}
+
+func TestExecutePlanStopsAtFailure(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.txt")
+	config := fmt.Sprintf(`
+lint:
+  run:
+    - "false"
+
+publish:
+  run:
+    - "sh -c 'echo publish >> %[1]s'"
+`, out)
+
+	path := writePlan(t, "steps:\n  - task: lint\n  - task: publish\n")
+
+	e := newTestExecutor(t, config)
+	err := e.executePlan(path)
+
+	require.EqualError(t, err, `step 1 (lint) failed: "false" failed: exit status 1`)
+	require.NoFileExists(t, out)
+}
+
+func TestExecutePlanGivesParamsAndEnvToEverythingTheStepRuns(t *testing.T) {
+	env := NewInMemoryEnv(`
+deploy:
+  params:
+    env: staging
+  run:
+    - "ship {env}"
+    - goke: services/api
+      task: publish
+`)
+	require.Nil(t, env.FS.WriteFile("services/api/goke.yml", []byte("publish:\n  run:\n    - \"upload\"\n"), 0644))
+	path := writePlan(t, `
+steps:
+  - task: deploy
+    params: {env: prod}
+    env: {REGION: eu}
+  - task: deploy
+`)
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+
+	require.Nil(t, e.executePlan(path))
+
+	commands := env.Runner.Commands()
+	require.Equal(t, []string{"ship prod", "upload", "ship staging", "upload"}, recordedCommands(env))
+	require.Contains(t, commands[0].Env, "REGION=eu")
+	require.Contains(t, commands[1].Env, "REGION=eu")
+	require.NotContains(t, commands[3].Env, "REGION=eu")
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func init() {
+	RegisterCapability("task.platform_run", "events.platform")
+}
+
+// The commands of the task for the operating system, ie. run_windows on
+// Windows, or run when the task has none for it.
+func (t Task) platformRun(goos string) []RunEntry {
+	var entries []RunEntry
+	switch goos {
+	case "windows":
+		entries = t.RunWindows
+	case "darwin":
+		entries = t.RunDarwin
+	case "linux":
+		entries = t.RunLinux
+	}
+
+	if entries != nil {
+		return entries
+	}
+
+	return t.Run
+}
+
+// Fails when the task only has commands for other operating systems, see
+// platformRun.
+func (t Task) checkPlatform() error {
+	if len(t.Run) == 0 && (len(t.RunWindows) != 0 || len(t.RunDarwin) != 0 || len(t.RunLinux) != 0) {
+		return fmt.Errorf("task '%s' has no commands for %s", t.Name, runtime.GOOS)
+	}
+
+	return nil
+}
+
+// The events for the operating system: the ones of before_each_run_windows
+// and the like replace the ones of the same event when it's Windows.
+func (events Events) forOS(goos string) Events {
+	lists := map[string][4][]EventEntry{
+		"windows": {events.BeforeEachRunWindows, events.AfterEachRunWindows, events.BeforeEachTaskWindows, events.AfterEachTaskWindows},
+		"darwin":  {events.BeforeEachRunDarwin, events.AfterEachRunDarwin, events.BeforeEachTaskDarwin, events.AfterEachTaskDarwin},
+		"linux":   {events.BeforeEachRunLinux, events.AfterEachRunLinux, events.BeforeEachTaskLinux, events.AfterEachTaskLinux},
+	}[goos]
+
+	selected := Events{
+		BeforeEachRun:  events.BeforeEachRun,
+		AfterEachRun:   events.AfterEachRun,
+		BeforeEachTask: events.BeforeEachTask,
+		AfterEachTask:  events.AfterEachTask,
+	}
+
+	for i, list := range []*[]EventEntry{&selected.BeforeEachRun, &selected.AfterEachRun, &selected.BeforeEachTask, &selected.AfterEachTask} {
+		if lists[i] != nil {
+			*list = lists[i]
+		}
+	}
+
+	return selected
+}
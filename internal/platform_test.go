@@ -0,0 +1,69 @@
+package internal
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// An operating system with a platform list which isn't the current one.
+func otherOS(t *testing.T) string {
+	switch runtime.GOOS {
+	case "windows":
+		return "linux"
+	case "darwin", "linux":
+		return "windows"
+	}
+
+	t.Skip("no platform lists for " + runtime.GOOS)
+	return ""
+}
+
+func TestPlatformRun(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(fmt.Sprintf(`
+global:
+  events:
+    before_each_task:
+      - "echo before"
+    before_each_task_%[1]s:
+      - "echo before on %[1]s"
+    after_each_task_%[2]s:
+      - "echo after on %[2]s"
+
+clean:
+  run:
+    - "rm -rf dist"
+  run_%[1]s:
+    - "clean dist on %[1]s"
+
+build:
+  run:
+    - "go build ./..."
+  run_%[2]s:
+    - "build on %[2]s"
+`, runtime.GOOS, otherOS(t)))
+	env.Options.Force = true
+
+	require.Nil(t, env.Run("clean", "build"))
+	require.Equal(t, []string{
+		"echo before on " + runtime.GOOS,
+		"clean dist on " + runtime.GOOS,
+		"echo before on " + runtime.GOOS,
+		"go build ./...",
+	}, recordedCommands(env))
+}
+
+func TestPlatformRunWithoutCommandsForTheOS(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(fmt.Sprintf("clean:\n  run_%s:\n    - \"Remove-Item -Recurse dist\"\n", otherOS(t)))
+	env.Options.Force = true
+
+	err := env.Run("clean")
+	require.EqualError(t, err, "task 'clean' has no commands for "+runtime.GOOS)
+	require.Empty(t, recordedCommands(env))
+}
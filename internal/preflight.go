@@ -0,0 +1,179 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("task.preflight")
+}
+
+// Commands which are built into the shell, see isShellWord.
+var shellBuiltins = []string{".", ":", "[", "cd", "exit", "export", "set", "source", "test", "unset"}
+
+// Finds out which binaries used by tasks are missing, before running them.
+// Lookups are cached, so that every binary is looked up in PATH only once.
+type preflight struct {
+	parser  *Parser
+	found   map[string]bool
+	visited map[string]bool
+
+	// Binaries which were not found, along with the tasks using them.
+	missing map[string][]string
+
+	// Commands which can't be checked, since they are only known at runtime.
+	skipped []string
+}
+
+func newPreflight(p *Parser) *preflight {
+	return &preflight{
+		parser:  p,
+		found:   make(map[string]bool),
+		visited: make(map[string]bool),
+		missing: make(map[string][]string),
+	}
+}
+
+// Checks the commands of the task, of the tasks it references or depends on,
+// and of the events which run along with it.
+func (pf *preflight) checkTask(task Task) {
+	if pf.visited[task.Name] {
+		return
+	}
+	pf.visited[task.Name] = true
+
+	for _, dep := range task.Deps {
+		pf.checkTask(pf.parser.Tasks[dep])
+	}
+
+	events := pf.parser.taskEvents(task)
+	for _, ev := range events.all() {
+		if ev.appliesTo(task) {
+			pf.checkEntry(task, pf.parser.hookEntry(ev.Cmd))
+		}
+	}
+
+	// Subprojects run the preflight checks of their own tasks.
+	for _, entry := range task.Run {
+		if len(entry.Export) > 0 || entry.Goke != "" {
+			continue
+		}
+
+		if entry.Dir == "" {
+			entry.Dir = task.Dir
+		}
+
+		entry.shell = pf.parser.usesShell(task)
+		pf.checkEntry(task, entry)
+	}
+}
+
+func (pf *preflight) checkEntry(task Task, entry RunEntry) {
+	if ref, ok := pf.parser.Tasks[entry.Cmd]; ok {
+		pf.checkTask(ref)
+		return
+	}
+
+	words, err := splitCommand(entry.Cmd)
+	if err != nil || strings.ContainsAny(words[0], "${}") || (entry.shell && isShellWord(words[0])) {
+		pf.skipped = append(pf.skipped, entry.Cmd)
+		return
+	}
+
+	if _, ok, _ := lookupBuiltin(words[0]); ok {
+		return
+	}
+
+	bin := words[0]
+	if isPath(bin) {
+		bin = joinDir(entry.Dir, bin)
+	}
+
+	if !pf.lookup(bin) {
+		for _, name := range pf.missing[bin] {
+			if name == task.Name {
+				return
+			}
+		}
+
+		pf.missing[bin] = append(pf.missing[bin], task.Name)
+	}
+}
+
+func (pf *preflight) lookup(bin string) bool {
+	if found, ok := pf.found[bin]; ok {
+		return found
+	}
+
+	var err error
+	if isPath(bin) {
+		_, err = os.Stat(bin)
+	} else {
+		_, err = exec.LookPath(bin)
+	}
+
+	found := err == nil
+
+	pf.found[bin] = found
+	return found
+}
+
+// Whether the first word of a shell command is not a binary, ie. a builtin
+// or a variable assignment, which can't be looked up in the PATH.
+func isShellWord(word string) bool {
+	for _, builtin := range shellBuiltins {
+		if word == builtin {
+			return true
+		}
+	}
+
+	return strings.Contains(word, "=") || strings.ContainsAny(word, "|&;<>()")
+}
+
+// Whether the binary is given as a path, ie. "./gradlew", instead of a name.
+func isPath(bin string) bool {
+	return strings.ContainsAny(bin, "/"+string(filepath.Separator))
+}
+
+// Logs the commands which could not be checked.
+func (pf *preflight) logSkipped(e *Executor) {
+	for _, cmd := range pf.skipped {
+		e.logVerbose(fmt.Sprintf("Preflight skipped, resolved at runtime: %s", cmd))
+	}
+}
+
+// Checks the binaries used by all the tasks without running anything, like
+// "goke doctor --tools", and fails with all the missing ones. Also returns
+// the commands which can't be checked, since they are only known at runtime.
+func (p *Parser) CheckTools() ([]string, error) {
+	pf := p.preflightAll()
+	return pf.skipped, pf.err()
+}
+
+// Checks the commands of all the tasks.
+func (p *Parser) preflightAll() *preflight {
+	pf := newPreflight(p)
+	for _, name := range sortedKeys(p.Tasks) {
+		pf.checkTask(p.Tasks[name])
+	}
+
+	return pf
+}
+
+// Reports all the missing binaries at once, or nil if none are missing.
+func (pf *preflight) err() error {
+	if len(pf.missing) == 0 {
+		return nil
+	}
+
+	lines := []string{"missing binaries:"}
+	for _, bin := range sortedKeys(pf.missing) {
+		lines = append(lines, fmt.Sprintf("  %s (used by %s)", bin, strings.Join(pf.missing[bin], ", ")))
+	}
+
+	return fmt.Errorf("%s", strings.Join(lines, "\n"))
+}
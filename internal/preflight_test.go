@@ -0,0 +1,104 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPreflightReportsAllMissingBinaries(t *testing.T) {
+	config := `
+global:
+  events:
+    after_each_task:
+      - "goke-missing-hook"
+
+release:
+  deps: [build]
+  run:
+    - export:
+        VERSION: "1.0.0"
+    - "sh -c 'echo releasing'"
+    - "publish"
+    - "${TOOL} --version"
+
+build:
+  run:
+    - "goke-missing-compiler main.c"
+
+publish:
+  run:
+    - "goke-missing-uploader dist"
+    - "./goke-missing-script.sh"
+`
+
+	e := newTestExecutor(t, config)
+	require.Nil(t, e.parser.parseGlobal())
+
+	pf := newPreflight(&e.parser)
+	pf.checkTask(e.parser.Tasks["release"])
+
+	require.EqualError(t, pf.err(), `missing binaries:
+  ./goke-missing-script.sh (used by publish)
+  goke-missing-compiler (used by build)
+  goke-missing-hook (used by build, release, publish)
+  goke-missing-uploader (used by publish)`)
+	require.Equal(t, []string{"${TOOL} --version"}, pf.skipped)
+}
+
+func TestPreflightSkipsShellBuiltins(t *testing.T) {
+	config := `
+build:
+  shell: true
+  run:
+    - "cd web && npm run build"
+    - "GOOS=linux go build"
+    - "goke-missing-bundler | tee out.log"
+`
+
+	e := newTestExecutor(t, config)
+	pf := newPreflight(&e.parser)
+	pf.checkTask(e.parser.Tasks["build"])
+
+	require.EqualError(t, pf.err(), "missing binaries:\n  goke-missing-bundler (used by build)")
+	require.Equal(t, []string{"cd web && npm run build", "GOOS=linux go build"}, pf.skipped)
+}
+
+func TestPreflightCachesLookups(t *testing.T) {
+	pf := newPreflight(&Parser{})
+
+	require.True(t, pf.lookup("sh"))
+	require.False(t, pf.lookup("goke-missing-binary"))
+	require.Equal(t, map[string]bool{"sh": true, "goke-missing-binary": false}, pf.found)
+}
+
+func TestExecuteRunsPreflightFirst(t *testing.T) {
+	config := `
+release:
+  preflight: true
+  run:
+    - "false"
+    - "goke-missing-uploader dist"
+`
+
+	e := newTestExecutor(t, config)
+	err := e.execute([]string{"release"})
+
+	require.EqualError(t, err, "missing binaries:\n  goke-missing-uploader (used by release)")
+}
+
+func TestCheckToolsChecksAllTasks(t *testing.T) {
+	e := newTestExecutor(t, `
+build:
+  run:
+    - "goke-missing-compiler main.c"
+    - "${CC} main.c"
+lint:
+  run:
+    - "goke-missing-linter ./..."
+`)
+
+	skipped, err := e.parser.CheckTools()
+	require.EqualError(t, err, "missing binaries:\n  goke-missing-compiler (used by build)\n  goke-missing-linter (used by lint)")
+	require.Equal(t, []string{"${CC} main.c"}, skipped)
+}
@@ -0,0 +1,125 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterCapability("watch.restart")
+}
+
+// How long stopped processes get to exit after SIGTERM, before being killed.
+const restartGracePeriod = 5 * time.Second
+
+var errProcessesStopped = errors.New("processes were stopped")
+
+// Keeps track of the processes started during a run, so that they can be
+// stopped when a restarting task gets rerun. Each process runs in its own
+// process group, so that its children (ie. the binary built by "go run")
+// are stopped along with it.
+type processes struct {
+	mu      sync.Mutex
+	running map[*exec.Cmd]chan struct{}
+	stopped bool
+}
+
+func newProcesses() *processes {
+	return &processes{running: make(map[*exec.Cmd]chan struct{})}
+}
+
+// Starts the command and waits for it to exit. Commands started after
+// stop was called are refused, so that a stopped run doesn't continue.
+func (p *processes) run(cmd *exec.Cmd) error {
+	p.mu.Lock()
+	if p.stopped {
+		p.mu.Unlock()
+		return errProcessesStopped
+	}
+
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		p.mu.Unlock()
+		return err
+	}
+
+	exited := make(chan struct{})
+	p.running[cmd] = exited
+	p.mu.Unlock()
+
+	err := cmd.Wait()
+
+	p.mu.Lock()
+	delete(p.running, cmd)
+	close(exited)
+	p.mu.Unlock()
+
+	return err
+}
+
+// Sends SIGTERM to the running processes, and kills the ones which
+// are still running once the grace period is over. Processes which
+// already exited on their own are not tracked anymore, so they are skipped.
+func (p *processes) stop(grace time.Duration) {
+	p.stopWith(terminateProcess, grace)
+}
+
+// Same as stop, but the processes get the signal instead of SIGTERM, ie.
+// the Ctrl-C goke received, see runInterrupt.
+func (p *processes) interrupt(sig os.Signal, grace time.Duration) {
+	p.stopWith(func(cmd *exec.Cmd) error { return signalProcess(cmd, sig) }, grace)
+}
+
+func (p *processes) stopWith(send func(cmd *exec.Cmd) error, grace time.Duration) {
+	p.mu.Lock()
+	p.stopped = true
+
+	running := make(map[*exec.Cmd]chan struct{}, len(p.running))
+	for cmd, exited := range p.running {
+		running[cmd] = exited
+		_ = send(cmd)
+	}
+	p.mu.Unlock()
+
+	deadline := time.After(grace)
+	for cmd, exited := range running {
+		select {
+		case <-exited:
+		case <-deadline:
+			// Once the deadline fired, every remaining process is killed.
+			deadline = closedTimeChan()
+			_ = killProcess(cmd)
+			<-exited
+		}
+	}
+}
+
+// Stops a single command like stop does, leaving the other ones running.
+func (p *processes) stopCommand(cmd *exec.Cmd, grace time.Duration) {
+	p.mu.Lock()
+	exited, ok := p.running[cmd]
+	if ok {
+		_ = terminateProcess(cmd)
+	}
+	p.mu.Unlock()
+
+	if !ok {
+		return
+	}
+
+	select {
+	case <-exited:
+	case <-time.After(grace):
+		_ = killProcess(cmd)
+		<-exited
+	}
+}
+
+func closedTimeChan() <-chan time.Time {
+	ch := make(chan time.Time)
+	close(ch)
+	return ch
+}
@@ -0,0 +1,57 @@
+package internal
+
+import (
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Starts the command in the background and waits until it is tracked.
+func runInBackground(t *testing.T, p *processes, cmd *exec.Cmd) chan error {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on POSIX signals")
+	}
+
+	errCh := make(chan error, 1)
+	go func() { errCh <- p.run(cmd) }()
+
+	require.Eventually(t, func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		return len(p.running) == 1
+	}, time.Second, 10*time.Millisecond)
+
+	return errCh
+}
+
+func TestProcessesStopTerminates(t *testing.T) {
+	p := newProcesses()
+	errCh := runInBackground(t, p, exec.Command("sleep", "30"))
+
+	start := time.Now()
+	p.stop(10 * time.Second)
+
+	require.Error(t, <-errCh)
+	require.Less(t, time.Since(start), 5*time.Second)
+}
+
+func TestProcessesStopKillsAfterGracePeriod(t *testing.T) {
+	p := newProcesses()
+	errCh := runInBackground(t, p, exec.Command("sh", "-c", "trap '' TERM; sleep 30"))
+
+	p.stop(100 * time.Millisecond)
+
+	require.Error(t, <-errCh)
+}
+
+func TestProcessesStopAfterProcessExited(t *testing.T) {
+	p := newProcesses()
+	require.Nil(t, p.run(exec.Command("true")))
+
+	p.stop(time.Second)
+
+	require.Equal(t, errProcessesStopped, p.run(exec.Command("true")))
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package internal
+
+import (
+	"os"
+	"os/exec"
+)
+
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// Windows has no SIGTERM, so processes are killed right away.
+func terminateProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func killProcess(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// Whether a process with the pid exists, which FindProcess checks on Windows.
+func isProcessAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	_ = p.Release()
+	return true
+}
+
+// Windows can't send signals to processes, so they are killed right away.
+func signalProcess(cmd *exec.Cmd, sig os.Signal) error {
+	return cmd.Process.Kill()
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+func init() {
+	RegisterCapability("run.progress")
+}
+
+// Where the progress of the steps is reported, replaced in tests.
+var reportProgress = func(e *Executor, message string) {
+	if !e.options.Quiet {
+		e.spinnerMessage(message)
+	}
+}
+
+// The progress of a run across the commands of all the tasks it's planned
+// to run, including the ones of dependencies, referenced tasks and events,
+// see planSteps. The total stays the same while nested tasks run, and only
+// grows when the run turns out to have more steps, ie. when {FILES} gets
+// split into batches. The steps of skipped tasks count as done.
+type runProgress struct {
+	mu    sync.Mutex
+	done  int
+	total int
+
+	// The steps added since the last one was reported.
+	added int
+}
+
+func newRunProgress(total int) *runProgress {
+	return &runProgress{total: total}
+}
+
+// Counts the next step and renders it, ie. "step 14/36 · build › go vet".
+func (p *runProgress) next(label string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	if p.done > p.total {
+		p.added += p.done - p.total
+		p.total = p.done
+	}
+
+	message := fmt.Sprintf("step %d/%d", p.done, p.total)
+	if p.added > 0 {
+		message += fmt.Sprintf(" (%d more)", p.added)
+		p.added = 0
+	}
+
+	return message + " · " + label
+}
+
+// Counts the steps of a skipped task as done.
+func (p *runProgress) skip(steps int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done += steps
+	if p.done > p.total {
+		p.total = p.done
+	}
+}
+
+// Adds steps found while running.
+func (p *runProgress) grow(steps int) {
+	if steps <= 0 {
+		return
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.total += steps
+	p.added += steps
+}
+
+// Counts the next step of the run and reports it. Without progress, ie.
+// with --jobs, the command is reported on its own.
+func (e *Executor) reportStep(cmd string) {
+	if e.progress == nil {
+		reportProgress(e, fmt.Sprintf("Running: %s", cmd))
+		return
+	}
+
+	reportProgress(e, e.progress.next(strings.Join(append(append([]string{}, e.taskChain...), cmd), " › ")))
+}
+
+// Counts the steps of a task which doesn't run as done, given the
+// dependencies resolved so far, and the task as skipped in the report.
+func (e *Executor) skipSteps(task Task, resolved map[string]bool, initialRun bool) {
+	e.report.taskSkipped()
+
+	if e.progress != nil {
+		c := stepCounter{parser: &e.parser, force: e.options.Force, bare: e.options.Bare, resolved: resolved}
+		e.progress.skip(c.taskSteps(task, initialRun))
+	}
+}
+
+// Counts the commands the given tasks run, the way executeTasks runs them,
+// assuming that every task runs.
+func (e *Executor) planSteps(taskNames []string) int {
+	steps := 0
+	for _, name := range taskNames {
+		c := stepCounter{parser: &e.parser, force: e.options.Force, bare: e.options.Bare, resolved: make(map[string]bool)}
+		steps += c.taskSteps(e.parser.Tasks[name], true)
+	}
+
+	return steps
+}
+
+// Counts the commands of tasks, see planSteps.
+type stepCounter struct {
+	parser *Parser
+	force  bool
+
+	// Without events, see Executor.taskEvents.
+	bare bool
+
+	// The dependencies which already ran, shared with the tasks the
+	// counted task references, like Executor.resolved.
+	resolved map[string]bool
+
+	// The tasks being counted. A task referencing itself, directly or
+	// not, only runs until something stops it, so its steps are unknown.
+	counting map[string]bool
+}
+
+// Counts the commands of the task, the way dispatchTask runs them.
+func (c *stepCounter) taskSteps(task Task, initialRun bool) int {
+	if c.counting == nil {
+		c.counting = make(map[string]bool)
+	}
+
+	if c.counting[task.Name] {
+		return 0
+	}
+
+	c.counting[task.Name] = true
+	defer delete(c.counting, task.Name)
+
+	steps := 0
+
+	for _, dep := range task.Deps {
+		if !c.resolved[dep] {
+			c.resolved[dep] = true
+			steps += c.taskSteps(c.parser.Tasks[dep], false)
+		}
+	}
+
+	hooks := func(events []EventEntry) int {
+		n := 0
+		for _, ev := range events {
+			if ev.appliesTo(task) {
+				n += c.entrySteps(c.parser.hookEntry(ev.Cmd).Cmd)
+			}
+		}
+
+		return n
+	}
+
+	events := Events{}
+	if !c.bare {
+		events = c.parser.taskEvents(task)
+	}
+
+	runHooks := 0
+	if initialRun {
+		steps += hooks(events.BeforeEachTask)
+		runHooks = hooks(events.BeforeEachRun) + hooks(events.AfterEachRun)
+	}
+
+	if task.Parallel {
+		steps += runHooks + len(task.Run)
+	} else {
+		for _, entry := range task.Run {
+			if len(entry.Export) > 0 {
+				continue
+			}
+
+			steps += runHooks
+
+			if _, ok := c.parser.Tasks[entry.Cmd]; ok && c.resolved[entry.Cmd] && !c.force {
+				continue
+			}
+
+			steps += c.entrySteps(entry.Cmd)
+		}
+	}
+
+	return steps + hooks(events.AfterEachTask)
+}
+
+// Counts the commands of a run entry: the ones of the task it references,
+// or else the command itself.
+func (c *stepCounter) entrySteps(cmd string) int {
+	if task, ok := c.parser.Tasks[cmd]; ok {
+		return c.taskSteps(task, false)
+	}
+
+	return 1
+}
+
+func copyResolved(resolved map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(resolved))
+	for k, v := range resolved {
+		c[k] = v
+	}
+
+	return c
+}
@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func stubReportProgress(t *testing.T) *[]string {
+	messages := &[]string{}
+	orig := reportProgress
+	reportProgress = func(e *Executor, message string) {
+		*messages = append(*messages, message)
+	}
+	t.Cleanup(func() { reportProgress = orig })
+
+	return messages
+}
+
+const progressConfig = `
+global:
+  events:
+    before_each_task:
+      - "echo starting"
+    after_each_run:
+      - "notify"
+
+lint:
+  run:
+    - "go vet"
+
+generate:
+  run:
+    - "go generate"
+
+build:
+  deps: [lint]
+  run:
+    - "generate"
+    - "go build"
+    - "lint"
+
+release:
+  deps: [build]
+  run:
+    - "goreleaser"
+
+notify:
+  run:
+    - "echo done"
+`
+
+func TestProgressFollowsThePlan(t *testing.T) {
+	messages := stubReportProgress(t)
+	env := NewInMemoryEnv(progressConfig)
+	env.Options.Force = true
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	require.Equal(t, 7, e.planSteps([]string{"release"}))
+	require.Nil(t, e.Start([]string{"release"}))
+
+	// Forced, the referenced lint runs again after running as a dependency.
+	require.Equal(t, []string{
+		"step 1/7 · release › build › lint › go vet",
+		"step 2/7 · release › build › generate › go generate",
+		"step 3/7 · release › build › go build",
+		"step 4/7 · release › build › lint › go vet",
+		"step 5/7 · release › echo starting",
+		"step 6/7 · release › goreleaser",
+		"step 7/7 · release › notify › echo done",
+	}, *messages)
+	require.Len(t, recordedCommands(env), 7)
+}
+
+func TestProgressCountsSkippedTasksAsDone(t *testing.T) {
+	messages := stubReportProgress(t)
+	env := NewInMemoryEnv(`
+lint:
+  when: os() == "plan9"
+  run:
+    - "go vet"
+    - "staticcheck"
+
+test:
+  run:
+    - "go test"
+`)
+	env.Options.Force = true
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	require.Nil(t, e.Start([]string{"lint", "test"}))
+
+	require.Equal(t, []string{"step 3/3 · test › go test"}, *messages)
+}
+
+func TestProgressGrowsWithBatches(t *testing.T) {
+	orig := maxCommandLength
+	maxCommandLength = 30
+	t.Cleanup(func() { maxCommandLength = orig })
+
+	messages := stubReportProgress(t)
+	env := NewInMemoryEnv(`
+fmt:
+  files: [src/*.go]
+  run:
+    - "gofmt -l {FILES}"
+    - "go vet"
+`)
+	env.Options.Force = true
+	for _, f := range []string{"first.go", "second.go", "third.go"} {
+		require.Nil(t, env.FS.WriteFile("/work/src/"+f, []byte("package src"), 0644))
+	}
+
+	require.Nil(t, env.Run("fmt"))
+
+	require.Equal(t, []string{
+		"step 1/4 (2 more) · fmt › gofmt -l src/first.go",
+		"step 2/4 · fmt › gofmt -l src/second.go",
+		"step 3/4 · fmt › gofmt -l src/third.go",
+		"step 4/4 · fmt › go vet",
+	}, *messages)
+}
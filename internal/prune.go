@@ -0,0 +1,303 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterCapability("config.prune_tasks", "command.prune-tasks")
+}
+
+// How long a task must not have run successfully to be reported by
+// "goke prune-tasks", unless --unused-for says otherwise.
+const DefaultUnusedFor = 90 * 24 * time.Hour
+
+// A task nothing runs anymore, see PruneCandidates.
+type PruneCandidate struct {
+	Name     string `json:"name"`
+	Location string `json:"location"`
+
+	// The last successful run of the task, nil when it never ran.
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+
+	// The metadata of the goke invocation which last ran the task
+	// successfully, nil when it's unknown.
+	LastRun *RunMetadata `json:"last_run,omitempty"`
+
+	// The prune candidates referencing the task, empty when no task does.
+	ReferencedBy []string `json:"referenced_by,omitempty"`
+}
+
+// Finds the tasks nothing runs anymore, in the order they are declared.
+// The default task, the tasks of the git hooks and of the global events,
+// and the ones which ran successfully within unusedFor are in use, along
+// with every task they reference through deps, run, on_success, on_failure,
+// events and extends. The others are candidates, including the tasks only
+// referenced by other candidates. Generated tasks and the ones of the local
+// overrides aren't declared in the config, so they count as in use.
+func (p *Parser) PruneCandidates(h *History, now time.Time, unusedFor time.Duration) []PruneCandidate {
+	used := make(map[string]bool, len(p.Tasks))
+
+	var use func(name string)
+	use = func(name string) {
+		task, ok := p.Tasks[name]
+		if !ok || used[name] {
+			return
+		}
+
+		used[name] = true
+		for _, ref := range p.taskReferences(task) {
+			use(ref)
+		}
+	}
+
+	use(DefaultTask)
+	for _, hook := range sortedKeys(p.Hooks) {
+		for _, name := range p.Hooks[hook] {
+			use(name)
+		}
+	}
+
+	for _, ev := range p.Global.Shared.Events.all() {
+		use(ev.Cmd)
+	}
+
+	order := declarationOrder(p.Tasks, keyLines(p.config))
+	for _, name := range order {
+		last, ran := h.LastSuccess(name)
+		if name == "global" || !p.isDeclared(name) || (ran && now.Sub(last) < unusedFor) {
+			use(name)
+		}
+	}
+
+	referencedBy := map[string][]string{}
+	for _, name := range order {
+		if used[name] {
+			continue
+		}
+
+		for _, ref := range p.taskReferences(p.Tasks[name]) {
+			referencedBy[ref] = append(referencedBy[ref], name)
+		}
+	}
+
+	candidates := []PruneCandidate{}
+	for _, name := range order {
+		if used[name] {
+			continue
+		}
+
+		c := PruneCandidate{Name: name, Location: p.taskLocation(name), ReferencedBy: referencedBy[name]}
+		if last, ok := h.LastSuccess(name); ok {
+			c.LastSuccess = &last
+			c.LastRun = h.LastRun(name)
+		}
+
+		candidates = append(candidates, c)
+	}
+
+	return candidates
+}
+
+// The declared tasks the task references, once each.
+func (p *Parser) taskReferences(task Task) []string {
+	refs := []string{}
+	seen := map[string]bool{}
+
+	add := func(name string) {
+		if _, ok := p.Tasks[name]; ok && !seen[name] && name != task.Name {
+			seen[name] = true
+			refs = append(refs, name)
+		}
+	}
+
+	add(task.Extends)
+	for _, dep := range task.Deps {
+		add(dep)
+	}
+
+	for _, entries := range [][]RunEntry{task.Run, task.RunWindows, task.RunDarwin, task.RunLinux} {
+		for _, entry := range entries {
+			add(entry.Cmd)
+		}
+	}
+
+	for _, name := range append(append([]string{}, task.OnSuccess...), task.OnFailure...) {
+		add(name)
+	}
+
+	if task.OwnEvents {
+		for _, ev := range task.Events.all() {
+			add(ev.Cmd)
+		}
+	}
+
+	return refs
+}
+
+// Whether the task is declared in the config or in one of its included
+// files, rather than generated or declared by the local overrides.
+func (p *Parser) isDeclared(name string) bool {
+	task := p.Tasks[name]
+	return task.Origin == "" && (task.Include != "" || keyLine(p.config, name) > 0)
+}
+
+// Writes the prune candidates, with when they last ran and the candidates
+// referencing them, for "goke prune-tasks".
+func WritePruneCandidates(out io.Writer, candidates []PruneCandidate, now time.Time) {
+	if len(candidates) == 0 {
+		fmt.Fprintln(out, "No unused tasks")
+		return
+	}
+
+	nameWidth, locationWidth := 0, 0
+	for _, c := range candidates {
+		if len(c.Name) > nameWidth {
+			nameWidth = len(c.Name)
+		}
+		if len(c.Location) > locationWidth {
+			locationWidth = len(c.Location)
+		}
+	}
+
+	for _, c := range candidates {
+		status := "never ran"
+		if c.LastSuccess != nil {
+			status = "last success " + humanizeSince(*c.LastSuccess, now)
+		}
+
+		if len(c.ReferencedBy) > 0 {
+			status += ", only referenced by " + strings.Join(c.ReferencedBy, ", ")
+		}
+
+		fmt.Fprintf(out, "%-*s  %-*s  %s\n", nameWidth, c.Name, locationWidth, c.Location, status)
+	}
+}
+
+// Removes the tasks from the files declaring them, keeping the comments of
+// the rest. Nothing changes when a remaining task or git hook still
+// references one of them, or when a file wouldn't parse into the same
+// config minus the removed tasks.
+func (p *Parser) DeleteTasks(names []string) error {
+	deleted := make(map[string]bool, len(names))
+	for _, name := range names {
+		if _, ok := p.Tasks[name]; !ok {
+			return &TaskNotFoundError{Task: name}
+		}
+
+		if !p.isDeclared(name) {
+			return fmt.Errorf("task '%s' isn't declared in the config or its included files", name)
+		}
+
+		deleted[name] = true
+	}
+
+	for _, name := range declarationOrder(p.Tasks, keyLines(p.config)) {
+		if deleted[name] {
+			continue
+		}
+
+		for _, ref := range p.taskReferences(p.Tasks[name]) {
+			if deleted[ref] {
+				return fmt.Errorf("can't delete task '%s', task '%s' references it", ref, name)
+			}
+		}
+	}
+
+	for _, hook := range sortedKeys(p.Hooks) {
+		for _, name := range p.Hooks[hook] {
+			if deleted[name] {
+				return fmt.Errorf("can't delete task '%s', the %s hook runs it", name, hook)
+			}
+		}
+	}
+
+	// The names of the tasks in the files declaring them.
+	byFile := map[string][]string{}
+	for _, name := range names {
+		task := p.Tasks[name]
+		if task.Include == "" {
+			byFile[p.configFile()] = append(byFile[p.configFile()], name)
+		} else {
+			local := strings.TrimPrefix(name, task.Namespace+":")
+			byFile[task.Include] = append(byFile[task.Include], local)
+		}
+	}
+
+	files := sortedKeys(byFile)
+	edited := make([][]byte, len(files))
+	for i, file := range files {
+		src, err := p.fs.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		if edited[i], err = removeTasks(file, src, byFile[file]); err != nil {
+			return err
+		}
+	}
+
+	for i, file := range files {
+		info, err := p.fs.Stat(file)
+		if err != nil {
+			return err
+		}
+
+		if err := p.fs.WriteFile(file, edited[i], info.Mode().Perm()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Removes the top-level keys of the tasks from the config, along with their
+// comments, and encodes the rest like FormatConfig. Fails rather than
+// return a config which would parse differently otherwise.
+func removeTasks(file string, src []byte, names []string) ([]byte, error) {
+	var doc yaml.Node
+	if err := decodeConfig(file, string(src), &doc); err != nil {
+		return nil, err
+	}
+
+	if len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s: the config must be a mapping of tasks", file)
+	}
+
+	root := doc.Content[0]
+	for _, name := range names {
+		i := mappingIndex(root, name)
+		if i < 0 {
+			return nil, fmt.Errorf("%s: task '%s' not found", file, name)
+		}
+
+		root.Content = append(root.Content[:i], root.Content[i+2:]...)
+	}
+
+	edited, err := encodeConfig(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	before, err := decodeFormattedConfig(file, src)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range names {
+		delete(before.Tasks, name)
+	}
+
+	after, err := decodeFormattedConfig(file, edited)
+	if err != nil || !reflect.DeepEqual(before, after) {
+		return nil, fmt.Errorf("%s: removing %s would change how the rest of the config parses, leaving it as is", file, strings.Join(names, ", "))
+	}
+
+	return edited, nil
+}
@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const pruneConfig = `# Tasks of the project.
+global:
+  events:
+    after_each_task:
+      - notify
+
+hooks:
+  pre-commit: [lint]
+
+main:
+  deps: [build]
+  run:
+    - "echo done"
+
+build:
+  run:
+    - "go build ./..."
+
+lint:
+  run:
+    - "golangci-lint run"
+
+notify:
+  run:
+    - "notify-send done"
+
+release:
+  run:
+    - publish
+
+publish:
+  run:
+    - "goreleaser release"
+
+# Deploys to the old cluster.
+old-deploy:
+  run:
+    - old-upload
+  on_success:
+    - old-notify
+
+old-upload:
+  run:
+    - "scp dist.tar.gz old:/srv"
+
+old-notify:
+  run:
+    - "curl -X POST https://hooks.example.com"
+
+base:
+  abstract: true
+  run:
+    - "docker build ."
+
+docker:
+  extends: base
+`
+
+// The history of pruneConfig: release ran recently, old-deploy long ago.
+func newPruneEnv(t *testing.T, now time.Time) (*InMemoryEnv, *Parser) {
+	env := newGeneratorEnv(pruneConfig)
+	require.Nil(t, env.FS.WriteFile("/work/goke.yml", []byte(pruneConfig), 0644))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	env.history.JSON["/work"] = taskHistoryJson{
+		"release":    {At: now.Add(-24 * time.Hour).Unix()},
+		"old-deploy": {At: now.Add(-200 * 24 * time.Hour).Unix(), Metadata: &RunMetadata{Version: "1.2.3"}},
+	}
+
+	return env, p
+}
+
+func TestPruneCandidates(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	env, p := newPruneEnv(t, now)
+
+	candidates := p.PruneCandidates(&env.history, now, DefaultUnusedFor)
+	names := []string{}
+	for _, c := range candidates {
+		names = append(names, c.Name)
+	}
+	require.Equal(t, []string{"old-deploy", "old-upload", "old-notify", "base", "docker"}, names)
+
+	require.Equal(t, "goke.yml:36", candidates[0].Location)
+	require.Empty(t, candidates[0].ReferencedBy)
+	require.Equal(t, &RunMetadata{Version: "1.2.3"}, candidates[0].LastRun)
+	require.Equal(t, []string{"old-deploy"}, candidates[1].ReferencedBy)
+	require.Nil(t, candidates[1].LastRun)
+	require.Equal(t, []string{"docker"}, candidates[3].ReferencedBy)
+
+	out := bytes.Buffer{}
+	WritePruneCandidates(&out, candidates[:2], now)
+	require.Equal(t, `old-deploy  goke.yml:36  last success 200 days ago
+old-upload  goke.yml:42  never ran, only referenced by old-deploy
+`, out.String())
+
+	// With a window longer than its last run, old-deploy and the tasks it
+	// references are in use.
+	candidates = p.PruneCandidates(&env.history, now, 365*24*time.Hour)
+	require.Len(t, candidates, 2)
+	require.Equal(t, "base", candidates[0].Name)
+	require.Equal(t, "docker", candidates[1].Name)
+}
+
+func TestDeleteTasks(t *testing.T) {
+	t.Parallel()
+
+	now := time.Unix(1700000000, 0)
+	env, p := newPruneEnv(t, now)
+
+	require.EqualError(t, p.DeleteTasks([]string{"old-upload"}), "can't delete task 'old-upload', task 'old-deploy' references it")
+	require.EqualError(t, p.DeleteTasks([]string{"lint"}), "can't delete task 'lint', the pre-commit hook runs it")
+
+	require.Nil(t, p.DeleteTasks([]string{"old-deploy", "old-upload", "old-notify"}))
+
+	edited, err := env.FS.ReadFile("/work/goke.yml")
+	require.Nil(t, err)
+
+	// The tasks are removed along with their comment, the rest is untouched.
+	removed := pruneConfig[strings.Index(pruneConfig, "# Deploys"):strings.Index(pruneConfig, "base:")]
+	require.Equal(t, strings.Replace(pruneConfig, removed, "", 1), string(edited))
+
+	// The rest of the config parses the same.
+	after, err := NewInMemoryEnv(string(edited)).Parse()
+	require.Nil(t, err)
+	require.ElementsMatch(t, []string{"global", "main", "build", "lint", "notify", "release", "publish", "base", "docker"}, sortedKeys(after.Tasks))
+	require.Equal(t, p.Tasks["docker"].Run, after.Tasks["docker"].Run)
+	require.Equal(t, p.Hooks, after.Hooks)
+}
+
+func TestDeleteTasksOfNamespacedIncludes(t *testing.T) {
+	t.Parallel()
+
+	env := newGeneratorEnv(namespacedIncludesConfig)
+	writeNamespacedIncludes(t, env)
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Nil(t, p.DeleteTasks([]string{"api:release"}))
+
+	edited, err := env.FS.ReadFile("/work/services/api/goke.yml")
+	require.Nil(t, err)
+	require.Equal(t, `build:
+  desc: Builds the API
+  deps: [gen]
+  run:
+    - "go build ./..."
+
+gen:
+  run:
+    - "go generate ./..."
+`, string(edited))
+}
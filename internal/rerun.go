@@ -0,0 +1,156 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mattn/go-isatty"
+)
+
+func init() {
+	RegisterCapability("run.rerun_prompt")
+}
+
+// How long the rerun prompt waits for an answer before quitting, so that a
+// script which happens to run in a terminal isn't held up for long.
+const rerunPromptTimeout = 10 * time.Second
+
+type rerunChoice int
+
+const (
+	rerunQuit rerunChoice = iota
+	rerunAll
+	rerunFailed
+)
+
+// Asks whether to rerun the tasks after a failed run. The terminal stays in
+// line mode, so answers are confirmed with Enter.
+type rerunPrompt struct {
+	in      io.Reader
+	out     io.Writer
+	timeout time.Duration
+}
+
+func newRerunPrompt(in io.Reader, out io.Writer, timeout time.Duration) *rerunPrompt {
+	return &rerunPrompt{in: in, out: out, timeout: timeout}
+}
+
+// Prints the prompt and waits for the answer. Anything but r or f quits,
+// as does the end of the input or the timeout.
+func (p *rerunPrompt) ask() rerunChoice {
+	fmt.Fprintf(p.out, "r to rerun, f to rerun failed steps only, q to quit (quitting in %s): ", p.timeout)
+
+	line, ok := p.readLine()
+	if !ok {
+		fmt.Fprintln(p.out)
+		return rerunQuit
+	}
+
+	switch strings.ToLower(strings.TrimSpace(line)) {
+	case "r":
+		return rerunAll
+	case "f":
+		return rerunFailed
+	}
+
+	return rerunQuit
+}
+
+// Reads the answer, false when the input ended or the timeout passed first.
+// Only the line of the answer is read, a byte at a time, and nothing is
+// read once the prompt is over, so that the input is left to the commands
+// which run next. Files, ie. stdin, are only read once they have something
+// to read, see waitReadable.
+func (p *rerunPrompt) readLine() (string, bool) {
+	deadline := time.Now().Add(p.timeout)
+	line := []byte{}
+	b := make([]byte, 1)
+
+	for {
+		if f, ok := p.in.(*os.File); ok {
+			if ready, err := waitReadable(f, time.Until(deadline)); err != nil || !ready {
+				return "", false
+			}
+		}
+
+		n, err := p.in.Read(b)
+		if n == 1 {
+			if b[0] == '\n' {
+				return string(line), true
+			}
+
+			line = append(line, b[0])
+		}
+
+		if err != nil {
+			return string(line), err == io.EOF && len(line) > 0
+		}
+	}
+}
+
+// Whether goke runs interactively: stdin and stdout are terminals, outside
+// of CI.
+func isInteractive() bool {
+	tty := func(f *os.File) bool {
+		return isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())
+	}
+
+	return tty(os.Stdin) && tty(os.Stdout) && os.Getenv("CI") == ""
+}
+
+// After a run of tasks failed interactively, asks whether to rerun them.
+// Never asks with --quiet, --no-interactive, --watch, --batch or --dry-run,
+// nor when the run was cancelled or failed before any task ran.
+func (e *Executor) askRerun() rerunChoice {
+	if len(e.failed) == 0 || e.context().Err() != nil || e.deadline.hasExpired() || e.interrupt.received() != nil {
+		return rerunQuit
+	}
+
+	o := e.options
+	if o.Quiet || o.NoInteractive || o.Watch || o.Batch != "" || o.DryRun || o.List {
+		return rerunQuit
+	}
+
+	if e.prompt == nil {
+		if !isInteractive() {
+			return rerunQuit
+		}
+
+		e.prompt = newRerunPrompt(os.Stdin, os.Stderr, rerunPromptTimeout)
+	}
+
+	return e.prompt.ask()
+}
+
+// Reruns the tasks regardless of their files, since the lockfile already
+// recorded them before they failed. rerunAll runs the given tasks again
+// with all of their dependencies. rerunFailed only runs the task which
+// failed and the ones given after it, skipping the dependencies which
+// already succeeded.
+func (e *Executor) rerun(taskNames []string, choice rerunChoice) error {
+	failed := e.failed
+	e.failed = nil
+	e.options.Force = true
+	e.report.retry()
+
+	if choice == rerunFailed {
+		e.skipCompleted = true
+		return e.execute(failed)
+	}
+
+	e.skipCompleted = false
+	e.completed = nil
+	return e.start(taskNames)
+}
+
+// Records that the task or dependency succeeded, see rerun.
+func (e *Executor) markCompleted(taskName string) {
+	if e.completed == nil {
+		e.completed = make(map[string]bool)
+	}
+
+	e.completed[taskName] = true
+}
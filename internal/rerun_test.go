@@ -0,0 +1,130 @@
+package internal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const rerunConfig = `
+generate:
+  run:
+    - "buf generate"
+
+build:
+  deps: [generate]
+  run:
+    - "go build"
+
+test:
+  run:
+    - "go test"
+`
+
+// Runs the tasks, answering the rerun prompt with the input. The first
+// "go test" fails, every other command succeeds.
+func runWithRerunPrompt(t *testing.T, opts Options, input string, taskNames ...string) (*InMemoryEnv, string, error) {
+	env := NewInMemoryEnv(rerunConfig)
+	env.Options = opts
+
+	failures := 1
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if strings.Join(cmd.Args, " ") == "go test" && failures > 0 {
+			failures--
+			return errors.New("tests failed")
+		}
+
+		return nil
+	}
+
+	p, err := env.Parse()
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	e.prompt = newRerunPrompt(strings.NewReader(input), &out, time.Second)
+
+	err = e.StartContext(context.Background(), taskNames)
+	return env, out.String(), err
+}
+
+func TestRerunPromptRerunsAllTasks(t *testing.T) {
+	env, out, err := runWithRerunPrompt(t, Options{}, "r\n", "build", "test")
+
+	require.NoError(t, err)
+	require.Contains(t, out, "r to rerun, f to rerun failed steps only, q to quit")
+	require.Equal(t, []string{"buf generate", "go build", "go test", "buf generate", "go build", "go test"}, recordedCommands(env))
+}
+
+func TestRerunPromptRerunsFailedTasksOnly(t *testing.T) {
+	env, _, err := runWithRerunPrompt(t, Options{}, "f\n", "build", "test")
+
+	require.NoError(t, err)
+	require.Equal(t, []string{"buf generate", "go build", "go test", "go test"}, recordedCommands(env))
+}
+
+func TestRerunPromptQuits(t *testing.T) {
+	for _, input := range []string{"q\n", "\n", "x\n", ""} {
+		env, _, err := runWithRerunPrompt(t, Options{}, input, "build", "test")
+
+		require.ErrorContains(t, err, "task 'test' failed", "%q", input)
+		require.Equal(t, []string{"buf generate", "go build", "go test"}, recordedCommands(env), "%q", input)
+	}
+}
+
+func TestRerunPromptIsSuppressed(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"quiet":          {Quiet: true},
+		"no-interactive": {NoInteractive: true},
+	} {
+		env, out, err := runWithRerunPrompt(t, opts, "r\n", "test")
+
+		require.Error(t, err, name)
+		require.Empty(t, out, name)
+		require.Equal(t, []string{"go test"}, recordedCommands(env), name)
+	}
+}
+
+func TestRerunPromptTimesOut(t *testing.T) {
+	in, w, err := os.Pipe()
+	require.Nil(t, err)
+	defer in.Close()
+	defer w.Close()
+
+	var out bytes.Buffer
+	p := newRerunPrompt(in, &out, 10*time.Millisecond)
+
+	require.Equal(t, rerunQuit, p.ask())
+	require.Contains(t, out.String(), "quitting in 10ms")
+
+	// Nothing reads the input once the prompt is over.
+	_, err = w.Write([]byte("go test\n"))
+	require.Nil(t, err)
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	require.Nil(t, err)
+	require.Equal(t, "go test\n", line)
+}
+
+func TestRerunPromptOnlyReadsTheAnswer(t *testing.T) {
+	in := strings.NewReader("f\nyes\n")
+
+	var out bytes.Buffer
+	p := newRerunPrompt(in, &out, time.Second)
+
+	require.Equal(t, rerunFailed, p.ask())
+
+	rest, err := io.ReadAll(in)
+	require.Nil(t, err)
+	require.Equal(t, "yes\n", string(rest))
+}
@@ -0,0 +1,64 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+func init() {
+	RegisterCapability("task.retries")
+}
+
+// Runs the command of the entry, and runs it again when it fails, up to the
+// retries of its task. Attempts are retryDelay apart, doubled after each
+// one with retry_backoff. Runs which were cancelled, interrupted or cut off
+// by the deadline, and parallel commands stopped by the failure of another one,
+// aren't retried. Only the last failure is returned.
+func (e *Executor) withRetries(entry RunEntry, run func() error) error {
+	delay := entry.retryDelay
+
+	for retry := 1; ; retry++ {
+		err := run()
+		if err == nil || retry > entry.retries || e.runStopped(err) {
+			return err
+		}
+
+		if !e.options.Quiet {
+			e.spinnerMessage(fmt.Sprintf("Retrying (%d/%d): %s", retry, entry.retries, entry.Cmd))
+		}
+
+		if !e.wait(delay) {
+			return err
+		}
+
+		if entry.retryBackoff {
+			delay *= 2
+		}
+	}
+}
+
+// Whether the run was cancelled, interrupted or stopped, in which case failed
+// commands don't run again.
+func (e *Executor) runStopped(err error) bool {
+	return e.context().Err() != nil || e.deadline.hasExpired() || e.interrupt.received() != nil || errors.Is(err, errProcessesStopped)
+}
+
+// Waits for the duration, and reports false when the run was cancelled in
+// the meantime.
+func (e *Executor) wait(d time.Duration) bool {
+	if d <= 0 {
+		return true
+	}
+
+	done := make(chan struct{})
+	stop := e.timer()(d, func() { close(done) })
+	defer stop()
+
+	select {
+	case <-done:
+		return true
+	case <-e.context().Done():
+		return false
+	}
+}
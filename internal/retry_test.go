@@ -0,0 +1,85 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Fails the command the given amount of times, then lets it succeed.
+func failTimes(env *InMemoryEnv, command string, failures int) {
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if strings.Join(cmd.Args, " ") == command && failures > 0 {
+			failures--
+			return errors.New("connection reset")
+		}
+
+		return nil
+	}
+}
+
+func TestRetriesRunFailedCommandsAgain(t *testing.T) {
+	env := NewInMemoryEnv(`
+release:
+  retries: 3
+  run:
+    - "docker build ."
+    - "docker push app"
+`)
+	failTimes(env, "docker push app", 2)
+
+	require.NoError(t, env.Run("release"))
+	require.Equal(t, []string{"docker build .", "docker push app", "docker push app", "docker push app"}, recordedCommands(env))
+}
+
+func TestRetriesOnlyReturnTheLastFailure(t *testing.T) {
+	env := NewInMemoryEnv(`
+release:
+  retries: 1
+  run:
+    - "docker push app"
+    - "echo pushed"
+`)
+	failTimes(env, "docker push app", 5)
+
+	require.EqualError(t, env.Run("release"), `"docker push app" failed: connection reset`)
+	require.Equal(t, []string{"docker push app", "docker push app"}, recordedCommands(env))
+}
+
+func TestRetriesWaitWithBackoff(t *testing.T) {
+	env := NewInMemoryEnv(`
+install:
+  retries: 3
+  retry_delay: 2s
+  retry_backoff: true
+  run:
+    - "npm install"
+`)
+	failTimes(env, "npm install", 3)
+
+	p, err := env.Parse()
+	require.NoError(t, err)
+
+	delays := []time.Duration{}
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	e.newTimer = func(d time.Duration, f func()) func() {
+		delays = append(delays, d)
+		f()
+		return func() {}
+	}
+
+	require.NoError(t, e.StartContext(context.Background(), []string{"install"}))
+	require.Equal(t, []time.Duration{2 * time.Second, 4 * time.Second, 8 * time.Second}, delays)
+	require.Len(t, recordedCommands(env), 4)
+}
+
+func TestRetriesMustNotBeNegative(t *testing.T) {
+	_, err := NewInMemoryEnv("build:\n  retries: -1\n  run: [\"go build\"]\n").Parse()
+	require.EqualError(t, err, "task 'build': retries and retry_delay can't be negative")
+}
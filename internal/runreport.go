@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+func init() {
+	RegisterCapability("report.summary_line")
+}
+
+// Writes the summary line to stdout instead of a file, see --summary-line.
+const SummaryLineStdout = "-"
+
+// The longest summary line, in characters, which is what commit statuses
+// accept as their description.
+const summaryLineMax = 140
+
+// The shortest part of a failed command or error kept in the summary line,
+// below which it's left out instead.
+const summaryLineMinTail = 8
+
+// What happened during a run: the tasks and commands which ran, the tasks
+// which were skipped and the innermost task which failed. It's collected
+// while the tasks run, shared by the copies of the executor, and consumed
+// once the run is over, ie. by the summary line of --summary-line.
+type runReport struct {
+	mu      sync.Mutex
+	label   string
+	now     func() time.Time
+	started time.Time
+
+	ran        map[string]bool
+	commands   int
+	skipped    int
+	failedTask string
+
+	// The commands whose output was discarded, see runDiscarded.
+	discarded int
+}
+
+func newRunReport(label string, now func() time.Time) *runReport {
+	return &runReport{label: label, now: now, started: now(), ran: make(map[string]bool)}
+}
+
+// Records that the task was dispatched. Tasks which run more than once,
+// ie. when referenced by several tasks, count once.
+func (r *runReport) taskRan(name string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.ran[name] = true
+}
+
+// Records that commands ran, retries not included.
+func (r *runReport) commandsRan(n int) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.commands += n
+}
+
+// Records that the output of a command was discarded.
+func (r *runReport) outputDiscarded() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.discarded++
+}
+
+// Records that a task was skipped, because its files didn't change or its
+// conditions didn't hold.
+func (r *runReport) taskSkipped() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.skipped++
+}
+
+// Records that the task failed. Tasks fail from the innermost one out, so
+// only the first one is kept, until the run is retried.
+func (r *runReport) taskFailed(name string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.failedTask == "" {
+		r.failedTask = name
+	}
+}
+
+// Forgets the failure of the previous attempt, see rerun.
+func (r *runReport) retry() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.failedTask = ""
+}
+
+// Renders the outcome of the run as a single line of at most summaryLineMax
+// characters, ie. "ci ✓ 7 tasks, 42 cmds, 3 skipped, 4m12s" or
+// "ci ✗ test failed (exit 2) at 'go test ./...'". Long lines first lose the
+// end of the failed command or error, then the end of the label. The status
+// and the failed task are always kept.
+func (r *runReport) summaryLine(err error) string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	mark, head, tail := "✓", r.counts(), ""
+	if err != nil {
+		mark = "✗"
+		head, tail = r.failure(err)
+	}
+
+	status := " " + mark + " " + head
+	if room := summaryLineMax - utf8.RuneCountInString(r.label+status); utf8.RuneCountInString(tail) > room {
+		if room >= summaryLineMinTail {
+			tail = truncateRunes(tail, room)
+		} else {
+			tail = ""
+		}
+	}
+
+	label := truncateRunes(r.label, summaryLineMax-utf8.RuneCountInString(status+tail))
+	return truncateRunes(label+status+tail, summaryLineMax)
+}
+
+// Renders what ran, ie. "7 tasks, 42 cmds, 3 skipped, 4m12s", or
+// "7 tasks, 42 cmds, output of 30 discarded, 4m12s".
+func (r *runReport) counts() string {
+	parts := []string{
+		pluralize(len(r.ran), "task", "tasks"),
+		pluralize(r.commands, "cmd", "cmds"),
+	}
+
+	if r.discarded > 0 {
+		parts = append(parts, fmt.Sprintf("output of %d discarded", r.discarded))
+	}
+
+	if r.skipped > 0 {
+		parts = append(parts, fmt.Sprintf("%d skipped", r.skipped))
+	}
+
+	return strings.Join(append(parts, r.now().Sub(r.started).Round(time.Second).String()), ", ")
+}
+
+// Renders the failure of the run, split into what is always kept, ie.
+// "test failed (exit 2)", and what may be shortened, ie. " at 'go test'".
+func (r *runReport) failure(err error) (string, string) {
+	var exceeded *DeadlineExceededError
+	if errors.As(err, &exceeded) {
+		cutOff := exceeded.CutOff
+		if r.failedTask != "" {
+			cutOff = r.failedTask
+		}
+
+		if cutOff == "" {
+			return fmt.Sprintf("deadline of %s exceeded", exceeded.Deadline), ""
+		}
+
+		return fmt.Sprintf("deadline of %s exceeded, %s cut off", exceeded.Deadline, cutOff), ""
+	}
+
+	var interrupted *InterruptedError
+	if errors.As(err, &interrupted) {
+		if r.failedTask == "" {
+			return "interrupted", ""
+		}
+
+		return fmt.Sprintf("%s interrupted", r.failedTask), ""
+	}
+
+	var cmdErr *CommandError
+	if errors.As(err, &cmdErr) && r.failedTask != "" {
+		return fmt.Sprintf("%s failed (exit %d)", r.failedTask, ExitCode(err)), fmt.Sprintf(" at '%s'", cmdErr.Cmd)
+	}
+
+	message, _, _ := strings.Cut(err.Error(), "\n")
+	if r.failedTask == "" {
+		return "failed", ": " + message
+	}
+
+	return fmt.Sprintf("%s failed", r.failedTask), ": " + message
+}
+
+// Writes the summary line of the run to stdout or to the file of
+// --summary-line, regardless of --quiet.
+func (e *Executor) writeSummaryLine(err error) {
+	if e.report == nil {
+		return
+	}
+
+	line := e.report.summaryLine(err) + "\n"
+	if e.options.SummaryLine == SummaryLineStdout {
+		fmt.Fprint(os.Stdout, line)
+		return
+	}
+
+	if writeErr := os.WriteFile(e.options.SummaryLine, []byte(line), 0644); writeErr != nil {
+		fmt.Fprintf(os.Stderr, "Warning: could not write the summary line: %s\n", writeErr)
+	}
+}
+
+// The tasks the summary line is about: the given ones, the tag or the plan
+// they were picked by, or the main task.
+func (e *Executor) summaryLabel(taskNames []string) string {
+	switch {
+	case len(taskNames) > 0:
+		return strings.Join(taskNames, " ")
+	case e.options.Batch != "":
+		return e.options.Batch
+	case e.options.Tag != "":
+		return "tag " + e.options.Tag
+	default:
+		return DefaultTask
+	}
+}
+
+// Cuts s down to at most n characters, marking the cut with "…".
+func truncateRunes(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+
+	if n <= 0 {
+		return ""
+	}
+
+	return string([]rune(s)[:n-1]) + "…"
+}
+
+func pluralize(n int, singular string, plural string) string {
+	if n == 1 {
+		return fmt.Sprintf("%d %s", n, singular)
+	}
+
+	return fmt.Sprintf("%d %s", n, plural)
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/require"
+)
+
+const runReportConfig = `
+build:
+  run:
+    - "go build ./..."
+
+lint:
+  run:
+    - "go vet ./..."
+    - "golangci-lint run"
+
+test:
+  deps: [build]
+  run:
+    - "go test ./..."
+
+windows:
+  only_os: [plan9]
+  run:
+    - "go test -tags windows ./..."
+
+docs:
+  only_os: [plan9]
+  run:
+    - "mkdocs build"
+
+ci:
+  run:
+    - lint
+    - test
+    - windows
+    - docs
+`
+
+// Runs the tasks on a fake clock where every command takes 6 seconds, and
+// returns the summary line written to the --summary-line file. The handler
+// may fail the commands.
+func runWithSummaryLine(t *testing.T, env *InMemoryEnv, handler func(clock *fakeDeadlineClock, started time.Time, cmd string) error, taskNames ...string) (string, error) {
+	path := filepath.Join(t.TempDir(), "status.txt")
+	env.Options.SummaryLine = path
+
+	started := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	clock := &fakeDeadlineClock{now: started}
+
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		clock.mu.Lock()
+		clock.now = clock.now.Add(6 * time.Second)
+		clock.mu.Unlock()
+
+		return handler(clock, started, strings.Join(cmd.Args, " "))
+	}
+
+	p, err := env.Parse()
+	require.NoError(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	e.report = newRunReport(e.summaryLabel(taskNames), clock.Now)
+	if env.Options.Deadline > 0 {
+		e.deadline = newRunDeadline(env.Options.Deadline, clock.Now, clock.newTimer)
+	}
+
+	runErr := e.StartContext(context.Background(), taskNames)
+
+	line, err := os.ReadFile(path)
+	require.NoError(t, err)
+
+	return string(line), runErr
+}
+
+func readSummaryGolden(t *testing.T, name string) string {
+	contents, err := os.ReadFile(filepath.Join("testdata", "summary", name))
+	require.NoError(t, err)
+
+	return string(contents)
+}
+
+func succeed(*fakeDeadlineClock, time.Time, string) error {
+	return nil
+}
+
+func TestSummaryLineOfSuccessfulRun(t *testing.T) {
+	line, err := runWithSummaryLine(t, NewInMemoryEnv(runReportConfig), succeed, "lint", "test")
+	require.NoError(t, err)
+	require.Equal(t, readSummaryGolden(t, "success.txt"), line)
+}
+
+func TestSummaryLineOfFailedRun(t *testing.T) {
+	line, err := runWithSummaryLine(t, NewInMemoryEnv(runReportConfig), func(_ *fakeDeadlineClock, _ time.Time, cmd string) error {
+		if cmd == "go test ./..." {
+			return errors.New("exit status 1")
+		}
+
+		return nil
+	}, "ci")
+
+	require.Error(t, err)
+	require.Equal(t, readSummaryGolden(t, "failure.txt"), line)
+}
+
+func TestSummaryLineOfSkippedTasks(t *testing.T) {
+	line, err := runWithSummaryLine(t, NewInMemoryEnv(runReportConfig), succeed, "ci")
+	require.NoError(t, err)
+	require.Equal(t, readSummaryGolden(t, "skipped.txt"), line)
+}
+
+func TestSummaryLineOfDeadlineExceededRun(t *testing.T) {
+	env := NewInMemoryEnv(runReportConfig)
+	env.Options.Deadline = 25 * time.Minute
+
+	line, err := runWithSummaryLine(t, env, func(clock *fakeDeadlineClock, started time.Time, cmd string) error {
+		if cmd == "go test ./..." {
+			clock.fire(0, started)
+			return errors.New("signal: terminated")
+		}
+
+		return nil
+	}, "lint", "test")
+
+	var exceeded *DeadlineExceededError
+	require.ErrorAs(t, err, &exceeded)
+	require.Equal(t, readSummaryGolden(t, "deadline.txt"), line)
+}
+
+func TestSummaryLineIsTruncated(t *testing.T) {
+	longCmd := "go test -run " + strings.Repeat("TestSomething|", 20) + " ./..."
+	report := newRunReport("lint test", time.Now)
+	report.taskFailed("test")
+
+	err := &CommandError{Cmd: longCmd, Err: errors.New("exit status 1")}
+	line := report.summaryLine(err)
+	require.Equal(t, summaryLineMax, utf8.RuneCountInString(line))
+	require.True(t, strings.HasPrefix(line, "lint test ✗ test failed (exit 1) at 'go test -run TestSomething|"))
+	require.True(t, strings.HasSuffix(line, "…"))
+
+	// Labels are shortened once the command is left out, the status and
+	// the failed task stay.
+	report = newRunReport(strings.Repeat("integration ", 20), time.Now)
+	report.taskFailed("integration")
+
+	line = report.summaryLine(err)
+	require.Equal(t, summaryLineMax, utf8.RuneCountInString(line))
+	require.True(t, strings.HasSuffix(line, "… ✗ integration failed (exit 1)"))
+}
@@ -0,0 +1,80 @@
+package internal
+
+import (
+	"time"
+)
+
+func init() {
+	RegisterCapability("task.every")
+}
+
+// Creates a ticker firing on the given interval, along with a function
+// stopping it. Replaced in tests with a fake clock.
+type tickerFunc func(every time.Duration) (<-chan time.Time, func())
+
+func newRealTicker(every time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(every)
+	return ticker.C, ticker.Stop
+}
+
+// Returns the tasks of a watch session which run on a schedule: the watched
+// task and the tasks it depends on or references, which declare "every".
+func (p *Parser) scheduledTasks(task Task) []Task {
+	scheduled := []Task{}
+	visited := make(map[string]bool)
+
+	var walk func(t Task)
+	walk = func(t Task) {
+		if visited[t.Name] {
+			return
+		}
+		visited[t.Name] = true
+
+		if t.Every > 0 {
+			scheduled = append(scheduled, t)
+		}
+
+		for _, dep := range t.Deps {
+			walk(p.Tasks[dep])
+		}
+
+		for _, entry := range t.Run {
+			if ref, ok := p.Tasks[entry.Cmd]; ok {
+				walk(ref)
+			}
+		}
+	}
+
+	walk(task)
+	return scheduled
+}
+
+// Sends the name of each task whenever its interval elapses,
+// until stop is closed. Every task has a ticker of its own.
+func scheduleTicks(tasks []Task, newTicker tickerFunc, stop <-chan struct{}) <-chan string {
+	ticks := make(chan string)
+
+	for _, task := range tasks {
+		c, stopTicker := newTicker(task.Every)
+
+		go func(name string) {
+			defer stopTicker()
+
+			for {
+				select {
+				case <-c:
+				case <-stop:
+					return
+				}
+
+				select {
+				case ticks <- name:
+				case <-stop:
+					return
+				}
+			}
+		}(task.Name)
+	}
+
+	return ticks
+}
@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A clock whose tickers only fire when told to.
+type fakeClock struct {
+	mu      sync.Mutex
+	tickers map[time.Duration]chan time.Time
+}
+
+func (c *fakeClock) newTicker(every time.Duration) (<-chan time.Time, func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.tickers == nil {
+		c.tickers = make(map[time.Duration]chan time.Time)
+	}
+
+	ticker := make(chan time.Time)
+	c.tickers[every] = ticker
+
+	return ticker, func() {}
+}
+
+func (c *fakeClock) tick(every time.Duration) {
+	c.mu.Lock()
+	ticker := c.tickers[every]
+	c.mu.Unlock()
+
+	ticker <- time.Now()
+}
+
+func TestScheduledTasks(t *testing.T) {
+	p := Parser{Tasks: taskList{
+		"dev":    {Name: "dev", Deps: []string{"lint"}, Run: []RunEntry{{Cmd: "health"}, {Cmd: "go run ."}}},
+		"lint":   {Name: "lint", Every: 10 * time.Minute},
+		"health": {Name: "health", Every: time.Minute, Run: []RunEntry{{Cmd: "lint"}}},
+		"other":  {Name: "other", Every: time.Minute},
+	}}
+
+	scheduled := p.scheduledTasks(p.Tasks["dev"])
+
+	require.Len(t, scheduled, 2)
+	require.Equal(t, "lint", scheduled[0].Name)
+	require.Equal(t, "health", scheduled[1].Name)
+}
+
+func TestScheduleTicksPerTask(t *testing.T) {
+	clock := &fakeClock{}
+	stop := make(chan struct{})
+	defer close(stop)
+
+	tasks := []Task{{Name: "lint", Every: 10 * time.Minute}, {Name: "health", Every: time.Minute}}
+	ticks := scheduleTicks(tasks, clock.newTicker, stop)
+
+	go clock.tick(time.Minute)
+	require.Equal(t, "health", <-ticks)
+
+	go clock.tick(10 * time.Minute)
+	require.Equal(t, "lint", <-ticks)
+}
+
+// Receives the next run, failing the test instead of hanging without one.
+func receiveRun(t *testing.T, runs <-chan string) string {
+	select {
+	case task := <-runs:
+		return task
+	case <-time.After(5 * time.Second):
+		require.FailNow(t, "the scheduled task never ran")
+		return ""
+	}
+}
+
+func TestWatchLoopSkipsTicksWhileRunning(t *testing.T) {
+	clock := &fakeClock{}
+	stop := make(chan struct{})
+	defer close(stop)
+
+	release := make(chan struct{})
+	interrupt := make(chan os.Signal)
+	runs := make(chan string, 10)
+	status := newWatchStatus("dev")
+
+	loop := watchLoop{
+		interrupt: interrupt,
+		status:    status,
+		ticks:     scheduleTicks([]Task{{Name: "lint", Every: time.Minute}}, clock.newTicker, stop),
+		run: func(initial bool) (bool, error) {
+			return true, nil
+		},
+		runScheduled: func(task string) (bool, error) {
+			runs <- task
+			<-release
+			return true, nil
+		},
+		stop: func() {},
+	}
+
+	done := make(chan struct{})
+	go func() {
+		loop.loop()
+		close(done)
+	}()
+
+	require.Eventually(t, func() bool { return status.snapshot().State == stateWaiting }, time.Second, time.Millisecond)
+
+	clock.tick(time.Minute)
+	require.Equal(t, "lint", receiveRun(t, runs))
+
+	// The scheduled run is still in progress, so the next tick gets skipped.
+	clock.tick(time.Minute)
+	require.Eventually(t, func() bool { return status.snapshot().SkippedTicks == 1 }, time.Second, time.Millisecond)
+
+	release <- struct{}{}
+	require.Eventually(t, func() bool { return status.snapshot().State == stateWaiting }, time.Second, time.Millisecond)
+
+	clock.tick(time.Minute)
+	require.Equal(t, "lint", receiveRun(t, runs))
+	release <- struct{}{}
+
+	interrupt <- os.Interrupt
+	<-done
+
+	history := status.recentIterations()
+	require.Len(t, history, 3)
+	require.Equal(t, triggerInitial, history[0].Trigger)
+	require.Equal(t, "dev", history[0].Task)
+	require.Equal(t, triggerSchedule, history[1].Trigger)
+	require.Equal(t, "lint", history[1].Task)
+	require.Equal(t, 1, status.snapshot().SkippedTicks)
+}
+
+func TestParseTasksRejectsNegativeEvery(t *testing.T) {
+	parser := NewParser("lint:\n  every: -1m\n  run:\n    - \"true\"\n", &clearCacheOpts, &LocalFileSystem{})
+
+	require.EqualError(t, parser.parseTasks(), "task 'lint': every must be a positive duration")
+
+	parser = NewParser("lint:\n  every: 10m\n  run:\n    - \"true\"\n", &clearCacheOpts, &LocalFileSystem{})
+	require.Nil(t, parser.parseTasks())
+	require.Equal(t, 10*time.Minute, parser.Tasks["lint"].Every)
+}
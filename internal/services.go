@@ -0,0 +1,186 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+func init() {
+	RegisterCapability("run.services")
+}
+
+// Tasks with restart, referenced by another task, run as services: they
+// start in the background once per invocation, and the referencing task
+// carries on instead of waiting for them to exit. Referencing a running one
+// again, ie. on the next iteration of a watched task, reuses it unless its
+// own files changed, which restarts it. They are all stopped once the
+// invocation ends. References never enter watch mode themselves.
+type services struct {
+	mu      sync.Mutex
+	running map[string]*service
+
+	// Stops the processes of a service, replaced in tests.
+	stopGroup func(group *processes)
+}
+
+// A service task running in the background.
+type service struct {
+	group   *processes
+	done    chan struct{}
+	stopped atomic.Bool
+}
+
+func newServices() *services {
+	return &services{
+		running:   make(map[string]*service),
+		stopGroup: func(group *processes) { group.stop(restartGracePeriod) },
+	}
+}
+
+// Starts the service task, or reuses it when it's already running and its
+// files didn't change since it started.
+func (e *Executor) startService(task Task) error {
+	changed, err := e.serviceChanged(task)
+	if err != nil {
+		return err
+	}
+
+	s := e.services
+	s.mu.Lock()
+	running, ok := s.running[task.Name]
+	if ok && !changed {
+		s.mu.Unlock()
+		e.logVerbose(fmt.Sprintf("Reusing service: %s", task.Name))
+		return nil
+	}
+	delete(s.running, task.Name)
+	s.mu.Unlock()
+
+	// The service is stopped without holding the lock, since its own
+	// references may be starting other services meanwhile.
+	if ok {
+		e.logVerbose(fmt.Sprintf("Restarting service: %s", task.Name))
+		s.stop(running)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Another task may have started it in the meantime, ie. with --jobs.
+	if _, ok := s.running[task.Name]; ok {
+		return nil
+	}
+
+	if !e.options.Quiet {
+		e.spinnerMessage(fmt.Sprintf("Starting service: %s", task.Name))
+	}
+
+	s.running[task.Name] = e.runService(task)
+
+	return nil
+}
+
+// Whether the files of the service changed since it last started, which
+// also records them for the next check. Services without any files never
+// change.
+func (e *Executor) serviceChanged(task Task) (bool, error) {
+	if files, _ := e.parser.inputFiles(task); len(files) == 0 {
+		return false, nil
+	}
+
+	return e.shouldDispatch(task)
+}
+
+// Dispatches the service task in the background, in its own process group,
+// with the name of the task prefixing its output.
+func (e *Executor) runService(task Task) *service {
+	c := *e
+	c.processes = newProcesses()
+	c.progress = nil
+	c.resolved = nil
+	c.taskChain = append([]string{}, e.taskChain...)
+	c.outputPrefix = e.outputPrefix + fmt.Sprintf("[%s] ", task.Name)
+
+	s := &service{group: c.processes, done: make(chan struct{})}
+
+	go func() {
+		defer c.RecoverPanic()
+		defer close(s.done)
+
+		err := c.dispatchTask(task, false)
+		if err != nil && !s.stopped.Load() && !e.options.Quiet {
+			message, _, _ := strings.Cut(err.Error(), "\n")
+			fmt.Fprintf(os.Stderr, "Service '%s' exited: %s\n", task.Name, message)
+		}
+	}()
+
+	return s
+}
+
+// Stops the service and waits for it to exit.
+func (s *services) stop(svc *service) {
+	svc.stopped.Store(true)
+	s.stopGroup(svc.group)
+	<-svc.done
+}
+
+// Stops all the running services, by name, at the end of the invocation.
+func (s *services) stopAll() {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	running := s.running
+	s.running = make(map[string]*service)
+	s.mu.Unlock()
+
+	for _, name := range sortedKeys(running) {
+		s.stop(running[name])
+	}
+}
+
+// Fails when a task with restart references, through its run entries,
+// deps, hooks or events, a task which ends up referencing it back. As a
+// service, it would be started by the very task it's waiting on.
+func (p *Parser) validateServices() error {
+	for _, name := range p.taskNames() {
+		if !p.Tasks[name].Restart {
+			continue
+		}
+
+		if path := p.referencePath(name, name, map[string]bool{}); path != nil {
+			return fmt.Errorf(
+				"task '%s': restart tasks run as services of the tasks referencing them, so it can't reference task '%s', which runs it: %s",
+				name, path[1], strings.Join(path, " -> "),
+			)
+		}
+	}
+
+	return nil
+}
+
+// The path of references from the task to the target, including both, or
+// nil when the task doesn't reference it.
+func (p *Parser) referencePath(from string, target string, visited map[string]bool) []string {
+	visited[from] = true
+
+	for _, ref := range p.taskReferences(p.Tasks[from]) {
+		if ref == target {
+			return []string{from, ref}
+		}
+
+		if visited[ref] {
+			continue
+		}
+
+		if path := p.referencePath(ref, target, visited); path != nil {
+			return append([]string{from}, path...)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,164 @@
+package internal
+
+import (
+	"errors"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const servicesConfig = `
+server:
+  restart: true
+  files: [cmd/server/*.go]
+  run:
+    - "serve --port 8080"
+
+dev:
+  files: [web/*.js]
+  run:
+    - server
+    - "npm run build"
+    - server
+`
+
+// A fake service process, which runs until it's stopped like the processes
+// of a service, see services.stopGroup.
+type fakeService struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	starts int
+	stops  int
+}
+
+// Records the commands, with "serve" blocking until it's stopped.
+func newFakeService(env *InMemoryEnv, e *Executor) *fakeService {
+	f := &fakeService{}
+	f.cond = sync.NewCond(&f.mu)
+
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		if cmd.Args[0] != "serve" {
+			return nil
+		}
+
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.starts++
+		for started := f.starts; f.stops < started; {
+			f.cond.Wait()
+		}
+
+		return errors.New("signal: terminated")
+	}
+
+	e.services.stopGroup = func(*processes) {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		f.stops++
+		f.cond.Broadcast()
+	}
+
+	return f
+}
+
+// Waits for the service to have started the given number of times.
+func (f *fakeService) requireStarts(t *testing.T, starts int) {
+	require.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+
+		return f.starts == starts
+	}, time.Second, time.Millisecond)
+}
+
+func (f *fakeService) counts() (int, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.starts, f.stops
+}
+
+func TestServicesStartOnceAndStopAtTheEnd(t *testing.T) {
+	env := NewInMemoryEnv(servicesConfig)
+	e := newCaptureExecutor(t, env)
+	f := newFakeService(env, &e)
+
+	require.Nil(t, e.Start([]string{"dev"}))
+
+	starts, stops := f.counts()
+	require.Equal(t, 1, starts)
+	require.Equal(t, 1, stops)
+	require.Contains(t, recordedCommands(env), "npm run build")
+	require.Empty(t, e.services.running)
+}
+
+func TestServicesAreReusedAcrossWatchIterations(t *testing.T) {
+	env := NewInMemoryEnv(servicesConfig)
+	require.Nil(t, env.FS.WriteFile("cmd/server/main.go", []byte("package main"), 0644))
+	require.Nil(t, env.FS.WriteFile("web/app.js", []byte("app"), 0644))
+
+	e := newCaptureExecutor(t, env)
+	f := newFakeService(env, &e)
+	dev := e.parser.Tasks["dev"]
+
+	for i := 0; i < 3; i++ {
+		_, err := e.watchedRun(dev, false)
+		require.Nil(t, err)
+		f.requireStarts(t, 1)
+	}
+
+	// Only the files of the watched task changed.
+	require.Nil(t, env.FS.WriteFile("web/admin.js", []byte("admin"), 0644))
+	_, err := e.watchedRun(dev, false)
+	require.Nil(t, err)
+	f.requireStarts(t, 1)
+
+	// The files of the service changed, which restarts it.
+	require.Nil(t, env.FS.WriteFile("cmd/server/routes.go", []byte("package main"), 0644))
+	_, err = e.watchedRun(dev, false)
+	require.Nil(t, err)
+	f.requireStarts(t, 2)
+
+	starts, stops := f.counts()
+	require.Equal(t, 2, starts)
+	require.Equal(t, 1, stops)
+
+	e.services.stopAll()
+
+	starts, stops = f.counts()
+	require.Equal(t, 2, starts)
+	require.Equal(t, 2, stops)
+	require.Equal(t, 5, countCommands(recordedCommands(env), "npm run build"))
+}
+
+func TestServicesCantReferenceTheTasksRunningThem(t *testing.T) {
+	_, err := NewInMemoryEnv(`
+server:
+  restart: true
+  run:
+    - "serve --port 8080"
+    - dev
+
+dev:
+  run:
+    - server
+`).Parse()
+
+	require.EqualError(t, err, "task 'server': restart tasks run as services of the tasks referencing them, so it can't reference task 'dev', which runs it: server -> dev -> server")
+}
+
+func countCommands(commands []string, cmd string) int {
+	count := 0
+	for _, c := range commands {
+		if c == cmd {
+			count++
+		}
+	}
+
+	return count
+}
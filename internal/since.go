@@ -0,0 +1,175 @@
+package internal
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCapability("run.since")
+}
+
+// Where the tasks skipped by --since are reported.
+var sinceOutput io.Writer = os.Stderr
+
+// Runs git with the arguments and returns its stdout, replaced in tests.
+type gitFunc func(args ...string) ([]byte, error)
+
+func runGit(args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil && stderr.Len() > 0 {
+		return nil, errors.New(strings.TrimSpace(stderr.String()))
+	}
+
+	return out, err
+}
+
+// The files which changed according to --since: the ones git reports as
+// changed since a ref, including untracked ones, or the ones modified
+// within a duration. It never looks at the lockfile.
+type sinceFilter struct {
+	value string
+
+	// The changed files, relative to the working directory, with a ref.
+	changed map[string]bool
+
+	// Files modified after it changed, with a duration.
+	after time.Time
+	fs    FileSystem
+}
+
+// Reads the change set of the --since value, which is a duration like
+// "2h" when it parses as one, and a git ref otherwise.
+func newSinceFilter(value string, fs FileSystem, now time.Time, git gitFunc) (*sinceFilter, error) {
+	if d, err := time.ParseDuration(value); err == nil {
+		if d <= 0 {
+			return nil, fmt.Errorf("--since %s must be a positive duration", value)
+		}
+
+		return &sinceFilter{value: value, after: now.Add(-d), fs: fs}, nil
+	}
+
+	if _, err := git("rev-parse", "--is-inside-work-tree"); err != nil {
+		return nil, fmt.Errorf("--since %s needs a git repository, pass a duration like 2h instead", value)
+	}
+
+	s := &sinceFilter{value: value, changed: make(map[string]bool)}
+	for _, args := range [][]string{
+		{"diff", "--name-only", "--relative", value, "--"},
+		{"ls-files", "--others", "--exclude-standard"},
+	} {
+		out, err := git(args...)
+		if err != nil {
+			return nil, fmt.Errorf("--since %s: %w", value, err)
+		}
+
+		for _, line := range strings.Split(string(out), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				s.changed[filepath.FromSlash(line)] = true
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// The files of the task which changed, in order. With a ref, deleted files
+// which match its patterns count too.
+func (s *sinceFilter) changedFiles(files []string, patterns []string) ([]string, error) {
+	changed := make(map[string]bool)
+
+	for _, f := range files {
+		if s.changed != nil {
+			if s.changed[f] {
+				changed[f] = true
+			}
+			continue
+		}
+
+		info, err := s.fs.Stat(f)
+		if err != nil {
+			return nil, err
+		}
+
+		if info.ModTime().After(s.after) {
+			changed[f] = true
+		}
+	}
+
+	for f := range s.changed {
+		if matchesPatterns(patterns, f) {
+			changed[f] = true
+		}
+	}
+
+	return sortedKeys(changed), nil
+}
+
+// Reads the change set of --since once, before any task runs.
+func (e *Executor) initSince() error {
+	if e.options.Since == "" || e.since != nil {
+		return nil
+	}
+
+	git := e.git
+	if git == nil {
+		git = runGit
+	}
+
+	since, err := newSinceFilter(e.options.Since, e.lockfile.fs, time.Now(), git)
+	if err != nil {
+		return err
+	}
+
+	e.since = since
+	return nil
+}
+
+// Whether some files of the task changed according to --since, which
+// replaces the lockfile to decide whether the task runs. Tasks without
+// files always run.
+func (e *Executor) changedSince(task Task) (bool, error) {
+	files, _ := e.parser.inputFiles(task)
+	patterns := e.parser.inputPatterns(task)
+
+	if len(files) == 0 && len(patterns) == 0 {
+		e.printSince("%s: has no files, running it regardless of --since", task.Name)
+		return true, nil
+	}
+
+	changed, err := e.since.changedFiles(files, patterns)
+	if err != nil {
+		return false, err
+	}
+
+	e.changed.record(task.Name, changed, files)
+	if len(changed) == 0 {
+		e.printSince("%s: skipped, none of its files changed since %s", task.Name, e.since.value)
+		return false, nil
+	}
+
+	e.logVerbose(fmt.Sprintf("%s: changed since %s: %s", task.Name, e.since.value, strings.Join(changed, ", ")))
+	return true, nil
+}
+
+// Reports why a task runs or not with --since, along the other commands
+// with --dry-run.
+func (e *Executor) printSince(format string, args ...any) {
+	switch {
+	case e.options.DryRun:
+		e.printDryRun(format, args...)
+	case !e.options.Quiet:
+		fmt.Fprintf(sinceOutput, format+"\n", args...)
+	}
+}
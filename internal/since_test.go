@@ -0,0 +1,143 @@
+package internal
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const sinceConfig = `
+lint:
+  files: ["*.go"]
+  run: ["golangci-lint run"]
+
+test:
+  files: ["internal/*.go"]
+  run: ["go test ./internal"]
+
+release:
+  run: ["goreleaser"]
+`
+
+// Answers like git would, with the given output of "git diff".
+func fakeGit(diff string, untracked string) gitFunc {
+	return func(args ...string) ([]byte, error) {
+		switch args[0] {
+		case "diff":
+			return []byte(diff), nil
+		case "ls-files":
+			return []byte(untracked), nil
+		}
+
+		return []byte("true\n"), nil
+	}
+}
+
+func newSinceEnv(t *testing.T, since string) *InMemoryEnv {
+	env := NewInMemoryEnv(sinceConfig)
+	env.Options.Since = since
+	require.NoError(t, env.FS.WriteFile("main.go", []byte("package main"), 0644))
+	require.NoError(t, env.FS.WriteFile("internal/util.go", []byte("package internal"), 0644))
+
+	return env
+}
+
+func sinceExecutor(t *testing.T, env *InMemoryEnv, git gitFunc) *Executor {
+	p, err := env.Parse()
+	require.NoError(t, err)
+
+	e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+	e.runner = env.Runner
+	e.git = git
+
+	return &e
+}
+
+func TestSinceRef(t *testing.T) {
+	t.Parallel()
+
+	env := newSinceEnv(t, "origin/main")
+	e := sinceExecutor(t, env, fakeGit("main.go\ndocs/README.md\n", ""))
+
+	path, err := e.lockfile.getLockfilePath()
+	require.NoError(t, err)
+	lockfile, err := env.FS.ReadFile(path)
+	require.NoError(t, err)
+
+	dispatched, err := e.executeTasks([]string{"lint", "test", "release"})
+	require.NoError(t, err)
+	require.True(t, dispatched)
+	require.Equal(t, []string{"golangci-lint run", "goreleaser"}, recordedCommands(env))
+
+	// The lockfile plays no part with --since.
+	after, err := env.FS.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, string(lockfile), string(after))
+}
+
+func TestSinceRefCountsUntrackedAndDeletedFiles(t *testing.T) {
+	t.Parallel()
+
+	env := newSinceEnv(t, "HEAD~1")
+	e := sinceExecutor(t, env, fakeGit("internal/removed.go\n", "internal/util.go\n"))
+
+	require.NoError(t, e.initSince())
+	changed, err := e.since.changedFiles([]string{"internal/util.go"}, []string{"internal/*.go"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"internal/removed.go", "internal/util.go"}, changed)
+}
+
+func TestSinceDuration(t *testing.T) {
+	t.Parallel()
+
+	env := newSinceEnv(t, "2h")
+	require.NoError(t, env.FS.Chtimes("main.go", time.Now().Add(-3*time.Hour)))
+	require.NoError(t, env.FS.Chtimes("internal/util.go", time.Now().Add(-time.Minute)))
+
+	// Durations never need git.
+	e := sinceExecutor(t, env, func(args ...string) ([]byte, error) {
+		return nil, errors.New("not a git repository")
+	})
+
+	_, err := e.executeTasks([]string{"lint", "test"})
+	require.NoError(t, err)
+	require.Equal(t, []string{"go test ./internal"}, recordedCommands(env))
+}
+
+func TestSinceEmptyChangeSet(t *testing.T) {
+	out := stubDryRunOutput(t)
+
+	env := newSinceEnv(t, "origin/main")
+	env.Options.DryRun = true
+	e := sinceExecutor(t, env, fakeGit("", ""))
+
+	_, err := e.executeTasks([]string{"lint", "test", "release"})
+	require.NoError(t, err)
+	require.Equal(t, strings.Join([]string{
+		"lint: skipped, none of its files changed since origin/main",
+		"test: skipped, none of its files changed since origin/main",
+		"release: has no files, running it regardless of --since",
+		"release:",
+		"  goreleaser",
+		"",
+	}, "\n"), out.String())
+}
+
+func TestSinceRequiresGitForRefs(t *testing.T) {
+	t.Parallel()
+
+	env := newSinceEnv(t, "origin/main")
+	e := sinceExecutor(t, env, func(args ...string) ([]byte, error) {
+		return nil, errors.New("exit status 128")
+	})
+
+	_, err := e.executeTasks([]string{"lint"})
+	require.EqualError(t, err, "--since origin/main needs a git repository, pass a duration like 2h instead")
+	require.Empty(t, recordedCommands(env))
+
+	_, err = newSinceFilter("-1h", env.FS, time.Now(), runGit)
+	require.EqualError(t, err, "--since -1h must be a positive duration")
+}
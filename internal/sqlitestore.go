@@ -0,0 +1,469 @@
+package internal
+
+import (
+	"database/sql"
+	"encoding/json"
+	"net/url"
+	"os/user"
+	"path"
+	"path/filepath"
+	"sync"
+
+	_ "modernc.org/sqlite"
+)
+
+func init() {
+	RegisterCapability("state.sqlite")
+}
+
+// Bumped whenever the tables of the database change, see
+// sqliteStateStore.migrate.
+const sqliteStateVersion = "2"
+
+const sqliteStateSchema = `
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS files (
+	project TEXT NOT NULL,
+	task    TEXT NOT NULL,
+	file    TEXT NOT NULL,
+	mtime   INTEGER NOT NULL,
+	target  TEXT NOT NULL DEFAULT '',
+	sha256  TEXT NOT NULL DEFAULT '',
+	missing INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (project, task, file)
+);
+
+CREATE TABLE IF NOT EXISTS history (
+	project      TEXT NOT NULL,
+	task         TEXT NOT NULL,
+	last_success INTEGER NOT NULL,
+	metadata     TEXT NOT NULL DEFAULT '',
+	PRIMARY KEY (project, task)
+);
+`
+
+// The store of state_backend: sqlite, a single database in the state
+// directory, or in the home directory for the projects without one. Flushing
+// only writes the rows which changed, which makes a difference for projects
+// recording tens of thousands of files, and every flush is a transaction, so
+// a goke killed while flushing leaves the previous state. The database runs
+// in WAL mode, where concurrent invocations read while another one writes.
+type sqliteStateStore struct {
+	path    string
+	options Options
+	fs      FileSystem
+
+	once sync.Once
+	db   *sql.DB
+	err  error
+
+	// Guards the pending changes.
+	mu      sync.Mutex
+	pending stateChanges
+}
+
+var (
+	sqliteStoresMu sync.Mutex
+	sqliteStores   = map[string]*sqliteStateStore{}
+)
+
+// Returns the store of the database of the options, shared by the lockfile
+// and the history, and by the subprojects.
+func sharedSQLiteStateStore(opts Options, fs FileSystem) StateStore {
+	dbPath, err := sqliteStatePath(opts)
+	if err != nil {
+		return &sqliteStateStore{options: opts, fs: fs, err: err}
+	}
+
+	sqliteStoresMu.Lock()
+	defer sqliteStoresMu.Unlock()
+
+	if s, ok := sqliteStores[dbPath]; ok {
+		return s
+	}
+
+	s := &sqliteStateStore{path: dbPath, options: opts, fs: fs}
+	sqliteStores[dbPath] = s
+
+	return s
+}
+
+// Returns the location of the database in the system.
+func sqliteStatePath(opts Options) (string, error) {
+	if opts.StateDir != "" {
+		return path.Join(opts.StateDir, "state.db"), nil
+	}
+
+	user, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(user.HomeDir, ".goke.db"), nil
+}
+
+// Opens the database on first use, creating its tables and importing the
+// state of the files the first time, see migrate.
+func (s *sqliteStateStore) open() (*sql.DB, error) {
+	s.once.Do(func() {
+		if s.err != nil {
+			return
+		}
+
+		// Writing transactions take the lock when they begin, so that
+		// concurrent invocations wait on each other for busy_timeout
+		// rather than fail when upgrading a read lock.
+		dsn := "file:" + filepath.ToSlash(s.path) + "?" + url.Values{
+			"_pragma": {"busy_timeout(10000)", "journal_mode(WAL)", "synchronous(NORMAL)"},
+			"_txlock": {"immediate"},
+		}.Encode()
+
+		if s.db, s.err = sql.Open("sqlite", dsn); s.err != nil {
+			return
+		}
+
+		if s.err = s.migrate(); s.err != nil {
+			s.db.Close()
+		}
+	})
+
+	return s.db, s.err
+}
+
+// Creates the tables, and imports the lockfile and the history which the
+// file store wrote before, so that switching backends doesn't rerun every
+// task. The files stay in place, untouched from then on.
+func (s *sqliteStateStore) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(sqliteStateSchema); err != nil {
+		return err
+	}
+
+	var version string
+	err = tx.QueryRow("SELECT value FROM meta WHERE key = 'version'").Scan(&version)
+	if err == nil {
+		// Databases of version 1 have no metadata in their history.
+		if version == "1" {
+			if _, err := tx.Exec("ALTER TABLE history ADD COLUMN metadata TEXT NOT NULL DEFAULT ''"); err != nil {
+				return err
+			}
+
+			if _, err := tx.Exec("UPDATE meta SET value = ? WHERE key = 'version'", sqliteStateVersion); err != nil {
+				return err
+			}
+		}
+
+		return tx.Commit()
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	files := &fileStateStore{options: s.options, fs: s.fs}
+	files.options.Quiet = true
+	imported := stateChanges{}
+
+	if projects, _, err := files.LoadFiles(); err == nil {
+		for project, tasks := range projects {
+			for task, recorded := range tasks {
+				for file, entry := range recorded {
+					imported.set(project, task, file, entry)
+				}
+			}
+		}
+	}
+
+	if history, err := files.LoadHistory(); err == nil {
+		for project, tasks := range history {
+			for task, entry := range tasks {
+				imported.setLastSuccess(project, task, entry)
+			}
+		}
+	}
+
+	if err := writeStateChanges(tx, imported); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("INSERT INTO meta (key, value) VALUES ('version', ?)", sqliteStateVersion); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s *sqliteStateStore) LoadFiles() (lockFileJson, bool, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, false, err
+	}
+
+	rows, err := db.Query("SELECT project, task, file, mtime, target, sha256, missing FROM files")
+	if err != nil {
+		return nil, false, err
+	}
+	defer rows.Close()
+
+	projects := lockFileJson{}
+	for rows.Next() {
+		var project, task, file string
+		var entry fileEntry
+
+		if err := rows.Scan(&project, &task, &file, &entry.ModTime, &entry.Target, &entry.Hash, &entry.Missing); err != nil {
+			return nil, false, err
+		}
+
+		if projects[project] == nil {
+			projects[project] = make(singleProjectJson)
+		}
+		if projects[project][task] == nil {
+			projects[project][task] = make(taskFilesJson)
+		}
+		projects[project][task][file] = entry
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, false, err
+	}
+
+	found := len(projects) > 0
+
+	s.mu.Lock()
+	s.pending.applyFiles(projects)
+	s.mu.Unlock()
+
+	return projects, found, nil
+}
+
+func (s *sqliteStateStore) Get(project string, task string, file string) (fileEntry, bool, error) {
+	s.mu.Lock()
+	entry, found, known := s.pending.get(project, task, file)
+	s.mu.Unlock()
+
+	if known {
+		return entry, found, nil
+	}
+
+	db, err := s.open()
+	if err != nil {
+		return fileEntry{}, false, err
+	}
+
+	err = db.QueryRow("SELECT mtime, target, sha256, missing FROM files WHERE project = ? AND task = ? AND file = ?", project, task, file).
+		Scan(&entry.ModTime, &entry.Target, &entry.Hash, &entry.Missing)
+	if err == sql.ErrNoRows {
+		return fileEntry{}, false, nil
+	}
+	if err != nil {
+		return fileEntry{}, false, err
+	}
+
+	return entry, true, nil
+}
+
+func (s *sqliteStateStore) Set(project string, task string, file string, entry fileEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending.set(project, task, file, entry)
+}
+
+func (s *sqliteStateStore) DeleteTask(project string, task string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending.deleteTask(project, task)
+}
+
+func (s *sqliteStateStore) LastSuccess(project string, task string) (historyEntry, bool, error) {
+	s.mu.Lock()
+	entry, ok := s.pending.lastSuccess(project, task)
+	s.mu.Unlock()
+
+	if ok {
+		return entry, true, nil
+	}
+
+	db, err := s.open()
+	if err != nil {
+		return historyEntry{}, false, err
+	}
+
+	var metadata string
+	err = db.QueryRow("SELECT last_success, metadata FROM history WHERE project = ? AND task = ?", project, task).Scan(&entry.At, &metadata)
+	if err == sql.ErrNoRows {
+		return historyEntry{}, false, nil
+	}
+	if err != nil {
+		return historyEntry{}, false, err
+	}
+
+	entry.Metadata, err = decodeRunMetadata(metadata)
+	return entry, err == nil, err
+}
+
+func (s *sqliteStateStore) SetLastSuccess(project string, task string, entry historyEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending.setLastSuccess(project, task, entry)
+}
+
+func (s *sqliteStateStore) Update(fn func(tx StateTx) error) error {
+	return updateState(s, fn)
+}
+
+func (s *sqliteStateStore) Flush() error {
+	db, err := s.open()
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.pending.empty() {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := writeStateChanges(tx, s.pending); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	s.pending = stateChanges{}
+	return nil
+}
+
+// Writes the rows of the changes: deletes the files of the deleted tasks,
+// then replaces the rows of the files and the history which were set.
+func writeStateChanges(tx *sql.Tx, changes stateChanges) error {
+	for key := range changes.deleted {
+		if _, err := tx.Exec("DELETE FROM files WHERE project = ? AND task = ?", key.project, key.task); err != nil {
+			return err
+		}
+	}
+
+	insert, err := tx.Prepare("INSERT OR REPLACE INTO files (project, task, file, mtime, target, sha256, missing) VALUES (?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer insert.Close()
+
+	for key, files := range changes.files {
+		for file, entry := range files {
+			if _, err := insert.Exec(key.project, key.task, file, entry.ModTime, entry.Target, entry.Hash, entry.Missing); err != nil {
+				return err
+			}
+		}
+	}
+
+	for key, entry := range changes.history {
+		metadata, err := encodeRunMetadata(entry.Metadata)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("INSERT OR REPLACE INTO history (project, task, last_success, metadata) VALUES (?, ?, ?, ?)", key.project, key.task, entry.At, metadata); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// The metadata column of a history entry holds the metadata as JSON, or
+// nothing when it's unknown.
+func encodeRunMetadata(meta *RunMetadata) (string, error) {
+	if meta == nil {
+		return "", nil
+	}
+
+	contents, err := json.Marshal(meta)
+	return string(contents), err
+}
+
+func decodeRunMetadata(column string) (*RunMetadata, error) {
+	if column == "" {
+		return nil, nil
+	}
+
+	meta := &RunMetadata{}
+	return meta, json.Unmarshal([]byte(column), meta)
+}
+
+func (s *sqliteStateStore) LoadHistory() (historyFileJson, error) {
+	db, err := s.open()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT project, task, last_success, metadata FROM history")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	history := historyFileJson{}
+	for rows.Next() {
+		var project, task, metadata string
+		var entry historyEntry
+
+		if err := rows.Scan(&project, &task, &entry.At, &metadata); err != nil {
+			return nil, err
+		}
+
+		meta, err := decodeRunMetadata(metadata)
+		if err != nil {
+			return nil, err
+		}
+		entry.Metadata = meta
+
+		if history[project] == nil {
+			history[project] = make(taskHistoryJson)
+		}
+		history[project][task] = entry
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.pending.applyHistory(history)
+	s.mu.Unlock()
+
+	return history, nil
+}
+
+// Closes the database, which the next store of its path opens again. The
+// changes which weren't flushed are dropped.
+func (s *sqliteStateStore) Close() error {
+	sqliteStoresMu.Lock()
+	defer sqliteStoresMu.Unlock()
+
+	delete(sqliteStores, s.path)
+	if s.db == nil {
+		return nil
+	}
+
+	return s.db.Close()
+}
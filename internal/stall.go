@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	RegisterCapability("run.stall_hint", "task.interactive")
+}
+
+// How long a command runs without any output before it's reported as
+// waiting for input, unless global.stall_after says otherwise.
+const defaultStallAfter = 30 * time.Second
+
+const stallHint = "command appears to be waiting for input; pass `interactive: true` or provide input via the config"
+
+// The time after which silent commands are reported, see watchStall.
+func (p *Parser) stallAfter() time.Duration {
+	if p.Global.Shared.StallAfter > 0 {
+		return p.Global.Shared.StallAfter
+	}
+
+	return defaultStallAfter
+}
+
+// Watches a command for output, see watchStall.
+type stallWatch struct {
+	output   atomic.Bool
+	finished atomic.Bool
+	stop     func()
+}
+
+// Reports the command once it ran for global.stall_after without any
+// output. Only interactive commands get goke's stdin, the others read from
+// the null device, so that one waiting for input anyway, ie. psql or ssh
+// asking for a password on the terminal, would otherwise keep the spinner
+// going forever. The command keeps running. Returns nil for interactive
+// commands.
+func (e *Executor) watchStall(entry RunEntry, line string) *stallWatch {
+	if entry.interactive {
+		return nil
+	}
+
+	timer := e.newStallTimer
+	if timer == nil {
+		timer = newRealTimer
+	}
+
+	s := &stallWatch{}
+	s.stop = timer(e.parser.stallAfter(), func() {
+		if !s.output.Load() && !s.finished.Load() {
+			e.reportStall(line)
+		}
+	})
+
+	return s
+}
+
+// Notes the output written to w.
+func (s *stallWatch) watch(w io.Writer) io.Writer {
+	if s == nil || w == nil {
+		return w
+	}
+
+	return stallWriter{w: w, watch: s}
+}
+
+// Whether the command printed anything. Unwatched commands count as having
+// printed, since nothing is known about their output.
+func (s *stallWatch) printed() bool {
+	return s == nil || s.output.Load()
+}
+
+// Stops watching once the command exited.
+func (s *stallWatch) end() {
+	if s == nil {
+		return
+	}
+
+	s.finished.Store(true)
+	s.stop()
+}
+
+type stallWriter struct {
+	w     io.Writer
+	watch *stallWatch
+}
+
+func (sw stallWriter) Write(p []byte) (int, error) {
+	if len(p) > 0 {
+		sw.watch.output.Store(true)
+	}
+
+	return sw.w.Write(p)
+}
+
+// Shows the hint in the progress line, and prints it, which ends up in the
+// log of detached watch sessions too.
+func (e *Executor) reportStall(line string) {
+	if e.options.Quiet {
+		return
+	}
+
+	e.spinnerMessage(fmt.Sprintf("Waiting for input? %s", line))
+	fmt.Fprintf(os.Stderr, "Warning: '%s' produced no output for %s: %s\n", line, e.parser.stallAfter(), stallHint)
+}
@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Runs the task with the stall timers recorded instead of started. The
+// handler is the command, which may fire the timers while it runs.
+func runWithStallTimers(t *testing.T, env *InMemoryEnv, handler func(cmd *exec.Cmd, timers *[]fakeDeadlineTimer) error) (string, []fakeDeadlineTimer) {
+	timers := []fakeDeadlineTimer{}
+	env.Options.Quiet = false
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		return handler(cmd, &timers)
+	}
+
+	out := captureTerminal(t, func() {
+		e := newCaptureExecutor(t, env)
+		e.newStallTimer = func(d time.Duration, f func()) func() {
+			timers = append(timers, fakeDeadlineTimer{d: d, f: f})
+			return func() {}
+		}
+
+		require.Nil(t, e.Start([]string{"db"}))
+	})
+
+	return out, timers
+}
+
+func TestSilentCommandsAreReportedAsWaitingForInput(t *testing.T) {
+	env := NewInMemoryEnv("db:\n  run:\n    - \"psql\"\n")
+
+	// Like psql asking for a password, the command blocks until the hint.
+	out, timers := runWithStallTimers(t, env, func(cmd *exec.Cmd, timers *[]fakeDeadlineTimer) error {
+		require.Nil(t, cmd.Stdin)
+		(*timers)[0].f()
+		return nil
+	})
+
+	require.Len(t, timers, 1)
+	require.Equal(t, defaultStallAfter, timers[0].d)
+	require.Contains(t, out, "Warning: 'psql' produced no output for 30s: "+stallHint+"\n")
+}
+
+func TestCommandsWithOutputAreNotReported(t *testing.T) {
+	env := NewInMemoryEnv("global:\n  stall_after: 5s\n\ndb:\n  run:\n    - \"pg_dump app\"\n")
+
+	out, timers := runWithStallTimers(t, env, func(cmd *exec.Cmd, timers *[]fakeDeadlineTimer) error {
+		fmt.Fprintln(cmd.Stdout, "-- PostgreSQL database dump")
+		(*timers)[0].f()
+		return nil
+	})
+
+	require.Equal(t, 5*time.Second, timers[0].d)
+	require.NotContains(t, out, stallHint)
+}
+
+func TestInteractiveCommandsReadStdin(t *testing.T) {
+	env := NewInMemoryEnv("db:\n  interactive: true\n  run:\n    - \"psql\"\n")
+
+	out, timers := runWithStallTimers(t, env, func(cmd *exec.Cmd, timers *[]fakeDeadlineTimer) error {
+		require.Equal(t, os.Stdin, cmd.Stdin)
+		return nil
+	})
+
+	require.Empty(t, timers)
+	require.NotContains(t, out, stallHint)
+}
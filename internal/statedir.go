@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+func init() {
+	RegisterCapability("state.local_dir", "state.file_lock")
+}
+
+// Where goke keeps the lockfile and the cache of the config, see --state.
+const (
+	// The project's .goke directory when it exists or the project is a git
+	// repository, and the home and temp directories otherwise.
+	StateAuto = "auto"
+
+	// Always the project's .goke directory.
+	StateLocal = "local"
+
+	// Always the home and temp directories.
+	StateGlobal = "global"
+)
+
+// The project-local state directory, next to goke.yml.
+const stateDirName = ".goke"
+
+// Decides where the state of the project in the working directory lives,
+// see --state, and creates the project's .goke directory when it's used.
+// A .goke directory created by goke ignores itself in git.
+func ResolveStateDir(opts *Options) error {
+	local := false
+
+	switch opts.State {
+	case "", StateAuto:
+		info, err := os.Stat(stateDirName)
+		local = err == nil && info.IsDir()
+		if _, err := os.Stat(".git"); err == nil {
+			local = true
+		}
+	case StateLocal:
+		local = true
+	case StateGlobal:
+	default:
+		return fmt.Errorf("--state must be %s, %s or %s, got '%s'", StateAuto, StateLocal, StateGlobal, opts.State)
+	}
+
+	if !local {
+		return nil
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Join(cwd, stateDirName)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return err
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, ".gitignore"), []byte("*\n"), 0644); err != nil {
+			return err
+		}
+	}
+
+	opts.StateDir = dir
+	return nil
+}
+
+// Implemented by the filesystems which can hold an advisory lock on a file,
+// see withStateLock.
+type fileLocker interface {
+	// Blocks until the lock on the file, which is created if needed, is
+	// acquired, and returns the function releasing it.
+	Lock(name string) (func(), error)
+}
+
+// Runs fn while holding the lock of the state directory, so that concurrent
+// goke invocations don't write the lockfile or the cache at the same time.
+// The state of the home and temp directories isn't locked, nor the one of
+// filesystems which can't lock files.
+func withStateLock(fs FileSystem, opts Options, fn func() error) error {
+	locker, ok := fs.(fileLocker)
+	if opts.StateDir == "" || !ok {
+		return fn()
+	}
+
+	unlock, err := locker.Lock(filepath.Join(opts.StateDir, "lock"))
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	return fn()
+}
+
+// The name of the cache file of the config in the state directory. Configs
+// other than goke.yml, given with --config, get their own.
+func (p *Parser) localCacheName() string {
+	cwd, _ := p.fs.Getwd()
+	name := "cache"
+
+	if f := p.options.ConfigPath; f != "" {
+		if !filepath.IsAbs(f) {
+			f = filepath.Join(cwd, f)
+		}
+
+		if f != filepath.Join(cwd, p.defaultConfigFile()) {
+			if rel, err := filepath.Rel(cwd, f); err == nil {
+				f = rel
+			}
+			name += pathFileName(f)
+		}
+	}
+
+	return name
+}
+
+// Moves the cache of the temp directory into the state directory, unless
+// there already is one, so that switching to the .goke directory doesn't
+// mean parsing again.
+func (p *Parser) migrateCache(cacheFile string) {
+	legacy := path.Join(p.fs.TempDir(), p.getTempFileName())
+	if p.fs.FileExists(cacheFile) || !p.fs.FileExists(legacy) {
+		return
+	}
+
+	data, err := p.fs.ReadFile(legacy)
+	if err != nil {
+		return
+	}
+
+	if p.fs.WriteFile(cacheFile, data, 0644) == nil {
+		_ = p.fs.Remove(legacy)
+	}
+}
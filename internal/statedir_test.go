@@ -0,0 +1,148 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveStateDir(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+
+	opts := Options{}
+	require.Nil(t, ResolveStateDir(&opts))
+	require.Empty(t, opts.StateDir)
+
+	// Git repositories keep their state in a .goke directory ignoring itself.
+	require.Nil(t, os.Mkdir(".git", 0755))
+	require.Nil(t, ResolveStateDir(&opts))
+	require.Equal(t, filepath.Join(dir, ".goke"), opts.StateDir)
+
+	ignore, err := os.ReadFile(filepath.Join(".goke", ".gitignore"))
+	require.Nil(t, err)
+	require.Equal(t, "*\n", string(ignore))
+
+	opts = Options{State: StateGlobal}
+	require.Nil(t, ResolveStateDir(&opts))
+	require.Empty(t, opts.StateDir)
+
+	opts = Options{State: "home"}
+	require.EqualError(t, ResolveStateDir(&opts), "--state must be auto, local or global, got 'home'")
+}
+
+func TestResolveStateDirUsesAnExistingGokeDir(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	require.Nil(t, os.Mkdir(".goke", 0755))
+
+	opts := Options{}
+	require.Nil(t, ResolveStateDir(&opts))
+	require.Equal(t, filepath.Join(dir, ".goke"), opts.StateDir)
+	require.NoFileExists(t, filepath.Join(".goke", ".gitignore"))
+}
+
+func TestLocalStateMovesTheTempCache(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemFileSystem("/work")
+	require.Nil(t, fs.WriteFile("/work/goke.yml", []byte(yamlConfigStub), 0644))
+
+	opts := Options{Quiet: true}
+	p := NewParser(yamlConfigStub, &opts, fs)
+	require.Nil(t, p.Bootstrap())
+	legacy := p.cacheFile()
+	require.True(t, fs.FileExists(legacy))
+
+	opts.StateDir = "/work/.goke"
+	p = NewParser(yamlConfigStub, &opts, fs)
+	require.True(t, p.cached)
+	require.Equal(t, "/work/.goke/cache", p.cacheFile())
+	require.True(t, fs.FileExists("/work/.goke/cache"))
+	require.False(t, fs.FileExists(legacy))
+}
+
+func TestLocalStateMovesTheProjectOutOfTheGlobalLockfile(t *testing.T) {
+	t.Parallel()
+
+	fs := NewMemFileSystem("/work")
+	global, err := globalLockfilePath()
+	require.Nil(t, err)
+
+	contents, err := encodeLockfile(lockFileJson{
+		"/work":  {"build": {"main.go": {ModTime: 1}}},
+		"/other": {"lib": {"lib.go": {ModTime: 2}}},
+	})
+	require.Nil(t, err)
+	require.Nil(t, fs.WriteFile(global, contents, 0644))
+
+	l := NewLockfile(map[string][]string{"build": {"main.go"}}, &Options{StateDir: "/work/.goke"}, fs)
+	require.Nil(t, l.Bootstrap())
+	require.Equal(t, taskFilesJson{"main.go": {ModTime: 1}}, l.GetTaskFiles("build"))
+
+	contents, err = fs.ReadFile(global)
+	require.Nil(t, err)
+
+	rest, err := decodeLockfile(contents)
+	require.Nil(t, err)
+	require.Equal(t, lockFileJson{"/other": {"lib": {"lib.go": {ModTime: 2}}}}, rest)
+}
+
+func TestLocalLockfileKeepsConcurrentWrites(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	require.Nil(t, os.WriteFile("main.go", []byte("package main"), 0644))
+
+	opts := Options{StateDir: filepath.Join(dir, ".goke")}
+	require.Nil(t, os.Mkdir(opts.StateDir, 0755))
+
+	fs := &LocalFileSystem{}
+	root := NewLockfile(map[string][]string{"build": {"main.go"}}, &opts, fs)
+	require.Nil(t, root.Bootstrap())
+
+	// Another invocation records its project after this one loaded the
+	// lockfile.
+	other := NewLockfile(nil, &opts, fs)
+	require.Nil(t, other.Bootstrap())
+	other = other.ForProject(filepath.Join(dir, "sub"), nil)
+	require.Nil(t, other.UpdateTimestampsForFiles("build", []string{"main.go"}, true, false))
+
+	require.Nil(t, root.UpdateTimestampsForFiles("build", []string{"main.go"}, true, false))
+
+	contents, err := os.ReadFile(filepath.Join(opts.StateDir, "lockfile.json"))
+	require.Nil(t, err)
+
+	recorded, err := decodeLockfile(contents)
+	require.Nil(t, err)
+	require.Contains(t, recorded, dir)
+	require.Contains(t, recorded, filepath.Join(dir, "sub"))
+}
+
+func TestStateLockIsExclusive(t *testing.T) {
+	fs := &LocalFileSystem{}
+	name := filepath.Join(t.TempDir(), "lock")
+
+	unlock, err := fs.Lock(name)
+	require.Nil(t, err)
+
+	acquired := make(chan struct{})
+	go func() {
+		unlockOther, err := fs.Lock(name)
+		if err == nil {
+			unlockOther()
+		}
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("the lock was acquired twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	<-acquired
+}
@@ -0,0 +1,571 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path"
+	"sync"
+)
+
+func init() {
+	RegisterCapability("state.store", "state.atomic_writes")
+}
+
+// The backends of state_backend, which keep the recorded files of the tasks
+// and the history of their runs.
+const (
+	StateBackendFile   = "file"
+	StateBackendSQLite = "sqlite"
+)
+
+// Keeps the state goke records between runs: the files of each task, see
+// Lockfile, and when each task last ran successfully, see History. Both
+// load all of it when they start, and then read and change it one task and
+// file at a time. Changes stay pending until they are flushed, so that
+// recording the thousands of files of a task writes the store once.
+type StateStore interface {
+	StateTx
+
+	// Loads the recorded files of all the projects, false when nothing
+	// was ever recorded.
+	LoadFiles() (lockFileJson, bool, error)
+
+	// Loads when the tasks of all the projects last ran successfully.
+	LoadHistory() (historyFileJson, error)
+
+	// Writes the pending changes at once: either all of them make it to
+	// the store, or none do and they stay pending.
+	Flush() error
+
+	// Makes the changes of fn, which are flushed along with the other
+	// pending ones when it succeeds, and dropped when it fails.
+	Update(fn func(tx StateTx) error) error
+}
+
+// Reads and changes the recorded state one task and file at a time. The
+// reads see the changes still pending.
+type StateTx interface {
+	// Returns the recorded state of the file for the task of the project,
+	// false when there's none.
+	Get(project string, task string, file string) (fileEntry, bool, error)
+
+	// Records the state of the file for the task of the project.
+	Set(project string, task string, file string, entry fileEntry)
+
+	// Forgets every file recorded for the task of the project.
+	DeleteTask(project string, task string)
+
+	// Returns the last successful run of the task of the project, false
+	// when it never did.
+	LastSuccess(project string, task string) (historyEntry, bool, error)
+
+	// Records the last successful run of the task of the project.
+	SetLastSuccess(project string, task string, entry historyEntry)
+}
+
+// Returns the store of the backend of the options, the files by default.
+func newStateStore(opts Options, fs FileSystem) StateStore {
+	if opts.StateBackend == StateBackendSQLite {
+		return sharedSQLiteStateStore(opts, fs)
+	}
+
+	return &fileStateStore{options: opts, fs: fs}
+}
+
+func validateStateBackend(backend string) error {
+	switch backend {
+	case "", StateBackendFile, StateBackendSQLite:
+		return nil
+	}
+
+	return fmt.Errorf("global: state_backend must be %s or %s, got '%s'", StateBackendFile, StateBackendSQLite, backend)
+}
+
+// Replaces the files recorded for the task of the project.
+func setTaskFiles(tx StateTx, project string, task string, files taskFilesJson) {
+	tx.DeleteTask(project, task)
+	for file, entry := range files {
+		tx.Set(project, task, file, entry)
+	}
+}
+
+// Identifies the state recorded for a task of a project.
+type stateKey struct {
+	project string
+	task    string
+}
+
+// The changes made to a store which are yet to be flushed.
+type stateChanges struct {
+	// The tasks whose files were deleted, before the ones in files were
+	// set.
+	deleted map[stateKey]bool
+	files   map[stateKey]taskFilesJson
+	history map[stateKey]historyEntry
+}
+
+func (c *stateChanges) set(project string, task string, file string, entry fileEntry) {
+	key := stateKey{project, task}
+	if c.files == nil {
+		c.files = make(map[stateKey]taskFilesJson)
+	}
+	if c.files[key] == nil {
+		c.files[key] = make(taskFilesJson)
+	}
+
+	c.files[key][file] = entry
+}
+
+func (c *stateChanges) deleteTask(project string, task string) {
+	key := stateKey{project, task}
+	if c.deleted == nil {
+		c.deleted = make(map[stateKey]bool)
+	}
+
+	c.deleted[key] = true
+	delete(c.files, key)
+}
+
+func (c *stateChanges) setLastSuccess(project string, task string, entry historyEntry) {
+	if c.history == nil {
+		c.history = make(map[stateKey]historyEntry)
+	}
+
+	c.history[stateKey{project, task}] = entry
+}
+
+// Returns the pending state of the file, with known false when it's up to
+// the store to tell.
+func (c *stateChanges) get(project string, task string, file string) (entry fileEntry, found bool, known bool) {
+	key := stateKey{project, task}
+	if entry, ok := c.files[key][file]; ok {
+		return entry, true, true
+	}
+
+	return fileEntry{}, false, c.deleted[key]
+}
+
+func (c *stateChanges) lastSuccess(project string, task string) (historyEntry, bool) {
+	entry, ok := c.history[stateKey{project, task}]
+	return entry, ok
+}
+
+func (c *stateChanges) empty() bool {
+	return len(c.deleted) == 0 && len(c.files) == 0 && len(c.history) == 0
+}
+
+// Applies the changes to the files loaded from the store.
+func (c *stateChanges) applyFiles(projects lockFileJson) {
+	for key := range c.deleted {
+		delete(projects[key.project], key.task)
+	}
+
+	for key, files := range c.files {
+		if projects[key.project] == nil {
+			projects[key.project] = make(singleProjectJson)
+		}
+		if projects[key.project][key.task] == nil {
+			projects[key.project][key.task] = make(taskFilesJson)
+		}
+
+		for file, entry := range files {
+			projects[key.project][key.task][file] = entry
+		}
+	}
+}
+
+// Applies the changes to the history loaded from the store.
+func (c *stateChanges) applyHistory(history historyFileJson) {
+	for key, entry := range c.history {
+		if history[key.project] == nil {
+			history[key.project] = make(taskHistoryJson)
+		}
+
+		history[key.project][key.task] = entry
+	}
+}
+
+// Makes the changes to the store, pending until it flushes.
+func (c *stateChanges) applyTo(store StateTx) {
+	for key := range c.deleted {
+		store.DeleteTask(key.project, key.task)
+	}
+
+	for key, files := range c.files {
+		for file, entry := range files {
+			store.Set(key.project, key.task, file, entry)
+		}
+	}
+
+	for key, entry := range c.history {
+		store.SetLastSuccess(key.project, key.task, entry)
+	}
+}
+
+// The transaction of StateStore.Update, which keeps its changes apart from
+// the ones of the store until fn succeeds.
+type stateTx struct {
+	store   StateStore
+	changes stateChanges
+}
+
+func (tx *stateTx) Get(project string, task string, file string) (fileEntry, bool, error) {
+	if entry, found, known := tx.changes.get(project, task, file); known {
+		return entry, found, nil
+	}
+
+	return tx.store.Get(project, task, file)
+}
+
+func (tx *stateTx) Set(project string, task string, file string, entry fileEntry) {
+	tx.changes.set(project, task, file, entry)
+}
+
+func (tx *stateTx) DeleteTask(project string, task string) {
+	tx.changes.deleteTask(project, task)
+}
+
+func (tx *stateTx) LastSuccess(project string, task string) (historyEntry, bool, error) {
+	if entry, ok := tx.changes.lastSuccess(project, task); ok {
+		return entry, true, nil
+	}
+
+	return tx.store.LastSuccess(project, task)
+}
+
+func (tx *stateTx) SetLastSuccess(project string, task string, entry historyEntry) {
+	tx.changes.setLastSuccess(project, task, entry)
+}
+
+// Implements StateStore.Update for every backend.
+func updateState(store StateStore, fn func(tx StateTx) error) error {
+	tx := &stateTx{store: store}
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	tx.changes.applyTo(store)
+	return store.Flush()
+}
+
+// The default store, which writes the lockfile and the history as JSON,
+// see lockfilePath and historyPath. Every flush rewrites the whole file
+// with the changes applied to what was loaded, see LoadFiles and
+// LoadHistory.
+type fileStateStore struct {
+	options Options
+	fs      FileSystem
+
+	// Guards the state loaded from the files and the pending changes.
+	mu      sync.Mutex
+	files   lockFileJson
+	history historyFileJson
+	pending stateChanges
+}
+
+func (s *fileStateStore) LoadFiles() (lockFileJson, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	lockfilePath, err := lockfilePath(s.options)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var projects lockFileJson
+	found := false
+
+	err = withStateLock(s.fs, s.options, func() error {
+		projects, found, err = s.readLockfile(lockfilePath)
+		if errors.Is(err, errOutdatedLockfile) {
+			// Every task runs once, rather than skipping on the
+			// changes another task saw.
+			if !s.options.Quiet {
+				fmt.Fprintf(os.Stderr, "Warning: discarding the lockfile of an older goke %s, tasks run once to record their files\n", lockfilePath)
+			}
+
+			projects = lockFileJson{}
+			return nil
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	s.files = projects
+
+	loaded := copyProjects(projects)
+	s.pending.applyFiles(loaded)
+
+	return loaded, found, nil
+}
+
+// Reads the lockfile, false when it doesn't exist.
+func (s *fileStateStore) readLockfile(lockfilePath string) (lockFileJson, bool, error) {
+	if !s.fs.FileExists(lockfilePath) {
+		return lockFileJson{}, false, nil
+	}
+
+	contents, err := s.fs.ReadFile(lockfilePath)
+	if err != nil {
+		return nil, false, err
+	}
+
+	projects, err := decodeLockfile(contents)
+	return projects, true, err
+}
+
+func (s *fileStateStore) LoadHistory() (historyFileJson, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	history, err := s.readHistory()
+	if err != nil {
+		return nil, err
+	}
+
+	s.history = history
+
+	loaded := make(historyFileJson, len(history))
+	for project, tasks := range history {
+		loaded[project] = make(taskHistoryJson, len(tasks))
+		for task, entry := range tasks {
+			loaded[project][task] = entry
+		}
+	}
+	s.pending.applyHistory(loaded)
+
+	return loaded, nil
+}
+
+func (s *fileStateStore) readHistory() (historyFileJson, error) {
+	historyPath, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+
+	history := historyFileJson{}
+	if !s.fs.FileExists(historyPath) {
+		return history, nil
+	}
+
+	contents, err := s.fs.ReadFile(historyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return history, json.Unmarshal(contents, &history)
+}
+
+func (s *fileStateStore) Get(project string, task string, file string) (fileEntry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, found, known := s.pending.get(project, task, file); known {
+		return entry, found, nil
+	}
+
+	if s.files == nil {
+		lockfilePath, err := lockfilePath(s.options)
+		if err != nil {
+			return fileEntry{}, false, err
+		}
+
+		if err := s.loadFiles(lockfilePath); err != nil {
+			return fileEntry{}, false, err
+		}
+	}
+
+	entry, found := s.files[project][task][file]
+	return entry, found, nil
+}
+
+// Loads the lockfile the first time it's needed, empty when it's outdated.
+func (s *fileStateStore) loadFiles(lockfilePath string) error {
+	projects, _, err := s.readLockfile(lockfilePath)
+	if errors.Is(err, errOutdatedLockfile) {
+		projects, err = lockFileJson{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	s.files = projects
+	return nil
+}
+
+func (s *fileStateStore) Set(project string, task string, file string, entry fileEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending.set(project, task, file, entry)
+}
+
+func (s *fileStateStore) DeleteTask(project string, task string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending.deleteTask(project, task)
+}
+
+func (s *fileStateStore) LastSuccess(project string, task string) (historyEntry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if entry, ok := s.pending.lastSuccess(project, task); ok {
+		return entry, true, nil
+	}
+
+	if s.history == nil {
+		history, err := s.readHistory()
+		if err != nil {
+			return historyEntry{}, false, err
+		}
+
+		s.history = history
+	}
+
+	entry, ok := s.history[project][task]
+	return entry, ok, nil
+}
+
+func (s *fileStateStore) SetLastSuccess(project string, task string, entry historyEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.pending.setLastSuccess(project, task, entry)
+}
+
+func (s *fileStateStore) Update(fn func(tx StateTx) error) error {
+	return updateState(s, fn)
+}
+
+func (s *fileStateStore) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if len(s.pending.deleted) > 0 || len(s.pending.files) > 0 {
+		if err := s.flushFiles(); err != nil {
+			return err
+		}
+
+		s.pending.deleted, s.pending.files = nil, nil
+	}
+
+	if len(s.pending.history) > 0 {
+		if err := s.flushHistory(); err != nil {
+			return err
+		}
+
+		s.pending.history = nil
+	}
+
+	return nil
+}
+
+func (s *fileStateStore) flushFiles() error {
+	lockfilePath, err := lockfilePath(s.options)
+	if err != nil {
+		return err
+	}
+
+	return withStateLock(s.fs, s.options, func() error {
+		// Picks up the tasks other goke invocations recorded in the
+		// state directory since the lockfile was loaded, so that
+		// writing it doesn't drop them.
+		if s.options.StateDir != "" {
+			_ = s.loadFiles(lockfilePath)
+		}
+		if s.files == nil {
+			s.files = lockFileJson{}
+		}
+
+		s.pending.applyFiles(s.files)
+
+		contents, err := encodeLockfile(s.files)
+		if err != nil {
+			return err
+		}
+
+		return writeFileAtomic(s.fs, lockfilePath, contents)
+	})
+}
+
+func (s *fileStateStore) flushHistory() error {
+	historyPath, err := historyPath()
+	if err != nil {
+		return err
+	}
+
+	if s.history == nil {
+		s.history = historyFileJson{}
+	}
+
+	s.pending.applyHistory(s.history)
+
+	contents, err := json.MarshalIndent(s.history, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeFileAtomic(s.fs, historyPath, contents)
+}
+
+// Returns a copy of the recorded files, which the caller may change.
+func copyProjects(projects lockFileJson) lockFileJson {
+	copied := make(lockFileJson, len(projects))
+	for project, tasks := range projects {
+		copied[project] = make(singleProjectJson, len(tasks))
+		for task, files := range tasks {
+			copied[project][task] = make(taskFilesJson, len(files))
+			for file, entry := range files {
+				copied[project][task][file] = entry
+			}
+		}
+	}
+
+	return copied
+}
+
+// Writes the file through a temporary one renamed over it, so that a goke
+// killed while writing leaves either the previous contents or the new ones,
+// never a truncated file. The name of the temporary file is unique to the
+// process, as other invocations may be writing the same file.
+func writeFileAtomic(fs FileSystem, name string, contents []byte) error {
+	tmp := fmt.Sprintf("%s.%d.tmp", name, os.Getpid())
+	if err := fs.WriteFile(tmp, contents, 0644); err != nil {
+		_ = fs.Remove(tmp)
+		return err
+	}
+
+	if err := fs.Rename(tmp, name); err != nil {
+		_ = fs.Remove(tmp)
+		return err
+	}
+
+	return nil
+}
+
+// Returns the location of the lockfile in the system.
+func lockfilePath(opts Options) (string, error) {
+	if opts.StateDir != "" {
+		return path.Join(opts.StateDir, "lockfile.json"), nil
+	}
+
+	return globalLockfilePath()
+}
+
+// Returns the location of the history file in the system.
+func historyPath() (string, error) {
+	user, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return path.Join(user.HomeDir, ".goke-history"), nil
+}
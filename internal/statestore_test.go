@@ -0,0 +1,457 @@
+package internal
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Fails writing the files of the state directory halfway through, like a
+// full disk.
+type failingWritesFS struct {
+	*MemFileSystem
+}
+
+func (f failingWritesFS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	_ = f.MemFileSystem.WriteFile(name, data[:len(data)/2], perm)
+	return errors.New("no space left on device")
+}
+
+// Records n files for the task, all with the given mtime.
+func recordTaskFiles(projects lockFileJson, task string, n int, mtime int64) {
+	if projects["/work"] == nil {
+		projects["/work"] = singleProjectJson{}
+	}
+
+	files := make(taskFilesJson, n)
+	for i := 0; i < n; i++ {
+		files[fmt.Sprintf("src/%s/%d.go", task, i)] = fileEntry{ModTime: mtime}
+	}
+
+	projects["/work"][task] = files
+}
+
+// Replaces the files of the tasks in the store with the ones of projects.
+func saveTaskFiles(store StateStore, projects lockFileJson, tasks ...string) error {
+	return store.Update(func(tx StateTx) error {
+		for _, task := range tasks {
+			setTaskFiles(tx, "/work", task, projects["/work"][task])
+		}
+
+		return nil
+	})
+}
+
+// Returns a function which opens the store of the backend, each time as a
+// new goke invocation would. The file store keeps its files in memory, so
+// that the history stays out of the home directory.
+func stateStoreOpener(t *testing.T, backend string) func() StateStore {
+	if backend == StateBackendFile {
+		memFS := NewMemFileSystem("/work")
+		return func() StateStore {
+			return newStateStore(Options{StateDir: "/work/.goke"}, memFS)
+		}
+	}
+
+	dir := t.TempDir()
+	var last *sqliteStateStore
+	t.Cleanup(func() {
+		if last != nil {
+			_ = last.Close()
+		}
+	})
+
+	return func() StateStore {
+		if last != nil {
+			require.Nil(t, last.Close())
+		}
+
+		last = newStateStore(Options{StateDir: dir, StateBackend: backend}, &LocalFileSystem{}).(*sqliteStateStore)
+		return last
+	}
+}
+
+// What every backend of state_backend must do.
+var stateStoreBehaviors = []struct {
+	name string
+	test func(t *testing.T, open func() StateStore)
+}{
+	{"nothing is recorded at first", func(t *testing.T, open func() StateStore) {
+		store := open()
+
+		_, found, err := store.LoadFiles()
+		require.Nil(t, err)
+		require.False(t, found)
+
+		_, found, err = store.Get("/work", "build", "main.go")
+		require.Nil(t, err)
+		require.False(t, found)
+	}},
+	{"changes are pending until flushed", func(t *testing.T, open func() StateStore) {
+		store := open()
+		store.Set("/work", "build", "main.go", fileEntry{ModTime: 1})
+
+		entry, found, err := store.Get("/work", "build", "main.go")
+		require.Nil(t, err)
+		require.True(t, found)
+		require.Equal(t, fileEntry{ModTime: 1}, entry)
+
+		loaded, _, err := store.LoadFiles()
+		require.Nil(t, err)
+		require.Equal(t, taskFilesJson{"main.go": {ModTime: 1}}, loaded["/work"]["build"])
+
+		store = open()
+		_, found, err = store.Get("/work", "build", "main.go")
+		require.Nil(t, err)
+		require.False(t, found)
+
+		store.Set("/work", "build", "main.go", fileEntry{ModTime: 2, Hash: "ab12"})
+		require.Nil(t, store.Flush())
+
+		entry, found, err = open().Get("/work", "build", "main.go")
+		require.Nil(t, err)
+		require.True(t, found)
+		require.Equal(t, fileEntry{ModTime: 2, Hash: "ab12"}, entry)
+	}},
+	{"deleting a task replaces its files", func(t *testing.T, open func() StateStore) {
+		err := open().Update(func(tx StateTx) error {
+			setTaskFiles(tx, "/work", "build", taskFilesJson{"a.go": {ModTime: 1}, "b.go": {Missing: true}})
+			setTaskFiles(tx, "/work", "lint", taskFilesJson{"a.go": {ModTime: 1}})
+			setTaskFiles(tx, "/other", "build", taskFilesJson{"lib.go": {ModTime: 1, Target: "/src/lib.go"}})
+			return nil
+		})
+		require.Nil(t, err)
+
+		err = open().Update(func(tx StateTx) error {
+			setTaskFiles(tx, "/work", "build", taskFilesJson{"c.go": {ModTime: 2}})
+			return nil
+		})
+		require.Nil(t, err)
+
+		loaded, found, err := open().LoadFiles()
+		require.Nil(t, err)
+		require.True(t, found)
+		require.Equal(t, lockFileJson{
+			"/work": {
+				"build": {"c.go": {ModTime: 2}},
+				"lint":  {"a.go": {ModTime: 1}},
+			},
+			"/other": {"build": {"lib.go": {ModTime: 1, Target: "/src/lib.go"}}},
+		}, loaded)
+	}},
+	{"a failed update changes nothing", func(t *testing.T, open func() StateStore) {
+		store := open()
+		require.Nil(t, store.Update(func(tx StateTx) error {
+			tx.Set("/work", "build", "a.go", fileEntry{ModTime: 1})
+			return nil
+		}))
+
+		err := store.Update(func(tx StateTx) error {
+			setTaskFiles(tx, "/work", "build", taskFilesJson{"b.go": {ModTime: 2}})
+			tx.SetLastSuccess("/work", "build", historyEntry{At: 2})
+			return errors.New("interrupted")
+		})
+		require.EqualError(t, err, "interrupted")
+
+		unchanged := func(store StateStore) {
+			_, found, err := store.Get("/work", "build", "a.go")
+			require.Nil(t, err)
+			require.True(t, found)
+
+			_, found, err = store.Get("/work", "build", "b.go")
+			require.Nil(t, err)
+			require.False(t, found)
+
+			_, found, err = store.LastSuccess("/work", "build")
+			require.Nil(t, err)
+			require.False(t, found)
+		}
+
+		unchanged(store)
+		unchanged(open())
+	}},
+	{"an update sees its own changes", func(t *testing.T, open func() StateStore) {
+		store := open()
+		store.Set("/work", "build", "a.go", fileEntry{ModTime: 1})
+		require.Nil(t, store.Flush())
+
+		err := store.Update(func(tx StateTx) error {
+			_, found, err := tx.Get("/work", "build", "a.go")
+			require.Nil(t, err)
+			require.True(t, found)
+
+			tx.DeleteTask("/work", "build")
+			tx.Set("/work", "build", "b.go", fileEntry{ModTime: 2})
+
+			_, found, err = tx.Get("/work", "build", "a.go")
+			require.Nil(t, err)
+			require.False(t, found)
+
+			entry, found, err := tx.Get("/work", "build", "b.go")
+			require.Nil(t, err)
+			require.True(t, found)
+			require.Equal(t, fileEntry{ModTime: 2}, entry)
+
+			// Nothing is seen outside of the update before it ends.
+			_, found, err = store.Get("/work", "build", "a.go")
+			require.Nil(t, err)
+			require.True(t, found)
+
+			return nil
+		})
+		require.Nil(t, err)
+
+		loaded, _, err := open().LoadFiles()
+		require.Nil(t, err)
+		require.Equal(t, taskFilesJson{"b.go": {ModTime: 2}}, loaded["/work"]["build"])
+	}},
+	{"the last success of each task is recorded", func(t *testing.T, open func() StateStore) {
+		meta := &RunMetadata{Version: "1.2.3", Args: []string{"goke", "lint"}, Started: time.Unix(1671843600, 0).UTC()}
+
+		store := open()
+		store.SetLastSuccess("/work", "build", historyEntry{At: 1671843661})
+		store.SetLastSuccess("/work", "lint", historyEntry{At: 1671843662})
+
+		entry, found, err := store.LastSuccess("/work", "build")
+		require.Nil(t, err)
+		require.True(t, found)
+		require.Equal(t, historyEntry{At: 1671843661}, entry)
+		require.Nil(t, store.Flush())
+
+		store = open()
+		_, err = store.LoadHistory()
+		require.Nil(t, err)
+
+		store.SetLastSuccess("/work", "lint", historyEntry{At: 1671843663, Metadata: meta})
+		require.Nil(t, store.Flush())
+
+		history, err := open().LoadHistory()
+		require.Nil(t, err)
+		require.Equal(t, taskHistoryJson{"build": {At: 1671843661}, "lint": {At: 1671843663, Metadata: meta}}, history["/work"])
+
+		entry, _, err = open().LastSuccess("/work", "lint")
+		require.Nil(t, err)
+		require.Equal(t, historyEntry{At: 1671843663, Metadata: meta}, entry)
+	}},
+}
+
+func TestStateStoreBackends(t *testing.T) {
+	t.Parallel()
+
+	for _, backend := range []string{StateBackendFile, StateBackendSQLite} {
+		for _, behavior := range stateStoreBehaviors {
+			backend, behavior := backend, behavior
+			t.Run(backend+"/"+behavior.name, func(t *testing.T) {
+				t.Parallel()
+				behavior.test(t, stateStoreOpener(t, backend))
+			})
+		}
+	}
+}
+
+func TestFileStateStoreKeepsStateWhenWriteFails(t *testing.T) {
+	t.Parallel()
+
+	memFS := NewMemFileSystem("/work")
+	opts := Options{StateDir: "/work/.goke"}
+
+	projects := lockFileJson{}
+	recordTaskFiles(projects, "build", 10, 1)
+	require.Nil(t, saveTaskFiles(newStateStore(opts, memFS), projects, "build"))
+
+	recordTaskFiles(projects, "build", 10, 2)
+	err := saveTaskFiles(newStateStore(opts, failingWritesFS{memFS}), projects, "build")
+	require.EqualError(t, err, "no space left on device")
+
+	loaded, found, err := newStateStore(opts, memFS).LoadFiles()
+	require.Nil(t, err)
+	require.True(t, found)
+	require.Equal(t, fileEntry{ModTime: 1}, loaded["/work"]["build"]["src/build/0.go"])
+	require.False(t, memFS.FileExists(fmt.Sprintf("/work/.goke/lockfile.json.%d.tmp", os.Getpid())))
+}
+
+func TestSQLiteStateStoreImportsTheLockfile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	opts := Options{StateDir: dir, StateBackend: StateBackendSQLite}
+
+	projects := lockFileJson{}
+	recordTaskFiles(projects, "build", 3, 1671843661)
+	projects["/work"]["lint"] = taskFilesJson{"link.go": {ModTime: 1, Target: "/src/real.go"}, "gone.go": {Missing: true}, "hashed.go": {ModTime: 2, Hash: "ab12"}}
+
+	contents, err := encodeLockfile(projects)
+	require.Nil(t, err)
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "lockfile.json"), contents, 0644))
+
+	store := newStateStore(opts, &LocalFileSystem{})
+	defer store.(*sqliteStateStore).Close()
+
+	loaded, found, err := store.LoadFiles()
+	require.Nil(t, err)
+	require.True(t, found)
+	require.Equal(t, projects["/work"], loaded["/work"])
+
+	// Saving a task replaces its files only.
+	projects["/work"]["build"] = taskFilesJson{"main.go": {ModTime: 5}}
+	require.Nil(t, saveTaskFiles(store, projects, "build"))
+
+	loaded, _, err = store.LoadFiles()
+	require.Nil(t, err)
+	require.Equal(t, projects["/work"], loaded["/work"])
+
+	entry, found, err := store.Get("/work", "lint", "link.go")
+	require.Nil(t, err)
+	require.True(t, found)
+	require.Equal(t, fileEntry{ModTime: 1, Target: "/src/real.go"}, entry)
+}
+
+func TestSQLiteStateStoreAddsMetadataToTheHistoryOfVersion1(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	db, err := sql.Open("sqlite", filepath.Join(dir, "state.db"))
+	require.Nil(t, err)
+
+	_, err = db.Exec(`
+CREATE TABLE meta (key TEXT PRIMARY KEY, value TEXT NOT NULL);
+CREATE TABLE history (project TEXT NOT NULL, task TEXT NOT NULL, last_success INTEGER NOT NULL, PRIMARY KEY (project, task));
+INSERT INTO meta (key, value) VALUES ('version', '1');
+INSERT INTO history (project, task, last_success) VALUES ('/work', 'build', 1671843661);
+`)
+	require.Nil(t, err)
+	require.Nil(t, db.Close())
+
+	store := newStateStore(Options{StateDir: dir, StateBackend: StateBackendSQLite}, &LocalFileSystem{})
+	defer store.(*sqliteStateStore).Close()
+
+	entry, found, err := store.LastSuccess("/work", "build")
+	require.Nil(t, err)
+	require.True(t, found)
+	require.Equal(t, historyEntry{At: 1671843661}, entry)
+
+	meta := &RunMetadata{Version: "1.2.3"}
+	store.SetLastSuccess("/work", "build", historyEntry{At: 1671843662, Metadata: meta})
+	require.Nil(t, store.Flush())
+
+	history, err := store.LoadHistory()
+	require.Nil(t, err)
+	require.Equal(t, historyEntry{At: 1671843662, Metadata: meta}, history["/work"]["build"])
+}
+
+func TestStateBackendMustBeKnown(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewInMemoryEnv("global:\n  state_backend: redis\n").Parse()
+	require.EqualError(t, err, "global: state_backend must be file or sqlite, got 'redis'")
+}
+
+// Saves the files of a task over and over, with a new mtime each time, for
+// TestStateStoresSurviveBeingKilled, which kills it while it's saving.
+func TestStateStoreHelperProcess(t *testing.T) {
+	dir := os.Getenv("GOKE_STATE_STORE_HELPER_DIR")
+	if dir == "" {
+		t.Skip("only runs as the helper of TestStateStoresSurviveBeingKilled")
+	}
+
+	store := newStateStore(Options{StateDir: dir, StateBackend: os.Getenv("GOKE_STATE_STORE_HELPER_BACKEND")}, &LocalFileSystem{})
+	projects := lockFileJson{}
+
+	for mtime := int64(1); ; mtime++ {
+		recordTaskFiles(projects, "build", 2000, mtime)
+		if err := saveTaskFiles(store, projects, "build"); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if mtime == 1 {
+			fmt.Println("saved")
+		}
+	}
+}
+
+func TestStateStoresSurviveBeingKilled(t *testing.T) {
+	if testing.Short() {
+		t.Skip("kills helper processes")
+	}
+
+	for _, backend := range []string{StateBackendFile, StateBackendSQLite} {
+		for i := 0; i < 5; i++ {
+			dir := t.TempDir()
+
+			cmd := exec.Command(os.Args[0], "-test.run=^TestStateStoreHelperProcess$")
+			cmd.Env = append(os.Environ(), "GOKE_STATE_STORE_HELPER_DIR="+dir, "GOKE_STATE_STORE_HELPER_BACKEND="+backend)
+			stdout, err := cmd.StdoutPipe()
+			require.Nil(t, err)
+			require.Nil(t, cmd.Start())
+
+			line, err := bufio.NewReader(stdout).ReadString('\n')
+			require.Nil(t, err)
+			require.Equal(t, "saved\n", line)
+
+			time.Sleep(time.Duration(i*7) * time.Millisecond)
+			require.Nil(t, cmd.Process.Kill())
+			_ = cmd.Wait()
+
+			store := newStateStore(Options{StateDir: dir, StateBackend: backend}, &LocalFileSystem{})
+			loaded, found, err := store.LoadFiles()
+			require.Nil(t, err, backend)
+			require.True(t, found, backend)
+
+			// Every file was recorded by the same save.
+			files := loaded["/work"]["build"]
+			require.Len(t, files, 2000, backend)
+			for _, entry := range files {
+				require.Equal(t, files["src/build/0.go"], entry, backend+" "+strconv.Itoa(i))
+			}
+
+			if s, ok := store.(*sqliteStateStore); ok {
+				require.Nil(t, s.Close())
+			}
+		}
+	}
+}
+
+// Updates and flushes one task of a project recording 50,000 files over 100
+// tasks, the case state_backend: sqlite is for.
+func benchmarkStateStore(b *testing.B, backend string) {
+	store := newStateStore(Options{StateDir: b.TempDir(), StateBackend: backend}, &LocalFileSystem{})
+	if s, ok := store.(*sqliteStateStore); ok {
+		defer s.Close()
+	}
+
+	projects := lockFileJson{}
+	tasks := []string{}
+	for i := 0; i < 100; i++ {
+		task := fmt.Sprintf("task%d", i)
+		recordTaskFiles(projects, task, 500, 1)
+		tasks = append(tasks, task)
+	}
+	require.Nil(b, saveTaskFiles(store, projects, tasks...))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		recordTaskFiles(projects, "task0", 500, int64(i+2))
+		if err := saveTaskFiles(store, projects, "task0"); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFileStateStore(b *testing.B) {
+	benchmarkStateStore(b, StateBackendFile)
+}
+
+func BenchmarkSQLiteStateStore(b *testing.B) {
+	benchmarkStateStore(b, StateBackendSQLite)
+}
@@ -0,0 +1,222 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegisterCapability("watch.serve_status")
+}
+
+// Amount of past iterations kept for the /history endpoint.
+const statusHistorySize = 20
+
+const (
+	stateRunning = "running"
+	stateWaiting = "waiting"
+)
+
+// What started an iteration of the watch session.
+const (
+	triggerInitial  = "initial"
+	triggerChange   = "change"
+	triggerRemote   = "remote"
+	triggerSchedule = "schedule"
+)
+
+// A single run of the task during a watch session.
+type iteration struct {
+	Number     int       `json:"number"`
+	Task       string    `json:"task"`
+	Trigger    string    `json:"trigger"`
+	Started    time.Time `json:"started"`
+	DurationMs int64     `json:"duration_ms"`
+	Result     string    `json:"result,omitempty"`
+	Error      string    `json:"error,omitempty"`
+
+	Metadata *RunMetadata `json:"metadata,omitempty"`
+}
+
+type statusJson struct {
+	Task          string     `json:"task"`
+	State         string     `json:"state"`
+	UptimeSeconds int64      `json:"uptime_seconds"`
+	Iterations    int        `json:"iterations"`
+	SkippedTicks  int        `json:"skipped_ticks"`
+	LastIteration *iteration `json:"last_iteration"`
+
+	Metadata *RunMetadata `json:"metadata,omitempty"`
+}
+
+// The state of a watch session, updated by the watch loop and
+// exposed over HTTP with --serve-status.
+type watchStatus struct {
+	mu      sync.Mutex
+	task    string
+	state   string
+	started time.Time
+	count   int
+	skipped int
+	history []iteration
+	trigger chan struct{}
+
+	// Included in the status and in every iteration, when set.
+	metadata *RunMetadata
+}
+
+func newWatchStatus(task string) *watchStatus {
+	return &watchStatus{
+		task:    task,
+		state:   stateWaiting,
+		started: time.Now(),
+		trigger: make(chan struct{}, 1),
+	}
+}
+
+func (s *watchStatus) beginIteration(task string, trigger string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.count++
+	s.state = stateRunning
+	s.history = append(s.history, iteration{Number: s.count, Task: task, Trigger: trigger, Started: time.Now(), Metadata: s.metadata})
+
+	if len(s.history) > statusHistorySize {
+		s.history = s.history[len(s.history)-statusHistorySize:]
+	}
+}
+
+func (s *watchStatus) endIteration(dispatched bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.state = stateWaiting
+	last := &s.history[len(s.history)-1]
+	last.DurationMs = time.Since(last.Started).Milliseconds()
+
+	switch {
+	case err != nil:
+		last.Result = "failed"
+		last.Error = err.Error()
+	case !dispatched:
+		last.Result = "skipped"
+	default:
+		last.Result = "success"
+	}
+}
+
+// Counts a scheduled run which was skipped, since a run was still in progress.
+func (s *watchStatus) skipTick() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.skipped++
+}
+
+func (s *watchStatus) snapshot() statusJson {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := statusJson{
+		Task:          s.task,
+		State:         s.state,
+		UptimeSeconds: int64(time.Since(s.started).Seconds()),
+		Iterations:    s.count,
+		SkippedTicks:  s.skipped,
+		Metadata:      s.metadata,
+	}
+
+	if len(s.history) > 0 {
+		last := s.history[len(s.history)-1]
+		status.LastIteration = &last
+	}
+
+	return status
+}
+
+func (s *watchStatus) recentIterations() []iteration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]iteration{}, s.history...)
+}
+
+// Serves /status, /history and /trigger. Triggering a run is refused
+// unless allowTrigger is set.
+func (s *watchStatus) handler(allowTrigger bool) http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, s.snapshot())
+	})
+
+	mux.HandleFunc("/history", func(w http.ResponseWriter, r *http.Request) {
+		writeJson(w, http.StatusOK, s.recentIterations())
+	})
+
+	mux.HandleFunc("/trigger", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeJson(w, http.StatusMethodNotAllowed, map[string]string{"error": "use POST"})
+			return
+		}
+
+		if !allowTrigger {
+			writeJson(w, http.StatusForbidden, map[string]string{"error": "triggering is disabled, see --allow-remote-trigger"})
+			return
+		}
+
+		select {
+		case s.trigger <- struct{}{}:
+		default:
+		}
+
+		writeJson(w, http.StatusAccepted, map[string]bool{"triggered": true})
+	})
+
+	return mux
+}
+
+func writeJson(w http.ResponseWriter, code int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// Starts the status server in the background. Addresses without a host,
+// ie. ":4477", only bind to localhost.
+func serveStatus(addr string, s *watchStatus, allowTrigger bool) (*http.Server, net.Addr, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if host == "" {
+		addr = net.JoinHostPort("127.0.0.1", port)
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	srv := &http.Server{Handler: s.handler(allowTrigger), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		defer RecoverPanic()
+		_ = srv.Serve(ln)
+	}()
+
+	return srv, ln.Addr(), nil
+}
+
+// Stops the status server, giving in-flight requests a moment to finish.
+func shutdownStatus(srv *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	_ = srv.Shutdown(ctx)
+}
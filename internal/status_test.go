@@ -0,0 +1,168 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// A runner which reports the results it is given, one per run.
+type fakeRunner struct {
+	mu      sync.Mutex
+	results []error
+	runs    []bool
+}
+
+func (r *fakeRunner) run(initial bool) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.runs = append(r.runs, initial)
+	if len(r.results) == 0 {
+		return true, nil
+	}
+
+	err := r.results[0]
+	r.results = r.results[1:]
+	return true, err
+}
+
+func (r *fakeRunner) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return len(r.runs)
+}
+
+type testWatchSession struct {
+	runner    *fakeRunner
+	changes   chan struct{}
+	interrupt chan os.Signal
+	server    *httptest.Server
+	done      chan struct{}
+}
+
+func startTestWatchSession(t *testing.T, allowTrigger bool, results ...error) *testWatchSession {
+	s := &testWatchSession{
+		runner:    &fakeRunner{results: results},
+		changes:   make(chan struct{}),
+		interrupt: make(chan os.Signal),
+		done:      make(chan struct{}),
+	}
+
+	status := newWatchStatus("build")
+	s.server = httptest.NewServer(status.handler(allowTrigger))
+
+	loop := watchLoop{
+		changes:   s.changes,
+		interrupt: s.interrupt,
+		status:    status,
+		run:       s.runner.run,
+		stop:      func() {},
+	}
+
+	go func() {
+		loop.loop()
+		close(s.done)
+	}()
+
+	t.Cleanup(func() {
+		s.interrupt <- os.Interrupt
+		<-s.done
+		s.server.Close()
+	})
+
+	return s
+}
+
+func (s *testWatchSession) getJson(t *testing.T, path string, v any) {
+	res, err := http.Get(s.server.URL + path)
+	require.Nil(t, err)
+	defer res.Body.Close()
+
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Nil(t, json.NewDecoder(res.Body).Decode(v))
+}
+
+func (s *testWatchSession) waitForStatus(t *testing.T, iterations int) statusJson {
+	var status statusJson
+
+	require.Eventually(t, func() bool {
+		s.getJson(t, "/status", &status)
+		return status.Iterations == iterations && status.State == stateWaiting
+	}, 2*time.Second, 10*time.Millisecond)
+
+	return status
+}
+
+func TestStatusReportsIterations(t *testing.T) {
+	s := startTestWatchSession(t, false, nil, errors.New("exit status 1"))
+
+	status := s.waitForStatus(t, 1)
+	require.Equal(t, "build", status.Task)
+	require.Equal(t, "success", status.LastIteration.Result)
+
+	s.changes <- struct{}{}
+
+	status = s.waitForStatus(t, 2)
+	require.Equal(t, "failed", status.LastIteration.Result)
+	require.Equal(t, "exit status 1", status.LastIteration.Error)
+
+	var history []iteration
+	s.getJson(t, "/history", &history)
+	require.Len(t, history, 2)
+	require.Equal(t, 1, history[0].Number)
+	require.Equal(t, "success", history[0].Result)
+	require.Equal(t, []bool{true, false}, s.runner.runs)
+}
+
+func TestStatusTriggerRunsTheTask(t *testing.T) {
+	s := startTestWatchSession(t, true)
+	s.waitForStatus(t, 1)
+
+	res, err := http.Post(s.server.URL+"/trigger", "application/json", strings.NewReader(""))
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusAccepted, res.StatusCode)
+
+	s.waitForStatus(t, 2)
+	require.Equal(t, 2, s.runner.count())
+}
+
+func TestStatusTriggerIsDisabledByDefault(t *testing.T) {
+	s := startTestWatchSession(t, false)
+	s.waitForStatus(t, 1)
+
+	res, err := http.Post(s.server.URL+"/trigger", "application/json", strings.NewReader(""))
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusForbidden, res.StatusCode)
+
+	res, err = http.Get(s.server.URL + "/trigger")
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+
+	require.Equal(t, 1, s.runner.count())
+}
+
+func TestServeStatusBindsToLocalhost(t *testing.T) {
+	srv, addr, err := serveStatus(":0", newWatchStatus("build"), false)
+	require.Nil(t, err)
+	defer shutdownStatus(srv)
+
+	require.True(t, strings.HasPrefix(addr.String(), "127.0.0.1:"))
+
+	res, err := http.Get("http://" + addr.String() + "/status")
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+}
@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() {
+	RegisterCapability("run.subproject")
+}
+
+// How deeply subprojects may run tasks of further subprojects.
+const maxSubprojectDepth = 16
+
+// Runs a task of the goke.yml in another directory, for the run entries
+// like {goke: services/api, task: test}. The config is parsed in-process
+// and never cached. Its tasks run in its directory, through an executor
+// with its own lockfile and history scope, so they are only dispatched
+// when their files changed. Their output is prefixed with the directory.
+func (e *Executor) runSubproject(entry RunEntry) error {
+	dir := filepath.Clean(entry.Goke)
+	taskName := entry.Task
+	if taskName == "" {
+		taskName = DefaultTask
+	}
+
+	cwd, err := e.parser.fs.Getwd()
+	if err != nil {
+		return err
+	}
+
+	abs := joinDir(cwd, dir)
+	parents := e.parents
+	if len(parents) == 0 {
+		parents = []string{cwd}
+	}
+
+	for i, parent := range parents {
+		if parent == abs {
+			return fmt.Errorf("subproject cycle detected: %s", strings.Join(relativeTo(cwd, append(parents[i:], abs)), " -> "))
+		}
+	}
+
+	if len(parents) > maxSubprojectDepth {
+		return fmt.Errorf("subproject %s: more than %d nested subprojects", dir, maxSubprojectDepth)
+	}
+
+	p, err := e.subprojectParser(dir)
+	if err != nil {
+		return fmt.Errorf("subproject %s: %w", dir, err)
+	}
+
+	if _, ok := p.Tasks[taskName]; !ok {
+		return fmt.Errorf("subproject %s: task '%s' not found", dir, taskName)
+	}
+
+	if !e.options.Quiet {
+		e.spinnerMessage(fmt.Sprintf("Running: %s in %s", taskName, dir))
+	}
+
+	lockfile := e.lockfile.ForProject(abs, p.TaskFiles())
+	history := e.history.ForProject(abs)
+
+	sub := *e
+	sub.parser = *p
+	sub.lockfile = lockfile
+	sub.history = history
+	sub.resolved = nil
+	sub.completed = nil
+	sub.prompt = nil
+	sub.progress = nil
+	sub.taskChain = nil
+	sub.outputPrefix = e.outputPrefix + fmt.Sprintf("[%s] ", dir)
+	sub.parents = append(append([]string{}, parents...), abs)
+
+	if e.options.DryRun {
+		defer sub.beginDryRunTask(dir)()
+	}
+
+	if _, err := sub.executeTasks([]string{taskName}); err != nil {
+		return fmt.Errorf("subproject %s: %w", dir, err)
+	}
+
+	return nil
+}
+
+// Parses the config of the subproject in the directory.
+func (e *Executor) subprojectParser(dir string) (*Parser, error) {
+	for _, name := range GokeFiles() {
+		configPath := filepath.Join(dir, name)
+
+		cfg, err := e.parser.fs.ReadFile(configPath)
+		if err != nil || len(cfg) == 0 {
+			continue
+		}
+
+		p, err := ParseProjectConfig(configPath, string(cfg), &e.options, e.parser.fs)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, warning := range p.Warnings {
+			if !e.options.Quiet {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", configPath, warning)
+			}
+		}
+
+		return p, nil
+	}
+
+	return nil, fmt.Errorf("no presence of goke.yml sighted in %s", dir)
+}
@@ -0,0 +1,136 @@
+package internal
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSubprojectRunsInItsDirectory(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+test:
+  run:
+    - goke: services/api
+      task: test
+    - "go vet ./..."
+`)
+	require.Nil(t, env.FS.WriteFile("services/api/goke.yml", []byte(`
+global:
+  environment:
+    SERVICE: "api"
+
+test:
+  files: ["*.go"]
+  run:
+    - "go test {FILES}"
+`), 0644))
+	require.Nil(t, env.FS.WriteFile("services/api/main.go", []byte("package main"), 0644))
+
+	require.Nil(t, env.Run("test"))
+
+	commands := env.Runner.Commands()
+	require.Equal(t, []string{"go test main.go", "go vet ./..."}, recordedCommands(env))
+	require.Equal(t, "services/api", commands[0].Dir)
+	require.Contains(t, commands[0].Env, "SERVICE=api")
+	require.Empty(t, commands[1].Dir)
+
+	// The subproject has its own lockfile scope, so its unchanged files
+	// skip its task the next time.
+	require.Nil(t, env.Run("test"))
+	require.Equal(t, []string{"go test main.go", "go vet ./...", "go vet ./..."}, recordedCommands(env))
+	require.Contains(t, env.lockfile.JSON, "/work/services/api")
+}
+
+func TestSubprojectVariablesOnlyReachItsCommands(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+main:
+  run:
+    - goke: services/api
+    - "go vet ./..."
+
+lint:
+  run:
+    - "golangci-lint run"
+`)
+	require.Nil(t, env.FS.WriteFile("services/api/goke.yml", []byte(`
+global:
+  environment:
+    GOKE_TEST_SUBPROJECT_SERVICE: "api"
+
+main:
+  env:
+    GOKE_TEST_SUBPROJECT_TASK: "test"
+  run:
+    - "go test"
+`), 0644))
+
+	require.Nil(t, env.Run())
+	require.Nil(t, env.Run("lint"))
+
+	commands := env.Runner.Commands()
+	require.Equal(t, []string{"go test", "go vet ./...", "golangci-lint run"}, recordedCommands(env))
+	require.Subset(t, commands[0].Env, []string{"GOKE_TEST_SUBPROJECT_SERVICE=api", "GOKE_TEST_SUBPROJECT_TASK=test"})
+
+	for _, command := range commands[1:] {
+		require.NotContains(t, command.Env, "GOKE_TEST_SUBPROJECT_SERVICE=api")
+		require.NotContains(t, command.Env, "GOKE_TEST_SUBPROJECT_TASK=test")
+	}
+
+	_, ok := os.LookupEnv("GOKE_TEST_SUBPROJECT_SERVICE")
+	require.False(t, ok)
+	_, ok = os.LookupEnv("GOKE_TEST_SUBPROJECT_TASK")
+	require.False(t, ok)
+}
+
+func TestSubprojectFailuresFailTheParent(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+main:
+  run:
+    - goke: services/api
+    - "go vet ./..."
+`)
+	require.Nil(t, env.FS.WriteFile("services/api/goke.yml", []byte("main:\n  run:\n    - \"go test\"\n"), 0644))
+	env.Runner.Handler = func(cmd *exec.Cmd) error {
+		return errors.New("exit status 3")
+	}
+
+	err := env.Run()
+	require.ErrorContains(t, err, "subproject services/api: ")
+	require.Equal(t, []string{"go test"}, recordedCommands(env))
+}
+
+func TestSubprojectCyclesAndMissingTasks(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+main:
+  run:
+    - goke: a
+
+broken:
+  run:
+    - goke: a
+      task: deploy
+`)
+	require.Nil(t, env.FS.WriteFile("a/goke.yml", []byte("main:\n  run:\n    - goke: ../b\n"), 0644))
+	require.Nil(t, env.FS.WriteFile("b/goke.yml", []byte("main:\n  run:\n    - goke: ../a\n"), 0644))
+
+	require.ErrorContains(t, env.Run(), "subproject cycle detected: a -> b -> a")
+	require.ErrorContains(t, env.Run("broken"), "subproject a: task 'deploy' not found")
+}
+
+func TestSubprojectEntriesAreValidated(t *testing.T) {
+	var entry RunEntry
+
+	require.ErrorContains(t, decodeConfig("", "cmd: make\ngoke: web\n", &entry), `cannot have both "cmd" and "goke"`)
+	require.ErrorContains(t, decodeConfig("", "cmd: make\ntask: build\n", &entry), `"task" only applies to "goke" entries`)
+}
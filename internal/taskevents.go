@@ -0,0 +1,41 @@
+package internal
+
+import "runtime"
+
+func init() {
+	RegisterCapability("task.events")
+}
+
+// Resolves the events of the task against the global ones. Each list the
+// task declares for the platform, even an empty one, replaces the global
+// list, or comes after it with inherit_events. The other lists stay the
+// global ones. Tasks which declare none keep running the global events.
+func (t *Task) resolveEvents(global Events) {
+	own := t.Events.forOS(runtime.GOOS)
+	lists := [][]EventEntry{own.BeforeEachRun, own.AfterEachRun, own.BeforeEachTask, own.AfterEachTask}
+
+	resolved := global
+	for i, list := range []*[]EventEntry{&resolved.BeforeEachRun, &resolved.AfterEachRun, &resolved.BeforeEachTask, &resolved.AfterEachTask} {
+		switch {
+		case lists[i] == nil:
+			continue
+		case t.InheritEvents:
+			*list = append(append([]EventEntry{}, *list...), lists[i]...)
+		default:
+			*list = lists[i]
+		}
+
+		t.OwnEvents = true
+	}
+
+	t.Events = resolved
+}
+
+// The events which run along with the task: its own, or the global ones.
+func (p *Parser) taskEvents(task Task) Events {
+	if task.OwnEvents {
+		return task.Events
+	}
+
+	return p.Global.Shared.Events
+}
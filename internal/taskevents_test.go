@@ -0,0 +1,87 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const taskEventsConfig = `
+global:
+  events:
+    before_each_run:
+      - "docker compose up -d"
+    after_each_task:
+      - "echo done"
+
+test:
+  run:
+    - "go test ./..."
+
+lint:
+  events:
+    before_each_run: []
+  run:
+    - "golangci-lint run"
+
+e2e:
+  inherit_events: true
+  events:
+    before_each_run:
+      - "npm run seed"
+    after_each_task:
+      - "docker compose down"
+  run:
+    - "npm run e2e"
+
+ci:
+  run:
+    - lint
+    - e2e
+`
+
+func TestTaskEventsReplaceTheGlobalOnes(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(taskEventsConfig)
+	require.Nil(t, env.Run("test", "lint"))
+	require.Equal(t, []string{
+		"docker compose up -d",
+		"go test ./...",
+		"echo done",
+		"golangci-lint run",
+		"echo done",
+	}, recordedCommands(env))
+}
+
+func TestTaskEventsCanInheritTheGlobalOnes(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(taskEventsConfig)
+	require.Nil(t, env.Run("e2e"))
+	require.Equal(t, []string{
+		"docker compose up -d",
+		"npm run seed",
+		"npm run e2e",
+		"echo done",
+		"docker compose down",
+	}, recordedCommands(env))
+}
+
+func TestReferencedTasksRunTheirOwnEvents(t *testing.T) {
+	t.Parallel()
+
+	// Referenced tasks only run after_each_task, their own.
+	env := NewInMemoryEnv(taskEventsConfig)
+	require.Nil(t, env.Run("ci"))
+	require.Equal(t, []string{
+		"docker compose up -d",
+		"golangci-lint run",
+		"echo done",
+		"docker compose up -d",
+		"npm run e2e",
+		"echo done",
+		"docker compose down",
+		"echo done",
+	}, recordedCommands(env))
+}
@@ -0,0 +1,140 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+func init() {
+	RegisterCapability("temp.cleanup", "command.clean-temp")
+}
+
+const (
+	// How old goke's temp files get before they are removed, see CleanTemp.
+	DefaultTempRetention = 7 * 24 * time.Hour
+
+	// How often goke looks for old temp files on startup.
+	tempCleanupInterval = time.Hour
+
+	// Touched in the state dir whenever temp files were looked for.
+	tempCleanupStamp = "temp-cleanup"
+)
+
+// The files goke leaves in the temp dir, which are the caches of parsed
+// configs, see Parser.getTempFileName. Nothing else is ever removed.
+var gokeTempFileRegexp = regexp.MustCompile(`^goke-v[0-9]+[^/\\]*$`)
+
+// What CleanTemp removed.
+type TempCleanup struct {
+	Files int
+	Bytes int64
+}
+
+func (c TempCleanup) String() string {
+	return fmt.Sprintf("Removed %d temp file(s) of goke older than the retention, reclaiming %s", c.Files, formatBytes(c.Bytes))
+}
+
+// Removes goke's files in the temp dir which weren't modified within the
+// retention, except the one named keep. Only regular files directly in
+// the temp dir whose names match gokeTempFileRegexp are considered. Files
+// which can't be removed, ie. those of other users, are left alone.
+func CleanTemp(fsys FileSystem, keep string, retention time.Duration, now time.Time) (TempCleanup, error) {
+	cleanup := TempCleanup{}
+
+	entries, err := fsys.ReadDir(fsys.TempDir())
+	if err != nil {
+		return cleanup, err
+	}
+
+	for _, entry := range entries {
+		if !gokeTempFileRegexp.MatchString(entry.Name()) || entry.Name() == keep {
+			continue
+		}
+
+		p := filepath.Join(fsys.TempDir(), entry.Name())
+		info, err := fsys.Lstat(p)
+		if err != nil || !info.Mode().IsRegular() || now.Sub(info.ModTime()) < retention {
+			continue
+		}
+
+		if err := fsys.Remove(p); err != nil {
+			continue
+		}
+
+		cleanup.Files++
+		cleanup.Bytes += info.Size()
+	}
+
+	return cleanup, nil
+}
+
+// Cleans the temp dir on startup, at most once per tempCleanupInterval,
+// unless disabled with --keep-temp. What got removed is logged with
+// --verbose. Failures are ignored, as they don't affect the run.
+func AutoCleanTemp(opts Options) {
+	if opts.KeepTemp {
+		return
+	}
+
+	dir, err := StateDir()
+	if err != nil {
+		return
+	}
+
+	if info, err := os.Stat(filepath.Join(dir, tempCleanupStamp)); err == nil && time.Since(info.ModTime()) < tempCleanupInterval {
+		return
+	}
+
+	cleanup, err := CleanTempNow(opts)
+	if err == nil && cleanup.Files > 0 && opts.Verbose && !opts.Quiet {
+		fmt.Fprintln(os.Stderr, cleanup)
+	}
+}
+
+// Removes goke's old temp files right away, for "goke clean-temp".
+func CleanTempNow(opts Options) (TempCleanup, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return TempCleanup{}, err
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, tempCleanupStamp), nil, 0644); err != nil {
+		return TempCleanup{}, err
+	}
+
+	return CleanTemp(&LocalFileSystem{}, currentCacheFile(opts), opts.tempRetention(), time.Now())
+}
+
+// The name of the cache file of the config in use.
+func currentCacheFile(opts Options) string {
+	p := Parser{fs: &LocalFileSystem{}, options: opts}
+	return p.getTempFileName()
+}
+
+// The retention of temp files, which defaults to DefaultTempRetention.
+func (opts *Options) tempRetention() time.Duration {
+	if opts.TempRetention <= 0 {
+		return DefaultTempRetention
+	}
+
+	return opts.TempRetention
+}
+
+// Formats the size in bytes for humans, ie. "1.5 MB".
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCleanTempOnlyRemovesOldFilesOfGoke(t *testing.T) {
+	fs := NewMemFileSystem("/work")
+	now := time.Date(2023, time.March, 1, 0, 0, 0, 0, time.UTC)
+	old := now.Add(-8 * 24 * time.Hour)
+
+	files := map[string]time.Time{
+		"/tmp/goke-v6-work":              old, // the cache in use
+		"/tmp/goke-v5-home-me-project":   old,
+		"/tmp/goke-v6-home-me-gone":      old,
+		"/tmp/goke-v6-home-me-recent":    now.Add(-time.Hour),
+		"/tmp/goke-notes.txt":            old,
+		"/tmp/notes.txt":                 old,
+		"/tmp/goke-v6-dir/inside":        old,
+		"/tmp/other/goke-v6-home-me-sub": old,
+	}
+
+	for name, mtime := range files {
+		require.Nil(t, fs.WriteFile(name, []byte("cached"), 0644))
+		require.Nil(t, fs.Chtimes(name, mtime))
+	}
+	require.Nil(t, fs.Chtimes("/tmp/goke-v6-dir", old))
+	require.Nil(t, fs.Symlink("/tmp/notes.txt", "/tmp/goke-v6-link"))
+
+	cleanup, err := CleanTemp(fs, "goke-v6-work", DefaultTempRetention, now)
+	require.Nil(t, err)
+	require.Equal(t, TempCleanup{Files: 2, Bytes: 12}, cleanup)
+
+	for name := range files {
+		removed := name == "/tmp/goke-v5-home-me-project" || name == "/tmp/goke-v6-home-me-gone"
+		require.Equal(t, !removed, fs.FileExists(name), name)
+	}
+
+	_, err = fs.Lstat("/tmp/goke-v6-link")
+	require.Nil(t, err)
+
+	// A shorter retention removes the recent cache too.
+	cleanup, err = CleanTemp(fs, "goke-v6-work", time.Minute, now)
+	require.Nil(t, err)
+	require.Equal(t, 1, cleanup.Files)
+	require.False(t, fs.FileExists("/tmp/goke-v6-home-me-recent"))
+}
+
+func TestFormatBytes(t *testing.T) {
+	require.Equal(t, "512 B", formatBytes(512))
+	require.Equal(t, "1.5 KB", formatBytes(1536))
+	require.Equal(t, "3.0 MB", formatBytes(3*1024*1024))
+}
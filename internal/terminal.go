@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/mattn/go-isatty"
+	"github.com/mattn/go-runewidth"
+	"github.com/theckman/yacspin"
+)
+
+// Width assumed for plain output when it is unknown, ie. in CI logs.
+const defaultPlainWidth = 80
+
+// The terminal goke writes to. Spinner messages are truncated to its width,
+// so that they never wrap and leave redraw artifacts behind.
+type terminal struct {
+	// Plain terminals get one line per message instead of an animated spinner.
+	plain bool
+	width atomic.Int32
+}
+
+func newTerminal() *terminal {
+	fd := os.Stdout.Fd()
+	tty := isatty.IsTerminal(fd) || isatty.IsCygwinTerminal(fd)
+
+	t := &terminal{plain: isPlainTerminal(os.Getenv("TERM"), tty)}
+	t.width.Store(int32(terminalWidth()))
+
+	if !t.plain {
+		watchResize(func() {
+			t.width.Store(int32(terminalWidth()))
+		})
+	}
+
+	return t
+}
+
+// Dumb terminals can't redraw a line, so they are treated like pipes.
+func isPlainTerminal(term string, tty bool) bool {
+	return !tty || term == "dumb"
+}
+
+// Returns the spinner configuration for the given renderer.
+func newSpinnerConfig(plain bool) yacspin.Config {
+	cfg := spinnerCfg
+	if plain {
+		cfg.TerminalMode = yacspin.ForceNoTTYMode | yacspin.ForceDumbTerminalMode
+	}
+
+	return cfg
+}
+
+// Truncates the message so that the spinner line, including its suffix, fits
+// the terminal. In plain mode messages are only truncated to a default width
+// unless running verbosely, since lines can wrap there without artifacts.
+func (t *terminal) fit(message string, suffix string, verbose bool) string {
+	width := int(t.width.Load())
+
+	if t.plain {
+		if verbose {
+			return message
+		}
+
+		if width <= 0 {
+			width = defaultPlainWidth
+		}
+	}
+
+	if width <= 0 {
+		return message
+	}
+
+	// The spinner character, the suffix and its colon precede the message.
+	// The last column stays empty, some terminals wrap as soon as it's written.
+	reserved := 2 + runewidth.StringWidth(suffix)
+	if strings.TrimSpace(suffix) != "" {
+		reserved += 2
+	}
+
+	return truncate(message, width-reserved)
+}
+
+// Truncates the string to the given display width, ending it with an ellipsis.
+func truncate(s string, width int) string {
+	if width <= 0 {
+		return ""
+	}
+
+	return runewidth.Truncate(s, width, "…")
+}
+
+// Falls back to $COLUMNS when the width can't be queried, 0 means unknown.
+func columnsEnv() int {
+	columns, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || columns < 0 {
+		return 0
+	}
+
+	return columns
+}
@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/theckman/yacspin"
+)
+
+func newTestTerminal(plain bool, width int) *terminal {
+	t := &terminal{plain: plain}
+	t.width.Store(int32(width))
+
+	return t
+}
+
+func TestTruncate(t *testing.T) {
+	message := "Running: go test ./..."
+
+	require.Equal(t, message, truncate(message, 40))
+	require.Equal(t, message, truncate(message, 22))
+	require.Equal(t, "Running: go test…", truncate(message, 17))
+	require.Equal(t, "Runni…", truncate(message, 6))
+	require.Equal(t, "…", truncate(message, 1))
+	require.Equal(t, "", truncate(message, 0))
+	require.Equal(t, "ビルド…", truncate("ビルド中です", 7))
+}
+
+func TestTerminalFitsMessagesToTheWidth(t *testing.T) {
+	message := "Running: go build -o ./build/goke ./cmd/cli"
+
+	require.Equal(t, message, newTestTerminal(false, 0).fit(message, " ", false))
+	require.Equal(t, message, newTestTerminal(false, 120).fit(message, " ", false))
+	require.Equal(t, "Running: go build…", newTestTerminal(false, 21).fit(message, " ", false))
+	require.Equal(t, "Runnin…", newTestTerminal(false, 21).fit(message, " 1/2 build", false))
+	require.Equal(t, "", newTestTerminal(false, 5).fit(message, " 1/2 build", false))
+}
+
+func TestPlainTerminalTruncatesUnlessVerbose(t *testing.T) {
+	message := "Running: " + strings.Repeat("x", 100)
+
+	require.Len(t, []rune(newTestTerminal(true, 0).fit(message, " ", false)), defaultPlainWidth-3)
+	require.Len(t, []rune(newTestTerminal(true, 40).fit(message, " ", false)), 40-3)
+	require.Equal(t, message, newTestTerminal(true, 40).fit(message, " ", true))
+}
+
+func TestDumbTerminalUsesPlainRenderer(t *testing.T) {
+	require.True(t, isPlainTerminal("dumb", true))
+	require.True(t, isPlainTerminal("xterm-256color", false))
+	require.False(t, isPlainTerminal("xterm-256color", true))
+
+	require.Equal(t, yacspin.ForceNoTTYMode|yacspin.ForceDumbTerminalMode, newSpinnerConfig(true).TerminalMode)
+	require.Equal(t, yacspin.TerminalMode(0), newSpinnerConfig(false).TerminalMode)
+}
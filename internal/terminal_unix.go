@@ -0,0 +1,54 @@
+//go:build !windows
+
+package internal
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func terminalWidth() int {
+	ws, err := unix.IoctlGetWinsize(int(os.Stdout.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 {
+		return columnsEnv()
+	}
+
+	return int(ws.Col)
+}
+
+// Calls resized whenever the terminal window changes its size.
+func watchResize(resized func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGWINCH)
+
+	go func() {
+		for range ch {
+			resized()
+		}
+	}()
+}
+
+// Waits until the file has something to read, false when the timeout passed
+// first.
+func waitReadable(f *os.File, timeout time.Duration) (bool, error) {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		ms := int(time.Until(deadline) / time.Millisecond)
+		if ms < 0 {
+			ms = 0
+		}
+
+		fds := []unix.PollFd{{Fd: int32(f.Fd()), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, ms)
+		if err == unix.EINTR {
+			continue
+		}
+
+		return n > 0, err
+	}
+}
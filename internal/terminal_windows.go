@@ -0,0 +1,38 @@
+//go:build windows
+
+package internal
+
+import (
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+func terminalWidth() int {
+	var info windows.ConsoleScreenBufferInfo
+	if err := windows.GetConsoleScreenBufferInfo(windows.Handle(os.Stdout.Fd()), &info); err != nil {
+		return columnsEnv()
+	}
+
+	return int(info.Window.Right - info.Window.Left + 1)
+}
+
+// Windows has no SIGWINCH, the width is only queried once.
+func watchResize(resized func()) {}
+
+// Waits until the file has something to read, false when the timeout passed
+// first. Consoles also signal input for events other than keys, ie. focus
+// changes, after which reading still waits for a key.
+func waitReadable(f *os.File, timeout time.Duration) (bool, error) {
+	if timeout < 0 {
+		timeout = 0
+	}
+
+	event, err := windows.WaitForSingleObject(windows.Handle(f.Fd()), uint32(timeout/time.Millisecond))
+	if err != nil {
+		return false, err
+	}
+
+	return event == windows.WAIT_OBJECT_0, nil
+}
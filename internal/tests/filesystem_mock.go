@@ -13,6 +13,27 @@ type FileSystem struct {
 	mock.Mock
 }
 
+// EvalSymlinks provides a mock function with given fields: path
+func (_m *FileSystem) EvalSymlinks(path string) (string, error) {
+	ret := _m.Called(path)
+
+	var r0 string
+	if rf, ok := ret.Get(0).(func(string) string); ok {
+		r0 = rf(path)
+	} else {
+		r0 = ret.Get(0).(string)
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(path)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // FileExists provides a mock function with given fields: filename
 func (_m *FileSystem) FileExists(filename string) bool {
 	ret := _m.Called(filename)
@@ -71,6 +92,52 @@ func (_m *FileSystem) Glob(path string) ([]string, error) {
 	return r0, r1
 }
 
+// Lstat provides a mock function with given fields: name
+func (_m *FileSystem) Lstat(name string) (fs.FileInfo, error) {
+	ret := _m.Called(name)
+
+	var r0 fs.FileInfo
+	if rf, ok := ret.Get(0).(func(string) fs.FileInfo); ok {
+		r0 = rf(name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(fs.FileInfo)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// ReadDir provides a mock function with given fields: name
+func (_m *FileSystem) ReadDir(name string) ([]fs.DirEntry, error) {
+	ret := _m.Called(name)
+
+	var r0 []fs.DirEntry
+	if rf, ok := ret.Get(0).(func(string) []fs.DirEntry); ok {
+		r0 = rf(name)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]fs.DirEntry)
+		}
+	}
+
+	var r1 error
+	if rf, ok := ret.Get(1).(func(string) error); ok {
+		r1 = rf(name)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // ReadFile provides a mock function with given fields: name
 func (_m *FileSystem) ReadFile(name string) ([]byte, error) {
 	ret := _m.Called(name)
@@ -108,6 +175,20 @@ func (_m *FileSystem) Remove(name string) error {
 	return r0
 }
 
+// Rename provides a mock function with given fields: oldpath, newpath
+func (_m *FileSystem) Rename(oldpath string, newpath string) error {
+	ret := _m.Called(oldpath, newpath)
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(string, string) error); ok {
+		r0 = rf(oldpath, newpath)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // Stat provides a mock function with given fields: name
 func (_m *FileSystem) Stat(name string) (fs.FileInfo, error) {
 	ret := _m.Called(name)
@@ -8,7 +8,7 @@ import (
 	mock "github.com/stretchr/testify/mock"
 )
 
-const ReadFileBase64 = "Sf+BAwEBBlBhcnNlcgH/ggABBAEFVGFza3MB/4gAAQlGaWxlUGF0aHMB/4YAAQpZQU1MQ29uZmlnAQwAAQZHbG9iYWwB/4oAAAAZ/4cEAQEIdGFza0xpc3QB/4gAAQwB/4QAACn/gwMBAv+EAAEDAQROYW1lAQwAAQVGaWxlcwH/hgABA1J1bgH/hgAAABb/hQIBAQhbXXN0cmluZwH/hgABDAAAIP+JAwEBBkdsb2JhbAH/igABAQEGU2hhcmVkAf+MAAAA/gGY/4sDAQH+AWtzdHJ1Y3QgeyBFbnZpcm9ubWVudCBtYXBbc3RyaW5nXXN0cmluZyAieWFtbDpcImVudmlyb25tZW50LG9taXRlbXB0eVwiIjsgRXZlbnRzIHN0cnVjdCB7IEJlZm9yZUVhY2hSdW4gW11zdHJpbmcgInlhbWw6XCJiZWZvcmVfZWFjaF9ydW4sb21pdGVtcHR5XCIiOyBBZnRlckVhY2hSdW4gW11zdHJpbmcgInlhbWw6XCJhZnRlcl9lYWNoX3J1bixvbWl0ZW1wdHlcIiI7IEJlZm9yZUVhY2hUYXNrIFtdc3RyaW5nICJ5YW1sOlwiYmVmb3JlX2VhY2hfdGFzayxvbWl0ZW1wdHlcIiI7IEFmdGVyRWFjaFRhc2sgW11zdHJpbmcgInlhbWw6XCJhZnRlcl9lYWNoX3Rhc2ssb21pdGVtcHR5XCIiIH0gInlhbWw6XCJldmVudHMsb21pdGVtcHR5XCIiIH0B/4wAAQIBC0Vudmlyb25tZW50Af+OAAEGRXZlbnRzAf+QAAAAIf+NBAEBEW1hcFtzdHJpbmddc3RyaW5nAf+OAAEMAQwAAP4BWP+PAwEB//1zdHJ1Y3QgeyBCZWZvcmVFYWNoUnVuIFtdc3RyaW5nICJ5YW1sOlwiYmVmb3JlX2VhY2hfcnVuLG9taXRlbXB0eVwiIjsgQWZ0ZXJFYWNoUnVuIFtdc3RyaW5nICJ5YW1sOlwiYWZ0ZXJfZWFjaF9ydW4sb21pdGVtcHR5XCIiOyBCZWZvcmVFYWNoVGFzayBbXXN0cmluZyAieWFtbDpcImJlZm9yZV9lYWNoX3Rhc2ssb21pdGVtcHR5XCIiOyBBZnRlckVhY2hUYXNrIFtdc3RyaW5nICJ5YW1sOlwiYWZ0ZXJfZWFjaF90YXNrLG9taXRlbXB0eVwiIiB9Af+QAAEEAQ1CZWZvcmVFYWNoUnVuAf+GAAEMQWZ0ZXJFYWNoUnVuAf+GAAEOQmVmb3JlRWFjaFRhc2sB/4YAAQ1BZnRlckVhY2hUYXNrAf+GAAAA/gMv/4IBBQZnbG9iYWwBBmdsb2JhbAAGZXZlbnRzAQZldmVudHMAC2dyZWV0LWxpc2hhAQtncmVldC1saXNoYQIBE2VjaG8gJ0hlbGxvIExpc2hhIScACmdyZWV0LWxva2kBCmdyZWV0LWxva2kCARFlY2hvICJIZWxsbyBCb2tpIgAKZ3JlZXQtY2F0cwEKZ3JlZXQtY2F0cwEBD2NtZC9jbGkvbWFpbi5nbwEDEWVjaG8gIkhlbGxvIEZyZXkiEmVjaG8gIkhlbGxvIFN1bm55IgpncmVldC1sb2tpAAEBD2NtZC9jbGkvbWFpbi5nbwH+AhIKZ2xvYmFsOgogIGVudmlyb25tZW50OgogICAgRk9POiAiZm9vIgogICAgQkFSOiAiJChlY2hvICdiYXInKSIKICAgIEJBWjogImJheiIKCmV2ZW50czoKICBiZWZvcmVfZWFjaF9ydW46CiAgICAtICJlY2hvICdiZWZvcmUgZWFjaCAxJyIKICAgIC0gImVjaG8gJ2JlZm9yZSBlYWNoIDInIgogIGFmdGVyX2VhY2hfcnVuOgogICAgLSAiZWNobyAnYWZ0ZXIgZWFjaCAxJyIKICAgIC0gImdyZWV0LWxpc2hhIgogIGJlZm9yZV9lYWNoX3Rhc2s6CiAgICAtICJlY2hvICdiZWZvcmUgdGFzayciCiAgYWZ0ZXJfZWFjaF90YXNrOgogICAgLSAiZWNobyAnYWZ0ZXIgdGFzayciCgpncmVldC1saXNoYToKICBydW46CiAgICAtICJlY2hvICdIZWxsbyBMaXNoYSEnIgoKZ3JlZXQtbG9raToKICBydW46CiAgICAtICdlY2hvICJIZWxsbyBCb2tpIicKCmdyZWV0LWNhdHM6CiAgZmlsZXM6IFtjbWQvY2xpLypdCiAgcnVuOgogICAgLSAnZWNobyAiSGVsbG8gRnJleSInCiAgICAtICdlY2hvICJIZWxsbyBTdW5ueSInCiAgICAtICJncmVldC1sb2tpIgEBAQMDQkFaA2JhegNGT08DZm9vA0JBUg0kKGVjaG8gJ2JhcicpAQAAAAA="
+const ReadFileBase64 = "R38DAQEGUGFyc2VyAf+AAAEEAQVUYXNrcwH/jAABCUZpbGVQYXRocwH/hAABCFdhcm5pbmdzAf+EAAEGR2xvYmFsAf+OAAAAGf+LBAEBCHRhc2tMaXN0Af+MAAEMAf+CAAD+AQ//gQMBAv+CAAEUAQROYW1lAQwAAQREZXNjAQwAAQVGaWxlcwH/hAABA1J1bgH/igABA0VudgH/iAABBERlcHMB/4QAAQxGaWxlUGF0dGVybnMB/4QAAQ5Gb2xsb3dTeW1saW5rcwECAAEMSW5oZXJpdEZpbGVzAQIAAQRUYWdzAf+EAAEFU2hlbGwBAgABCENoZWNrc3VtAQIAAQlQcmVmbGlnaHQBAgABA0RpcgEMAAEOT3V0cHV0RW5jb2RpbmcBDAABB1Jlc3RhcnQBAgABCFBhcmFsbGVsAQIAAQ5NYXhDb25jdXJyZW5jeQEEAAEPQ29udGludWVPbkVycm9yAQIAAQVFdmVyeQEEAAAAFv+DAgEBCFtdc3RyaW5nAf+EAAEMAAAi/4kCAQETW11pbnRlcm5hbC5SdW5FbnRyeQH/igAB/4YAAGP/hQMBAQhSdW5FbnRyeQH/hgABBwEDQ21kAQwAAQNEaXIBDAABCkRpZmZPdXRwdXQBAgABBkV4cG9ydAH/iAABBEdva2UBDAABBFRhc2sBDAABC0lnbm9yZUVycm9yAQIAAAAh/4cEAQERbWFwW3N0cmluZ11zdHJpbmcB/4gAAQwBDAAAIP+NAwEBBkdsb2JhbAH/jgABAQEGU2hhcmVkAf+QAAAA/gEU/48DAQH/0XN0cnVjdCB7IEVudmlyb25tZW50IG1hcFtzdHJpbmddc3RyaW5nICJ5YW1sOlwiZW52aXJvbm1lbnQsb21pdGVtcHR5XCIiOyBTaGVsbCBib29sICJ5YW1sOlwic2hlbGwsb21pdGVtcHR5XCIiOyBDaGVja3N1bSBib29sICJ5YW1sOlwiY2hlY2tzdW0sb21pdGVtcHR5XCIiOyBFdmVudHMgaW50ZXJuYWwuRXZlbnRzICJ5YW1sOlwiZXZlbnRzLG9taXRlbXB0eVwiIiB9Af+QAAEEAQtFbnZpcm9ubWVudAH/iAABBVNoZWxsAQIAAQhDaGVja3N1bQECAAEGRXZlbnRzAf+SAAAAYP+RAwEBBkV2ZW50cwH/kgABBAENQmVmb3JlRWFjaFJ1bgH/lgABDEFmdGVyRWFjaFJ1bgH/lgABDkJlZm9yZUVhY2hUYXNrAf+WAAENQWZ0ZXJFYWNoVGFzawH/lgAAACT/lQIBARVbXWludGVybmFsLkV2ZW50RW50cnkB/5YAAf+UAAA+/5MDAQEKRXZlbnRFbnRyeQH/lAABAwEDQ21kAQwAAQhPbmx5VGFncwH/hAABCkV4Y2VwdFRhZ3MB/4QAAAD+AZv/gAEGC2dyZWV0LWxpc2hhAQtncmVldC1saXNoYQMBARNlY2hvICdIZWxsbyBMaXNoYSEnAAAKZ3JlZXQtbG9raQEKZ3JlZXQtbG9raQMBARFlY2hvICJIZWxsbyBCb2tpIgAACmdyZWV0LWNhdHMBCmdyZWV0LWNhdHMDAwERZWNobyAiSGVsbG8gRnJleSIAARJlY2hvICJIZWxsbyBTdW5ueSIAAQpncmVldC1sb2tpAAMBCWNtZC9jbGkvKgAKZ3JlZXQtdGhvcgEKZ3JlZXQtdGhvcgMBARRlY2hvICJIZWxsbyAke1RIT1J9IgABAQRUSE9SD0xPUkQgT0YgVEhVTkRFUgAGZ2xvYmFsAQZnbG9iYWwABmV2ZW50cwEGZXZlbnRzAAEBD2NtZC9jbGkvbWFpbi5nbwEBPXRhc2sgJ2dyZWV0LWNhdHMnOiBmaWxlcyBwYXR0ZXJuICJjbWQvY2xpLyoiIG1hdGNoZXMgbm8gZmlsZXMBAQEDA0JBUgNiYXIDQkFaA2JhegNGT08DZm9vAwAAAAA="
 
 func GetFileSystemMock(t *testing.T) any {
 	fsMock := NewFileSystem(t)
@@ -0,0 +1,95 @@
+package internal
+
+import (
+	"fmt"
+	"os/exec"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	RegisterCapability("task.timeout")
+}
+
+const (
+	// The exit status of a run whose command timed out, like the timeout
+	// command.
+	ExitCodeTimeout = 124
+
+	// How long a timed out command gets to exit after SIGTERM, before it's
+	// killed.
+	timeoutGracePeriod = 5 * time.Second
+)
+
+// A command ran longer than the timeout of its task.
+type CommandTimeoutError struct {
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *CommandTimeoutError) Error() string {
+	return fmt.Sprintf("timed out after %s", e.Timeout)
+}
+
+func (e *CommandTimeoutError) Unwrap() error {
+	return e.Err
+}
+
+// The timeout of the task's commands, which defaults to global.timeout.
+// Zero means no timeout.
+func (p *Parser) commandTimeout(task Task) time.Duration {
+	if task.Timeout != nil {
+		return *task.Timeout
+	}
+
+	return p.Global.Shared.Timeout
+}
+
+// Runs the command in the group. Once the timeout passed, unless it's zero,
+// the command gets SIGTERM, and is killed if it's still running after
+// timeoutGracePeriod. When --deadline expires first, it stops the command
+// instead, so the smaller of both wins. Ctrl-C is forwarded to the command,
+// see runInterrupt.
+func (e *Executor) runInGroup(group *processes, cmd *exec.Cmd, timeout time.Duration) error {
+	if err := e.deadline.check(); err != nil {
+		return err
+	}
+
+	if err := e.interrupt.check(); err != nil {
+		return err
+	}
+
+	var timedOut atomic.Bool
+	if timeout > 0 {
+		stop := e.timer()(timeout, func() {
+			timedOut.Store(true)
+			go group.stopCommand(cmd, timeoutGracePeriod)
+		})
+		defer stop()
+	}
+
+	var err error
+	if e.runner != nil {
+		err = e.runner.Run(cmd)
+	} else {
+		untrack, unforward := e.deadline.track(group), e.interrupt.track(group)
+		err = group.run(cmd)
+		untrack()
+		unforward()
+	}
+
+	if timedOut.Load() && !e.deadline.hasExpired() {
+		return &CommandTimeoutError{Timeout: timeout, Err: err}
+	}
+
+	return err
+}
+
+// Creates the timers of timeouts, which are replaced in tests.
+func (e *Executor) timer() timerFunc {
+	if e.newTimer == nil {
+		return newRealTimer
+	}
+
+	return e.newTimer
+}
@@ -0,0 +1,109 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCommandTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("relies on POSIX signals")
+	}
+
+	config := `
+build:
+  timeout: 100ms
+  run:
+    - "sleep 5"
+`
+	e := newTestExecutor(t, config)
+
+	started := time.Now()
+	err := e.dispatchTask(e.parser.Tasks["build"], true)
+
+	require.Less(t, time.Since(started), 3*time.Second)
+	require.EqualError(t, err, `"sleep 5" timed out after 100ms`)
+	require.Equal(t, ExitCodeTimeout, ExitCode(err))
+}
+
+func TestParseTimeouts(t *testing.T) {
+	env := NewInMemoryEnv(`
+global:
+  timeout: 10m
+
+build:
+  run:
+    - "go build"
+
+test:
+  timeout: 30s
+  run:
+    - "go test"
+
+serve:
+  timeout: 0s
+  run:
+    - "go run ."
+`)
+	p, err := env.Parse()
+	require.NoError(t, err)
+
+	require.Equal(t, 10*time.Minute, p.commandTimeout(p.Tasks["build"]))
+	require.Equal(t, 30*time.Second, p.commandTimeout(p.Tasks["test"]))
+	require.Equal(t, time.Duration(0), p.commandTimeout(p.Tasks["serve"]))
+	require.Equal(t, 10*time.Minute, p.hookEntry("echo done").timeout)
+
+	_, err = NewInMemoryEnv("build:\n  timeout: -1s\n  run: [\"go build\"]\n").Parse()
+	require.EqualError(t, err, "task 'build': timeout must be a positive duration")
+}
+
+// The timeout of the task and --deadline each stop the command, whichever
+// expires first decides how the run fails.
+func TestTimeoutAndDeadlineSmallerWins(t *testing.T) {
+	cases := []struct {
+		timeout  string
+		exceeded bool
+		exitCode int
+	}{
+		{timeout: "1m", exceeded: false, exitCode: ExitCodeTimeout},
+		{timeout: "1h", exceeded: true, exitCode: ExitCodeDeadlineExceeded},
+	}
+
+	for _, c := range cases {
+		env := NewInMemoryEnv("test:\n  timeout: " + c.timeout + "\n  run: [\"go test\"]\n")
+		clock := &fakeDeadlineClock{}
+
+		p, err := env.Parse()
+		require.NoError(t, err)
+
+		e := NewExecutor(p, &env.lockfile, &env.history, &env.Options, RunMetadata{})
+		e.runner = env.Runner
+		e.newTimer = clock.newTimer
+		e.deadline = newRunDeadline(25*time.Minute, clock.Now, clock.newTimer)
+
+		// The timer of the deadline is the first one, the timeout's the second.
+		env.Runner.Handler = func(cmd *exec.Cmd) error {
+			first := 0
+			if clock.timers[1].d < clock.timers[0].d {
+				first = 1
+			}
+
+			clock.fire(first, time.Time{})
+			return errors.New("signal: terminated")
+		}
+
+		err = e.StartContext(context.Background(), []string{"test"})
+		require.Equal(t, c.exitCode, ExitCode(err), c.timeout)
+
+		var exceeded *DeadlineExceededError
+		require.Equal(t, c.exceeded, errors.As(err, &exceeded), c.timeout)
+		require.Equal(t, !c.exceeded, strings.Contains(err.Error(), `"go test" timed out after `+c.timeout), err.Error())
+	}
+}
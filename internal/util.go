@@ -6,15 +6,28 @@ import (
 	"encoding/gob"
 	"errors"
 	"fmt"
-	"log"
+	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"gopkg.in/yaml.v3"
 )
 
 func GokeFiles() []string {
 	return []string{"goke.yml", "goke.yaml"}
 }
 
-func CurrentConfigFile() string {
+// The config to load: the one given with --config, or else the goke.yml or
+// goke.yaml of the working directory, if any.
+func CurrentConfigFile(configPath string) string {
+	if configPath != "" {
+		return configPath
+	}
+
 	for _, f := range GokeFiles() {
 		if FileExists(f) {
 			return f
@@ -24,7 +37,61 @@ func CurrentConfigFile() string {
 	return ""
 }
 
-func ReadYamlConfig() (string, error) {
+// Changes to the closest directory above the working one which has a
+// goke.yml or goke.yaml, like git does for .git, so that the config works
+// the same from any subdirectory. Stays put when the working directory has
+// one, or when none is found up to the root of the filesystem. The paths of
+// the options are made absolute first, so that they keep pointing to the
+// files given on the command line, see Options.absPaths.
+func ChdirToConfigDir(opts *Options) error {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	dir := findConfigDir(cwd)
+	if dir == "" || dir == cwd {
+		return nil
+	}
+
+	if opts.Verbose && !opts.Quiet {
+		fmt.Printf("Using the config of %s\n", dir)
+	}
+
+	if err := opts.absPaths(); err != nil {
+		return err
+	}
+
+	return os.Chdir(dir)
+}
+
+// Returns the first of dir and its parents with a goke.yml or goke.yaml,
+// or "" when there is none.
+func findConfigDir(dir string) string {
+	for {
+		for _, f := range GokeFiles() {
+			if FileExists(filepath.Join(dir, f)) {
+				return dir
+			}
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+
+		dir = parent
+	}
+}
+
+// Reads the config given with --config, or else the one of the working
+// directory, see CurrentConfigFile.
+func ReadYamlConfig(configPath string) (string, error) {
+	if configPath != "" {
+		content, err := os.ReadFile(configPath)
+		return string(content), err
+	}
+
 	for _, f := range GokeFiles() {
 		content, err := os.ReadFile(f)
 
@@ -36,12 +103,122 @@ func ReadYamlConfig() (string, error) {
 	return "", errors.New("no presence of goke.yml sighted")
 }
 
+// Per-developer overrides files, loaded after the main config. They are not
+// meant to be committed.
+func LocalGokeFiles() []string {
+	return []string{"goke.local.yml", filepath.Join(".goke", "local.yml")}
+}
+
+func CurrentLocalConfigFile() string {
+	for _, f := range LocalGokeFiles() {
+		if FileExists(f) {
+			return f
+		}
+	}
+
+	return ""
+}
+
+// Reads the local overrides file, if there is one.
+// Returns its path and its contents.
+func ReadLocalYamlConfig() (string, string, error) {
+	f := CurrentLocalConfigFile()
+	if f == "" {
+		return "", "", nil
+	}
+
+	content, err := os.ReadFile(f)
+	if err != nil {
+		return "", "", err
+	}
+
+	return f, string(content), nil
+}
+
+// Reports whether the path is matched by a pattern in the project's .gitignore.
+func IsGitIgnored(path string) bool {
+	content, err := os.ReadFile(".gitignore")
+	if err != nil {
+		return false
+	}
+
+	path = filepath.ToSlash(path)
+
+	for _, line := range strings.Split(string(content), "\n") {
+		pattern := strings.Trim(strings.TrimSpace(line), "/")
+		if pattern == "" || strings.HasPrefix(pattern, "#") {
+			continue
+		}
+
+		if matched, _ := filepath.Match(pattern, path); matched {
+			return true
+		}
+
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			return true
+		}
+
+		if strings.HasPrefix(path, pattern+"/") {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Returns the line on which the top-level key is declared in the YAML config.
+func keyLine(config string, key string) int {
+	return keyLines(config)[key]
+}
+
+// Returns the lines on which the top-level keys are declared in the YAML
+// config.
+func keyLines(config string) map[string]int {
+	lines := make(map[string]int)
+
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(config), &doc); err != nil || len(doc.Content) == 0 {
+		return lines
+	}
+
+	mapping := doc.Content[0]
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if _, ok := lines[mapping.Content[i].Value]; !ok {
+			lines[mapping.Content[i].Value] = mapping.Content[i].Line
+		}
+	}
+
+	return lines
+}
+
+// Whether a key declared on lineA comes before one declared on lineB, where
+// the line of keys the config doesn't declare, ie. the ones only declared by
+// local overrides, is 0 and they come last.
+func lineBefore(lineA int, lineB int) bool {
+	if lineA == 0 || lineB == 0 {
+		return lineA != 0 && lineB == 0
+	}
+
+	return lineA < lineB
+}
+
+// Sorts the keys of m in the order the config declares them, see lineBefore.
+// Keys which aren't declared are sorted by name.
+func declarationOrder[T any](m map[string]T, lines map[string]int) []string {
+	keys := sortedKeys(m)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return lineBefore(lines[keys[i]], lines[keys[j]])
+	})
+
+	return keys
+}
+
 func CreateGokeConfig() error {
 	const sampleConfig = `global:
-environment:
-  MY_BINARY: "my_binary"
+  environment:
+    MY_BINARY: "my_binary"
 
-build: 
+build:
   files: [cmd/cli/*.go, internal/*]
   run:
     - "go build -o ./build/${MY_BINARY} ./cmd/cli"
@@ -56,6 +233,17 @@ build:
 	return os.WriteFile("goke.yml", []byte(sampleConfig), 0644)
 }
 
+// Returns goke's own directory for state files, creating it if needed.
+func StateDir() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir := filepath.Join(cacheDir, "goke")
+	return dir, os.MkdirAll(dir, 0755)
+}
+
 func FileExists(filename string) bool {
 	info, err := os.Stat(filename)
 	if os.IsNotExist(err) {
@@ -64,37 +252,121 @@ func FileExists(filename string) bool {
 	return !info.IsDir()
 }
 
-// Serialize a struct
-func GOBSerialize[T any](structInstance T) string {
-	b := bytes.Buffer{}
-	e := gob.NewEncoder(&b)
-	err := e.Encode(structInstance)
+// Writes the rows as aligned columns, without any trailing padding.
+func writeTable(out io.Writer, rows [][]string) {
+	table := bytes.Buffer{}
+	w := tabwriter.NewWriter(&table, 0, 0, 2, ' ', 0)
 
-	if err != nil {
-		log.Fatal("failed gob encode", err)
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
 	}
 
-	return base64.StdEncoding.EncodeToString(b.Bytes())
+	w.Flush()
+
+	for _, line := range strings.SplitAfter(table.String(), "\n") {
+		if line != "" {
+			fmt.Fprintln(out, strings.TrimRight(line, " \n"))
+		}
+	}
 }
 
-// Deserialize a struct
-func GOBDeserialize[T any](structStr string, structShell *T) T {
-	by, err := base64.StdEncoding.DecodeString(structStr)
+// Wraps the command to run through the system shell: sh, or on Windows cmd
+// unless global.windows_shell names another.
+func shellCommand(command string, windowsShell string) []string {
+	if runtime.GOOS == "windows" {
+		return windowsShellCommand(command, windowsShell)
+	}
 
-	if err != nil {
-		log.Fatal("failed base64 decode", err)
+	return []string{"sh", "-c", command}
+}
+
+// The shells global.windows_shell can name.
+const (
+	windowsShellCmd        = "cmd"
+	windowsShellPowerShell = "powershell"
+	windowsShellPwsh       = "pwsh"
+)
+
+// Runs the command through the Windows shell, cmd unless another is given.
+func windowsShellCommand(command string, shell string) []string {
+	switch shell {
+	case windowsShellPowerShell, windowsShellPwsh:
+		return []string{shell, "-NoProfile", "-NonInteractive", "-Command", command}
+	}
+
+	return []string{"cmd", "/C", command}
+}
+
+func validateWindowsShell(shell string) error {
+	switch shell {
+	case "", windowsShellCmd, windowsShellPowerShell, windowsShellPwsh:
+		return nil
+	}
+
+	return fmt.Errorf("global: windows_shell must be %s, %s or %s, got '%s'", windowsShellCmd, windowsShellPowerShell, windowsShellPwsh, shell)
+}
+
+// Joins a relative path onto dir, leaving absolute paths alone.
+func joinDir(dir string, path string) string {
+	if dir == "" || filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(dir, path)
+}
+
+// Makes the files relative to dir, ie. for commands running in it.
+func relativeTo(dir string, files []string) []string {
+	if dir == "" {
+		return files
+	}
+
+	rel := make([]string, len(files))
+	for i, f := range files {
+		if r, err := filepath.Rel(dir, f); err == nil {
+			rel[i] = r
+		} else {
+			rel[i] = f
+		}
+	}
+
+	return rel
+}
+
+// Returns the keys of the map in lexical order.
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
 	}
 
+	sort.Strings(keys)
+	return keys
+}
+
+// Serializes the value with gob, encoded in base64.
+func GOBSerialize[T any](structInstance T) (string, error) {
 	b := bytes.Buffer{}
-	b.Write(by)
-	d := gob.NewDecoder(&b)
-	err = d.Decode(structShell)
+	if err := gob.NewEncoder(&b).Encode(structInstance); err != nil {
+		return "", fmt.Errorf("failed gob encode: %w", err)
+	}
+
+	return base64.StdEncoding.EncodeToString(b.Bytes()), nil
+}
 
+// Deserializes a value written by GOBSerialize into structShell, which is
+// also returned. Truncated or otherwise corrupt data results in an error.
+func GOBDeserialize[T any](structStr string, structShell *T) (T, error) {
+	by, err := base64.StdEncoding.DecodeString(structStr)
 	if err != nil {
-		log.Fatal("failed gob decode", err)
+		return *structShell, fmt.Errorf("failed base64 decode: %w", err)
 	}
 
-	return *structShell
+	if err := gob.NewDecoder(bytes.NewReader(by)).Decode(structShell); err != nil {
+		return *structShell, fmt.Errorf("failed gob decode: %w", err)
+	}
+
+	return *structShell, nil
 }
 
 func PermutateArgs(args []string) int {
@@ -102,7 +374,7 @@ func PermutateArgs(args []string) int {
 	optind := 0
 
 	for i := range args {
-		if args[i][0] == '-' {
+		if len(args[i]) > 0 && args[i][0] == '-' {
 			tmp := args[i]
 			args[i] = args[optind]
 			args[optind] = tmp
@@ -114,68 +386,34 @@ func PermutateArgs(args []string) int {
 }
 
 // Parses the command string into an array of [command, args, args]...
+// Kept for compatibility, see shellwords.Split for the exact rules, and
+// shellwords.SplitWindows for the ones of Windows.
 func ParseCommandLine(command string) ([]string, error) {
-	var args []string
-	state := "start"
-	current := ""
-	quote := "\""
-	escapeNext := true
-
-	for i := 0; i < len(command); i++ {
-		c := command[i]
-
-		if state == "quotes" {
-			if string(c) != quote {
-				current += string(c)
-			} else {
-				args = append(args, current)
-				current = ""
-				state = "start"
-			}
-			continue
-		}
-
-		if escapeNext {
-			current += string(c)
-			escapeNext = false
-			continue
-		}
-
-		if c == '\\' {
-			escapeNext = true
-			continue
-		}
-
-		if c == '"' || c == '\'' {
-			state = "quotes"
-			quote = string(c)
-			continue
-		}
-
-		if state == "arg" {
-			if c == ' ' || c == '\t' {
-				args = append(args, current)
-				current = ""
-				state = "start"
-			} else {
-				current += string(c)
-			}
-			continue
-		}
+	return splitWords(command)
+}
 
-		if c != ' ' && c != '\t' {
-			state = "arg"
-			current += string(c)
-		}
+// Splits the command into the program and its arguments,
+// naming the command when it can't be split.
+func splitCommand(command string) ([]string, error) {
+	words, err := splitWords(command)
+	if err != nil {
+		return nil, fmt.Errorf("invalid command %s: %w", command, err)
 	}
 
-	if state == "quotes" {
-		return []string{}, fmt.Errorf("unclosed quote in command: %s", command)
+	if len(words) == 0 {
+		return nil, errors.New("empty command")
 	}
 
-	if current != "" {
-		args = append(args, current)
+	return words, nil
+}
+
+// Whether the list contains the string.
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
 	}
 
-	return args, nil
+	return false
 }
@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPermutateArgs(t *testing.T) {
+	args := []string{"goke", "greet-cats", "--force"}
+
+	require.Equal(t, 2, PermutateArgs(args))
+	require.Equal(t, []string{"goke", "--force", "greet-cats"}, args)
+}
+
+func TestPermutateArgsWithEmptyArg(t *testing.T) {
+	require.Equal(t, 2, PermutateArgs([]string{"goke", "", "--force"}))
+}
+
+func chdir(t *testing.T, dir string) {
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+	require.Nil(t, os.Chdir(dir))
+
+	t.Cleanup(func() { _ = os.Chdir(cwd) })
+}
+
+func TestIsGitIgnored(t *testing.T) {
+	chdir(t, t.TempDir())
+	require.False(t, IsGitIgnored("goke.local.yml"))
+
+	require.Nil(t, os.WriteFile(".gitignore", []byte("# local files\n/build\n*.local.yml\n.goke/\n"), 0644))
+	require.True(t, IsGitIgnored("goke.local.yml"))
+	require.True(t, IsGitIgnored(".goke/local.yml"))
+	require.False(t, IsGitIgnored("goke.yml"))
+}
+
+func TestKeyLine(t *testing.T) {
+	require.Equal(t, 2, keyLine("\ngreet-loki:\n  run: []\n", "greet-loki"))
+	require.Equal(t, 0, keyLine("\ngreet-loki:\n  run: []\n", "greet-thor"))
+}
+
+func TestSplitCommand(t *testing.T) {
+	words, err := splitCommand(`echo "Hello Pepper"`)
+	require.Nil(t, err)
+	require.Equal(t, []string{"echo", "Hello Pepper"}, words)
+
+	_, err = splitCommand("  ")
+	require.EqualError(t, err, "empty command")
+
+	_, err = splitCommand("echo 'Hello")
+	require.EqualError(t, err, "invalid command echo 'Hello: unterminated single quote at position 5")
+}
+
+func TestWindowsShell(t *testing.T) {
+	require.Equal(t, []string{"cmd", "/C", "dir"}, windowsShellCommand("dir", ""))
+	require.Equal(t, []string{"pwsh", "-NoProfile", "-NonInteractive", "-Command", "Get-ChildItem"}, windowsShellCommand("Get-ChildItem", windowsShellPwsh))
+
+	_, err := NewInMemoryEnv("global:\n  windows_shell: bash\n").Parse()
+	require.EqualError(t, err, "global: windows_shell must be cmd, powershell or pwsh, got 'bash'")
+}
+
+func TestCreateGokeConfigNestsEnvironmentUnderGlobal(t *testing.T) {
+	chdir(t, t.TempDir())
+	require.Nil(t, CreateGokeConfig())
+
+	config, err := ReadYamlConfig("")
+	require.Nil(t, err)
+	require.Nil(t, lintConfig("goke.yml", config))
+
+	var g Global
+	require.Nil(t, decodeConfig("goke.yml", config, &g))
+	require.Equal(t, map[string]EnvValue{"MY_BINARY": {Value: "my_binary"}}, g.Shared.EnvironmentValues)
+}
+
+func TestFindConfigDirWalksUpToTheClosestConfig(t *testing.T) {
+	root := t.TempDir()
+	nested := filepath.Join(root, "internal", "foo")
+	require.Nil(t, os.MkdirAll(nested, 0755))
+
+	require.Equal(t, "", findConfigDir(nested))
+
+	require.Nil(t, os.WriteFile(filepath.Join(root, "goke.yaml"), []byte("build: {}\n"), 0644))
+	require.Equal(t, root, findConfigDir(nested))
+	require.Equal(t, root, findConfigDir(root))
+
+	require.Nil(t, os.WriteFile(filepath.Join(root, "internal", "goke.yml"), []byte("build: {}\n"), 0644))
+	require.Equal(t, filepath.Join(root, "internal"), findConfigDir(nested))
+}
+
+func TestChdirToConfigDirKeepsThePathsOfTheOptions(t *testing.T) {
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.Nil(t, err)
+
+	nested := filepath.Join(root, "cmd")
+	require.Nil(t, os.Mkdir(nested, 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(root, "goke.yml"), []byte("build: {}\n"), 0644))
+	chdir(t, nested)
+
+	opts := Options{Batch: "plan.yml", CaptureDir: "out", SummaryLine: "status.txt"}
+	require.Nil(t, ChdirToConfigDir(&opts))
+
+	cwd, err := os.Getwd()
+	require.Nil(t, err)
+	require.Equal(t, root, cwd)
+	require.Equal(t, filepath.Join(nested, "plan.yml"), opts.Batch)
+	require.Equal(t, filepath.Join(nested, "out"), opts.CaptureDir)
+	require.Equal(t, filepath.Join(nested, "status.txt"), opts.SummaryLine)
+
+	opts = Options{SummaryLine: SummaryLineStdout}
+	chdir(t, nested)
+	require.Nil(t, ChdirToConfigDir(&opts))
+	require.Equal(t, SummaryLineStdout, opts.SummaryLine)
+}
@@ -0,0 +1,401 @@
+package internal
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+func init() {
+	RegisterCapability("command.validate")
+}
+
+// Commands which look like the name of a task, ie. "tset" or "api:build",
+// rather than a command with arguments.
+var bareWordRe = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// How far a misspelled key or task may be from the right one to be
+// suggested instead, see editDistance.
+const maxSuggestionDistance = 2
+
+// The keys under global.fmt, which only "goke fmt" reads.
+type formatSettings struct {
+	SortTasks bool `yaml:"sort_tasks"`
+}
+
+// Lists the mistakes of the parsed config, for "goke validate":
+//
+//   - keys the config and its included files declare which goke doesn't
+//     know, since they are otherwise ignored, see UnknownConfigKeys;
+//   - the warnings of the config, ie. files patterns matching no files;
+//   - commands of run, on_success, on_failure and the events which look
+//     like the name of a task, but are neither a task nor a binary in PATH;
+//   - deps which aren't tasks, when the config was parsed with
+//     CollectErrors;
+//   - tasks which reference themselves, through run, deps, on_success,
+//     on_failure and their events.
+//
+// Each problem names the task it's about.
+func (p *Parser) Validate() []string {
+	problems := UnknownConfigKeys(p.configFile(), p.config)
+	for _, inc := range p.included {
+		problems = append(problems, UnknownConfigKeys(inc.path, inc.config)...)
+	}
+
+	if p.localConfig != "" {
+		problems = append(problems, UnknownConfigKeys(p.localConfigPath, p.localConfig)...)
+	}
+
+	problems = append(problems, p.Warnings...)
+	problems = append(problems, p.unknownCommands()...)
+	problems = append(problems, p.unknownDeps...)
+
+	return append(problems, p.referenceCycles()...)
+}
+
+// Lists the keys of the config which goke doesn't know, along with the
+// line they're on, ie. "goke.yml:12: task 'build': unknown key 'fiels',
+// did you mean 'files'?". Configs which can't be decoded have none, their
+// errors are reported when parsing them instead.
+func UnknownConfigKeys(file string, config string) []string {
+	var doc yaml.Node
+	if yaml.Unmarshal([]byte(config), &doc) != nil || len(doc.Content) == 0 {
+		return nil
+	}
+
+	root := resolveAlias(doc.Content[0])
+	if root.Kind != yaml.MappingNode {
+		return nil
+	}
+
+	problems := []string{}
+	for i := 0; i+1 < len(root.Content); i += 2 {
+		key, value := root.Content[i], root.Content[i+1]
+
+		var (
+			label = fmt.Sprintf("task '%s'", key.Value)
+			t     = reflect.TypeOf(Task{})
+		)
+
+		switch key.Value {
+		case varsKey, hooksKey, placeholdersKey, generateTasksKey:
+			continue
+		case includesKey:
+			label, t = includesKey, reflect.TypeOf([]includeEntry{})
+		case "global":
+			label, t = "global", globalKeysType()
+		}
+
+		problems = append(problems, unknownKeys(file, label, "", value, t)...)
+	}
+
+	return problems
+}
+
+// The type of global, along with global.fmt, see formatSettings.
+func globalKeysType() reflect.Type {
+	shared := reflect.TypeOf(Global{}.Shared)
+	fields := []reflect.StructField{{Name: "Fmt", Type: reflect.TypeOf(formatSettings{}), Tag: `yaml:"fmt"`}}
+	for i := 0; i < shared.NumField(); i++ {
+		fields = append(fields, shared.Field(i))
+	}
+
+	return reflect.StructOf(fields)
+}
+
+// Walks the node along with the type it's decoded into, and reports the
+// keys of its mappings which aren't fields of the type. Like yaml.v3, the
+// fields without a yaml tag are known by their lowercased name. Scalars
+// decoded into structs, ie. run entries given as a plain command, are left
+// to their UnmarshalYAML.
+func unknownKeys(file string, label string, path string, node *yaml.Node, t reflect.Type) []string {
+	node = resolveAlias(node)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	problems := []string{}
+	switch {
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for _, item := range node.Content {
+			problems = append(problems, unknownKeys(file, label, path, item, t.Elem())...)
+		}
+
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 1; i < len(node.Content); i += 2 {
+			problems = append(problems, unknownKeys(file, label, joinKeyPath(path, node.Content[i-1].Value), node.Content[i], t.Elem())...)
+		}
+
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := yamlFields(t)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+
+			// Merge keys, ie. "<<: *defaults", add the keys of the mappings
+			// they reference.
+			if key.Value == "<<" {
+				problems = append(problems, unknownKeys(file, label, path, value, t)...)
+				continue
+			}
+
+			field, ok := fields[key.Value]
+			if !ok {
+				problem := fmt.Sprintf("%s:%d: %s: unknown key '%s'", file, key.Line, label, joinKeyPath(path, key.Value))
+				if suggestion := closestName(key.Value, sortedKeys(fields)); suggestion != "" {
+					problem += fmt.Sprintf(", did you mean '%s'?", suggestion)
+				}
+
+				problems = append(problems, problem)
+				continue
+			}
+
+			problems = append(problems, unknownKeys(file, label, joinKeyPath(path, key.Value), value, field)...)
+		}
+	}
+
+	return problems
+}
+
+// The types of the fields of the struct, by the key they're decoded from.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := map[string]reflect.Type{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+
+		fields[name] = field.Type
+	}
+
+	return fields
+}
+
+// The node an alias, ie. "*defaults", refers to.
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node != nil && node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	return node
+}
+
+// Joins the keys leading to a nested one, ie. "events.before_each_run".
+func joinKeyPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
+
+// Lists the commands which look like the name of a task, but are neither a
+// task, a built-in command, a shell builtin when they run through the
+// shell, nor a binary in PATH. They are most likely misspelled tasks, which
+// would only fail once the task runs.
+func (p *Parser) unknownCommands() []string {
+	var (
+		pf       = newPreflight(p)
+		problems = []string{}
+	)
+
+	check := func(label string, key string, cmd string, shell bool) {
+		if !bareWordRe.MatchString(cmd) {
+			return
+		}
+
+		if _, ok := p.Tasks[cmd]; ok && cmd != "global" {
+			return
+		}
+
+		if _, ok, err := lookupBuiltin(cmd); ok || err != nil {
+			if err != nil {
+				problems = append(problems, fmt.Sprintf("%s: %s: %s", label, key, err))
+			}
+			return
+		}
+
+		if (shell && isShellWord(cmd)) || pf.lookup(cmd) {
+			return
+		}
+
+		problem := fmt.Sprintf("%s: %s: '%s' is neither a task nor a binary in PATH", label, key, cmd)
+		if suggestion := closestName(cmd, p.taskNames()); suggestion != "" {
+			problem += fmt.Sprintf(", did you mean '%s'?", suggestion)
+		}
+
+		problems = append(problems, problem)
+	}
+
+	checkEvents := func(label string, events Events) {
+		for _, ev := range events.all() {
+			check(label, "events", p.hookEntry(ev.Cmd).Cmd, p.Global.Shared.Shell)
+		}
+	}
+
+	checkEvents("global", p.Global.Shared.Events)
+
+	for _, name := range p.taskNames() {
+		task := p.Tasks[name]
+		label := fmt.Sprintf("task '%s'", name)
+
+		for _, entry := range task.Run {
+			if len(entry.Export) == 0 && entry.Goke == "" {
+				check(label, "run", entry.Cmd, p.usesShell(task))
+			}
+		}
+
+		for _, hooks := range []struct {
+			key  string
+			cmds []string
+		}{{"on_success", task.OnSuccess}, {"on_failure", task.OnFailure}} {
+			for _, cmd := range hooks.cmds {
+				cmd, _ = trimIgnorePrefix(cmd)
+				check(label, hooks.key, cmd, p.usesShell(task))
+			}
+		}
+
+		if task.OwnEvents {
+			checkEvents(label, task.Events)
+		}
+	}
+
+	return problems
+}
+
+// Lists the tasks which end up running themselves, through the tasks they
+// reference, see taskReferences, ie.
+// "task 'build': reference cycle detected: build -> test -> build". Each
+// cycle is reported once, from the first of its tasks by name.
+func (p *Parser) referenceCycles() []string {
+	const (
+		visiting = iota + 1
+		done
+	)
+
+	var (
+		state    = map[string]int{}
+		problems = []string{}
+		visit    func(name string, path []string)
+	)
+
+	visit = func(name string, path []string) {
+		path = append(path, name)
+		state[name] = visiting
+
+		refs := p.taskReferences(p.Tasks[name])
+		if p.Tasks[name].referencesItself() {
+			refs = append(refs, name)
+		}
+
+		for _, ref := range refs {
+			switch state[ref] {
+			case visiting:
+				for i, n := range path {
+					if n == ref {
+						cycle := append(append([]string{}, path[i:]...), ref)
+						problems = append(problems, fmt.Sprintf("task '%s': reference cycle detected: %s", ref, strings.Join(cycle, " -> ")))
+					}
+				}
+			case 0:
+				visit(ref, path)
+			}
+		}
+
+		state[name] = done
+	}
+
+	for _, name := range p.taskNames() {
+		if state[name] == 0 {
+			visit(name, nil)
+		}
+	}
+
+	return problems
+}
+
+// Whether the task runs itself, which taskReferences leaves out.
+func (t Task) referencesItself() bool {
+	cmds := append(append(append([]string{}, t.Deps...), t.OnSuccess...), t.OnFailure...)
+	for _, entry := range t.Run {
+		cmds = append(cmds, entry.Cmd)
+	}
+
+	for _, cmd := range cmds {
+		if cmd, _ = trimIgnorePrefix(cmd); cmd == t.Name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// The names of the tasks, without global, sorted.
+func (p *Parser) taskNames() []string {
+	names := []string{}
+	for _, name := range sortedKeys(p.Tasks) {
+		if name != "global" {
+			names = append(names, name)
+		}
+	}
+
+	return names
+}
+
+// The name closest to the misspelled one, if it's close enough, see
+// maxSuggestionDistance.
+func closestName(misspelled string, names []string) string {
+	closest, distance := "", maxSuggestionDistance+1
+	for _, name := range names {
+		if d := editDistance(misspelled, name); d < distance && d < len(misspelled) {
+			closest, distance = name, d
+		}
+	}
+
+	return closest
+}
+
+// The Levenshtein distance between the strings: how many characters have
+// to be inserted, deleted or replaced to turn one into the other.
+func editDistance(a string, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			cur[j] = cur[j-1] + 1
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
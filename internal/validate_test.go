@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const invalidConfig = `
+global:
+  shel: true
+  fmt:
+    sort_task: true
+  events:
+    before_each_task: [lnit]
+
+defaults: &defaults
+  retires: 2
+  run: ["go vet ./..."]
+
+lint:
+  <<: *defaults
+
+build:
+  deps: [lint]
+  fiels: ["*.go"]
+  files: ["src/*.go"]
+  events:
+    befor_each_run: ["echo build"]
+  run:
+    - cmd: "go build"
+      dri: cmd
+    - tets
+  on_success: [release]
+
+test:
+  run:
+    - build
+
+release:
+  run: [test, "goke:sleep 1s"]
+`
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	env := newGeneratorEnv(invalidConfig)
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	require.Equal(t, []string{
+		"goke.yml:3: global: unknown key 'shel', did you mean 'shell'?",
+		"goke.yml:5: global: unknown key 'fmt.sort_task', did you mean 'sort_tasks'?",
+		"goke.yml:10: task 'defaults': unknown key 'retires', did you mean 'retries'?",
+		"goke.yml:10: task 'lint': unknown key 'retires', did you mean 'retries'?",
+		"goke.yml:18: task 'build': unknown key 'fiels', did you mean 'files'?",
+		"goke.yml:21: task 'build': unknown key 'events.befor_each_run', did you mean 'before_each_run'?",
+		"goke.yml:24: task 'build': unknown key 'run.dri', did you mean 'dir'?",
+		`task 'build': files pattern "src/*.go" matches no files`,
+		"global: events: 'lnit' is neither a task nor a binary in PATH, did you mean 'lint'?",
+		"task 'build': run: 'tets' is neither a task nor a binary in PATH, did you mean 'test'?",
+		"task 'build': reference cycle detected: build -> release -> test -> build",
+	}, p.Validate())
+}
+
+func TestValidateWithoutProblems(t *testing.T) {
+	t.Parallel()
+
+	env := newGeneratorEnv(`
+global:
+  shell: true
+  fmt:
+    sort_tasks: true
+
+build:
+  run:
+    - "go build"
+    - export:
+        GOOS: linux
+    - set
+
+ci:
+  run: [build]
+  on_failure: ["-build"]
+`)
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	require.Empty(t, p.Validate())
+}
+
+func TestValidateIncludedFiles(t *testing.T) {
+	t.Parallel()
+
+	env := newGeneratorEnv(includesConfig)
+	writeIncludes(t, env)
+	require.Nil(t, env.FS.WriteFile("/work/tasks/db/tasks.yml", []byte("db:\n  file: [schema.sql]\n  run: [wbb]\n"), 0644))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	require.Equal(t, []string{
+		"tasks/db/tasks.yml:2: task 'db': unknown key 'file', did you mean 'files'?",
+		"task 'db': run: 'wbb' is neither a task nor a binary in PATH, did you mean 'web'?",
+	}, p.Validate())
+}
+
+func TestUnknownConfigKeysOfInvalidConfig(t *testing.T) {
+	t.Parallel()
+
+	require.Nil(t, UnknownConfigKeys("goke.yml", "build: [\n"))
+	require.Equal(t, []string{
+		"goke.yml:4: includes: unknown key 'namespce', did you mean 'namespace'?",
+	}, UnknownConfigKeys("goke.yml", "includes:\n  - tasks/web.yml\n  - path: tasks/api.yml\n    namespce: api\nvars:\n  anything: goes\n"))
+}
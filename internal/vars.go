@@ -0,0 +1,118 @@
+package internal
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+func init() {
+	RegisterCapability("config.vars")
+}
+
+// The top-level key holding the variables, which is not a task.
+const varsKey = "vars"
+
+// The top-level vars of the config, see parseVars.
+type configVars struct {
+	Vars map[string]string `yaml:"vars,omitempty"`
+}
+
+var (
+	undefinedVarRegexp = regexp.MustCompile(`map has no entry for key "([^"]+)"`)
+	templateErrRegexp  = regexp.MustCompile(`^template: :[0-9]+(:[0-9]+)?: (executing "" at <[^>]*>: )?`)
+)
+
+// Parses the top-level vars, running their $(...) commands, so that vars
+// can be computed, ie. VERSION: $(git describe --tags).
+func (p *Parser) parseVars() error {
+	var c configVars
+	if err := decodeConfig(p.configFile(), p.config, &c); err != nil {
+		return err
+	}
+
+	vars, err := p.resolveEnvVariables(c.Vars, nil)
+	if err != nil {
+		return err
+	}
+
+	p.vars = vars
+	return nil
+}
+
+// The vars of the task, which override the top-level ones.
+func (p *Parser) taskVars(task Task) (map[string]string, error) {
+	if len(task.Vars) == 0 {
+		return p.vars, nil
+	}
+
+	own, err := p.resolveEnvVariables(task.Vars, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	vars := make(map[string]string, len(p.vars)+len(own))
+	for k, v := range p.vars {
+		vars[k] = v
+	}
+
+	for k, v := range own {
+		vars[k] = v
+	}
+
+	return vars, nil
+}
+
+// Replaces the {{.NAME}} templates of the vars in str. Configs without vars
+// are left alone, since their commands may use the same syntax for other
+// tools, ie. docker ps --format '{{.Names}}'. An undefined var is an error
+// naming where it was used, which is a task or "global".
+func (p *Parser) expandVars(where string, vars map[string]string, str *string) error {
+	if len(vars) == 0 || !strings.Contains(*str, "{{") {
+		return nil
+	}
+
+	t, err := template.New("").Option("missingkey=error").Parse(*str)
+	if err != nil {
+		return p.varsError(where, *str, err)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, vars); err != nil {
+		return p.varsError(where, *str, err)
+	}
+
+	*str = b.String()
+	return nil
+}
+
+// Describes the template error with the line of the config it's on.
+func (p *Parser) varsError(where string, str string, err error) error {
+	msg := templateErrRegexp.ReplaceAllString(err.Error(), "")
+	needle := str
+	if m := undefinedVarRegexp.FindStringSubmatch(msg); m != nil {
+		msg = fmt.Sprintf("undefined variable %s", m[1])
+		needle = "." + m[1]
+	}
+
+	label := "global"
+	if where != "global" {
+		label = fmt.Sprintf("task '%s'", where)
+	}
+
+	return fmt.Errorf("%s:%d: %s: %s in \"%s\"", p.configFile(), p.lineAfter(where, needle), label, msg, str)
+}
+
+// The line of the first occurrence of needle after the top-level key, or
+// the line of the key when it doesn't occur verbatim.
+func (p *Parser) lineAfter(key string, needle string) int {
+	start := keyLine(p.config, key)
+	for i, line := range strings.Split(p.config, "\n") {
+		if i+1 >= start && strings.Contains(line, needle) {
+			return i + 1
+		}
+	}
+
+	return start
+}
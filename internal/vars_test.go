@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestVarsAreExpanded(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+vars:
+  BINARY: app
+  IMAGE: registry/app:1.2
+  SRC: cmd
+
+global:
+  environment:
+    VARS_TEST_OUT: "bin/{{.BINARY}}"
+
+build:
+  files: ["{{.SRC}}/*.go"]
+  env:
+    VARS_TEST_IMAGE: "{{.IMAGE}}"
+  run:
+    - "go build -o {{.BINARY}} ./{{.SRC}}"
+
+image:
+  vars:
+    BINARY: app-linux
+  run:
+    - "docker build -t {{.IMAGE}} --build-arg BIN={{.BINARY}} ."
+`)
+	require.Nil(t, env.FS.WriteFile("cmd/main.go", []byte("package main"), 0644))
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+
+	require.NotContains(t, p.Tasks, "vars")
+	require.Equal(t, []string{"cmd/main.go"}, p.Tasks["build"].Files)
+	require.Equal(t, "go build -o app ./cmd", p.Tasks["build"].Run[0].Cmd)
+	require.Equal(t, "registry/app:1.2", p.Tasks["build"].Env["VARS_TEST_IMAGE"])
+	require.Equal(t, "bin/app", p.Global.Shared.Environment["VARS_TEST_OUT"])
+
+	// Task vars override the top-level ones for that task only.
+	require.Equal(t, "docker build -t registry/app:1.2 --build-arg BIN=app-linux .", p.Tasks["image"].Run[0].Cmd)
+}
+
+func TestComputedVars(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+vars:
+  VERSION: $(echo 1.2.3)
+
+release:
+  run:
+    - "echo {{.VERSION}}"
+`)
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, "echo 1.2.3", p.Tasks["release"].Run[0].Cmd)
+}
+
+func TestUndefinedVarsFailParsing(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`vars:
+  BINARY: app
+
+build:
+  run:
+    - "go vet"
+    - "go build -o {{.BINARY}} {{.PKG}}"
+`)
+
+	_, err := env.Parse()
+	require.ErrorContains(t, err, `:7: task 'build': undefined variable PKG in "go build -o {{.BINARY}} {{.PKG}}"`)
+
+	env = NewInMemoryEnv(`vars:
+  BINARY: app
+
+build:
+  run:
+    - "go build -o {{.BINARY"
+`)
+
+	_, err = env.Parse()
+	require.ErrorContains(t, err, `:6: task 'build': unclosed action in "go build -o {{.BINARY"`)
+}
+
+func TestConfigsWithoutVarsKeepTheirBraces(t *testing.T) {
+	t.Parallel()
+
+	env := NewInMemoryEnv(`
+names:
+  run:
+    - "docker ps --format '{{.Names}}'"
+`)
+
+	p, err := env.Parse()
+	require.Nil(t, err)
+	require.Equal(t, "docker ps --format '{{.Names}}'", p.Tasks["names"].Run[0].Cmd)
+}
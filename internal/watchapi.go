@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"context"
+	"time"
+)
+
+func init() {
+	RegisterCapability("watch.api")
+}
+
+// What happened in a watch session, see WatchEvent.
+type WatchEventKind int
+
+const (
+	// The files of the task changed, and a run follows once the one in
+	// progress, if any, finished.
+	WatchChangeDetected WatchEventKind = iota + 1
+
+	// A run of the task started.
+	WatchRunStarted
+
+	// A run of the task finished, see WatchEvent.Dispatched and Err.
+	WatchRunFinished
+)
+
+func (k WatchEventKind) String() string {
+	switch k {
+	case WatchChangeDetected:
+		return "change detected"
+	case WatchRunStarted:
+		return "run started"
+	case WatchRunFinished:
+		return "run finished"
+	}
+
+	return "unknown"
+}
+
+// An event of a watch session, see Executor.Watch.
+type WatchEvent struct {
+	Kind WatchEventKind
+
+	// The watched task, or the task which ran on its schedule.
+	Task string
+
+	// What started the run: "initial", "change", "remote" or "schedule".
+	// Empty for WatchChangeDetected.
+	Trigger string
+
+	// Set for WatchRunFinished: whether the task was dispatched, which it
+	// isn't when its files didn't change since the last session or its
+	// condition doesn't hold, the error which failed the run and how long
+	// it took.
+	Dispatched bool
+	Err        error
+	Duration   time.Duration
+}
+
+// Watches the task like --watch does, in the background. The events of the
+// session are sent on the returned channel, which is closed once the
+// session stopped and its last run finished. Cancelling the context stops
+// the session, like SIGTERM stops the goke command, and the events which
+// aren't received by then are dropped. Unlike the goke command, signals
+// aren't handled. Errors setting up the session, ie. an unknown task, are
+// returned right away.
+func (e *Executor) Watch(ctx context.Context, taskName string) (<-chan WatchEvent, error) {
+	e.ctx = ctx
+	if e.interrupt == nil {
+		e.interrupt = newRunInterrupt()
+	}
+
+	events := make(chan WatchEvent)
+	w, err := e.newWatch(taskName, events)
+	if err != nil {
+		return nil, err
+	}
+
+	go func() {
+		defer close(events)
+		defer w.close()
+
+		w.loop.loop()
+		e.stopWatchSpinner()
+	}()
+
+	return events, nil
+}
+
+// Sends the events to the channel until the context of the executor is
+// cancelled. Without a channel, there is nothing to report.
+func (e *Executor) emitWatchEvent(events chan<- WatchEvent) func(WatchEvent) {
+	if events == nil {
+		return nil
+	}
+
+	return func(event WatchEvent) {
+		select {
+		case events <- event:
+		case <-e.context().Done():
+		}
+	}
+}
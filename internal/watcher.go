@@ -0,0 +1,359 @@
+package internal
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func init() {
+	RegisterCapability("watch.fsnotify", "watch.debounce")
+}
+
+// Default time window in which file events are coalesced into a single run.
+const DefaultDebounce = 200 * time.Millisecond
+
+// Watches the directories containing the given files and reports debounced
+// changes, so that a "save all" in an editor results in a single run.
+// New files matching the patterns, and deleted files, are changes too.
+type fileWatcher struct {
+	watcher  *fsnotify.Watcher
+	files    map[string]bool
+	patterns []string
+	debounce time.Duration
+	changes  chan struct{}
+}
+
+// Subscribes to the directories of the files and of the patterns. Directories
+// are watched instead of the files themselves, so that editors which save by
+// replacing the file (write to a temp file, then rename) keep being noticed.
+func newFileWatcher(files []string, patterns []string, debounce time.Duration) (*fileWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	w := &fileWatcher{
+		watcher:  watcher,
+		files:    make(map[string]bool, len(files)),
+		patterns: patterns,
+		debounce: debounce,
+		changes:  make(chan struct{}, 1),
+	}
+
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		w.files[filepath.Clean(f)] = true
+		dirs[filepath.Dir(f)] = true
+	}
+
+	// Directories which are patterns themselves, ie. "src/*/main.go",
+	// are only watched through the files matching them. The whole tree
+	// below the base of "**" patterns is watched, except for excluded
+	// directories.
+	for _, pattern := range patterns {
+		if isExclusion(pattern) {
+			continue
+		}
+
+		if strings.Contains(pattern, "**") {
+			for _, dir := range w.subdirs(globBase(pattern)) {
+				dirs[dir] = true
+			}
+		} else if dir := filepath.Dir(pattern); !hasGlobMeta(dir) && isDir(dir) {
+			dirs[dir] = true
+		}
+	}
+
+	for _, dir := range sortedKeys(dirs) {
+		if err := watcher.Add(dir); err != nil {
+			watcher.Close()
+			return nil, err
+		}
+	}
+
+	go w.loop()
+
+	return w, nil
+}
+
+// Receives a value once the files changed and the debounce window elapsed.
+// Changes happening while the previous one is handled are coalesced.
+func (w *fileWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Errors reported by the underlying watcher, ie. when the event queue overflows.
+func (w *fileWatcher) Errors() <-chan error {
+	return w.watcher.Errors
+}
+
+func (w *fileWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+func (w *fileWatcher) loop() {
+	var timer *time.Timer
+	var fire <-chan time.Time
+
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+
+			if event.Has(fsnotify.Create) {
+				w.watchCreatedDir(filepath.Clean(event.Name))
+			}
+
+			if !w.isRelevant(event) {
+				continue
+			}
+
+			if timer != nil {
+				timer.Stop()
+			}
+
+			timer = time.NewTimer(w.debounce)
+			fire = timer.C
+		case <-fire:
+			timer, fire = nil, nil
+
+			select {
+			case w.changes <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+func (w *fileWatcher) isRelevant(event fsnotify.Event) bool {
+	if !event.Has(fsnotify.Write) && !event.Has(fsnotify.Create) && !event.Has(fsnotify.Rename) && !event.Has(fsnotify.Remove) {
+		return false
+	}
+
+	name := filepath.Clean(event.Name)
+	return w.files[name] || matchesPatterns(w.patterns, name)
+}
+
+// Starts watching a directory created below the base of a "**" pattern,
+// so that files created in it are noticed too.
+func (w *fileWatcher) watchCreatedDir(dir string) {
+	if !isDir(dir) {
+		return
+	}
+
+	for _, pattern := range w.patterns {
+		if isExclusion(pattern) || !strings.Contains(pattern, "**") {
+			continue
+		}
+
+		if base := globBase(pattern); base == "." || strings.HasPrefix(dir, base+string(filepath.Separator)) {
+			for _, subdir := range w.subdirs(dir) {
+				w.watcher.Add(subdir)
+			}
+			return
+		}
+	}
+}
+
+// Returns the directory and the ones below it, except for the excluded ones
+// and .goke directories, like globRecursive.
+func (w *fileWatcher) subdirs(root string) []string {
+	dirs := []string{}
+
+	filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+
+		if p != root && (w.isExcluded(p) || d.Name() == stateDirName) {
+			return filepath.SkipDir
+		}
+
+		dirs = append(dirs, p)
+		return nil
+	})
+
+	return dirs
+}
+
+func (w *fileWatcher) isExcluded(name string) bool {
+	for _, pattern := range w.patterns {
+		if isExclusion(pattern) && globMatch(strings.TrimPrefix(pattern, "!"), name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+func isDir(path string) bool {
+	info, err := os.Stat(path)
+	return err == nil && info.IsDir()
+}
+
+// Drives a watch session: runs the task once, then again on every change
+// or trigger, until interrupted. Runs happen in the background, so that
+// changes and signals keep being received meanwhile.
+type watchLoop struct {
+	changes   <-chan struct{}
+	interrupt <-chan os.Signal
+	status    *watchStatus
+
+	// Receives a signal once the terminal closed. The session then stops
+	// like when interrupted, unless detach is set. Both are optional.
+	hangup <-chan os.Signal
+	detach func()
+
+	// Names of the tasks whose schedule is due, see scheduleTicks. Optional.
+	ticks <-chan string
+
+	// Reports the changes and the runs of the session, see WatchEvent.
+	// Optional.
+	emit func(WatchEvent)
+
+	// Runs the task and reports whether it got dispatched. The initial
+	// run is skipped when the files did not change since the last session.
+	run func(initial bool) (bool, error)
+
+	// Runs a task on its schedule. Required when there are ticks.
+	runScheduled func(task string) (bool, error)
+
+	// Prepares the next run, it is optional.
+	reset func()
+
+	// Asks the run in progress to stop early. It must still finish.
+	stop func()
+
+	// Stops the run in progress on a first Ctrl-C, the session then keeps
+	// watching until another one. Without it, Ctrl-C ends the session.
+	interruptRun func(sig os.Signal)
+}
+
+// Returns the signal which stopped the session. A first Ctrl-C during a run
+// only stops that run when interruptRun is set, see watchLoop.
+func (l *watchLoop) loop() os.Signal {
+	done := l.start(l.status.task, triggerInitial, func() (bool, error) { return l.run(true) })
+	rerun := func() (bool, error) { return l.run(false) }
+	interrupted := false
+
+	for {
+		select {
+		case <-l.changes:
+			l.emitEvent(WatchEvent{Kind: WatchChangeDetected, Task: l.status.task})
+			l.wait(done)
+			done, interrupted = l.start(l.status.task, triggerChange, rerun), false
+		case <-l.status.trigger:
+			l.wait(done)
+			done, interrupted = l.start(l.status.task, triggerRemote, rerun), false
+		case task := <-l.ticks:
+			// Scheduled runs never interrupt a run in progress, the tick is
+			// skipped instead. A run which just finished doesn't count.
+			if finished(done) {
+				done, interrupted = nil, false
+			}
+
+			if done != nil {
+				l.status.skipTick()
+				continue
+			}
+
+			done = l.start(task, triggerSchedule, func() (bool, error) { return l.runScheduled(task) })
+		case <-done:
+			done, interrupted = nil, false
+		case sig := <-l.interrupt:
+			if finished(done) {
+				done, interrupted = nil, false
+			}
+
+			if l.interruptRun != nil && done != nil && sig == os.Interrupt && !interrupted {
+				interrupted = true
+				l.interruptRun(sig)
+				continue
+			}
+
+			if interrupted {
+				l.interruptRun(sig)
+			}
+
+			l.wait(done)
+			return sig
+		case sig := <-l.hangup:
+			if l.detach != nil {
+				l.detach()
+				continue
+			}
+
+			l.wait(done)
+			return sig
+		}
+	}
+}
+
+func (l *watchLoop) start(task string, trigger string, run func() (bool, error)) chan struct{} {
+	done := make(chan struct{})
+	l.status.beginIteration(task, trigger)
+
+	if l.reset != nil {
+		l.reset()
+	}
+
+	l.emitEvent(WatchEvent{Kind: WatchRunStarted, Task: task, Trigger: trigger})
+	started := time.Now()
+
+	go func() {
+		defer close(done)
+
+		dispatched, err := run()
+		l.status.endIteration(dispatched, err)
+		l.emitEvent(WatchEvent{
+			Kind:       WatchRunFinished,
+			Task:       task,
+			Trigger:    trigger,
+			Dispatched: dispatched,
+			Err:        err,
+			Duration:   time.Since(started),
+		})
+	}()
+
+	return done
+}
+
+func (l *watchLoop) emitEvent(event WatchEvent) {
+	if l.emit != nil {
+		l.emit(event)
+	}
+}
+
+// Whether the run finished, even when the loop didn't notice yet.
+func finished(done chan struct{}) bool {
+	select {
+	case <-done:
+		return true
+	default:
+		return false
+	}
+}
+
+// Waits for the run in progress, if any.
+func (l *watchLoop) wait(done chan struct{}) {
+	if done == nil {
+		return
+	}
+
+	l.stop()
+	<-done
+}
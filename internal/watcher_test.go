@@ -0,0 +1,117 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+const testDebounce = 50 * time.Millisecond
+
+func newTestWatcher(t *testing.T, files []string, patterns ...string) *fileWatcher {
+	w, err := newFileWatcher(files, patterns, testDebounce)
+	require.Nil(t, err)
+	t.Cleanup(func() { w.Close() })
+
+	return w
+}
+
+func requireChanges(t *testing.T, w *fileWatcher, expected int) {
+	received := 0
+	timeout := time.After(10 * testDebounce)
+
+	for {
+		select {
+		case <-w.Changes():
+			received++
+		case <-timeout:
+			require.Equal(t, expected, received)
+			return
+		}
+	}
+}
+
+func TestFileWatcherDebouncesEvents(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.go")
+	b := filepath.Join(dir, "b.go")
+	require.Nil(t, os.WriteFile(a, []byte("a"), 0644))
+	require.Nil(t, os.WriteFile(b, []byte("b"), 0644))
+
+	w := newTestWatcher(t, []string{a, b})
+
+	for i := 0; i < 5; i++ {
+		require.Nil(t, os.WriteFile(a, []byte("aa"), 0644))
+		require.Nil(t, os.WriteFile(b, []byte("bb"), 0644))
+	}
+
+	requireChanges(t, w, 1)
+}
+
+func TestFileWatcherIgnoresUnrelatedFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.go")
+	require.Nil(t, os.WriteFile(a, []byte("a"), 0644))
+
+	w := newTestWatcher(t, []string{a})
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "out.bin"), []byte("x"), 0644))
+
+	requireChanges(t, w, 0)
+}
+
+func TestFileWatcherNoticesReplacedFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.go")
+	tmp := filepath.Join(dir, ".a.go.swp")
+	require.Nil(t, os.WriteFile(a, []byte("a"), 0644))
+
+	w := newTestWatcher(t, []string{a})
+	require.Nil(t, os.WriteFile(tmp, []byte("aa"), 0644))
+	require.Nil(t, os.Rename(tmp, a))
+
+	requireChanges(t, w, 1)
+}
+
+func TestFileWatcherNoticesNewAndDeletedFiles(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.go")
+	require.Nil(t, os.WriteFile(a, []byte("a"), 0644))
+
+	w := newTestWatcher(t, []string{a}, filepath.Join(dir, "*.go"))
+
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "b.go"), []byte("b"), 0644))
+	requireChanges(t, w, 1)
+
+	require.Nil(t, os.Remove(a))
+	requireChanges(t, w, 1)
+
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("x"), 0644))
+	requireChanges(t, w, 0)
+}
+
+func TestFileWatcherWatchesRecursivePatterns(t *testing.T) {
+	dir := t.TempDir()
+	nested := filepath.Join(dir, "internal", "cli")
+	vendor := filepath.Join(dir, "vendor", "lib")
+	require.Nil(t, os.MkdirAll(nested, 0755))
+	require.Nil(t, os.MkdirAll(vendor, 0755))
+
+	w := newTestWatcher(t, nil, filepath.Join(dir, "**", "*.go"), "!"+filepath.Join(dir, "vendor", "**"))
+
+	require.Nil(t, os.WriteFile(filepath.Join(nested, "options.go"), []byte("a"), 0644))
+	requireChanges(t, w, 1)
+
+	require.Nil(t, os.WriteFile(filepath.Join(vendor, "lib.go"), []byte("b"), 0644))
+	requireChanges(t, w, 0)
+
+	// Directories created later are watched too.
+	created := filepath.Join(dir, "pkg")
+	require.Nil(t, os.Mkdir(created, 0755))
+	requireChanges(t, w, 0)
+
+	require.Nil(t, os.WriteFile(filepath.Join(created, "new.go"), []byte("c"), 0644))
+	requireChanges(t, w, 1)
+}
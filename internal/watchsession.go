@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func init() {
+	RegisterCapability("watch.sessions", "command.doctor")
+}
+
+// A running "goke --watch", registered in the state directory so that other
+// sessions of the same project notice it, see checkWatchSessions.
+type WatchSession struct {
+	PID       int       `json:"pid"`
+	Project   string    `json:"project"`
+	Tasks     []string  `json:"tasks"`
+	FileCount int       `json:"file_count"`
+	Started   time.Time `json:"started"`
+
+	// The watched files, to detect overlaps.
+	Files []string `json:"files,omitempty"`
+}
+
+func (s WatchSession) String() string {
+	return fmt.Sprintf(
+		"pid %d watching %s in %s (%d files), since %s",
+		s.PID, strings.Join(s.Tasks, ", "), s.Project, s.FileCount, s.Started.Format(time.RFC3339),
+	)
+}
+
+// Whether the sessions watch the same tasks or files of the same project.
+func (s WatchSession) overlaps(other WatchSession) bool {
+	if s.Project != other.Project {
+		return false
+	}
+
+	for _, task := range s.Tasks {
+		for _, otherTask := range other.Tasks {
+			if task == otherTask {
+				return true
+			}
+		}
+	}
+
+	files := make(map[string]bool, len(s.Files))
+	for _, f := range s.Files {
+		files[f] = true
+	}
+
+	for _, f := range other.Files {
+		if files[f] {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Stubbed in tests, so that sessions aren't registered in the state directory.
+var watchSessionsDir = func() (string, error) {
+	dir, err := StateDir()
+	if err != nil {
+		return "", err
+	}
+
+	dir = filepath.Join(dir, "watch-sessions")
+	return dir, os.MkdirAll(dir, 0755)
+}
+
+// Stubbed in tests, to simulate live and dead sessions.
+var processAlive = isProcessAlive
+
+// Returns the registered watch sessions whose process is still alive, in
+// the order they started. The registrations of dead processes, ie. of
+// sessions which crashed, are removed.
+func ActiveWatchSessions() ([]WatchSession, error) {
+	dir, err := watchSessionsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	sessions := []WatchSession{}
+	for _, entry := range entries {
+		p := filepath.Join(dir, entry.Name())
+		if filepath.Ext(p) != ".json" {
+			continue
+		}
+
+		data, err := os.ReadFile(p)
+		if err != nil {
+			continue
+		}
+
+		var s WatchSession
+		if err := json.Unmarshal(data, &s); err != nil || !processAlive(s.PID) {
+			_ = os.Remove(p)
+			continue
+		}
+
+		sessions = append(sessions, s)
+	}
+
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].Started.Before(sessions[j].Started) })
+	return sessions, nil
+}
+
+// Registers the session until the returned function is called. Another
+// session overlapping with it is an error, unless allowMultiple is set, in
+// which case it's only printed as a warning unless quiet. Failing to access
+// the registry never prevents watching.
+func registerWatchSession(s WatchSession, allowMultiple bool, quiet bool) (func(), error) {
+	sessions, err := ActiveWatchSessions()
+	if err != nil {
+		return func() {}, nil
+	}
+
+	for _, other := range sessions {
+		if other.PID == s.PID || !s.overlaps(other) {
+			continue
+		}
+
+		if !quiet {
+			fmt.Fprintf(os.Stderr, "Warning: another watch session overlaps with this one: %s\n", other)
+		}
+
+		if !allowMultiple {
+			return nil, fmt.Errorf("another goke --watch (pid %d) already watches the same tasks or files, stop it or pass --allow-multiple-watch", other.PID)
+		}
+	}
+
+	dir, err := watchSessionsDir()
+	if err != nil {
+		return func() {}, nil
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return func() {}, nil
+	}
+
+	p := filepath.Join(dir, strconv.Itoa(s.PID)+".json")
+	if err := os.WriteFile(p, data, 0644); err != nil {
+		return func() {}, nil
+	}
+
+	return func() { _ = os.Remove(p) }, nil
+}
+
+// The session of watching the task with the files.
+func (e *Executor) watchSession(task Task, files []string) WatchSession {
+	cwd, _ := e.parser.fs.Getwd()
+
+	abs := make([]string, len(files))
+	for i, f := range files {
+		abs[i] = joinDir(cwd, f)
+	}
+
+	return WatchSession{
+		PID:       os.Getpid(),
+		Project:   cwd,
+		Tasks:     []string{task.Name},
+		FileCount: len(files),
+		Started:   time.Now(),
+		Files:     abs,
+	}
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// Registers the sessions in a temp dir, the ones in alive being alive.
+func stubWatchSessions(t *testing.T, alive map[int]bool, sessions ...WatchSession) string {
+	dir := t.TempDir()
+	origDir, origAlive := watchSessionsDir, processAlive
+	watchSessionsDir = func() (string, error) { return dir, nil }
+	processAlive = func(pid int) bool { return alive[pid] }
+	t.Cleanup(func() { watchSessionsDir, processAlive = origDir, origAlive })
+
+	for _, s := range sessions {
+		data, err := json.Marshal(s)
+		require.Nil(t, err)
+		require.Nil(t, os.WriteFile(filepath.Join(dir, strconv.Itoa(s.PID)+".json"), data, 0644))
+	}
+
+	return dir
+}
+
+func TestActiveWatchSessionsRemovesStaleOnes(t *testing.T) {
+	started := time.Date(2022, 10, 1, 12, 0, 0, 0, time.UTC)
+	dir := stubWatchSessions(t, map[int]bool{222: true},
+		WatchSession{PID: 111, Project: "/work", Tasks: []string{"dev"}, Started: started},
+		WatchSession{PID: 222, Project: "/work", Tasks: []string{"test"}, FileCount: 3, Started: started},
+	)
+
+	sessions, err := ActiveWatchSessions()
+	require.Nil(t, err)
+	require.Len(t, sessions, 1)
+	require.Equal(t, "pid 222 watching test in /work (3 files), since 2022-10-01T12:00:00Z", sessions[0].String())
+
+	require.NoFileExists(t, filepath.Join(dir, "111.json"))
+	require.FileExists(t, filepath.Join(dir, "222.json"))
+}
+
+func TestOverlappingWatchSessionsRequireAllowMultiple(t *testing.T) {
+	dir := stubWatchSessions(t, map[int]bool{222: true, 333: true},
+		WatchSession{PID: 111, Project: "/work", Tasks: []string{"dev"}},
+		WatchSession{PID: 222, Project: "/work", Tasks: []string{"test"}, Files: []string{"/work/main.go"}},
+	)
+
+	// Stale sessions are ignored.
+	unregister, err := registerWatchSession(WatchSession{PID: 333, Project: "/work", Tasks: []string{"dev"}}, false, true)
+	require.Nil(t, err)
+	require.FileExists(t, filepath.Join(dir, "333.json"))
+	unregister()
+	require.NoFileExists(t, filepath.Join(dir, "333.json"))
+
+	// Sessions of other projects don't overlap.
+	unregister, err = registerWatchSession(WatchSession{PID: 333, Project: "/other", Tasks: []string{"test"}}, false, true)
+	require.Nil(t, err)
+	unregister()
+
+	overlapping := WatchSession{PID: 333, Project: "/work", Tasks: []string{"dev"}, Files: []string{"/work/main.go"}}
+	_, err = registerWatchSession(overlapping, false, true)
+	require.EqualError(t, err, "another goke --watch (pid 222) already watches the same tasks or files, stop it or pass --allow-multiple-watch")
+	require.NoFileExists(t, filepath.Join(dir, "333.json"))
+
+	unregister, err = registerWatchSession(overlapping, true, true)
+	require.Nil(t, err)
+	require.FileExists(t, filepath.Join(dir, "333.json"))
+	unregister()
+}
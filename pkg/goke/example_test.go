@@ -0,0 +1,41 @@
+package goke_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+
+	"github.com/dugajean/goke/pkg/goke"
+)
+
+func ExampleProject_Watch() {
+	project, err := goke.LoadConfig("goke.yml")
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	// The session stops on Ctrl-C, which closes the channel.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	events, err := project.Watch(ctx, []string{"build"}, goke.WatchOptions{Quiet: true})
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	for event := range events {
+		switch event.Kind {
+		case goke.WatchChangeDetected:
+			fmt.Println("files of", event.Task, "changed")
+		case goke.WatchRunFinished:
+			if event.Err != nil {
+				fmt.Printf("%s failed after %s: %s\n", event.Task, event.Duration, event.Err)
+			} else if event.Dispatched {
+				fmt.Printf("%s ran in %s\n", event.Task, event.Duration)
+			}
+		}
+	}
+}
@@ -0,0 +1,592 @@
+// Package goke runs the tasks of goke.yml files from Go programs, the same
+// way the goke command does, which is built on top of it:
+//
+//	project, err := goke.LoadConfig("services/api/goke.yml")
+//	if err != nil {
+//		return err
+//	}
+//
+//	err = project.Run(ctx, "test", goke.RunOptions{Quiet: true})
+//
+// Tasks only run when their files changed since their last run, which is
+// recorded in the same lockfile the goke command uses.
+package goke
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/dugajean/goke/internal"
+)
+
+type (
+	// TaskNotFoundError is returned when a task to run is not declared.
+	TaskNotFoundError = internal.TaskNotFoundError
+
+	// CommandError is returned when a command of a task fails.
+	CommandError = internal.CommandError
+
+	// InterruptedError is returned when Ctrl-C or SIGTERM stopped the run.
+	InterruptedError = internal.InterruptedError
+
+	// OutputsError is returned when the outputs of the tasks form a cycle,
+	// or overlap with LoadOptions.Strict.
+	OutputsError = internal.OutputsError
+
+	// EnvConflict is a variable of a task defined by more than one source,
+	// see internal.EnvPrecedence for which one wins.
+	EnvConflict = internal.EnvConflict
+
+	// PruneCandidate is a task nothing runs anymore, see
+	// Project.PruneCandidates.
+	PruneCandidate = internal.PruneCandidate
+
+	// WatchEvent is a change or a run of a watch session, see Project.Watch.
+	WatchEvent = internal.WatchEvent
+
+	// WatchEventKind tells the events of a watch session apart.
+	WatchEventKind = internal.WatchEventKind
+
+	// CapabilityReport lists the versions and the features of this build,
+	// see Capabilities.
+	CapabilityReport = internal.Capabilities
+)
+
+// The kinds of the events of a watch session, see WatchEvent.
+const (
+	WatchChangeDetected = internal.WatchChangeDetected
+	WatchRunStarted     = internal.WatchRunStarted
+	WatchRunFinished    = internal.WatchRunFinished
+)
+
+// LoadOptions are the settings for loading a config, see Load.
+type LoadOptions struct {
+	// Neither reads nor writes the cache of the config, like --no-cache.
+	NoCache bool
+
+	// Doesn't print the warnings about the config.
+	Quiet bool
+
+	// Fails when tasks declare overlapping outputs instead of warning,
+	// like --strict.
+	Strict bool
+
+	// Keeps the lockfile and the cache of the config in the directory,
+	// ie. the project's .goke directory like --state local, instead of the
+	// home and temp directories. Writes to it are guarded by a file lock.
+	StateDir string
+
+	// Skips the command of generate_tasks, like --no-generate, so the
+	// generated tasks are missing.
+	NoGenerate bool
+
+	// Parses every task even after one failed, see Validate.
+	collectErrors bool
+}
+
+// RunOptions are the settings of a run, the same as the flags of goke.
+type RunOptions struct {
+	// Runs the tasks regardless of whether their files changed, like --force.
+	Force bool
+
+	// Disables all output, like --quiet.
+	Quiet bool
+
+	// Prints additional details, like --verbose. VeryVerbose also reports
+	// the variables defined by more than one source, like -vv.
+	Verbose     bool
+	VeryVerbose bool
+
+	// Watches the files of the task and reruns it when they change,
+	// until the context is cancelled, like --watch. See Debounce,
+	// ServeStatus, AllowRemoteTrigger, AllowMultipleWatch and Hup.
+	Watch              bool
+	Debounce           time.Duration
+	ServeStatus        string
+	AllowRemoteTrigger bool
+	AllowMultipleWatch bool
+	Hup                string
+
+	// Lists the tasks instead of running them, like --list.
+	List bool
+
+	// Bounds the whole run, like --deadline. A run which doesn't finish in
+	// time fails with an error for which ExitCode returns 75.
+	Deadline time.Duration
+
+	// Runs the given tasks concurrently, at most this many at a time, like
+	// --jobs. Tasks of the same group still run one after the other.
+	Jobs int
+
+	// Only runs the tasks whose files changed since the git ref or within
+	// the duration, like --since, regardless of the lockfile.
+	Since string
+
+	// Asks whether to rerun the tasks after a failed run, and which task to
+	// run when none is given and the config has no main task, when stdin and
+	// stdout are terminals outside of CI. The opposite of --no-interactive.
+	Interactive bool
+
+	// Runs the steps of the given plan file, like --batch.
+	Batch string
+
+	// Checks that the binaries of the tasks exist before running them,
+	// like --preflight. Project.CheckTools only runs the checks.
+	Preflight bool
+
+	// Runs the tasks with the given tag instead of the given ones, like --tag.
+	Tag string
+
+	// Writes the output of every command to files of the directory,
+	// like --capture-dir.
+	CaptureDir string
+
+	// Prints the commands instead of running them, like --dry-run.
+	DryRun bool
+
+	// Rewrites the golden files of the commands instead of comparing them,
+	// like --update-golden.
+	UpdateGolden bool
+
+	// Replace the {ARGS} placeholder of the commands, like the arguments
+	// after "--" do.
+	ExtraArgs []string
+
+	// Override the params of the tasks, by task name, like "env=prod" does
+	// after a task name on the command line.
+	Params map[string]map[string]string
+
+	// Writes a single line summing up the run to the file once it's over,
+	// or to stdout with "-", like --summary-line. Quiet doesn't affect it.
+	SummaryLine string
+
+	// Runs only the commands of the tasks, without the events, on_success,
+	// on_failure and the variables of the config, like --bare.
+	Bare bool
+
+	// Discards the output of the commands which ran in less than 100ms
+	// without any, from their next run on, like --discard-quiet-commands.
+	DiscardQuiet bool
+
+	// The command line recorded in the metadata of the run, if any.
+	Args []string
+}
+
+func (o RunOptions) options() internal.Options {
+	return internal.Options{
+		Force:              o.Force,
+		Quiet:              o.Quiet,
+		Verbose:            o.Verbose || o.VeryVerbose,
+		VeryVerbose:        o.VeryVerbose,
+		Watch:              o.Watch,
+		Debounce:           o.Debounce,
+		ServeStatus:        o.ServeStatus,
+		AllowRemoteTrigger: o.AllowRemoteTrigger,
+		AllowMultipleWatch: o.AllowMultipleWatch,
+		Hup:                o.Hup,
+		List:               o.List,
+		NoInteractive:      !o.Interactive,
+		Deadline:           o.Deadline,
+		Jobs:               o.Jobs,
+		Since:              o.Since,
+		Batch:              o.Batch,
+		SummaryLine:        o.SummaryLine,
+		Bare:               o.Bare,
+		DiscardQuiet:       o.DiscardQuiet,
+		Preflight:          o.Preflight,
+		Tag:                o.Tag,
+		CaptureDir:         o.CaptureDir,
+		DryRun:             o.DryRun,
+		UpdateGolden:       o.UpdateGolden,
+		ExtraArgs:          o.ExtraArgs,
+		Params:             o.Params,
+	}
+}
+
+// WatchOptions are the settings of a watch session, the same as the flags
+// of "goke --watch".
+type WatchOptions struct {
+	// Runs the task at the start of the session regardless of whether its
+	// files changed since the last one, like --force.
+	Force bool
+
+	// Disables all output, like --quiet. Embedders usually want it, and
+	// report the events instead.
+	Quiet bool
+
+	// Prints additional details, like --verbose.
+	Verbose bool
+
+	// How long changes are coalesced into a single run, like --debounce.
+	Debounce time.Duration
+
+	// Serves the status of the session, and triggers runs when
+	// AllowRemoteTrigger is set, like --serve-status.
+	ServeStatus        string
+	AllowRemoteTrigger bool
+
+	// Watches even when another session watches the same task or files,
+	// like --allow-multiple-watch.
+	AllowMultipleWatch bool
+}
+
+func (o WatchOptions) options() internal.Options {
+	return internal.Options{
+		Force:              o.Force,
+		Quiet:              o.Quiet,
+		Verbose:            o.Verbose,
+		Watch:              true,
+		Debounce:           o.Debounce,
+		ServeStatus:        o.ServeStatus,
+		AllowRemoteTrigger: o.AllowRemoteTrigger,
+		AllowMultipleWatch: o.AllowMultipleWatch,
+		NoInteractive:      true,
+	}
+}
+
+// Task describes a task of a project.
+type Task struct {
+	Name string
+	Desc string
+	Deps []string
+	Tags []string
+
+	// The files the task is gated on, with their patterns expanded.
+	Files []string
+}
+
+// Project is a loaded goke.yml, whose tasks can be run.
+type Project struct {
+	config   string
+	parser   *internal.Parser
+	lockfile internal.Lockfile
+	history  internal.History
+}
+
+// Loads the goke.yml at path, see Load.
+func LoadConfig(path string) (*Project, error) {
+	return Load(path, LoadOptions{})
+}
+
+// Loads the goke.yml at path. The config of the working directory is
+// cached and merged with its local overrides, like the goke command does.
+// The configs of other directories are loaded like subprojects: their
+// tasks run in their directory and their files are tracked separately.
+func Load(path string, opts LoadOptions) (*Project, error) {
+	c, err := parseConfig(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	p := c.parser
+	project := &Project{config: c.config, parser: p}
+
+	c.options.StateBackend = p.Global.Shared.StateBackend
+	project.lockfile = internal.NewLockfile(p.TaskFiles(), &c.options, c.fs)
+	project.history = internal.NewHistory(&c.options, c.fs)
+
+	if c.subproject != "" {
+		project.lockfile = project.lockfile.ForProject(c.subproject, p.TaskFiles())
+		project.history = project.history.ForProject(c.subproject)
+	}
+
+	p.CheckClockSkew()
+	if err := project.lockfile.Bootstrap(); err != nil {
+		return nil, err
+	}
+
+	if err := project.history.Bootstrap(); err != nil {
+		return nil, err
+	}
+
+	return project, nil
+}
+
+// Parses the goke.yml at path like Load, and returns the tasks which can be
+// run by name, sorted by name. Unlike Load, it neither reads the state of
+// the project nor checks the clock of its filesystem, so that listing the
+// tasks of a cached config stays fast enough for shell completion.
+func ListTasks(path string, opts LoadOptions) ([]Task, error) {
+	c, err := parseConfig(path, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	project := &Project{config: c.config, parser: c.parser}
+	runnable := c.parser.RunnableTasks()
+
+	tasks := make([]Task, len(runnable))
+	for i, task := range runnable {
+		tasks[i], _ = project.Task(task.Name)
+	}
+
+	return tasks, nil
+}
+
+// Parses the goke.yml at path like Load, without the cache, and lists its
+// problems, like "goke validate" does: the keys goke doesn't know, the
+// commands which look like tasks but are neither a task nor a binary, the
+// files patterns matching no files, the tasks running themselves and the
+// other warnings. A config which doesn't parse is a problem too, with each
+// of its tasks which fail to parse and its unknown keys, so only failing to
+// read it is an error.
+func Validate(path string, opts LoadOptions) ([]string, error) {
+	cfg, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	opts.NoCache, opts.Quiet, opts.collectErrors = true, true, true
+	c, err := parseConfig(path, opts)
+	if err != nil {
+		problems := internal.UnknownConfigKeys(path, string(cfg))
+
+		var errs internal.ConfigErrors
+		if !errors.As(err, &errs) {
+			return append(problems, err.Error()), nil
+		}
+
+		for _, err := range errs {
+			problems = append(problems, err.Error())
+		}
+
+		return problems, nil
+	}
+
+	return c.parser.Validate(), nil
+}
+
+// A parsed config, with the options its lockfile and history are created
+// with.
+type parsedConfig struct {
+	config  string
+	parser  *internal.Parser
+	options internal.Options
+	fs      internal.FileSystem
+
+	// The directory of the config when it isn't the working directory,
+	// whose tasks run like the ones of a subproject.
+	subproject string
+}
+
+func parseConfig(path string, opts LoadOptions) (parsedConfig, error) {
+	cfg, err := os.ReadFile(path)
+	if err != nil {
+		return parsedConfig{}, err
+	}
+
+	cwd, err := os.Getwd()
+	if err != nil {
+		return parsedConfig{}, err
+	}
+
+	dir, err := filepath.Abs(filepath.Dir(path))
+	if err != nil {
+		return parsedConfig{}, err
+	}
+
+	c := parsedConfig{
+		config:  string(cfg),
+		options: internal.Options{ClearCache: opts.NoCache, Quiet: opts.Quiet, Strict: opts.Strict, StateDir: opts.StateDir, NoGenerate: opts.NoGenerate, CollectErrors: opts.collectErrors},
+		fs:      &internal.LocalFileSystem{},
+	}
+
+	if dir != cwd {
+		p, err := internal.ParseProjectConfig(path, c.config, &c.options, c.fs)
+		if err != nil {
+			return parsedConfig{}, err
+		}
+
+		for _, warning := range p.Warnings {
+			if !opts.Quiet {
+				fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", path, warning)
+			}
+		}
+
+		c.parser = p
+		c.subproject = dir
+		return c, nil
+	}
+
+	c.options.ConfigPath = path
+
+	localPath, localCfg, err := internal.ReadLocalYamlConfig()
+	if err != nil {
+		return parsedConfig{}, err
+	}
+
+	p := internal.NewParser(c.config, &c.options, c.fs)
+	p.SetLocalConfig(localPath, localCfg)
+	if err := p.Bootstrap(); err != nil {
+		return parsedConfig{}, err
+	}
+
+	c.parser = &p
+	return c, nil
+}
+
+// Returns the task with the given name, or a TaskNotFoundError.
+func (p *Project) Task(name string) (Task, error) {
+	t, ok := p.parser.Tasks[name]
+	if !ok || name == "global" {
+		return Task{}, &TaskNotFoundError{Task: name}
+	}
+
+	return Task{Name: name, Desc: t.Desc, Deps: t.Deps, Tags: t.Tags, Files: t.Files}, nil
+}
+
+// Lists the variables of the task which are defined by more than one source,
+// ie. both by the OS and by global.environment, by name.
+func (p *Project) EnvConflicts(name string) ([]EnvConflict, error) {
+	if name == "global" {
+		return nil, &TaskNotFoundError{Task: name}
+	}
+
+	return p.parser.EnvConflicts(name)
+}
+
+// Writes the variables of each task defined by more than one source, like
+// "goke config --env-conflicts".
+func (p *Project) WriteEnvConflicts(w io.Writer) {
+	p.parser.WriteEnvConflicts(w)
+}
+
+// Writes where each task is declared, or which command generated it, like
+// "goke config".
+func (p *Project) WriteTaskOrigins(w io.Writer) {
+	p.parser.WriteTaskOrigins(w)
+}
+
+// Writes the files shared between tasks through their outputs, along with
+// the overlapping outputs, like "goke doctor".
+func (p *Project) WriteOutputs(w io.Writer) {
+	p.parser.Outputs.Write(w)
+}
+
+// Checks that the binaries used by all tasks exist, without running
+// anything, like "goke doctor --tools", and fails with all the missing ones.
+// Also returns the commands which can't be checked, since they are only
+// known at runtime, ie. "${TOOL} build".
+func (p *Project) CheckTools() ([]string, error) {
+	return p.parser.CheckTools()
+}
+
+// Returns the tasks nothing runs anymore: the ones which didn't run
+// successfully for unusedFor, and which aren't the default task, nor run by
+// git hooks or referenced by tasks in use, like "goke prune-tasks".
+func (p *Project) PruneCandidates(unusedFor time.Duration) []PruneCandidate {
+	return p.parser.PruneCandidates(&p.history, time.Now(), unusedFor)
+}
+
+// Writes the prune candidates like "goke prune-tasks".
+func (p *Project) WritePruneCandidates(w io.Writer, candidates []PruneCandidate) {
+	internal.WritePruneCandidates(w, candidates, time.Now())
+}
+
+// Removes the tasks from the config, or the included files declaring them,
+// keeping the comments of the rest. Fails without changing anything while
+// other tasks or git hooks still reference them.
+func (p *Project) DeleteTasks(names []string) error {
+	return p.parser.DeleteTasks(names)
+}
+
+// Returns the tasks of the project, sorted by name.
+func (p *Project) Tasks() []Task {
+	names := []string{}
+	for name := range p.parser.Tasks {
+		if name != "global" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	tasks := make([]Task, len(names))
+	for i, name := range names {
+		tasks[i], _ = p.Task(name)
+	}
+
+	return tasks
+}
+
+// Returns the tasks each git hook of the project runs, by hook name, like
+// pre-commit: [fmt, lint] in the hooks section of goke.yml.
+func (p *Project) Hooks() map[string][]string {
+	hooks := make(map[string][]string, len(p.parser.Hooks))
+	for hook, tasks := range p.parser.Hooks {
+		hooks[hook] = append([]string{}, tasks...)
+	}
+
+	return hooks
+}
+
+// Returns the warnings about the config, which Load prints unless it's
+// quiet, ie. files patterns matching no files.
+func (p *Project) Warnings() []string {
+	return append([]string{}, p.parser.Warnings...)
+}
+
+// Runs the task like "goke <name>" does. Cancelling the context kills
+// its running commands.
+func (p *Project) Run(ctx context.Context, name string, opts RunOptions) error {
+	return p.RunTasks(ctx, []string{name}, opts)
+}
+
+// Runs the tasks in order like "goke <names...>" does, or the main task
+// if none are given. Failures of commands are returned as a CommandError,
+// see ExitCode for the exit status goke ends with.
+func (p *Project) RunTasks(ctx context.Context, names []string, opts RunOptions) error {
+	options := opts.options()
+	meta := internal.NewRunMetadata(opts.Args, p.config)
+	internal.SetCrashMetadata(meta)
+
+	e := internal.NewExecutor(p.parser, &p.lockfile, &p.history, &options, meta)
+	return e.StartContext(ctx, names)
+}
+
+// Watches the task like "goke --watch <name>" does, or the main task if none
+// is given, in the background. Like the goke command, a session watches a
+// single task. The changes and the runs of the session are sent on the
+// returned channel, which is closed once the context is cancelled and the
+// run in progress, if any, was stopped. Events which aren't received by
+// then are dropped. Errors setting up the session, ie. a TaskNotFoundError,
+// are returned right away.
+func (p *Project) Watch(ctx context.Context, names []string, opts WatchOptions) (<-chan WatchEvent, error) {
+	if len(names) > 1 {
+		return nil, errors.New("a watch session accepts a single task")
+	}
+
+	name := internal.DefaultTask
+	if len(names) == 1 {
+		name = names[0]
+	}
+
+	options := opts.options()
+	meta := internal.NewRunMetadata(nil, p.config)
+
+	e := internal.NewExecutor(p.parser, &p.lockfile, &p.history, &options, meta)
+	return e.Watch(ctx, name)
+}
+
+// Returns the exit status goke ends with for the error returned by a run:
+// the exit code of the failed command, or 1 for other errors.
+func ExitCode(err error) int {
+	return internal.ExitCode(err)
+}
+
+// Returns the version of goke, the versions of its contracts and the
+// features it supports, like "goke capabilities --json" does, ie. to check
+// for a feature with HasCapability before relying on it.
+func Capabilities() CapabilityReport {
+	return internal.GetCapabilities()
+}
+
+// Whether this build of goke supports the feature, ie. "task.params".
+func HasCapability(id string) bool {
+	return internal.HasCapability(id)
+}
@@ -0,0 +1,164 @@
+package goke
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+const projectConfig = `
+build:
+  desc: "Builds the project"
+  files: [src/*.go]
+  run:
+    - "touch built"
+
+test:
+  deps: [build]
+  tags: [ci]
+  run:
+    - "touch tested"
+`
+
+// Keeps the lockfiles and history which goke writes to the temp dir out of
+// the real one, so that the tests neither leak nor share state.
+func isolateTempDir(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("TMPDIR", dir)
+	t.Setenv("TMP", dir)
+	t.Setenv("TEMP", dir)
+}
+
+func writeProject(t *testing.T) string {
+	isolateTempDir(t)
+	dir := t.TempDir()
+	require.Nil(t, os.MkdirAll(filepath.Join(dir, "src"), 0755))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main"), 0644))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "goke.yml"), []byte(projectConfig), 0644))
+
+	return dir
+}
+
+func TestLoadConfigDescribesTasks(t *testing.T) {
+	dir := writeProject(t)
+	project, err := LoadConfig(filepath.Join(dir, "goke.yml"))
+	require.Nil(t, err)
+
+	task, err := project.Task("build")
+	require.Nil(t, err)
+	require.Equal(t, "build", task.Name)
+	require.Equal(t, "Builds the project", task.Desc)
+	require.Equal(t, []string{filepath.Join(dir, "src", "main.go")}, task.Files)
+
+	task, err = project.Task("test")
+	require.Nil(t, err)
+	require.Equal(t, []string{"build"}, task.Deps)
+	require.Equal(t, []string{"ci"}, task.Tags)
+
+	names := []string{}
+	for _, task := range project.Tasks() {
+		names = append(names, task.Name)
+	}
+	require.Equal(t, []string{"build", "test"}, names)
+
+	_, err = project.Task("deploy")
+	var notFound *TaskNotFoundError
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, "deploy", notFound.Task)
+}
+
+func TestListTasks(t *testing.T) {
+	dir := writeProject(t)
+	tasks, err := ListTasks(filepath.Join(dir, "goke.yml"), LoadOptions{Quiet: true})
+	require.Nil(t, err)
+
+	require.Len(t, tasks, 2)
+	require.Equal(t, "build", tasks[0].Name)
+	require.Equal(t, "Builds the project", tasks[0].Desc)
+	require.Equal(t, "test", tasks[1].Name)
+}
+
+func TestValidateReportsConfigsWhichDontParse(t *testing.T) {
+	isolateTempDir(t)
+	path := filepath.Join(t.TempDir(), "goke.yml")
+	require.Nil(t, os.WriteFile(path, []byte("build:\n  deps: [tset]\n  fiels: [\"*.go\"]\n  run: [\"go build\"]\n"), 0644))
+
+	problems, err := Validate(path, LoadOptions{})
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		path + ":3: task 'build': unknown key 'fiels', did you mean 'files'?",
+		"task 'build' depends on unknown task 'tset'",
+	}, problems)
+}
+
+func TestValidateReportsEveryTaskWhichDoesntParse(t *testing.T) {
+	isolateTempDir(t)
+	path := filepath.Join(t.TempDir(), "goke.yml")
+	require.Nil(t, os.WriteFile(path, []byte("build:\n  timeout: -1s\n  run: [\"go build\"]\ntest:\n  retries: -1\n  run: [\"go test\"]\nlint:\n  run: [\"go vet\"]\n"), 0644))
+
+	problems, err := Validate(path, LoadOptions{})
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"task 'build': timeout must be a positive duration",
+		"task 'test': retries and retry_delay can't be negative",
+	}, problems)
+}
+
+func TestValidateReportsDependencyCyclesAlongOtherProblems(t *testing.T) {
+	isolateTempDir(t)
+	path := filepath.Join(t.TempDir(), "goke.yml")
+	require.Nil(t, os.WriteFile(path, []byte("build:\n  deps: [test]\n  files: [\"src/*.go\"]\n  run: [tets]\ntest:\n  deps: [build]\n  run: [\"go test\"]\n"), 0644))
+
+	problems, err := Validate(path, LoadOptions{})
+	require.Nil(t, err)
+	require.Equal(t, []string{
+		"task 'build': files pattern \"" + filepath.Join(filepath.Dir(path), "src/*.go") + "\" matches no files",
+		"task 'build': run: 'tets' is neither a task nor a binary in PATH, did you mean 'test'?",
+		"task 'build': reference cycle detected: build -> test -> build",
+	}, problems)
+}
+
+func TestLoadConfigReturnsErrors(t *testing.T) {
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "goke.yml"))
+	require.True(t, errors.Is(err, os.ErrNotExist))
+}
+
+func TestRunRunsTasksInTheirDirectory(t *testing.T) {
+	dir := writeProject(t)
+	project, err := LoadConfig(filepath.Join(dir, "goke.yml"))
+	require.Nil(t, err)
+
+	require.Nil(t, project.Run(context.Background(), "test", RunOptions{Quiet: true, Force: true}))
+	require.FileExists(t, filepath.Join(dir, "built"))
+	require.FileExists(t, filepath.Join(dir, "tested"))
+
+	err = project.Run(context.Background(), "deploy", RunOptions{Quiet: true})
+	var notFound *TaskNotFoundError
+	require.True(t, errors.As(err, &notFound))
+	require.Equal(t, 1, ExitCode(err))
+}
+
+func TestRunStopsWhenTheContextIsCancelled(t *testing.T) {
+	dir := writeProject(t)
+	project, err := LoadConfig(filepath.Join(dir, "goke.yml"))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.NotNil(t, project.Run(ctx, "test", RunOptions{Quiet: true, Force: true}))
+	require.NoFileExists(t, filepath.Join(dir, "tested"))
+}
+
+func TestCapabilities(t *testing.T) {
+	c := Capabilities()
+
+	require.NotEmpty(t, c.Version)
+	require.Contains(t, c.Features, "command.capabilities")
+	require.True(t, HasCapability("task.params"))
+	require.False(t, HasCapability("run.matrix"))
+}
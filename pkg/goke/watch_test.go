@@ -0,0 +1,116 @@
+package goke
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.uber.org/goleak"
+)
+
+// Receives the next event of the session, failing after a while.
+func nextEvent(t *testing.T, events <-chan WatchEvent) WatchEvent {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "the session stopped")
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("no event received")
+		return WatchEvent{}
+	}
+}
+
+// Waits for the channel of the session to be closed, dropping the events.
+func drain(t *testing.T, events <-chan WatchEvent) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			t.Fatal("the session didn't stop")
+		}
+	}
+}
+
+func TestWatchReportsChangesAndRuns(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	dir := writeProject(t)
+	project, err := LoadConfig(filepath.Join(dir, "goke.yml"))
+	require.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := WatchOptions{Quiet: true, Force: true, AllowMultipleWatch: true, Debounce: 10 * time.Millisecond}
+	events, err := project.Watch(ctx, []string{"build"}, opts)
+	require.Nil(t, err)
+
+	event := nextEvent(t, events)
+	require.Equal(t, WatchEvent{Kind: WatchRunStarted, Task: "build", Trigger: "initial"}, event)
+
+	event = nextEvent(t, events)
+	require.Equal(t, WatchRunFinished, event.Kind)
+	require.Equal(t, "initial", event.Trigger)
+	require.True(t, event.Dispatched)
+	require.Nil(t, event.Err)
+	require.FileExists(t, filepath.Join(dir, "built"))
+
+	require.Nil(t, os.Remove(filepath.Join(dir, "built")))
+	require.Nil(t, os.WriteFile(filepath.Join(dir, "src", "main.go"), []byte("package main // changed"), 0644))
+
+	require.Equal(t, WatchEvent{Kind: WatchChangeDetected, Task: "build"}, nextEvent(t, events))
+	require.Equal(t, WatchEvent{Kind: WatchRunStarted, Task: "build", Trigger: "change"}, nextEvent(t, events))
+
+	event = nextEvent(t, events)
+	require.Equal(t, WatchRunFinished, event.Kind)
+	require.Equal(t, "change", event.Trigger)
+	require.FileExists(t, filepath.Join(dir, "built"))
+
+	cancel()
+	drain(t, events)
+}
+
+func TestWatchStartStopCycles(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	dir := writeProject(t)
+	project, err := LoadConfig(filepath.Join(dir, "goke.yml"))
+	require.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		events, err := project.Watch(ctx, []string{"build"}, WatchOptions{Quiet: true, AllowMultipleWatch: true})
+		require.Nil(t, err)
+
+		// Stopping doesn't wait for anyone to receive the events.
+		cancel()
+		drain(t, events)
+	}
+}
+
+func TestWatchReturnsSetupErrors(t *testing.T) {
+	defer goleak.VerifyNone(t)
+
+	dir := writeProject(t)
+	project, err := LoadConfig(filepath.Join(dir, "goke.yml"))
+	require.Nil(t, err)
+
+	_, err = project.Watch(context.Background(), []string{"deploy"}, WatchOptions{Quiet: true})
+	var notFound *TaskNotFoundError
+	require.True(t, errors.As(err, &notFound))
+
+	_, err = project.Watch(context.Background(), []string{"build", "test"}, WatchOptions{Quiet: true})
+	require.EqualError(t, err, "a watch session accepts a single task")
+
+	_, err = project.Watch(context.Background(), []string{"test"}, WatchOptions{Quiet: true})
+	require.EqualError(t, err, "task 'test' has no files to watch")
+}
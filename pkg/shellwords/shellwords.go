@@ -0,0 +1,252 @@
+// Package shellwords splits command lines into words the way goke does
+// for the "run" entries of goke.yml, following POSIX shell quoting rules:
+//
+//   - Unquoted spaces, tabs and newlines separate words.
+//   - Single quotes preserve everything up to the next single quote.
+//   - Double quotes preserve everything up to the next unescaped double quote.
+//     Inside them, a backslash only escapes $, `, ", \ and newline, and is
+//     kept as is before any other character.
+//   - Outside of quotes, a backslash preserves the next character.
+//     A backslash followed by a newline continues the line.
+//   - Quoted and unquoted parts next to each other form a single word,
+//     ie. a"b c"d is the word "ab cd". Empty quotes form an empty word.
+//
+// Variables such as $VAR are left untouched, since goke expands them later.
+// Unlike a shell, there are no operators: pipes, redirections and globs
+// are ordinary characters.
+//
+// SplitWindows follows the rules of Windows programs instead, where
+// backslashes separate paths, see its documentation.
+package shellwords
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ParseError describes a command line which could not be split.
+type ParseError struct {
+	// Byte offset in the command line where the problem starts,
+	// ie. the position of the quote which is never closed.
+	Pos int
+	Msg string
+}
+
+func (e *ParseError) Error() string {
+	return fmt.Sprintf("%s at position %d", e.Msg, e.Pos)
+}
+
+// Split splits the command line into words. It returns a *ParseError when
+// a quote is not terminated or the command line ends with a backslash.
+func Split(line string) ([]string, error) {
+	words := []string{}
+	word := strings.Builder{}
+	inWord := false
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch c {
+		case ' ', '\t', '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case '\\':
+			if i+1 == len(line) {
+				return nil, &ParseError{Pos: i, Msg: "unterminated escape"}
+			}
+
+			i++
+			if line[i] != '\n' {
+				word.WriteByte(line[i])
+				inWord = true
+			}
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end == -1 {
+				return nil, &ParseError{Pos: i, Msg: "unterminated single quote"}
+			}
+
+			word.WriteString(line[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		case '"':
+			end, err := readDoubleQuoted(line, i, &word)
+			if err != nil {
+				return nil, err
+			}
+
+			inWord = true
+			i = end
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// SplitWindows splits the command line into words like Split, but with
+// the backslash rules of Windows programs, so that paths like C:\tools\go.exe
+// stay intact:
+//
+//   - A backslash is an ordinary character, unless backslashes are followed
+//     by a double quote. Then each pair of them is a single backslash, and
+//     an odd one out makes the double quote an ordinary character.
+//   - Double quotes group words, following the same backslash rules.
+//   - Single quotes preserve everything up to the next single quote, like
+//     with Split, so that configs shared with other systems keep working.
+//
+// It returns a *ParseError when a quote is not terminated.
+func SplitWindows(line string) ([]string, error) {
+	words := []string{}
+	word := strings.Builder{}
+	inWord := false
+	quoted := -1
+
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case c == '\\':
+			n := 1
+			for i+n < len(line) && line[i+n] == '\\' {
+				n++
+			}
+
+			switch {
+			case i+n == len(line) || line[i+n] != '"':
+				word.WriteString(strings.Repeat("\\", n))
+				i += n - 1
+			case n%2 == 1:
+				word.WriteString(strings.Repeat("\\", n/2) + `"`)
+				i += n
+			default:
+				// The double quote is read next, as a quote.
+				word.WriteString(strings.Repeat("\\", n/2))
+				i += n - 1
+			}
+			inWord = true
+		case c == '"':
+			if quoted == -1 {
+				quoted = i
+			} else {
+				quoted = -1
+			}
+			inWord = true
+		case quoted != -1:
+			word.WriteByte(c)
+		case c == ' ' || c == '\t' || c == '\n':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		case c == '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end == -1 {
+				return nil, &ParseError{Pos: i, Msg: "unterminated single quote"}
+			}
+
+			word.WriteString(line[i+1 : i+1+end])
+			inWord = true
+			i += end + 1
+		default:
+			word.WriteByte(c)
+			inWord = true
+		}
+	}
+
+	if quoted != -1 {
+		return nil, &ParseError{Pos: quoted, Msg: "unterminated double quote"}
+	}
+
+	if inWord {
+		words = append(words, word.String())
+	}
+
+	return words, nil
+}
+
+// Reads the double quoted part starting at the given position into the word,
+// and returns the position of the closing quote.
+func readDoubleQuoted(line string, start int, word *strings.Builder) (int, error) {
+	for i := start + 1; i < len(line); i++ {
+		c := line[i]
+
+		switch {
+		case c == '"':
+			return i, nil
+		case c == '\\' && i+1 < len(line) && strings.IndexByte("$`\"\\\n", line[i+1]) != -1:
+			i++
+			if line[i] != '\n' {
+				word.WriteByte(line[i])
+			}
+		default:
+			word.WriteByte(c)
+		}
+	}
+
+	return 0, &ParseError{Pos: start, Msg: "unterminated double quote"}
+}
+
+// ContainsOperators reports whether the line contains unquoted and unescaped
+// shell operators (| & ; < >) or globs (*), which only have a meaning when
+// the line runs through a shell. Split treats them as ordinary characters.
+func ContainsOperators(line string) bool {
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '\'':
+			end := strings.IndexByte(line[i+1:], '\'')
+			if end == -1 {
+				return false
+			}
+			i += end + 1
+		case '"':
+			var discard strings.Builder
+			end, err := readDoubleQuoted(line, i, &discard)
+			if err != nil {
+				return false
+			}
+			i = end
+		case '|', '&', ';', '<', '>', '*':
+			return true
+		}
+	}
+
+	return false
+}
+
+// Join quotes the words where needed and joins them with spaces,
+// so that Split returns the same words again.
+func Join(words []string) string {
+	quoted := make([]string, len(words))
+	for i, w := range words {
+		quoted[i] = Quote(w)
+	}
+
+	return strings.Join(quoted, " ")
+}
+
+// Quote returns the word as is if it needs no quoting,
+// otherwise it wraps it in single quotes.
+func Quote(word string) string {
+	if word == "" {
+		return "''"
+	}
+
+	if !strings.ContainsAny(word, " \t\n\\'\"") {
+		return word
+	}
+
+	return "'" + strings.ReplaceAll(word, "'", `'\''`) + "'"
+}
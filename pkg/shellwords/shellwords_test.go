@@ -0,0 +1,158 @@
+package shellwords
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestSplit(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []string
+	}{
+		{``, []string{}},
+		{`   `, []string{}},
+		{`go test ./...`, []string{"go", "test", "./..."}},
+		{"  go\ttest \n ./...  ", []string{"go", "test", "./..."}},
+		{`echo 'Hello Pepper'`, []string{"echo", "Hello Pepper"}},
+		{`echo "Hello Pepper"`, []string{"echo", "Hello Pepper"}},
+		{`echo 'a "b" c'`, []string{"echo", `a "b" c`}},
+		{`echo "a 'b' c"`, []string{"echo", `a 'b' c`}},
+		{`echo 'a\b'`, []string{"echo", `a\b`}},
+		{`echo "a\b"`, []string{"echo", `a\b`}},
+		{`echo "a\"b\\c\$d"`, []string{"echo", `a"b\c$d`}},
+		{`echo a\ b`, []string{"echo", "a b"}},
+		{`echo \'a\'`, []string{"echo", "'a'"}},
+		{`echo a"b c"d`, []string{"echo", "ab cd"}},
+		{`echo 'a'"b"c`, []string{"echo", "abc"}},
+		{`echo '' ""`, []string{"echo", "", ""}},
+		{`echo $HOME ${USER}`, []string{"echo", "$HOME", "${USER}"}},
+		{`echo "$HOME"`, []string{"echo", "$HOME"}},
+		{"echo a\\\nb", []string{"echo", "ab"}},
+		{"echo \"a\\\nb\"", []string{"echo", "ab"}},
+		{`sh -c 'echo $1 | wc -l > out'`, []string{"sh", "-c", "echo $1 | wc -l > out"}},
+		{`echo ü "ö ä"`, []string{"echo", "ü", "ö ä"}},
+	}
+
+	for _, c := range cases {
+		words, err := Split(c.line)
+
+		require.Nil(t, err, c.line)
+		require.Equal(t, c.expected, words, c.line)
+	}
+}
+
+func TestSplitErrors(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected string
+		pos      int
+	}{
+		{`echo 'foo`, "unterminated single quote at position 5", 5},
+		{`echo "foo`, "unterminated double quote at position 5", 5},
+		{`echo "foo\"`, "unterminated double quote at position 5", 5},
+		{`echo 'a' "b`, "unterminated double quote at position 9", 9},
+		{`echo foo\`, "unterminated escape at position 8", 8},
+	}
+
+	for _, c := range cases {
+		words, err := Split(c.line)
+		require.Nil(t, words)
+		require.EqualError(t, err, c.expected, c.line)
+
+		var parseErr *ParseError
+		require.True(t, errors.As(err, &parseErr))
+		require.Equal(t, c.pos, parseErr.Pos)
+	}
+}
+
+func TestSplitWindows(t *testing.T) {
+	cases := []struct {
+		line     string
+		expected []string
+	}{
+		{`go test ./...`, []string{"go", "test", "./..."}},
+		{`C:\tools\go.exe build .\cmd\cli`, []string{`C:\tools\go.exe`, "build", `.\cmd\cli`}},
+		{`copy "C:\Program Files\app\app.ini" dist\`, []string{"copy", `C:\Program Files\app\app.ini`, `dist\`}},
+		{`echo "a\"b"`, []string{"echo", `a"b`}},
+		{`echo "a\\" b`, []string{"echo", `a\`, "b"}},
+		{`echo a\\\"b`, []string{"echo", `a\"b`}},
+		{`echo 'a\b c'`, []string{"echo", `a\b c`}},
+		{`echo a"b c"d`, []string{"echo", "ab cd"}},
+		{`echo "" %PATH%`, []string{"echo", "", "%PATH%"}},
+	}
+
+	for _, c := range cases {
+		words, err := SplitWindows(c.line)
+
+		require.Nil(t, err, c.line)
+		require.Equal(t, c.expected, words, c.line)
+	}
+
+	_, err := SplitWindows(`echo "C:\temp\"`)
+	require.EqualError(t, err, "unterminated double quote at position 5")
+
+	_, err = SplitWindows(`echo 'foo`)
+	require.EqualError(t, err, "unterminated single quote at position 5")
+}
+
+func TestContainsOperators(t *testing.T) {
+	cases := map[string]bool{
+		`go vet ./... && go test ./...`: true,
+		`go list ./... | wc -l`:         true,
+		`echo hi > out.txt`:             true,
+		`rm *.o`:                        true,
+		`make; make install`:            true,
+		`go test ./...`:                 false,
+		`echo 'a | b' "c && d"`:         false,
+		`echo a\|b`:                     false,
+		`echo $HOME {FILES}`:            false,
+		`echo 'unterminated |`:          false,
+	}
+
+	for line, expected := range cases {
+		require.Equal(t, expected, ContainsOperators(line), line)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	require.Equal(t, "''", Quote(""))
+	require.Equal(t, "./...", Quote("./..."))
+	require.Equal(t, "'a b'", Quote("a b"))
+	require.Equal(t, `'it'\''s'`, Quote("it's"))
+	require.Equal(t, `'a\b'`, Quote(`a\b`))
+}
+
+func TestJoin(t *testing.T) {
+	words := []string{"sh", "-c", "echo 'hi' \"there\"", "", `C:\dir`}
+	line := Join(words)
+
+	require.Equal(t, `sh -c 'echo '\''hi'\'' "there"' '' 'C:\dir'`, line)
+
+	split, err := Split(line)
+	require.Nil(t, err)
+	require.Equal(t, words, split)
+}
+
+func FuzzSplitJoin(f *testing.F) {
+	f.Add(`go test ./...`)
+	f.Add(`echo 'a "b" c' "d \"e\"" f\ g`)
+	f.Add(`echo a"b c"d '' ""`)
+	f.Add("echo a\\\nb\t$HOME")
+
+	f.Fuzz(func(t *testing.T, line string) {
+		words, err := Split(line)
+		if err != nil {
+			return
+		}
+
+		again, err := Split(Join(words))
+		if err != nil {
+			t.Fatalf("split of joined %q: %v", words, err)
+		}
+
+		require.Equal(t, words, again)
+	})
+}